@@ -0,0 +1,167 @@
+package ackcheck_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/ackcheck"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+// run starts consuming from the source, returning the channels and a function stopping consumption.
+func run(source substrate.AsyncMessageSource) (<-chan substrate.Message, chan<- substrate.Message, func() error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+	return messages, acks, func() error {
+		cancel()
+		return <-errs
+	}
+}
+
+func receive(t *testing.T, ch <-chan ackcheck.Violation) ackcheck.Violation {
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(time.Second * 5):
+		require.FailNow(t, "no violation reported")
+		return ackcheck.Violation{}
+	}
+}
+
+func TestSource_PassesValidAcks(t *testing.T) {
+	one, two := message.FromString("one"), message.FromString("two")
+	violations := make(chan ackcheck.Violation, 10)
+	source := ackcheck.NewAsyncMessageSource(
+		&mock.AsyncMessageSource{Messages: []substrate.Message{one, two}},
+		ackcheck.WithViolationFunc(func(v ackcheck.Violation) { violations <- v }),
+	)
+
+	messages, acks, stop := run(source)
+	require.Equal(t, one, <-messages)
+	require.Equal(t, two, <-messages)
+	acks <- one
+	acks <- two
+	// The mock source fails on acknowledgements it doesn't expect.
+	time.Sleep(time.Millisecond * 50)
+	require.NoError(t, stop())
+	require.Empty(t, violations)
+}
+
+func TestSource_DetectsViolations(t *testing.T) {
+	one, two := message.FromString("one"), message.FromString("two")
+	violations := make(chan ackcheck.Violation, 10)
+	source := ackcheck.NewAsyncMessageSource(
+		&mock.AsyncMessageSource{Messages: []substrate.Message{one, two}},
+		ackcheck.WithViolationFunc(func(v ackcheck.Violation) { violations <- v }),
+	)
+
+	messages, acks, stop := run(source)
+	require.Equal(t, one, <-messages)
+
+	unknown := message.FromString("unknown")
+	acks <- unknown
+	require.Equal(t, ackcheck.Violation{Kind: ackcheck.Unknown, Message: unknown}, receive(t, violations))
+
+	acks <- one
+	acks <- one
+	require.Equal(t, ackcheck.Violation{Kind: ackcheck.Duplicate, Message: one}, receive(t, violations))
+
+	// Violations aren't passed on, so the mock source doesn't fail.
+	require.Equal(t, two, <-messages)
+	require.NoError(t, stop())
+
+	acks <- two
+	v := receive(t, violations)
+	require.Equal(t, ackcheck.Violation{Kind: ackcheck.AfterShutdown, Message: two}, v)
+	require.EqualError(t, v, "acknowledged a message after consumption stopped")
+}
+
+// runsSource delivers the messages of the next run every time it is consumed, ignoring acknowledgements.
+type runsSource struct {
+	runs [][]substrate.Message
+}
+
+func (s *runsSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	run := s.runs[0]
+	s.runs = s.runs[1:]
+	for _, msg := range run {
+		select {
+		case <-ctx.Done():
+			return nil
+		case messages <- msg:
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-acks:
+		}
+	}
+}
+
+func (s *runsSource) Close() error {
+	return nil
+}
+
+func (s *runsSource) Status() (*substrate.Status, error) {
+	return &substrate.Status{Working: true}, nil
+}
+
+func TestSource_ForgetsPreviousConsumption(t *testing.T) {
+	one, two := message.FromString("one"), message.FromString("two")
+	violations := make(chan ackcheck.Violation, 10)
+	source := ackcheck.NewAsyncMessageSource(
+		&runsSource{runs: [][]substrate.Message{{one}, {two}}},
+		ackcheck.WithViolationFunc(func(v ackcheck.Violation) { violations <- v }),
+	)
+
+	messages, _, stop := run(source)
+	require.Equal(t, one, <-messages)
+	require.NoError(t, stop())
+
+	messages, acks, stop := run(source)
+	require.Equal(t, two, <-messages)
+	acks <- one
+	require.Equal(t, ackcheck.Violation{Kind: ackcheck.Unknown, Message: one}, receive(t, violations))
+	acks <- two
+	require.NoError(t, stop())
+	require.Empty(t, violations)
+}
+
+func TestSource_LogsAndCounts(t *testing.T) {
+	one := message.FromString("one")
+	var logs bytes.Buffer
+	counterOpts := prometheus.CounterOpts{Name: "ackcheck_test_violations_total", Help: "Acknowledgement violations."}
+	violations := make(chan ackcheck.Violation, 10)
+	source := ackcheck.NewAsyncMessageSource(
+		&mock.AsyncMessageSource{Messages: []substrate.Message{one}},
+		ackcheck.WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+		ackcheck.WithViolationCounter(counterOpts, "topic"),
+		ackcheck.WithViolationFunc(func(v ackcheck.Violation) { violations <- v }),
+	)
+	registered := prometheus.Register(prometheus.NewCounterVec(counterOpts, []string{"kind", "topic"}))
+	counter := registered.(prometheus.AlreadyRegisteredError).ExistingCollector.(*prometheus.CounterVec)
+	before := testutil.ToFloat64(counter.WithLabelValues("unknown", "topic"))
+
+	_, acks, stop := run(source)
+	acks <- message.FromString("unknown")
+	receive(t, violations)
+	require.NoError(t, stop())
+
+	require.Equal(t, float64(1), testutil.ToFloat64(counter.WithLabelValues("unknown", "topic"))-before)
+	require.Contains(t, logs.String(), `level=ERROR msg="acknowledged a message that wasn't delivered" kind=unknown type=*message.Message`)
+}
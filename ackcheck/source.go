@@ -0,0 +1,312 @@
+// Package ackcheck provides a message source wrapper verifying the acknowledgement discipline of the application,
+// turning acknowledgements of unknown messages, duplicate acknowledgements and acknowledgements sent after consumption
+// stopped into loud failures instead of subtle production bugs.
+package ackcheck
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+const defaultHistory = 10000
+
+// Kind is the kind of a violation of the acknowledgement discipline.
+type Kind int
+
+const (
+	// Unknown is the acknowledgement of a message that wasn't delivered by the current consumption.
+	Unknown Kind = iota
+	// Duplicate is the acknowledgement of a message that was already acknowledged.
+	Duplicate
+	// AfterShutdown is an acknowledgement sent after consumption stopped.
+	AfterShutdown
+)
+
+// String returns the name of the kind, used as the value of the kind label of the violation counter.
+func (k Kind) String() string {
+	switch k {
+	case Unknown:
+		return "unknown"
+	case Duplicate:
+		return "duplicate"
+	case AfterShutdown:
+		return "after_shutdown"
+	default:
+		return fmt.Sprintf("kind(%d)", int(k))
+	}
+}
+
+// Violation is a violation of the acknowledgement discipline.
+type Violation struct {
+	// Kind is the kind of the violation.
+	Kind Kind
+	// Message is the acknowledged message.
+	Message substrate.Message
+}
+
+func (v Violation) Error() string {
+	switch v.Kind {
+	case Unknown:
+		return "acknowledged a message that wasn't delivered"
+	case Duplicate:
+		return "acknowledged a message twice"
+	case AfterShutdown:
+		return "acknowledged a message after consumption stopped"
+	default:
+		return fmt.Sprintf("acknowledgement violation of %s", v.Kind)
+	}
+}
+
+// AsyncMessageSourceOption is a function which sets an AsyncMessageSource configuration option.
+type AsyncMessageSourceOption func(s *checkSource)
+
+// WithPanic makes the source panic on violations, crashing the process, which is the response if no other one is
+// configured.
+func WithPanic() AsyncMessageSourceOption {
+	return func(s *checkSource) {
+		s.responses = append(s.responses, func(v Violation) {
+			panic(v)
+		})
+	}
+}
+
+// WithLogger makes the source log violations at error level with the logger.
+func WithLogger(logger *slog.Logger) AsyncMessageSourceOption {
+	return func(s *checkSource) {
+		s.responses = append(s.responses, func(v Violation) {
+			logger.Error(v.Error(), slog.String("kind", v.Kind.String()), slog.String("type", fmt.Sprintf("%T", v.Message)))
+		})
+	}
+}
+
+// WithViolationCounter makes the source count violations, labelled with their kind and the topic.
+// It panics in case it can't register the metric.
+func WithViolationCounter(counterOpts prometheus.CounterOpts, topic string) AsyncMessageSourceOption {
+	return func(s *checkSource) {
+		counter := metrics.Register(prometheus.NewCounterVec(counterOpts, []string{"kind", "topic"})).(*prometheus.CounterVec)
+		s.responses = append(s.responses, func(v Violation) {
+			counter.WithLabelValues(v.Kind.String(), topic).Inc()
+		})
+	}
+}
+
+// WithViolationFunc makes the source call the function on violations, e.g. to fail a test. It is called
+// synchronously, so it must not block.
+func WithViolationFunc(f func(v Violation)) AsyncMessageSourceOption {
+	return func(s *checkSource) {
+		s.responses = append(s.responses, f)
+	}
+}
+
+// WithHistory sets the number of acknowledged messages remembered to detect duplicate acknowledgements, older
+// messages acknowledged again are reported as unknown. The default value is 10000.
+func WithHistory(size int) AsyncMessageSourceOption {
+	return func(s *checkSource) {
+		s.history = size
+	}
+}
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that verifies that every acknowledgement
+// is for a message delivered by the current consumption, that wasn't acknowledged yet. Valid acknowledgements are
+// passed on to the underlying source, while violations are responded to as configured, panicking by default, and
+// aren't passed on. Once consumption stopped, acknowledgements sent on the channel are reported as violations until
+// consumption starts again. Messages are identified by their identity, so acknowledgements of messages of types that
+// aren't comparable aren't verified. It is meant for tests and debugging.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, opts ...AsyncMessageSourceOption) substrate.AsyncMessageSource {
+	s := &checkSource{
+		source:  source,
+		history: defaultHistory,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if len(s.responses) == 0 {
+		WithPanic()(s)
+	}
+	return s
+}
+
+type checkSource struct {
+	source    substrate.AsyncMessageSource
+	history   int
+	responses []func(v Violation)
+
+	mutex     sync.Mutex
+	delivered map[substrate.Message]int
+	acked     map[substrate.Message]struct{}
+	ackOrder  []substrate.Message
+	// stopDrain stops reporting the acknowledgements sent after the last consumption stopped.
+	stopDrain func()
+}
+
+func (s *checkSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	s.reset()
+
+	emit := events.NewEmitter(ctx, events.Info{Component: "ackcheck"})
+	rg, groupCtx := rungroup.New(ctx)
+
+	sourceMsgs := make(chan substrate.Message, cap(messages))
+	sourceAcks := make(chan substrate.Message, cap(acks))
+
+	rg.Go(func() error {
+		return s.source.ConsumeMessages(groupCtx, sourceMsgs, sourceAcks)
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-groupCtx.Done():
+				return nil
+			case msg := <-sourceMsgs:
+				s.deliver(msg)
+				emit.Publish(msg)
+				select {
+				case <-groupCtx.Done():
+					return nil
+				case messages <- msg:
+				}
+			}
+		}
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-groupCtx.Done():
+				return nil
+			case ack := <-acks:
+				if !s.check(ack) {
+					continue
+				}
+				emit.Ack(ack)
+				select {
+				case <-groupCtx.Done():
+					return nil
+				case sourceAcks <- ack:
+				}
+			}
+		}
+	})
+
+	err := rg.Wait()
+	s.drain(acks)
+	return err
+}
+
+// reset stops reporting the acknowledgements of the previous consumption and forgets its messages.
+func (s *checkSource) reset() {
+	s.mutex.Lock()
+	stop := s.stopDrain
+	s.stopDrain = nil
+	s.delivered = make(map[substrate.Message]int)
+	s.acked = make(map[substrate.Message]struct{})
+	s.ackOrder = nil
+	s.mutex.Unlock()
+
+	if stop != nil {
+		stop()
+	}
+}
+
+// drain reports the acknowledgements sent after consumption stopped, until consumption starts again.
+func (s *checkSource) drain(acks <-chan substrate.Message) {
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			case ack := <-acks:
+				s.report(Violation{Kind: AfterShutdown, Message: ack})
+			}
+		}
+	}()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.stopDrain = func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+func (s *checkSource) deliver(msg substrate.Message) {
+	if !trackable(msg) {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.delivered[msg]++
+}
+
+// check reports whether the acknowledgement is valid, reporting the violation otherwise.
+func (s *checkSource) check(ack substrate.Message) bool {
+	if ack == nil {
+		return s.report(Violation{Kind: Unknown})
+	}
+	if !trackable(ack) {
+		return true
+	}
+
+	s.mutex.Lock()
+	if s.delivered[ack] > 0 {
+		if s.delivered[ack]--; s.delivered[ack] == 0 {
+			delete(s.delivered, ack)
+		}
+		s.remember(ack)
+		s.mutex.Unlock()
+		return true
+	}
+	_, duplicate := s.acked[ack]
+	s.mutex.Unlock()
+
+	if duplicate {
+		return s.report(Violation{Kind: Duplicate, Message: ack})
+	}
+	return s.report(Violation{Kind: Unknown, Message: ack})
+}
+
+// remember records the acknowledged message, forgetting the oldest one if the history is full.
+func (s *checkSource) remember(ack substrate.Message) {
+	if s.history <= 0 {
+		return
+	}
+	if len(s.ackOrder) >= s.history {
+		delete(s.acked, s.ackOrder[0])
+		s.ackOrder = s.ackOrder[1:]
+	}
+	s.acked[ack] = struct{}{}
+	s.ackOrder = append(s.ackOrder, ack)
+}
+
+// report responds to the violation. It always returns false, for the acknowledgement not to be passed on.
+func (s *checkSource) report(v Violation) bool {
+	for _, respond := range s.responses {
+		respond(v)
+	}
+	return false
+}
+
+// trackable reports whether the message can be identified, i.e. whether its type is comparable.
+func trackable(msg substrate.Message) bool {
+	return reflect.TypeOf(msg).Comparable()
+}
+
+func (s *checkSource) Close() error {
+	return s.source.Close()
+}
+
+func (s *checkSource) Status() (*substrate.Status, error) {
+	return s.source.Status()
+}
@@ -4,58 +4,119 @@ import (
 	"context"
 
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+	"github.com/uw-labs/substrate-tools/message"
 	"github.com/uw-labs/sync/rungroup"
 )
 
+// Option is a function which sets an ack ordering source configuration option.
+type Option func(m *ackOrderingMiddleware)
+
+// WithWindowSize sets the maximum number of messages that can be consumed without their acknowledgement
+// having been forwarded to the underlying source. Once the window is full, no more messages are consumed
+// until the oldest message is acknowledged. The default value is 0 (unlimited).
+func WithWindowSize(size uint) Option {
+	return func(m *ackOrderingMiddleware) {
+		m.windowSize = size
+	}
+}
+
+// WithBufferedAcksGauge sets a gauge that is updated with the number of acknowledgements buffered
+// because they were received out of order. It panics in case it can't register the metric.
+func WithBufferedAcksGauge(gaugeOpts prometheus.GaugeOpts) Option {
+	return func(m *ackOrderingMiddleware) {
+		m.bufferedAcks = metrics.Register(prometheus.NewGauge(gaugeOpts)).(prometheus.Gauge)
+	}
+}
+
+// WithBufferGauges reports the number of messages consumed without their acknowledgement having been forwarded to
+// the underlying source, along with the window size as the capacity, with the shared buffer gauges, labelled with
+// the wrapper, which is "ackordering", and the provided name. It panics in case it can't register the metrics.
+func WithBufferGauges(name string) Option {
+	return func(m *ackOrderingMiddleware) {
+		m.bufferName = &name
+	}
+}
+
 // NewAsyncMessageSource is a message source that accepts acknowledgements in any order and
 // forwards them to underlying source in the order in which the messages are read.
-func NewAsyncMessageSource(delegate substrate.AsyncMessageSource) substrate.AsyncMessageSource {
-	return &ackOrderingMiddleware{
+func NewAsyncMessageSource(delegate substrate.AsyncMessageSource, opts ...Option) substrate.AsyncMessageSource {
+	m := &ackOrderingMiddleware{
 		delegate: delegate,
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.bufferName != nil {
+		m.buffer = metrics.NewBuffer("ackordering", *m.bufferName, "", float64(m.windowSize))
+	}
+	return m
 }
 
 type ackOrderingMiddleware struct {
-	delegate substrate.AsyncMessageSource
+	delegate     substrate.AsyncMessageSource
+	windowSize   uint
+	bufferedAcks prometheus.Gauge
+	bufferName   *string
+	buffer       *metrics.Buffer
 }
 
 func (m *ackOrderingMiddleware) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "ackordering"})
 	rg, ctx := rungroup.New(ctx)
 	delegateMsgs := make(chan substrate.Message)
 	delegateAcks := make(chan substrate.Message)
 
+	var window chan struct{}
+	if m.windowSize > 0 {
+		window = make(chan struct{}, m.windowSize)
+	}
+	m.buffer.Set(0)
+
 	rg.Go(func() error {
 		return m.delegate.ConsumeMessages(ctx, delegateMsgs, delegateAcks)
 	})
 	rg.Go(func() error {
-		return m.passMessages(ctx, messages, delegateMsgs)
-	})
-	rg.Go(func() error {
-		return m.passAcks(ctx, acks, delegateAcks)
+		return m.passMessages(ctx, emit, messages, delegateMsgs, window)
 	})
+	rg.Go(emit.Errors(func() error {
+		return m.passAcks(ctx, emit, acks, delegateAcks, window)
+	}))
 
 	return rg.Wait()
 }
 
-func (m *ackOrderingMiddleware) passMessages(ctx context.Context, messages chan<- substrate.Message, delegateMsgs <-chan substrate.Message) error {
+func (m *ackOrderingMiddleware) passMessages(ctx context.Context, emit events.Emitter, messages chan<- substrate.Message, delegateMsgs <-chan substrate.Message, window chan<- struct{}) error {
 	var seq uint64
 	for {
+		if window != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			case window <- struct{}{}:
+			}
+		}
 		select {
 		case <-ctx.Done():
 			return nil
 		case msg := <-delegateMsgs:
+			aMsg := &ackMessage{msg: msg, seq: seq}
+			emit.Publish(aMsg)
 			select {
 			case <-ctx.Done():
-			case messages <- &ackMessage{msg: msg, seq: seq}:
+			case messages <- aMsg:
+				m.buffer.Add(1)
 				seq++
 			}
 		}
 	}
 }
 
-func (m *ackOrderingMiddleware) passAcks(ctx context.Context, acks <-chan substrate.Message, delegateAcks chan<- substrate.Message) error {
+func (m *ackOrderingMiddleware) passAcks(ctx context.Context, emit events.Emitter, acks <-chan substrate.Message, delegateAcks chan<- substrate.Message, window <-chan struct{}) error {
 	var seq uint64
 	toAck := make(map[uint64]substrate.Message)
 
@@ -68,6 +129,7 @@ func (m *ackOrderingMiddleware) passAcks(ctx context.Context, acks <-chan substr
 			if !ok {
 				return errors.Errorf("invalid ack message type: %v", ack)
 			}
+			emit.Ack(ack)
 			toAck[msg.seq] = msg.msg
 			dMsg, ok := toAck[seq]
 
@@ -77,11 +139,18 @@ func (m *ackOrderingMiddleware) passAcks(ctx context.Context, acks <-chan substr
 					return nil
 				case delegateAcks <- dMsg:
 					delete(toAck, seq)
+					m.buffer.Add(-1)
+					if window != nil {
+						<-window
+					}
 
 					seq++
 					dMsg, ok = toAck[seq]
 				}
 			}
+			if m.bufferedAcks != nil {
+				m.bufferedAcks.Set(float64(len(toAck)))
+			}
 		}
 	}
 }
@@ -94,6 +163,16 @@ func (m *ackOrderingMiddleware) Status() (*substrate.Status, error) {
 	return m.delegate.Status()
 }
 
+// Unwrap returns the message consumed from the underlying source that was delivered as the message by an ack
+// ordering source, or the message itself if it wasn't delivered by one, e.g. for functions relying on the concrete
+// type of the messages of the underlying source.
+func Unwrap(msg substrate.Message) substrate.Message {
+	if aMsg, ok := msg.(*ackMessage); ok {
+		return aMsg.msg
+	}
+	return msg
+}
+
 type ackMessage struct {
 	msg substrate.Message
 	seq uint64
@@ -108,3 +187,15 @@ func (msg *ackMessage) DiscardPayload() {
 		dMsg.DiscardPayload()
 	}
 }
+
+func (msg *ackMessage) Headers() map[string]string {
+	return message.Headers(msg.msg)
+}
+
+func (msg *ackMessage) ContentType() string {
+	return message.ContentType(msg.msg)
+}
+
+func (msg *ackMessage) CarriedEnvelope() (message.Envelope, bool) {
+	return message.EnvelopeOf(msg.msg)
+}
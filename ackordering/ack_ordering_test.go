@@ -2,10 +2,14 @@ package ackordering_test
 
 import (
 	"context"
+	"fmt"
 	"math/rand"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 
 	"github.com/uw-labs/substrate"
@@ -60,3 +64,116 @@ func TestAckOrderingMessageSource(t *testing.T) {
 	require.NoError(t, source.Close())
 	require.True(t, mockSource.WasClosed())
 }
+
+func TestAckOrderingMessageSource_Window(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			message.FromString("1"),
+			message.FromString("2"),
+			message.FromString("3"),
+		},
+	}
+	gaugeOpts := prometheus.GaugeOpts{
+		Name: "buffered_acks",
+		Help: "buffered_acks",
+	}
+
+	source := ackordering.NewAsyncMessageSource(mockSource, ackordering.WithWindowSize(2), ackordering.WithBufferedAcksGauge(gaugeOpts))
+	gauge := prometheus.Register(prometheus.NewGauge(gaugeOpts)).(prometheus.AlreadyRegisteredError).ExistingCollector.(prometheus.Gauge)
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	go func() {
+		require.NoError(t, source.ConsumeMessages(context.Background(), messages, acks))
+	}()
+
+	first, second := <-messages, <-messages
+	select {
+	case <-messages:
+		require.FailNow(t, "consumed message outside of the window")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	acks <- second
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(gauge) == 1
+	}, time.Second, time.Millisecond*10)
+
+	acks <- first
+	select {
+	case <-ctx.Done():
+		require.FailNow(t, "failed to consume message after the window moved")
+	case third := <-messages:
+		require.Equal(t, "3", string(third.Data()))
+		acks <- third
+	}
+	require.Equal(t, float64(0), testutil.ToFloat64(gauge))
+
+	require.NoError(t, source.Close())
+}
+
+func TestAckOrderingMessageSource_BufferGauges(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			message.FromString("1"),
+			message.FromString("2"),
+		},
+	}
+	source := ackordering.NewAsyncMessageSource(mockSource, ackordering.WithBufferGauges("orders"), ackordering.WithWindowSize(5))
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	first, second := <-messages, <-messages
+	requireBuffer(t, 2)
+	acks <- second
+	requireBuffer(t, 2)
+	acks <- first
+	requireBuffer(t, 0)
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func requireBuffer(t *testing.T, used int) {
+	expected := fmt.Sprintf(`
+# HELP substrate_tools_buffer_capacity Capacity of the buffer of a wrapper, in the same unit as the used gauge, or 0 if it is unbounded.
+# TYPE substrate_tools_buffer_capacity gauge
+substrate_tools_buffer_capacity{buffer="",name="orders",wrapper="ackordering"} 5
+# HELP substrate_tools_buffer_used Number of messages, or bytes for buffers on disk, held in the buffer of a wrapper.
+# TYPE substrate_tools_buffer_used gauge
+substrate_tools_buffer_used{buffer="",name="orders",wrapper="ackordering"} %d
+`, used)
+	require.Eventually(t, func() bool {
+		return testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected),
+			"substrate_tools_buffer_used", "substrate_tools_buffer_capacity") == nil
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestAckOrderingMessageSource_Unwrap(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	original := message.NewEnvelopedMessage(message.Envelope{Payload: []byte("1"), Headers: map[string]string{"key": "value"}})
+	source := ackordering.NewAsyncMessageSource(&mock.AsyncMessageSource{Messages: []substrate.Message{original}})
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	msg := <-messages
+	require.Equal(t, map[string]string{"key": "value"}, message.Headers(msg))
+	require.Equal(t, substrate.Message(original), ackordering.Unwrap(msg))
+	require.Equal(t, substrate.Message(original), ackordering.Unwrap(original))
+	acks <- msg
+
+	cancel()
+	require.NoError(t, <-errs)
+}
@@ -0,0 +1,232 @@
+// Package acktimeout provides a message sink wrapper detecting messages that wait too long for their acknowledgement.
+package acktimeout
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// ErrAckTimeout is returned when a message wasn't acknowledged before the deadline and the sink is configured
+// to fail.
+var ErrAckTimeout = errors.New("acknowledgement timed out")
+
+// errRestart stops an attempt to publish messages so that the underlying sink is restarted.
+var errRestart = errors.New("restart")
+
+// Action is what the sink does once a message wasn't acknowledged before the deadline.
+type Action int
+
+const (
+	// Fail makes PublishMessages return an error wrapping ErrAckTimeout.
+	Fail Action = iota
+	// Restart cancels the call to PublishMessages of the underlying sink and calls it again, publishing all
+	// messages that haven't been acknowledged yet again. Messages may be published more than once as a result.
+	Restart
+	// Report only reports the timeout, using the callback and the counter, and keeps waiting for the
+	// acknowledgement.
+	Report
+)
+
+// TimeoutFunc is called with the message and how long it has been waiting once a message wasn't acknowledged
+// before the deadline. It is called at most once for every time the message is published.
+type TimeoutFunc func(msg substrate.Message, waited time.Duration)
+
+// SinkOption is a function which sets an acknowledgement timeout sink configuration option.
+type SinkOption func(s *timeoutSink)
+
+// WithAction sets what the sink does when a message wasn't acknowledged before the deadline. The default
+// action is Fail.
+func WithAction(action Action) SinkOption {
+	return func(s *timeoutSink) {
+		s.action = action
+	}
+}
+
+// WithTimeoutFunc sets a function that is called for every message that wasn't acknowledged before the deadline.
+func WithTimeoutFunc(f TimeoutFunc) SinkOption {
+	return func(s *timeoutSink) {
+		s.onTimeout = f
+	}
+}
+
+// WithTimeoutCounter sets a counter that is incremented for every message that wasn't acknowledged before
+// the deadline.
+func WithTimeoutCounter(counterOpts prometheus.CounterOpts) SinkOption {
+	return func(s *timeoutSink) {
+		s.timeouts = metrics.Register(prometheus.NewCounter(counterOpts)).(prometheus.Counter)
+	}
+}
+
+// WithRestartCounter sets a counter that is incremented every time the underlying sink is restarted because of
+// a timeout. It only has an effect with the Restart action.
+func WithRestartCounter(counterOpts prometheus.CounterOpts) SinkOption {
+	return func(s *timeoutSink) {
+		s.restarts = metrics.Register(prometheus.NewCounter(counterOpts)).(prometheus.Counter)
+	}
+}
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that publishes messages to the underlying
+// sink and tracks how long each of them waits for its acknowledgement. Once a message wasn't acknowledged within
+// the timeout, it is reported and the configured action is taken.
+func NewAsyncMessageSink(sink substrate.AsyncMessageSink, timeout time.Duration, opts ...SinkOption) substrate.AsyncMessageSink {
+	s := &timeoutSink{
+		sink:    sink,
+		timeout: timeout,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type timeoutSink struct {
+	sink      substrate.AsyncMessageSink
+	timeout   time.Duration
+	action    Action
+	onTimeout TimeoutFunc
+	timeouts  prometheus.Counter
+	restarts  prometheus.Counter
+}
+
+// pendingMessage is a message waiting for its acknowledgement, along with the time it was passed on to the
+// underlying sink at.
+type pendingMessage struct {
+	msg      substrate.Message
+	sent     time.Time
+	reported bool
+}
+
+// PublishMessages publishes messages to the underlying sink, restarting it after a timeout if configured to.
+func (s *timeoutSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "acktimeout"})
+	var pending []*pendingMessage
+	for {
+		err := s.publish(ctx, emit, acks, messages, &pending)
+		if err != errRestart {
+			return err
+		}
+		if s.restarts != nil {
+			s.restarts.Inc()
+		}
+	}
+}
+
+// publish runs a single call of PublishMessages of the underlying sink. The messages still pending from a
+// previous call are published first.
+func (s *timeoutSink) publish(ctx context.Context, emit events.Emitter, acks chan<- substrate.Message, messages <-chan substrate.Message, pending *[]*pendingMessage) error {
+	rg, ctx := rungroup.New(ctx)
+
+	toSink, sinkAcks := make(chan substrate.Message), make(chan substrate.Message)
+
+	rg.Go(func() error {
+		return s.sink.PublishMessages(ctx, sinkAcks, toSink)
+	})
+	rg.Go(func() error {
+		// The messages before sent have been passed on to the underlying sink by this call, and the messages
+		// before watched have been reported already.
+		sent, watched := 0, 0
+		var (
+			timer    *time.Timer
+			deadline <-chan time.Time
+			timed    *pendingMessage
+		)
+		defer func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}()
+
+		for {
+			for watched < sent && (*pending)[watched].reported {
+				watched++
+			}
+			if watched < sent && (*pending)[watched] != timed {
+				if timer != nil {
+					timer.Stop()
+				}
+				timed = (*pending)[watched]
+				timer = time.NewTimer(time.Until(timed.sent.Add(s.timeout)))
+				deadline = timer.C
+			} else if watched == sent && timed != nil {
+				timer.Stop()
+				timer, deadline, timed = nil, nil, nil
+			}
+
+			var (
+				in   <-chan substrate.Message
+				out  chan<- substrate.Message
+				next substrate.Message
+			)
+			if sent < len(*pending) {
+				out, next = toSink, (*pending)[sent].msg
+			} else {
+				in = messages
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-in:
+				emit.Publish(msg)
+				*pending = append(*pending, &pendingMessage{msg: msg})
+			case out <- next:
+				(*pending)[sent].sent = time.Now()
+				(*pending)[sent].reported = false
+				sent++
+			case ack := <-sinkAcks:
+				if sent == 0 || ack != (*pending)[0].msg {
+					return emit.Error(errors.Errorf("unexpected message acknowledged: %v", ack))
+				}
+				(*pending)[0] = nil
+				*pending = (*pending)[1:]
+				sent--
+				if watched > 0 {
+					watched--
+				}
+				emit.Ack(ack)
+				select {
+				case <-ctx.Done():
+					return nil
+				case acks <- ack:
+				}
+			case <-deadline:
+				timer, deadline, timed = nil, nil, nil
+				p := (*pending)[watched]
+				p.reported = true
+				if s.onTimeout != nil {
+					s.onTimeout(p.msg, time.Since(p.sent))
+				}
+				if s.timeouts != nil {
+					s.timeouts.Inc()
+				}
+				err := emit.Error(errors.Wrapf(ErrAckTimeout, "message waited %s", time.Since(p.sent).Round(time.Millisecond)))
+				switch s.action {
+				case Fail:
+					return err
+				case Restart:
+					return errRestart
+				}
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+// Close closes the underlying sink.
+func (s *timeoutSink) Close() error {
+	return s.sink.Close()
+}
+
+// Status returns the status of the underlying sink.
+func (s *timeoutSink) Status() (*substrate.Status, error) {
+	return s.sink.Status()
+}
@@ -0,0 +1,177 @@
+package acktimeout_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/acktimeout"
+	"github.com/uw-labs/substrate-tools/conformance"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+// stallingSink is a sink that never acknowledges messages during the first stalls calls of PublishMessages,
+// and acknowledges them after the delay afterwards.
+type stallingSink struct {
+	substrate.AsyncMessageSink
+	stalls int
+	delay  time.Duration
+
+	mutex    sync.Mutex
+	calls    int
+	received []string
+}
+
+func (s *stallingSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	s.mutex.Lock()
+	s.calls++
+	stall := s.calls <= s.stalls
+	s.mutex.Unlock()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-messages:
+			s.mutex.Lock()
+			s.received = append(s.received, string(msg.Data()))
+			s.mutex.Unlock()
+			if stall {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(s.delay):
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case acks <- msg:
+			}
+		}
+	}
+}
+
+func (s *stallingSink) state() (int, []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.calls, append([]string(nil), s.received...)
+}
+
+type timeout struct {
+	msg    substrate.Message
+	waited time.Duration
+}
+
+func TestSink_Fail(t *testing.T) {
+	timeouts := make(chan timeout, 1)
+	sink := acktimeout.NewAsyncMessageSink(&stallingSink{stalls: 1}, time.Millisecond*20,
+		acktimeout.WithTimeoutFunc(func(msg substrate.Message, waited time.Duration) {
+			timeouts <- timeout{msg: msg, waited: waited}
+		}),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	messages := make(chan substrate.Message, 1)
+	msg := message.FromString("a")
+	messages <- msg
+
+	err := sink.PublishMessages(ctx, make(chan substrate.Message), messages)
+	require.Equal(t, acktimeout.ErrAckTimeout, errors.Cause(err))
+
+	reported := <-timeouts
+	require.Equal(t, msg, reported.msg)
+	require.GreaterOrEqual(t, reported.waited, time.Millisecond*20)
+}
+
+func TestSink_Restart(t *testing.T) {
+	backend := &stallingSink{stalls: 1}
+	var timeouts int
+	sink := acktimeout.NewAsyncMessageSink(backend, time.Millisecond*20,
+		acktimeout.WithAction(acktimeout.Restart),
+		acktimeout.WithTimeoutFunc(func(substrate.Message, time.Duration) {
+			timeouts++
+		}),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	for _, payload := range []string{"a", "b"} {
+		messages <- message.FromString(payload)
+	}
+	for _, payload := range []string{"a", "b"} {
+		select {
+		case ack := <-acks:
+			require.Equal(t, payload, string(ack.Data()))
+		case err := <-errs:
+			require.FailNow(t, "publishing failed", "%v", err)
+		}
+	}
+	messages <- message.FromString("c")
+	require.Equal(t, "c", string((<-acks).Data()))
+
+	cancel()
+	require.NoError(t, <-errs)
+
+	calls, received := backend.state()
+	require.Equal(t, 2, calls)
+	require.Equal(t, []string{"a", "b", "a", "b", "c"}, received)
+	require.Equal(t, 1, timeouts)
+}
+
+func TestSink_Report(t *testing.T) {
+	var (
+		mutex    sync.Mutex
+		reported []string
+	)
+	sink := acktimeout.NewAsyncMessageSink(&stallingSink{delay: time.Millisecond * 50}, time.Millisecond*10,
+		acktimeout.WithAction(acktimeout.Report),
+		acktimeout.WithTimeoutFunc(func(msg substrate.Message, _ time.Duration) {
+			mutex.Lock()
+			reported = append(reported, string(msg.Data()))
+			mutex.Unlock()
+		}),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	for _, payload := range []string{"a", "b"} {
+		messages <- message.FromString(payload)
+		require.Equal(t, payload, string((<-acks).Data()))
+	}
+
+	cancel()
+	require.NoError(t, <-errs)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	require.Equal(t, []string{"a", "b"}, reported)
+}
+
+func TestSink_Conformance(t *testing.T) {
+	conformance.TestAsyncSink(t, func(_ *testing.T, backend substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+		return acktimeout.NewAsyncMessageSink(backend, time.Second)
+	})
+}
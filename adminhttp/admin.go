@@ -0,0 +1,280 @@
+// Package adminhttp provides a single admin endpoint exposing the state of the wrappers of a pipeline, such as
+// retry counters, circuit breaker statuses and buffer depths, along with controls to pause and resume sources and
+// to flush sinks, which can be mounted on an existing http.ServeMux instead of wiring bespoke endpoints per wrapper.
+package adminhttp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/debug"
+	"github.com/uw-labs/substrate-tools/health"
+	"github.com/uw-labs/substrate-tools/pause"
+	"github.com/uw-labs/substrate-tools/pipeline"
+)
+
+const defaultFlushTimeout = time.Second * 30
+
+// Flusher is implemented by sinks that can wait for the messages published so far to be acknowledged, such as the
+// sink of the flush package.
+type Flusher interface {
+	FlushContext(ctx context.Context) error
+}
+
+// ValueFunc returns the current value of a piece of state of a wrapper, e.g. a counter or the depth of a buffer.
+type ValueFunc func() float64
+
+// CollectorValue returns a ValueFunc summing the values of all the counters and gauges collected by the collector,
+// so that the metrics already exposed by a wrapper, e.g. a retry counter, can be added to the admin endpoint.
+func CollectorValue(collector prometheus.Collector) ValueFunc {
+	return func() float64 {
+		metrics := make(chan prometheus.Metric)
+		go func() {
+			collector.Collect(metrics)
+			close(metrics)
+		}()
+
+		var sum float64
+		for metric := range metrics {
+			var m dto.Metric
+			if err := metric.Write(&m); err != nil {
+				continue
+			}
+			switch {
+			case m.Counter != nil:
+				sum += m.Counter.GetValue()
+			case m.Gauge != nil:
+				sum += m.Gauge.GetValue()
+			case m.Untyped != nil:
+				sum += m.Untyped.GetValue()
+			}
+		}
+		return sum
+	}
+}
+
+// State is the state of all the wrappers added to the admin endpoint, as served by it.
+type State struct {
+	// Paused reports whether each pausable source is paused.
+	Paused map[string]bool `json:"paused"`
+	// Statuses are the statuses of the statusers, in the order in which they were added.
+	Statuses []health.CheckResult `json:"statuses"`
+	// Values are the current values of the value functions.
+	Values map[string]float64 `json:"values"`
+	// Flushers are the names of the sinks that can be flushed, sorted.
+	Flushers []string `json:"flushers"`
+	// Graphs are the names of the pipelines whose graph is served, sorted.
+	Graphs []string `json:"graphs"`
+}
+
+// Option is a function which sets an admin endpoint configuration option.
+type Option func(a *Admin)
+
+// WithFlushTimeout sets how long a flush request waits for the sink to be flushed before failing. The default value
+// is 30 seconds.
+func WithFlushTimeout(timeout time.Duration) Option {
+	return func(a *Admin) {
+		a.flushTimeout = timeout
+	}
+}
+
+// WithDebug serves snapshots of the messages in flight through the wrappers of the debug registry, see debug.Handler.
+func WithDebug(r *debug.Registry) Option {
+	return func(a *Admin) {
+		a.debug = r
+	}
+}
+
+// Admin is an http.Handler serving the admin endpoint of the wrappers added to it. Relative to the path it is
+// mounted on, it serves:
+//
+//   - GET /: the State of all the wrappers, as JSON.
+//   - /pause/{name}: the pause.Handler of the pausable source, which a POST request pauses and a DELETE request
+//     resumes.
+//   - POST /flush/{name}: flushes the sink, responding with http.StatusGatewayTimeout if it can't be flushed before
+//     the flush timeout.
+//   - GET /graph/{name}: the graph of the pipeline, see pipeline.GraphHandler.
+//   - GET /debug: a snapshot of the messages in flight, if enabled using WithDebug, see debug.Handler.
+//
+// Wrappers can be added at any time. It is safe for concurrent use.
+type Admin struct {
+	flushTimeout time.Duration
+	statuses     *health.Handler
+	debug        *debug.Registry
+
+	mutex    sync.RWMutex
+	pausers  map[string]pause.Pauser
+	flushers map[string]Flusher
+	values   map[string]ValueFunc
+	graphs   map[string]*pipeline.Pipeline
+}
+
+// New returns an admin endpoint without any wrappers.
+func New(opts ...Option) *Admin {
+	a := &Admin{
+		flushTimeout: defaultFlushTimeout,
+		statuses:     health.NewHandler(),
+		pausers:      make(map[string]pause.Pauser),
+		flushers:     make(map[string]Flusher),
+		values:       make(map[string]ValueFunc),
+		graphs:       make(map[string]*pipeline.Pipeline),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Register mounts the admin endpoint on the mux under the prefix, e.g. "/admin".
+func (a *Admin) Register(mux *http.ServeMux, prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	mux.Handle(prefix+"/", http.StripPrefix(prefix, a))
+}
+
+// AddPauser adds a pausable source, such as the source of the pause package, under the name.
+func (a *Admin) AddPauser(name string, p pause.Pauser) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.pausers[name] = p
+}
+
+// AddFlusher adds a sink that can be flushed under the name.
+func (a *Admin) AddFlusher(name string, f Flusher) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.flushers[name] = f
+}
+
+// AddStatus adds a statuser under the name, e.g. a circuit breaker reporting whether it is open as a problem.
+func (a *Admin) AddStatus(name string, statuser substrate.Statuser) {
+	a.statuses.Add(name, statuser)
+}
+
+// AddValue adds a value function under the name, e.g. the backlog of a buffer.
+func (a *Admin) AddValue(name string, f ValueFunc) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.values[name] = f
+}
+
+// AddGraph adds a pipeline under the name, serving its graph so that the wiring of the consumer can be inspected.
+func (a *Admin) AddGraph(name string, p *pipeline.Pipeline) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.graphs[name] = p
+}
+
+// State returns the current state of all the wrappers.
+func (a *Admin) State() State {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	state := State{
+		Paused:   make(map[string]bool, len(a.pausers)),
+		Statuses: a.statuses.Report().Checks,
+		Values:   make(map[string]float64, len(a.values)),
+		Flushers: make([]string, 0, len(a.flushers)),
+		Graphs:   make([]string, 0, len(a.graphs)),
+	}
+	for name, p := range a.pausers {
+		state.Paused[name] = p.Paused()
+	}
+	for name, f := range a.values {
+		state.Values[name] = f()
+	}
+	for name := range a.flushers {
+		state.Flushers = append(state.Flushers, name)
+	}
+	sort.Strings(state.Flushers)
+	for name := range a.graphs {
+		state.Graphs = append(state.Graphs, name)
+	}
+	sort.Strings(state.Graphs)
+	return state
+}
+
+// ServeHTTP serves the admin endpoint.
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	switch {
+	case path == "":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, a.State())
+	case strings.HasPrefix(path, "pause/"):
+		a.mutex.RLock()
+		p, ok := a.pausers[strings.TrimPrefix(path, "pause/")]
+		a.mutex.RUnlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		pause.Handler(p).ServeHTTP(w, r)
+	case strings.HasPrefix(path, "flush/"):
+		a.mutex.RLock()
+		f, ok := a.flushers[strings.TrimPrefix(path, "flush/")]
+		a.mutex.RUnlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		a.flush(w, r, f)
+	case strings.HasPrefix(path, "graph/"):
+		a.mutex.RLock()
+		p, ok := a.graphs[strings.TrimPrefix(path, "graph/")]
+		a.mutex.RUnlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		pipeline.GraphHandler(p).ServeHTTP(w, r)
+	case path == "debug" && a.debug != nil:
+		debug.Handler(a.debug).ServeHTTP(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// flushResult is the body of the responses to flush requests.
+type flushResult struct {
+	Flushed bool   `json:"flushed"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (a *Admin) flush(w http.ResponseWriter, r *http.Request, f Flusher) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), a.flushTimeout)
+	defer cancel()
+
+	if err := f.FlushContext(ctx); err != nil {
+		writeJSON(w, http.StatusGatewayTimeout, flushResult{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, flushResult{Flushed: true})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
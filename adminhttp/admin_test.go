@@ -0,0 +1,117 @@
+package adminhttp_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/adminhttp"
+	"github.com/uw-labs/substrate-tools/debug"
+	"github.com/uw-labs/substrate-tools/health"
+	"github.com/uw-labs/substrate-tools/mock"
+	"github.com/uw-labs/substrate-tools/pause"
+	"github.com/uw-labs/substrate-tools/pipeline"
+)
+
+type flusherFunc func(ctx context.Context) error
+
+func (f flusherFunc) FlushContext(ctx context.Context) error {
+	return f(ctx)
+}
+
+func serve(t *testing.T, mux *http.ServeMux, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func TestAdmin(t *testing.T) {
+	retries := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "adminhttp_test_retries_total", Help: "help"}, []string{"topic"})
+	retries.WithLabelValues("a").Add(2)
+	retries.WithLabelValues("b").Add(3)
+
+	source := pause.NewAsyncMessageSource(mock.NewSource())
+	flushed := 0
+
+	registry := debug.NewRegistry()
+	debug.NewAsyncMessageSink(mock.NewSink(), "archive", debug.WithRegistry(registry))
+
+	admin := adminhttp.New(adminhttp.WithDebug(registry))
+	admin.AddPauser("orders", source)
+	admin.AddFlusher("events", flusherFunc(func(ctx context.Context) error {
+		flushed++
+		return nil
+	}))
+	admin.AddFlusher("stuck", flusherFunc(func(ctx context.Context) error {
+		return errors.New("incomplete flush: 3 left to ack")
+	}))
+	admin.AddStatus("breaker", health.StatusFunc(func() (*substrate.Status, error) {
+		return &substrate.Status{Working: false, Problems: []string{"circuit open"}}, nil
+	}))
+	admin.AddValue("retries", adminhttp.CollectorValue(retries))
+	admin.AddValue("backlog", func() float64 { return 42 })
+	admin.AddGraph("orders", pipeline.From(source).Stage("decode", nil).To(mock.NewSink()))
+
+	mux := http.NewServeMux()
+	admin.Register(mux, "/admin")
+
+	w := serve(t, mux, http.MethodPost, "/admin/pause/orders")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.True(t, source.Paused())
+
+	w = serve(t, mux, http.MethodPost, "/admin/flush/events")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"flushed": true}`, w.Body.String())
+	require.Equal(t, 1, flushed)
+
+	w = serve(t, mux, http.MethodPost, "/admin/flush/stuck")
+	require.Equal(t, http.StatusGatewayTimeout, w.Code)
+	require.JSONEq(t, `{"flushed": false, "error": "incomplete flush: 3 left to ack"}`, w.Body.String())
+
+	w = serve(t, mux, http.MethodGet, "/admin/")
+	require.Equal(t, http.StatusOK, w.Code)
+	var state adminhttp.State
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &state))
+	require.Equal(t, adminhttp.State{
+		Paused:   map[string]bool{"orders": true},
+		Statuses: []health.CheckResult{{Name: "breaker", Problems: []string{"circuit open"}}},
+		Values:   map[string]float64{"retries": 5, "backlog": 42},
+		Flushers: []string{"events", "stuck"},
+		Graphs:   []string{"orders"},
+	}, state)
+
+	w = serve(t, mux, http.MethodGet, "/admin/graph/orders?format=dot")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"stage-0" [label="decode\nworkers: 1, buffer: 0" shape=box];`)
+
+	w = serve(t, mux, http.MethodGet, "/admin/debug")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "sink archive [idle]:")
+
+	w = serve(t, mux, http.MethodDelete, "/admin/pause/orders")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.False(t, source.Paused())
+}
+
+func TestAdmin_Errors(t *testing.T) {
+	admin := adminhttp.New()
+	admin.AddFlusher("events", flusherFunc(func(ctx context.Context) error { return nil }))
+
+	mux := http.NewServeMux()
+	admin.Register(mux, "/admin/")
+
+	require.Equal(t, http.StatusNotFound, serve(t, mux, http.MethodPost, "/admin/pause/unknown").Code)
+	require.Equal(t, http.StatusNotFound, serve(t, mux, http.MethodPost, "/admin/flush/unknown").Code)
+	require.Equal(t, http.StatusNotFound, serve(t, mux, http.MethodGet, "/admin/graph/unknown").Code)
+	require.Equal(t, http.StatusNotFound, serve(t, mux, http.MethodGet, "/admin/unknown").Code)
+	require.Equal(t, http.StatusNotFound, serve(t, mux, http.MethodGet, "/admin/debug").Code)
+	require.Equal(t, http.StatusMethodNotAllowed, serve(t, mux, http.MethodGet, "/admin/flush/events").Code)
+	require.Equal(t, http.StatusMethodNotAllowed, serve(t, mux, http.MethodPost, "/admin/").Code)
+}
@@ -0,0 +1,293 @@
+// Package aggregate provides a minimal stream processing layer over substrate, grouping consumed messages into
+// time windows by key and publishing the result of reducing each window to a sink.
+package aggregate
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// KeyFunc returns the key messages are grouped by.
+type KeyFunc func(msg substrate.Message) (string, error)
+
+// ReduceFunc returns the message published for the window of the key, given the messages of the window in the
+// order in which they were consumed. An error returned by it stops the aggregation.
+type ReduceFunc func(key string, window Window, messages []substrate.Message) (substrate.Message, error)
+
+// Window is the time range of a window, from its inclusive start to its exclusive end.
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Windows describes how messages are assigned to windows. Window boundaries are multiples of the slide, so that
+// all the keys share them.
+type Windows struct {
+	size  time.Duration
+	slide time.Duration
+}
+
+// Tumbling returns fixed size, non-overlapping windows, each message belonging to exactly one window.
+func Tumbling(size time.Duration) Windows {
+	return Windows{size: size, slide: size}
+}
+
+// Sliding returns fixed size windows starting every slide, which must not exceed the size, a message belonging
+// to every window overlapping the time it was consumed at.
+func Sliding(size, slide time.Duration) Windows {
+	return Windows{size: size, slide: slide}
+}
+
+// of returns the windows containing the time.
+func (w Windows) of(t time.Time) []Window {
+	var windows []Window
+	for start := t.Truncate(w.slide); start.Add(w.size).After(t); start = start.Add(-w.slide) {
+		windows = append(windows, Window{Start: start, End: start.Add(w.size)})
+	}
+	return windows
+}
+
+// Run consumes messages from the source, assigning them to the windows of their key by the time at which they
+// were consumed. Once a window ends, the result of reducing its messages is published to the sink. Consumed
+// messages are only acknowledged to the source once the results of all the windows they belong to have been
+// acknowledged by the sink, so messages of windows that didn't end before the aggregation stops are consumed
+// again by the next one. It runs until the context is cancelled, in which case it returns nil, or any of the
+// source, the sink or the functions fails.
+func Run(ctx context.Context, source substrate.AsyncMessageSource, sink substrate.AsyncMessageSink, windows Windows, key KeyFunc, reduce ReduceFunc) error {
+	if windows.slide <= 0 || windows.slide > windows.size {
+		return errors.New("window slide must be positive and not exceed the window size")
+	}
+	a := &aggregator{
+		windows: windows,
+		key:     key,
+		reduce:  reduce,
+		open:    make(map[windowKey]*window),
+		now:     time.Now,
+	}
+	return a.run(ctx, source, sink)
+}
+
+type windowKey struct {
+	key   string
+	start time.Time
+}
+
+type window struct {
+	Window
+	key     string
+	entries []*entry
+}
+
+// entry is a consumed message, which is acknowledged once the results of all its windows are acknowledged.
+type entry struct {
+	msg       substrate.Message
+	remaining int
+}
+
+// result is a published window result, with the entries it completes once acknowledged.
+type result struct {
+	msg     substrate.Message
+	entries []*entry
+}
+
+type aggregator struct {
+	windows Windows
+	key     KeyFunc
+	reduce  ReduceFunc
+	now     func() time.Time
+
+	// open is only accessed by the goroutine assigning messages to windows.
+	open map[windowKey]*window
+
+	mutex     sync.Mutex
+	pending   []*entry
+	published []*result
+}
+
+func (a *aggregator) run(ctx context.Context, source substrate.AsyncMessageSource, sink substrate.AsyncMessageSink) error {
+	rg, ctx := rungroup.New(ctx)
+
+	consumed, sourceAcks := make(chan substrate.Message), make(chan substrate.Message)
+	toPublish, sinkAcks := make(chan substrate.Message), make(chan substrate.Message)
+
+	rg.Go(func() error {
+		if err := source.ConsumeMessages(ctx, consumed, sourceAcks); err != nil {
+			return errors.Wrap(err, "source failed")
+		}
+		return nil
+	})
+	rg.Go(func() error {
+		if err := sink.PublishMessages(ctx, sinkAcks, toPublish); err != nil {
+			return errors.Wrap(err, "sink failed")
+		}
+		return nil
+	})
+
+	// Assign consumed messages to windows and publish the results of the windows that ended.
+	rg.Go(func() error {
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-consumed:
+				if err := a.assign(msg); err != nil {
+					return err
+				}
+			case <-timer.C:
+				results, err := a.close()
+				if err != nil {
+					return err
+				}
+				for _, res := range results {
+					select {
+					case <-ctx.Done():
+						return nil
+					case toPublish <- res.msg:
+					}
+				}
+			}
+			a.resetTimer(timer)
+		}
+	})
+
+	// Acknowledge consumed messages once the results of all their windows are acknowledged.
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-sinkAcks:
+				released, err := a.complete(ack)
+				if err != nil {
+					return err
+				}
+				for _, msg := range released {
+					select {
+					case <-ctx.Done():
+						return nil
+					case sourceAcks <- msg:
+					}
+				}
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+// assign adds the message to its windows.
+func (a *aggregator) assign(msg substrate.Message) error {
+	key, err := a.key(msg)
+	if err != nil {
+		return errors.Wrap(err, "failed to get message key")
+	}
+	windows := a.windows.of(a.now())
+
+	e := &entry{msg: msg, remaining: len(windows)}
+	a.mutex.Lock()
+	a.pending = append(a.pending, e)
+	a.mutex.Unlock()
+
+	for _, w := range windows {
+		wk := windowKey{key: key, start: w.Start}
+		open, ok := a.open[wk]
+		if !ok {
+			open = &window{Window: w, key: key}
+			a.open[wk] = open
+		}
+		open.entries = append(open.entries, e)
+	}
+	return nil
+}
+
+// close reduces the windows that ended, ordered by their end and then by key, and records their results as
+// published, so that the acknowledgements of the sink can be matched with them.
+func (a *aggregator) close() ([]*result, error) {
+	now := a.now()
+	var ended []*window
+	for wk, w := range a.open {
+		if !w.End.After(now) {
+			ended = append(ended, w)
+			delete(a.open, wk)
+		}
+	}
+	sort.Slice(ended, func(i, j int) bool {
+		if !ended[i].End.Equal(ended[j].End) {
+			return ended[i].End.Before(ended[j].End)
+		}
+		return ended[i].key < ended[j].key
+	})
+
+	results := make([]*result, 0, len(ended))
+	for _, w := range ended {
+		messages := make([]substrate.Message, len(w.entries))
+		for i, e := range w.entries {
+			messages[i] = e.msg
+		}
+		msg, err := a.reduce(w.key, w.Window, messages)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to reduce window of key %s", w.key)
+		}
+		if msg == nil {
+			return nil, errors.Errorf("no result for window of key %s", w.key)
+		}
+		results = append(results, &result{msg: msg, entries: w.entries})
+	}
+
+	a.mutex.Lock()
+	a.published = append(a.published, results...)
+	a.mutex.Unlock()
+	return results, nil
+}
+
+// resetTimer sets the timer to fire when the earliest open window ends.
+func (a *aggregator) resetTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	var next time.Time
+	for _, w := range a.open {
+		if next.IsZero() || w.End.Before(next) {
+			next = w.End
+		}
+	}
+	if !next.IsZero() {
+		timer.Reset(next.Sub(a.now()))
+	}
+}
+
+// complete matches the acknowledgement with the oldest published result and returns the consumed messages that
+// can be acknowledged, in order.
+func (a *aggregator) complete(ack substrate.Message) ([]substrate.Message, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if len(a.published) == 0 || a.published[0].msg != ack {
+		return nil, errors.Errorf("unexpected message acknowledged: %v", ack)
+	}
+	res := a.published[0]
+	a.published = a.published[1:]
+	for _, e := range res.entries {
+		e.remaining--
+	}
+
+	var released []substrate.Message
+	for len(a.pending) > 0 && a.pending[0].remaining == 0 {
+		released = append(released, a.pending[0].msg)
+		a.pending = a.pending[1:]
+	}
+	return released, nil
+}
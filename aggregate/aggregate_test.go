@@ -0,0 +1,128 @@
+package aggregate_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/aggregate"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+// key groups payloads of the form key:value by their key.
+func key(msg substrate.Message) (string, error) {
+	return strings.SplitN(string(msg.Data()), ":", 2)[0], nil
+}
+
+// count publishes the key of the window and the number of its messages.
+func count(key string, _ aggregate.Window, messages []substrate.Message) (substrate.Message, error) {
+	return message.FromString(key + "=" + strconv.Itoa(len(messages))), nil
+}
+
+// totals sums the counts of the published results per key.
+func totals(broker *mem.Broker) map[string]int {
+	totals := make(map[string]int)
+	for _, data := range broker.Messages("results") {
+		parts := strings.SplitN(string(data), "=", 2)
+		n, _ := strconv.Atoi(parts[1])
+		totals[parts[0]] += n
+	}
+	return totals
+}
+
+func run(ctx context.Context, source substrate.AsyncMessageSource, broker *mem.Broker, windows aggregate.Windows) <-chan error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- aggregate.Run(ctx, source, broker.NewAsyncMessageSink("results"), windows, key, count)
+	}()
+	return errs
+}
+
+func TestRun_Tumbling(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source := mock.NewSource(
+		message.FromString("a:1"),
+		message.FromString("b:1"),
+		message.FromString("a:2"),
+	)
+	broker := mem.NewBroker()
+	errs := run(ctx, source, broker, aggregate.Tumbling(time.Millisecond*50))
+
+	// The mock source terminates with an error if acknowledgements are out of order.
+	select {
+	case <-source.AllAcked():
+	case err := <-errs:
+		require.FailNow(t, "aggregation failed", "%v", err)
+	}
+	cancel()
+	require.NoError(t, <-errs)
+	require.Equal(t, map[string]int{"a": 2, "b": 1}, totals(broker))
+}
+
+func TestRun_Sliding(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source := mock.NewSource(message.FromString("a:1"), message.FromString("a:2"))
+	broker := mem.NewBroker()
+	errs := run(ctx, source, broker, aggregate.Sliding(time.Millisecond*100, time.Millisecond*50))
+
+	// Every message belongs to two windows, and is only acknowledged once both results are.
+	select {
+	case <-source.AllAcked():
+	case err := <-errs:
+		require.FailNow(t, "aggregation failed", "%v", err)
+	}
+	cancel()
+	require.NoError(t, <-errs)
+	require.Equal(t, map[string]int{"a": 4}, totals(broker))
+}
+
+func TestRun_Unacknowledged(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source := mock.NewSource(message.FromString("a:1"))
+	sink := mock.NewSink().AckWith(func(int, substrate.Message) bool {
+		return false
+	})
+	errs := make(chan error, 1)
+	go func() {
+		errs <- aggregate.Run(ctx, source, sink, aggregate.Tumbling(time.Millisecond*10), key, count)
+	}()
+
+	require.Eventually(t, func() bool {
+		return len(sink.Published()) == 1
+	}, time.Second, time.Millisecond*5)
+	cancel()
+	require.NoError(t, <-errs)
+	require.Empty(t, source.Acked())
+}
+
+func TestRun_ReduceError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source := mock.NewSource(message.FromString("a:1"))
+	err := aggregate.Run(ctx, source, mem.NewBroker().NewAsyncMessageSink("results"), aggregate.Tumbling(time.Millisecond*10), key,
+		func(string, aggregate.Window, []substrate.Message) (substrate.Message, error) {
+			return nil, errors.New("boom")
+		},
+	)
+	require.EqualError(t, err, "failed to reduce window of key a: boom")
+}
+
+func TestRun_InvalidWindows(t *testing.T) {
+	err := aggregate.Run(context.Background(), mock.NewSource(), mock.NewSink(), aggregate.Sliding(time.Second, time.Minute), key, count)
+	require.Error(t, err)
+}
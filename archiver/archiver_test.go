@@ -0,0 +1,184 @@
+package archiver_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/archiver"
+	"github.com/uw-labs/substrate-tools/checkpoint"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+func consume(t *testing.T, source substrate.AsyncMessageSource, count int) []substrate.Message {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	var consumed []substrate.Message
+	for i := 0; i < count; i++ {
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "failed to consume all messages")
+		case msg := <-messages:
+			consumed = append(consumed, msg)
+			acks <- msg
+		}
+	}
+	cancel()
+	require.NoError(t, <-errs)
+	return consumed
+}
+
+func payloads(msgs []substrate.Message) []string {
+	var payloads []string
+	for _, msg := range msgs {
+		payloads = append(payloads, string(msg.Data()))
+	}
+	return payloads
+}
+
+func TestArchiveAndReplay(t *testing.T) {
+	fileStore, err := archiver.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	for name, store := range map[string]archiver.ObjectStore{
+		"memory": archiver.NewMemoryStore(),
+		"file":   fileStore,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+
+			source := &mock.AsyncMessageSource{
+				Messages: []substrate.Message{
+					message.FromString("one"),
+					message.NewEnvelopedMessage(message.Envelope{
+						Headers: map[string]string{"key": "value"},
+						Payload: []byte("two"),
+					}),
+					message.FromString("three"),
+				},
+			}
+			errs := make(chan error)
+			go func() {
+				errs <- archiver.Run(ctx, source, store, archiver.WithPrefix("orders/"), archiver.WithMaxSize(6))
+			}()
+
+			// The first two messages fill up an object, while the last one waits for its object to fill up.
+			var keys []string
+			require.Eventually(t, func() bool {
+				keys, err = store.List(ctx, "orders/")
+				return err == nil && len(keys) == 1
+			}, time.Second, time.Millisecond*10)
+			require.Regexp(t, regexp.MustCompile(`^orders/\d{4}/\d{2}/\d{2}/\d{2}/\d{8}T\d{6}\.\d{9}Z-[0-9a-f]{8}\.arc$`), keys[0])
+			cancel()
+			require.NoError(t, <-errs)
+
+			consumed := consume(t, archiver.NewAsyncMessageSource(store, archiver.WithSourcePrefix("orders/")), 2)
+			require.Equal(t, []string{"one", "two"}, payloads(consumed))
+			require.Equal(t, map[string]string{"key": "value"}, message.Headers(consumed[1]))
+			positioned := consumed[1].(checkpoint.Positioned)
+			require.Equal(t, keys[0], positioned.Partition())
+			require.EqualValues(t, 1, positioned.Offset())
+		})
+	}
+}
+
+func TestArchive_WritesOldObjects(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	store := archiver.NewMemoryStore()
+	source := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{message.FromString("one"), message.FromString("two")},
+	}
+	errs := make(chan error)
+	go func() {
+		errs <- archiver.Run(ctx, source, store, archiver.WithMaxAge(time.Millisecond*10))
+	}()
+
+	require.Eventually(t, func() bool {
+		keys, err := store.List(ctx, "")
+		if err != nil || len(keys) == 0 {
+			return false
+		}
+		total := 0
+		for _, key := range keys {
+			data, err := store.Get(ctx, key)
+			require.NoError(t, err)
+			object, err := archiver.Decode(data)
+			require.NoError(t, err)
+			total += object.Len()
+		}
+		return total == 2
+	}, time.Second, time.Millisecond*10)
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestEncodeDecode(t *testing.T) {
+	records := []archiver.Record{
+		{Time: time.Date(2024, 5, 17, 9, 30, 0, 0, time.UTC), Data: []byte("one")},
+		{Time: time.Date(2024, 5, 17, 9, 31, 0, 0, time.UTC), Data: []byte("two"), Headers: map[string]string{"key": "value"}},
+	}
+	data, err := archiver.Encode(records)
+	require.NoError(t, err)
+
+	object, err := archiver.Decode(data)
+	require.NoError(t, err)
+	require.Equal(t, 2, object.Len())
+	// Records can be read in any order through the index.
+	for _, i := range []int{1, 0} {
+		record, err := object.Record(i)
+		require.NoError(t, err)
+		require.Equal(t, records[i], record)
+	}
+
+	_, err = archiver.Decode(data[:len(data)-1])
+	require.Equal(t, archiver.ErrInvalidObject, err)
+	_, err = archiver.Decode(data[len(data)-20:])
+	require.Equal(t, archiver.ErrInvalidObject, err)
+}
+
+func TestReplay_FailsOnInvalidObject(t *testing.T) {
+	store := archiver.NewMemoryStore()
+	require.NoError(t, store.Put(context.Background(), "broken.arc", []byte("not an archive")))
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	err := archiver.NewAsyncMessageSource(store).ConsumeMessages(context.Background(), messages, acks)
+	require.EqualError(t, err, "failed to decode object broken.arc: invalid archive object")
+}
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := archiver.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, store.Put(ctx, "a/2/object", []byte("two")))
+	require.NoError(t, store.Put(ctx, "a/1/object", []byte("one")))
+	require.NoError(t, store.Put(ctx, "b/object", []byte("three")))
+
+	keys, err := store.List(ctx, "a/")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a/1/object", "a/2/object"}, keys)
+
+	data, err := store.Get(ctx, "a/2/object")
+	require.NoError(t, err)
+	require.Equal(t, []byte("two"), data)
+
+	_, err = store.Get(ctx, "missing")
+	require.Equal(t, archiver.ErrObjectNotFound, err)
+	require.EqualError(t, store.Put(ctx, "../escape", nil), `invalid object key "../escape"`)
+}
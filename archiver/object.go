@@ -0,0 +1,98 @@
+package archiver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// magic terminates every archived object, identifying its format.
+const magic = "SUBARC01"
+
+// footerSize is the size of the footer of an object: the number of records followed by the magic.
+const footerSize = 4 + len(magic)
+
+// ErrInvalidObject is returned when an object isn't a valid archive.
+var ErrInvalidObject = errors.New("invalid archive object")
+
+// Record is an archived message.
+type Record struct {
+	// Time is the time at which the message was received by the archiver.
+	Time time.Time `json:"time"`
+	// Data is the payload of the message.
+	Data []byte `json:"data"`
+	// Headers are the headers of the message, if it carried any.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Encode returns an archived object holding the records. An object is the sequence of the records, each encoded as
+// JSON and prefixed by its length as a 4 byte big endian integer, followed by an index made of the offset of each
+// record as an 8 byte big endian integer, the number of records as a 4 byte big endian integer and the "SUBARC01"
+// magic.
+func Encode(records []Record) ([]byte, error) {
+	var (
+		buf   bytes.Buffer
+		index = make([]byte, 0, 8*len(records)+footerSize)
+	)
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to encode record")
+		}
+		index = binary.BigEndian.AppendUint64(index, uint64(buf.Len()))
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(len(data))))
+		buf.Write(data)
+	}
+	index = binary.BigEndian.AppendUint32(index, uint32(len(records)))
+	index = append(index, magic...)
+	buf.Write(index)
+	return buf.Bytes(), nil
+}
+
+// Object is a decoded archived object, giving access to its records through its index.
+type Object struct {
+	data    []byte
+	offsets []uint64
+}
+
+// Decode decodes the index of an archived object. It returns ErrInvalidObject if the data isn't a valid object.
+func Decode(data []byte) (*Object, error) {
+	if len(data) < footerSize || string(data[len(data)-len(magic):]) != magic {
+		return nil, ErrInvalidObject
+	}
+	count := int(binary.BigEndian.Uint32(data[len(data)-footerSize:]))
+	indexStart := len(data) - footerSize - 8*count
+	if indexStart < 0 {
+		return nil, ErrInvalidObject
+	}
+	offsets := make([]uint64, count)
+	for i := range offsets {
+		offsets[i] = binary.BigEndian.Uint64(data[indexStart+8*i:])
+		if offsets[i]+4 > uint64(indexStart) {
+			return nil, ErrInvalidObject
+		}
+	}
+	return &Object{data: data[:indexStart], offsets: offsets}, nil
+}
+
+// Len returns the number of records of the object.
+func (o *Object) Len() int {
+	return len(o.offsets)
+}
+
+// Record returns the i-th record of the object.
+func (o *Object) Record(i int) (Record, error) {
+	var record Record
+	offset := o.offsets[i]
+	size := uint64(binary.BigEndian.Uint32(o.data[offset:]))
+	if offset+4+size > uint64(len(o.data)) {
+		return record, ErrInvalidObject
+	}
+	if err := json.Unmarshal(o.data[offset+4:offset+4+size], &record); err != nil {
+		return record, errors.Wrap(err, "failed to decode record")
+	}
+	return record, nil
+}
@@ -0,0 +1,174 @@
+// Package archiver provides a way of archiving messages into the objects of a pluggable object store, such as S3,
+// and of replaying archived objects as a message source.
+package archiver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/bridge"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+const (
+	defaultMaxSize = 16 << 20
+	defaultMaxAge  = time.Minute
+)
+
+// Option is a function which sets an archiver configuration option.
+type Option func(s *archiveSink)
+
+// WithPrefix sets the prefix of the keys of the objects, e.g. "orders/". Keys are made of the prefix followed by the
+// time at which the object was written, e.g. "orders/2024/05/17/09/20240517T093012.123456789Z-0f3a9c1e.arc", so that
+// they are listed in the order in which they were written and the objects of a period share a prefix.
+func WithPrefix(prefix string) Option {
+	return func(s *archiveSink) {
+		s.prefix = prefix
+	}
+}
+
+// WithMaxSize sets the size in bytes of the payloads of the messages of an object after which it is written. The
+// default value is 16MiB.
+func WithMaxSize(size int) Option {
+	return func(s *archiveSink) {
+		s.maxSize = size
+	}
+}
+
+// WithMaxAge sets the maximum time a message waits for its object to fill up before the object is written anyway.
+// The default value is 1 minute.
+func WithMaxAge(age time.Duration) Option {
+	return func(s *archiveSink) {
+		s.maxAge = age
+	}
+}
+
+// Run consumes messages from the source and archives them into objects of the store until the context is cancelled
+// or either of them fails. Messages are only acknowledged to the source once their object was written. It returns
+// nil if it is stopped by cancelling the context.
+func Run(ctx context.Context, source substrate.AsyncMessageSource, store ObjectStore, opts ...Option) error {
+	return bridge.Run(ctx, source, NewAsyncMessageSink(store, opts...), bridge.Options{})
+}
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that archives messages, including their
+// headers, into objects of the store. Messages are batched into objects bounded by size and age, and acknowledged
+// once their object was written. A failure to write an object makes PublishMessages return an error, leaving the
+// messages of the object unacknowledged.
+func NewAsyncMessageSink(store ObjectStore, opts ...Option) substrate.AsyncMessageSink {
+	s := &archiveSink{
+		store:   store,
+		maxSize: defaultMaxSize,
+		maxAge:  defaultMaxAge,
+		now:     time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type archiveSink struct {
+	store   ObjectStore
+	prefix  string
+	maxSize int
+	maxAge  time.Duration
+	now     func() time.Time
+}
+
+func (s *archiveSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "archiver"})
+	var (
+		batch   []substrate.Message
+		records []Record
+		size    int
+		timer   *time.Timer
+		due     <-chan time.Time
+	)
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	flush := func() error {
+		if timer != nil {
+			timer.Stop()
+			timer, due = nil, nil
+		}
+		if err := s.write(ctx, records); err != nil {
+			return emit.Error(err)
+		}
+		for _, msg := range batch {
+			emit.Ack(msg)
+			select {
+			case <-ctx.Done():
+				return nil
+			case acks <- msg:
+			}
+		}
+		batch, records, size = nil, nil, 0
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-messages:
+			emit.Publish(msg)
+			record := Record{Time: s.now().UTC(), Data: msg.Data()}
+			if headers := message.Headers(msg); len(headers) > 0 {
+				record.Headers = headers
+			}
+			batch, records = append(batch, msg), append(records, record)
+			size += len(record.Data)
+			if size >= s.maxSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			} else if timer == nil {
+				timer = time.NewTimer(s.maxAge)
+				due = timer.C
+			}
+		case <-due:
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// write writes the records to a new object.
+func (s *archiveSink) write(ctx context.Context, records []Record) error {
+	data, err := Encode(records)
+	if err != nil {
+		return err
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return errors.Wrap(err, "failed to generate object key")
+	}
+	now := s.now().UTC()
+	key := fmt.Sprintf("%s%s/%s-%s.arc", s.prefix, now.Format("2006/01/02/15"), now.Format("20060102T150405.000000000Z"), hex.EncodeToString(suffix))
+	if err := s.store.Put(ctx, key, data); err != nil {
+		return errors.Wrap(err, "failed to archive messages")
+	}
+	return nil
+}
+
+// Close does nothing, the store is owned by the caller.
+func (s *archiveSink) Close() error {
+	return nil
+}
+
+// Status always reports working status.
+func (s *archiveSink) Status() (*substrate.Status, error) {
+	return &substrate.Status{Working: true}, nil
+}
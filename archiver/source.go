@@ -0,0 +1,174 @@
+package archiver
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// SourceOption is a function which sets an archive source configuration option.
+type SourceOption func(s *archiveSource)
+
+// WithSourcePrefix sets the prefix of the keys of the objects to replay, e.g. "orders/2024/05/" to replay the
+// messages archived with the "orders/" prefix in May 2024. All objects are replayed by default.
+func WithSourcePrefix(prefix string) SourceOption {
+	return func(s *archiveSource) {
+		s.prefix = prefix
+	}
+}
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that replays the messages of the
+// archived objects of the store, in the order of their keys. Messages implement checkpoint.Positioned, with the key
+// of their object as the partition and their index within the object as the offset. Consumption fails if an object
+// isn't a valid archive.
+func NewAsyncMessageSource(store ObjectStore, opts ...SourceOption) substrate.AsyncMessageSource {
+	s := &archiveSource{store: store}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type archiveSource struct {
+	store  ObjectStore
+	prefix string
+
+	mutex  sync.RWMutex
+	cancel func()
+	closed bool
+}
+
+// ConsumeMessages replays the archived messages and then waits for the context to be cancelled.
+func (s *archiveSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "archiver"})
+	ctx, cancel, err := s.init(ctx)
+	if err != nil {
+		return emit.Error(err)
+	}
+	defer cancel()
+
+	rg, ctx := rungroup.New(ctx)
+
+	rg.Go(emit.Errors(func() error {
+		keys, err := s.store.List(ctx, s.prefix)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := s.replay(ctx, emit, key, messages); err != nil {
+				return err
+			}
+		}
+		<-ctx.Done()
+		return nil
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				if _, ok := ack.(*archivedMessage); !ok {
+					return errors.Errorf("unexpected message acknowledged: %v", ack)
+				}
+				emit.Ack(ack)
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+// replay delivers the messages of the object.
+func (s *archiveSource) replay(ctx context.Context, emit events.Emitter, key string, messages chan<- substrate.Message) error {
+	data, err := s.store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	object, err := Decode(data)
+	if err != nil {
+		return errors.Wrapf(err, "failed to decode object %s", key)
+	}
+	for i := 0; i < object.Len(); i++ {
+		record, err := object.Record(i)
+		if err != nil {
+			return errors.Wrapf(err, "failed to decode object %s", key)
+		}
+		msg := &archivedMessage{record: record, key: key, index: int64(i)}
+		emit.Publish(msg)
+		select {
+		case <-ctx.Done():
+			return nil
+		case messages <- msg:
+		}
+	}
+	return nil
+}
+
+func (s *archiveSource) init(ctx context.Context) (context.Context, func(), error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil, nil, errors.New("source already closed")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s.cancel = cancel
+	return ctx, cancel, nil
+}
+
+// Close closes the source, it causes any running call to ConsumeMessages to return.
+func (s *archiveSource) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.cancel != nil {
+		s.cancel()
+	}
+	return nil
+}
+
+// Status returns the status of the source. It reports not working after the source was closed.
+func (s *archiveSource) Status() (*substrate.Status, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.closed {
+		return &substrate.Status{Working: false, Problems: []string{"source already closed"}}, nil
+	}
+	return &substrate.Status{Working: true}, nil
+}
+
+type archivedMessage struct {
+	record Record
+	key    string
+	index  int64
+}
+
+func (msg *archivedMessage) Data() []byte {
+	return msg.record.Data
+}
+
+// Headers returns the headers the message was archived with.
+func (msg *archivedMessage) Headers() map[string]string {
+	return msg.record.Headers
+}
+
+// Partition returns the key of the object the message was archived in.
+func (msg *archivedMessage) Partition() string {
+	return msg.key
+}
+
+// Offset returns the index of the message within its object.
+func (msg *archivedMessage) Offset() int64 {
+	return msg.index
+}
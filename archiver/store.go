@@ -0,0 +1,223 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrObjectNotFound is returned by stores when an object doesn't exist.
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectStore stores the archived objects.
+type ObjectStore interface {
+	// Put stores the data under the key.
+	Put(ctx context.Context, key string, data []byte) error
+	// Get returns the data stored under the key, or ErrObjectNotFound if there is none.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// List returns the keys starting with the prefix, in lexical order.
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// NewMemoryStore returns an in-memory store, which is mostly useful for testing.
+func NewMemoryStore() ObjectStore {
+	return &memoryStore{
+		objects: make(map[string][]byte),
+	}
+}
+
+type memoryStore struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *memoryStore) Put(_ context.Context, key string, data []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.objects[key] = append([]byte(nil), data...)
+	return nil
+}
+
+func (s *memoryStore) Get(_ context.Context, key string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (s *memoryStore) List(_ context.Context, prefix string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// NewFileStore returns a store that keeps each object in a file of the directory, the slashes of keys separating
+// sub-directories. Files are written atomically, so that readers never read a partially written object.
+func NewFileStore(dir string) (ObjectStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "failed to create archive directory")
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+type fileStore struct {
+	dir string
+}
+
+func (s *fileStore) Put(_ context.Context, key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return errors.Wrap(err, "failed to write object")
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".object-*")
+	if err != nil {
+		return errors.Wrap(err, "failed to write object")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write object")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write object")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to write object")
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.Wrap(err, "failed to write object")
+	}
+	return nil
+}
+
+func (s *fileStore) Get(_ context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return nil, ErrObjectNotFound
+	case err != nil:
+		return nil, errors.Wrap(err, "failed to read object")
+	}
+	return data, nil
+}
+
+func (s *fileStore) List(_ context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(s.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".object-") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list objects")
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// path returns the path of the file of the key, which must not escape the directory.
+func (s *fileStore) path(key string) (string, error) {
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." || strings.Contains(part, `\`) {
+			return "", errors.Errorf("invalid object key %q", key)
+		}
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// ObjectClient is a minimal client of an S3 compatible object storage, which can be implemented on top of e.g. the
+// AWS SDK or MinIO to archive messages using NewObjectStore.
+type ObjectClient interface {
+	// PutObject uploads the object.
+	PutObject(ctx context.Context, bucket, key string, body io.Reader, size int64) error
+	// GetObject returns the content of the object, or an error for which IsNotFound reports true if it doesn't
+	// exist.
+	GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error)
+	// ListObjects returns the keys of the objects starting with the prefix, in lexical order.
+	ListObjects(ctx context.Context, bucket, prefix string) ([]string, error)
+	// IsNotFound reports whether the error was returned because the object doesn't exist.
+	IsNotFound(err error) bool
+}
+
+// NewObjectStore returns a store that keeps each object as an object of the bucket.
+func NewObjectStore(client ObjectClient, bucket string) ObjectStore {
+	return &objectStore{client: client, bucket: bucket}
+}
+
+type objectStore struct {
+	client ObjectClient
+	bucket string
+}
+
+func (s *objectStore) Put(ctx context.Context, key string, data []byte) error {
+	if err := s.client.PutObject(ctx, s.bucket, key, bytes.NewReader(data), int64(len(data))); err != nil {
+		return errors.Wrap(err, "failed to write object")
+	}
+	return nil
+}
+
+func (s *objectStore) Get(ctx context.Context, key string) ([]byte, error) {
+	body, err := s.client.GetObject(ctx, s.bucket, key)
+	switch {
+	case err != nil && s.client.IsNotFound(err):
+		return nil, ErrObjectNotFound
+	case err != nil:
+		return nil, errors.Wrap(err, "failed to read object")
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read object")
+	}
+	return data, nil
+}
+
+func (s *objectStore) List(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := s.client.ListObjects(ctx, s.bucket, prefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list objects")
+	}
+	return keys, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
 	"github.com/uw-labs/sync/rungroup"
 )
 
@@ -77,6 +78,7 @@ type messageSourceAdapter struct {
 }
 
 func (a *messageSourceAdapter) ConsumeMessages(ctx context.Context, handler ConsumerMessageHandler) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "async"})
 	rg, ctx := rungroup.New(ctx)
 
 	messages := make(chan substrate.Message, a.msgBuffer)
@@ -96,6 +98,7 @@ func (a *messageSourceAdapter) ConsumeMessages(ctx context.Context, handler Cons
 						if acked {
 							return nil
 						}
+						emit.Ack(msg)
 						select {
 						case acks <- msg:
 							acked = true
@@ -104,8 +107,9 @@ func (a *messageSourceAdapter) ConsumeMessages(ctx context.Context, handler Cons
 							return ctx.Err()
 						}
 					}
+					emit.Publish(msg)
 					if err := handler(ctx, msg, ackFunc); err != nil {
-						return err
+						return emit.Error(err)
 					}
 				case <-ctx.Done():
 					return nil
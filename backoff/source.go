@@ -0,0 +1,249 @@
+// Package backoff provides a message source wrapper that redelivers messages the consumer failed to process after
+// an exponentially increasing delay, retrying with backoff at the consumer instead of republishing the messages.
+package backoff
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/ackordering"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+const (
+	defaultInitialDelay = time.Second
+	defaultMaxDelay     = time.Minute
+	defaultMultiplier   = 2
+)
+
+// AsyncMessageSourceOption is a function which sets an AsyncMessageSource configuration option.
+type AsyncMessageSourceOption func(s *backoffSource)
+
+// WithInitialDelay sets the delay before a message is delivered again after its first attempt failed. The
+// default value is 1 second.
+func WithInitialDelay(delay time.Duration) AsyncMessageSourceOption {
+	return func(s *backoffSource) {
+		s.initialDelay = delay
+	}
+}
+
+// WithMaxDelay sets the maximum delay before a message is delivered again. The default value is 1 minute.
+func WithMaxDelay(delay time.Duration) AsyncMessageSourceOption {
+	return func(s *backoffSource) {
+		s.maxDelay = delay
+	}
+}
+
+// WithMultiplier sets the factor the delay is multiplied by after every failed attempt. The default value is 2.
+func WithMultiplier(multiplier float64) AsyncMessageSourceOption {
+	return func(s *backoffSource) {
+		s.multiplier = multiplier
+	}
+}
+
+// WithJitter sets the fraction of every delay that is randomly cut off from it, e.g. 0.2 makes the delays vary
+// between 80% and 100% of their value, so that messages failing together aren't all redelivered at once. The
+// default value is 0.
+func WithJitter(jitter float64) AsyncMessageSourceOption {
+	return func(s *backoffSource) {
+		s.jitter = jitter
+	}
+}
+
+// WithMaxAttempts sets the number of times a message is delivered. Once a message delivered that many times is
+// nacked, consumption fails with the reason it was nacked for. The default value is 0 (unlimited).
+func WithMaxAttempts(attempts int) AsyncMessageSourceOption {
+	return func(s *backoffSource) {
+		s.maxAttempts = attempts
+	}
+}
+
+// Nack returns a negative acknowledgement for a message consumed from the backoff source, which makes it deliver
+// the message again after a delay. It should be sent on the acks channel instead of the message itself.
+func Nack(msg substrate.Message, reason error) substrate.Message {
+	return &nack{msg: msg, reason: reason}
+}
+
+// Attempts returns the number of times the message consumed from the backoff source was delivered, including
+// the current delivery, or 0 if it wasn't consumed from a backoff source.
+func Attempts(msg substrate.Message) int {
+	if bMsg, ok := msg.(*backoffMessage); ok {
+		return bMsg.attempts
+	}
+	return 0
+}
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that delivers messages nacked using
+// the Nack function again once a delay elapsed. The delay starts at the initial delay and is multiplied after
+// every failed attempt, up to the maximum delay. Messages waiting to be delivered again are held in memory, and
+// they are only acknowledged to the underlying source once they were acknowledged, in the order in which they were
+// consumed, so consumption resumes from the oldest of them after a restart.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, opts ...AsyncMessageSourceOption) substrate.AsyncMessageSource {
+	s := &backoffSource{
+		source:       ackordering.NewAsyncMessageSource(source),
+		initialDelay: defaultInitialDelay,
+		maxDelay:     defaultMaxDelay,
+		multiplier:   defaultMultiplier,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type backoffSource struct {
+	source       substrate.AsyncMessageSource
+	initialDelay time.Duration
+	maxDelay     time.Duration
+	multiplier   float64
+	jitter       float64
+	maxAttempts  int
+}
+
+func (s *backoffSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "backoff"})
+	rg, ctx := rungroup.New(ctx)
+
+	sourceMsgs := make(chan substrate.Message, cap(messages))
+	sourceAcks := make(chan substrate.Message, cap(acks))
+
+	rg.Go(func() error {
+		return s.source.ConsumeMessages(ctx, sourceMsgs, sourceAcks)
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-sourceMsgs:
+				bMsg := &backoffMessage{msg: msg, attempts: 1}
+				emit.Publish(bMsg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case messages <- bMsg:
+				}
+			}
+		}
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				switch msg := ack.(type) {
+				case *backoffMessage:
+					emit.Ack(msg)
+					select {
+					case <-ctx.Done():
+						return nil
+					case sourceAcks <- msg.msg:
+					}
+				case *nack:
+					bMsg, ok := msg.msg.(*backoffMessage)
+					if !ok {
+						return errors.Errorf("unexpected message type: %T", msg.msg)
+					}
+					if s.maxAttempts > 0 && bMsg.attempts >= s.maxAttempts {
+						if msg.reason == nil {
+							return errors.Errorf("message failed after %d attempts", bMsg.attempts)
+						}
+						return errors.Wrapf(msg.reason, "message failed after %d attempts", bMsg.attempts)
+					}
+					if msg.reason != nil {
+						emit.Error(msg.reason)
+					}
+					go s.redeliver(ctx, emit, messages, bMsg)
+				default:
+					return errors.Errorf("unexpected message type: %T", ack)
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+// redeliver delivers the message again once the delay following its last attempt elapsed.
+func (s *backoffSource) redeliver(ctx context.Context, emit events.Emitter, messages chan<- substrate.Message, msg *backoffMessage) {
+	timer := time.NewTimer(s.delay(msg.attempts))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-timer.C:
+	}
+	next := &backoffMessage{msg: msg.msg, attempts: msg.attempts + 1}
+	emit.Publish(next)
+	select {
+	case <-ctx.Done():
+	case messages <- next:
+	}
+}
+
+// delay returns the delay after the given number of failed attempts.
+func (s *backoffSource) delay(attempts int) time.Duration {
+	delay := float64(s.initialDelay) * math.Pow(s.multiplier, float64(attempts-1))
+	if delay > float64(s.maxDelay) {
+		delay = float64(s.maxDelay)
+	}
+	if s.jitter > 0 {
+		delay -= delay * s.jitter * rand.Float64()
+	}
+	return time.Duration(delay)
+}
+
+// Close closes the underlying source.
+func (s *backoffSource) Close() error {
+	return s.source.Close()
+}
+
+// Status returns the status of the underlying source.
+func (s *backoffSource) Status() (*substrate.Status, error) {
+	return s.source.Status()
+}
+
+type backoffMessage struct {
+	msg      substrate.Message
+	attempts int
+}
+
+func (msg *backoffMessage) Data() []byte {
+	return msg.msg.Data()
+}
+
+func (msg *backoffMessage) DiscardPayload() {
+	if dMsg, ok := msg.msg.(substrate.DiscardableMessage); ok {
+		dMsg.DiscardPayload()
+	}
+}
+
+func (msg *backoffMessage) Headers() map[string]string {
+	return message.Headers(msg.msg)
+}
+
+func (msg *backoffMessage) ContentType() string {
+	return message.ContentType(msg.msg)
+}
+
+func (msg *backoffMessage) CarriedEnvelope() (message.Envelope, bool) {
+	return message.EnvelopeOf(msg.msg)
+}
+
+type nack struct {
+	msg    substrate.Message
+	reason error
+}
+
+func (msg *nack) Data() []byte {
+	return msg.msg.Data()
+}
@@ -0,0 +1,140 @@
+package backoff_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/backoff"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+func receive(t *testing.T, messages <-chan substrate.Message) substrate.Message {
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(time.Second * 5):
+		require.FailNow(t, "no message delivered")
+		return nil
+	}
+}
+
+func TestSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	mockSource := mock.NewSource(message.FromString("1"), message.FromString("2"))
+	source := backoff.NewAsyncMessageSource(mockSource,
+		backoff.WithInitialDelay(time.Millisecond*50),
+		backoff.WithMultiplier(10),
+		backoff.WithMaxDelay(time.Millisecond*100),
+	)
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	first, second := receive(t, messages), receive(t, messages)
+	require.Equal(t, 1, backoff.Attempts(first))
+
+	nacked := time.Now()
+	acks <- backoff.Nack(first, errors.New("failure"))
+	acks <- second
+
+	redelivered := receive(t, messages)
+	require.GreaterOrEqual(t, time.Since(nacked), time.Millisecond*50)
+	require.Equal(t, "1", string(redelivered.Data()))
+	require.Equal(t, 2, backoff.Attempts(redelivered))
+
+	// The delay is capped by the maximum delay.
+	nacked = time.Now()
+	acks <- backoff.Nack(redelivered, errors.New("failure"))
+	redelivered = receive(t, messages)
+	require.GreaterOrEqual(t, time.Since(nacked), time.Millisecond*100)
+	require.Less(t, time.Since(nacked), time.Millisecond*400)
+	require.Equal(t, 3, backoff.Attempts(redelivered))
+
+	// The first message holds back the acknowledgement of the second one until it is acknowledged.
+	require.Empty(t, mockSource.Acked())
+	acks <- redelivered
+	select {
+	case <-mockSource.AllAcked():
+	case <-ctx.Done():
+		require.FailNow(t, "messages weren't acknowledged")
+	}
+	require.Equal(t, []string{"1", "2"}, payloads(mockSource.Acked()))
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func payloads(msgs []substrate.Message) []string {
+	var payloads []string
+	for _, msg := range msgs {
+		payloads = append(payloads, string(msg.Data()))
+	}
+	return payloads
+}
+
+func TestSource_MaxAttempts(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source := backoff.NewAsyncMessageSource(mock.NewSource(message.FromString("1")),
+		backoff.WithInitialDelay(time.Millisecond),
+		backoff.WithMaxAttempts(2),
+	)
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	errFailure := errors.New("failure")
+	acks <- backoff.Nack(receive(t, messages), errFailure)
+	acks <- backoff.Nack(receive(t, messages), errFailure)
+
+	err := <-errs
+	require.EqualError(t, err, "message failed after 2 attempts: failure")
+	require.Equal(t, errFailure, errors.Cause(err))
+}
+
+func TestSource_Metadata(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source := backoff.NewAsyncMessageSource(mock.NewSource(message.NewEnvelopedMessage(message.Envelope{
+		ContentType: "text/plain",
+		Headers:     map[string]string{"key": "value"},
+		Payload:     []byte("1"),
+	})))
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	// Delivered messages report the headers and content type of the messages of the source.
+	msg := receive(t, messages)
+	require.Equal(t, map[string]string{"key": "value"}, message.Headers(msg))
+	require.Equal(t, "text/plain", message.ContentType(msg))
+	acks <- msg
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestSource_UnexpectedAck(t *testing.T) {
+	source := backoff.NewAsyncMessageSource(mock.NewSource())
+	acks := make(chan substrate.Message, 1)
+	acks <- message.FromString("1")
+
+	err := source.ConsumeMessages(context.Background(), make(chan substrate.Message), acks)
+	require.EqualError(t, err, "unexpected message type: *message.Message")
+}
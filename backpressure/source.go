@@ -0,0 +1,164 @@
+// Package backpressure provides a message source wrapper limiting the total size of the messages that were delivered
+// but not acknowledged yet, preventing consumers of topics with large messages from running out of memory.
+package backpressure
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// AsyncMessageSourceOption is a function which sets an AsyncMessageSource configuration option.
+type AsyncMessageSourceOption func(s *budgetSource)
+
+// WithInFlightBytesGauge sets a gauge that is updated with the total size, in bytes, of the messages that were
+// delivered but not acknowledged yet. It panics in case it can't register the metric.
+func WithInFlightBytesGauge(gaugeOpts prometheus.GaugeOpts) AsyncMessageSourceOption {
+	return func(s *budgetSource) {
+		s.gauge = metrics.Register(prometheus.NewGauge(gaugeOpts)).(prometheus.Gauge)
+	}
+}
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that stops pulling messages from the
+// provided source while the total size of the payloads of the messages that were delivered but not acknowledged yet
+// reaches the budget, in bytes, and resumes once acknowledgements free enough space. As the size of a message is
+// only known once it is pulled, the budget can be exceeded by a single message, so that messages larger than the
+// budget are still delivered. Messages the underlying source prefetches aren't accounted for. Messages must be
+// acknowledged in order.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, budget int, opts ...AsyncMessageSourceOption) substrate.AsyncMessageSource {
+	s := &budgetSource{
+		impl:   source,
+		budget: budget,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// budgetSource implements substrate.AsyncMessageSource limiting the size of in-flight messages.
+type budgetSource struct {
+	impl   substrate.AsyncMessageSource
+	budget int
+	gauge  prometheus.Gauge
+}
+
+// inFlight is a delivered message along with its size, which is recorded on delivery as the payload may be
+// discarded before it is acknowledged.
+type inFlight struct {
+	msg  substrate.Message
+	size int
+}
+
+// ConsumeMessages consumes messages from the underlying source as long as the budget allows it, and passes on
+// the acknowledgements.
+func (s *budgetSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "backpressure"})
+	rg, ctx := rungroup.New(ctx)
+
+	consumed, toAck := make(chan substrate.Message), make(chan substrate.Message)
+
+	var (
+		mutex   sync.Mutex
+		pending []inFlight
+		size    int
+	)
+	freed := make(chan struct{}, 1)
+	// available reports whether the in-flight messages leave room in the budget.
+	available := func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return size < s.budget
+	}
+	update := func() {
+		if s.gauge != nil {
+			s.gauge.Set(float64(size))
+		}
+	}
+
+	rg.Go(func() error {
+		return s.impl.ConsumeMessages(ctx, consumed, toAck)
+	})
+	rg.Go(func() error {
+		for {
+			for !available() {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-freed:
+				}
+			}
+
+			var msg substrate.Message
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg = <-consumed:
+			}
+
+			mutex.Lock()
+			pending = append(pending, inFlight{msg: msg, size: len(msg.Data())})
+			size += len(msg.Data())
+			update()
+			mutex.Unlock()
+
+			emit.Publish(msg)
+			select {
+			case <-ctx.Done():
+				return nil
+			case messages <- msg:
+			}
+		}
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				mutex.Lock()
+				if len(pending) == 0 || pending[0].msg != ack {
+					var expected substrate.Message
+					if len(pending) > 0 {
+						expected = pending[0].msg
+					}
+					mutex.Unlock()
+					return substrate.InvalidAckError{Acked: ack, Expected: expected}
+				}
+				size -= pending[0].size
+				pending = pending[1:]
+				update()
+				mutex.Unlock()
+
+				select {
+				case freed <- struct{}{}:
+				default:
+				}
+				emit.Ack(ack)
+				select {
+				case <-ctx.Done():
+					return nil
+				case toAck <- ack:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+// Close closes the underlying source.
+func (s *budgetSource) Close() error {
+	return s.impl.Close()
+}
+
+// Status returns the status of the underlying source.
+func (s *budgetSource) Status() (*substrate.Status, error) {
+	return s.impl.Status()
+}
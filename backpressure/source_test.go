@@ -0,0 +1,82 @@
+package backpressure_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/backpressure"
+	"github.com/uw-labs/substrate-tools/conformance"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+func TestSource_Budget(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	gaugeOpts := prometheus.GaugeOpts{Name: "backpressure_test_in_flight_bytes", Help: "backpressure_test_in_flight_bytes"}
+	backend := mock.NewSource(message.FromString("first"), message.FromString("second"), message.FromString("third"))
+	source := backpressure.NewAsyncMessageSource(backend, 10, backpressure.WithInFlightBytesGauge(gaugeOpts))
+	gauge := prometheus.Register(prometheus.NewGauge(gaugeOpts)).(prometheus.AlreadyRegisteredError).ExistingCollector.(prometheus.Gauge)
+
+	msgs, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, msgs, acks)
+	}()
+
+	// The second message exceeds the budget, so nothing else is pulled until space is freed.
+	first, second := <-msgs, <-msgs
+	require.Equal(t, "second", string(second.Data()))
+	require.Equal(t, float64(11), testutil.ToFloat64(gauge))
+	select {
+	case msg := <-msgs:
+		require.FailNow(t, "budget exceeded", "%s delivered", msg.Data())
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	acks <- first
+	third := <-msgs
+	require.Equal(t, "third", string(third.Data()))
+	require.Equal(t, float64(11), testutil.ToFloat64(gauge))
+
+	acks <- second
+	acks <- third
+	select {
+	case <-backend.AllAcked():
+	case err := <-errs:
+		require.FailNow(t, "consuming failed", "%v", err)
+	}
+	require.Equal(t, float64(0), testutil.ToFloat64(gauge))
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestSource_InvalidAck(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source := backpressure.NewAsyncMessageSource(mock.NewSource(message.FromString("message-0")), 10)
+	msgs, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, msgs, acks)
+	}()
+
+	<-msgs
+	acks <- message.FromString("message-0")
+	require.IsType(t, substrate.InvalidAckError{}, <-errs)
+}
+
+func TestSource_Conformance(t *testing.T) {
+	conformance.TestAsyncSource(t, func(_ *testing.T, backend substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		return backpressure.NewAsyncMessageSource(backend, 1)
+	})
+}
@@ -0,0 +1,160 @@
+// Package batch provides a message source adapter that delivers messages to the consumer in batches.
+package batch
+
+import (
+	"context"
+	"time"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+const (
+	defaultMaxSize = 100
+	defaultMaxWait = time.Second
+)
+
+// MessageSourceOption is a function which sets a MessageSource configuration option.
+type MessageSourceOption func(msa *messageSourceAdapter)
+
+// WithMaxSize sets the maximum number of messages in a batch. The default value is 100.
+func WithMaxSize(size uint) MessageSourceOption {
+	return func(msa *messageSourceAdapter) {
+		msa.maxSize = size
+	}
+}
+
+// WithMaxWait sets the maximum time to wait for a batch to fill up after its first message was received.
+// Once the time elapses, the batch is delivered with the messages received so far. The default value is 1 second.
+func WithMaxWait(wait time.Duration) MessageSourceOption {
+	return func(msa *messageSourceAdapter) {
+		msa.maxWait = wait
+	}
+}
+
+// WithBufferGauges reports the number of messages in the batch being filled or handled, along with the maximum size
+// as the capacity, with the shared buffer gauges, labelled with the wrapper, which is "batch", and the provided name.
+// It panics in case it can't register the metrics.
+func WithBufferGauges(name string) MessageSourceOption {
+	return func(msa *messageSourceAdapter) {
+		msa.bufferName = &name
+	}
+}
+
+// ConsumerBatchHandler is the callback function type that batch message consumers must implement.
+// All messages in the batch are acknowledged once the handler returns successfully.
+type ConsumerBatchHandler func(ctx context.Context, batch []substrate.Message) error
+
+// MessageSource represents a message source that delivers messages in batches and relieves the consumer
+// from having to deal with acknowledgements.
+type MessageSource interface {
+	// Close closed the MessageSource, freeing underlying resources.
+	Close() error
+	// ConsumeMessages calls the handler function for each batch of messages available to consume. The messages
+	// in a batch are acknowledged in order once the handler returns for it. If an error is returned by the handler,
+	// it will be propagated and returned from this function. This function will block until the context is done
+	// or until an error occurs.
+	ConsumeMessages(ctx context.Context, handler ConsumerBatchHandler) error
+	substrate.Statuser
+}
+
+// NewMessageSource returns a new batching message source, given an AsyncMessageSource. When Close is called
+// on the MessageSource, this is also propagated to the underlying AsyncMessageSource.
+func NewMessageSource(source substrate.AsyncMessageSource, opts ...MessageSourceOption) MessageSource {
+	msa := &messageSourceAdapter{
+		source:  source,
+		maxSize: defaultMaxSize,
+		maxWait: defaultMaxWait,
+	}
+
+	for _, opt := range opts {
+		opt(msa)
+	}
+	if msa.bufferName != nil {
+		msa.buffer = metrics.NewBuffer("batch", *msa.bufferName, "", float64(msa.maxSize))
+	}
+
+	return msa
+}
+
+type messageSourceAdapter struct {
+	source  substrate.AsyncMessageSource
+	maxSize uint
+	maxWait time.Duration
+
+	bufferName *string
+	buffer     *metrics.Buffer
+}
+
+func (a *messageSourceAdapter) ConsumeMessages(ctx context.Context, handler ConsumerBatchHandler) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "batch"})
+	rg, ctx := rungroup.New(ctx)
+
+	messages := make(chan substrate.Message, a.maxSize)
+	acks := make(chan substrate.Message, a.maxSize)
+
+	rg.Go(func() error {
+		return a.source.ConsumeMessages(ctx, messages, acks)
+	})
+	rg.Go(func() error {
+		batch := make([]substrate.Message, 0, a.maxSize)
+		timer := time.NewTimer(a.maxWait)
+		timer.Stop()
+		a.buffer.Set(0)
+
+		for {
+			flush := false
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-messages:
+				if len(batch) == 0 {
+					timer.Reset(a.maxWait)
+				}
+				batch = append(batch, msg)
+				a.buffer.Set(float64(len(batch)))
+				flush = uint(len(batch)) >= a.maxSize
+			case <-timer.C:
+				flush = len(batch) > 0
+			}
+			if !flush {
+				continue
+			}
+
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			for _, msg := range batch {
+				emit.Publish(msg)
+			}
+			if err := handler(ctx, batch); err != nil {
+				return emit.Error(err)
+			}
+			for _, msg := range batch {
+				emit.Ack(msg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case acks <- msg:
+				}
+			}
+			batch = make([]substrate.Message, 0, a.maxSize)
+			a.buffer.Set(0)
+		}
+	})
+
+	return rg.Wait()
+}
+
+func (a *messageSourceAdapter) Close() error {
+	return a.source.Close()
+}
+
+func (a *messageSourceAdapter) Status() (*substrate.Status, error) {
+	return a.source.Status()
+}
@@ -0,0 +1,105 @@
+package batch_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/batch"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+func TestBatchMessageSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			message.FromString("1"),
+			message.FromString("2"),
+			message.FromString("3"),
+			message.FromString("4"),
+			message.FromString("5"),
+		},
+	}
+	source := batch.NewMessageSource(mockSource, batch.WithMaxSize(2), batch.WithMaxWait(time.Millisecond*50))
+
+	var batches [][]string
+	err := source.ConsumeMessages(ctx, func(_ context.Context, msgs []substrate.Message) error {
+		var payloads []string
+		for _, msg := range msgs {
+			payloads = append(payloads, string(msg.Data()))
+		}
+		batches = append(batches, payloads)
+		if len(batches) == 3 {
+			go func() {
+				time.Sleep(time.Millisecond * 100)
+				source.Close()
+			}()
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"1", "2"}, {"3", "4"}, {"5"}}, batches)
+}
+
+func TestBatchMessageSource_HandlerError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			message.FromString("1"),
+		},
+	}
+	source := batch.NewMessageSource(mockSource, batch.WithMaxWait(time.Millisecond*10))
+	handlerErr := errors.New("handler error")
+
+	err := source.ConsumeMessages(ctx, func(_ context.Context, msgs []substrate.Message) error {
+		return handlerErr
+	})
+	require.Equal(t, handlerErr, err)
+}
+
+func TestBatchMessageSource_BufferGauges(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			message.FromString("1"),
+			message.FromString("2"),
+			message.FromString("3"),
+		},
+	}
+	source := batch.NewMessageSource(mockSource, batch.WithBufferGauges("orders"), batch.WithMaxSize(2),
+		batch.WithMaxWait(time.Millisecond*10))
+	errDone := errors.New("done")
+
+	err := source.ConsumeMessages(ctx, func(_ context.Context, msgs []substrate.Message) error {
+		expected := fmt.Sprintf(`
+# HELP substrate_tools_buffer_capacity Capacity of the buffer of a wrapper, in the same unit as the used gauge, or 0 if it is unbounded.
+# TYPE substrate_tools_buffer_capacity gauge
+substrate_tools_buffer_capacity{buffer="",name="orders",wrapper="batch"} 2
+# HELP substrate_tools_buffer_used Number of messages, or bytes for buffers on disk, held in the buffer of a wrapper.
+# TYPE substrate_tools_buffer_used gauge
+substrate_tools_buffer_used{buffer="",name="orders",wrapper="batch"} %d
+`, len(msgs))
+		require.NoError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected),
+			"substrate_tools_buffer_used", "substrate_tools_buffer_capacity"))
+		if len(msgs) == 1 {
+			return errDone
+		}
+		return nil
+	})
+	require.Equal(t, errDone, err)
+}
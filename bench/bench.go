@@ -0,0 +1,288 @@
+// Package bench provides a way of benchmarking substrate backends, by publishing messages to a sink and
+// consuming them from a source, measuring throughput and latencies along the way.
+package bench
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/sync/rungroup"
+
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+// headerSize is the size of the run identifier, sequence number and timestamp at the start of every payload.
+// Payloads are never smaller than this.
+const headerSize = 24
+
+// Config configures a benchmark run.
+type Config struct {
+	// Sizes is the distribution of payload sizes, 1KiB payloads are published if it is nil.
+	Sizes SizeDistribution
+	// Concurrency is the maximum number of messages that are published but not yet acknowledged by the sink.
+	// It defaults to 1.
+	Concurrency int
+	// Duration is for how long messages are published. It is unlimited if 0, in which case Messages must be set.
+	Duration time.Duration
+	// Messages is the number of messages to publish. It is unlimited if 0, in which case Duration must be set.
+	Messages int
+	// DrainTimeout is for how long to wait for all published messages to be consumed once publishing stopped.
+	// It defaults to 10 seconds.
+	DrainTimeout time.Duration
+	// Seed seeds the random number generator used for payload sizes.
+	Seed int64
+	// Registerer is used to register latency histograms and message counters, if it is set.
+	Registerer prometheus.Registerer
+}
+
+// Run publishes messages to the sink and consumes them from the source, until the configured duration elapsed
+// or number of messages were published and all of them were consumed, or the drain timeout elapsed. The source
+// should consume messages published to the sink, messages that weren't published by this run are ignored.
+// It returns an error if the configuration is invalid or if either the sink or the source fails.
+func Run(ctx context.Context, sink substrate.AsyncMessageSink, source substrate.AsyncMessageSource, cfg Config) (*Report, error) {
+	if cfg.Duration <= 0 && cfg.Messages <= 0 {
+		return nil, errors.New("either the duration or the number of messages must be set")
+	}
+	if cfg.Sizes == nil {
+		cfg.Sizes = FixedSize(1024)
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.DrainTimeout <= 0 {
+		cfg.DrainTimeout = time.Second * 10
+	}
+	m, err := newMetrics(cfg.Registerer)
+	if err != nil {
+		return nil, err
+	}
+
+	var runID [8]byte
+	if _, err := rand.Read(runID[:]); err != nil {
+		return nil, errors.Wrap(err, "failed to generate run identifier")
+	}
+
+	r := &run{
+		cfg:     cfg,
+		metrics: m,
+		runID:   runID,
+		rand:    mrand.New(mrand.NewSource(cfg.Seed)),
+		seen:    make(map[uint64]struct{}),
+	}
+	if err := r.execute(ctx, sink, source); err != nil {
+		return nil, err
+	}
+	return r.report(), nil
+}
+
+// run holds the state of a single benchmark run.
+type run struct {
+	cfg     Config
+	metrics *metrics
+	runID   [8]byte
+	rand    *mrand.Rand
+
+	start    time.Time
+	end      time.Time
+	bytes    int64
+	inFlight []time.Time
+
+	mutex           sync.Mutex
+	published       int
+	acknowledged    int
+	seen            map[uint64]struct{}
+	duplicates      int
+	ackLatency      []time.Duration
+	endToEndLatency []time.Duration
+}
+
+func (r *run) execute(ctx context.Context, sink substrate.AsyncMessageSink, source substrate.AsyncMessageSource) error {
+	rg, ctx := rungroup.New(ctx)
+
+	toPublish, published := make(chan substrate.Message, r.cfg.Concurrency), make(chan substrate.Message, r.cfg.Concurrency)
+	consumed, toAck := make(chan substrate.Message, r.cfg.Concurrency), make(chan substrate.Message, r.cfg.Concurrency)
+	window := make(chan struct{}, r.cfg.Concurrency)
+	publishDone := make(chan struct{})
+	acked := make(chan struct{}, 1)
+
+	rg.Go(func() error {
+		if err := sink.PublishMessages(ctx, published, toPublish); err != nil {
+			return errors.Wrap(err, "sink failed")
+		}
+		return nil
+	})
+	rg.Go(func() error {
+		if err := source.ConsumeMessages(ctx, consumed, toAck); err != nil {
+			return errors.Wrap(err, "source failed")
+		}
+		return nil
+	})
+
+	// Publish messages.
+	rg.Go(func() error {
+		r.start = time.Now()
+		var deadline <-chan time.Time
+		if r.cfg.Duration > 0 {
+			timer := time.NewTimer(r.cfg.Duration)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+
+	publish:
+		for seq := 0; r.cfg.Messages == 0 || seq < r.cfg.Messages; seq++ {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-deadline:
+				break publish
+			case window <- struct{}{}:
+			}
+
+			payload := r.payload(uint64(seq))
+			r.mutex.Lock()
+			r.inFlight = append(r.inFlight, time.Now())
+			r.published++
+			r.mutex.Unlock()
+			r.bytes += int64(len(payload))
+			r.metrics.published.Inc()
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case toPublish <- message.NewMessage(payload):
+			}
+		}
+		close(publishDone)
+
+		timer := time.NewTimer(r.cfg.DrainTimeout)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+		return nil
+	})
+
+	// Record acknowledgements of the sink.
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-published:
+				r.mutex.Lock()
+				if len(r.inFlight) == 0 {
+					r.mutex.Unlock()
+					return errors.New("sink acknowledged a message that wasn't published")
+				}
+				latency := time.Since(r.inFlight[0])
+				r.inFlight = r.inFlight[1:]
+				r.acknowledged++
+				r.ackLatency = append(r.ackLatency, latency)
+				r.mutex.Unlock()
+				r.metrics.ackLatency.Observe(latency.Seconds())
+
+				<-window
+				select {
+				case acked <- struct{}{}:
+				default:
+				}
+			}
+		}
+	})
+
+	// Consume messages, stopping once all published messages were acknowledged and consumed.
+	rg.Go(func() error {
+		done := publishDone
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-done:
+				done = nil
+			case <-acked:
+			case msg := <-consumed:
+				r.consume(msg.Data())
+				select {
+				case <-ctx.Done():
+					return nil
+				case toAck <- msg:
+				}
+			}
+			if done == nil && r.complete() {
+				return nil
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+// payload returns a payload of a random size that starts with the run identifier, the sequence number
+// and the current time.
+func (r *run) payload(seq uint64) []byte {
+	size := r.cfg.Sizes(r.rand)
+	if size < headerSize {
+		size = headerSize
+	}
+	payload := make([]byte, size)
+	copy(payload, r.runID[:])
+	binary.BigEndian.PutUint64(payload[8:], seq)
+	binary.BigEndian.PutUint64(payload[16:], uint64(time.Now().UnixNano()))
+	return payload
+}
+
+// consume records the end-to-end latency of a consumed message, ignoring messages published by other runs.
+func (r *run) consume(payload []byte) {
+	if len(payload) < headerSize || string(payload[:8]) != string(r.runID[:]) {
+		return
+	}
+	seq := binary.BigEndian.Uint64(payload[8:])
+	latency := time.Since(time.Unix(0, int64(binary.BigEndian.Uint64(payload[16:]))))
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.seen[seq]; ok {
+		r.duplicates++
+		return
+	}
+	r.seen[seq] = struct{}{}
+	r.end = time.Now()
+	r.endToEndLatency = append(r.endToEndLatency, latency)
+	r.metrics.consumed.Inc()
+	r.metrics.endToEndLatency.Observe(latency.Seconds())
+}
+
+// complete reports whether all published messages were acknowledged and consumed.
+func (r *run) complete() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.acknowledged == r.published && len(r.seen) == r.published
+}
+
+func (r *run) report() *Report {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	report := &Report{
+		Published:       r.published,
+		Acknowledged:    r.acknowledged,
+		Consumed:        len(r.seen),
+		Duplicates:      r.duplicates,
+		Bytes:           r.bytes,
+		AckLatency:      percentiles(r.ackLatency),
+		EndToEndLatency: percentiles(r.endToEndLatency),
+	}
+	if !r.end.IsZero() {
+		report.Duration = r.end.Sub(r.start)
+	}
+	return report
+}
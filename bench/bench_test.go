@@ -0,0 +1,110 @@
+package bench_test
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/bench"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+func TestRun_Messages(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	// Messages that weren't published by the run are ignored.
+	acks, messages := make(chan substrate.Message, 1), make(chan substrate.Message, 1)
+	go broker.NewAsyncMessageSink("topic").PublishMessages(ctx, acks, messages)
+	messages <- message.FromString("unrelated")
+	<-acks
+
+	registry := prometheus.NewRegistry()
+	report, err := bench.Run(ctx, broker.NewAsyncMessageSink("topic"), broker.NewAsyncMessageSource("topic", "bench"), bench.Config{
+		Sizes:       bench.UniformSize(10, 100),
+		Concurrency: 10,
+		Messages:    100,
+		Registerer:  registry,
+	})
+	require.NoError(t, err)
+	require.NoError(t, ctx.Err())
+
+	require.Equal(t, 100, report.Published)
+	require.Equal(t, 100, report.Acknowledged)
+	require.Equal(t, 100, report.Consumed)
+	require.Zero(t, report.Duplicates)
+	require.True(t, report.Bytes >= 24*100 && report.Bytes <= 100*100)
+	require.True(t, report.Duration > 0)
+	require.True(t, report.Throughput() > 0)
+	require.True(t, report.EndToEndLatency.Max >= report.EndToEndLatency.P50)
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 2)
+	for _, family := range families {
+		if family.GetName() == "substrate_bench_messages_total" {
+			for _, metric := range family.GetMetric() {
+				require.Equal(t, 100.0, metric.GetCounter().GetValue())
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, report.Write(&out))
+	require.Contains(t, out.String(), "consumed:     100 messages (0 duplicates)")
+}
+
+func TestRun_Duration(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	report, err := bench.Run(ctx, broker.NewAsyncMessageSink("topic"), broker.NewAsyncMessageSource("topic", "bench"), bench.Config{
+		Duration: time.Millisecond * 50,
+	})
+	require.NoError(t, err)
+	require.True(t, report.Published > 0)
+	require.Equal(t, report.Published, report.Consumed)
+}
+
+func TestRun_InvalidConfig(t *testing.T) {
+	broker := mem.NewBroker()
+	_, err := bench.Run(context.Background(), broker.NewAsyncMessageSink("topic"), broker.NewAsyncMessageSource("topic", "bench"), bench.Config{})
+	require.Error(t, err)
+}
+
+func TestParseSizeDistribution(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	sizes, err := bench.ParseSizeDistribution("512")
+	require.NoError(t, err)
+	require.Equal(t, 512, sizes(r))
+
+	sizes, err = bench.ParseSizeDistribution("fixed:64")
+	require.NoError(t, err)
+	require.Equal(t, 64, sizes(r))
+
+	sizes, err = bench.ParseSizeDistribution("uniform:10-20")
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		size := sizes(r)
+		require.True(t, size >= 10 && size <= 20)
+	}
+
+	sizes, err = bench.ParseSizeDistribution("normal:100,0")
+	require.NoError(t, err)
+	require.Equal(t, 100, sizes(r))
+
+	for _, invalid := range []string{"fixed:x", "uniform:20-10", "normal:1", "poisson:1"} {
+		_, err := bench.ParseSizeDistribution(invalid)
+		require.Error(t, err, invalid)
+	}
+}
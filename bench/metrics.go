@@ -0,0 +1,40 @@
+package bench
+
+import (
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics are the prometheus metrics recorded during a run, they are only registered if a registerer is provided.
+type metrics struct {
+	published       prometheus.Counter
+	consumed        prometheus.Counter
+	ackLatency      prometheus.Histogram
+	endToEndLatency prometheus.Histogram
+}
+
+func newMetrics(registerer prometheus.Registerer) (*metrics, error) {
+	latency := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "substrate_bench_latency_seconds",
+		Help:    "Latency of messages published by the benchmark, by kind of latency",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 16),
+	}, []string{"kind"})
+	messages := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "substrate_bench_messages_total",
+		Help: "Total count of messages published and consumed by the benchmark",
+	}, []string{"operation"})
+
+	if registerer != nil {
+		for _, collector := range []prometheus.Collector{latency, messages} {
+			if err := registerer.Register(collector); err != nil {
+				return nil, errors.Wrap(err, "failed to register metrics")
+			}
+		}
+	}
+	return &metrics{
+		published:       messages.WithLabelValues("published"),
+		consumed:        messages.WithLabelValues("consumed"),
+		ackLatency:      latency.WithLabelValues("ack").(prometheus.Histogram),
+		endToEndLatency: latency.WithLabelValues("end_to_end").(prometheus.Histogram),
+	}, nil
+}
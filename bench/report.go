@@ -0,0 +1,81 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Percentiles summarises a set of latencies.
+type Percentiles struct {
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+	Max time.Duration
+}
+
+func percentiles(latencies []time.Duration) Percentiles {
+	if len(latencies) == 0 {
+		return Percentiles{}
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	at := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+	return Percentiles{
+		P50: at(0.5),
+		P90: at(0.9),
+		P99: at(0.99),
+		Max: sorted[len(sorted)-1],
+	}
+}
+
+func (p Percentiles) String() string {
+	return fmt.Sprintf("p50=%s p90=%s p99=%s max=%s", p.P50, p.P90, p.P99, p.Max)
+}
+
+// Report is the result of a benchmark run.
+type Report struct {
+	// Published is the number of messages published to the sink.
+	Published int
+	// Acknowledged is the number of messages acknowledged by the sink.
+	Acknowledged int
+	// Consumed is the number of distinct messages consumed from the source.
+	Consumed int
+	// Duplicates is the number of messages consumed more than once.
+	Duplicates int
+	// Bytes is the total size of the payloads of all published messages.
+	Bytes int64
+	// Duration is the time between the first message being published and the last one being consumed.
+	Duration time.Duration
+	// AckLatency is the time between a message being published and the sink acknowledging it.
+	AckLatency Percentiles
+	// EndToEndLatency is the time between a message being published and it being consumed from the source.
+	EndToEndLatency Percentiles
+}
+
+// Throughput returns the number of messages consumed per second.
+func (r *Report) Throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Consumed) / r.Duration.Seconds()
+}
+
+// Write writes a human readable summary of the report.
+func (r *Report) Write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "published:    %d messages (%d bytes)\n"+
+		"acknowledged: %d messages\n"+
+		"consumed:     %d messages (%d duplicates)\n"+
+		"duration:     %s\n"+
+		"throughput:   %.1f messages/s\n"+
+		"ack latency:  %s\n"+
+		"end-to-end:   %s\n",
+		r.Published, r.Bytes, r.Acknowledged, r.Consumed, r.Duplicates,
+		r.Duration.Round(time.Millisecond), r.Throughput(), r.AckLatency, r.EndToEndLatency,
+	)
+	return err
+}
@@ -0,0 +1,76 @@
+package bench
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SizeDistribution returns the size of the next message payload in bytes.
+type SizeDistribution func(r *rand.Rand) int
+
+// FixedSize returns a distribution of payloads of the given size.
+func FixedSize(size int) SizeDistribution {
+	return func(*rand.Rand) int {
+		return size
+	}
+}
+
+// UniformSize returns a distribution of payload sizes uniformly distributed between min and max, inclusive.
+func UniformSize(min, max int) SizeDistribution {
+	return func(r *rand.Rand) int {
+		return min + r.Intn(max-min+1)
+	}
+}
+
+// NormalSize returns a distribution of normally distributed payload sizes. Negative sizes are treated as 0.
+func NormalSize(mean, stddev float64) SizeDistribution {
+	return func(r *rand.Rand) int {
+		return int(math.Max(0, math.Round(r.NormFloat64()*stddev+mean)))
+	}
+}
+
+// ParseSizeDistribution parses a size distribution in one of the formats "fixed:<size>",
+// "uniform:<min>-<max>" or "normal:<mean>,<stddev>". A plain number is treated as a fixed size.
+func ParseSizeDistribution(s string) (SizeDistribution, error) {
+	kind, params := "fixed", s
+	if i := strings.Index(s, ":"); i >= 0 {
+		kind, params = s[:i], s[i+1:]
+	}
+
+	switch kind {
+	case "fixed":
+		size, err := strconv.Atoi(params)
+		if err != nil || size < 0 {
+			return nil, errors.Errorf("invalid fixed size %q", params)
+		}
+		return FixedSize(size), nil
+	case "uniform":
+		bounds := strings.SplitN(params, "-", 2)
+		if len(bounds) != 2 {
+			return nil, errors.Errorf("invalid uniform size range %q", params)
+		}
+		min, minErr := strconv.Atoi(bounds[0])
+		max, maxErr := strconv.Atoi(bounds[1])
+		if minErr != nil || maxErr != nil || min < 0 || max < min {
+			return nil, errors.Errorf("invalid uniform size range %q", params)
+		}
+		return UniformSize(min, max), nil
+	case "normal":
+		values := strings.SplitN(params, ",", 2)
+		if len(values) != 2 {
+			return nil, errors.Errorf("invalid normal size parameters %q", params)
+		}
+		mean, meanErr := strconv.ParseFloat(values[0], 64)
+		stddev, stddevErr := strconv.ParseFloat(values[1], 64)
+		if meanErr != nil || stddevErr != nil || stddev < 0 {
+			return nil, errors.Errorf("invalid normal size parameters %q", params)
+		}
+		return NormalSize(mean, stddev), nil
+	default:
+		return nil, errors.Errorf("unknown size distribution %q", kind)
+	}
+}
@@ -0,0 +1,128 @@
+// Package bridge provides a way of continuously copying messages from a substrate source to a substrate sink.
+package bridge
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// TransformFunc transforms a message consumed from the source into the message that is published to the sink.
+type TransformFunc func(msg substrate.Message) (substrate.Message, error)
+
+// Options configure how messages are copied by Run.
+type Options struct {
+	// Transform is called for every consumed message, the returned message is published instead of it.
+	// An error returned by it stops the bridge. Messages are published unchanged if it is nil.
+	Transform TransformFunc
+	// MaxInFlight limits the number of messages that are being published at the same time, i.e. the number
+	// of messages that were consumed but haven't been acknowledged yet. The number is unlimited if it is 0.
+	MaxInFlight int
+	// Copied is incremented for every message acknowledged by the sink and then by the source, if it is set.
+	Copied prometheus.Counter
+}
+
+// Run consumes messages from the source and publishes them to the sink until the context is cancelled or
+// either of them fails. It provides at-least-once semantics, messages are only acknowledged to the source
+// once the sink acknowledged them. It returns nil if it is stopped by cancelling the context.
+func Run(ctx context.Context, source substrate.AsyncMessageSource, sink substrate.AsyncMessageSink, opts Options) error {
+	rg, ctx := rungroup.New(ctx)
+
+	consumed, sourceAcks := make(chan substrate.Message), make(chan substrate.Message)
+	toPublish, sinkAcks := make(chan substrate.Message), make(chan substrate.Message)
+
+	var inFlight chan struct{}
+	if opts.MaxInFlight > 0 {
+		inFlight = make(chan struct{}, opts.MaxInFlight)
+	}
+	var (
+		mutex   sync.Mutex
+		pending []substrate.Message
+	)
+
+	rg.Go(func() error {
+		if err := source.ConsumeMessages(ctx, consumed, sourceAcks); err != nil {
+			return errors.Wrap(err, "source failed")
+		}
+		return nil
+	})
+	rg.Go(func() error {
+		if err := sink.PublishMessages(ctx, sinkAcks, toPublish); err != nil {
+			return errors.Wrap(err, "sink failed")
+		}
+		return nil
+	})
+
+	// Transform consumed messages and publish them.
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-consumed:
+				out := msg
+				if opts.Transform != nil {
+					var err error
+					if out, err = opts.Transform(msg); err != nil {
+						return errors.Wrap(err, "failed to transform message")
+					}
+				}
+				if inFlight != nil {
+					select {
+					case <-ctx.Done():
+						return nil
+					case inFlight <- struct{}{}:
+					}
+				}
+
+				mutex.Lock()
+				pending = append(pending, msg)
+				mutex.Unlock()
+
+				select {
+				case <-ctx.Done():
+					return nil
+				case toPublish <- out:
+				}
+			}
+		}
+	})
+
+	// Acknowledge published messages to the source.
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-sinkAcks:
+				// Sinks acknowledge messages in order, so the acknowledgement is for the oldest pending message.
+				mutex.Lock()
+				if len(pending) == 0 {
+					mutex.Unlock()
+					return errors.New("sink acknowledged a message that wasn't published")
+				}
+				msg := pending[0]
+				pending = pending[1:]
+				mutex.Unlock()
+
+				select {
+				case <-ctx.Done():
+					return nil
+				case sourceAcks <- msg:
+				}
+				if inFlight != nil {
+					<-inFlight
+				}
+				if opts.Copied != nil {
+					opts.Copied.Inc()
+				}
+			}
+		}
+	})
+
+	return rg.Wait()
+}
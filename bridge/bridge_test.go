@@ -0,0 +1,74 @@
+package bridge_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/bridge"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+func TestRun(t *testing.T) {
+	for _, maxInFlight := range []int{0, 1} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+
+		source := &mock.AsyncMessageSource{
+			Messages: []substrate.Message{
+				message.FromString("one"),
+				message.FromString("two"),
+				message.FromString("three"),
+			},
+		}
+		broker := mem.NewBroker()
+		copied := prometheus.NewCounter(prometheus.CounterOpts{Name: "copied"})
+
+		errs := make(chan error)
+		go func() {
+			defer close(errs)
+			errs <- bridge.Run(ctx, source, broker.NewAsyncMessageSink("out"), bridge.Options{
+				Transform: func(msg substrate.Message) (substrate.Message, error) {
+					return message.NewMessage(bytes.ToUpper(msg.Data())), nil
+				},
+				MaxInFlight: maxInFlight,
+				Copied:      copied,
+			})
+		}()
+
+		// The mock source fails in case the messages are not acknowledged in order.
+		require.Eventually(t, func() bool {
+			return testutil.ToFloat64(copied) == 3
+		}, time.Second*5, time.Millisecond*10)
+		require.Equal(t, [][]byte{[]byte("ONE"), []byte("TWO"), []byte("THREE")}, broker.Messages("out"))
+
+		cancel()
+		require.NoError(t, <-errs)
+	}
+}
+
+func TestRun_TransformError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	transformErr := errors.New("transform error")
+	source := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{message.FromString("one")},
+	}
+	err := bridge.Run(ctx, source, mem.NewBroker().NewAsyncMessageSink("out"), bridge.Options{
+		Transform: func(substrate.Message) (substrate.Message, error) {
+			return nil, transformErr
+		},
+	})
+	require.Error(t, err)
+	require.Equal(t, transformErr, errors.Cause(err))
+	require.NoError(t, ctx.Err())
+}
@@ -0,0 +1,138 @@
+// Package chaos provides wrappers for substrate sinks and sources that inject faults, making it possible to test
+// how consumers and producers handle errors, slow or lost acknowledgements and redeliveries. All faults are drawn
+// from a seedable random number generator, so that a given sequence of messages always experiences the same faults.
+package chaos
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInjectedFault is the error returned by a sink when it injects a publishing error.
+	ErrInjectedFault = errors.New("chaos: injected fault")
+	// ErrInjectedDisconnect is the error returned by a sink or a source when it injects a disconnect.
+	ErrInjectedDisconnect = errors.New("chaos: injected disconnect")
+)
+
+// Option is a function which sets a chaos configuration option.
+type Option func(c *config)
+
+// WithSeed sets the seed of the random number generator used to draw faults. By default the current time is used.
+func WithSeed(seed int64) Option {
+	return func(c *config) {
+		c.seed = seed
+	}
+}
+
+// WithPublishErrorRate makes the sink fail with ErrInjectedFault, instead of publishing a message, with the given
+// probability. It has no effect on sources.
+func WithPublishErrorRate(rate float64) Option {
+	return func(c *config) {
+		c.publishErrorRate = rate
+	}
+}
+
+// WithDisconnectRate makes the sink or the source terminate with ErrInjectedDisconnect with the given probability
+// for every message. A sink terminates after the message was passed on for publishing, a source before the message
+// is delivered.
+func WithDisconnectRate(rate float64) Option {
+	return func(c *config) {
+		c.disconnectRate = rate
+	}
+}
+
+// WithAckDelay delays the acknowledgement of every message by a random duration up to the given maximum.
+// Acknowledgements stay in order, so a delayed acknowledgement also delays all the following ones.
+func WithAckDelay(max time.Duration) Option {
+	return func(c *config) {
+		c.maxAckDelay = max
+	}
+}
+
+// WithDroppedAckRate drops acknowledgements with the given probability. A sink doesn't pass a dropped
+// acknowledgement on to the user, a source doesn't pass it on to the underlying source.
+func WithDroppedAckRate(rate float64) Option {
+	return func(c *config) {
+		c.droppedAckRate = rate
+	}
+}
+
+// WithDuplicateRate makes the source deliver a message twice with the given probability. Only one of the two
+// acknowledgements is passed on to the underlying source. It has no effect on sinks.
+func WithDuplicateRate(rate float64) Option {
+	return func(c *config) {
+		c.duplicateRate = rate
+	}
+}
+
+type config struct {
+	seed             int64
+	publishErrorRate float64
+	disconnectRate   float64
+	maxAckDelay      time.Duration
+	droppedAckRate   float64
+	duplicateRate    float64
+
+	mutex sync.Mutex
+	rand  *rand.Rand
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		seed: time.Now().UnixNano(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.rand = rand.New(rand.NewSource(c.seed))
+	return c
+}
+
+// faults are the faults drawn for a single message.
+type faults struct {
+	publishError bool
+	disconnect   bool
+	ackDelay     time.Duration
+	dropAck      bool
+	duplicate    bool
+}
+
+// draw draws the faults for the next message. All faults are drawn for every message, regardless of whether
+// they are enabled, so that enabling one fault doesn't change which messages experience the others.
+func (c *config) draw() faults {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	f := faults{
+		publishError: c.rand.Float64() < c.publishErrorRate,
+		disconnect:   c.rand.Float64() < c.disconnectRate,
+		dropAck:      c.rand.Float64() < c.droppedAckRate,
+		duplicate:    c.rand.Float64() < c.duplicateRate,
+	}
+	delay := c.rand.Float64()
+	if c.maxAckDelay > 0 {
+		f.ackDelay = time.Duration(delay * float64(c.maxAckDelay))
+	}
+	return f
+}
+
+// sleep waits for the given duration or until the context is cancelled. It reports whether the duration elapsed.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,212 @@
+package chaos_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/chaos"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+// publish publishes messages with the given payloads one at a time, until the sink fails or an acknowledgement
+// doesn't arrive in time. It returns the payloads that were acknowledged and the error returned by the sink.
+func publish(t *testing.T, sink substrate.AsyncMessageSink, payloads ...string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	var acked []string
+	for _, payload := range payloads {
+		select {
+		case err := <-errs:
+			return acked, err
+		case messages <- message.FromString(payload):
+		}
+		select {
+		case err := <-errs:
+			return acked, err
+		case ack := <-acks:
+			acked = append(acked, string(ack.Data()))
+		case <-time.After(time.Millisecond * 50):
+		}
+	}
+	cancel()
+	return acked, <-errs
+}
+
+func TestAsyncMessageSink_PublishError(t *testing.T) {
+	broker := mem.NewBroker()
+	sink := chaos.NewAsyncMessageSink(broker.NewAsyncMessageSink("topic"), chaos.WithPublishErrorRate(1))
+
+	acked, err := publish(t, sink, "1")
+	require.Equal(t, chaos.ErrInjectedFault, err)
+	require.Empty(t, acked)
+	require.Empty(t, broker.Messages("topic"))
+}
+
+func TestAsyncMessageSink_Disconnect(t *testing.T) {
+	broker := mem.NewBroker()
+	sink := chaos.NewAsyncMessageSink(broker.NewAsyncMessageSink("topic"), chaos.WithDisconnectRate(1))
+
+	_, err := publish(t, sink, "1", "2")
+	require.Equal(t, chaos.ErrInjectedDisconnect, err)
+}
+
+func TestAsyncMessageSink_DroppedAcks(t *testing.T) {
+	broker := mem.NewBroker()
+	sink := chaos.NewAsyncMessageSink(broker.NewAsyncMessageSink("topic"), chaos.WithDroppedAckRate(1))
+
+	acked, err := publish(t, sink, "1", "2")
+	require.NoError(t, err)
+	require.Empty(t, acked)
+	require.Equal(t, [][]byte{[]byte("1"), []byte("2")}, broker.Messages("topic"))
+}
+
+func TestAsyncMessageSink_AckDelay(t *testing.T) {
+	sink := chaos.NewAsyncMessageSink(mem.NewBroker().NewAsyncMessageSink("topic"), chaos.WithAckDelay(time.Millisecond*20))
+
+	acked, err := publish(t, sink, "1", "2", "3")
+	require.NoError(t, err)
+	require.Equal(t, []string{"1", "2", "3"}, acked)
+}
+
+func TestAsyncMessageSink_Seed(t *testing.T) {
+	payloads := make([]string, 20)
+	for i := range payloads {
+		payloads[i] = string(rune('a' + i))
+	}
+	run := func(seed int64) []string {
+		sink := chaos.NewAsyncMessageSink(mem.NewBroker().NewAsyncMessageSink("topic"),
+			chaos.WithSeed(seed),
+			chaos.WithDroppedAckRate(0.5),
+		)
+		acked, err := publish(t, sink, payloads...)
+		require.NoError(t, err)
+		return acked
+	}
+
+	acked := run(42)
+	require.NotEmpty(t, acked)
+	require.NotEqual(t, payloads, acked)
+	require.Equal(t, acked, run(42))
+}
+
+func TestAsyncMessageSource_Duplicates(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	msgs := []substrate.Message{message.FromString("1"), message.FromString("2")}
+	// The mock source fails in case it receives the acknowledgement of a duplicate.
+	source := chaos.NewAsyncMessageSource(&mock.AsyncMessageSource{Messages: msgs}, chaos.WithDuplicateRate(1))
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	var consumed []string
+	for i := 0; i < 4; i++ {
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "failed to consume all messages")
+		case msg := <-messages:
+			consumed = append(consumed, string(msg.Data()))
+			acks <- msg
+		}
+	}
+	require.Equal(t, []string{"1", "1", "2", "2"}, consumed)
+
+	select {
+	case err := <-errs:
+		require.FailNow(t, "unexpected source termination", "error: %v", err)
+	case <-time.After(time.Millisecond * 50):
+	}
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestAsyncMessageSource_DroppedAcks(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	source := chaos.NewAsyncMessageSource(broker.NewAsyncMessageSource("topic", "group"), chaos.WithDroppedAckRate(1))
+	sink := broker.NewAsyncMessageSink("topic")
+	_, err := publish(t, sink, "1")
+	require.NoError(t, err)
+
+	consume := func(source substrate.AsyncMessageSource) {
+		ctx, cancel := context.WithCancel(ctx)
+		messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+		errs := make(chan error, 1)
+		go func() {
+			errs <- source.ConsumeMessages(ctx, messages, acks)
+		}()
+		msg := <-messages
+		require.Equal(t, "1", string(msg.Data()))
+		acks <- msg
+		cancel()
+		require.NoError(t, <-errs)
+		require.NoError(t, source.Close())
+	}
+
+	// The acknowledgement was dropped, so the message is delivered again.
+	consume(source)
+	consume(broker.NewAsyncMessageSource("topic", "group"))
+}
+
+func TestAsyncMessageSource_Disconnect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source := chaos.NewAsyncMessageSource(&mock.AsyncMessageSource{
+		Messages: []substrate.Message{message.FromString("1")},
+	}, chaos.WithDisconnectRate(1))
+
+	err := source.ConsumeMessages(ctx, make(chan substrate.Message), make(chan substrate.Message))
+	require.Equal(t, chaos.ErrInjectedDisconnect, err)
+}
+
+func TestAsyncMessageSource_Metadata(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source := chaos.NewAsyncMessageSource(&mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			message.NewEnvelopedMessage(message.Envelope{
+				ContentType: "text/plain",
+				Headers:     map[string]string{"traceparent": "00-trace-span-01"},
+				Payload:     []byte("1"),
+			}),
+		},
+	}, chaos.WithDuplicateRate(1))
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	// Delivered messages, including duplicates, report the headers and content type of the messages of the source.
+	for i := 0; i < 2; i++ {
+		msg := <-messages
+		require.Equal(t, map[string]string{"traceparent": "00-trace-span-01"}, message.Headers(msg))
+		require.Equal(t, "text/plain", message.ContentType(msg))
+		acks <- msg
+	}
+
+	cancel()
+	require.NoError(t, <-errs)
+}
@@ -0,0 +1,114 @@
+package chaos
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that injects the configured faults
+// when publishing messages to the provided sink.
+func NewAsyncMessageSink(sink substrate.AsyncMessageSink, opts ...Option) substrate.AsyncMessageSink {
+	return &chaosSink{
+		impl:   sink,
+		config: newConfig(opts),
+	}
+}
+
+// chaosSink implements substrate.AsyncMessageSink that injects faults.
+type chaosSink struct {
+	impl   substrate.AsyncMessageSink
+	config *config
+}
+
+// PublishMessages publishes messages to the underlying sink, injecting the configured faults.
+func (s *chaosSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "chaos"})
+	rg, ctx := rungroup.New(ctx)
+
+	toPublish := make(chan substrate.Message, cap(messages))
+	published := make(chan substrate.Message, cap(acks))
+
+	var (
+		mutex    sync.Mutex
+		inFlight []faults
+	)
+
+	rg.Go(func() error {
+		return s.impl.PublishMessages(ctx, published, toPublish)
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-messages:
+				emit.Publish(msg)
+				f := s.config.draw()
+				if f.publishError {
+					return ErrInjectedFault
+				}
+
+				mutex.Lock()
+				inFlight = append(inFlight, f)
+				mutex.Unlock()
+
+				select {
+				case <-ctx.Done():
+					return nil
+				case toPublish <- msg:
+				}
+				if f.disconnect {
+					return ErrInjectedDisconnect
+				}
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-published:
+				// Sinks acknowledge messages in order, so the acknowledgement is for the oldest message in flight.
+				mutex.Lock()
+				if len(inFlight) == 0 {
+					mutex.Unlock()
+					return errors.Errorf("unexpected message acknowledged: %v", ack)
+				}
+				f := inFlight[0]
+				inFlight = inFlight[1:]
+				mutex.Unlock()
+
+				if !sleep(ctx, f.ackDelay) {
+					return nil
+				}
+				if f.dropAck {
+					continue
+				}
+				emit.Ack(ack)
+				select {
+				case <-ctx.Done():
+					return nil
+				case acks <- ack:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+// Close closes the underlying sink.
+func (s *chaosSink) Close() error {
+	return s.impl.Close()
+}
+
+// Status returns the status of the underlying sink.
+func (s *chaosSink) Status() (*substrate.Status, error) {
+	return s.impl.Status()
+}
@@ -0,0 +1,133 @@
+package chaos
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that injects the configured faults
+// when consuming messages from the provided source.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, opts ...Option) substrate.AsyncMessageSource {
+	return &chaosSource{
+		impl:   source,
+		config: newConfig(opts),
+	}
+}
+
+// chaosSource implements substrate.AsyncMessageSource that injects faults.
+type chaosSource struct {
+	impl   substrate.AsyncMessageSource
+	config *config
+}
+
+// ConsumeMessages consumes messages from the underlying source, injecting the configured faults.
+func (s *chaosSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "chaos"})
+	rg, ctx := rungroup.New(ctx)
+
+	consumed := make(chan substrate.Message, cap(messages))
+	toAck := make(chan substrate.Message, cap(acks))
+
+	rg.Go(func() error {
+		return s.impl.ConsumeMessages(ctx, consumed, toAck)
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-consumed:
+				f := s.config.draw()
+				if f.disconnect {
+					return ErrInjectedDisconnect
+				}
+
+				deliveries := []*chaosMessage{{msg: msg, faults: f}}
+				if f.duplicate {
+					deliveries = append(deliveries, &chaosMessage{msg: msg, faults: f, duplicate: true})
+				}
+				for _, cMsg := range deliveries {
+					emit.Publish(cMsg)
+					select {
+					case <-ctx.Done():
+						return nil
+					case messages <- cMsg:
+					}
+				}
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				cMsg, ok := ack.(*chaosMessage)
+				if !ok {
+					return errors.Errorf("unexpected message type: %T", ack)
+				}
+				emit.Ack(ack)
+				if cMsg.duplicate {
+					continue
+				}
+				if !sleep(ctx, cMsg.faults.ackDelay) {
+					return nil
+				}
+				if cMsg.faults.dropAck {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case toAck <- cMsg.msg:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+// Close closes the underlying source.
+func (s *chaosSource) Close() error {
+	return s.impl.Close()
+}
+
+// Status returns the status of the underlying source.
+func (s *chaosSource) Status() (*substrate.Status, error) {
+	return s.impl.Status()
+}
+
+type chaosMessage struct {
+	msg       substrate.Message
+	faults    faults
+	duplicate bool
+}
+
+func (m *chaosMessage) DiscardPayload() {
+	if dMsg, ok := m.msg.(substrate.DiscardableMessage); ok {
+		dMsg.DiscardPayload()
+	}
+}
+
+func (m *chaosMessage) Data() []byte {
+	return m.msg.Data()
+}
+
+func (m *chaosMessage) Headers() map[string]string {
+	return message.Headers(m.msg)
+}
+
+func (m *chaosMessage) ContentType() string {
+	return message.ContentType(m.msg)
+}
+
+func (m *chaosMessage) CarriedEnvelope() (message.Envelope, bool) {
+	return message.EnvelopeOf(m.msg)
+}
@@ -0,0 +1,259 @@
+// Package checkpoint provides a message source wrapper that persists consumption progress to an external
+// store, for backends whose native consumer groups are insufficient or unavailable.
+package checkpoint
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/ackordering"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/internal/inorder"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+const defaultInterval = 5 * time.Second
+
+// Positioned is implemented by messages that expose their position within the partition of the topic they
+// were consumed from. The progress of messages not implementing it isn't tracked.
+type Positioned interface {
+	substrate.Message
+	// Partition returns the identifier of the partition the message was consumed from.
+	Partition() string
+	// Offset returns the offset of the message within its partition.
+	Offset() int64
+}
+
+// AsyncMessageSourceOption is a function which sets an AsyncMessageSource configuration option.
+type AsyncMessageSourceOption func(s *checkpointSource)
+
+// WithInterval sets how often the checkpoint is saved, if any messages were acknowledged since it was last
+// saved. The checkpoint is also saved when consumption stops. The default value is 5 seconds.
+func WithInterval(interval time.Duration) AsyncMessageSourceOption {
+	return func(s *checkpointSource) {
+		s.interval = interval
+	}
+}
+
+// WithResume makes the source skip, and acknowledge automatically, messages at or before the last saved
+// checkpoint, for sources that start consuming from an earlier position, e.g. the start of the topic.
+func WithResume() AsyncMessageSourceOption {
+	return func(s *checkpointSource) {
+		s.resume = true
+	}
+}
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that records the offset of the last
+// acknowledged message of each partition and periodically saves it to the store as the checkpoint of the consumer.
+// Acknowledgements are forwarded to the underlying source in the order in which the messages were consumed, so the
+// checkpoint never covers messages that haven't been acknowledged. The last checkpoint can be loaded from the store
+// to configure the position from which the underlying source starts consuming.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, consumer string, store Store, opts ...AsyncMessageSourceOption) substrate.AsyncMessageSource {
+	s := &checkpointSource{
+		source:   source,
+		consumer: consumer,
+		store:    store,
+		interval: defaultInterval,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return ackordering.NewAsyncMessageSource(s)
+}
+
+// checkpointSource receives acknowledgements in the order in which messages were consumed from the ack ordering
+// wrapper, which allows it to track the progress of each partition.
+type checkpointSource struct {
+	source   substrate.AsyncMessageSource
+	consumer string
+	store    Store
+	interval time.Duration
+	resume   bool
+
+	mutex    sync.Mutex
+	progress Checkpoint
+	version  uint64
+	saved    uint64
+}
+
+func (s *checkpointSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "checkpoint"})
+	committed, err := s.store.Load(ctx, s.consumer)
+	if err != nil {
+		return emit.Error(errors.Wrap(err, "failed to load checkpoint"))
+	}
+	s.mutex.Lock()
+	s.progress, s.version, s.saved = committed.copy(), 0, 0
+	s.mutex.Unlock()
+
+	rg, groupCtx := rungroup.New(ctx)
+
+	sourceMsgs := make(chan substrate.Message, cap(messages))
+	sourceAcks := make(chan substrate.Message, cap(acks))
+	queue := inorder.NewQueue[*checkpointMessage, substrate.Message]()
+
+	release := func(msgs []substrate.Message) bool {
+		for _, msg := range msgs {
+			select {
+			case <-groupCtx.Done():
+				return false
+			case sourceAcks <- msg:
+				s.record(msg)
+			}
+		}
+		return true
+	}
+
+	rg.Go(func() error {
+		return s.source.ConsumeMessages(groupCtx, sourceMsgs, sourceAcks)
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-groupCtx.Done():
+				return nil
+			case msg := <-sourceMsgs:
+				if s.resume && covered(committed, msg) {
+					queue.PushDone(msg)
+					continue
+				}
+				delivered := &checkpointMessage{msg: msg}
+				queue.Push(0, delivered, msg)
+				emit.Publish(delivered)
+				select {
+				case <-groupCtx.Done():
+					return nil
+				case messages <- delivered:
+				}
+			}
+		}
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			var released []substrate.Message
+			select {
+			case <-groupCtx.Done():
+				return nil
+			case <-queue.Ready():
+				released = queue.Release()
+			case ack := <-acks:
+				cMsg, ok := ack.(*checkpointMessage)
+				if ok {
+					released, ok = queue.Complete(0, cMsg)
+				}
+				if !ok {
+					return errors.Errorf("unexpected message acknowledged: %v", ack)
+				}
+				emit.Ack(ack)
+			}
+			if !release(released) {
+				return nil
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-groupCtx.Done():
+				return nil
+			case <-ticker.C:
+				if err := s.save(groupCtx); err != nil {
+					return err
+				}
+			}
+		}
+	}))
+
+	err = rg.Wait()
+	// save the final progress even though consumption was cancelled
+	if saveErr := emit.Error(s.save(context.WithoutCancel(ctx))); err == nil {
+		err = saveErr
+	}
+	return err
+}
+
+// covered checks whether the message is at or before the position recorded in the checkpoint.
+func covered(checkpoint Checkpoint, msg substrate.Message) bool {
+	pMsg, ok := msg.(Positioned)
+	if !ok {
+		return false
+	}
+	offset, ok := checkpoint[pMsg.Partition()]
+	return ok && pMsg.Offset() <= offset
+}
+
+// record records the position of an acknowledged message.
+func (s *checkpointSource) record(msg substrate.Message) {
+	pMsg, ok := msg.(Positioned)
+	if !ok {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.progress[pMsg.Partition()] = pMsg.Offset()
+	s.version++
+}
+
+// save saves the checkpoint, if it changed since it was last saved.
+func (s *checkpointSource) save(ctx context.Context) error {
+	s.mutex.Lock()
+	if s.version == s.saved {
+		s.mutex.Unlock()
+		return nil
+	}
+	checkpoint, version := s.progress.copy(), s.version
+	s.mutex.Unlock()
+
+	if err := s.store.Save(ctx, s.consumer, checkpoint); err != nil {
+		return errors.Wrap(err, "failed to save checkpoint")
+	}
+
+	s.mutex.Lock()
+	s.saved = version
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *checkpointSource) Close() error {
+	return s.source.Close()
+}
+
+func (s *checkpointSource) Status() (*substrate.Status, error) {
+	return s.source.Status()
+}
+
+// checkpointMessage is a message delivered to the user, which is acknowledged in its place.
+type checkpointMessage struct {
+	msg substrate.Message
+}
+
+func (msg *checkpointMessage) Data() []byte {
+	return msg.msg.Data()
+}
+
+func (msg *checkpointMessage) DiscardPayload() {
+	if dMsg, ok := msg.msg.(substrate.DiscardableMessage); ok {
+		dMsg.DiscardPayload()
+	}
+}
+
+func (msg *checkpointMessage) Headers() map[string]string {
+	return message.Headers(msg.msg)
+}
+
+func (msg *checkpointMessage) ContentType() string {
+	return message.ContentType(msg.msg)
+}
+
+func (msg *checkpointMessage) CarriedEnvelope() (message.Envelope, bool) {
+	return message.EnvelopeOf(msg.msg)
+}
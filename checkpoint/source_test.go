@@ -0,0 +1,161 @@
+package checkpoint_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/checkpoint"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+type positionedMessage struct {
+	*message.Message
+	partition string
+	offset    int64
+}
+
+func (m *positionedMessage) Partition() string {
+	return m.partition
+}
+
+func (m *positionedMessage) Offset() int64 {
+	return m.offset
+}
+
+func positioned(partition string, offset int64) substrate.Message {
+	return &positionedMessage{Message: message.FromString(partition), partition: partition, offset: offset}
+}
+
+func consume(ctx context.Context, t *testing.T, source substrate.AsyncMessageSource, count int) (<-chan error, []substrate.Message) {
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	var consumed []substrate.Message
+	for i := 0; i < count; i++ {
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "failed to consume all messages")
+		case msg := <-messages:
+			consumed = append(consumed, msg)
+		}
+	}
+	// Acknowledge the messages in reverse order, the checkpoint must only cover acknowledged messages.
+	for i := len(consumed) - 1; i >= 0; i-- {
+		acks <- consumed[i]
+	}
+	return errs, consumed
+}
+
+func TestCheckpointSavedOnStop(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	store := checkpoint.NewMemoryStore()
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			positioned("0", 1),
+			positioned("1", 1),
+			positioned("0", 2),
+			message.FromString("plain"),
+		},
+	}
+	source := checkpoint.NewAsyncMessageSource(mockSource, "consumer", store, checkpoint.WithInterval(time.Hour))
+
+	consumeCtx, consumeCancel := context.WithCancel(ctx)
+	errs, _ := consume(consumeCtx, t, source, 4)
+
+	// Give the wrapper time to forward the acknowledgements.
+	time.Sleep(time.Millisecond * 50)
+	consumeCancel()
+	require.NoError(t, <-errs)
+
+	saved, err := store.Load(ctx, "consumer")
+	require.NoError(t, err)
+	require.Equal(t, checkpoint.Checkpoint{"0": 2, "1": 1}, saved)
+}
+
+func TestCheckpointSavedPeriodically(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	store := checkpoint.NewMemoryStore()
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{positioned("0", 7)},
+	}
+	source := checkpoint.NewAsyncMessageSource(mockSource, "consumer", store, checkpoint.WithInterval(time.Millisecond*10))
+
+	errs, _ := consume(ctx, t, source, 1)
+
+	require.Eventually(t, func() bool {
+		saved, err := store.Load(ctx, "consumer")
+		return err == nil && saved["0"] == 7
+	}, time.Second, time.Millisecond*10)
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestResumeFromCheckpoint(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	store := checkpoint.NewMemoryStore()
+	require.NoError(t, store.Save(ctx, "consumer", checkpoint.Checkpoint{"0": 1}))
+
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			positioned("0", 0),
+			positioned("1", 0),
+			positioned("0", 1),
+			positioned("0", 2),
+		},
+	}
+	source := checkpoint.NewAsyncMessageSource(mockSource, "consumer", store, checkpoint.WithResume())
+
+	consumeCtx, consumeCancel := context.WithCancel(ctx)
+	errs, consumed := consume(consumeCtx, t, source, 2)
+	require.Len(t, consumed, 2)
+
+	time.Sleep(time.Millisecond * 50)
+	consumeCancel()
+	// The mock source terminates with an error if acknowledgements are out of order.
+	require.NoError(t, <-errs)
+
+	saved, err := store.Load(ctx, "consumer")
+	require.NoError(t, err)
+	require.Equal(t, checkpoint.Checkpoint{"0": 2, "1": 0}, saved)
+}
+
+func TestCheckpointMessageMetadata(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			message.NewEnvelopedMessage(message.Envelope{
+				ContentType: "text/plain",
+				Headers:     map[string]string{"key": "value"},
+				Payload:     []byte("1"),
+			}),
+		},
+	}
+	source := checkpoint.NewAsyncMessageSource(mockSource, "consumer", checkpoint.NewMemoryStore())
+
+	consumeCtx, consumeCancel := context.WithCancel(ctx)
+	errs, consumed := consume(consumeCtx, t, source, 1)
+
+	// Delivered messages report the headers and content type of the messages of the source.
+	require.Equal(t, map[string]string{"key": "value"}, message.Headers(consumed[0]))
+	require.Equal(t, "text/plain", message.ContentType(consumed[0]))
+
+	consumeCancel()
+	require.NoError(t, <-errs)
+}
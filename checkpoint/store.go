@@ -0,0 +1,165 @@
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Checkpoint maps partitions to the offset of the last message acknowledged in them.
+type Checkpoint map[string]int64
+
+// Store persists the checkpoints of consumers.
+type Store interface {
+	// Load returns the last checkpoint saved for the consumer, or an empty checkpoint if none was saved.
+	Load(ctx context.Context, consumer string) (Checkpoint, error)
+	// Save saves the checkpoint of the consumer, replacing the previous one.
+	Save(ctx context.Context, consumer string, checkpoint Checkpoint) error
+}
+
+// NewMemoryStore returns an in-memory store, which is mostly useful for testing.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		checkpoints: make(map[string]Checkpoint),
+	}
+}
+
+type memoryStore struct {
+	mutex       sync.Mutex
+	checkpoints map[string]Checkpoint
+}
+
+func (s *memoryStore) Load(_ context.Context, consumer string) (Checkpoint, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.checkpoints[consumer].copy(), nil
+}
+
+func (s *memoryStore) Save(_ context.Context, consumer string, checkpoint Checkpoint) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.checkpoints[consumer] = checkpoint.copy()
+	return nil
+}
+
+// NewFileStore returns a store that keeps the checkpoint of each consumer in a JSON file in the directory.
+// Files are replaced atomically, so that a crash never leaves a partially written checkpoint behind.
+func NewFileStore(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "failed to create checkpoint directory")
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+type fileStore struct {
+	dir string
+}
+
+func (s *fileStore) Load(_ context.Context, consumer string) (Checkpoint, error) {
+	data, err := os.ReadFile(s.path(consumer))
+	switch {
+	case os.IsNotExist(err):
+		return Checkpoint{}, nil
+	case err != nil:
+		return nil, errors.Wrap(err, "failed to read checkpoint")
+	}
+	return decode(data)
+}
+
+func (s *fileStore) Save(_ context.Context, consumer string, checkpoint Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode checkpoint")
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".checkpoint-*")
+	if err != nil {
+		return errors.Wrap(err, "failed to write checkpoint")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write checkpoint")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write checkpoint")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to write checkpoint")
+	}
+	if err := os.Rename(tmp.Name(), s.path(consumer)); err != nil {
+		return errors.Wrap(err, "failed to write checkpoint")
+	}
+	return nil
+}
+
+func (s *fileStore) path(consumer string) string {
+	return filepath.Join(s.dir, url.PathEscape(consumer)+".json")
+}
+
+// KeyValue is a minimal key value store, which can be implemented on top of e.g. Redis or an SQL table
+// to store checkpoints using NewKeyValueStore.
+type KeyValue interface {
+	// Get returns the value of the key, or nil if it doesn't exist.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set sets the value of the key.
+	Set(ctx context.Context, key string, value []byte) error
+}
+
+// NewKeyValueStore returns a store that keeps the checkpoint of each consumer, encoded as JSON, in the key
+// value store under the key made of the prefix followed by the name of the consumer.
+func NewKeyValueStore(kv KeyValue, prefix string) Store {
+	return &keyValueStore{kv: kv, prefix: prefix}
+}
+
+type keyValueStore struct {
+	kv     KeyValue
+	prefix string
+}
+
+func (s *keyValueStore) Load(ctx context.Context, consumer string) (Checkpoint, error) {
+	data, err := s.kv.Get(ctx, s.prefix+consumer)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read checkpoint")
+	}
+	if data == nil {
+		return Checkpoint{}, nil
+	}
+	return decode(data)
+}
+
+func (s *keyValueStore) Save(ctx context.Context, consumer string, checkpoint Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode checkpoint")
+	}
+	if err := s.kv.Set(ctx, s.prefix+consumer, data); err != nil {
+		return errors.Wrap(err, "failed to write checkpoint")
+	}
+	return nil
+}
+
+func decode(data []byte) (Checkpoint, error) {
+	checkpoint := Checkpoint{}
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, errors.Wrap(err, "failed to decode checkpoint")
+	}
+	return checkpoint, nil
+}
+
+func (c Checkpoint) copy() Checkpoint {
+	copied := make(Checkpoint, len(c))
+	for partition, offset := range c {
+		copied[partition] = offset
+	}
+	return copied
+}
@@ -0,0 +1,64 @@
+package checkpoint_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate-tools/checkpoint"
+)
+
+type mapKeyValue struct {
+	mutex  sync.Mutex
+	values map[string][]byte
+}
+
+func (kv *mapKeyValue) Get(_ context.Context, key string) ([]byte, error) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+	return kv.values[key], nil
+}
+
+func (kv *mapKeyValue) Set(_ context.Context, key string, value []byte) error {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+	kv.values[key] = value
+	return nil
+}
+
+func TestStores(t *testing.T) {
+	fileStore, err := checkpoint.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	kv := &mapKeyValue{values: make(map[string][]byte)}
+
+	stores := map[string]checkpoint.Store{
+		"memory":    checkpoint.NewMemoryStore(),
+		"file":      fileStore,
+		"key value": checkpoint.NewKeyValueStore(kv, "checkpoints/"),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			loaded, err := store.Load(ctx, "consumer/a")
+			require.NoError(t, err)
+			require.Empty(t, loaded)
+
+			require.NoError(t, store.Save(ctx, "consumer/a", checkpoint.Checkpoint{"0": 1}))
+			require.NoError(t, store.Save(ctx, "consumer/a", checkpoint.Checkpoint{"0": 5, "1": 3}))
+			require.NoError(t, store.Save(ctx, "consumer/b", checkpoint.Checkpoint{"0": 9}))
+
+			loaded, err = store.Load(ctx, "consumer/a")
+			require.NoError(t, err)
+			require.Equal(t, checkpoint.Checkpoint{"0": 5, "1": 3}, loaded)
+
+			loaded, err = store.Load(ctx, "consumer/b")
+			require.NoError(t, err)
+			require.Equal(t, checkpoint.Checkpoint{"0": 9}, loaded)
+		})
+	}
+
+	require.Contains(t, kv.values, "checkpoints/consumer/a")
+}
@@ -0,0 +1,94 @@
+// Package checksum provides wrappers that append a checksum to the payloads of published messages and verify it on
+// consumption, detecting payloads corrupted in transit, e.g. by backends or bridges suspected of mangling them.
+package checksum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/pkg/errors"
+)
+
+// Algorithm is an algorithm used to compute checksums.
+type Algorithm byte
+
+const (
+	// CRC32C computes 4 byte checksums using CRC-32 with the Castagnoli polynomial.
+	CRC32C Algorithm = iota + 1
+	// XXHash computes 8 byte checksums using the 64 bit xxHash algorithm.
+	XXHash
+)
+
+// String returns the name of the algorithm.
+func (a Algorithm) String() string {
+	switch a {
+	case CRC32C:
+		return "crc32c"
+	case XXHash:
+		return "xxhash"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrCorrupted is returned when the checksum of a consumed payload doesn't match, or the payload doesn't carry one.
+var ErrCorrupted = errors.New("payload is corrupted")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// size returns the size of the checksums computed by the algorithm, or 0 if it is unknown.
+func (a Algorithm) size() int {
+	switch a {
+	case CRC32C:
+		return 4
+	case XXHash:
+		return 8
+	default:
+		return 0
+	}
+}
+
+func (a Algorithm) sum(data []byte) []byte {
+	sum := make([]byte, a.size())
+	switch a {
+	case CRC32C:
+		binary.BigEndian.PutUint32(sum, crc32.Checksum(data, castagnoli))
+	case XXHash:
+		binary.BigEndian.PutUint64(sum, xxhash.Sum64(data))
+	}
+	return sum
+}
+
+// Append returns the payload followed by its checksum and a byte identifying the algorithm.
+func Append(algorithm Algorithm, data []byte) ([]byte, error) {
+	if algorithm.size() == 0 {
+		return nil, errors.Errorf("unknown checksum algorithm %d", algorithm)
+	}
+	out := make([]byte, 0, len(data)+algorithm.size()+1)
+	out = append(out, data...)
+	out = append(out, algorithm.sum(data)...)
+	return append(out, byte(algorithm)), nil
+}
+
+// Verify checks the checksum appended to the payload by Append, using the algorithm the payload records, and
+// returns the payload without it. It returns an error wrapping ErrCorrupted if the checksum doesn't match.
+func Verify(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.Wrap(ErrCorrupted, "missing checksum")
+	}
+	algorithm := Algorithm(data[len(data)-1])
+	size := algorithm.size()
+	if size == 0 {
+		return nil, errors.Wrapf(ErrCorrupted, "unknown checksum algorithm %d", algorithm)
+	}
+	if len(data) < size+1 {
+		return nil, errors.Wrap(ErrCorrupted, "payload is too short")
+	}
+	payload, sum := data[:len(data)-size-1], data[len(data)-size-1:len(data)-1]
+	if !bytes.Equal(sum, algorithm.sum(payload)) {
+		return nil, errors.Wrapf(ErrCorrupted, "%s checksum mismatch", algorithm)
+	}
+	return payload, nil
+}
@@ -0,0 +1,144 @@
+package checksum_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/checksum"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+func publish(ctx context.Context, t *testing.T, sink substrate.AsyncMessageSink, msgs ...substrate.Message) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+	for _, msg := range msgs {
+		messages <- msg
+		require.Equal(t, msg, <-acks)
+	}
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestAppendAndVerify(t *testing.T) {
+	for _, algorithm := range []checksum.Algorithm{checksum.CRC32C, checksum.XXHash} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			data, err := checksum.Append(algorithm, []byte("payload"))
+			require.NoError(t, err)
+
+			payload, err := checksum.Verify(data)
+			require.NoError(t, err)
+			require.Equal(t, "payload", string(payload))
+
+			data[0] ^= 0xff
+			_, err = checksum.Verify(data)
+			require.Equal(t, checksum.ErrCorrupted, errors.Cause(err))
+		})
+	}
+
+	for _, data := range [][]byte{nil, []byte("payload"), {0, byte(checksum.XXHash)}} {
+		_, err := checksum.Verify(data)
+		require.Equal(t, checksum.ErrCorrupted, errors.Cause(err))
+	}
+
+	_, err := checksum.Append(checksum.Algorithm(42), []byte("payload"))
+	require.EqualError(t, err, "unknown checksum algorithm 42")
+}
+
+func TestChecksums(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	publish(ctx, t, checksum.NewAsyncMessageSink(message.NewEnvelopeSink(broker.NewAsyncMessageSink("topic")), checksum.WithAlgorithm(checksum.XXHash)),
+		message.NewEnvelopedMessage(message.Envelope{
+			ID:      "id-1",
+			Headers: map[string]string{"traceparent": "trace"},
+			Payload: []byte("payload"),
+		}),
+	)
+
+	source := checksum.NewAsyncMessageSource(message.NewEnvelopeSource(broker.NewAsyncMessageSource("topic", "group")))
+	consumed, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, consumed, acks)
+	}()
+
+	msg := <-consumed
+	require.Equal(t, "payload", string(msg.Data()))
+	require.Equal(t, map[string]string{"traceparent": "trace"}, message.Headers(msg))
+	acks <- msg
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestCorruption(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	good, err := checksum.Append(checksum.CRC32C, []byte("good"))
+	require.NoError(t, err)
+	bad, err := checksum.Append(checksum.CRC32C, []byte("bad"))
+	require.NoError(t, err)
+	bad[0] = 'm'
+
+	broker := mem.NewBroker()
+	publish(ctx, t, broker.NewAsyncMessageSink("topic"), message.NewMessage(bad), message.NewMessage(good))
+
+	t.Run("fail", func(t *testing.T) {
+		source := checksum.NewAsyncMessageSource(broker.NewAsyncMessageSource("topic", "fail"))
+		err := source.ConsumeMessages(ctx, make(chan substrate.Message), make(chan substrate.Message))
+		require.Equal(t, checksum.ErrCorrupted, errors.Cause(err))
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		counterOpts := prometheus.CounterOpts{
+			Name: "checksum_test_corrupted_total",
+			Help: "checksum_test_corrupted_total",
+		}
+		source := checksum.NewAsyncMessageSource(broker.NewAsyncMessageSource("topic", "drop"),
+			checksum.WithPolicy(checksum.Drop),
+			checksum.WithCorruptionCounter(counterOpts, "topic"),
+		)
+		counter := corruptionCounter(t, counterOpts)
+		before := testutil.ToFloat64(counter)
+
+		ctx, cancel := context.WithCancel(ctx)
+		consumed, acks := make(chan substrate.Message), make(chan substrate.Message)
+		errs := make(chan error, 1)
+		go func() {
+			errs <- source.ConsumeMessages(ctx, consumed, acks)
+		}()
+
+		msg := <-consumed
+		require.Equal(t, "good", string(msg.Data()))
+		acks <- msg
+		require.Equal(t, before+1, testutil.ToFloat64(counter))
+
+		cancel()
+		require.NoError(t, <-errs)
+	})
+}
+
+// corruptionCounter returns the registered corruption counter for the topic.
+func corruptionCounter(t *testing.T, counterOpts prometheus.CounterOpts) prometheus.Counter {
+	err := prometheus.Register(prometheus.NewCounterVec(counterOpts, []string{"topic"}))
+	are, ok := err.(prometheus.AlreadyRegisteredError)
+	require.True(t, ok)
+	return are.ExistingCollector.(*prometheus.CounterVec).WithLabelValues("topic")
+}
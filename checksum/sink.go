@@ -0,0 +1,66 @@
+package checksum
+
+import (
+	"context"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/internal/transform"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+// SinkOption is a function which sets a checksumming sink configuration option.
+type SinkOption func(s *checksumSink)
+
+// WithAlgorithm sets the algorithm used to compute checksums. The default algorithm is CRC32C.
+func WithAlgorithm(algorithm Algorithm) SinkOption {
+	return func(s *checksumSink) {
+		s.algorithm = algorithm
+	}
+}
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that appends a checksum to payloads before
+// publishing them. Messages are published as message.EnvelopedMessage, so that the envelope sink preserves the
+// metadata of enveloped messages.
+func NewAsyncMessageSink(sink substrate.AsyncMessageSink, opts ...SinkOption) substrate.AsyncMessageSink {
+	s := &checksumSink{
+		sink:      sink,
+		algorithm: CRC32C,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type checksumSink struct {
+	sink      substrate.AsyncMessageSink
+	algorithm Algorithm
+}
+
+func (s *checksumSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	return transform.PublishMessages(ctx, "checksum", s.sink, acks, messages, func(_ context.Context, msg substrate.Message) (substrate.Message, error) {
+		return s.checksum(msg)
+	})
+}
+
+// checksum returns the message to publish in place of the provided one.
+func (s *checksumSink) checksum(msg substrate.Message) (substrate.Message, error) {
+	envelope, _ := message.EnvelopeOf(msg)
+	envelope.Headers = message.Headers(msg)
+
+	data, err := Append(s.algorithm, envelope.Payload)
+	if err != nil {
+		return nil, err
+	}
+	envelope.Payload = data
+
+	return message.NewEnvelopedMessage(envelope), nil
+}
+
+func (s *checksumSink) Close() error {
+	return s.sink.Close()
+}
+
+func (s *checksumSink) Status() (*substrate.Status, error) {
+	return s.sink.Status()
+}
@@ -0,0 +1,188 @@
+package checksum
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/filter"
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// Policy is what the verifying source does with corrupted messages.
+type Policy int
+
+const (
+	// Fail makes ConsumeMessages return an error wrapping ErrCorrupted.
+	Fail Policy = iota
+	// Drop acknowledges corrupted messages without delivering them.
+	Drop
+)
+
+// SourceOption is a function which sets a verifying source configuration option.
+type SourceOption func(s *verifyingSource)
+
+// WithPolicy sets what is done with corrupted messages. The default policy is Fail.
+func WithPolicy(policy Policy) SourceOption {
+	return func(s *verifyingSource) {
+		s.policy = policy
+	}
+}
+
+// WithCorruptionCounter enables a counter of corrupted messages, labelled with the topic.
+// It panics in case it can't register the metric.
+func WithCorruptionCounter(counterOpts prometheus.CounterOpts, topic string) SourceOption {
+	return func(s *verifyingSource) {
+		counter := metrics.Register(prometheus.NewCounterVec(counterOpts, []string{"topic"})).(*prometheus.CounterVec)
+		s.corrupted = counter.WithLabelValues(topic)
+	}
+}
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that verifies the checksums appended to
+// payloads by the checksumming sink, delivering the payloads without them. Corrupted messages, including those
+// without a checksum, make consumption fail by default.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, opts ...SourceOption) substrate.AsyncMessageSource {
+	s := &verifyingSource{
+		source: source,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.policy == Drop {
+		return filter.NewAsyncMessageSource(s, func(msg substrate.Message) bool {
+			_, corrupted := msg.(*corruptedMessage)
+			return !corrupted
+		})
+	}
+	return s
+}
+
+type verifyingSource struct {
+	source    substrate.AsyncMessageSource
+	policy    Policy
+	corrupted prometheus.Counter
+}
+
+func (s *verifyingSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "checksum"})
+	rg, ctx := rungroup.New(ctx)
+
+	consumed := make(chan substrate.Message, cap(messages))
+	toAck := make(chan substrate.Message, cap(acks))
+
+	rg.Go(func() error {
+		return s.source.ConsumeMessages(ctx, consumed, toAck)
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-consumed:
+				vMsg, err := s.verify(msg)
+				if err != nil {
+					return err
+				}
+				emit.Publish(vMsg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case messages <- vMsg:
+				}
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				emit.Ack(ack)
+				switch vMsg := ack.(type) {
+				case *verifiedMessage:
+					ack = vMsg.msg
+				case *corruptedMessage:
+					ack = vMsg.msg
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case toAck <- ack:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+// verify returns the message to deliver in place of the consumed one. Corrupted messages are delivered as
+// corruptedMessage, for the filter to drop them, unless the policy is Fail.
+func (s *verifyingSource) verify(msg substrate.Message) (substrate.Message, error) {
+	data, err := Verify(msg.Data())
+	if err != nil {
+		if s.corrupted != nil {
+			s.corrupted.Inc()
+		}
+		if s.policy == Fail {
+			return nil, err
+		}
+		return &corruptedMessage{msg: msg}, nil
+	}
+	return &verifiedMessage{data: data, msg: msg}, nil
+}
+
+func (s *verifyingSource) Close() error {
+	return s.source.Close()
+}
+
+func (s *verifyingSource) Status() (*substrate.Status, error) {
+	return s.source.Status()
+}
+
+// verifiedMessage is a message with a verified payload, delivered on behalf of the consumed message.
+type verifiedMessage struct {
+	data []byte
+	msg  substrate.Message
+}
+
+// Data returns the payload without the checksum.
+func (msg *verifiedMessage) Data() []byte {
+	return msg.data
+}
+
+// Headers returns the headers of the consumed message.
+func (msg *verifiedMessage) Headers() map[string]string {
+	return message.Headers(msg.msg)
+}
+
+// ContentType returns the content type of the consumed message.
+func (msg *verifiedMessage) ContentType() string {
+	return message.ContentType(msg.msg)
+}
+
+// DiscardPayload discards the payload.
+func (msg *verifiedMessage) DiscardPayload() {
+	msg.data = nil
+	if dMsg, ok := msg.msg.(substrate.DiscardableMessage); ok {
+		dMsg.DiscardPayload()
+	}
+}
+
+func (msg *verifiedMessage) CarriedEnvelope() (message.Envelope, bool) {
+	return message.EnvelopeOf(msg.msg)
+}
+
+// corruptedMessage is a corrupted message, which is dropped by the filter.
+type corruptedMessage struct {
+	msg substrate.Message
+}
+
+func (msg *corruptedMessage) Data() []byte {
+	return msg.msg.Data()
+}
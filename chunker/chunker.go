@@ -0,0 +1,91 @@
+// Package chunker provides a message sink wrapper that splits payloads exceeding a threshold into numbered chunks,
+// and a source wrapper reassembling them, so that large payloads can be sent over backends limiting message sizes.
+package chunker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+// Headers set on chunks, in addition to the headers of the original message.
+const (
+	// IDHeader identifies the message the chunk belongs to.
+	IDHeader = "chunk-id"
+	// IndexHeader is the position of the chunk within its message, starting at 0.
+	IndexHeader = "chunk-index"
+	// CountHeader is the number of chunks of the message.
+	CountHeader = "chunk-count"
+	// PartialHeader is set to true on messages delivered although some of their chunks are missing.
+	PartialHeader = "chunk-partial"
+)
+
+// IncompleteError is the error returned when not all the chunks of a message were consumed before the timeout.
+type IncompleteError struct {
+	// ID is the ID of the chunked message.
+	ID string
+	// Received is the number of chunks that were consumed.
+	Received int
+	// Count is the number of chunks of the message.
+	Count int
+}
+
+func (e IncompleteError) Error() string {
+	return fmt.Sprintf("only %d of %d chunks of message %s were consumed before the timeout", e.Received, e.Count, e.ID)
+}
+
+// Split returns the chunks of the message, each carrying the headers of the message and the chunk headers, with
+// payloads no larger than the size. Messages whose payload doesn't exceed the size are returned unchanged.
+func Split(msg substrate.Message, size int) ([]substrate.Message, error) {
+	data := msg.Data()
+	if len(data) <= size {
+		return []substrate.Message{msg}, nil
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	count := (len(data) + size - 1) / size
+	chunks := make([]substrate.Message, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(data) {
+			end = len(data)
+		}
+		headers := message.Headers(msg)
+		headers[IDHeader] = id
+		headers[IndexHeader] = strconv.Itoa(i)
+		headers[CountHeader] = strconv.Itoa(count)
+		chunks = append(chunks, &chunkMessage{data: data[i*size : end], headers: headers})
+	}
+	return chunks, nil
+}
+
+func newID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", errors.Wrap(err, "failed to generate chunk ID")
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// chunkMessage is a chunk of the payload of a split message.
+type chunkMessage struct {
+	data    []byte
+	headers map[string]string
+}
+
+func (msg *chunkMessage) Data() []byte {
+	return msg.data
+}
+
+func (msg *chunkMessage) Headers() map[string]string {
+	return msg.headers
+}
@@ -0,0 +1,196 @@
+package chunker_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/chunker"
+	"github.com/uw-labs/substrate-tools/conformance"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+func chunk(id string, index, count int, data string) substrate.Message {
+	return message.NewEnvelopedMessage(message.Envelope{
+		Payload: []byte(data),
+		Headers: map[string]string{
+			chunker.IDHeader:    id,
+			chunker.IndexHeader: strconv.Itoa(index),
+			chunker.CountHeader: strconv.Itoa(count),
+			"k":                 "v",
+		},
+	})
+}
+
+func consume(ctx context.Context, t *testing.T, source substrate.AsyncMessageSource, count int) (<-chan error, []substrate.Message) {
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	var consumed []substrate.Message
+	for i := 0; i < count; i++ {
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "failed to consume all messages")
+		case err := <-errs:
+			require.FailNow(t, "consumption failed", "%v", err)
+		case msg := <-messages:
+			consumed = append(consumed, msg)
+			acks <- msg
+		}
+	}
+	return errs, consumed
+}
+
+func payloads(messages []substrate.Message) []string {
+	out := make([]string, len(messages))
+	for i, msg := range messages {
+		out[i] = string(msg.Data())
+	}
+	return out
+}
+
+func TestSplit(t *testing.T) {
+	chunks, err := chunker.Split(message.NewEnvelopedMessage(message.Envelope{
+		Payload: []byte("abcdefghij"),
+		Headers: map[string]string{"k": "v"},
+	}), 4)
+	require.NoError(t, err)
+	require.Equal(t, []string{"abcd", "efgh", "ij"}, payloads(chunks))
+
+	id := message.Headers(chunks[0])[chunker.IDHeader]
+	require.NotEmpty(t, id)
+	for i, c := range chunks {
+		require.Equal(t, map[string]string{
+			chunker.IDHeader:    id,
+			chunker.IndexHeader: strconv.Itoa(i),
+			chunker.CountHeader: "3",
+			"k":                 "v",
+		}, message.Headers(c))
+	}
+
+	small := message.FromString("abcd")
+	chunks, err = chunker.Split(small, 4)
+	require.NoError(t, err)
+	require.Equal(t, []substrate.Message{small}, chunks)
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	sink := chunker.NewAsyncMessageSink(message.NewEnvelopeSink(broker.NewAsyncMessageSink("topic")), 4)
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	go sink.PublishMessages(ctx, acks, messages)
+
+	for _, msg := range []substrate.Message{message.FromString("abcdefghij"), message.FromString("abc")} {
+		messages <- msg
+		// The original message is acknowledged once all its chunks are.
+		require.Equal(t, msg, <-acks)
+	}
+	require.Len(t, broker.Messages("topic"), 4)
+
+	source := chunker.NewAsyncMessageSource(message.NewEnvelopeSource(broker.NewAsyncMessageSource("topic", "group")))
+	_, consumed := consume(ctx, t, source, 2)
+	require.Equal(t, []string{"abcdefghij", "abc"}, payloads(consumed))
+	require.Empty(t, message.Headers(consumed[0]))
+}
+
+func TestSource_Interleaved(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	backend := mock.NewSource(
+		chunk("a", 0, 2, "a0"),
+		chunk("b", 1, 2, "b1"),
+		message.FromString("plain"),
+		chunk("b", 0, 2, "b0"),
+		chunk("a", 1, 2, "a1"),
+	)
+	errs, consumed := consume(ctx, t, chunker.NewAsyncMessageSource(backend), 3)
+	require.Equal(t, []string{"plain", "b0b1", "a0a1"}, payloads(consumed))
+	require.Equal(t, map[string]string{"k": "v"}, message.Headers(consumed[1]))
+
+	// The mock source terminates with an error if acknowledgements are out of order.
+	<-backend.AllAcked()
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestSource_InvalidChunk(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source := chunker.NewAsyncMessageSource(mock.NewSource(chunk("a", 0, 2, "a0"), chunk("a", 0, 2, "a0")))
+	err := source.ConsumeMessages(ctx, make(chan substrate.Message), make(chan substrate.Message))
+	require.EqualError(t, err, "invalid chunk 0 of 2 of message a")
+}
+
+func TestSource_Incomplete(t *testing.T) {
+	incomplete := func() *mock.Source {
+		return mock.NewSource(chunk("a", 0, 3, "a0"), message.FromString("plain"), chunk("a", 2, 3, "a2"))
+	}
+
+	t.Run("fail", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		source := chunker.NewAsyncMessageSource(incomplete(), chunker.WithTimeout(time.Millisecond*20))
+		errs, _ := consume(ctx, t, source, 1)
+		err := <-errs
+		var iErr chunker.IncompleteError
+		require.True(t, errors.As(err, &iErr))
+		require.Equal(t, chunker.IncompleteError{ID: "a", Received: 2, Count: 3}, iErr)
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		backend := incomplete()
+		source := chunker.NewAsyncMessageSource(backend, chunker.WithTimeout(time.Millisecond*20), chunker.WithIncompletePolicy(chunker.Drop))
+		errs, consumed := consume(ctx, t, source, 1)
+		require.Equal(t, []string{"plain"}, payloads(consumed))
+
+		// The acknowledgement of the plain message is held back until the chunks are dropped.
+		<-backend.AllAcked()
+		require.Len(t, backend.Acked(), 3)
+		cancel()
+		require.NoError(t, <-errs)
+	})
+
+	t.Run("deliver", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		backend := incomplete()
+		source := chunker.NewAsyncMessageSource(backend, chunker.WithTimeout(time.Millisecond*20), chunker.WithIncompletePolicy(chunker.Deliver))
+		errs, consumed := consume(ctx, t, source, 2)
+		require.Equal(t, []string{"plain", "a0a2"}, payloads(consumed))
+		require.Equal(t, map[string]string{"k": "v", chunker.PartialHeader: "true"}, message.Headers(consumed[1]))
+
+		<-backend.AllAcked()
+		cancel()
+		require.NoError(t, <-errs)
+	})
+}
+
+func TestConformance(t *testing.T) {
+	conformance.TestAsyncSink(t, func(_ *testing.T, backend substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+		return chunker.NewAsyncMessageSink(backend, 1024)
+	})
+	conformance.TestAsyncSource(t, func(_ *testing.T, backend substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		return chunker.NewAsyncMessageSource(backend, chunker.WithTimeout(time.Second))
+	})
+}
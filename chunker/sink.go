@@ -0,0 +1,38 @@
+package chunker
+
+import (
+	"context"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/internal/transform"
+)
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that splits payloads larger than the
+// threshold, in bytes, into chunks no larger than it, which are published in order. The original message is
+// acknowledged once all its chunks are. Smaller messages are published unchanged. The threshold applies to
+// payloads only, so it should leave room for the overhead of headers or envelopes.
+func NewAsyncMessageSink(sink substrate.AsyncMessageSink, threshold int) substrate.AsyncMessageSink {
+	return &chunkSink{
+		sink:      sink,
+		threshold: threshold,
+	}
+}
+
+type chunkSink struct {
+	sink      substrate.AsyncMessageSink
+	threshold int
+}
+
+func (s *chunkSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	return transform.PublishSplit(ctx, "chunker", s.sink, acks, messages, func(_ context.Context, msg substrate.Message) ([]substrate.Message, error) {
+		return Split(msg, s.threshold)
+	})
+}
+
+func (s *chunkSink) Close() error {
+	return s.sink.Close()
+}
+
+func (s *chunkSink) Status() (*substrate.Status, error) {
+	return s.sink.Status()
+}
@@ -0,0 +1,325 @@
+package chunker
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/internal/inorder"
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// IncompletePolicy is what the source does with messages whose chunks weren't all consumed before the timeout.
+type IncompletePolicy int
+
+const (
+	// Fail makes ConsumeMessages return an IncompleteError.
+	Fail IncompletePolicy = iota
+	// Drop acknowledges the consumed chunks without delivering anything.
+	Drop
+	// Deliver delivers the consumed chunks, joined in order, with the PartialHeader header set to true.
+	Deliver
+)
+
+// SourceOption is a function which sets a reassembling source configuration option.
+type SourceOption func(s *reassemblySource)
+
+// WithTimeout sets how long after its first chunk was consumed a message is considered incomplete, in which case
+// the incomplete policy applies. Messages are awaited forever by default, which keeps their chunks unacknowledged
+// if the missing chunks are lost.
+func WithTimeout(timeout time.Duration) SourceOption {
+	return func(s *reassemblySource) {
+		s.timeout = timeout
+	}
+}
+
+// WithIncompletePolicy sets what is done with incomplete messages. The default policy is Fail.
+func WithIncompletePolicy(policy IncompletePolicy) SourceOption {
+	return func(s *reassemblySource) {
+		s.policy = policy
+	}
+}
+
+// WithIncompleteCounter enables a counter of incomplete messages, labelled with the topic.
+// It panics in case it can't register the metric.
+func WithIncompleteCounter(counterOpts prometheus.CounterOpts, topic string) SourceOption {
+	return func(s *reassemblySource) {
+		counter := metrics.Register(prometheus.NewCounterVec(counterOpts, []string{"topic"})).(*prometheus.CounterVec)
+		s.incomplete = counter.WithLabelValues(topic)
+	}
+}
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that reassembles chunked messages.
+// Chunks of different messages may be interleaved. A reassembled message is delivered once all its chunks were
+// consumed, with the headers of the original message, and its chunks are acknowledged once it is. Messages that
+// aren't chunks are delivered unchanged. Acknowledgements are forwarded to the underlying source in the order in
+// which messages were consumed, so an incomplete message holds back the acknowledgements of the messages consumed
+// after it until the timeout.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, opts ...SourceOption) substrate.AsyncMessageSource {
+	s := &reassemblySource{
+		source: source,
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type reassemblySource struct {
+	source     substrate.AsyncMessageSource
+	timeout    time.Duration
+	policy     IncompletePolicy
+	incomplete prometheus.Counter
+	now        func() time.Time
+}
+
+// assembly collects the chunks of a chunked message.
+type assembly struct {
+	id       string
+	started  time.Time
+	headers  map[string]string
+	chunks   map[int][]byte
+	count    int
+	consumed []int
+}
+
+func (s *reassemblySource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "chunker"})
+	rg, ctx := rungroup.New(ctx)
+
+	sourceMsgs := make(chan substrate.Message, cap(messages))
+	sourceAcks := make(chan substrate.Message, cap(acks))
+
+	// Consumed messages are queued in a lane of their own, identified by their sequence number, as the messages
+	// reassembled from them may be acknowledged in any order.
+	queue := inorder.NewQueue[int, substrate.Message]()
+	// dropped receives the sequence numbers of the chunks dropped by the incomplete policy, which are done without
+	// being acknowledged.
+	dropped := make(chan []int)
+	partial := make(map[string]*assembly)
+
+	var expiry <-chan time.Time
+	if s.timeout > 0 {
+		ticker := time.NewTicker(s.timeout / 4)
+		defer ticker.Stop()
+		expiry = ticker.C
+	}
+
+	rg.Go(func() error {
+		return s.source.ConsumeMessages(ctx, sourceMsgs, sourceAcks)
+	})
+	rg.Go(emit.Errors(func() error {
+		var seq int
+		for {
+			var out []*reassembledMessage
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-sourceMsgs:
+				seq++
+				queue.Push(seq, seq, msg)
+
+				rMsg, err := s.reassemble(partial, msg, seq)
+				if err != nil {
+					return err
+				}
+				if rMsg != nil {
+					out = append(out, rMsg)
+				}
+			case <-expiry:
+				expired, err := s.expire(partial)
+				if err != nil {
+					return err
+				}
+				if s.policy == Drop && len(expired) > 0 {
+					var chunks []int
+					for _, a := range expired {
+						chunks = append(chunks, a.consumed...)
+					}
+					select {
+					case <-ctx.Done():
+						return nil
+					case dropped <- chunks:
+					}
+					continue
+				}
+				for _, a := range expired {
+					out = append(out, a.join(true))
+				}
+			}
+			for _, rMsg := range out {
+				emit.Publish(rMsg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case messages <- rMsg:
+				}
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		// complete marks the consumed messages as done, returning the messages that can be acknowledged, or false
+		// if any of them was already done.
+		complete := func(consumed []int) ([]substrate.Message, bool) {
+			var released []substrate.Message
+			for _, seq := range consumed {
+				done, ok := queue.Complete(seq, seq)
+				if !ok {
+					return nil, false
+				}
+				released = append(released, done...)
+			}
+			return released, true
+		}
+
+		for {
+			var released []substrate.Message
+			select {
+			case <-ctx.Done():
+				return nil
+			case chunks := <-dropped:
+				released, _ = complete(chunks)
+			case ack := <-acks:
+				rMsg, ok := ack.(*reassembledMessage)
+				if !ok {
+					return errors.Errorf("unexpected message acknowledged: %v", ack)
+				}
+				if released, ok = complete(rMsg.consumed); !ok {
+					return errors.Errorf("unexpected message acknowledged: %v", ack)
+				}
+				emit.Ack(ack)
+			}
+
+			for _, msg := range released {
+				select {
+				case <-ctx.Done():
+					return nil
+				case sourceAcks <- msg:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+// reassemble returns the message to deliver for the consumed message, or nil if it is a chunk of a message whose
+// other chunks weren't all consumed yet.
+func (s *reassemblySource) reassemble(partial map[string]*assembly, msg substrate.Message, seq int) (*reassembledMessage, error) {
+	headers := message.Headers(msg)
+	id, ok := headers[IDHeader]
+	if !ok {
+		return &reassembledMessage{data: msg.Data(), headers: headers, msg: msg, consumed: []int{seq}}, nil
+	}
+	index, err := strconv.Atoi(headers[IndexHeader])
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid index of chunk of message %s", id)
+	}
+	count, err := strconv.Atoi(headers[CountHeader])
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid count of chunks of message %s", id)
+	}
+	a, ok := partial[id]
+	if !ok {
+		delete(headers, IDHeader)
+		delete(headers, IndexHeader)
+		delete(headers, CountHeader)
+		a = &assembly{id: id, started: s.now(), headers: headers, chunks: make(map[int][]byte), count: count}
+		partial[id] = a
+	}
+	if _, duplicate := a.chunks[index]; duplicate || index < 0 || index >= a.count || count != a.count {
+		return nil, errors.Errorf("invalid chunk %d of %d of message %s", index, count, id)
+	}
+	a.chunks[index] = msg.Data()
+	a.consumed = append(a.consumed, seq)
+	if len(a.chunks) < a.count {
+		return nil, nil
+	}
+	delete(partial, id)
+	return a.join(false), nil
+}
+
+// expire removes the messages whose timeout elapsed, returning them unless the policy is Fail, in which case
+// an error is returned.
+func (s *reassemblySource) expire(partial map[string]*assembly) ([]*assembly, error) {
+	now := s.now()
+	var expired []*assembly
+	for id, a := range partial {
+		if now.Sub(a.started) < s.timeout {
+			continue
+		}
+		if s.incomplete != nil {
+			s.incomplete.Inc()
+		}
+		if s.policy == Fail {
+			return nil, IncompleteError{ID: id, Received: len(a.chunks), Count: a.count}
+		}
+		delete(partial, id)
+		expired = append(expired, a)
+	}
+	// deliver expired messages in the order in which they started
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].started.Before(expired[j].started)
+	})
+	return expired, nil
+}
+
+// join returns the message reassembled from the consumed chunks.
+func (a *assembly) join(partial bool) *reassembledMessage {
+	indexes := make([]int, 0, len(a.chunks))
+	for index := range a.chunks {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	chunks := make([][]byte, len(indexes))
+	for i, index := range indexes {
+		chunks[i] = a.chunks[index]
+	}
+	if partial {
+		a.headers[PartialHeader] = "true"
+	}
+	return &reassembledMessage{data: bytes.Join(chunks, nil), headers: a.headers, consumed: a.consumed}
+}
+
+func (s *reassemblySource) Close() error {
+	return s.source.Close()
+}
+
+func (s *reassemblySource) Status() (*substrate.Status, error) {
+	return s.source.Status()
+}
+
+// reassembledMessage is a message delivered on behalf of the consumed messages it was reassembled from, identified
+// by their sequence numbers.
+type reassembledMessage struct {
+	data     []byte
+	headers  map[string]string
+	msg      substrate.Message
+	consumed []int
+}
+
+func (msg *reassembledMessage) Data() []byte {
+	return msg.data
+}
+
+func (msg *reassembledMessage) Headers() map[string]string {
+	return msg.headers
+}
+
+// DiscardPayload discards the payload.
+func (msg *reassembledMessage) DiscardPayload() {
+	msg.data = nil
+	if dMsg, ok := msg.msg.(substrate.DiscardableMessage); ok {
+		dMsg.DiscardPayload()
+	}
+}
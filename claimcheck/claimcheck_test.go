@@ -0,0 +1,195 @@
+package claimcheck_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/claimcheck"
+	"github.com/uw-labs/substrate-tools/conformance"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+func TestClaimCheck(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	store := claimcheck.NewMemoryStore()
+	sink := claimcheck.NewAsyncMessageSink(message.NewEnvelopeSink(broker.NewAsyncMessageSink("topic")), store, 8)
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	go sink.PublishMessages(ctx, acks, messages)
+
+	large := bytes.Repeat([]byte("payload"), 100)
+	published := []substrate.Message{
+		message.NewEnvelopedMessage(message.Envelope{Payload: large, Headers: map[string]string{"k": "v"}}),
+		message.FromString("small"),
+	}
+	for _, msg := range published {
+		messages <- msg
+		require.Equal(t, msg, <-acks)
+	}
+
+	var (
+		mutex     sync.Mutex
+		collected []string
+	)
+	source := claimcheck.NewAsyncMessageSource(
+		message.NewEnvelopeSource(broker.NewAsyncMessageSource("topic", "group")),
+		store,
+		claimcheck.WithGC(func(ctx context.Context, key string) error {
+			mutex.Lock()
+			collected = append(collected, key)
+			mutex.Unlock()
+			return claimcheck.DeleteBlobs(store)(ctx, key)
+		}),
+	)
+	consumed, sourceAcks := make(chan substrate.Message), make(chan substrate.Message)
+	go source.ConsumeMessages(ctx, consumed, sourceAcks)
+
+	first := <-consumed
+	require.Equal(t, large, first.Data())
+	require.Equal(t, map[string]string{"k": "v"}, message.Headers(first))
+	sourceAcks <- first
+
+	second := <-consumed
+	require.Equal(t, "small", string(second.Data()))
+	sourceAcks <- second
+
+	// The blob of the first message is collected once it is acknowledged.
+	require.Eventually(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(collected) == 1
+	}, time.Second, time.Millisecond*5)
+	_, err := store.Get(ctx, collected[0])
+	require.ErrorIs(t, err, claimcheck.ErrBlobNotFound)
+}
+
+func TestSink_OffloadFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	sink := claimcheck.NewAsyncMessageSink(mock.NewSink(), failingStore{claimcheck.NewMemoryStore()}, 1)
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message, 1)
+	messages <- message.FromString("payload")
+	require.EqualError(t, sink.PublishMessages(ctx, acks, messages), "failed to offload payload: boom")
+}
+
+func TestSource_MissingBlob(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	backend := mock.NewSource(message.NewEnvelopedMessage(message.Envelope{
+		Payload: []byte("key"),
+		Headers: map[string]string{claimcheck.ReferenceHeader: "key"},
+	}))
+	source := claimcheck.NewAsyncMessageSource(backend, claimcheck.NewMemoryStore())
+	err := source.ConsumeMessages(ctx, make(chan substrate.Message), make(chan substrate.Message))
+	require.EqualError(t, err, "failed to fetch payload of blob key: blob not found")
+}
+
+type failingStore struct {
+	claimcheck.BlobStore
+}
+
+func (failingStore) Put(context.Context, string, []byte) error {
+	return errors.New("boom")
+}
+
+// objectClient is an in-memory ObjectClient.
+type objectClient struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+}
+
+var errNoSuchKey = errors.New("no such key")
+
+func (c *objectClient) PutObject(_ context.Context, bucket, key string, body io.Reader, _ int64) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.objects[bucket+"/"+key] = data
+	return nil
+}
+
+func (c *objectClient) GetObject(_ context.Context, bucket, key string) (io.ReadCloser, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	data, ok := c.objects[bucket+"/"+key]
+	if !ok {
+		return nil, errNoSuchKey
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (c *objectClient) DeleteObject(_ context.Context, bucket, key string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.objects[bucket+"/"+key]; !ok {
+		return errNoSuchKey
+	}
+	delete(c.objects, bucket+"/"+key)
+	return nil
+}
+
+func (c *objectClient) IsNotFound(err error) bool {
+	return errors.Is(err, errNoSuchKey)
+}
+
+func TestStores(t *testing.T) {
+	fileStore, err := claimcheck.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	client := &objectClient{objects: make(map[string][]byte)}
+
+	stores := map[string]claimcheck.BlobStore{
+		"memory": claimcheck.NewMemoryStore(),
+		"file":   fileStore,
+		"object": claimcheck.NewObjectStore(client, "bucket", "blobs/"),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, err := store.Get(ctx, "key")
+			require.ErrorIs(t, err, claimcheck.ErrBlobNotFound)
+
+			require.NoError(t, store.Put(ctx, "key", []byte("data")))
+			data, err := store.Get(ctx, "key")
+			require.NoError(t, err)
+			require.Equal(t, "data", string(data))
+
+			require.NoError(t, store.Delete(ctx, "key"))
+			require.NoError(t, store.Delete(ctx, "key"))
+			_, err = store.Get(ctx, "key")
+			require.ErrorIs(t, err, claimcheck.ErrBlobNotFound)
+		})
+	}
+	require.Empty(t, client.objects)
+
+	// Keys can't escape the directory of the file store.
+	require.Error(t, fileStore.Put(context.Background(), "../key", []byte("data")))
+}
+
+func TestConformance(t *testing.T) {
+	conformance.TestAsyncSink(t, func(_ *testing.T, backend substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+		return claimcheck.NewAsyncMessageSink(backend, claimcheck.NewMemoryStore(), 1024)
+	})
+	conformance.TestAsyncSource(t, func(_ *testing.T, backend substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		return claimcheck.NewAsyncMessageSource(backend, claimcheck.NewMemoryStore())
+	})
+}
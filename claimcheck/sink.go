@@ -0,0 +1,89 @@
+// Package claimcheck implements the claim check pattern: a sink wrapper offloads large payloads to a blob store and
+// publishes a reference to them instead, and a source wrapper fetches the payloads back when messages are consumed.
+package claimcheck
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/internal/transform"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+// ReferenceHeader is the header holding the key of the blob of messages whose payload was offloaded. The payload of
+// such messages is the key too, so that consumers without the source wrapper can still identify the blob.
+const ReferenceHeader = "claim-check"
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that stores payloads larger than the
+// threshold, in bytes, in the blob store under a random key, and publishes messages carrying only the key in place
+// of them, with the headers of the original message. Smaller messages are published unchanged. Publishing fails if
+// a payload can't be stored. Blobs of messages that end up not being published aren't deleted.
+func NewAsyncMessageSink(sink substrate.AsyncMessageSink, store BlobStore, threshold int) substrate.AsyncMessageSink {
+	return &claimCheckSink{
+		sink:      sink,
+		store:     store,
+		threshold: threshold,
+	}
+}
+
+type claimCheckSink struct {
+	sink      substrate.AsyncMessageSink
+	store     BlobStore
+	threshold int
+}
+
+func (s *claimCheckSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	return transform.PublishMessages(ctx, "claimcheck", s.sink, acks, messages, s.offload)
+}
+
+// offload returns the message to publish in place of the provided one, storing its payload if it is too large.
+func (s *claimCheckSink) offload(ctx context.Context, msg substrate.Message) (substrate.Message, error) {
+	data := msg.Data()
+	if len(data) <= s.threshold {
+		return msg, nil
+	}
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.Put(ctx, key, data); err != nil {
+		return nil, errors.Wrap(err, "failed to offload payload")
+	}
+	headers := message.Headers(msg)
+	headers[ReferenceHeader] = key
+	return &referenceMessage{key: key, headers: headers}, nil
+}
+
+func (s *claimCheckSink) Close() error {
+	return s.sink.Close()
+}
+
+func (s *claimCheckSink) Status() (*substrate.Status, error) {
+	return s.sink.Status()
+}
+
+func newKey() (string, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return "", errors.Wrap(err, "failed to generate blob key")
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// referenceMessage is published in place of a message whose payload was offloaded.
+type referenceMessage struct {
+	key     string
+	headers map[string]string
+}
+
+func (msg *referenceMessage) Data() []byte {
+	return []byte(msg.key)
+}
+
+func (msg *referenceMessage) Headers() map[string]string {
+	return msg.headers
+}
@@ -0,0 +1,172 @@
+package claimcheck
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// GCFunc is called with the key of the blob of a message once it has been acknowledged, e.g. to delete it. An error
+// returned by it stops consumption.
+type GCFunc func(ctx context.Context, key string) error
+
+// DeleteBlobs returns a GCFunc deleting acknowledged blobs from the store. It should only be used when a single
+// consumer group consumes the messages, as other consumers would fail to fetch deleted blobs.
+func DeleteBlobs(store BlobStore) GCFunc {
+	return func(ctx context.Context, key string) error {
+		return store.Delete(ctx, key)
+	}
+}
+
+// SourceOption is a function which sets a claim check source configuration option.
+type SourceOption func(s *claimCheckSource)
+
+// WithGC sets a function called for every acknowledged message whose payload was offloaded, after the
+// acknowledgement was forwarded to the underlying source.
+func WithGC(gc GCFunc) SourceOption {
+	return func(s *claimCheckSource) {
+		s.gc = gc
+	}
+}
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that fetches the payloads offloaded by
+// the sink from the blob store, delivering messages with their original payload and without the ReferenceHeader
+// header. Other messages are delivered unchanged. Consumption fails if a payload can't be fetched.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, store BlobStore, opts ...SourceOption) substrate.AsyncMessageSource {
+	s := &claimCheckSource{
+		source: source,
+		store:  store,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type claimCheckSource struct {
+	source substrate.AsyncMessageSource
+	store  BlobStore
+	gc     GCFunc
+}
+
+func (s *claimCheckSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "claimcheck"})
+	rg, ctx := rungroup.New(ctx)
+
+	consumed := make(chan substrate.Message, cap(messages))
+	toAck := make(chan substrate.Message, cap(acks))
+
+	rg.Go(func() error {
+		return s.source.ConsumeMessages(ctx, consumed, toAck)
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-consumed:
+				fMsg, err := s.fetch(ctx, msg)
+				if err != nil {
+					return err
+				}
+				emit.Publish(fMsg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case messages <- fMsg:
+				}
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				emit.Ack(ack)
+				var key string
+				if fMsg, ok := ack.(*fetchedMessage); ok {
+					ack, key = fMsg.msg, fMsg.key
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case toAck <- ack:
+				}
+				if key == "" || s.gc == nil {
+					continue
+				}
+				if err := s.gc(ctx, key); err != nil {
+					return errors.Wrapf(err, "failed to collect blob %s", key)
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+// fetch returns the message to deliver in place of the consumed one.
+func (s *claimCheckSource) fetch(ctx context.Context, msg substrate.Message) (substrate.Message, error) {
+	headers := message.Headers(msg)
+	key, ok := headers[ReferenceHeader]
+	if !ok {
+		return msg, nil
+	}
+	data, err := s.store.Get(ctx, key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch payload of blob %s", key)
+	}
+	delete(headers, ReferenceHeader)
+
+	return &fetchedMessage{
+		data:    data,
+		headers: headers,
+		key:     key,
+		msg:     msg,
+	}, nil
+}
+
+func (s *claimCheckSource) Close() error {
+	return s.source.Close()
+}
+
+func (s *claimCheckSource) Status() (*substrate.Status, error) {
+	return s.source.Status()
+}
+
+// fetchedMessage is a message with a fetched payload, delivered on behalf of the consumed message.
+type fetchedMessage struct {
+	data    []byte
+	headers map[string]string
+	key     string
+	msg     substrate.Message
+}
+
+// Data returns the fetched payload.
+func (msg *fetchedMessage) Data() []byte {
+	return msg.data
+}
+
+// Headers returns the headers of the consumed message, without the reference.
+func (msg *fetchedMessage) Headers() map[string]string {
+	return msg.headers
+}
+
+// DiscardPayload discards the payload.
+func (msg *fetchedMessage) DiscardPayload() {
+	msg.data = nil
+	if dMsg, ok := msg.msg.(substrate.DiscardableMessage); ok {
+		dMsg.DiscardPayload()
+	}
+}
+
+func (msg *fetchedMessage) CarriedEnvelope() (message.Envelope, bool) {
+	return message.EnvelopeOf(msg.msg)
+}
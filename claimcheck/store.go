@@ -0,0 +1,193 @@
+package claimcheck
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrBlobNotFound is returned by stores when a blob doesn't exist.
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore stores the payloads offloaded by the sink.
+type BlobStore interface {
+	// Put stores the data under the key.
+	Put(ctx context.Context, key string, data []byte) error
+	// Get returns the data stored under the key, or ErrBlobNotFound if there is none.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Delete deletes the data stored under the key. Deleting a key that doesn't exist isn't an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// NewMemoryStore returns an in-memory store, which is mostly useful for testing.
+func NewMemoryStore() BlobStore {
+	return &memoryStore{
+		blobs: make(map[string][]byte),
+	}
+}
+
+type memoryStore struct {
+	mutex sync.Mutex
+	blobs map[string][]byte
+}
+
+func (s *memoryStore) Put(_ context.Context, key string, data []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.blobs[key] = append([]byte(nil), data...)
+	return nil
+}
+
+func (s *memoryStore) Get(_ context.Context, key string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data, ok := s.blobs[key]
+	if !ok {
+		return nil, ErrBlobNotFound
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.blobs, key)
+	return nil
+}
+
+// NewFileStore returns a store that keeps each blob in a file of the directory, e.g. on a shared volume.
+// Files are written atomically, so that consumers never read a partially written blob.
+func NewFileStore(dir string) (BlobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "failed to create blob directory")
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+type fileStore struct {
+	dir string
+}
+
+func (s *fileStore) Put(_ context.Context, key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".blob-*")
+	if err != nil {
+		return errors.Wrap(err, "failed to write blob")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write blob")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to write blob")
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.Wrap(err, "failed to write blob")
+	}
+	return nil
+}
+
+func (s *fileStore) Get(_ context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return nil, ErrBlobNotFound
+	case err != nil:
+		return nil, errors.Wrap(err, "failed to read blob")
+	}
+	return data, nil
+}
+
+func (s *fileStore) Delete(_ context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to delete blob")
+	}
+	return nil
+}
+
+// path returns the path of the file of the key, which must not escape the directory.
+func (s *fileStore) path(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, `/\`) || key == "." || key == ".." {
+		return "", errors.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.dir, key), nil
+}
+
+// ObjectClient is a minimal client of an S3 compatible object storage, which can be implemented on top of e.g. the
+// AWS SDK or MinIO to store blobs using NewObjectStore.
+type ObjectClient interface {
+	// PutObject uploads the object.
+	PutObject(ctx context.Context, bucket, key string, body io.Reader, size int64) error
+	// GetObject returns the content of the object, or an error for which IsNotFound reports true if it doesn't
+	// exist.
+	GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error)
+	// DeleteObject deletes the object.
+	DeleteObject(ctx context.Context, bucket, key string) error
+	// IsNotFound reports whether the error was returned because the object doesn't exist.
+	IsNotFound(err error) bool
+}
+
+// NewObjectStore returns a store that keeps each blob as an object of the bucket, under the key made of the prefix
+// followed by the key of the blob. A lifecycle rule of the bucket can expire the blobs that weren't collected.
+func NewObjectStore(client ObjectClient, bucket, prefix string) BlobStore {
+	return &objectStore{client: client, bucket: bucket, prefix: prefix}
+}
+
+type objectStore struct {
+	client ObjectClient
+	bucket string
+	prefix string
+}
+
+func (s *objectStore) Put(ctx context.Context, key string, data []byte) error {
+	if err := s.client.PutObject(ctx, s.bucket, s.prefix+key, bytes.NewReader(data), int64(len(data))); err != nil {
+		return errors.Wrap(err, "failed to write blob")
+	}
+	return nil
+}
+
+func (s *objectStore) Get(ctx context.Context, key string) ([]byte, error) {
+	body, err := s.client.GetObject(ctx, s.bucket, s.prefix+key)
+	switch {
+	case err != nil && s.client.IsNotFound(err):
+		return nil, ErrBlobNotFound
+	case err != nil:
+		return nil, errors.Wrap(err, "failed to read blob")
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read blob")
+	}
+	return data, nil
+}
+
+func (s *objectStore) Delete(ctx context.Context, key string) error {
+	if err := s.client.DeleteObject(ctx, s.bucket, s.prefix+key); err != nil && !s.client.IsNotFound(err) {
+		return errors.Wrap(err, "failed to delete blob")
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package cloudevents_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/cloudevents"
+	"github.com/uw-labs/substrate-tools/conformance"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+type headeredMessage struct {
+	*message.Message
+	headers map[string]string
+}
+
+func (m *headeredMessage) Headers() map[string]string {
+	return m.headers
+}
+
+func publish(ctx context.Context, t *testing.T, sink substrate.AsyncMessageSink, published ...substrate.Message) {
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	go sink.PublishMessages(ctx, acks, messages)
+
+	for _, msg := range published {
+		messages <- msg
+		require.Equal(t, msg, <-acks)
+	}
+}
+
+func TestStructuredMode(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	sink := cloudevents.NewAsyncMessageSink(broker.NewAsyncMessageSink("orders"), "orders",
+		cloudevents.WithType("com.example.order"),
+		cloudevents.WithContentType("application/json"),
+	)
+	publish(ctx, t, sink,
+		&headeredMessage{Message: message.FromString(`{"id":1}`), headers: map[string]string{"Trace-Parent": "trace"}},
+		message.NewEnvelopedMessage(message.Envelope{ID: "id-2", ContentType: "text/plain", Payload: []byte("plain")}),
+	)
+
+	var raw map[string]interface{}
+	require.NoError(t, json.Unmarshal(broker.Messages("orders")[0], &raw))
+	require.Equal(t, "1.0", raw["specversion"])
+	require.Equal(t, "orders", raw["source"])
+	require.Equal(t, "com.example.order", raw["type"])
+	require.Equal(t, "trace", raw["traceparent"])
+	require.Equal(t, map[string]interface{}{"id": float64(1)}, raw["data"])
+	require.NoError(t, json.Unmarshal(broker.Messages("orders")[1], &raw))
+	require.Equal(t, "cGxhaW4=", raw["data_base64"])
+
+	source := cloudevents.NewAsyncMessageSource(broker.NewAsyncMessageSource("orders", "group"))
+	consumed, acks := make(chan substrate.Message), make(chan substrate.Message)
+	go source.ConsumeMessages(ctx, consumed, acks)
+
+	first := (<-consumed).(*cloudevents.EventMessage)
+	require.Equal(t, `{"id":1}`, string(first.Data()))
+	require.NotEmpty(t, first.ID)
+	require.False(t, first.Time.IsZero())
+	require.Equal(t, "orders", first.Source)
+	require.Equal(t, map[string]string{"traceparent": "trace"}, message.Headers(first))
+	acks <- first
+
+	second := (<-consumed).(*cloudevents.EventMessage)
+	require.Equal(t, "plain", string(second.Data()))
+	require.Equal(t, "id-2", second.ID)
+	require.Equal(t, "text/plain", second.DataContentType)
+	acks <- second
+}
+
+func TestBinaryMode(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	// The memory broker doesn't keep headers, so the published messages are captured instead.
+	var (
+		mu       sync.Mutex
+		captured []substrate.Message
+	)
+	backend := mock.NewSink().AckWith(func(_ int, msg substrate.Message) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		captured = append(captured, msg)
+		return true
+	})
+	sink := cloudevents.NewAsyncMessageSink(backend, "orders", cloudevents.WithMode(cloudevents.Binary))
+	publish(ctx, t, sink, cloudevents.NewEventMessage(cloudevents.Event{
+		ID:              "id-1",
+		Source:          "/orders",
+		Type:            "com.example.order",
+		Subject:         "order-1",
+		DataContentType: "application/octet-stream",
+		Extensions:      map[string]string{"partitionkey": "1"},
+		Data:            []byte{0, 1},
+	}))
+
+	mu.Lock()
+	headers := message.Headers(captured[0])
+	mu.Unlock()
+	require.Equal(t, []byte{0, 1}, captured[0].Data())
+	require.Equal(t, "id-1", headers["ce_id"])
+	require.Equal(t, "1.0", headers["ce_specversion"])
+	require.Equal(t, "order-1", headers["ce_subject"])
+	require.Equal(t, "1", headers["ce_partitionkey"])
+	require.Equal(t, "application/octet-stream", headers["content-type"])
+
+	backendSource := mock.NewSource(captured...)
+	source := cloudevents.NewAsyncMessageSource(backendSource)
+	consumed, acks := make(chan substrate.Message), make(chan substrate.Message)
+	go source.ConsumeMessages(ctx, consumed, acks)
+
+	event := (<-consumed).(*cloudevents.EventMessage)
+	require.Equal(t, []byte{0, 1}, event.Data())
+	require.Equal(t, "/orders", event.Source)
+	require.Equal(t, "order-1", event.Subject)
+	require.Equal(t, "application/octet-stream", event.DataContentType)
+	require.Equal(t, map[string]string{"partitionkey": "1"}, event.Extensions)
+	acks <- event
+	<-backendSource.AllAcked()
+}
+
+func TestSource_InvalidEvent(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	for _, payload := range []string{
+		"not an event",
+		`{"specversion":"1.0","source":"orders","type":"t"}`,
+		`{"specversion":"0.3","id":"1","source":"orders","type":"t"}`,
+	} {
+		source := cloudevents.NewAsyncMessageSource(mock.NewSource(message.FromString(payload)))
+		err := source.ConsumeMessages(ctx, make(chan substrate.Message), make(chan substrate.Message))
+		require.Error(t, err, payload)
+	}
+}
+
+func TestSink_Conformance(t *testing.T) {
+	conformance.TestAsyncSink(t, func(_ *testing.T, backend substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+		return cloudevents.NewAsyncMessageSink(backend, "topic", cloudevents.WithMode(cloudevents.Binary))
+	})
+}
@@ -0,0 +1,322 @@
+// Package cloudevents provides message sink and source wrappers that encode and decode payloads as CloudEvents,
+// in either the structured JSON mode or the binary mode, for interoperability with CloudEvents based consumers.
+package cloudevents
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+)
+
+// SpecVersion is the version of the CloudEvents specification events are produced with.
+const SpecVersion = "1.0"
+
+const (
+	// HeaderPrefix prefixes the names of the headers carrying the attributes of events in the binary mode,
+	// as defined by the Kafka protocol binding.
+	HeaderPrefix = "ce_"
+	// ContentTypeHeader is the header carrying the content type of the data of events in the binary mode.
+	ContentTypeHeader = "content-type"
+)
+
+// Mode is the way in which events are encoded in messages.
+type Mode int
+
+const (
+	// Structured encodes the attributes and the data of events together as a JSON document.
+	Structured Mode = iota
+	// Binary encodes the attributes of events as message headers, and the data as the payload.
+	// It requires the backend to support message headers.
+	Binary
+)
+
+// Event is a CloudEvent.
+type Event struct {
+	// ID identifies the event within its source.
+	ID string
+	// Source identifies the context in which the event happened.
+	Source string
+	// SpecVersion is the version of the CloudEvents specification.
+	SpecVersion string
+	// Type describes the type of the event.
+	Type string
+	// Subject describes the subject of the event in the context of its source, optional.
+	Subject string
+	// Time is the time at which the event happened, optional.
+	Time time.Time
+	// DataContentType is the content type of the data, optional.
+	DataContentType string
+	// DataSchema identifies the schema the data adheres to, optional.
+	DataSchema string
+	// Extensions are the extension attributes of the event, such as the trace context.
+	Extensions map[string]string
+	// Data is the payload of the event.
+	Data []byte
+}
+
+// EventMessage is a message carrying a CloudEvent. Messages consumed from the source are of this type, while
+// messages of this type published to the sink keep their attributes. Its headers are the extension attributes
+// of the event.
+type EventMessage struct {
+	Event
+
+	msg substrate.Message
+}
+
+// NewEventMessage returns a new message carrying the event.
+func NewEventMessage(event Event) *EventMessage {
+	return &EventMessage{Event: event}
+}
+
+// Data returns the data of the event.
+func (msg *EventMessage) Data() []byte {
+	return msg.Event.Data
+}
+
+// Headers returns the extension attributes of the event.
+func (msg *EventMessage) Headers() map[string]string {
+	return msg.Extensions
+}
+
+// DiscardPayload discards the data of the event.
+func (msg *EventMessage) DiscardPayload() {
+	msg.Event.Data = nil
+	if dMsg, ok := msg.msg.(substrate.DiscardableMessage); ok {
+		dMsg.DiscardPayload()
+	}
+}
+
+// Context attributes of events, other attributes are extensions.
+const (
+	attrID              = "id"
+	attrSource          = "source"
+	attrSpecVersion     = "specversion"
+	attrType            = "type"
+	attrSubject         = "subject"
+	attrTime            = "time"
+	attrDataContentType = "datacontenttype"
+	attrDataSchema      = "dataschema"
+	attrData            = "data"
+	attrDataBase64      = "data_base64"
+)
+
+var reserved = map[string]bool{
+	attrID:              true,
+	attrSource:          true,
+	attrSpecVersion:     true,
+	attrType:            true,
+	attrSubject:         true,
+	attrTime:            true,
+	attrDataContentType: true,
+	attrDataSchema:      true,
+	attrData:            true,
+	attrDataBase64:      true,
+}
+
+// attributes returns the attributes of the event, excluding its data.
+func (e *Event) attributes() map[string]string {
+	attrs := make(map[string]string, len(e.Extensions)+8)
+	for name, value := range e.Extensions {
+		attrs[name] = value
+	}
+	attrs[attrID] = e.ID
+	attrs[attrSource] = e.Source
+	attrs[attrSpecVersion] = e.SpecVersion
+	attrs[attrType] = e.Type
+	for name, value := range map[string]string{
+		attrSubject:         e.Subject,
+		attrDataContentType: e.DataContentType,
+		attrDataSchema:      e.DataSchema,
+	} {
+		if value != "" {
+			attrs[name] = value
+		}
+	}
+	if !e.Time.IsZero() {
+		attrs[attrTime] = e.Time.Format(time.RFC3339Nano)
+	}
+	return attrs
+}
+
+// setAttribute sets the attribute of the event, returning an error if its value is invalid.
+func (e *Event) setAttribute(name, value string) error {
+	switch name {
+	case attrID:
+		e.ID = value
+	case attrSource:
+		e.Source = value
+	case attrSpecVersion:
+		e.SpecVersion = value
+	case attrType:
+		e.Type = value
+	case attrSubject:
+		e.Subject = value
+	case attrDataContentType:
+		e.DataContentType = value
+	case attrDataSchema:
+		e.DataSchema = value
+	case attrTime:
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return errors.Wrap(err, "invalid event time")
+		}
+		e.Time = t
+	default:
+		if e.Extensions == nil {
+			e.Extensions = make(map[string]string)
+		}
+		e.Extensions[name] = value
+	}
+	return nil
+}
+
+// validate checks that the required attributes are set.
+func (e *Event) validate() error {
+	switch {
+	case e.SpecVersion != SpecVersion:
+		return errors.Errorf("unsupported CloudEvents spec version %q", e.SpecVersion)
+	case e.ID == "":
+		return errors.New("event has no id")
+	case e.Source == "":
+		return errors.New("event has no source")
+	case e.Type == "":
+		return errors.New("event has no type")
+	}
+	return nil
+}
+
+// encodeStructured encodes the event in the structured JSON mode. JSON data is embedded as is, other data is
+// base64 encoded.
+func encodeStructured(e *Event) ([]byte, error) {
+	doc := make(map[string]interface{})
+	for name, value := range e.attributes() {
+		doc[name] = value
+	}
+	if e.Data != nil {
+		if isJSON(e.DataContentType) && json.Valid(e.Data) {
+			doc[attrData] = json.RawMessage(e.Data)
+		} else {
+			doc[attrDataBase64] = base64.StdEncoding.EncodeToString(e.Data)
+		}
+	}
+	return json.Marshal(doc)
+}
+
+// decodeStructured decodes an event encoded in the structured JSON mode. Extension attributes which aren't
+// strings are kept in their JSON representation.
+func decodeStructured(data []byte) (Event, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return Event{}, errors.Wrap(err, "failed to decode structured event")
+	}
+
+	var e Event
+	for name, raw := range doc {
+		if name == attrData || name == attrDataBase64 {
+			continue
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			value = string(raw)
+		}
+		if err := e.setAttribute(name, value); err != nil {
+			return Event{}, err
+		}
+	}
+
+	if raw, ok := doc[attrDataBase64]; ok {
+		var encoded string
+		if err := json.Unmarshal(raw, &encoded); err != nil {
+			return Event{}, errors.Wrap(err, "invalid event data")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return Event{}, errors.Wrap(err, "invalid event data")
+		}
+		e.Data = decoded
+	} else if raw, ok := doc[attrData]; ok {
+		// strings are the data itself unless the data is JSON
+		var str string
+		if !isJSON(e.DataContentType) && json.Unmarshal(raw, &str) == nil {
+			e.Data = []byte(str)
+		} else {
+			e.Data = []byte(raw)
+		}
+	}
+	return e, e.validate()
+}
+
+// binaryHeaders returns the headers carrying the attributes of the event in the binary mode.
+func binaryHeaders(e *Event) map[string]string {
+	headers := make(map[string]string)
+	for name, value := range e.attributes() {
+		if name == attrDataContentType {
+			headers[ContentTypeHeader] = value
+			continue
+		}
+		headers[HeaderPrefix+name] = value
+	}
+	return headers
+}
+
+// isBinary returns whether the headers carry an event in the binary mode.
+func isBinary(headers map[string]string) bool {
+	_, ok := headers[HeaderPrefix+attrSpecVersion]
+	return ok
+}
+
+// decodeBinary decodes an event encoded in the binary mode. Headers without the prefix are ignored.
+func decodeBinary(headers map[string]string, data []byte) (Event, error) {
+	e := Event{Data: data}
+	for name, value := range headers {
+		if name == ContentTypeHeader {
+			e.DataContentType = value
+			continue
+		}
+		if !strings.HasPrefix(name, HeaderPrefix) {
+			continue
+		}
+		if err := e.setAttribute(strings.TrimPrefix(name, HeaderPrefix), value); err != nil {
+			return Event{}, err
+		}
+	}
+	return e, e.validate()
+}
+
+// isJSON returns whether the content type denotes JSON data. Events without a content type are assumed
+// to carry JSON data.
+func isJSON(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// extensionName converts a header name into a valid extension attribute name, made of lowercase letters and
+// digits. It returns an empty name if nothing is left or the name is reserved.
+func extensionName(header string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return -1
+		}
+	}, header)
+	if reserved[name] {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,226 @@
+package cloudevents
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// DefaultType is the type of events published by the sink, unless set using the WithType option.
+const DefaultType = "substrate.message"
+
+// SinkOption is a function which sets a CloudEvents sink configuration option.
+type SinkOption func(s *eventSink)
+
+// WithMode sets the mode in which events are encoded. The default is Structured.
+func WithMode(mode Mode) SinkOption {
+	return func(s *eventSink) {
+		s.mode = mode
+	}
+}
+
+// WithSource sets the source attribute of events which don't specify one. The default is the topic.
+func WithSource(source string) SinkOption {
+	return func(s *eventSink) {
+		s.source = source
+	}
+}
+
+// WithType sets the type attribute of events which don't specify one. The default is DefaultType.
+func WithType(eventType string) SinkOption {
+	return func(s *eventSink) {
+		s.eventType = eventType
+	}
+}
+
+// WithContentType sets the content type of the data of events which don't specify one.
+func WithContentType(contentType string) SinkOption {
+	return func(s *eventSink) {
+		s.contentType = contentType
+	}
+}
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that publishes each message as a
+// CloudEvent, whose source attribute defaults to the topic. Messages of type EventMessage keep their attributes,
+// while the metadata of messages carrying an envelope, see message.EnvelopeOf, is mapped to the corresponding attributes.
+// The headers of messages implementing message.HeaderedMessage are recorded as extension attributes, with their
+// names reduced to lowercase letters and digits. Missing IDs and times are generated.
+func NewAsyncMessageSink(sink substrate.AsyncMessageSink, topic string, opts ...SinkOption) substrate.AsyncMessageSink {
+	s := &eventSink{
+		sink:      sink,
+		source:    topic,
+		eventType: DefaultType,
+		now:       time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type eventSink struct {
+	sink        substrate.AsyncMessageSink
+	mode        Mode
+	source      string
+	eventType   string
+	contentType string
+	now         func() time.Time
+}
+
+func (s *eventSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "cloudevents"})
+	rg, ctx := rungroup.New(ctx)
+
+	toPublish := make(chan substrate.Message, cap(messages))
+	published := make(chan substrate.Message, cap(acks))
+
+	rg.Go(func() error {
+		return s.sink.PublishMessages(ctx, published, toPublish)
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-messages:
+				emit.Publish(msg)
+				encoded, err := s.encode(msg)
+				if err != nil {
+					return err
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case toPublish <- encoded:
+				}
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-published:
+				encoded, ok := ack.(*encodedMessage)
+				if !ok {
+					return errors.Errorf("unexpected message type: %T", ack)
+				}
+				emit.Ack(encoded.msg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case acks <- encoded.msg:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+// encode returns the message to publish in place of the provided one.
+func (s *eventSink) encode(msg substrate.Message) (*encodedMessage, error) {
+	event, err := s.event(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.mode == Binary {
+		return &encodedMessage{data: event.Data, headers: binaryHeaders(&event), msg: msg}, nil
+	}
+	data, err := encodeStructured(&event)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode event")
+	}
+	return &encodedMessage{data: data, msg: msg}, nil
+}
+
+// event returns the event the message is published as.
+func (s *eventSink) event(msg substrate.Message) (Event, error) {
+	var event Event
+	if eMsg, ok := msg.(*EventMessage); ok {
+		event = eMsg.Event
+	} else if envelope, ok := message.EnvelopeOf(msg); ok {
+		event = Event{
+			ID:              envelope.ID,
+			Time:            envelope.Timestamp,
+			DataContentType: envelope.ContentType,
+			Data:            envelope.Payload,
+		}
+	} else {
+		event.Data = msg.Data()
+	}
+
+	extensions := make(map[string]string, len(event.Extensions))
+	for name, value := range message.Headers(msg) {
+		if name = extensionName(name); name != "" {
+			extensions[name] = value
+		}
+	}
+	event.Extensions = extensions
+
+	if event.ID == "" {
+		id, err := newID()
+		if err != nil {
+			return Event{}, err
+		}
+		event.ID = id
+	}
+	if event.Time.IsZero() {
+		event.Time = s.now().UTC()
+	}
+	if event.SpecVersion == "" {
+		event.SpecVersion = SpecVersion
+	}
+	if event.Source == "" {
+		event.Source = s.source
+	}
+	if event.Type == "" {
+		event.Type = s.eventType
+	}
+	if event.DataContentType == "" {
+		event.DataContentType = s.contentType
+	}
+	return event, event.validate()
+}
+
+func (s *eventSink) Close() error {
+	return s.sink.Close()
+}
+
+func (s *eventSink) Status() (*substrate.Status, error) {
+	return s.sink.Status()
+}
+
+// encodedMessage is an encoded event published on behalf of the original message.
+type encodedMessage struct {
+	data    []byte
+	headers map[string]string
+	msg     substrate.Message
+}
+
+func (msg *encodedMessage) Data() []byte {
+	return msg.data
+}
+
+// Headers returns the attributes of events in the binary mode.
+func (msg *encodedMessage) Headers() map[string]string {
+	return msg.headers
+}
+
+func newID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", errors.Wrap(err, "failed to generate event ID")
+	}
+	return hex.EncodeToString(id), nil
+}
@@ -0,0 +1,94 @@
+package cloudevents
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that decodes CloudEvents. Messages
+// whose headers carry the spec version attribute are decoded in the binary mode, others in the structured mode.
+// Consumed messages are of type EventMessage, giving access to the attributes of the event. Consumption fails if
+// a message is not a valid event.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+	return &eventSource{
+		source: source,
+	}
+}
+
+type eventSource struct {
+	source substrate.AsyncMessageSource
+}
+
+func (s *eventSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "cloudevents"})
+	rg, ctx := rungroup.New(ctx)
+
+	consumed := make(chan substrate.Message, cap(messages))
+	toAck := make(chan substrate.Message, cap(acks))
+
+	rg.Go(func() error {
+		return s.source.ConsumeMessages(ctx, consumed, toAck)
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-consumed:
+				event, err := decode(msg)
+				if err != nil {
+					return errors.Wrap(err, "failed to decode event")
+				}
+				eMsg := &EventMessage{Event: event, msg: msg}
+				emit.Publish(eMsg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case messages <- eMsg:
+				}
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				eMsg, ok := ack.(*EventMessage)
+				if !ok || eMsg.msg == nil {
+					return errors.Errorf("unexpected message acknowledged: %v", ack)
+				}
+				emit.Ack(ack)
+				select {
+				case <-ctx.Done():
+					return nil
+				case toAck <- eMsg.msg:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+func decode(msg substrate.Message) (Event, error) {
+	if headers := message.Headers(msg); isBinary(headers) {
+		return decodeBinary(headers, msg.Data())
+	}
+	return decodeStructured(msg.Data())
+}
+
+func (s *eventSource) Close() error {
+	return s.source.Close()
+}
+
+func (s *eventSource) Status() (*substrate.Status, error) {
+	return s.source.Status()
+}
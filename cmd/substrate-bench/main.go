@@ -0,0 +1,120 @@
+// Command substrate-bench benchmarks a substrate backend by publishing messages to a sink URL and consuming them
+// from a source URL, reporting throughput and latency percentiles.
+//
+// Usage:
+//
+//	substrate-bench [flags] <sink-url> <source-url>
+//
+// For example:
+//
+//	substrate-bench -duration 30s -concurrency 100 -size uniform:100-10000 \
+//		"kafka://localhost:9092/bench" "kafka://localhost:9092/bench?consumer-group=bench&offset=newest"
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/uw-labs/substrate/suburl"
+
+	"github.com/uw-labs/substrate-tools/bench"
+
+	_ "github.com/uw-labs/substrate/kafka"
+	_ "github.com/uw-labs/substrate/natsstreaming"
+	_ "github.com/uw-labs/substrate/proximo"
+)
+
+type config struct {
+	sinkURL      string
+	sourceURL    string
+	size         string
+	concurrency  int
+	duration     time.Duration
+	messages     int
+	drainTimeout time.Duration
+	seed         int64
+	pushURL      string
+	pushJob      string
+}
+
+func main() {
+	cfg := config{}
+	flag.StringVar(&cfg.size, "size", "1024", "payload size distribution, one of <size>, fixed:<size>, uniform:<min>-<max> or normal:<mean>,<stddev>")
+	flag.IntVar(&cfg.concurrency, "concurrency", 1, "maximum number of messages published but not acknowledged yet")
+	flag.DurationVar(&cfg.duration, "duration", time.Second*10, "for how long to publish messages, unlimited if 0")
+	flag.IntVar(&cfg.messages, "n", 0, "number of messages to publish, unlimited if 0")
+	flag.DurationVar(&cfg.drainTimeout, "drain-timeout", time.Second*10, "for how long to wait for published messages to be consumed")
+	flag.Int64Var(&cfg.seed, "seed", time.Now().UnixNano(), "seed of the random payload sizes")
+	flag.StringVar(&cfg.pushURL, "push-url", "", "URL of a prometheus push gateway to push metrics to once done")
+	flag.StringVar(&cfg.pushJob, "push-job", "substrate-bench", "job name used when pushing metrics")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <sink-url> <source-url>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cfg.sinkURL, cfg.sourceURL = flag.Arg(0), flag.Arg(1)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if err := run(ctx, cfg, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "substrate-bench:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, cfg config, out io.Writer) error {
+	sizes, err := bench.ParseSizeDistribution(cfg.size)
+	if err != nil {
+		return err
+	}
+
+	sink, err := suburl.NewSink(cfg.sinkURL)
+	if err != nil {
+		return errors.Wrap(err, "failed to create sink")
+	}
+	defer sink.Close()
+
+	source, err := suburl.NewSource(cfg.sourceURL)
+	if err != nil {
+		return errors.Wrap(err, "failed to create source")
+	}
+	defer source.Close()
+
+	registry := prometheus.NewRegistry()
+	report, err := bench.Run(ctx, sink, source, bench.Config{
+		Sizes:        sizes,
+		Concurrency:  cfg.concurrency,
+		Duration:     cfg.duration,
+		Messages:     cfg.messages,
+		DrainTimeout: cfg.drainTimeout,
+		Seed:         cfg.seed,
+		Registerer:   registry,
+	})
+	if err != nil {
+		return err
+	}
+	if err := report.Write(out); err != nil {
+		return errors.Wrap(err, "failed to write report")
+	}
+
+	if cfg.pushURL != "" {
+		if err := push.New(cfg.pushURL, cfg.pushJob).Gatherer(registry).Push(); err != nil {
+			return errors.Wrap(err, "failed to push metrics")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// decodeProto is the prefix of decoders of protobuf payloads, followed by the path of a file descriptor set,
+// as written by protoc --descriptor_set_out --include_imports, and the full name of the message type, e.g.
+// proto:orders.pb:acme.orders.v1.Order.
+const decodeProto = "proto:"
+
+// decoder converts payloads to JSON, so that they can be filtered and printed like JSON payloads.
+type decoder func(payload []byte) ([]byte, error)
+
+func newDecoder(decode string) (decoder, error) {
+	if decode == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(decode, decodeProto) {
+		return nil, errors.Errorf("unknown decoder %q", decode)
+	}
+	spec := strings.TrimPrefix(decode, decodeProto)
+	i := strings.LastIndex(spec, ":")
+	if i < 0 {
+		return nil, errors.Errorf("invalid decoder %q, expected proto:<descriptor-set>:<message>", decode)
+	}
+	desc, err := loadMessageDescriptor(spec[:i], spec[i+1:])
+	if err != nil {
+		return nil, err
+	}
+	return protoDecoder(desc), nil
+}
+
+// loadMessageDescriptor returns the descriptor of the message type from the file descriptor set.
+func loadMessageDescriptor(path, name string) (protoreflect.MessageDescriptor, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read descriptor set")
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, errors.Wrap(err, "invalid descriptor set")
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid descriptor set")
+	}
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to find message %s", name)
+	}
+	msgDesc, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, errors.Errorf("%s is not a message", name)
+	}
+	return msgDesc, nil
+}
+
+// protoDecoder returns a decoder of binary protobuf payloads of the message type into their JSON mapping.
+func protoDecoder(desc protoreflect.MessageDescriptor) decoder {
+	return func(payload []byte) ([]byte, error) {
+		msg := dynamicpb.NewMessage(desc)
+		if err := proto.Unmarshal(payload, msg); err != nil {
+			return nil, errors.Wrapf(err, "failed to decode %s", desc.FullName())
+		}
+		data, err := protojson.Marshal(msg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode %s", desc.FullName())
+		}
+		// the output of protojson is deliberately unstable, it is compacted so that it can be relied on
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, data); err != nil {
+			return nil, errors.Wrapf(err, "failed to decode %s", desc.FullName())
+		}
+		return buf.Bytes(), nil
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// filter is a predicate over JSON payloads, parsed from an expression such as
+//
+//	user.age >= 18 && (status == "active" || tags.0 =~ "^vip")
+//
+// Operands are dot separated paths of fields of the payload, array elements being selected by their index, or
+// literal strings, numbers, booleans and null. Fields that don't exist are null. Comparisons of operands of
+// different types are false, except for !=. A path on its own is true unless it is null, false, 0 or "".
+type filter struct {
+	expr expr
+}
+
+func newFilter(expression string) (*filter, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid filter")
+	}
+	p := &parser{tokens: tokens}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid filter")
+	}
+	if !p.done() {
+		return nil, errors.Errorf("invalid filter: unexpected %q", p.peek().text)
+	}
+	return &filter{expr: e}, nil
+}
+
+// match reports whether the payload matches the filter.
+func (f *filter) match(payload []byte) (bool, error) {
+	var value interface{}
+	if err := json.Unmarshal(payload, &value); err != nil {
+		return false, errors.Wrap(err, "failed to filter message, payload is not valid JSON")
+	}
+	return truthy(f.expr.eval(value)), nil
+}
+
+type expr interface {
+	// eval returns the value of the expression for the decoded payload.
+	eval(payload interface{}) interface{}
+}
+
+type pathExpr []string
+
+func (e pathExpr) eval(payload interface{}) interface{} {
+	value := payload
+	for _, field := range e {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[field]
+		case []interface{}:
+			i, err := strconv.Atoi(field)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil
+			}
+			value = v[i]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+type literalExpr struct {
+	value interface{}
+}
+
+func (e literalExpr) eval(interface{}) interface{} {
+	return e.value
+}
+
+type notExpr struct {
+	expr expr
+}
+
+func (e notExpr) eval(payload interface{}) interface{} {
+	return !truthy(e.expr.eval(payload))
+}
+
+type logicalExpr struct {
+	and         bool
+	left, right expr
+}
+
+func (e logicalExpr) eval(payload interface{}) interface{} {
+	left := truthy(e.left.eval(payload))
+	if e.and != left {
+		// the result is known without evaluating the right operand
+		return left
+	}
+	return truthy(e.right.eval(payload))
+}
+
+type compareExpr struct {
+	op          string
+	left, right expr
+}
+
+func (e compareExpr) eval(payload interface{}) interface{} {
+	left, right := e.left.eval(payload), e.right.eval(payload)
+	switch e.op {
+	case "==":
+		return equal(left, right)
+	case "!=":
+		return !equal(left, right)
+	}
+
+	var cmp int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case l < r:
+			cmp = -1
+		case l > r:
+			cmp = 1
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		return false
+	}
+	switch e.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+type matchExpr struct {
+	expr   expr
+	regexp *regexp.Regexp
+}
+
+func (e matchExpr) eval(payload interface{}) interface{} {
+	s, ok := e.expr.eval(payload).(string)
+	return ok && e.regexp.MatchString(s)
+}
+
+func equal(left, right interface{}) bool {
+	switch left.(type) {
+	case map[string]interface{}, []interface{}:
+		// objects and arrays are compared by their encoding, as keys of objects are sorted
+		l, lErr := json.Marshal(left)
+		r, rErr := json.Marshal(right)
+		return lErr == nil && rErr == nil && string(l) == string(r)
+	}
+	switch right.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	}
+	return left == right
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	default:
+		return true
+	}
+}
+
+type tokenKind int
+
+const (
+	tokenOperator tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// operators are ordered so that longer operators are matched first.
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "<", ">", "!", "(", ")"}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(s) && s[end] != '"'; end++ {
+				if s[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(s) {
+				return nil, errors.New("unterminated string")
+			}
+			value, err := strconv.Unquote(s[i : end+1])
+			if err != nil {
+				return nil, errors.Errorf("invalid string %s", s[i:end+1])
+			}
+			tokens = append(tokens, token{kind: tokenString, text: value})
+			i = end + 1
+		case c == '-' || c >= '0' && c <= '9':
+			end := i + 1
+			for end < len(s) && strings.ContainsRune("0123456789.eE+-", rune(s[end])) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: s[i:end]})
+			i = end
+		case c == '_' || unicode.IsLetter(rune(c)):
+			end := i + 1
+			for end < len(s) && (s[end] == '_' || s[end] == '.' || s[end] == '-' || unicode.IsLetter(rune(s[end])) || unicode.IsDigit(rune(s[end]))) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: s[i:end]})
+			i = end
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(s[i:], op) {
+					tokens = append(tokens, token{kind: tokenOperator, text: op})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, errors.Errorf("unexpected character %q", c)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// parser is a recursive descent parser of filter expressions, && taking precedence over ||.
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *parser) peek() token {
+	if p.done() {
+		return token{kind: tokenOperator, text: "end of expression"}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) accept(op string) bool {
+	if t := p.peek(); !p.done() && t.kind == tokenOperator && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalExpr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalExpr{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	if p.accept("!") {
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{expr: e}, nil
+	}
+	if p.accept("(") {
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, errors.Errorf("expected \")\", got %q", p.peek().text)
+		}
+		return e, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (expr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.accept("=~") {
+		t := p.peek()
+		if p.done() || t.kind != tokenString {
+			return nil, errors.Errorf("expected regular expression string, got %q", t.text)
+		}
+		p.pos++
+		re, err := regexp.Compile(t.text)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid regular expression")
+		}
+		return matchExpr{expr: left, regexp: re}, nil
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			right, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			return compareExpr{op: op, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseOperand() (expr, error) {
+	t := p.peek()
+	if p.done() {
+		return nil, errors.New("unexpected end of expression")
+	}
+	switch t.kind {
+	case tokenString:
+		p.pos++
+		return literalExpr{value: t.text}, nil
+	case tokenNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, errors.Errorf("invalid number %s", t.text)
+		}
+		p.pos++
+		return literalExpr{value: n}, nil
+	case tokenIdent:
+		p.pos++
+		switch t.text {
+		case "true":
+			return literalExpr{value: true}, nil
+		case "false":
+			return literalExpr{value: false}, nil
+		case "null":
+			return literalExpr{value: nil}, nil
+		}
+		return pathExpr(strings.Split(t.text, ".")), nil
+	default:
+		return nil, errors.Errorf("unexpected %q", t.text)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Supported output formats.
+const (
+	formatRaw  = "raw"
+	formatHex  = "hex"
+	formatJSON = "json"
+)
+
+// formatter formats message payloads for printing, optionally decoding them and skipping those that don't
+// match a filter first.
+type formatter struct {
+	format string
+	key    []string
+	decode decoder
+	filter *filter
+}
+
+func newFormatter(format, key string) (*formatter, error) {
+	switch format {
+	case formatRaw, formatHex, formatJSON:
+	default:
+		return nil, errors.Errorf("unknown format %q", format)
+	}
+	f := &formatter{format: format}
+	if key != "" {
+		f.key = strings.Split(key, ".")
+	}
+	return f, nil
+}
+
+// process returns the formatted payload, after decoding it, and whether it matches the filter. The payload
+// isn't formatted if it doesn't match.
+func (f *formatter) process(payload []byte) (string, bool, error) {
+	if f.decode != nil {
+		var err error
+		if payload, err = f.decode(payload); err != nil {
+			return "", false, err
+		}
+	}
+	if f.filter != nil {
+		if ok, err := f.filter.match(payload); err != nil || !ok {
+			return "", false, err
+		}
+	}
+	out, err := f.formatPayload(payload)
+	return out, err == nil, err
+}
+
+// formatPayload returns the payload in the configured format, prefixed with the extracted key and a tab if
+// key extraction is enabled.
+func (f *formatter) formatPayload(payload []byte) (string, error) {
+	var out string
+	switch f.format {
+	case formatHex:
+		out = hex.EncodeToString(payload)
+	case formatJSON:
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, payload, "", "  "); err != nil {
+			return "", errors.Wrap(err, "payload is not valid JSON")
+		}
+		out = buf.String()
+	default:
+		out = string(payload)
+	}
+
+	if f.key == nil {
+		return out, nil
+	}
+	key, err := f.extractKey(payload)
+	if err != nil {
+		return "", err
+	}
+	return key + "\t" + out, nil
+}
+
+// extractKey returns the value at the configured, dot separated, path of a JSON payload.
+func (f *formatter) extractKey(payload []byte) (string, error) {
+	var value interface{}
+	if err := json.Unmarshal(payload, &value); err != nil {
+		return "", errors.Wrap(err, "failed to extract key, payload is not valid JSON")
+	}
+	for _, field := range f.key {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", errors.Errorf("failed to extract key, %q is not a field of an object", field)
+		}
+		if value, ok = object[field]; !ok {
+			return "", errors.Errorf("failed to extract key, field %q not found", field)
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to encode key")
+		}
+		return string(data), nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
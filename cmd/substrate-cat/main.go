@@ -0,0 +1,196 @@
+// Command substrate-cat consumes messages from any substrate source URL and prints them to stdout.
+//
+// Usage:
+//
+//	substrate-cat [flags] <source-url>
+//
+// For example:
+//
+//	substrate-cat -offset oldest -n 10 -format json "kafka://localhost:9092/topic?consumer-group=cat"
+//
+// Messages can be decoded from protobuf and filtered with an expression over their JSON payload:
+//
+//	substrate-cat -decode proto:orders.pb:acme.orders.v1.Order -filter 'total >= 100 && status == "PAID"' "kafka://localhost:9092/orders?consumer-group=cat"
+//
+// Consumption can start from an approximate time by fast-forwarding through older messages:
+//
+//	substrate-cat -offset oldest -since 2h -timestamp-field created_at "kafka://localhost:9092/orders?consumer-group=cat"
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate/suburl"
+	"github.com/uw-labs/sync/rungroup"
+
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/seek"
+
+	_ "github.com/uw-labs/substrate/kafka"
+	_ "github.com/uw-labs/substrate/natsstreaming"
+	_ "github.com/uw-labs/substrate/proximo"
+)
+
+type config struct {
+	url     string
+	format  string
+	key     string
+	offset  string
+	filter  string
+	decode  string
+	since   string
+	field   string
+	count   int
+	timeout time.Duration
+	noAck   bool
+}
+
+func main() {
+	cfg := config{}
+	flag.StringVar(&cfg.format, "format", formatRaw, "output format, one of raw, hex or json")
+	flag.StringVar(&cfg.key, "key", "", "dot separated path of a field of JSON payloads printed before each message")
+	flag.StringVar(&cfg.offset, "offset", "", "offset to start consuming from, e.g. oldest or newest, overrides the offset of the URL")
+	flag.StringVar(&cfg.filter, "filter", "", `only print messages whose JSON payload matches the expression, e.g. 'user.age >= 18 && status == "active"'`)
+	flag.StringVar(&cfg.decode, "decode", "", "decode payloads to JSON before filtering and printing them, e.g. proto:<descriptor-set>:<message>")
+	flag.StringVar(&cfg.since, "since", "", "skip messages produced before an RFC 3339 time or a duration ago, e.g. 1h, using the timestamps of enveloped messages")
+	flag.StringVar(&cfg.field, "timestamp-field", "", "dot separated path of a field of JSON payloads holding the timestamp used by -since, instead of envelopes")
+	flag.IntVar(&cfg.count, "n", 0, "number of messages to consume before exiting, unlimited if 0")
+	flag.DurationVar(&cfg.timeout, "timeout", 0, "duration after which to exit, unlimited if 0")
+	flag.BoolVar(&cfg.noAck, "no-ack", false, "don't acknowledge consumed messages")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <source-url>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cfg.url = flag.Arg(0)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if err := run(ctx, cfg, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "substrate-cat:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, cfg config, out io.Writer) error {
+	f, err := newFormatter(cfg.format, cfg.key)
+	if err != nil {
+		return err
+	}
+	if f.decode, err = newDecoder(cfg.decode); err != nil {
+		return err
+	}
+	if cfg.filter != "" {
+		if f.filter, err = newFilter(cfg.filter); err != nil {
+			return err
+		}
+	}
+	sourceURL, err := withOffset(cfg.url, cfg.offset)
+	if err != nil {
+		return err
+	}
+	source, err := suburl.NewSource(sourceURL)
+	if err != nil {
+		return errors.Wrap(err, "failed to create source")
+	}
+	defer source.Close()
+
+	if cfg.since != "" {
+		start, err := parseSince(cfg.since, time.Now())
+		if err != nil {
+			return err
+		}
+		opts := []seek.AsyncMessageSourceOption{seek.WithProgress(reportProgress(os.Stderr), time.Second)}
+		if cfg.field != "" {
+			opts = append(opts, seek.WithTimestampFunc(fieldTimestamp(cfg.field)))
+		} else {
+			source = message.NewEnvelopeSource(source)
+		}
+		source = seek.NewAsyncMessageSource(source, start, opts...)
+	}
+
+	if cfg.timeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, cfg.timeout)
+		defer cancel()
+	}
+	return consume(ctx, source, f, cfg.count, !cfg.noAck, out)
+}
+
+// withOffset sets the offset query parameter of the URL, if an offset is provided.
+func withOffset(rawURL, offset string) (string, error) {
+	if offset == "" {
+		return rawURL, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid source URL")
+	}
+	q := u.Query()
+	q.Set("offset", offset)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// consume prints messages consumed from the source until the context is done or the given number of messages
+// was printed. Messages not matching the filter of the formatter are acknowledged without being printed. It returns nil in case it stops because of the context.
+func consume(ctx context.Context, source substrate.AsyncMessageSource, f *formatter, count int, ack bool, out io.Writer) error {
+	rg, ctx := rungroup.New(ctx)
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	rg.Go(func() error {
+		return source.ConsumeMessages(ctx, messages, acks)
+	})
+	rg.Go(func() error {
+		w := bufio.NewWriter(out)
+		defer w.Flush()
+
+		for printed := 0; count == 0 || printed < count; {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-messages:
+				line, matched, err := f.process(msg.Data())
+				if err != nil {
+					return err
+				}
+				if matched {
+					if _, err := fmt.Fprintln(w, line); err != nil {
+						return errors.Wrap(err, "failed to print message")
+					}
+					if err := w.Flush(); err != nil {
+						return errors.Wrap(err, "failed to print message")
+					}
+					printed++
+				}
+				if !ack {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case acks <- msg:
+				}
+			}
+		}
+		return nil
+	})
+
+	return rg.Wait()
+}
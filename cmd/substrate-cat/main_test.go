@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+	"github.com/uw-labs/substrate-tools/seek"
+)
+
+func TestFormatter(t *testing.T) {
+	payload := []byte(`{"id":{"value":"abc"},"n":1}`)
+
+	tests := []struct {
+		name     string
+		format   string
+		key      string
+		expected string
+	}{
+		{name: "raw", format: formatRaw, expected: `{"id":{"value":"abc"},"n":1}`},
+		{name: "hex", format: formatHex, expected: "7b226964223a7b2276616c7565223a22616263227d2c226e223a317d"},
+		{name: "json", format: formatJSON, expected: "{\n  \"id\": {\n    \"value\": \"abc\"\n  },\n  \"n\": 1\n}"},
+		{name: "string key", format: formatRaw, key: "id.value", expected: "abc\t" + string(payload)},
+		{name: "number key", format: formatRaw, key: "n", expected: "1\t" + string(payload)},
+		{name: "object key", format: formatRaw, key: "id", expected: `{"value":"abc"}` + "\t" + string(payload)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := newFormatter(test.format, test.key)
+			require.NoError(t, err)
+
+			out, err := f.formatPayload(payload)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, out)
+		})
+	}
+}
+
+func TestFormatter_Errors(t *testing.T) {
+	_, err := newFormatter("xml", "")
+	require.Error(t, err)
+
+	f, err := newFormatter(formatJSON, "")
+	require.NoError(t, err)
+	_, err = f.formatPayload([]byte("not json"))
+	require.Error(t, err)
+
+	f, err = newFormatter(formatRaw, "id.missing")
+	require.NoError(t, err)
+	_, err = f.formatPayload([]byte(`{"id":{}}`))
+	require.Error(t, err)
+}
+
+func TestWithOffset(t *testing.T) {
+	u, err := withOffset("kafka://localhost:9092/topic?consumer-group=cat&offset=newest", "oldest")
+	require.NoError(t, err)
+	require.Equal(t, "kafka://localhost:9092/topic?consumer-group=cat&offset=oldest", u)
+
+	u, err = withOffset("kafka://localhost:9092/topic", "")
+	require.NoError(t, err)
+	require.Equal(t, "kafka://localhost:9092/topic", u)
+}
+
+func TestConsume(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	// The mock source fails in case messages are not acknowledged in order.
+	source := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			message.FromString("one"),
+			message.FromString("two"),
+			message.FromString("three"),
+		},
+	}
+	f, err := newFormatter(formatRaw, "")
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, consume(ctx, source, f, 2, true, &out))
+	require.Equal(t, "one\ntwo\n", out.String())
+}
+
+func TestConsume_NoAck(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	sink := broker.NewAsyncMessageSink("topic")
+	acks, messages := make(chan substrate.Message, 1), make(chan substrate.Message, 1)
+	go sink.PublishMessages(ctx, acks, messages)
+	messages <- message.FromString("one")
+	<-acks
+
+	f, err := newFormatter(formatHex, "")
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		var out bytes.Buffer
+		source := broker.NewAsyncMessageSource("topic", "cat")
+		require.NoError(t, consume(ctx, source, f, 1, false, &out))
+		require.NoError(t, source.Close())
+		// The message wasn't acknowledged, so it's consumed again.
+		require.Equal(t, "6f6e65\n", out.String())
+	}
+}
+
+func TestFilter(t *testing.T) {
+	payload := []byte(`{"user":{"name":"ann","age":30},"status":"active","tags":["vip","new"],"score":0}`)
+
+	tests := []struct {
+		expression string
+		expected   bool
+	}{
+		{expression: `status == "active"`, expected: true},
+		{expression: `status != "active"`, expected: false},
+		{expression: `user.age >= 18 && user.age < 65`, expected: true},
+		{expression: `user.age > 30 || user.name == "bob"`, expected: false},
+		{expression: `!(user.age > 30) && tags.0 =~ "^v"`, expected: true},
+		{expression: `tags.1 == "new"`, expected: true},
+		{expression: `user.missing == null`, expected: true},
+		{expression: `user.missing`, expected: false},
+		{expression: `score`, expected: false},
+		{expression: `user.name`, expected: true},
+		{expression: `user.age == "30"`, expected: false},
+		{expression: `user.name < "bob"`, expected: true},
+		{expression: `-1 < score`, expected: true},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			f, err := newFilter(test.expression)
+			require.NoError(t, err)
+
+			matched, err := f.match(payload)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, matched)
+		})
+	}
+}
+
+func TestFilter_Errors(t *testing.T) {
+	for _, expression := range []string{``, `status ==`, `(status`, `status == "x" )`, `name =~ 1`, `name =~ "("`, `"unterminated`, `a # b`, `tags == ["vip"]`} {
+		_, err := newFilter(expression)
+		require.Error(t, err, expression)
+	}
+
+	f, err := newFilter(`status`)
+	require.NoError(t, err)
+	_, err = f.match([]byte("not json"))
+	require.Error(t, err)
+}
+
+// writeDescriptorSet writes a descriptor set describing the acme.Order message type to a temporary file.
+func writeDescriptorSet(t *testing.T) (string, protoreflect.MessageDescriptor) {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("order.proto"),
+		Package: proto.String("acme"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Order"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("status"), JsonName: proto.String("status"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+				{Name: proto.String("total"), JsonName: proto.String("total"), Number: proto.Int32(2), Type: descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+			},
+		}},
+	}
+	data, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "order.pb")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	desc, err := loadMessageDescriptor(path, "acme.Order")
+	require.NoError(t, err)
+	return path, desc
+}
+
+func order(t *testing.T, desc protoreflect.MessageDescriptor, status string, total int32) []byte {
+	msg := dynamicpb.NewMessage(desc)
+	msg.Set(desc.Fields().ByName("status"), protoreflect.ValueOfString(status))
+	msg.Set(desc.Fields().ByName("total"), protoreflect.ValueOfInt32(total))
+	data, err := proto.Marshal(msg)
+	require.NoError(t, err)
+	return data
+}
+
+func TestDecoder_Proto(t *testing.T) {
+	path, desc := writeDescriptorSet(t)
+
+	decode, err := newDecoder("proto:" + path + ":acme.Order")
+	require.NoError(t, err)
+	data, err := decode(order(t, desc, "PAID", 120))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"status":"PAID","total":120}`, string(data))
+
+	_, err = decode([]byte{0xff})
+	require.Error(t, err)
+
+	for _, spec := range []string{"avro:schema.avsc", "proto:" + path, "proto:" + path + ":acme.Missing", "proto:missing.pb:acme.Order"} {
+		_, err := newDecoder(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestConsume_FilterDecoded(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	path, desc := writeDescriptorSet(t)
+	source := mock.NewSource(
+		message.NewMessage(order(t, desc, "PAID", 120)),
+		message.NewMessage(order(t, desc, "PENDING", 200)),
+		message.NewMessage(order(t, desc, "PAID", 50)),
+		message.NewMessage(order(t, desc, "PAID", 300)),
+	)
+	f, err := newFormatter(formatRaw, "status")
+	require.NoError(t, err)
+	f.decode, err = newDecoder("proto:" + path + ":acme.Order")
+	require.NoError(t, err)
+	f.filter, err = newFilter(`status == "PAID" && total >= 100`)
+	require.NoError(t, err)
+
+	// Messages not matching the filter are acknowledged, but not counted.
+	var out bytes.Buffer
+	require.NoError(t, consume(ctx, source, f, 2, true, &out))
+	require.Equal(t, "PAID\t{\"status\":\"PAID\",\"total\":120}\nPAID\t{\"status\":\"PAID\",\"total\":300}\n", out.String())
+	require.Eventually(t, func() bool {
+		return len(source.Acked()) == 4
+	}, time.Second, time.Millisecond*5)
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	since, err := parseSince("2023-12-31T08:30:00Z", now)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2023, 12, 31, 8, 30, 0, 0, time.UTC), since)
+
+	since, err = parseSince("90m", now)
+	require.NoError(t, err)
+	require.Equal(t, now.Add(-time.Minute*90), since)
+
+	_, err = parseSince("yesterday", now)
+	require.Error(t, err)
+}
+
+func TestFieldTimestamp(t *testing.T) {
+	timestamp := fieldTimestamp("meta.created")
+
+	ts, ok := timestamp(message.FromString(`{"meta":{"created":"2024-01-01T12:00:00Z"}}`))
+	require.True(t, ok)
+	require.True(t, ts.Equal(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)))
+
+	ts, ok = timestamp(message.FromString(`{"meta":{"created":1704110400.5}}`))
+	require.True(t, ok)
+	require.True(t, ts.Equal(time.Date(2024, 1, 1, 12, 0, 0, int(time.Millisecond*500), time.UTC)))
+
+	for _, payload := range []string{`{"meta":{}}`, `{"meta":"x"}`, `{"meta":{"created":"noon"}}`, `not json`} {
+		_, ok := timestamp(message.FromString(payload))
+		require.False(t, ok, payload)
+	}
+}
+
+func TestReportProgress(t *testing.T) {
+	var out bytes.Buffer
+	report := reportProgress(&out)
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	report(seek.Progress{Partition: "3", Skipped: 10, Timestamp: at})
+	report(seek.Progress{Skipped: 12, Timestamp: at, Reached: true})
+	require.Equal(t, "skipped 10 messages, at 2024-01-01T12:00:00Z of partition 3\nreached 2024-01-01T12:00:00Z after skipping 12 messages\n", out.String())
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uw-labs/substrate"
+
+	"github.com/uw-labs/substrate-tools/seek"
+	"github.com/uw-labs/substrate-tools/ttl"
+)
+
+// parseSince parses an RFC 3339 time, or a duration before now.
+func parseSince(since string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, since); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(since)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid start time %q, expected an RFC 3339 time or a duration", since)
+	}
+	return now.Add(-d), nil
+}
+
+// fieldTimestamp returns a function reading the timestamp of messages from the field at the dot separated path of
+// their JSON payloads, which is either an RFC 3339 string or a number of seconds since the Unix epoch.
+func fieldTimestamp(path string) ttl.TimestampFunc {
+	fields := strings.Split(path, ".")
+	return func(msg substrate.Message) (time.Time, bool) {
+		var value interface{}
+		if err := json.Unmarshal(msg.Data(), &value); err != nil {
+			return time.Time{}, false
+		}
+		for _, field := range fields {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return time.Time{}, false
+			}
+			value = object[field]
+		}
+		switch v := value.(type) {
+		case string:
+			t, err := time.Parse(time.RFC3339Nano, v)
+			return t, err == nil
+		case float64:
+			seconds, fraction := math.Modf(v)
+			return time.Unix(int64(seconds), int64(fraction*float64(time.Second))), true
+		default:
+			return time.Time{}, false
+		}
+	}
+}
+
+// reportProgress returns a function printing the progress of the fast-forward to the writer.
+func reportProgress(out io.Writer) seek.ProgressFunc {
+	return func(p seek.Progress) {
+		partition := ""
+		if p.Partition != "" {
+			partition = fmt.Sprintf(" of partition %s", p.Partition)
+		}
+		if p.Reached {
+			fmt.Fprintf(out, "reached %s%s after skipping %d messages\n", p.Timestamp.Format(time.RFC3339), partition, p.Skipped)
+			return
+		}
+		fmt.Fprintf(out, "skipped %d messages, at %s%s\n", p.Skipped, p.Timestamp.Format(time.RFC3339), partition)
+	}
+}
@@ -0,0 +1,187 @@
+// Command substrate-copy copies all the messages of a substrate source URL to a substrate sink URL, e.g. to migrate
+// a topic from one backend to another. Progress can be saved to resume an interrupted copy, payloads can be
+// transformed by a command or a Go plugin, and a dry run shows what would be copied without publishing anything.
+//
+// Usage:
+//
+//	substrate-copy [flags] <source-url> <sink-url>
+//
+// For example:
+//
+//	substrate-copy -rate 500 -checkpoint-dir /var/lib/copy "kafka://localhost:9092/orders?offset=oldest&consumer-group=copy" "nats-streaming://localhost:4222/orders?cluster-id=test-cluster&client-id=copy"
+//
+// The copy stops once no message was consumed for the idle duration and all the consumed messages were copied.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate/suburl"
+	"github.com/uw-labs/sync/rungroup"
+
+	"github.com/uw-labs/substrate-tools/bridge"
+	"github.com/uw-labs/substrate-tools/checkpoint"
+
+	_ "github.com/uw-labs/substrate/kafka"
+	_ "github.com/uw-labs/substrate/natsstreaming"
+	_ "github.com/uw-labs/substrate/proximo"
+)
+
+type config struct {
+	sourceURL     string
+	sinkURL       string
+	rate          float64
+	maxInFlight   int
+	checkpointDir string
+	checkpointID  string
+	exec          string
+	plugin        string
+	dryRun        bool
+	idle          time.Duration
+	progress      time.Duration
+}
+
+func main() {
+	cfg := config{}
+	flag.Float64Var(&cfg.rate, "rate", 0, "maximum number of messages copied per second, unlimited if 0")
+	flag.IntVar(&cfg.maxInFlight, "max-in-flight", 0, "maximum number of messages being published at the same time, unlimited if 0")
+	flag.StringVar(&cfg.checkpointDir, "checkpoint-dir", "", "directory in which progress is saved, to resume interrupted copies of sources with partition offsets")
+	flag.StringVar(&cfg.checkpointID, "checkpoint-id", "substrate-copy", "name under which progress is saved")
+	flag.StringVar(&cfg.exec, "exec", "", "shell command run for every message, receiving the payload on stdin and writing the new payload to stdout")
+	flag.StringVar(&cfg.plugin, "plugin", "", "Go plugin exporting a Transform func([]byte) ([]byte, error) applied to every payload")
+	flag.BoolVar(&cfg.dryRun, "dry-run", false, "print the messages that would be copied instead of publishing them, without acknowledging them or saving progress")
+	flag.DurationVar(&cfg.idle, "idle", time.Second*10, "duration without new messages after which the copy is complete, never if 0")
+	flag.DurationVar(&cfg.progress, "progress", time.Second*10, "how often to report progress, never if 0")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <source-url> <sink-url>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cfg.sourceURL, cfg.sinkURL = flag.Arg(0), flag.Arg(1)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if err := run(ctx, cfg, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "substrate-copy:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, cfg config, out io.Writer) error {
+	transform, err := newTransform(cfg)
+	if err != nil {
+		return err
+	}
+	source, err := suburl.NewSource(cfg.sourceURL)
+	if err != nil {
+		return errors.Wrap(err, "failed to create source")
+	}
+	defer source.Close()
+
+	var sink substrate.AsyncMessageSink = dryRunSink{out: out}
+	if !cfg.dryRun {
+		if sink, err = suburl.NewSink(cfg.sinkURL); err != nil {
+			return errors.Wrap(err, "failed to create sink")
+		}
+		defer sink.Close()
+	}
+	return copyMessages(ctx, source, sink, transform, cfg, out)
+}
+
+// newTransform returns the transform configured by the exec and plugin flags, the command being run first.
+func newTransform(cfg config) (bridge.TransformFunc, error) {
+	var transforms []PayloadTransform
+	if cfg.exec != "" {
+		transforms = append(transforms, execTransform(cfg.exec))
+	}
+	if cfg.plugin != "" {
+		transform, err := pluginTransform(cfg.plugin)
+		if err != nil {
+			return nil, err
+		}
+		transforms = append(transforms, transform)
+	}
+	return chain(transforms...), nil
+}
+
+// copyMessages copies messages from the source to the sink until the copy is idle or the context is done,
+// reporting progress to out. It returns nil in case it stops because of the context.
+func copyMessages(ctx context.Context, source substrate.AsyncMessageSource, sink substrate.AsyncMessageSink, transform bridge.TransformFunc, cfg config, out io.Writer) error {
+	if cfg.dryRun {
+		source = ackDiscardingSource{AsyncMessageSource: source}
+	}
+	if cfg.checkpointDir != "" {
+		store, err := checkpoint.NewFileStore(cfg.checkpointDir)
+		if err != nil {
+			return errors.Wrap(err, "failed to create checkpoint store")
+		}
+		if cfg.dryRun {
+			store = readOnlyStore{Store: store}
+		}
+		source = checkpoint.NewAsyncMessageSource(source, cfg.checkpointID, store, checkpoint.WithResume())
+	}
+	progress := newProgressSource(source, cfg.rate)
+
+	start := time.Now()
+	rg, ctx := rungroup.New(ctx)
+	rg.Go(func() error {
+		return bridge.Run(ctx, progress, sink, bridge.Options{
+			Transform:   transform,
+			MaxInFlight: cfg.maxInFlight,
+		})
+	})
+	rg.Go(func() error {
+		return watch(ctx, progress, cfg.idle, cfg.progress, out)
+	})
+	err := rg.Wait()
+
+	verb := "copied"
+	if cfg.dryRun {
+		verb = "would copy"
+	}
+	fmt.Fprintf(out, "%s %d messages in %s\n", verb, progress.copied(), time.Since(start).Round(time.Millisecond))
+	return err
+}
+
+// watch reports progress every interval and returns once the copy has been idle for the duration or the context
+// is done.
+func watch(ctx context.Context, progress *progressSource, idle, interval time.Duration, out io.Writer) error {
+	check := time.Second
+	if idle > 0 && idle/10 < check {
+		check = idle / 10
+	}
+	ticker := time.NewTicker(check)
+	defer ticker.Stop()
+
+	lastReport, lastCopied := time.Now(), 0
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			if idle > 0 && progress.idle(idle) {
+				return nil
+			}
+			if interval > 0 && now.Sub(lastReport) >= interval {
+				copied := progress.copied()
+				rate := float64(copied-lastCopied) / now.Sub(lastReport).Seconds()
+				fmt.Fprintf(out, "copied %d messages (%.1f/s)\n", copied, rate)
+				lastReport, lastCopied = now, copied
+			}
+		}
+	}
+}
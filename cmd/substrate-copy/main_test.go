@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+type positionedMessage struct {
+	substrate.Message
+	offset int64
+}
+
+func (m positionedMessage) Partition() string { return "0" }
+func (m positionedMessage) Offset() int64     { return m.offset }
+
+func positioned(payloads ...string) []substrate.Message {
+	messages := make([]substrate.Message, len(payloads))
+	for i, payload := range payloads {
+		messages[i] = positionedMessage{Message: message.FromString(payload), offset: int64(i)}
+	}
+	return messages
+}
+
+func copyAll(t *testing.T, source substrate.AsyncMessageSource, sink substrate.AsyncMessageSink, cfg config) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	if cfg.idle == 0 {
+		cfg.idle = time.Millisecond * 100
+	}
+	transform, err := newTransform(cfg)
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, copyMessages(ctx, source, sink, transform, cfg, &out))
+	require.NoError(t, ctx.Err(), "copy didn't complete")
+	return out.String()
+}
+
+func TestCopy_Exec(t *testing.T) {
+	source := mock.NewSource(message.FromString("a"), message.FromString("b"))
+	sink := mock.NewSink()
+
+	out := copyAll(t, source, sink, config{exec: "tr a-z A-Z"})
+	require.Equal(t, []string{"A", "B"}, sink.Published())
+	require.Len(t, source.Acked(), 2)
+	require.Contains(t, out, "copied 2 messages in")
+}
+
+func TestCopy_ExecError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	transform, err := newTransform(config{exec: "echo invalid >&2; exit 3"})
+	require.NoError(t, err)
+	err = copyMessages(ctx, mock.NewSource(message.FromString("a")), mock.NewSink(), transform, config{}, &bytes.Buffer{})
+	require.EqualError(t, err, "failed to transform message: transform command failed: invalid: exit status 3")
+}
+
+func TestCopy_Resume(t *testing.T) {
+	cfg := config{checkpointDir: t.TempDir(), checkpointID: "copy"}
+
+	first := mock.NewSink()
+	copyAll(t, mock.NewSource(positioned("a", "b")...), first, cfg)
+	require.Equal(t, []string{"a", "b"}, first.Published())
+
+	// Messages copied by the first run are skipped, as sources restart from the beginning.
+	second := mock.NewSink()
+	out := copyAll(t, mock.NewSource(positioned("a", "b", "c")...), second, cfg)
+	require.Equal(t, []string{"c"}, second.Published())
+	require.Contains(t, out, "copied 1 messages in")
+}
+
+func TestCopy_DryRun(t *testing.T) {
+	cfg := config{checkpointDir: t.TempDir(), checkpointID: "copy", dryRun: true}
+	source := mock.NewSource(positioned("a", strings.Repeat("x", 100))...)
+
+	var preview bytes.Buffer
+	out := copyAll(t, source, dryRunSink{out: &preview}, cfg)
+	require.Equal(t, "would publish 1 bytes: \"a\"\nwould publish 100 bytes: \""+strings.Repeat("x", maxPreview)+"\"\n", preview.String())
+	require.Contains(t, out, "would copy 2 messages in")
+	require.Empty(t, source.Acked())
+
+	// No progress was saved.
+	sink := mock.NewSink()
+	copyAll(t, mock.NewSource(positioned("a")...), sink, config{checkpointDir: cfg.checkpointDir, checkpointID: "copy"})
+	require.Equal(t, []string{"a"}, sink.Published())
+}
+
+func TestCopy_Rate(t *testing.T) {
+	source := mock.NewSource(message.FromString("a"), message.FromString("b"), message.FromString("c"))
+	sink := mock.NewSink()
+
+	start := time.Now()
+	copyAll(t, source, sink, config{rate: 20})
+	require.Len(t, sink.Published(), 3)
+	require.GreaterOrEqual(t, time.Since(start), time.Millisecond*100)
+}
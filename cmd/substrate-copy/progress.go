@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/sync/rungroup"
+
+	"github.com/uw-labs/substrate-tools/checkpoint"
+)
+
+// progressSource counts the messages consumed from the source and acknowledged to it, optionally limiting the
+// rate at which messages are consumed.
+type progressSource struct {
+	substrate.AsyncMessageSource
+	rate float64
+	now  func() time.Time
+
+	mutex        sync.Mutex
+	consumed     int
+	acked        int
+	lastActivity time.Time
+}
+
+func newProgressSource(source substrate.AsyncMessageSource, rate float64) *progressSource {
+	return &progressSource{
+		AsyncMessageSource: source,
+		rate:               rate,
+		now:                time.Now,
+		lastActivity:       time.Now(),
+	}
+}
+
+func (s *progressSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	rg, ctx := rungroup.New(ctx)
+
+	sourceMsgs, sourceAcks := make(chan substrate.Message), make(chan substrate.Message)
+	rg.Go(func() error {
+		return s.AsyncMessageSource.ConsumeMessages(ctx, sourceMsgs, sourceAcks)
+	})
+	rg.Go(func() error {
+		var next time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-sourceMsgs:
+				if s.rate > 0 {
+					if wait := next.Sub(s.now()); wait > 0 {
+						timer := time.NewTimer(wait)
+						select {
+						case <-ctx.Done():
+							timer.Stop()
+							return nil
+						case <-timer.C:
+						}
+					}
+					next = s.now().Add(time.Duration(float64(time.Second) / s.rate))
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case messages <- msg:
+					s.update(1, 0)
+				}
+			}
+		}
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				select {
+				case <-ctx.Done():
+					return nil
+				case sourceAcks <- ack:
+					s.update(0, 1)
+				}
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+func (s *progressSource) update(consumed, acked int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.consumed += consumed
+	s.acked += acked
+	s.lastActivity = s.now()
+}
+
+// idle reports whether all the consumed messages were acknowledged and nothing happened for the duration.
+func (s *progressSource) idle(d time.Duration) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.consumed == s.acked && s.now().Sub(s.lastActivity) >= d
+}
+
+// copied returns the number of messages that were copied.
+func (s *progressSource) copied() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.acked
+}
+
+// ackDiscardingSource drops acknowledgements instead of forwarding them to the source, so that dry runs don't
+// commit any offsets.
+type ackDiscardingSource struct {
+	substrate.AsyncMessageSource
+}
+
+func (s ackDiscardingSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	rg, ctx := rungroup.New(ctx)
+
+	rg.Go(func() error {
+		return s.AsyncMessageSource.ConsumeMessages(ctx, messages, make(chan substrate.Message))
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-acks:
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+// readOnlyStore loads checkpoints without ever saving them, so that dry runs start from the saved progress
+// without changing it.
+type readOnlyStore struct {
+	checkpoint.Store
+}
+
+func (readOnlyStore) Save(context.Context, string, checkpoint.Checkpoint) error {
+	return nil
+}
+
+// dryRunSink prints a line for every message instead of publishing it, acknowledging it straight away.
+type dryRunSink struct {
+	out io.Writer
+}
+
+// maxPreview is the number of payload bytes printed by dry runs.
+const maxPreview = 64
+
+func (s dryRunSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-messages:
+			payload := msg.Data()
+			preview := payload
+			if len(preview) > maxPreview {
+				preview = preview[:maxPreview]
+			}
+			if _, err := fmt.Fprintf(s.out, "would publish %d bytes: %q\n", len(payload), preview); err != nil {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case acks <- msg:
+			}
+		}
+	}
+}
+
+func (dryRunSink) Close() error {
+	return nil
+}
+
+func (dryRunSink) Status() (*substrate.Status, error) {
+	return &substrate.Status{Working: true}, nil
+}
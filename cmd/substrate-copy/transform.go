@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"plugin"
+
+	"github.com/pkg/errors"
+	"github.com/uw-labs/substrate"
+
+	"github.com/uw-labs/substrate-tools/bridge"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+// pluginSymbol is the name of the function Go plugins must export, with the signature of PayloadTransform.
+const pluginSymbol = "Transform"
+
+// PayloadTransform transforms the payload of a message.
+type PayloadTransform func(payload []byte) ([]byte, error)
+
+// execTransform returns a transform running the shell command for every message, with the payload as its
+// standard input, and publishing its standard output instead. A command exiting with a non-zero status stops
+// the copy.
+func execTransform(command string) PayloadTransform {
+	return func(payload []byte) ([]byte, error) {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(payload), &stdout, &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, errors.Wrapf(err, "transform command failed: %s", bytes.TrimSpace(stderr.Bytes()))
+		}
+		return stdout.Bytes(), nil
+	}
+}
+
+// pluginTransform loads a Go plugin exporting a Transform function with the signature of PayloadTransform.
+func pluginTransform(path string) (PayloadTransform, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open plugin")
+	}
+	symbol, err := p.Lookup(pluginSymbol)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to look up transform of plugin")
+	}
+	switch f := symbol.(type) {
+	case func([]byte) ([]byte, error):
+		return f, nil
+	case *func([]byte) ([]byte, error):
+		return *f, nil
+	default:
+		return nil, errors.Errorf("transform of plugin has unexpected type %T", symbol)
+	}
+}
+
+// chain returns a bridge transform applying the payload transforms in order. Headers of the consumed messages
+// are preserved.
+func chain(transforms ...PayloadTransform) bridge.TransformFunc {
+	if len(transforms) == 0 {
+		return nil
+	}
+	return func(msg substrate.Message) (substrate.Message, error) {
+		payload := msg.Data()
+		for _, transform := range transforms {
+			var err error
+			if payload, err = transform(payload); err != nil {
+				return nil, err
+			}
+		}
+		if _, ok := msg.(message.HeaderedMessage); ok {
+			return &headeredMessage{Message: message.NewMessage(payload), headers: message.Headers(msg)}, nil
+		}
+		return message.NewMessage(payload), nil
+	}
+}
+
+// headeredMessage is a transformed message carrying the headers of the consumed message.
+type headeredMessage struct {
+	*message.Message
+	headers map[string]string
+}
+
+func (msg *headeredMessage) Headers() map[string]string {
+	return msg.headers
+}
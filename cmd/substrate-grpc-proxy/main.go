@@ -0,0 +1,244 @@
+// Command substrate-grpc-proxy serves the proximo gRPC streaming API on top of substrate sinks and sources, so that
+// services can publish and consume through the proxy using any proximo client, while the backend configuration
+// and credentials are only known to the proxy.
+//
+// Usage:
+//
+//	substrate-grpc-proxy [flags]
+//
+// The sink and source URLs are templates in which {topic} is replaced by the topic of the stream. In the source URL,
+// {consumer} is replaced by the consumer of the stream too, and the initial offset requested by the consumer, if any,
+// overrides the offset query parameter. For example:
+//
+//	substrate-grpc-proxy -listen :6868 -topics orders,payments \
+//		-sink-url "kafka://localhost:9092/{topic}" \
+//		-source-url "kafka://localhost:9092/{topic}?consumer-group={consumer}"
+//
+// The proxy serves the standard gRPC health checking service, reporting every service as not serving once it
+// starts shutting down, and optionally gRPC reflection. To run it as a hardened sidecar, TLS is enabled by -tls-cert
+// and -tls-key, clients must present a certificate signed by -tls-client-ca if set, and -tokens-file makes every
+// call but health checks require one of the listed tokens as a bearer token in its authorization metadata.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uw-labs/proximo/proto"
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate/suburl"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+
+	"github.com/uw-labs/substrate-tools/grpcproxy"
+
+	_ "github.com/uw-labs/substrate/kafka"
+	_ "github.com/uw-labs/substrate/natsstreaming"
+	_ "github.com/uw-labs/substrate/proximo"
+)
+
+const (
+	topicPlaceholder    = "{topic}"
+	consumerPlaceholder = "{consumer}"
+)
+
+type config struct {
+	listen       string
+	sinkURL      string
+	sourceURL    string
+	topics       string
+	shutdownWait time.Duration
+	reflection   bool
+	tlsCert      string
+	tlsKey       string
+	tlsClientCA  string
+	tokensFile   string
+}
+
+func main() {
+	cfg := config{}
+	flag.StringVar(&cfg.listen, "listen", ":6868", "address to listen on")
+	flag.StringVar(&cfg.sinkURL, "sink-url", "", "sink url template used by publish streams, publishing is disabled if empty")
+	flag.StringVar(&cfg.sourceURL, "source-url", "", "source url template used by consume streams, consuming is disabled if empty")
+	flag.StringVar(&cfg.topics, "topics", "", "comma separated list of topics that can be published to and consumed, any topic if empty")
+	flag.DurationVar(&cfg.shutdownWait, "shutdown-timeout", time.Second*10, "time to wait for open streams when shutting down")
+	flag.BoolVar(&cfg.reflection, "reflection", false, "serve gRPC reflection")
+	flag.StringVar(&cfg.tlsCert, "tls-cert", "", "PEM encoded certificate file, enabling TLS along with -tls-key")
+	flag.StringVar(&cfg.tlsKey, "tls-key", "", "PEM encoded private key file of the certificate")
+	flag.StringVar(&cfg.tlsClientCA, "tls-client-ca", "", "PEM encoded CA file clients must present a certificate signed by, enabling mutual TLS")
+	flag.StringVar(&cfg.tokensFile, "tokens-file", "", "file listing the bearer tokens accepted by the proxy, one per line, any call is accepted if empty")
+	flag.Parse()
+
+	if cfg.sinkURL == "" && cfg.sourceURL == "" {
+		fmt.Fprintln(os.Stderr, "substrate-grpc-proxy: at least one of -sink-url and -source-url is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	lis, err := net.Listen("tcp", cfg.listen)
+	if err == nil {
+		err = run(ctx, cfg, lis)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "substrate-grpc-proxy:", err)
+		os.Exit(1)
+	}
+}
+
+// run serves the proxy on the listener until the context is cancelled.
+func run(ctx context.Context, cfg config, lis net.Listener) error {
+	var (
+		sinks   grpcproxy.SinkFunc
+		sources grpcproxy.SourceFunc
+		err     error
+	)
+	if cfg.sinkURL != "" {
+		if sinks, err = newSinkFunc(cfg.sinkURL, cfg.topics, suburl.NewSink); err != nil {
+			return err
+		}
+	}
+	if cfg.sourceURL != "" {
+		if sources, err = newSourceFunc(cfg.sourceURL, cfg.topics, suburl.NewSource); err != nil {
+			return err
+		}
+	}
+
+	opts, err := serverOptions(cfg)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer(opts...)
+	grpcproxy.New(sinks, sources).Register(server)
+
+	healthServer := health.NewServer()
+	for service := range server.GetServiceInfo() {
+		healthServer.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+	}
+	healthpb.RegisterHealthServer(server, healthServer)
+	if cfg.reflection {
+		reflection.Register(server)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve(lis)
+	}()
+
+	select {
+	case err = <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	healthServer.Shutdown()
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(cfg.shutdownWait):
+		server.Stop()
+	}
+	return nil
+}
+
+// newSinkFunc returns a function creating sinks from the URL template, limited to the listed topics if any.
+func newSinkFunc(template, topics string, newSink func(string) (substrate.AsyncMessageSink, error)) (grpcproxy.SinkFunc, error) {
+	topicURL, err := newTopicURL("sink", template, topics)
+	if err != nil {
+		return nil, err
+	}
+	return func(topic string) (substrate.AsyncMessageSink, error) {
+		url, err := topicURL(topic)
+		if err != nil {
+			return nil, err
+		}
+		sink, err := newSink(url)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to create sink for topic %s", topic)
+		}
+		return sink, nil
+	}, nil
+}
+
+// newSourceFunc returns a function creating sources from the URL template, limited to the listed topics if any.
+func newSourceFunc(template, topics string, newSource func(string) (substrate.AsyncMessageSource, error)) (grpcproxy.SourceFunc, error) {
+	topicURL, err := newTopicURL("source", template, topics)
+	if err != nil {
+		return nil, err
+	}
+	return func(topic, consumer string, offset proto.Offset) (substrate.AsyncMessageSource, error) {
+		sourceURL, err := topicURL(topic)
+		if err != nil {
+			return nil, err
+		}
+		sourceURL, err = withOffset(strings.ReplaceAll(sourceURL, consumerPlaceholder, url.QueryEscape(consumer)), offset)
+		if err != nil {
+			return nil, err
+		}
+		source, err := newSource(sourceURL)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to create source for topic %s", topic)
+		}
+		return source, nil
+	}, nil
+}
+
+// newTopicURL returns a function returning the URL of the topic from the URL template, failing with
+// grpcproxy.ErrUnknownTopic for topics that aren't listed, if any are.
+func newTopicURL(kind, template, topics string) (func(string) (string, error), error) {
+	if !strings.Contains(template, topicPlaceholder) {
+		return nil, errors.Errorf("%s url %q doesn't contain %s", kind, template, topicPlaceholder)
+	}
+
+	allowed := make(map[string]bool)
+	for _, topic := range strings.Split(topics, ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			allowed[topic] = true
+		}
+	}
+
+	return func(topic string) (string, error) {
+		if len(allowed) > 0 && !allowed[topic] {
+			return "", errors.Wrap(grpcproxy.ErrUnknownTopic, topic)
+		}
+		return strings.ReplaceAll(template, topicPlaceholder, topic), nil
+	}, nil
+}
+
+// withOffset sets the offset query parameter of the URL to the requested initial offset, unless the default
+// offset is requested.
+func withOffset(rawURL string, offset proto.Offset) (string, error) {
+	var value string
+	switch offset {
+	case proto.Offset_OFFSET_NEWEST:
+		value = "newest"
+	case proto.Offset_OFFSET_OLDEST:
+		value = "oldest"
+	default:
+		return rawURL, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid source URL")
+	}
+	q := u.Query()
+	q.Set("offset", value)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/uw-labs/proximo/proto"
+	"github.com/uw-labs/substrate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+
+	"github.com/uw-labs/substrate-tools/grpcproxy"
+)
+
+func TestNewSinkFunc(t *testing.T) {
+	_, err := newSinkFunc("kafka://localhost:9092/orders", "", nil)
+	require.Error(t, err)
+
+	var urls []string
+	sinks, err := newSinkFunc("kafka://localhost:9092/{topic}", "orders, payments", func(url string) (substrate.AsyncMessageSink, error) {
+		urls = append(urls, url)
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	_, err = sinks("payments")
+	require.NoError(t, err)
+	_, err = sinks("refunds")
+	require.Equal(t, grpcproxy.ErrUnknownTopic, errors.Cause(err))
+	require.Equal(t, []string{"kafka://localhost:9092/payments"}, urls)
+}
+
+func TestNewSourceFunc(t *testing.T) {
+	_, err := newSourceFunc("kafka://localhost:9092/orders", "", nil)
+	require.Error(t, err)
+
+	var urls []string
+	sources, err := newSourceFunc("kafka://localhost:9092/{topic}?consumer-group={consumer}&offset=newest", "orders", func(url string) (substrate.AsyncMessageSource, error) {
+		urls = append(urls, url)
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	_, err = sources("orders", "billing", proto.Offset_OFFSET_DEFAULT)
+	require.NoError(t, err)
+	_, err = sources("orders", "billing&reports", proto.Offset_OFFSET_OLDEST)
+	require.NoError(t, err)
+	_, err = sources("refunds", "billing", proto.Offset_OFFSET_DEFAULT)
+	require.Equal(t, grpcproxy.ErrUnknownTopic, errors.Cause(err))
+
+	require.Equal(t, []string{
+		"kafka://localhost:9092/orders?consumer-group=billing&offset=newest",
+		"kafka://localhost:9092/orders?consumer-group=billing%26reports&offset=oldest",
+	}, urls)
+}
+
+func TestRun(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// suburl has no sink registered for the scheme, so publishing fails once the stream is started
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- run(ctx, config{sinkURL: "unregistered://{topic}", shutdownWait: time.Second}, lis)
+	}()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	stream, err := proto.NewMessageSinkClient(conn).Publish(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&proto.PublisherRequest{StartRequest: &proto.StartPublishRequest{Topic: "orders"}}))
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unavailable, status.Code(err), "%v", err)
+
+	consume, err := proto.NewMessageSourceClient(conn).Consume(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, consume.Send(&proto.ConsumerRequest{StartRequest: &proto.StartConsumeRequest{Topic: "orders"}}))
+	_, err = consume.Recv()
+	require.Equal(t, codes.Unimplemented, status.Code(err), "%v", err)
+
+	cancel()
+	select {
+	case err := <-errs:
+		require.NoError(t, err)
+	case <-time.After(time.Second * 5):
+		require.FailNow(t, "proxy didn't shut down")
+	}
+}
+
+// serve runs the proxy with the configuration on a new listener, returning its address and a function stopping it.
+func serve(t *testing.T, cfg config) (string, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	cfg.sinkURL, cfg.shutdownWait = "unregistered://{topic}", time.Second
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- run(ctx, cfg, lis)
+	}()
+	return lis.Addr().String(), func() {
+		cancel()
+		select {
+		case err := <-errs:
+			require.NoError(t, err)
+		case <-time.After(time.Second * 5):
+			require.FailNow(t, "proxy didn't shut down")
+		}
+	}
+}
+
+func TestRun_HealthAndReflection(t *testing.T) {
+	addr, stop := serve(t, config{reflection: true})
+	defer stop()
+
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	for _, service := range []string{"", "proximo.MessageSink", "proximo.MessageSource"} {
+		resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		require.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+	}
+
+	info, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, info.Send(&rpb.ServerReflectionRequest{MessageRequest: &rpb.ServerReflectionRequest_ListServices{}}))
+	resp, err := info.Recv()
+	require.NoError(t, err)
+	var services []string
+	for _, service := range resp.GetListServicesResponse().Service {
+		services = append(services, service.Name)
+	}
+	require.ElementsMatch(t, []string{
+		"grpc.health.v1.Health",
+		"grpc.reflection.v1alpha.ServerReflection",
+		"proximo.MessageSink",
+		"proximo.MessageSource",
+	}, services)
+}
+
+// bearer holds a bearer token sent as per-RPC credentials.
+type bearer string
+
+func (b bearer) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(b)}, nil
+}
+
+func (b bearer) RequireTransportSecurity() bool {
+	return false
+}
+
+func TestRun_Tokens(t *testing.T) {
+	tokensFile := filepath.Join(t.TempDir(), "tokens")
+	require.NoError(t, os.WriteFile(tokensFile, []byte("# services\nsecret\n\nother\n"), 0o600))
+	addr, stop := serve(t, config{tokensFile: tokensFile})
+	defer stop()
+
+	publish := func(opts ...grpc.DialOption) error {
+		conn, err := grpc.Dial(addr, append(opts, grpc.WithInsecure())...)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		stream, err := proto.NewMessageSinkClient(conn).Publish(context.Background())
+		require.NoError(t, err)
+		_ = stream.Send(&proto.PublisherRequest{StartRequest: &proto.StartPublishRequest{Topic: "orders"}})
+		_, err = stream.Recv()
+		return err
+	}
+	require.Equal(t, codes.Unauthenticated, status.Code(publish()))
+	require.Equal(t, codes.Unauthenticated, status.Code(publish(grpc.WithPerRPCCredentials(bearer("wrong")))))
+	// The sink can't be created, which shows the call was authenticated.
+	require.Equal(t, codes.Unavailable, status.Code(publish(grpc.WithPerRPCCredentials(bearer("other")))))
+
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+}
+
+// writePEM writes the PEM block to a new file of the directory, returning its path.
+func writePEM(t *testing.T, dir, name, blockType string, data []byte) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0o600))
+	return path
+}
+
+// newCert returns a certificate for localhost signed by the parent, or self-signed if the parent is nil.
+func newCert(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, ca bool) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  ca,
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+func TestRun_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := newCert(t, nil, nil, true)
+	server, serverKey := newCert(t, ca, caKey, false)
+	client, clientKey := newCert(t, ca, caKey, false)
+
+	serverKeyDER, err := x509.MarshalECPrivateKey(serverKey)
+	require.NoError(t, err)
+	addr, stop := serve(t, config{
+		tlsCert:     writePEM(t, dir, "server.pem", "CERTIFICATE", server.Raw),
+		tlsKey:      writePEM(t, dir, "server-key.pem", "EC PRIVATE KEY", serverKeyDER),
+		tlsClientCA: writePEM(t, dir, "ca.pem", "CERTIFICATE", ca.Raw),
+	})
+	defer stop()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	check := func(certs ...tls.Certificate) error {
+		creds := credentials.NewTLS(&tls.Config{RootCAs: roots, Certificates: certs, ServerName: "localhost"})
+		conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(creds))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		return err
+	}
+	require.Error(t, check())
+	require.NoError(t, check(tls.Certificate{Certificate: [][]byte{client.Raw}, PrivateKey: clientKey}))
+}
+
+func TestNewTLSConfig_Invalid(t *testing.T) {
+	_, err := newTLSConfig("cert.pem", "", "")
+	require.EqualError(t, err, "both -tls-cert and -tls-key are required to enable TLS")
+	_, err = newTLSConfig("", "", "ca.pem")
+	require.EqualError(t, err, "both -tls-cert and -tls-key are required to enable TLS")
+
+	tlsConfig, err := newTLSConfig("", "", "")
+	require.NoError(t, err)
+	require.Nil(t, tlsConfig)
+}
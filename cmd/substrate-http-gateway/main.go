@@ -0,0 +1,229 @@
+// Command substrate-http-gateway exposes an HTTP endpoint publishing request bodies to substrate sinks, so that
+// services without substrate support can publish messages with a plain POST /topics/{topic} request.
+//
+// Usage:
+//
+//	substrate-http-gateway [flags] <sink-url>
+//
+// The sink URL is a template in which {topic} is replaced by the topic of the request, for example:
+//
+//	substrate-http-gateway -listen :8080 -topics orders,payments "kafka://localhost:9092/{topic}"
+//
+// With -source-url, GET requests to /topics/{topic} stream the messages of the topic as server-sent events.
+// The source URL is a template too, in which {stream} is replaced by a unique identifier of each stream, so
+// that every stream can consume the whole topic using its own consumer group.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate/suburl"
+
+	"github.com/uw-labs/substrate-tools/checkpoint"
+	"github.com/uw-labs/substrate-tools/httpbridge"
+
+	_ "github.com/uw-labs/substrate/kafka"
+	_ "github.com/uw-labs/substrate/natsstreaming"
+	_ "github.com/uw-labs/substrate/proximo"
+)
+
+const (
+	topicPlaceholder  = "{topic}"
+	streamPlaceholder = "{stream}"
+)
+
+type config struct {
+	listen       string
+	url          string
+	sourceURL    string
+	topics       string
+	token        string
+	maxBody      int64
+	maxBatch     int
+	shutdownWait time.Duration
+}
+
+func main() {
+	cfg := config{}
+	flag.StringVar(&cfg.listen, "listen", ":8080", "address to listen on")
+	flag.StringVar(&cfg.sourceURL, "source-url", "", "source url template used to stream topics to GET requests, streaming is disabled if empty")
+	flag.StringVar(&cfg.topics, "topics", "", "comma separated list of topics that can be published to and streamed, any topic if empty")
+	flag.StringVar(&cfg.token, "token", os.Getenv("SUBSTRATE_GATEWAY_TOKEN"), "bearer token required to publish, defaults to $SUBSTRATE_GATEWAY_TOKEN, no authentication if empty")
+	flag.Int64Var(&cfg.maxBody, "max-body", 1<<20, "maximum size of a request body in bytes")
+	flag.IntVar(&cfg.maxBatch, "max-batch", 1000, "maximum number of messages in a batch request")
+	flag.DurationVar(&cfg.shutdownWait, "shutdown-timeout", time.Second*10, "time to wait for in-flight requests when shutting down")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <sink-url>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cfg.url = flag.Arg(0)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	lis, err := net.Listen("tcp", cfg.listen)
+	if err == nil {
+		err = run(ctx, cfg, lis)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "substrate-http-gateway:", err)
+		os.Exit(1)
+	}
+}
+
+// run serves the gateway on the listener until the context is cancelled.
+func run(ctx context.Context, cfg config, lis net.Listener) error {
+	sinks, err := newSinkFunc(cfg.url, cfg.topics, suburl.NewSink)
+	if err != nil {
+		return err
+	}
+
+	opts := []httpbridge.Option{
+		httpbridge.WithMaxBodySize(cfg.maxBody),
+		httpbridge.WithMaxBatchSize(cfg.maxBatch),
+		httpbridge.WithRequestCounter(prometheus.CounterOpts{
+			Name: "substrate_http_gateway_requests_total",
+			Help: "Number of publish requests, labelled with topic and response code.",
+		}),
+		httpbridge.WithLatencyHistogram(prometheus.HistogramOpts{
+			Name: "substrate_http_gateway_publish_seconds",
+			Help: "Time taken to publish the messages of successful requests, labelled with topic.",
+		}),
+	}
+	if cfg.sourceURL != "" {
+		sources, err := newSourceFunc(cfg.sourceURL, cfg.topics, suburl.NewSource)
+		if err != nil {
+			return err
+		}
+		opts = append(opts,
+			httpbridge.WithSources(sources),
+			httpbridge.WithStreamGauge(prometheus.GaugeOpts{
+				Name: "substrate_http_gateway_streams",
+				Help: "Number of open streams, labelled with topic.",
+			}),
+		)
+	}
+	if cfg.token != "" {
+		opts = append(opts, httpbridge.WithAuth(httpbridge.BearerToken(cfg.token)))
+	}
+	bridge := httpbridge.New(sinks, opts...)
+
+	mux := http.NewServeMux()
+	mux.Handle("/topics/", bridge)
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		status, err := bridge.Status()
+		switch {
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		case !status.Working:
+			http.Error(w, strings.Join(status.Problems, "\n"), http.StatusServiceUnavailable)
+		default:
+			fmt.Fprintln(w, "ok")
+		}
+	})
+
+	server := &http.Server{Handler: mux}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve(lis)
+	}()
+
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.shutdownWait)
+		err = server.Shutdown(shutdownCtx)
+		cancel()
+	}
+	if err == http.ErrServerClosed {
+		err = nil
+	}
+	if closeErr := bridge.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// newSinkFunc returns a function creating sinks from the URL template, limited to the listed topics if any.
+func newSinkFunc(template, topics string, newSink func(string) (substrate.AsyncMessageSink, error)) (httpbridge.SinkFunc, error) {
+	topicURL, err := newTopicURL("sink", template, topics)
+	if err != nil {
+		return nil, err
+	}
+	return func(topic string) (substrate.AsyncMessageSink, error) {
+		url, err := topicURL(topic)
+		if err != nil {
+			return nil, err
+		}
+		sink, err := newSink(url)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to create sink for topic %s", topic)
+		}
+		return sink, nil
+	}, nil
+}
+
+// newSourceFunc returns a function creating a source for every stream from the URL template, limited to the
+// listed topics if any. The resume checkpoint of streams is ignored, as the gateway doesn't know how to start
+// consuming from it, so streams can only be resumed by sources that start from an earlier position.
+func newSourceFunc(template, topics string, newSource func(string) (substrate.AsyncMessageSource, error)) (httpbridge.SourceFunc, error) {
+	topicURL, err := newTopicURL("source", template, topics)
+	if err != nil {
+		return nil, err
+	}
+	var streams int64
+	return func(topic string, _ checkpoint.Checkpoint) (substrate.AsyncMessageSource, error) {
+		url, err := topicURL(topic)
+		if err != nil {
+			return nil, err
+		}
+		stream := fmt.Sprintf("%d-%d", os.Getpid(), atomic.AddInt64(&streams, 1))
+		source, err := newSource(strings.ReplaceAll(url, streamPlaceholder, stream))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to create source for topic %s", topic)
+		}
+		return source, nil
+	}, nil
+}
+
+// newTopicURL returns a function returning the URL of the topic from the URL template, failing with
+// httpbridge.ErrUnknownTopic for topics that aren't listed, if any are.
+func newTopicURL(kind, template, topics string) (func(string) (string, error), error) {
+	if !strings.Contains(template, topicPlaceholder) {
+		return nil, errors.Errorf("%s url %q doesn't contain %s", kind, template, topicPlaceholder)
+	}
+
+	allowed := make(map[string]bool)
+	for _, topic := range strings.Split(topics, ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			allowed[topic] = true
+		}
+	}
+
+	return func(topic string) (string, error) {
+		if len(allowed) > 0 && !allowed[topic] {
+			return "", errors.Wrap(httpbridge.ErrUnknownTopic, topic)
+		}
+		return strings.ReplaceAll(template, topicPlaceholder, topic), nil
+	}, nil
+}
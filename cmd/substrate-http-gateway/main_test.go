@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/uw-labs/substrate"
+
+	"github.com/uw-labs/substrate-tools/httpbridge"
+	"github.com/uw-labs/substrate-tools/mem"
+)
+
+func TestNewSinkFunc(t *testing.T) {
+	_, err := newSinkFunc("kafka://localhost:9092/orders", "", nil)
+	require.Error(t, err)
+
+	var urls []string
+	sinks, err := newSinkFunc("kafka://localhost:9092/{topic}", "orders, payments", func(url string) (substrate.AsyncMessageSink, error) {
+		urls = append(urls, url)
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	_, err = sinks("payments")
+	require.NoError(t, err)
+	_, err = sinks("refunds")
+	require.Equal(t, httpbridge.ErrUnknownTopic, errors.Cause(err))
+	require.Equal(t, []string{"kafka://localhost:9092/payments"}, urls)
+}
+
+func TestNewSourceFunc(t *testing.T) {
+	_, err := newSourceFunc("kafka://localhost:9092/orders", "", nil)
+	require.Error(t, err)
+
+	var urls []string
+	sources, err := newSourceFunc("kafka://localhost:9092/{topic}?consumer-group={stream}", "orders", func(url string) (substrate.AsyncMessageSource, error) {
+		urls = append(urls, url)
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	_, err = sources("orders", nil)
+	require.NoError(t, err)
+	_, err = sources("orders", nil)
+	require.NoError(t, err)
+	_, err = sources("refunds", nil)
+	require.Equal(t, httpbridge.ErrUnknownTopic, errors.Cause(err))
+
+	require.Len(t, urls, 2)
+	require.NotEqual(t, urls[0], urls[1])
+	for _, url := range urls {
+		require.True(t, strings.HasPrefix(url, "kafka://localhost:9092/orders?consumer-group="))
+		require.NotContains(t, url, "{stream}")
+	}
+}
+
+func TestRun(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// suburl has no sink registered for the scheme, so publishing fails after authentication
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- run(ctx, config{url: "unregistered://{topic}", token: "secret", maxBody: 1024, maxBatch: 10, shutdownWait: time.Second}, lis)
+	}()
+
+	// without keep-alives no idle or spare connections delay the shutdown
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	url := "http://" + lis.Addr().String()
+	req, err := http.NewRequest(http.MethodPost, url+"/topics/orders", strings.NewReader("payload"))
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	req, err = http.NewRequest(http.MethodPost, url+"/topics/orders", strings.NewReader("payload"))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadGateway, resp.StatusCode)
+
+	resp, err = client.Get(url + "/metrics")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestSinkFuncWithBroker(t *testing.T) {
+	broker := mem.NewBroker()
+	sinks, err := newSinkFunc("mem://{topic}", "", func(url string) (substrate.AsyncMessageSink, error) {
+		return broker.NewAsyncMessageSink(strings.TrimPrefix(url, "mem://")), nil
+	})
+	require.NoError(t, err)
+
+	bridge := httpbridge.New(sinks)
+	defer bridge.Close()
+
+	rec := httptest.NewRecorder()
+	bridge.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/topics/orders", strings.NewReader("payload")))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, [][]byte{[]byte("payload")}, broker.Messages("orders"))
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"math/big"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Supported input formats.
+const (
+	formatLines          = "lines"
+	formatLengthPrefixed = "length-prefixed"
+)
+
+// maxRecordSize is the maximum size of a single line or record read from the input.
+const maxRecordSize = 64 * 1024 * 1024
+
+// payloadReader returns the payloads to publish one at a time. It returns io.EOF once there are no more payloads.
+type payloadReader interface {
+	next() ([]byte, error)
+}
+
+func newPayloadReader(format string, r io.Reader) (payloadReader, error) {
+	switch format {
+	case formatLines:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, maxRecordSize)
+		return &lineReader{scanner: scanner}, nil
+	case formatLengthPrefixed:
+		return &lengthPrefixedReader{r: bufio.NewReader(r)}, nil
+	default:
+		return nil, errors.Errorf("unknown input format %q", format)
+	}
+}
+
+// lineReader returns every non-empty line of the input as a payload.
+type lineReader struct {
+	scanner *bufio.Scanner
+}
+
+func (r *lineReader) next() ([]byte, error) {
+	for r.scanner.Scan() {
+		if line := r.scanner.Bytes(); len(line) > 0 {
+			return append([]byte(nil), line...), nil
+		}
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read line")
+	}
+	return nil, io.EOF
+}
+
+// lengthPrefixedReader returns records prefixed with their length, encoded as a big-endian uint32, as payloads.
+type lengthPrefixedReader struct {
+	r io.Reader
+}
+
+func (r *lengthPrefixedReader) next() ([]byte, error) {
+	var size uint32
+	if err := binary.Read(r.r, binary.BigEndian, &size); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, errors.Wrap(err, "failed to read record length")
+	}
+	if size > maxRecordSize {
+		return nil, errors.Errorf("record of %d bytes exceeds the maximum size of %d bytes", size, maxRecordSize)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r.r, payload); err != nil {
+		return nil, errors.Wrap(err, "failed to read record")
+	}
+	return payload, nil
+}
+
+// multiReader returns the payloads of all the readers, one after another.
+type multiReader struct {
+	readers []payloadReader
+}
+
+func (r *multiReader) next() ([]byte, error) {
+	for len(r.readers) > 0 {
+		payload, err := r.readers[0].next()
+		if err != io.EOF {
+			return payload, err
+		}
+		r.readers = r.readers[1:]
+	}
+	return nil, io.EOF
+}
+
+// templateData is the data available to payload templates.
+type templateData struct {
+	// Seq is the sequence number of the payload, starting at 0.
+	Seq int
+	// Time is the time at which the payload is generated.
+	Time time.Time
+}
+
+var templateFuncs = template.FuncMap{
+	"uuid": func() (string, error) {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return "", err
+		}
+		id[6] = (id[6] & 0x0f) | 0x40
+		id[8] = (id[8] & 0x3f) | 0x80
+		s := hex.EncodeToString(id)
+		return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:], nil
+	},
+	"randInt": func(max int64) (int64, error) {
+		if max <= 0 {
+			return 0, errors.Errorf("randInt: max must be positive, got %d", max)
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(max))
+		if err != nil {
+			return 0, err
+		}
+		return n.Int64(), nil
+	},
+}
+
+// templateReader generates payloads by executing a template, count times or indefinitely if count is 0.
+type templateReader struct {
+	tmpl  *template.Template
+	count int
+	seq   int
+}
+
+func newTemplateReader(text string, count int) (*templateReader, error) {
+	tmpl, err := template.New("payload").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid payload template")
+	}
+	return &templateReader{tmpl: tmpl, count: count}, nil
+}
+
+func (r *templateReader) next() ([]byte, error) {
+	if r.count > 0 && r.seq >= r.count {
+		return nil, io.EOF
+	}
+	var buf bytes.Buffer
+	if err := r.tmpl.Execute(&buf, templateData{Seq: r.seq, Time: time.Now()}); err != nil {
+		return nil, errors.Wrap(err, "failed to execute payload template")
+	}
+	r.seq++
+	return buf.Bytes(), nil
+}
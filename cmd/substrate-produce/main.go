@@ -0,0 +1,217 @@
+// Command substrate-produce publishes messages read from stdin, files or generated from a template to any
+// substrate sink URL.
+//
+// Usage:
+//
+//	substrate-produce [flags] <sink-url> [file...]
+//
+// For example:
+//
+//	substrate-produce -rate 100 -template '{"id":"{{uuid}}","seq":{{.Seq}}}' -n 1000 "kafka://localhost:9092/topic"
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate/suburl"
+	"github.com/uw-labs/sync/rungroup"
+
+	"github.com/uw-labs/substrate-tools/message"
+
+	_ "github.com/uw-labs/substrate/kafka"
+	_ "github.com/uw-labs/substrate/natsstreaming"
+	_ "github.com/uw-labs/substrate/proximo"
+)
+
+type config struct {
+	url            string
+	files          []string
+	format         string
+	template       string
+	count          int
+	rate           float64
+	reportInterval time.Duration
+}
+
+func main() {
+	cfg := config{}
+	flag.StringVar(&cfg.format, "format", formatLines, "input format, one of lines or length-prefixed")
+	flag.StringVar(&cfg.template, "template", "", "template used to generate payloads instead of reading the input")
+	flag.IntVar(&cfg.count, "n", 0, "number of payloads to generate from the template, unlimited if 0")
+	flag.Float64Var(&cfg.rate, "rate", 0, "maximum number of messages published per second, unlimited if 0")
+	flag.DurationVar(&cfg.reportInterval, "report", time.Second*5, "interval at which to report the number of acknowledged messages")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <sink-url> [file...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cfg.url, cfg.files = flag.Arg(0), flag.Args()[1:]
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if err := run(ctx, cfg, os.Stdin, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "substrate-produce:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, cfg config, stdin io.Reader, report io.Writer) error {
+	payloads, closeInput, err := openInput(cfg, stdin)
+	if err != nil {
+		return err
+	}
+	defer closeInput()
+
+	sink, err := suburl.NewSink(cfg.url)
+	if err != nil {
+		return errors.Wrap(err, "failed to create sink")
+	}
+	defer sink.Close()
+
+	return produce(ctx, sink, payloads, cfg.rate, cfg.reportInterval, report)
+}
+
+// openInput returns the reader of the payloads to publish, together with a function closing any opened files.
+func openInput(cfg config, stdin io.Reader) (payloadReader, func(), error) {
+	if cfg.template != "" {
+		r, err := newTemplateReader(cfg.template, cfg.count)
+		return r, func() {}, err
+	}
+	if len(cfg.files) == 0 {
+		r, err := newPayloadReader(cfg.format, stdin)
+		return r, func() {}, err
+	}
+
+	var (
+		readers []payloadReader
+		files   []*os.File
+	)
+	closeFiles := func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}
+	for _, name := range cfg.files {
+		var in io.Reader = stdin
+		if name != "-" {
+			file, err := os.Open(name)
+			if err != nil {
+				closeFiles()
+				return nil, nil, errors.Wrap(err, "failed to open input")
+			}
+			files = append(files, file)
+			in = file
+		}
+		r, err := newPayloadReader(cfg.format, in)
+		if err != nil {
+			closeFiles()
+			return nil, nil, err
+		}
+		readers = append(readers, r)
+	}
+	return &multiReader{readers: readers}, closeFiles, nil
+}
+
+// produce publishes all the payloads to the sink, at most at the given rate per second, and waits for all of them
+// to be acknowledged. It reports the number of acknowledged messages at the given interval and once it is done.
+// It returns nil in case it stops because of the context.
+func produce(ctx context.Context, sink substrate.AsyncMessageSink, payloads payloadReader, rate float64, reportInterval time.Duration, report io.Writer) error {
+	rg, ctx := rungroup.New(ctx)
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	inputDone := make(chan struct{})
+	var sent, acked int64
+
+	start := time.Now()
+	printReport := func() {
+		n := atomic.LoadInt64(&acked)
+		elapsed := time.Since(start)
+		fmt.Fprintf(report, "acknowledged %d messages in %s (%.1f/s)\n", n, elapsed.Round(time.Millisecond), float64(n)/elapsed.Seconds())
+	}
+
+	rg.Go(func() error {
+		return sink.PublishMessages(ctx, acks, messages)
+	})
+	rg.Go(func() error {
+		var ticker *time.Ticker
+		if rate > 0 {
+			ticker = time.NewTicker(time.Duration(float64(time.Second) / rate))
+			defer ticker.Stop()
+		}
+		for {
+			payload, err := payloads.next()
+			if err == io.EOF {
+				close(inputDone)
+				<-ctx.Done()
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if ticker != nil {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-ticker.C:
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case messages <- message.NewMessage(payload):
+				atomic.AddInt64(&sent, 1)
+			}
+		}
+	})
+	rg.Go(func() error {
+		// inputDone is closed after the last message was sent, from then on all messages were acknowledged
+		// once the number of acknowledgements matches the number of messages sent.
+		done := inputDone
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-done:
+				done = nil
+			case <-acks:
+				atomic.AddInt64(&acked, 1)
+			}
+			if done == nil && atomic.LoadInt64(&acked) == atomic.LoadInt64(&sent) {
+				return nil
+			}
+		}
+	})
+	if reportInterval > 0 {
+		rg.Go(func() error {
+			ticker := time.NewTicker(reportInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-ticker.C:
+					printReport()
+				}
+			}
+		})
+	}
+
+	err := rg.Wait()
+	printReport()
+	return err
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate-tools/mem"
+)
+
+func readAll(t *testing.T, r payloadReader) []string {
+	var payloads []string
+	for {
+		payload, err := r.next()
+		if err == io.EOF {
+			return payloads
+		}
+		require.NoError(t, err)
+		payloads = append(payloads, string(payload))
+	}
+}
+
+func TestLineReader(t *testing.T) {
+	r, err := newPayloadReader(formatLines, strings.NewReader("one\n\ntwo\nthree"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"one", "two", "three"}, readAll(t, r))
+}
+
+func TestLengthPrefixedReader(t *testing.T) {
+	var input bytes.Buffer
+	for _, payload := range []string{"one", "", "three\nlines"} {
+		require.NoError(t, binary.Write(&input, binary.BigEndian, uint32(len(payload))))
+		input.WriteString(payload)
+	}
+
+	r, err := newPayloadReader(formatLengthPrefixed, &input)
+	require.NoError(t, err)
+	require.Equal(t, []string{"one", "", "three\nlines"}, readAll(t, r))
+}
+
+func TestLengthPrefixedReader_Truncated(t *testing.T) {
+	r, err := newPayloadReader(formatLengthPrefixed, bytes.NewReader([]byte{0, 0, 0, 5, 'o', 'n'}))
+	require.NoError(t, err)
+	_, err = r.next()
+	require.Error(t, err)
+}
+
+func TestTemplateReader(t *testing.T) {
+	r, err := newTemplateReader(`{{.Seq}}-{{randInt 1}}-{{len uuid}}`, 3)
+	require.NoError(t, err)
+	require.Equal(t, []string{"0-0-36", "1-0-36", "2-0-36"}, readAll(t, r))
+
+	_, err = newTemplateReader(`{{.Seq`, 1)
+	require.Error(t, err)
+
+	for _, max := range []string{"0", "-1"} {
+		r, err = newTemplateReader(`{{randInt `+max+`}}`, 1)
+		require.NoError(t, err)
+		_, err = r.next()
+		require.Error(t, err)
+	}
+}
+
+func TestProduce(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	r, err := newPayloadReader(formatLines, strings.NewReader("one\ntwo\nthree\n"))
+	require.NoError(t, err)
+
+	var report bytes.Buffer
+	start := time.Now()
+	require.NoError(t, produce(ctx, broker.NewAsyncMessageSink("topic"), r, 100, 0, &report))
+
+	require.GreaterOrEqual(t, time.Since(start), time.Millisecond*30)
+	require.Equal(t, [][]byte{[]byte("one"), []byte("two"), []byte("three")}, broker.Messages("topic"))
+	require.Contains(t, report.String(), "acknowledged 3 messages")
+	require.NoError(t, ctx.Err())
+}
+
+func TestOpenInput(t *testing.T) {
+	r, closeInput, err := openInput(config{format: formatLines, files: []string{"-", "-"}}, strings.NewReader("one\ntwo\n"))
+	require.NoError(t, err)
+	defer closeInput()
+	require.Equal(t, []string{"one", "two"}, readAll(t, r))
+
+	_, _, err = openInput(config{format: formatLines, files: []string{"does-not-exist"}}, nil)
+	require.Error(t, err)
+}
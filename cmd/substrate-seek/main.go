@@ -0,0 +1,116 @@
+// Command substrate-seek moves the position of a consumer whose progress is saved by a checkpoint source, such as
+// substrate-copy, to the beginning or the end of a source, to a point in time or to explicit offsets. A dry run prints
+// what would change, including the number of messages that would be skipped or reprocessed, without saving anything.
+//
+// Usage:
+//
+//	substrate-seek [flags] -to <target> [<source-url>]
+//
+// For example:
+//
+//	substrate-seek -checkpoint-dir /var/lib/copy -checkpoint-id substrate-copy -to 2h -dry-run "kafka://localhost:9092/orders?consumer-group=seek"
+//
+// The target is one of beginning, end, an RFC 3339 time or a duration ago, or offsets of the form
+// <partition>=<offset>,... of the first messages to consume. Seeking to the end or to a time reads the source, whose
+// messages must expose their partition and offset, from its oldest message until no message was consumed for the
+// idle duration, without acknowledging any message. Times are compared with the timestamps of enveloped messages.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uw-labs/substrate/suburl"
+
+	"github.com/uw-labs/substrate-tools/checkpoint"
+
+	_ "github.com/uw-labs/substrate/kafka"
+	_ "github.com/uw-labs/substrate/natsstreaming"
+	_ "github.com/uw-labs/substrate/proximo"
+)
+
+type config struct {
+	sourceURL     string
+	checkpointDir string
+	checkpointID  string
+	to            string
+	dryRun        bool
+	idle          time.Duration
+}
+
+func main() {
+	cfg := config{}
+	flag.StringVar(&cfg.checkpointDir, "checkpoint-dir", "", "directory in which the progress of the consumer is saved")
+	flag.StringVar(&cfg.checkpointID, "checkpoint-id", "", "name under which the progress of the consumer is saved")
+	flag.StringVar(&cfg.to, "to", "", "target position, one of beginning, end, an RFC 3339 time, a duration ago, e.g. 1h, or <partition>=<offset>,...")
+	flag.BoolVar(&cfg.dryRun, "dry-run", false, "print what would change without saving the new position")
+	flag.DurationVar(&cfg.idle, "idle", time.Second*5, "duration without new messages after which the whole source was read")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] -to <target> [<source-url>]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() > 1 || cfg.checkpointDir == "" || cfg.checkpointID == "" || cfg.to == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cfg.sourceURL = flag.Arg(0)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if err := run(ctx, cfg, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "substrate-seek:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, cfg config, out io.Writer) error {
+	t, err := parseTarget(cfg.to, time.Now())
+	if err != nil {
+		return err
+	}
+	store, err := checkpoint.NewFileStore(cfg.checkpointDir)
+	if err != nil {
+		return err
+	}
+
+	var positions *positions
+	if cfg.sourceURL != "" {
+		sourceURL, err := withOldestOffset(cfg.sourceURL)
+		if err != nil {
+			return err
+		}
+		source, err := suburl.NewSource(sourceURL)
+		if err != nil {
+			return errors.Wrap(err, "failed to create source")
+		}
+		defer source.Close()
+
+		if positions, err = scan(ctx, source, cfg.idle); err != nil {
+			return err
+		}
+	}
+
+	return seekTo(ctx, store, cfg.checkpointID, t, positions, cfg.dryRun, out)
+}
+
+// withOldestOffset makes the source of the URL consume from its oldest message.
+func withOldestOffset(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid source URL")
+	}
+	q := u.Query()
+	q.Set("offset", "oldest")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/checkpoint"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+var start = time.Date(2024, 5, 17, 9, 0, 0, 0, time.UTC)
+
+// publish publishes envelopes produced a minute apart, starting at the start time.
+func publish(t *testing.T, broker *mem.Broker, count int) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	acks, messages := make(chan substrate.Message, count), make(chan substrate.Message, count)
+	go broker.NewAsyncMessageSink("topic").PublishMessages(ctx, acks, messages)
+	for i := 0; i < count; i++ {
+		data, err := json.Marshal(message.Envelope{ID: "id", Timestamp: start.Add(time.Minute * time.Duration(i)), Payload: []byte("payload")})
+		require.NoError(t, err)
+		messages <- message.NewMessage(data)
+	}
+	for i := 0; i < count; i++ {
+		<-acks
+	}
+}
+
+func scanned(t *testing.T, count int) *positions {
+	broker := mem.NewBroker()
+	publish(t, broker, count)
+
+	p, err := scan(context.Background(), broker.NewAsyncMessageSource("topic", "seek"), time.Millisecond*50)
+	require.NoError(t, err)
+	return p
+}
+
+func TestParseTarget(t *testing.T) {
+	now := start.Add(time.Hour)
+	tests := []struct {
+		value    string
+		expected target
+	}{
+		{value: "beginning", expected: target{kind: targetBeginning}},
+		{value: "end", expected: target{kind: targetEnd}},
+		{value: "2024-05-17T09:30:00Z", expected: target{kind: targetTime, time: start.Add(time.Minute * 30)}},
+		{value: "15m", expected: target{kind: targetTime, time: start.Add(time.Minute * 45)}},
+		{value: "0=10,1=-1", expected: target{kind: targetOffsets, offsets: map[string]int64{"0": 10, "1": -1}}},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			parsed, err := parseTarget(test.value, now)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, parsed)
+		})
+	}
+
+	for _, value := range []string{"later", "0=x", "=1"} {
+		_, err := parseTarget(value, now)
+		require.Error(t, err, value)
+	}
+}
+
+func TestScan(t *testing.T) {
+	p := scanned(t, 3)
+	require.Equal(t, map[string][]position{"0": {
+		{offset: 0, timestamp: start},
+		{offset: 1, timestamp: start.Add(time.Minute)},
+		{offset: 2, timestamp: start.Add(time.Minute * 2)},
+	}}, p.partitions)
+}
+
+func TestScan_RequiresPositions(t *testing.T) {
+	source := &mock.AsyncMessageSource{Messages: []substrate.Message{message.FromString("one")}}
+	_, err := scan(context.Background(), source, time.Second)
+	require.EqualError(t, err, "failed to read source: source doesn't expose the positions of its messages: *message.Message")
+}
+
+func TestPlan(t *testing.T) {
+	p := scanned(t, 10)
+	current := checkpoint.Checkpoint{"0": 4, "other": 7}
+
+	tests := []struct {
+		name     string
+		target   target
+		expected checkpoint.Checkpoint
+	}{
+		{name: "beginning", target: target{kind: targetBeginning}, expected: checkpoint.Checkpoint{}},
+		{name: "end", target: target{kind: targetEnd}, expected: checkpoint.Checkpoint{"0": 9, "other": 7}},
+		{name: "time", target: target{kind: targetTime, time: start.Add(time.Minute * 2)}, expected: checkpoint.Checkpoint{"0": 1, "other": 7}},
+		{name: "future", target: target{kind: targetTime, time: start.Add(time.Hour)}, expected: checkpoint.Checkpoint{"0": 9, "other": 7}},
+		{name: "offsets", target: target{kind: targetOffsets, offsets: map[string]int64{"0": 6}}, expected: checkpoint.Checkpoint{"0": 5, "other": 7}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next, err := plan(current, test.target, p)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, next)
+		})
+	}
+
+	_, err := plan(current, target{kind: targetEnd}, nil)
+	require.EqualError(t, err, "a source URL is required to move to the end")
+}
+
+func TestSeekTo(t *testing.T) {
+	ctx := context.Background()
+	p := scanned(t, 10)
+	store, err := checkpoint.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, store.Save(ctx, "consumer", checkpoint.Checkpoint{"0": 7}))
+
+	var out bytes.Buffer
+	to := target{kind: targetTime, time: start.Add(time.Minute * 3)}
+	require.NoError(t, seekTo(ctx, store, "consumer", to, p, true, &out))
+	require.Equal(t, ""+
+		"PARTITION  CURRENT  NEW  CHANGE\n"+
+		"0          7        2    reprocess 5\n"+
+		"dry run, the position wasn't saved\n", out.String())
+	saved, err := store.Load(ctx, "consumer")
+	require.NoError(t, err)
+	require.Equal(t, checkpoint.Checkpoint{"0": 7}, saved)
+
+	out.Reset()
+	require.NoError(t, seekTo(ctx, store, "consumer", target{kind: targetEnd}, p, false, &out))
+	require.Equal(t, ""+
+		"PARTITION  CURRENT  NEW  CHANGE\n"+
+		"0          7        9    skip 2\n"+
+		"saved the position of consumer\n", out.String())
+	saved, err = store.Load(ctx, "consumer")
+	require.NoError(t, err)
+	require.Equal(t, checkpoint.Checkpoint{"0": 9}, saved)
+
+	out.Reset()
+	require.NoError(t, seekTo(ctx, store, "consumer", target{kind: targetBeginning}, nil, false, &out))
+	require.Equal(t, ""+
+		"PARTITION  CURRENT  NEW  CHANGE\n"+
+		"0          9        -    reprocess\n"+
+		"saved the position of consumer\n", out.String())
+}
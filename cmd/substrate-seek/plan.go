@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate-tools/checkpoint"
+)
+
+const (
+	targetBeginning = "beginning"
+	targetEnd       = "end"
+	targetTime      = "time"
+	targetOffsets   = "offsets"
+)
+
+// target is the position to move a consumer to.
+type target struct {
+	kind    string
+	time    time.Time
+	offsets map[string]int64
+}
+
+// parseTarget parses beginning, end, offsets of the form <partition>=<offset>,... or an RFC 3339 time or duration
+// before now.
+func parseTarget(value string, now time.Time) (target, error) {
+	switch {
+	case value == targetBeginning || value == targetEnd:
+		return target{kind: value}, nil
+	case strings.Contains(value, "="):
+		t := target{kind: targetOffsets, offsets: make(map[string]int64)}
+		for _, pair := range strings.Split(value, ",") {
+			partition, rawOffset, _ := strings.Cut(pair, "=")
+			offset, err := strconv.ParseInt(rawOffset, 10, 64)
+			if partition == "" || err != nil {
+				return target{}, errors.Errorf("invalid offset %q, expected <partition>=<offset>", pair)
+			}
+			t.offsets[partition] = offset
+		}
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return target{kind: targetTime, time: t}, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return target{}, errors.Errorf("invalid target %q, expected beginning, end, offsets, an RFC 3339 time or a duration", value)
+	}
+	return target{kind: targetTime, time: now.Add(-d)}, nil
+}
+
+// none is the offset of a partition without any consumed message.
+const none = math.MinInt64
+
+// plan returns the checkpoint moving the consumer from the current checkpoint to the target. The checkpoint records
+// the offset of the last consumed message of each partition, partitions without any consumed message are left out.
+// The positions of the messages of the source are required to move to the end or to a time.
+func plan(current checkpoint.Checkpoint, t target, p *positions) (checkpoint.Checkpoint, error) {
+	next := make(checkpoint.Checkpoint, len(current))
+	for partition, offset := range current {
+		next[partition] = offset
+	}
+	if p == nil && (t.kind == targetEnd || t.kind == targetTime) {
+		return nil, errors.Errorf("a source URL is required to move to the %s", t.kind)
+	}
+
+	switch t.kind {
+	case targetBeginning:
+		return checkpoint.Checkpoint{}, nil
+	case targetEnd:
+		for partition, positions := range p.partitions {
+			next[partition] = last(positions)
+		}
+	case targetTime:
+		timestamped := false
+		for partition, positions := range p.partitions {
+			next[partition] = last(positions)
+			for _, pos := range positions {
+				if pos.timestamp.IsZero() {
+					continue
+				}
+				timestamped = true
+				if !pos.timestamp.Before(t.time) {
+					next[partition] = pos.offset - 1
+					break
+				}
+			}
+		}
+		if !timestamped && len(p.partitions) > 0 {
+			return nil, errors.New("the messages of the source have no timestamps")
+		}
+	case targetOffsets:
+		for partition, offset := range t.offsets {
+			next[partition] = offset - 1
+		}
+	}
+	return next, nil
+}
+
+// last returns the largest offset of the positions.
+func last(positions []position) int64 {
+	offset := int64(none)
+	for _, pos := range positions {
+		if pos.offset > offset {
+			offset = pos.offset
+		}
+	}
+	return offset
+}
+
+// render prints the change of the position of each partition, along with the number of messages that would be
+// skipped or reprocessed if the positions of the messages of the source are known.
+func render(out io.Writer, current, next checkpoint.Checkpoint, p *positions) error {
+	partitions := make(map[string]bool)
+	for partition := range current {
+		partitions[partition] = true
+	}
+	for partition := range next {
+		partitions[partition] = true
+	}
+	if p != nil {
+		for partition := range p.partitions {
+			partitions[partition] = true
+		}
+	}
+	names := make([]string, 0, len(partitions))
+	for partition := range partitions {
+		names = append(names, partition)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PARTITION\tCURRENT\tNEW\tCHANGE")
+	for _, partition := range names {
+		from, to := offset(current, partition), offset(next, partition)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", partition, format(from), format(to), describe(partition, from, to, p))
+	}
+	return errors.Wrap(w.Flush(), "failed to print changes")
+}
+
+func offset(cp checkpoint.Checkpoint, partition string) int64 {
+	if offset, ok := cp[partition]; ok {
+		return offset
+	}
+	return none
+}
+
+func format(offset int64) string {
+	if offset == none {
+		return "-"
+	}
+	return strconv.FormatInt(offset, 10)
+}
+
+func describe(partition string, from, to int64, p *positions) string {
+	switch {
+	case from == to:
+		return "unchanged"
+	case p == nil && to > from:
+		return "skip"
+	case p == nil:
+		return "reprocess"
+	case to > from:
+		return fmt.Sprintf("skip %d", p.between(partition, from, to))
+	default:
+		return fmt.Sprintf("reprocess %d", p.between(partition, to, from))
+	}
+}
+
+// seekTo moves the consumer to the target, printing the changes, and saves its new checkpoint unless it is a dry run.
+func seekTo(ctx context.Context, store checkpoint.Store, consumer string, t target, p *positions, dryRun bool, out io.Writer) error {
+	current, err := store.Load(ctx, consumer)
+	if err != nil {
+		return errors.Wrap(err, "failed to load checkpoint")
+	}
+	next, err := plan(current, t, p)
+	if err != nil {
+		return err
+	}
+	if err := render(out, current, next, p); err != nil {
+		return err
+	}
+	if dryRun {
+		fmt.Fprintln(out, "dry run, the position wasn't saved")
+		return nil
+	}
+	if err := store.Save(ctx, consumer, next); err != nil {
+		return errors.Wrap(err, "failed to save checkpoint")
+	}
+	fmt.Fprintf(out, "saved the position of %s\n", consumer)
+	return nil
+}
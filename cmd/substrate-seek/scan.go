@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/sync/rungroup"
+
+	"github.com/uw-labs/substrate-tools/checkpoint"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+// position is the position of a message within its partition.
+type position struct {
+	offset    int64
+	timestamp time.Time
+}
+
+// positions are the positions of the messages of each partition of a source, in the order in which they were
+// consumed.
+type positions struct {
+	partitions map[string][]position
+}
+
+// scan reads the positions of the messages of the source until no message was consumed for the idle duration.
+// Messages aren't acknowledged.
+func scan(ctx context.Context, source substrate.AsyncMessageSource, idle time.Duration) (*positions, error) {
+	rg, ctx := rungroup.New(ctx)
+	p := &positions{partitions: make(map[string][]position)}
+
+	messages := make(chan substrate.Message)
+	rg.Go(func() error {
+		return source.ConsumeMessages(ctx, messages, make(chan substrate.Message))
+	})
+	rg.Go(func() error {
+		timer := time.NewTimer(idle)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-timer.C:
+				return errDone
+			case msg := <-messages:
+				pMsg, ok := msg.(checkpoint.Positioned)
+				if !ok {
+					return errors.Errorf("source doesn't expose the positions of its messages: %T", msg)
+				}
+				p.partitions[pMsg.Partition()] = append(p.partitions[pMsg.Partition()], position{
+					offset:    pMsg.Offset(),
+					timestamp: timestamp(msg),
+				})
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(idle)
+			}
+		}
+	})
+
+	if err := rg.Wait(); err != errDone {
+		if err == nil {
+			err = ctx.Err()
+		}
+		return nil, errors.Wrap(err, "failed to read source")
+	}
+	return p, nil
+}
+
+// errDone stops reading the source once it was read.
+var errDone = errors.New("done")
+
+// timestamp returns the timestamp of the envelope of the message, or the zero time if it isn't an envelope.
+func timestamp(msg substrate.Message) time.Time {
+	var envelope message.Envelope
+	if err := json.Unmarshal(msg.Data(), &envelope); err != nil {
+		return time.Time{}
+	}
+	return envelope.Timestamp
+}
+
+// names returns the names of the partitions.
+func (p *positions) names() []string {
+	var names []string
+	for name := range p.partitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// between returns the number of messages of the partition whose offsets are after from and at or before to.
+func (p *positions) between(partition string, from, to int64) int {
+	count := 0
+	for _, pos := range p.partitions[partition] {
+		if pos.offset > from && pos.offset <= to {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,158 @@
+// Command substrate-top consumes from one or more substrate source URLs and shows live message rates, payload size
+// percentiles and, for backends exposing offsets, the lag of every source, refreshing in place like top.
+//
+// Usage:
+//
+//	substrate-top [flags] <source-url>...
+//
+// For example:
+//
+//	substrate-top -window 30s "kafka://localhost:9092/orders?consumer-group=top" "nats-streaming://localhost:4222/payments?cluster-id=test-cluster&consumer-id=top"
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate/suburl"
+	"github.com/uw-labs/sync/rungroup"
+
+	_ "github.com/uw-labs/substrate/kafka"
+	_ "github.com/uw-labs/substrate/natsstreaming"
+	_ "github.com/uw-labs/substrate/proximo"
+)
+
+type config struct {
+	urls     []string
+	interval time.Duration
+	window   time.Duration
+	timeout  time.Duration
+	plain    bool
+	noAck    bool
+}
+
+func main() {
+	cfg := config{}
+	flag.DurationVar(&cfg.interval, "interval", time.Second, "how often to refresh the statistics")
+	flag.DurationVar(&cfg.window, "window", time.Second*10, "window over which rates and size percentiles are computed")
+	flag.DurationVar(&cfg.timeout, "timeout", 0, "duration after which to exit, unlimited if 0")
+	flag.BoolVar(&cfg.plain, "plain", false, "print every refresh below the previous one instead of redrawing the screen")
+	flag.BoolVar(&cfg.noAck, "no-ack", false, "don't acknowledge consumed messages")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <source-url>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cfg.urls = flag.Args()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if err := run(ctx, cfg, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "substrate-top:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, cfg config, out io.Writer) error {
+	if cfg.interval <= 0 || cfg.window <= 0 {
+		return errors.New("interval and window must be positive")
+	}
+	sources := make([]substrate.AsyncMessageSource, len(cfg.urls))
+	all := make([]*stats, len(cfg.urls))
+	for i, u := range cfg.urls {
+		source, err := suburl.NewSource(u)
+		if err != nil {
+			return errors.Wrapf(err, "failed to create source %s", u)
+		}
+		defer source.Close()
+		sources[i], all[i] = source, newStats(u, cfg.window)
+	}
+
+	if cfg.timeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, cfg.timeout)
+		defer cancel()
+	}
+	return monitor(ctx, sources, all, cfg, out)
+}
+
+// monitor consumes from all the sources, recording into the stats of the same index, and renders the stats every
+// interval until the context is done. A source failing doesn't stop the others, its error is shown instead.
+func monitor(ctx context.Context, sources []substrate.AsyncMessageSource, all []*stats, cfg config, out io.Writer) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for i, source := range sources {
+		s, source := all[i], source
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := consume(ctx, source, s, !cfg.noAck); err != nil {
+				s.fail(err)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(cfg.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			rows := make([]row, len(all))
+			for i, s := range all {
+				rows[i] = s.snapshot(now)
+			}
+			if err := render(out, rows, now, cfg.window, cfg.plain); err != nil {
+				return errors.Wrap(err, "failed to render statistics")
+			}
+		}
+	}
+}
+
+// consume records the messages consumed from the source until the context is done. It returns nil in case it
+// stops because of the context.
+func consume(ctx context.Context, source substrate.AsyncMessageSource, s *stats, ack bool) error {
+	rg, ctx := rungroup.New(ctx)
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	rg.Go(func() error {
+		return source.ConsumeMessages(ctx, messages, acks)
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-messages:
+				s.record(msg, time.Now())
+				if !ack {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case acks <- msg:
+				}
+			}
+		}
+	})
+
+	return rg.Wait()
+}
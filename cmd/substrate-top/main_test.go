@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+type offsetMessage struct {
+	substrate.Message
+	partition     string
+	offset, water int64
+}
+
+func (m offsetMessage) Partition() string    { return m.partition }
+func (m offsetMessage) Offset() int64        { return m.offset }
+func (m offsetMessage) HighWaterMark() int64 { return m.water }
+
+func TestStats(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := newStats("orders", time.Second*10)
+
+	// The first message falls out of the window.
+	s.record(message.NewMessage(make([]byte, 1000)), start)
+	for i := 1; i <= 10; i++ {
+		s.record(message.NewMessage(make([]byte, i*10)), start.Add(time.Second*5))
+	}
+	s.record(offsetMessage{Message: message.FromString("x"), partition: "0", offset: 5, water: 10}, start.Add(time.Second*6))
+	s.record(offsetMessage{Message: message.FromString("x"), partition: "1", offset: 2, water: 4}, start.Add(time.Second*6))
+
+	r := s.snapshot(start.Add(time.Second * 12))
+	require.Equal(t, 13, r.total)
+	require.Equal(t, 1.2, r.rate)
+	require.Equal(t, 55.2, r.throughput)
+	require.Equal(t, 40, r.p50)
+	require.Equal(t, 90, r.p90)
+	require.Equal(t, 100, r.p99)
+	require.Equal(t, 100, r.max)
+	require.True(t, r.hasLag)
+	require.Equal(t, int64(5), r.lag)
+}
+
+func TestRender(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 30, 0, 0, time.UTC)
+	rows := []row{
+		{name: "orders", total: 10, rate: 2.5, throughput: 2048, p50: 100, p90: 1536, p99: 2000, max: 4096, lag: 7, hasLag: true},
+		{name: "payments", err: errors.New("connection refused")},
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, render(&out, rows, now, time.Second*10, true))
+	require.Equal(t, `substrate-top - 12:30:00 - rates over 10s
+
+  MSGS  MSG/S  BYTES/S   P50     P90     P99     MAX  LAG SOURCE
+    10    2.5   2.0KiB  100B  1.5KiB  2.0KiB  4.0KiB    7 orders
+     0    0.0       0B    0B      0B      0B      0B    - payments (failed: connection refused)
+`, out.String())
+
+	out.Reset()
+	require.NoError(t, render(&out, rows, now, time.Second*10, false))
+	require.True(t, strings.HasPrefix(out.String(), clearScreen))
+}
+
+func TestFormatBytes(t *testing.T) {
+	require.Equal(t, "512B", formatBytes(512))
+	require.Equal(t, "1.0KiB", formatBytes(1024))
+	require.Equal(t, "1.5MiB", formatBytes(1536*1024))
+}
+
+func TestMonitor(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+
+	// The mock source fails in case messages are not acknowledged in order.
+	healthy := mock.NewSource(message.FromString("one"), message.FromString("two"))
+	failing := mock.NewSource(message.FromString("one")).FailAt(1, errors.New("boom"))
+	cfg := config{interval: time.Millisecond * 20, window: time.Second, plain: true}
+
+	var out bytes.Buffer
+	err := monitor(ctx, []substrate.AsyncMessageSource{healthy, failing}, []*stats{newStats("healthy", cfg.window), newStats("failing", cfg.window)}, cfg, &out)
+	require.NoError(t, err)
+	require.Len(t, healthy.Acked(), 2)
+
+	refreshes := strings.Split(out.String(), "substrate-top - ")
+	last := refreshes[len(refreshes)-1]
+	require.Contains(t, last, "2    2.0       6B")
+	require.Contains(t, last, "failing (failed: boom)")
+}
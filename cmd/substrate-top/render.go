@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// clearScreen moves the cursor to the top left corner and clears the terminal, so that every refresh is drawn
+// in place.
+const clearScreen = "\x1b[H\x1b[2J"
+
+// render writes a table of the rows, clearing the terminal first unless plain output is requested.
+func render(out io.Writer, rows []row, now time.Time, window time.Duration, plain bool) error {
+	if !plain {
+		if _, err := io.WriteString(out, clearScreen); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(out, "substrate-top - %s - rates over %s\n\n", now.Format(time.TimeOnly), window)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "MSGS\tMSG/S\tBYTES/S\tP50\tP90\tP99\tMAX\tLAG\t SOURCE")
+	for _, r := range rows {
+		lag := "-"
+		if r.hasLag {
+			lag = strconv.FormatInt(r.lag, 10)
+		}
+		name := r.name
+		if r.err != nil {
+			name += " (failed: " + r.err.Error() + ")"
+		}
+		fmt.Fprintf(w, "%d\t%.1f\t%s\t%s\t%s\t%s\t%s\t%s\t %s\n",
+			r.total, r.rate, formatBytes(int(r.throughput)),
+			formatBytes(r.p50), formatBytes(r.p90), formatBytes(r.p99), formatBytes(r.max), lag, name,
+		)
+	}
+	return w.Flush()
+}
+
+// formatBytes formats a number of bytes using binary units.
+func formatBytes(n int) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.Itoa(n) + "B"
+	}
+	value, suffix := float64(n)/unit, "KMGTPE"
+	i := 0
+	for value >= unit && i < len(suffix)-1 {
+		value /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f%ciB", value, suffix[i])
+}
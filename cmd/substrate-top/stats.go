@@ -0,0 +1,131 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uw-labs/substrate"
+
+	"github.com/uw-labs/substrate-tools/instrumented"
+)
+
+// sample is a consumed message recorded for computing rates and percentiles.
+type sample struct {
+	at   time.Time
+	size int
+}
+
+// stats tracks the messages consumed from a source. It is safe for concurrent use.
+type stats struct {
+	name   string
+	window time.Duration
+
+	mutex   sync.Mutex
+	total   int
+	bytes   int
+	samples []sample
+	// lag is the lag per partition, only known for messages implementing instrumented.Offsetter.
+	lag map[string]int64
+	err error
+}
+
+func newStats(name string, window time.Duration) *stats {
+	return &stats{
+		name:   name,
+		window: window,
+		lag:    make(map[string]int64),
+	}
+}
+
+// record records the message consumed at the time.
+func (s *stats) record(msg substrate.Message, at time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	size := len(msg.Data())
+	s.total++
+	s.bytes += size
+	s.samples = append(s.samples, sample{at: at, size: size})
+	s.expire(at)
+
+	if oMsg, ok := msg.(instrumented.Offsetter); ok {
+		lag := oMsg.HighWaterMark() - oMsg.Offset() - 1
+		if lag < 0 {
+			lag = 0
+		}
+		s.lag[oMsg.Partition()] = lag
+	}
+}
+
+// fail records the error the source failed with.
+func (s *stats) fail(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.err = err
+}
+
+// expire drops the samples that are older than the window. It must be called with the mutex held.
+func (s *stats) expire(now time.Time) {
+	cutoff := now.Add(-s.window)
+	i := 0
+	for i < len(s.samples) && !s.samples[i].at.After(cutoff) {
+		i++
+	}
+	s.samples = s.samples[i:]
+}
+
+// row is a snapshot of the stats of a source.
+type row struct {
+	name string
+	// total is the number of messages consumed since the start.
+	total int
+	// rate is the number of messages consumed per second, and throughput the number of bytes, over the window.
+	rate       float64
+	throughput float64
+	// p50, p90, p99 and max are the payload size percentiles over the window.
+	p50, p90, p99, max int
+	// lag is the total lag of all the partitions, if known.
+	lag    int64
+	hasLag bool
+	err    error
+}
+
+// snapshot returns the stats at the time.
+func (s *stats) snapshot(now time.Time) row {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.expire(now)
+	r := row{name: s.name, total: s.total, err: s.err}
+
+	sizes := make([]int, len(s.samples))
+	bytes := 0
+	for i, smp := range s.samples {
+		sizes[i] = smp.size
+		bytes += smp.size
+	}
+	r.rate = float64(len(sizes)) / s.window.Seconds()
+	r.throughput = float64(bytes) / s.window.Seconds()
+	if len(sizes) > 0 {
+		sort.Ints(sizes)
+		r.p50, r.p90, r.p99 = percentile(sizes, 50), percentile(sizes, 90), percentile(sizes, 99)
+		r.max = sizes[len(sizes)-1]
+	}
+
+	for _, lag := range s.lag {
+		r.lag += lag
+		r.hasLag = true
+	}
+	return r
+}
+
+// percentile returns the nearest-rank percentile of the sorted values.
+func percentile(sorted []int, p int) int {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,225 @@
+// Package coalesce provides a message sink wrapper combining messages that share a key and are published within a
+// short window into a single message, reducing the number of requests made to the backend by chatty producers.
+package coalesce
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+const (
+	defaultWindow      = time.Millisecond * 100
+	defaultMaxMessages = 100
+)
+
+// KeyFunc returns the key of a message. Messages with an empty key aren't combined with any other message.
+type KeyFunc func(msg substrate.Message) string
+
+// CombineFunc combines the messages sharing the key, in the order in which they were published, into the message
+// published instead of them. An error returned by it stops the publishing.
+type CombineFunc func(key string, msgs []substrate.Message) (substrate.Message, error)
+
+// AsyncMessageSinkOption is a function which sets an AsyncMessageSink configuration option.
+type AsyncMessageSinkOption func(s *coalescingSink)
+
+// WithWindow sets how long messages are held after the first message of a key was received, waiting for more
+// messages with the same key. The default value is 100 milliseconds.
+func WithWindow(window time.Duration) AsyncMessageSinkOption {
+	return func(s *coalescingSink) {
+		s.window = window
+	}
+}
+
+// WithMaxMessages sets the maximum number of messages combined together. Once the messages of a key reach it,
+// they are combined without waiting for the end of their window. The default value is 100.
+func WithMaxMessages(n int) AsyncMessageSinkOption {
+	return func(s *coalescingSink) {
+		s.maxMessages = n
+	}
+}
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that combines the messages sharing a key
+// within a window into a single message, using the combine function, and publishes it to the wrapped sink. The
+// combine function is called for every key, even if only one message was received within its window. The messages
+// are acknowledged in the order in which they were received, once the message they were combined into was
+// acknowledged. Messages are no longer received while the wrapped sink isn't ready to receive combined ones.
+func NewAsyncMessageSink(sink substrate.AsyncMessageSink, key KeyFunc, combine CombineFunc, opts ...AsyncMessageSinkOption) substrate.AsyncMessageSink {
+	s := &coalescingSink{
+		sink:        sink,
+		key:         key,
+		combine:     combine,
+		window:      defaultWindow,
+		maxMessages: defaultMaxMessages,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type coalescingSink struct {
+	sink        substrate.AsyncMessageSink
+	key         KeyFunc
+	combine     CombineFunc
+	window      time.Duration
+	maxMessages int
+}
+
+// group is a set of messages combined into a single one.
+type group struct {
+	key      string
+	msgs     []substrate.Message
+	deadline time.Time
+	combined substrate.Message
+	acked    bool
+}
+
+// received is a message received by the sink, along with the group it belongs to.
+type received struct {
+	msg   substrate.Message
+	group *group
+}
+
+// PublishMessages combines and publishes messages until the context is cancelled or an error occurs.
+func (s *coalescingSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "coalesce"})
+	rg, ctx := rungroup.New(ctx)
+
+	toPublish, sinkAcks := make(chan substrate.Message), make(chan substrate.Message)
+	rg.Go(func() error {
+		return s.sink.PublishMessages(ctx, sinkAcks, toPublish)
+	})
+	rg.Go(func() error {
+		return emit.Error(s.coalesce(ctx, emit, acks, messages, toPublish, sinkAcks))
+	})
+
+	return rg.Wait()
+}
+
+func (s *coalescingSink) coalesce(ctx context.Context, emit events.Emitter, acks chan<- substrate.Message, messages <-chan substrate.Message, toPublish chan<- substrate.Message, sinkAcks <-chan substrate.Message) error {
+	var (
+		open     = make(map[string]*group)
+		windows  []*group // the open groups, in the order in which their windows end
+		ready    []*group // the groups waiting to be published
+		inFlight []*group // the groups published and waiting to be acknowledged
+		pending  []received
+	)
+	closeGroup := func(g *group) error {
+		delete(open, g.key)
+		for i := range windows {
+			if windows[i] == g {
+				windows = append(windows[:i], windows[i+1:]...)
+				break
+			}
+		}
+		combined, err := s.combine(g.key, g.msgs)
+		if err != nil {
+			return errors.Wrapf(err, "failed to combine messages with key %q", g.key)
+		}
+		g.combined = combined
+		ready = append(ready, g)
+		return nil
+	}
+
+	timer := time.NewTimer(s.window)
+	stopTimer(timer)
+	defer timer.Stop()
+	var armed time.Time
+
+	for {
+		switch {
+		case len(windows) > 0 && !windows[0].deadline.Equal(armed):
+			stopTimer(timer)
+			armed = windows[0].deadline
+			timer.Reset(time.Until(armed))
+		case len(windows) == 0 && !armed.IsZero():
+			stopTimer(timer)
+			armed = time.Time{}
+		}
+
+		in := messages
+		var publish chan<- substrate.Message
+		var next substrate.Message
+		if len(ready) > 0 {
+			in, publish, next = nil, toPublish, ready[0].combined
+		}
+		var ack chan<- substrate.Message
+		var released substrate.Message
+		if len(pending) > 0 && pending[0].group.acked {
+			ack, released = acks, pending[0].msg
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-in:
+			emit.Publish(msg)
+			key := s.key(msg)
+			if key == "" {
+				g := &group{msgs: []substrate.Message{msg}, combined: msg}
+				ready = append(ready, g)
+				pending = append(pending, received{msg: msg, group: g})
+				continue
+			}
+			g, ok := open[key]
+			if !ok {
+				g = &group{key: key, deadline: time.Now().Add(s.window)}
+				open[key] = g
+				windows = append(windows, g)
+			}
+			g.msgs = append(g.msgs, msg)
+			pending = append(pending, received{msg: msg, group: g})
+			if len(g.msgs) >= s.maxMessages {
+				if err := closeGroup(g); err != nil {
+					return err
+				}
+			}
+		case <-timer.C:
+			armed = time.Time{}
+			now := time.Now()
+			for len(windows) > 0 && !windows[0].deadline.After(now) {
+				if err := closeGroup(windows[0]); err != nil {
+					return err
+				}
+			}
+		case publish <- next:
+			inFlight = append(inFlight, ready[0])
+			ready = ready[1:]
+		case msg := <-sinkAcks:
+			if len(inFlight) == 0 || msg != inFlight[0].combined {
+				return errors.Errorf("unexpected message acknowledged: %v", msg)
+			}
+			inFlight[0].acked = true
+			inFlight = inFlight[1:]
+		case ack <- released:
+			emit.Ack(released)
+			pending = pending[1:]
+		}
+	}
+}
+
+// stopTimer stops the timer, draining its channel in case it already fired.
+func stopTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+}
+
+// Close closes the wrapped sink.
+func (s *coalescingSink) Close() error {
+	return s.sink.Close()
+}
+
+// Status returns the status of the wrapped sink.
+func (s *coalescingSink) Status() (*substrate.Status, error) {
+	return s.sink.Status()
+}
@@ -0,0 +1,89 @@
+package coalesce_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/coalesce"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+// key returns the part of the payload before the colon, if any.
+func key(msg substrate.Message) string {
+	k, _, ok := strings.Cut(string(msg.Data()), ":")
+	if !ok {
+		return ""
+	}
+	return k
+}
+
+func join(key string, msgs []substrate.Message) (substrate.Message, error) {
+	var values []string
+	for _, msg := range msgs {
+		_, v, _ := strings.Cut(string(msg.Data()), ":")
+		values = append(values, v)
+	}
+	return message.FromString(key + ":" + strings.Join(values, ",")), nil
+}
+
+func TestSink(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	backend := mock.NewSink()
+	sink := coalesce.NewAsyncMessageSink(backend, key, join,
+		coalesce.WithWindow(time.Millisecond*50),
+		coalesce.WithMaxMessages(3),
+	)
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	var sent []substrate.Message
+	for _, payload := range []string{"a:1", "b:1", "plain", "a:2", "c:1", "c:2", "c:3", "a:3", "c:4"} {
+		msg := message.FromString(payload)
+		sent = append(sent, msg)
+		messages <- msg
+	}
+	for _, msg := range sent {
+		select {
+		case ack := <-acks:
+			require.Equal(t, msg, ack)
+		case <-ctx.Done():
+			require.FailNow(t, "messages weren't acknowledged")
+		}
+	}
+	cancel()
+	require.NoError(t, <-errs)
+
+	published := backend.Published()
+	// Messages without a key and full groups are published straight away, while the others wait for their window.
+	require.Equal(t, []string{"plain", "c:1,2,3", "a:1,2,3"}, published[:3])
+	require.ElementsMatch(t, []string{"b:1", "c:4"}, published[3:])
+}
+
+func TestSink_CombineError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	errCombine := errors.New("invalid message")
+	sink := coalesce.NewAsyncMessageSink(mock.NewSink(), key, func(string, []substrate.Message) (substrate.Message, error) {
+		return nil, errCombine
+	}, coalesce.WithWindow(time.Millisecond*10))
+
+	messages := make(chan substrate.Message, 1)
+	messages <- message.FromString("a:1")
+	err := sink.PublishMessages(ctx, make(chan substrate.Message), messages)
+	require.EqualError(t, err, `failed to combine messages with key "a": invalid message`)
+	require.Equal(t, errCombine, errors.Cause(err))
+}
@@ -0,0 +1,552 @@
+package avro
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// encode appends the Avro binary encoding of the value to the buffer. Values are the result of decoding JSON
+// using json.Number for numbers, so bytes and fixed values are expected as base64 strings, which is how
+// encoding/json represents byte slices.
+func encode(buf []byte, s *schema, v interface{}) ([]byte, error) {
+	switch s.typ {
+	case typeNull:
+		if v != nil {
+			return nil, errors.Errorf("expected null, got %T", v)
+		}
+		return buf, nil
+	case typeBoolean:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, errors.Errorf("expected boolean, got %T", v)
+		}
+		if b {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case typeInt, typeLong:
+		n, ok := integer(v)
+		if !ok || (s.typ == typeInt && (n < math.MinInt32 || n > math.MaxInt32)) {
+			return nil, errors.Errorf("expected %s, got %v", s.typ, v)
+		}
+		return binary.AppendVarint(buf, n), nil
+	case typeFloat:
+		f, ok := float(v)
+		if !ok {
+			return nil, errors.Errorf("expected float, got %v", v)
+		}
+		return binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(f))), nil
+	case typeDouble:
+		f, ok := float(v)
+		if !ok {
+			return nil, errors.Errorf("expected double, got %v", v)
+		}
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(f)), nil
+	case typeBytes:
+		data, ok := bytesValue(v)
+		if !ok {
+			return nil, errors.Errorf("expected bytes, got %T", v)
+		}
+		return append(binary.AppendVarint(buf, int64(len(data))), data...), nil
+	case typeString:
+		str, ok := v.(string)
+		if !ok {
+			return nil, errors.Errorf("expected string, got %T", v)
+		}
+		return append(binary.AppendVarint(buf, int64(len(str))), str...), nil
+	case typeFixed:
+		data, ok := bytesValue(v)
+		if !ok || len(data) != s.size {
+			return nil, errors.Errorf("expected %d bytes for fixed %q", s.size, s.name)
+		}
+		return append(buf, data...), nil
+	case typeEnum:
+		symbol, _ := v.(string)
+		index := indexOf(s.symbols, symbol)
+		if index < 0 {
+			return nil, errors.Errorf("unknown symbol %v of enum %q", v, s.name)
+		}
+		return binary.AppendVarint(buf, int64(index)), nil
+	case typeArray:
+		items, ok := v.([]interface{})
+		if !ok && v != nil {
+			return nil, errors.Errorf("expected array, got %T", v)
+		}
+		var err error
+		if len(items) > 0 {
+			buf = binary.AppendVarint(buf, int64(len(items)))
+			for _, item := range items {
+				if buf, err = encode(buf, s.items, item); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return append(buf, 0), nil
+	case typeMap:
+		m, ok := v.(map[string]interface{})
+		if !ok && v != nil {
+			return nil, errors.Errorf("expected map, got %T", v)
+		}
+		var err error
+		if len(m) > 0 {
+			buf = binary.AppendVarint(buf, int64(len(m)))
+			for key, value := range m {
+				buf = append(binary.AppendVarint(buf, int64(len(key))), key...)
+				if buf, err = encode(buf, s.values, value); err != nil {
+					return nil, errors.Wrapf(err, "invalid value of key %q", key)
+				}
+			}
+		}
+		return append(buf, 0), nil
+	case typeRecord:
+		record, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("expected record %q, got %T", s.name, v)
+		}
+		var err error
+		for _, f := range s.fields {
+			value, ok := record[f.name]
+			if !ok {
+				if !f.hasDefault {
+					return nil, errors.Errorf("missing field %q of record %q", f.name, s.name)
+				}
+				value = f.def
+			}
+			if buf, err = encode(buf, f.schema, value); err != nil {
+				return nil, errors.Wrapf(err, "invalid field %q of record %q", f.name, s.name)
+			}
+		}
+		return buf, nil
+	case typeUnion:
+		for i, branch := range s.branches {
+			if fits(branch, v) {
+				return encode(binary.AppendVarint(buf, int64(i)), branch, v)
+			}
+		}
+		return nil, errors.Errorf("value %v doesn't match any branch of the union", v)
+	default:
+		return nil, errors.Errorf("unsupported type %s", s.typ)
+	}
+}
+
+// fits returns whether the value can be encoded using the schema, which is a branch of a union. It doesn't
+// validate nested values, so that the first branch with the right shape is chosen.
+func fits(s *schema, v interface{}) bool {
+	switch s.typ {
+	case typeNull:
+		return v == nil
+	case typeBoolean:
+		_, ok := v.(bool)
+		return ok
+	case typeInt:
+		n, ok := integer(v)
+		return ok && n >= math.MinInt32 && n <= math.MaxInt32
+	case typeLong:
+		_, ok := integer(v)
+		return ok
+	case typeFloat, typeDouble:
+		_, ok := float(v)
+		return ok
+	case typeString:
+		_, ok := v.(string)
+		return ok
+	case typeBytes:
+		_, ok := bytesValue(v)
+		return ok
+	case typeFixed:
+		data, ok := bytesValue(v)
+		return ok && len(data) == s.size
+	case typeEnum:
+		symbol, ok := v.(string)
+		return ok && indexOf(s.symbols, symbol) >= 0
+	case typeArray:
+		_, ok := v.([]interface{})
+		return ok
+	case typeMap:
+		_, ok := v.(map[string]interface{})
+		return ok
+	case typeRecord:
+		record, ok := v.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for name := range record {
+			if s.field(name) == nil {
+				return false
+			}
+		}
+		for _, f := range s.fields {
+			if _, ok := record[f.name]; !ok && !f.hasDefault {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func integer(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
+
+func float(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
+
+func bytesValue(v interface{}) ([]byte, bool) {
+	switch b := v.(type) {
+	case []byte:
+		return b, true
+	case string:
+		data, err := base64.StdEncoding.DecodeString(b)
+		return data, err == nil
+	default:
+		return nil, false
+	}
+}
+
+// decoder decodes values in the Avro binary encoding, resolving the schema they were written with
+// against the schema they are read with.
+type decoder struct {
+	data []byte
+}
+
+// read decodes a value written using the writer schema into a value of the reader schema, following the
+// Avro schema resolution rules. Records are decoded as maps, enums as strings and bytes and fixed values as
+// byte slices.
+func (d *decoder) read(w, r *schema) (interface{}, error) {
+	if w.typ == typeUnion {
+		index, err := d.readLong()
+		if err != nil {
+			return nil, err
+		}
+		if index < 0 || index >= int64(len(w.branches)) {
+			return nil, errors.Errorf("invalid union branch %d", index)
+		}
+		return d.read(w.branches[index], r)
+	}
+	if r.typ == typeUnion {
+		branch := resolveBranch(w, r)
+		if branch == nil {
+			return nil, errors.Errorf("%s doesn't match any branch of the reader union", describe(w))
+		}
+		return d.read(w, branch)
+	}
+	if !resolvable(w, r) {
+		return nil, errors.Errorf("writer %s doesn't match reader %s", describe(w), describe(r))
+	}
+
+	switch w.typ {
+	case typeRecord:
+		return d.readRecord(w, r)
+	case typeEnum:
+		index, err := d.readLong()
+		if err != nil {
+			return nil, err
+		}
+		if index < 0 || index >= int64(len(w.symbols)) {
+			return nil, errors.Errorf("invalid symbol %d of enum %q", index, w.name)
+		}
+		symbol := w.symbols[index]
+		if indexOf(r.symbols, symbol) >= 0 {
+			return symbol, nil
+		}
+		if !r.hasDefault {
+			return nil, errors.Errorf("symbol %q is unknown to reader enum %q", symbol, r.name)
+		}
+		return r.enumDefault, nil
+	case typeArray:
+		items := []interface{}{}
+		err := d.readBlocks(func() error {
+			item, err := d.read(w.items, r.items)
+			items = append(items, item)
+			return err
+		})
+		return items, err
+	case typeMap:
+		m := map[string]interface{}{}
+		err := d.readBlocks(func() error {
+			key, err := d.readBytes()
+			if err != nil {
+				return err
+			}
+			m[string(key)], err = d.read(w.values, r.values)
+			return err
+		})
+		return m, err
+	case typeFixed:
+		return d.next(w.size)
+	default:
+		v, err := d.readPrimitive(w.typ)
+		if err != nil {
+			return nil, err
+		}
+		return promote(v, r.typ), nil
+	}
+}
+
+func (d *decoder) readRecord(w, r *schema) (interface{}, error) {
+	record := make(map[string]interface{}, len(r.fields))
+	for _, wf := range w.fields {
+		rf := r.field(wf.name)
+		if rf == nil {
+			// fields unknown to the reader are skipped
+			if _, err := d.read(wf.schema, wf.schema); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		v, err := d.read(wf.schema, rf.schema)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid field %q of record %q", wf.name, w.name)
+		}
+		record[rf.name] = v
+	}
+	for _, rf := range r.fields {
+		if _, ok := record[rf.name]; ok {
+			continue
+		}
+		if !rf.hasDefault {
+			return nil, errors.Errorf("field %q of record %q is missing and has no default", rf.name, r.name)
+		}
+		record[rf.name] = rf.def
+	}
+	return record, nil
+}
+
+// readBlocks reads the blocks of an array or a map, calling the function for each item.
+func (d *decoder) readBlocks(item func() error) error {
+	for {
+		count, err := d.readLong()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return nil
+		}
+		if count < 0 {
+			// a negative count is followed by the size of the block in bytes
+			count = -count
+			if _, err := d.readLong(); err != nil {
+				return err
+			}
+		}
+		for i := int64(0); i < count; i++ {
+			if err := item(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (d *decoder) readPrimitive(typ string) (interface{}, error) {
+	switch typ {
+	case typeNull:
+		return nil, nil
+	case typeBoolean:
+		b, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0] != 0, nil
+	case typeInt:
+		n, err := d.readLong()
+		if err != nil {
+			return nil, err
+		}
+		if n < math.MinInt32 || n > math.MaxInt32 {
+			return nil, errors.Errorf("int %d out of range", n)
+		}
+		return int32(n), nil
+	case typeLong:
+		return d.readLong()
+	case typeFloat:
+		b, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float32frombits(binary.LittleEndian.Uint32(b)), nil
+	case typeDouble:
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case typeBytes:
+		return d.readBytes()
+	case typeString:
+		b, err := d.readBytes()
+		return string(b), err
+	default:
+		return nil, errors.Errorf("unsupported type %s", typ)
+	}
+}
+
+func (d *decoder) readLong() (int64, error) {
+	n, size := binary.Varint(d.data)
+	if size <= 0 {
+		return 0, errors.New("invalid varint")
+	}
+	d.data = d.data[size:]
+	return n, nil
+}
+
+func (d *decoder) readBytes() ([]byte, error) {
+	length, err := d.readLong()
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		return nil, errors.Errorf("invalid length %d", length)
+	}
+	return d.next(int(length))
+}
+
+// next returns a copy of the next n bytes.
+func (d *decoder) next(n int) ([]byte, error) {
+	if n > len(d.data) {
+		return nil, errors.New("unexpected end of data")
+	}
+	b := make([]byte, n)
+	copy(b, d.data)
+	d.data = d.data[n:]
+	return b, nil
+}
+
+// promotions lists the reader types a writer type can be promoted to, besides itself.
+var promotions = map[string][]string{
+	typeInt:    {typeLong, typeFloat, typeDouble},
+	typeLong:   {typeFloat, typeDouble},
+	typeFloat:  {typeDouble},
+	typeString: {typeBytes},
+	typeBytes:  {typeString},
+}
+
+// resolvable returns whether values written using the writer schema can be read using the reader schema,
+// without looking into nested schemas. Neither schema may be a union.
+func resolvable(w, r *schema) bool {
+	if w.typ != r.typ {
+		return indexOf(promotions[w.typ], r.typ) >= 0
+	}
+	switch w.typ {
+	case typeRecord, typeEnum:
+		return sameName(w, r)
+	case typeFixed:
+		return sameName(w, r) && w.size == r.size
+	default:
+		return true
+	}
+}
+
+// resolveBranch returns the first branch of the reader union matching the writer schema, preferring branches
+// that don't require a promotion.
+func resolveBranch(w, r *schema) *schema {
+	for _, branch := range r.branches {
+		if branch.typ == w.typ && resolvable(w, branch) {
+			return branch
+		}
+	}
+	for _, branch := range r.branches {
+		if resolvable(w, branch) {
+			return branch
+		}
+	}
+	return nil
+}
+
+// sameName returns whether the unqualified name of the writer type matches the name, or an alias,
+// of the reader type.
+func sameName(w, r *schema) bool {
+	name := shortName(w.name)
+	if name == shortName(r.name) {
+		return true
+	}
+	for _, alias := range r.aliases {
+		if name == shortName(alias) {
+			return true
+		}
+	}
+	return false
+}
+
+// promote converts a primitive value to the reader type.
+func promote(v interface{}, typ string) interface{} {
+	switch typ {
+	case typeLong:
+		if n, ok := v.(int32); ok {
+			return int64(n)
+		}
+	case typeFloat:
+		switch n := v.(type) {
+		case int32:
+			return float32(n)
+		case int64:
+			return float32(n)
+		}
+	case typeDouble:
+		switch n := v.(type) {
+		case int32:
+			return float64(n)
+		case int64:
+			return float64(n)
+		case float32:
+			return float64(n)
+		}
+	case typeBytes:
+		if s, ok := v.(string); ok {
+			return []byte(s)
+		}
+	case typeString:
+		if b, ok := v.([]byte); ok {
+			return string(b)
+		}
+	}
+	return v
+}
+
+func describe(s *schema) string {
+	if s.name != "" {
+		return s.typ + " " + s.name
+	}
+	return s.typ
+}
+
+// field returns the field with the name, or with the name as an alias.
+func (s *schema) field(name string) *field {
+	for _, f := range s.fields {
+		if f.name == name {
+			return f
+		}
+	}
+	for _, f := range s.fields {
+		if indexOf(f.aliases, name) >= 0 {
+			return f
+		}
+	}
+	return nil
+}
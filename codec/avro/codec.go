@@ -0,0 +1,184 @@
+// Package avro provides a typed.Codec for Avro encoded values, supporting schema evolution through
+// writer and reader schema resolution, and optionally framing payloads in the Confluent wire format
+// using a schema registry.
+package avro
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate-tools/codec/registry"
+	"github.com/uw-labs/substrate-tools/typed"
+)
+
+// Codec encodes values of type T in the Avro binary encoding. Values are mapped to Avro data through their
+// JSON representation: records are matched with structs or maps by their JSON field names, enums are
+// strings, bytes and fixed values are byte slices, and unions are the value of their branch, with null
+// represented by nil pointers.
+type Codec[T any] struct {
+	schema *schema
+	// writer is the schema payloads are decoded from, when not using a registry.
+	writer *schema
+
+	client   *registry.Client
+	schemaID int
+
+	mutex   sync.RWMutex
+	writers map[int]*schema
+}
+
+var _ typed.Codec[any] = (*Codec[any])(nil)
+
+// Option is a function which sets a Codec configuration option.
+type Option func(c *options)
+
+type options struct {
+	writerSchema string
+}
+
+// WithWriterSchema sets the schema payloads were written with, when it differs from the schema of the codec,
+// e.g. while producers haven't been upgraded to a new version of the schema yet. Payloads are resolved
+// against the schema of the codec when decoding. It is ignored by registry codecs, which look up the
+// writer schema of each payload in the registry.
+func WithWriterSchema(definition string) Option {
+	return func(o *options) {
+		o.writerSchema = definition
+	}
+}
+
+// NewCodec returns a codec that encodes values using the schema definition, which must be the JSON
+// representation of an Avro schema.
+func NewCodec[T any](definition string, opts ...Option) (*Codec[T], error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	s, err := parseSchema(definition)
+	if err != nil {
+		return nil, err
+	}
+	c := &Codec[T]{schema: s, writer: s}
+	if o.writerSchema != "" {
+		if c.writer, err = parseSchema(o.writerSchema); err != nil {
+			return nil, errors.Wrap(err, "invalid writer schema")
+		}
+	}
+	return c, nil
+}
+
+// NewRegistryCodec returns a codec that registers the schema under the subject and frames payloads in the
+// Confluent wire format, so that they can be read by consumers relying on the registry. When decoding, the
+// schema each payload was written with is retrieved from the registry and resolved against the schema of
+// the codec, so that payloads produced with other versions of the schema can be read.
+func NewRegistryCodec[T any](ctx context.Context, client *registry.Client, subject, definition string) (*Codec[T], error) {
+	s, err := parseSchema(definition)
+	if err != nil {
+		return nil, err
+	}
+	id, err := client.Register(ctx, subject, registry.Schema{
+		Schema:     definition,
+		SchemaType: registry.SchemaTypeAvro,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Codec[T]{
+		schema:   s,
+		writer:   s,
+		client:   client,
+		schemaID: id,
+		writers:  map[int]*schema{id: s},
+	}, nil
+}
+
+// Encode encodes the value.
+func (c *Codec[T]) Encode(v T) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	payload, err := encode(nil, c.schema, value)
+	if err != nil {
+		return nil, err
+	}
+	if c.client == nil {
+		return payload, nil
+	}
+	return registry.Frame(c.schemaID, payload), nil
+}
+
+// Decode decodes the value.
+func (c *Codec[T]) Decode(data []byte) (T, error) {
+	var zero T
+
+	writer := c.writer
+	if c.client != nil {
+		id, payload, err := registry.Unframe(data)
+		if err != nil {
+			return zero, err
+		}
+		if writer, err = c.writerSchema(id); err != nil {
+			return zero, err
+		}
+		data = payload
+	}
+
+	d := decoder{data: data}
+	value, err := d.read(writer, c.schema)
+	if err != nil {
+		return zero, err
+	}
+	if len(d.data) > 0 {
+		return zero, errors.New("unexpected data after Avro value")
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return zero, err
+	}
+	var v T
+	if err := json.Unmarshal(encoded, &v); err != nil {
+		return zero, err
+	}
+	return v, nil
+}
+
+// writerSchema returns the parsed schema with the ID, retrieving it from the registry if it wasn't seen before.
+func (c *Codec[T]) writerSchema(id int) (*schema, error) {
+	c.mutex.RLock()
+	s, ok := c.writers[id]
+	c.mutex.RUnlock()
+	if ok {
+		return s, nil
+	}
+
+	registered, err := c.client.Schema(context.Background(), id)
+	if err != nil {
+		return nil, err
+	}
+	if registered.SchemaType != "" && registered.SchemaType != registry.SchemaTypeAvro {
+		return nil, errors.Errorf("schema %d is not an Avro schema", id)
+	}
+	if s, err = parseSchema(registered.Schema); err != nil {
+		return nil, errors.Wrapf(err, "invalid schema %d", id)
+	}
+
+	c.mutex.Lock()
+	c.writers[id] = s
+	c.mutex.Unlock()
+
+	return s, nil
+}
@@ -0,0 +1,200 @@
+package avro_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate-tools/codec/avro"
+	"github.com/uw-labs/substrate-tools/codec/registry"
+)
+
+const userV1 = `{
+	"type": "record",
+	"name": "User",
+	"namespace": "com.example",
+	"fields": [
+		{"name": "name", "type": "string"},
+		{"name": "age", "type": "int"},
+		{"name": "nickname", "type": ["null", "string"], "default": null}
+	]
+}`
+
+// userV2 drops the nickname, promotes the age to a long and adds fields with defaults.
+const userV2 = `{
+	"type": "record",
+	"name": "User",
+	"namespace": "com.example",
+	"fields": [
+		{"name": "name", "type": "string"},
+		{"name": "age", "type": "long"},
+		{"name": "role", "type": {"type": "enum", "name": "Role", "symbols": ["UNKNOWN", "ADMIN"], "default": "UNKNOWN"}, "default": "UNKNOWN"},
+		{"name": "tags", "type": {"type": "array", "items": "string"}, "default": []}
+	]
+}`
+
+type UserV1 struct {
+	Name     string  `json:"name"`
+	Age      int32   `json:"age"`
+	Nickname *string `json:"nickname"`
+}
+
+type UserV2 struct {
+	Name string   `json:"name"`
+	Age  int64    `json:"age"`
+	Role string   `json:"role"`
+	Tags []string `json:"tags"`
+}
+
+func TestCodec(t *testing.T) {
+	codec, err := avro.NewCodec[UserV1](userV1)
+	require.NoError(t, err)
+
+	data, err := codec.Encode(UserV1{Name: "a", Age: 1})
+	require.NoError(t, err)
+	// zig-zag encoded length and bytes of the name, zig-zag encoded age and the index of the null branch
+	require.Equal(t, []byte{2, 'a', 2, 0}, data)
+
+	nickname := "b"
+	data, err = codec.Encode(UserV1{Name: "a", Age: -1, Nickname: &nickname})
+	require.NoError(t, err)
+	require.Equal(t, []byte{2, 'a', 1, 2, 2, 'b'}, data)
+
+	user, err := codec.Decode(data)
+	require.NoError(t, err)
+	require.Equal(t, UserV1{Name: "a", Age: -1, Nickname: &nickname}, user)
+
+	_, err = codec.Decode(append(data, 0))
+	require.Error(t, err)
+}
+
+func TestCodec_ComplexTypes(t *testing.T) {
+	type Value struct {
+		Bytes  []byte             `json:"bytes"`
+		Fixed  []byte             `json:"fixed"`
+		Map    map[string]float64 `json:"map"`
+		Nested []map[string]any   `json:"nested"`
+		Float  float32            `json:"float"`
+	}
+	codec, err := avro.NewCodec[Value](`{
+		"type": "record",
+		"name": "Value",
+		"fields": [
+			{"name": "bytes", "type": "bytes"},
+			{"name": "fixed", "type": {"type": "fixed", "name": "Hash", "size": 2}},
+			{"name": "map", "type": {"type": "map", "values": "double"}},
+			{"name": "nested", "type": {"type": "array", "items": {
+				"type": "record",
+				"name": "Node",
+				"fields": [{"name": "next", "type": ["null", "Node"], "default": null}]
+			}}},
+			{"name": "float", "type": "float"}
+		]
+	}`)
+	require.NoError(t, err)
+
+	value := Value{
+		Bytes:  []byte{0, 1, 2},
+		Fixed:  []byte{3, 4},
+		Map:    map[string]float64{"pi": 3.14},
+		Nested: []map[string]any{{"next": map[string]any{"next": nil}}},
+		Float:  1.5,
+	}
+	data, err := codec.Encode(value)
+	require.NoError(t, err)
+
+	decoded, err := codec.Decode(data)
+	require.NoError(t, err)
+	require.Equal(t, value, decoded)
+
+	_, err = codec.Encode(Value{Fixed: []byte{1}})
+	require.Error(t, err)
+}
+
+func TestCodec_SchemaEvolution(t *testing.T) {
+	writer, err := avro.NewCodec[UserV1](userV1)
+	require.NoError(t, err)
+	reader, err := avro.NewCodec[UserV2](userV2, avro.WithWriterSchema(userV1))
+	require.NoError(t, err)
+
+	nickname := "b"
+	data, err := writer.Encode(UserV1{Name: "a", Age: 30, Nickname: &nickname})
+	require.NoError(t, err)
+
+	user, err := reader.Decode(data)
+	require.NoError(t, err)
+	require.Equal(t, UserV2{Name: "a", Age: 30, Role: "UNKNOWN", Tags: []string{}}, user)
+
+	// Reader fields without a default must be written.
+	strict, err := avro.NewCodec[map[string]any](`{
+		"type": "record",
+		"name": "User",
+		"fields": [{"name": "email", "type": "string"}]
+	}`, avro.WithWriterSchema(userV1))
+	require.NoError(t, err)
+	_, err = strict.Decode(data)
+	require.Error(t, err)
+}
+
+func TestCodec_InvalidSchema(t *testing.T) {
+	for _, schema := range []string{
+		`{"type": "record", "fields": []}`,
+		`{"type": "record", "name": "R", "fields": [{"name": "f", "type": "Unknown"}]}`,
+		`{"type": "enum", "name": "E", "symbols": ["A"], "default": "B"}`,
+		`[]`,
+	} {
+		_, err := avro.NewCodec[any](schema)
+		require.Error(t, err, schema)
+	}
+}
+
+func TestRegistryCodec(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/subjects/topic-value/versions":
+			w.Write([]byte(`{"id":2}`))
+		case "/schemas/ids/1":
+			json.NewEncoder(w).Encode(registry.Schema{Schema: userV1})
+		case "/schemas/ids/3":
+			json.NewEncoder(w).Encode(registry.Schema{Schema: "...", SchemaType: registry.SchemaTypeProtobuf})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := registry.NewClient(server.URL)
+	codec, err := avro.NewRegistryCodec[UserV2](context.Background(), client, "topic-value", userV2)
+	require.NoError(t, err)
+
+	data, err := codec.Encode(UserV2{Name: "a", Age: 1, Role: "ADMIN", Tags: []string{"x"}})
+	require.NoError(t, err)
+	id, _, err := registry.Unframe(data)
+	require.NoError(t, err)
+	require.Equal(t, 2, id)
+
+	user, err := codec.Decode(data)
+	require.NoError(t, err)
+	require.Equal(t, UserV2{Name: "a", Age: 1, Role: "ADMIN", Tags: []string{"x"}}, user)
+
+	// Payloads written with an older version of the schema are resolved against the current one.
+	v1, err := avro.NewCodec[UserV1](userV1)
+	require.NoError(t, err)
+	payload, err := v1.Encode(UserV1{Name: "b", Age: 2})
+	require.NoError(t, err)
+	user, err = codec.Decode(registry.Frame(1, payload))
+	require.NoError(t, err)
+	require.Equal(t, UserV2{Name: "b", Age: 2, Role: "UNKNOWN", Tags: []string{}}, user)
+
+	// Payloads framed with a schema that is not an Avro schema are rejected.
+	_, err = codec.Decode(registry.Frame(3, payload))
+	require.Error(t, err)
+
+	// Payloads framed with an unknown schema are rejected.
+	_, err = codec.Decode(registry.Frame(4, payload))
+	require.Error(t, err)
+}
@@ -0,0 +1,358 @@
+package avro
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Avro schema types. Logical types are treated as their underlying type.
+const (
+	typeNull    = "null"
+	typeBoolean = "boolean"
+	typeInt     = "int"
+	typeLong    = "long"
+	typeFloat   = "float"
+	typeDouble  = "double"
+	typeBytes   = "bytes"
+	typeString  = "string"
+	typeRecord  = "record"
+	typeEnum    = "enum"
+	typeArray   = "array"
+	typeMap     = "map"
+	typeFixed   = "fixed"
+	typeUnion   = "union"
+)
+
+var primitives = map[string]bool{
+	typeNull:    true,
+	typeBoolean: true,
+	typeInt:     true,
+	typeLong:    true,
+	typeFloat:   true,
+	typeDouble:  true,
+	typeBytes:   true,
+	typeString:  true,
+}
+
+// schema is a parsed Avro schema. Named types are shared by all the schemas referencing them, so that
+// recursive types are represented by cycles.
+type schema struct {
+	typ string
+
+	// name and aliases are the full names of named types.
+	name    string
+	aliases []string
+
+	fields   []*field
+	symbols  []string
+	items    *schema
+	values   *schema
+	branches []*schema
+	size     int
+
+	// enumDefault is the symbol used when reading an unknown symbol, if hasDefault is set.
+	enumDefault string
+	hasDefault  bool
+}
+
+type field struct {
+	name       string
+	aliases    []string
+	schema     *schema
+	def        interface{}
+	hasDefault bool
+}
+
+// parseSchema parses the JSON representation of an Avro schema.
+func parseSchema(data string) (*schema, error) {
+	var raw interface{}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(data)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, errors.Wrap(err, "failed to decode Avro schema")
+	}
+	p := parser{names: make(map[string]*schema)}
+	s, err := p.parse(raw, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid Avro schema")
+	}
+	return s, nil
+}
+
+type parser struct {
+	names map[string]*schema
+}
+
+func (p *parser) parse(raw interface{}, namespace string) (*schema, error) {
+	switch v := raw.(type) {
+	case string:
+		if primitives[v] {
+			return &schema{typ: v}, nil
+		}
+		if s, ok := p.names[fullName(v, namespace)]; ok {
+			return s, nil
+		}
+		if s, ok := p.names[v]; ok {
+			return s, nil
+		}
+		return nil, errors.Errorf("unknown type %q", v)
+	case []interface{}:
+		s := &schema{typ: typeUnion}
+		for _, branch := range v {
+			b, err := p.parse(branch, namespace)
+			if err != nil {
+				return nil, err
+			}
+			if b.typ == typeUnion {
+				return nil, errors.New("unions can't contain unions")
+			}
+			s.branches = append(s.branches, b)
+		}
+		if len(s.branches) == 0 {
+			return nil, errors.New("unions must have at least one branch")
+		}
+		return s, nil
+	case map[string]interface{}:
+		return p.parseObject(v, namespace)
+	default:
+		return nil, errors.Errorf("unexpected schema %v", raw)
+	}
+}
+
+func (p *parser) parseObject(obj map[string]interface{}, namespace string) (*schema, error) {
+	typ, ok := obj["type"].(string)
+	if !ok {
+		// the type is itself a schema, e.g. {"type": {"type": "array", "items": "int"}}
+		return p.parse(obj["type"], namespace)
+	}
+
+	switch typ {
+	case typeRecord, "error", typeEnum, typeFixed:
+		return p.parseNamed(obj, typ, namespace)
+	case typeArray:
+		items, err := p.parse(obj["items"], namespace)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid array items")
+		}
+		return &schema{typ: typeArray, items: items}, nil
+	case typeMap:
+		values, err := p.parse(obj["values"], namespace)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid map values")
+		}
+		return &schema{typ: typeMap, values: values}, nil
+	default:
+		return p.parse(typ, namespace)
+	}
+}
+
+func (p *parser) parseNamed(obj map[string]interface{}, typ, namespace string) (*schema, error) {
+	name, ok := obj["name"].(string)
+	if !ok || name == "" {
+		return nil, errors.Errorf("%s must have a name", typ)
+	}
+	if ns, ok := obj["namespace"].(string); ok && !strings.Contains(name, ".") {
+		namespace = ns
+	}
+	name = fullName(name, namespace)
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		namespace = name[:i]
+	}
+	if _, ok := p.names[name]; ok {
+		return nil, errors.Errorf("type %q is defined twice", name)
+	}
+
+	s := &schema{typ: typ, name: name}
+	if typ == "error" {
+		s.typ = typeRecord
+	}
+	aliases, err := stringList(obj["aliases"])
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid aliases of %q", name)
+	}
+	for _, alias := range aliases {
+		s.aliases = append(s.aliases, fullName(alias, namespace))
+	}
+	// the type is registered before parsing its fields, so that they can reference it
+	p.names[name] = s
+
+	switch s.typ {
+	case typeRecord:
+		fields, ok := obj["fields"].([]interface{})
+		if !ok {
+			return nil, errors.Errorf("record %q must have fields", name)
+		}
+		for _, raw := range fields {
+			f, err := p.parseField(raw, namespace)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid field of record %q", name)
+			}
+			s.fields = append(s.fields, f)
+		}
+	case typeEnum:
+		if s.symbols, err = stringList(obj["symbols"]); err != nil || len(s.symbols) == 0 {
+			return nil, errors.Errorf("enum %q must have symbols", name)
+		}
+		if def, ok := obj["default"]; ok {
+			if s.enumDefault, ok = def.(string); !ok || indexOf(s.symbols, s.enumDefault) < 0 {
+				return nil, errors.Errorf("invalid default of enum %q", name)
+			}
+			s.hasDefault = true
+		}
+	case typeFixed:
+		size, ok := obj["size"].(json.Number)
+		if !ok {
+			return nil, errors.Errorf("fixed %q must have a size", name)
+		}
+		n, err := size.Int64()
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("invalid size of fixed %q", name)
+		}
+		s.size = int(n)
+	}
+	return s, nil
+}
+
+func (p *parser) parseField(raw interface{}, namespace string) (*field, error) {
+	obj, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("field must be an object")
+	}
+	name, ok := obj["name"].(string)
+	if !ok || name == "" {
+		return nil, errors.New("field must have a name")
+	}
+	s, err := p.parse(obj["type"], namespace)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid type of field %q", name)
+	}
+	aliases, err := stringList(obj["aliases"])
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid aliases of field %q", name)
+	}
+
+	f := &field{name: name, aliases: aliases, schema: s}
+	if def, ok := obj["default"]; ok {
+		if f.def, err = defaultValue(s, def); err != nil {
+			return nil, errors.Wrapf(err, "invalid default of field %q", name)
+		}
+		f.hasDefault = true
+	}
+	return f, nil
+}
+
+// defaultValue converts the JSON encoded default value of a field into the value decoded for the schema.
+// The default value of a union is a value of its first branch.
+func defaultValue(s *schema, def interface{}) (interface{}, error) {
+	switch s.typ {
+	case typeUnion:
+		return defaultValue(s.branches[0], def)
+	case typeBytes, typeFixed:
+		str, ok := def.(string)
+		if !ok {
+			return nil, errors.Errorf("expected %s default to be a string", s.typ)
+		}
+		// bytes are encoded as strings whose code points are the byte values
+		data := make([]byte, 0, len(str))
+		for _, r := range str {
+			if r > 0xff {
+				return nil, errors.Errorf("invalid %s default", s.typ)
+			}
+			data = append(data, byte(r))
+		}
+		return data, nil
+	case typeRecord:
+		obj, ok := def.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("expected record default to be an object")
+		}
+		record := make(map[string]interface{}, len(s.fields))
+		for _, f := range s.fields {
+			value, ok := obj[f.name]
+			if !ok {
+				if !f.hasDefault {
+					return nil, errors.Errorf("record default is missing field %q", f.name)
+				}
+				record[f.name] = f.def
+				continue
+			}
+			v, err := defaultValue(f.schema, value)
+			if err != nil {
+				return nil, err
+			}
+			record[f.name] = v
+		}
+		return record, nil
+	case typeArray:
+		items, ok := def.([]interface{})
+		if !ok {
+			return nil, errors.New("expected array default to be an array")
+		}
+		array := make([]interface{}, len(items))
+		for i, item := range items {
+			v, err := defaultValue(s.items, item)
+			if err != nil {
+				return nil, err
+			}
+			array[i] = v
+		}
+		return array, nil
+	case typeMap:
+		obj, ok := def.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("expected map default to be an object")
+		}
+		m := make(map[string]interface{}, len(obj))
+		for key, value := range obj {
+			v, err := defaultValue(s.values, value)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+		}
+		return m, nil
+	default:
+		return def, nil
+	}
+}
+
+func fullName(name, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+// shortName returns the unqualified name of a named type.
+func shortName(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+func stringList(raw interface{}) ([]string, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	values, ok := raw.([]interface{})
+	if !ok {
+		return nil, errors.New("expected an array of strings")
+	}
+	result := make([]string, len(values))
+	for i, v := range values {
+		if result[i], ok = v.(string); !ok {
+			return nil, errors.New("expected an array of strings")
+		}
+	}
+	return result, nil
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
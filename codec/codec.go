@@ -0,0 +1,89 @@
+// Package codec provides a registry of the typed codecs of a type by content type, so that typed sinks and sources
+// can publish and consume several formats on the same topic, e.g. while gradually migrating from one to another.
+// The codecs of specific formats are provided by its sub-packages.
+package codec
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate-tools/typed"
+)
+
+// The content types of common formats.
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeAvro     = "application/avro"
+)
+
+// Registry holds the codecs of values of type T by content type. It implements typed.Codecs, to be used with
+// typed.NewSinkWithCodecs and typed.NewSourceWithCodecs. It is safe for concurrent use.
+type Registry[T any] struct {
+	mutex          sync.RWMutex
+	codecs         map[string]typed.Codec[T]
+	defaultContent string
+}
+
+// NewRegistry returns a registry without any codecs.
+func NewRegistry[T any]() *Registry[T] {
+	return &Registry[T]{
+		codecs: make(map[string]typed.Codec[T]),
+	}
+}
+
+// Register registers the codec of the content type, replacing any codec registered before. The first codec
+// registered is the default one, until another one is set using SetDefault.
+func (r *Registry[T]) Register(contentType string, codec typed.Codec[T]) *Registry[T] {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.codecs) == 0 {
+		r.defaultContent = contentType
+	}
+	r.codecs[contentType] = codec
+	return r
+}
+
+// SetDefault sets the content type whose codec is used for values and messages without a content type, e.g.
+// messages published before the content type was recorded.
+func (r *Registry[T]) SetDefault(contentType string) *Registry[T] {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.defaultContent = contentType
+	return r
+}
+
+// Codec returns the codec of the content type, or the default codec if the content type is empty. It returns an
+// error if no codec is registered for the content type.
+func (r *Registry[T]) Codec(contentType string) (typed.Codec[T], error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if contentType == "" {
+		contentType = r.defaultContent
+	}
+	codec, ok := r.codecs[contentType]
+	if !ok {
+		return nil, errors.Errorf("no codec registered for content type %q", contentType)
+	}
+	return codec, nil
+}
+
+// ContentTypes returns the content types of the registered codecs, in alphabetical order.
+func (r *Registry[T]) ContentTypes() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	contentTypes := make([]string, 0, len(r.codecs))
+	for contentType := range r.codecs {
+		contentTypes = append(contentTypes, contentType)
+	}
+	sort.Strings(contentTypes)
+	return contentTypes
+}
+
+var _ typed.Codecs[struct{}] = (*Registry[struct{}])(nil)
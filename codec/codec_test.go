@@ -0,0 +1,135 @@
+package codec_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/codec"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/typed"
+)
+
+type event struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// textCodec encodes events as "<id>:<name>".
+var textCodec = typed.CodecFuncs[event]{
+	EncodeFunc: func(v event) ([]byte, error) {
+		return []byte(fmt.Sprintf("%d:%s", v.ID, v.Name)), nil
+	},
+	DecodeFunc: func(data []byte) (event, error) {
+		var v event
+		_, err := fmt.Sscanf(string(data), "%d:%s", &v.ID, &v.Name)
+		return v, err
+	},
+}
+
+func TestRegistry(t *testing.T) {
+	registry := codec.NewRegistry[event]().
+		Register(codec.ContentTypeJSON, typed.JSONCodec[event]{}).
+		Register("text/plain", textCodec)
+	require.Equal(t, []string{codec.ContentTypeJSON, "text/plain"}, registry.ContentTypes())
+
+	// The first codec is the default one.
+	c, err := registry.Codec("")
+	require.NoError(t, err)
+	require.Equal(t, typed.JSONCodec[event]{}, c)
+
+	registry.SetDefault("text/plain")
+	data, err := registry.Codec("")
+	require.NoError(t, err)
+	encoded, err := data.Encode(event{ID: 1, Name: "created"})
+	require.NoError(t, err)
+	require.Equal(t, "1:created", string(encoded))
+
+	_, err = registry.Codec("application/xml")
+	require.EqualError(t, err, `no codec registered for content type "application/xml"`)
+}
+
+func TestRegistry_MixedFormats(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	registry := codec.NewRegistry[event]().
+		Register(codec.ContentTypeJSON, typed.JSONCodec[event]{}).
+		Register("text/plain", textCodec)
+
+	// Events are migrated to JSON, except for the legacy ones.
+	sink := typed.NewSinkWithCodecs[event](message.NewEnvelopeSink(broker.NewAsyncMessageSink("topic")), registry, func(v event) string {
+		if v.Name == "legacy" {
+			return "text/plain"
+		}
+		return codec.ContentTypeJSON
+	})
+	acks, messages := make(chan event), make(chan event)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+	published := []event{{ID: 1, Name: "legacy"}, {ID: 2, Name: "created"}}
+	for _, v := range published {
+		messages <- v
+		require.Equal(t, v, <-acks)
+	}
+	cancel()
+	require.NoError(t, <-errs)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source := typed.NewSourceWithCodecs[event](message.NewEnvelopeSource(broker.NewAsyncMessageSource("topic", "group")), registry)
+	consumed, consumedAcks := make(chan *typed.Message[event]), make(chan *typed.Message[event])
+	go func() {
+		errs <- source.ConsumeMessages(ctx, consumed, consumedAcks)
+	}()
+	var events []event
+	for range published {
+		msg := <-consumed
+		events = append(events, msg.Value)
+		consumedAcks <- msg
+	}
+	require.Equal(t, published, events)
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestRegistry_UnknownContentType(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	publishCtx, publishCancel := context.WithCancel(ctx)
+	sink := message.NewEnvelopeSink(broker.NewAsyncMessageSink("topic"))
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	go sink.PublishMessages(publishCtx, acks, messages)
+	messages <- message.NewEnvelopedMessage(message.Envelope{ContentType: "application/xml", Payload: []byte("<event/>")})
+	<-acks
+	messages <- message.NewEnvelopedMessage(message.Envelope{ContentType: codec.ContentTypeJSON, Payload: []byte(`{"id":1}`)})
+	<-acks
+	publishCancel()
+
+	// Messages whose content type has no codec are handled like messages that can't be decoded.
+	registry := codec.NewRegistry[event]().Register(codec.ContentTypeJSON, typed.JSONCodec[event]{})
+	source := typed.NewSourceWithCodecs[event](message.NewEnvelopeSource(broker.NewAsyncMessageSource("topic", "group")), registry, typed.WithSkipDecodeErrors())
+	consumed, consumedAcks := make(chan *typed.Message[event]), make(chan *typed.Message[event])
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, consumed, consumedAcks)
+	}()
+	msg := <-consumed
+	require.Equal(t, event{ID: 1}, msg.Value)
+	consumedAcks <- msg
+
+	cancel()
+	require.NoError(t, <-errs)
+}
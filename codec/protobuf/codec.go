@@ -0,0 +1,158 @@
+// Package protobuf provides a typed.Codec for protobuf messages, optionally framing payloads in the
+// Confluent wire format using a schema registry.
+package protobuf
+
+import (
+	"context"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/uw-labs/substrate-tools/codec/registry"
+	"github.com/uw-labs/substrate-tools/typed"
+)
+
+// Codec encodes protobuf messages of type T, which must be a pointer to a generated message type.
+type Codec[T proto.Message] struct {
+	client   *registry.Client
+	schemaID int
+	path     []int
+	indexes  []byte
+}
+
+var _ typed.Codec[proto.Message] = (*Codec[proto.Message])(nil)
+
+// NewCodec returns a codec that encodes messages using the protobuf binary format.
+func NewCodec[T proto.Message]() *Codec[T] {
+	return &Codec[T]{}
+}
+
+// NewRegistryCodec returns a codec that registers the schema under the subject and frames payloads in the
+// Confluent wire format, so that they can be read by consumers relying on the registry. The schema must be
+// the source of the .proto file defining the message type. When decoding, the schema ID of each payload is
+// validated against the registry.
+func NewRegistryCodec[T proto.Message](ctx context.Context, client *registry.Client, subject, schema string) (*Codec[T], error) {
+	id, err := client.Register(ctx, subject, registry.Schema{
+		Schema:     schema,
+		SchemaType: registry.SchemaTypeProtobuf,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var zero T
+	path := messageIndexes(zero.ProtoReflect().Descriptor())
+	return &Codec[T]{
+		client:   client,
+		schemaID: id,
+		path:     path,
+		indexes:  encodeIndexes(path),
+	}, nil
+}
+
+// Encode encodes the message.
+func (c *Codec[T]) Encode(v T) ([]byte, error) {
+	data, err := proto.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if c.client == nil {
+		return data, nil
+	}
+	// the indexes are shared by concurrent calls, so the payload is built in a new buffer
+	payload := make([]byte, 0, len(c.indexes)+len(data))
+	payload = append(append(payload, c.indexes...), data...)
+	return registry.Frame(c.schemaID, payload), nil
+}
+
+// Decode decodes the message.
+func (c *Codec[T]) Decode(data []byte) (T, error) {
+	var zero T
+	if c.client != nil {
+		payload, err := c.unframe(data)
+		if err != nil {
+			return zero, err
+		}
+		data = payload
+	}
+
+	msg := zero.ProtoReflect().New().Interface().(T)
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return zero, err
+	}
+	return msg, nil
+}
+
+func (c *Codec[T]) unframe(data []byte) ([]byte, error) {
+	id, payload, err := registry.Unframe(data)
+	if err != nil {
+		return nil, err
+	}
+	if id != c.schemaID {
+		schema, err := c.client.Schema(context.Background(), id)
+		if err != nil {
+			return nil, err
+		}
+		if schema.SchemaType != registry.SchemaTypeProtobuf {
+			return nil, errors.Errorf("schema %d is not a protobuf schema", id)
+		}
+	}
+
+	indexes, n, err := decodeIndexes(payload)
+	if err != nil {
+		return nil, err
+	}
+	if string(encodeIndexes(indexes)) != string(c.indexes) {
+		return nil, errors.Errorf("payload encodes message %v, expected %v", indexes, c.path)
+	}
+	return payload[n:], nil
+}
+
+// messageIndexes returns the path of indexes locating the message descriptor within its file.
+func messageIndexes(desc protoreflect.MessageDescriptor) []int {
+	var indexes []int
+	for d := protoreflect.Descriptor(desc); ; {
+		indexes = append([]int{d.Index()}, indexes...)
+		parent, ok := d.Parent().(protoreflect.MessageDescriptor)
+		if !ok {
+			return indexes
+		}
+		d = parent
+	}
+}
+
+// encodeIndexes encodes the message indexes as zig-zag varints prefixed by their count. The most common
+// case of the first message in the file is encoded as a single zero.
+func encodeIndexes(indexes []int) []byte {
+	if len(indexes) == 1 && indexes[0] == 0 {
+		return []byte{0}
+	}
+	buf := binary.AppendVarint(nil, int64(len(indexes)))
+	for _, index := range indexes {
+		buf = binary.AppendVarint(buf, int64(index))
+	}
+	return buf
+}
+
+func decodeIndexes(data []byte) ([]int, int, error) {
+	count, n := binary.Varint(data)
+	if n <= 0 || count < 0 {
+		return nil, 0, errors.New("invalid message indexes")
+	}
+	if count == 0 {
+		return []int{0}, n, nil
+	}
+
+	indexes := make([]int, count)
+	for i := range indexes {
+		index, m := binary.Varint(data[n:])
+		if m <= 0 {
+			return nil, 0, errors.New("invalid message indexes")
+		}
+		indexes[i] = int(index)
+		n += m
+	}
+	return indexes, n, nil
+}
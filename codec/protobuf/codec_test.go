@@ -0,0 +1,97 @@
+package protobuf_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/uw-labs/substrate-tools/codec/protobuf"
+	"github.com/uw-labs/substrate-tools/codec/registry"
+)
+
+func TestCodec(t *testing.T) {
+	codec := protobuf.NewCodec[*wrapperspb.StringValue]()
+
+	data, err := codec.Encode(wrapperspb.String("value"))
+	require.NoError(t, err)
+
+	msg, err := codec.Decode(data)
+	require.NoError(t, err)
+	require.True(t, proto.Equal(wrapperspb.String("value"), msg))
+}
+
+func newRegistryCodec(t *testing.T) *protobuf.Codec[*wrapperspb.StringValue] {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/subjects/topic-value/versions":
+			w.Write([]byte(`{"id":1}`))
+		case "/schemas/ids/2":
+			w.Write([]byte(`{"schema":"..."}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := registry.NewClient(server.URL)
+	codec, err := protobuf.NewRegistryCodec[*wrapperspb.StringValue](context.Background(), client, "topic-value", "...")
+	require.NoError(t, err)
+	return codec
+}
+
+func TestRegistryCodec(t *testing.T) {
+	codec := newRegistryCodec(t)
+
+	data, err := codec.Encode(wrapperspb.String("value"))
+	require.NoError(t, err)
+
+	id, payload, err := registry.Unframe(data)
+	require.NoError(t, err)
+	require.Equal(t, 1, id)
+	// StringValue is the eighth message defined in wrappers.proto.
+	require.Equal(t, []byte{2, 14}, payload[:2])
+
+	msg, err := codec.Decode(data)
+	require.NoError(t, err)
+	require.True(t, proto.Equal(wrapperspb.String("value"), msg))
+
+	// Payloads framed with a schema that is not a protobuf schema are rejected.
+	_, err = codec.Decode(registry.Frame(2, payload))
+	require.Error(t, err)
+
+	// Payloads framed with an unknown schema are rejected.
+	_, err = codec.Decode(registry.Frame(3, payload))
+	require.Error(t, err)
+}
+
+func TestRegistryCodec_ConcurrentEncode(t *testing.T) {
+	codec := newRegistryCodec(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(value string) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				data, err := codec.Encode(wrapperspb.String(value))
+				if !assert.NoError(t, err) {
+					return
+				}
+				msg, err := codec.Decode(data)
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, value, msg.GetValue())
+			}
+		}(strconv.Itoa(i))
+	}
+	wg.Wait()
+}
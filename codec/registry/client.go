@@ -0,0 +1,156 @@
+// Package registry provides a client for Confluent compatible schema registries and helpers for the
+// Confluent wire format, in which payloads are prefixed with a magic byte and the ID of their schema.
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const contentType = "application/vnd.schemaregistry.v1+json"
+
+// Schema types supported by Confluent compatible schema registries.
+const (
+	SchemaTypeAvro     = "AVRO"
+	SchemaTypeProtobuf = "PROTOBUF"
+	SchemaTypeJSON     = "JSON"
+)
+
+// Schema is a schema stored in the registry.
+type Schema struct {
+	// Schema is the definition of the schema.
+	Schema string `json:"schema"`
+	// SchemaType is the type of the schema, the registry assumes Avro if it's empty.
+	SchemaType string `json:"schemaType,omitempty"`
+}
+
+// ClientOption is a function which sets a Client configuration option.
+type ClientOption func(c *Client)
+
+// WithHTTPClient sets the HTTP client used to make requests to the registry. The default is http.DefaultClient.
+func WithHTTPClient(client *http.Client) ClientOption {
+	return func(c *Client) {
+		c.http = client
+	}
+}
+
+// WithBasicAuth sets the credentials used to authenticate with the registry.
+func WithBasicAuth(username, password string) ClientOption {
+	return func(c *Client) {
+		c.username = username
+		c.password = password
+	}
+}
+
+// Client is a client for a Confluent compatible schema registry. It caches the schemas it retrieves,
+// as schemas are immutable once registered.
+type Client struct {
+	url      string
+	http     *http.Client
+	username string
+	password string
+
+	mutex   sync.RWMutex
+	schemas map[int]Schema
+}
+
+// NewClient returns a new client for the schema registry at the given URL.
+func NewClient(registryURL string, opts ...ClientOption) *Client {
+	c := &Client{
+		url:     strings.TrimSuffix(registryURL, "/"),
+		http:    http.DefaultClient,
+		schemas: make(map[int]Schema),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Register registers the schema under the subject, returning its ID. If the schema is already registered
+// under the subject, the ID of the existing schema is returned.
+func (c *Client) Register(ctx context.Context, subject string, schema Schema) (int, error) {
+	var resp struct {
+		ID int `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/subjects/"+url.PathEscape(subject)+"/versions", schema, &resp); err != nil {
+		return 0, errors.Wrapf(err, "failed to register schema for subject %s", subject)
+	}
+
+	c.mutex.Lock()
+	c.schemas[resp.ID] = schema
+	c.mutex.Unlock()
+
+	return resp.ID, nil
+}
+
+// Schema returns the schema with the given ID.
+func (c *Client) Schema(ctx context.Context, id int) (Schema, error) {
+	c.mutex.RLock()
+	schema, ok := c.schemas[id]
+	c.mutex.RUnlock()
+	if ok {
+		return schema, nil
+	}
+
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/schemas/ids/%d", id), nil, &schema); err != nil {
+		return Schema{}, errors.Wrapf(err, "failed to get schema %d", id)
+	}
+
+	c.mutex.Lock()
+	c.schemas[id] = schema
+	c.mutex.Unlock()
+
+	return schema, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", contentType)
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var regErr struct {
+			ErrorCode int    `json:"error_code"`
+			Message   string `json:"message"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&regErr) == nil && regErr.Message != "" {
+			return errors.Errorf("registry error %d: %s", regErr.ErrorCode, regErr.Message)
+		}
+		return errors.Errorf("unexpected registry response status: %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
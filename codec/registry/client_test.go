@@ -0,0 +1,69 @@
+package registry_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate-tools/codec/registry"
+)
+
+func TestClient(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		user, pass, _ := r.BasicAuth()
+		require.Equal(t, "user", user)
+		require.Equal(t, "pass", pass)
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/subjects/topic-value/versions":
+			var schema registry.Schema
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&schema))
+			require.Equal(t, registry.SchemaTypeProtobuf, schema.SchemaType)
+			w.Write([]byte(`{"id":7}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/schemas/ids/8":
+			w.Write([]byte(`{"schema":"syntax = \"proto3\";","schemaType":"PROTOBUF"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error_code":40403,"message":"Schema not found"}`))
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	client := registry.NewClient(server.URL, registry.WithBasicAuth("user", "pass"))
+
+	id, err := client.Register(ctx, "topic-value", registry.Schema{Schema: "...", SchemaType: registry.SchemaTypeProtobuf})
+	require.NoError(t, err)
+	require.Equal(t, 7, id)
+
+	// Registered schemas are cached.
+	_, err = client.Schema(ctx, 7)
+	require.NoError(t, err)
+	require.Equal(t, 1, requests)
+
+	schema, err := client.Schema(ctx, 8)
+	require.NoError(t, err)
+	require.Equal(t, registry.SchemaTypeProtobuf, schema.SchemaType)
+
+	_, err = client.Schema(ctx, 9)
+	require.EqualError(t, err, "failed to get schema 9: registry error 40403: Schema not found")
+}
+
+func TestFrame(t *testing.T) {
+	framed := registry.Frame(258, []byte("payload"))
+	require.Equal(t, []byte{0, 0, 0, 1, 2}, framed[:5])
+
+	id, payload, err := registry.Unframe(framed)
+	require.NoError(t, err)
+	require.Equal(t, 258, id)
+	require.Equal(t, []byte("payload"), payload)
+
+	_, _, err = registry.Unframe([]byte("payload"))
+	require.Equal(t, registry.ErrInvalidFrame, err)
+}
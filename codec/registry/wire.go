@@ -0,0 +1,31 @@
+package registry
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	magicByte  = 0
+	headerSize = 5
+)
+
+// ErrInvalidFrame is returned when a payload is not in the Confluent wire format.
+var ErrInvalidFrame = errors.New("payload is not in the registry wire format")
+
+// Frame prefixes the payload with the magic byte and the schema ID, as defined by the Confluent wire format.
+func Frame(schemaID int, payload []byte) []byte {
+	framed := make([]byte, headerSize, headerSize+len(payload))
+	framed[0] = magicByte
+	binary.BigEndian.PutUint32(framed[1:headerSize], uint32(schemaID))
+	return append(framed, payload...)
+}
+
+// Unframe returns the schema ID and the payload of a message in the Confluent wire format.
+func Unframe(data []byte) (int, []byte, error) {
+	if len(data) < headerSize || data[0] != magicByte {
+		return 0, nil, ErrInvalidFrame
+	}
+	return int(binary.BigEndian.Uint32(data[1:headerSize])), data[headerSize:], nil
+}
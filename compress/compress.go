@@ -0,0 +1,111 @@
+// Package compress provides wrappers that compress the payloads of published messages and transparently
+// decompress them on consumption.
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// ContentEncodingHeader is the header recording the encoding of a compressed payload. The compression
+// sink sets it on compressed messages, so the sink it wraps must support headers, such as the envelope
+// sink of the message package.
+const ContentEncodingHeader = "content-encoding"
+
+// Encoding is an algorithm used to compress payloads.
+type Encoding string
+
+const (
+	// Gzip compresses payloads using gzip.
+	Gzip Encoding = "gzip"
+	// Snappy compresses payloads using the snappy block format.
+	Snappy Encoding = "snappy"
+	// Zstd compresses payloads using zstandard.
+	Zstd Encoding = "zstd"
+)
+
+// ErrUnknownEncoding is returned when a payload is to be compressed, or was compressed, using an unknown encoding.
+var ErrUnknownEncoding = errors.New("unknown content encoding")
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// initZstd lazily creates the zstd encoder and decoder, which are safe for concurrent use.
+func initZstd() error {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdErr = zstd.NewWriter(nil); zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdErr
+}
+
+// encode compresses the data using the encoding.
+func encode(encoding Encoding, data []byte) ([]byte, error) {
+	switch encoding {
+	case Gzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, errors.Wrap(err, "failed to compress payload")
+		}
+		if err := w.Close(); err != nil {
+			return nil, errors.Wrap(err, "failed to compress payload")
+		}
+		return buf.Bytes(), nil
+	case Snappy:
+		return snappy.Encode(nil, data), nil
+	case Zstd:
+		if err := initZstd(); err != nil {
+			return nil, errors.Wrap(err, "failed to create zstd encoder")
+		}
+		return zstdEncoder.EncodeAll(data, nil), nil
+	default:
+		return nil, errors.Wrapf(ErrUnknownEncoding, "encoding %q", encoding)
+	}
+}
+
+// decode decompresses the data using the encoding.
+func decode(encoding Encoding, data []byte) ([]byte, error) {
+	switch encoding {
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decompress payload")
+		}
+		defer r.Close()
+		decoded, err := io.ReadAll(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decompress payload")
+		}
+		return decoded, nil
+	case Snappy:
+		decoded, err := snappy.Decode(nil, data)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decompress payload")
+		}
+		return decoded, nil
+	case Zstd:
+		if err := initZstd(); err != nil {
+			return nil, errors.Wrap(err, "failed to create zstd decoder")
+		}
+		decoded, err := zstdDecoder.DecodeAll(data, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decompress payload")
+		}
+		return decoded, nil
+	default:
+		return nil, errors.Wrapf(ErrUnknownEncoding, "encoding %q", encoding)
+	}
+}
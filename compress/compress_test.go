@@ -0,0 +1,126 @@
+package compress_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/compress"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+func TestCompression(t *testing.T) {
+	for _, encoding := range []compress.Encoding{compress.Gzip, compress.Snappy, compress.Zstd} {
+		t.Run(string(encoding), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+
+			broker := mem.NewBroker()
+			sink := compress.NewAsyncMessageSink(
+				message.NewEnvelopeSink(broker.NewAsyncMessageSink("topic")),
+				compress.WithEncoding(encoding),
+				compress.WithThreshold(16),
+			)
+
+			acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+			go sink.PublishMessages(ctx, acks, messages)
+
+			large := bytes.Repeat([]byte("payload"), 100)
+			published := []substrate.Message{
+				message.NewMessage(large),
+				message.FromString("small"),
+				message.NewEnvelopedMessage(message.Envelope{ID: "id-3", Payload: large}),
+			}
+			for _, msg := range published {
+				messages <- msg
+				require.Equal(t, msg, <-acks)
+			}
+
+			var raw message.Envelope
+			require.NoError(t, json.Unmarshal(broker.Messages("topic")[0], &raw))
+			require.Equal(t, string(encoding), raw.Headers[compress.ContentEncodingHeader])
+			require.True(t, len(raw.Payload) < len(large))
+
+			source := compress.NewAsyncMessageSource(message.NewEnvelopeSource(broker.NewAsyncMessageSource("topic", "group")))
+			consumed, sourceAcks := make(chan substrate.Message), make(chan substrate.Message)
+			go source.ConsumeMessages(ctx, consumed, sourceAcks)
+
+			first := <-consumed
+			require.Equal(t, large, first.Data())
+			require.Empty(t, message.Headers(first))
+			sourceAcks <- first
+
+			second := (<-consumed).(*message.EnvelopedMessage)
+			require.Equal(t, "small", string(second.Data()))
+			require.Empty(t, second.Headers())
+			sourceAcks <- second
+
+			third := <-consumed
+			require.Equal(t, large, third.Data())
+			sourceAcks <- third
+		})
+	}
+}
+
+func TestCompressionRatioHistogram(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	histogramOpts := prometheus.HistogramOpts{
+		Name: "compress_test_ratio",
+		Help: "compress_test_ratio",
+	}
+	sink := compress.NewAsyncMessageSink(
+		mem.NewBroker().NewAsyncMessageSink("topic"),
+		compress.WithRatioHistogram(histogramOpts),
+	)
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	go sink.PublishMessages(ctx, acks, messages)
+
+	registered := prometheus.DefaultRegisterer.Register(prometheus.NewHistogramVec(histogramOpts, []string{"encoding"}))
+	histogram := registered.(prometheus.AlreadyRegisteredError).ExistingCollector.(*prometheus.HistogramVec)
+	sampleCount := func() uint64 {
+		var metric dto.Metric
+		require.NoError(t, histogram.WithLabelValues("gzip").(prometheus.Histogram).Write(&metric))
+		return metric.Histogram.GetSampleCount()
+	}
+	before := sampleCount()
+
+	messages <- message.NewMessage(bytes.Repeat([]byte("a"), 2048))
+	<-acks
+	messages <- message.FromString("small")
+	<-acks
+
+	require.Equal(t, before+1, sampleCount())
+}
+
+func TestUnknownEncoding(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	sink := message.NewEnvelopeSink(broker.NewAsyncMessageSink("topic"))
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	go sink.PublishMessages(ctx, acks, messages)
+
+	messages <- message.NewEnvelopedMessage(message.Envelope{
+		Headers: map[string]string{compress.ContentEncodingHeader: "lz4"},
+		Payload: []byte("data"),
+	})
+	<-acks
+
+	source := compress.NewAsyncMessageSource(message.NewEnvelopeSource(broker.NewAsyncMessageSource("topic", "group")))
+	err := source.ConsumeMessages(ctx, make(chan substrate.Message), make(chan substrate.Message))
+	require.Equal(t, compress.ErrUnknownEncoding, errors.Cause(err))
+}
@@ -0,0 +1,98 @@
+package compress
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+	"github.com/uw-labs/substrate-tools/internal/transform"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+const defaultThreshold = 1024
+
+// SinkOption is a function which sets a compression sink configuration option.
+type SinkOption func(s *compressSink)
+
+// WithEncoding sets the encoding used to compress payloads. The default encoding is Gzip.
+func WithEncoding(encoding Encoding) SinkOption {
+	return func(s *compressSink) {
+		s.encoding = encoding
+	}
+}
+
+// WithThreshold sets the size in bytes above which payloads are compressed. The default value is 1024.
+func WithThreshold(threshold int) SinkOption {
+	return func(s *compressSink) {
+		s.threshold = threshold
+	}
+}
+
+// WithRatioHistogram enables a histogram tracking the ratio between the compressed and the original size
+// of compressed payloads, labelled with encoding. It panics in case it can't register the metric.
+func WithRatioHistogram(histogramOpts prometheus.HistogramOpts) SinkOption {
+	return func(s *compressSink) {
+		s.ratio = metrics.Register(prometheus.NewHistogramVec(histogramOpts, []string{"encoding"})).(*prometheus.HistogramVec)
+	}
+}
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that compresses payloads larger than
+// the threshold before publishing them, setting the ContentEncodingHeader header. Payloads which don't get
+// smaller are published as they are. Messages are published as message.EnvelopedMessage, so that the envelope
+// sink preserves the metadata of enveloped messages.
+func NewAsyncMessageSink(sink substrate.AsyncMessageSink, opts ...SinkOption) substrate.AsyncMessageSink {
+	s := &compressSink{
+		sink:      sink,
+		encoding:  Gzip,
+		threshold: defaultThreshold,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type compressSink struct {
+	sink      substrate.AsyncMessageSink
+	encoding  Encoding
+	threshold int
+	ratio     *prometheus.HistogramVec
+}
+
+func (s *compressSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	return transform.PublishMessages(ctx, "compress", s.sink, acks, messages, func(_ context.Context, msg substrate.Message) (substrate.Message, error) {
+		return s.compress(msg)
+	})
+}
+
+// compress returns the message to publish in place of the provided one.
+func (s *compressSink) compress(msg substrate.Message) (substrate.Message, error) {
+	envelope, _ := message.EnvelopeOf(msg)
+	envelope.Headers = message.Headers(msg)
+
+	if len(envelope.Payload) > s.threshold {
+		data, err := encode(s.encoding, envelope.Payload)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < len(envelope.Payload) {
+			if s.ratio != nil {
+				s.ratio.WithLabelValues(string(s.encoding)).Observe(float64(len(data)) / float64(len(envelope.Payload)))
+			}
+			envelope.Payload = data
+			envelope.Headers[ContentEncodingHeader] = string(s.encoding)
+		}
+	}
+
+	return message.NewEnvelopedMessage(envelope), nil
+}
+
+func (s *compressSink) Close() error {
+	return s.sink.Close()
+}
+
+func (s *compressSink) Status() (*substrate.Status, error) {
+	return s.sink.Status()
+}
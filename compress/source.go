@@ -0,0 +1,133 @@
+package compress
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that decompresses the payloads
+// of messages with the ContentEncodingHeader header set. The header is removed from decompressed messages,
+// while messages without it are passed through unchanged. Consumption fails if a payload can't be decompressed.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+	return &compressSource{
+		source: source,
+	}
+}
+
+type compressSource struct {
+	source substrate.AsyncMessageSource
+}
+
+func (s *compressSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "compress"})
+	rg, ctx := rungroup.New(ctx)
+
+	consumed := make(chan substrate.Message, cap(messages))
+	toAck := make(chan substrate.Message, cap(acks))
+
+	rg.Go(func() error {
+		return s.source.ConsumeMessages(ctx, consumed, toAck)
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-consumed:
+				dMsg, err := decompress(msg)
+				if err != nil {
+					return err
+				}
+				emit.Publish(dMsg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case messages <- dMsg:
+				}
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				emit.Ack(ack)
+				if dMsg, ok := ack.(*decompressedMessage); ok {
+					ack = dMsg.msg
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case toAck <- ack:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+// decompress returns the message to deliver in place of the consumed one.
+func decompress(msg substrate.Message) (substrate.Message, error) {
+	headers := message.Headers(msg)
+	encoding, ok := headers[ContentEncodingHeader]
+	if !ok {
+		return msg, nil
+	}
+	data, err := decode(Encoding(encoding), msg.Data())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decompress message")
+	}
+	delete(headers, ContentEncodingHeader)
+
+	return &decompressedMessage{
+		data:    data,
+		headers: headers,
+		msg:     msg,
+	}, nil
+}
+
+func (s *compressSource) Close() error {
+	return s.source.Close()
+}
+
+func (s *compressSource) Status() (*substrate.Status, error) {
+	return s.source.Status()
+}
+
+// decompressedMessage is a message with a decompressed payload, delivered on behalf of the consumed message.
+type decompressedMessage struct {
+	data    []byte
+	headers map[string]string
+	msg     substrate.Message
+}
+
+// Data returns the decompressed payload.
+func (msg *decompressedMessage) Data() []byte {
+	return msg.data
+}
+
+// Headers returns the headers of the consumed message, without the content encoding.
+func (msg *decompressedMessage) Headers() map[string]string {
+	return msg.headers
+}
+
+// DiscardPayload discards the payload.
+func (msg *decompressedMessage) DiscardPayload() {
+	msg.data = nil
+	if dMsg, ok := msg.msg.(substrate.DiscardableMessage); ok {
+		dMsg.DiscardPayload()
+	}
+}
+
+func (msg *decompressedMessage) CarriedEnvelope() (message.Envelope, bool) {
+	return message.EnvelopeOf(msg.msg)
+}
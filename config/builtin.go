@@ -0,0 +1,398 @@
+package config
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uw-labs/substrate"
+
+	"github.com/uw-labs/substrate-tools/ackordering"
+	"github.com/uw-labs/substrate-tools/acktimeout"
+	"github.com/uw-labs/substrate-tools/backpressure"
+	"github.com/uw-labs/substrate-tools/chunker"
+	"github.com/uw-labs/substrate-tools/compress"
+	"github.com/uw-labs/substrate-tools/drain"
+	"github.com/uw-labs/substrate-tools/instrumented"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/middleware"
+	"github.com/uw-labs/substrate-tools/redact"
+	"github.com/uw-labs/substrate-tools/sample"
+	"github.com/uw-labs/substrate-tools/sizelimit"
+	"github.com/uw-labs/substrate-tools/traced"
+	"github.com/uw-labs/substrate-tools/ttl"
+)
+
+// The built-in wrapper types are named after the packages of the wrappers.
+func init() {
+	RegisterSink("acktimeout", ackTimeoutSink)
+	RegisterSink("chunker", chunkerSink)
+	RegisterSink("compress", compressSink)
+	RegisterSink("envelope", envelopeSink)
+	RegisterSink("instrumented", instrumentedSink)
+	RegisterSink("redact", redactSink)
+	RegisterSink("sizelimit", sizeLimitSink)
+	RegisterSink("traced", tracedSink)
+
+	RegisterSource("ackordering", ackOrderingSource)
+	RegisterSource("backpressure", backpressureSource)
+	RegisterSource("chunker", chunkerSource)
+	RegisterSource("compress", compressSource)
+	RegisterSource("drain", drainSource)
+	RegisterSource("envelope", envelopeSource)
+	RegisterSource("instrumented", instrumentedSource)
+	RegisterSource("redact", redactSource)
+	RegisterSource("sample", sampleSource)
+	RegisterSource("traced", tracedSource)
+	RegisterSource("ttl", ttlSource)
+}
+
+func ackTimeoutSink(opts Options) (middleware.SinkMiddleware, error) {
+	var o struct {
+		Timeout Duration `json:"timeout"`
+		Action  string   `json:"action"`
+	}
+	if err := opts.Decode(&o); err != nil {
+		return nil, err
+	}
+	if o.Timeout <= 0 {
+		return nil, errors.New("timeout is required")
+	}
+	action, err := oneOf("action", defaultString(o.Action, "fail"), map[string]acktimeout.Action{
+		"fail":    acktimeout.Fail,
+		"restart": acktimeout.Restart,
+		"report":  acktimeout.Report,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func(sink substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+		return acktimeout.NewAsyncMessageSink(sink, time.Duration(o.Timeout), acktimeout.WithAction(action))
+	}, nil
+}
+
+func chunkerSink(opts Options) (middleware.SinkMiddleware, error) {
+	var o struct {
+		Threshold int `json:"threshold"`
+	}
+	if err := opts.Decode(&o); err != nil {
+		return nil, err
+	}
+	if o.Threshold <= 0 {
+		return nil, errors.New("threshold is required")
+	}
+	return func(sink substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+		return chunker.NewAsyncMessageSink(sink, o.Threshold)
+	}, nil
+}
+
+func compressSink(opts Options) (middleware.SinkMiddleware, error) {
+	var o struct {
+		Encoding  string `json:"encoding"`
+		Threshold *int   `json:"threshold"`
+	}
+	if err := opts.Decode(&o); err != nil {
+		return nil, err
+	}
+	encoding, err := oneOf("encoding", defaultString(o.Encoding, string(compress.Gzip)), map[string]compress.Encoding{
+		string(compress.Gzip):   compress.Gzip,
+		string(compress.Snappy): compress.Snappy,
+		string(compress.Zstd):   compress.Zstd,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sinkOpts := []compress.SinkOption{compress.WithEncoding(encoding)}
+	if o.Threshold != nil {
+		sinkOpts = append(sinkOpts, compress.WithThreshold(*o.Threshold))
+	}
+	return func(sink substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+		return compress.NewAsyncMessageSink(sink, sinkOpts...)
+	}, nil
+}
+
+func envelopeSink(opts Options) (middleware.SinkMiddleware, error) {
+	var o struct {
+		ContentType string `json:"content_type"`
+	}
+	if err := opts.Decode(&o); err != nil {
+		return nil, err
+	}
+	var sinkOpts []message.EnvelopeSinkOption
+	if o.ContentType != "" {
+		sinkOpts = append(sinkOpts, message.WithContentType(o.ContentType))
+	}
+	return func(sink substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+		return message.NewEnvelopeSink(sink, sinkOpts...)
+	}, nil
+}
+
+func instrumentedSink(opts Options) (middleware.SinkMiddleware, error) {
+	var o struct {
+		Topic string `json:"topic"`
+	}
+	if err := opts.Decode(&o); err != nil {
+		return nil, err
+	}
+	if o.Topic == "" {
+		return nil, errors.New("topic is required")
+	}
+	counterOpts := prometheus.CounterOpts{
+		Namespace: opts.Namespace,
+		Name:      "messages_published_total",
+		Help:      "Total count of messages published",
+	}
+	return func(sink substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+		return instrumented.NewAsyncMessageSink(sink, counterOpts, o.Topic)
+	}, nil
+}
+
+func redactSink(opts Options) (middleware.SinkMiddleware, error) {
+	rules, err := redactRules(opts)
+	if err != nil {
+		return nil, err
+	}
+	return func(sink substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+		return redact.NewAsyncMessageSink(sink, rules...)
+	}, nil
+}
+
+func sizeLimitSink(opts Options) (middleware.SinkMiddleware, error) {
+	var o struct {
+		Limit  int    `json:"limit"`
+		Policy string `json:"policy"`
+	}
+	if err := opts.Decode(&o); err != nil {
+		return nil, err
+	}
+	if o.Limit <= 0 {
+		return nil, errors.New("limit is required")
+	}
+	policy, err := oneOf("policy", defaultString(o.Policy, "reject"), map[string]sizelimit.Policy{
+		"reject": sizelimit.Reject,
+		"split":  sizelimit.Split,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func(sink substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+		return sizelimit.NewAsyncMessageSink(sink, o.Limit, sizelimit.WithPolicy(policy))
+	}, nil
+}
+
+func tracedSink(opts Options) (middleware.SinkMiddleware, error) {
+	var o struct {
+		Topic string `json:"topic"`
+	}
+	if err := opts.Decode(&o); err != nil {
+		return nil, err
+	}
+	if o.Topic == "" {
+		return nil, errors.New("topic is required")
+	}
+	return func(sink substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+		return traced.NewAsyncMessageSink(sink, o.Topic)
+	}, nil
+}
+
+func ackOrderingSource(opts Options) (middleware.SourceMiddleware, error) {
+	var o struct {
+		WindowSize uint `json:"window_size"`
+	}
+	if err := opts.Decode(&o); err != nil {
+		return nil, err
+	}
+	return func(source substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		return ackordering.NewAsyncMessageSource(source, ackordering.WithWindowSize(o.WindowSize))
+	}, nil
+}
+
+func backpressureSource(opts Options) (middleware.SourceMiddleware, error) {
+	var o struct {
+		Budget int `json:"budget"`
+	}
+	if err := opts.Decode(&o); err != nil {
+		return nil, err
+	}
+	if o.Budget <= 0 {
+		return nil, errors.New("budget is required")
+	}
+	return func(source substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		return backpressure.NewAsyncMessageSource(source, o.Budget)
+	}, nil
+}
+
+func chunkerSource(opts Options) (middleware.SourceMiddleware, error) {
+	var o struct {
+		Timeout    Duration `json:"timeout"`
+		Incomplete string   `json:"incomplete"`
+	}
+	if err := opts.Decode(&o); err != nil {
+		return nil, err
+	}
+	policy, err := oneOf("incomplete policy", defaultString(o.Incomplete, "fail"), map[string]chunker.IncompletePolicy{
+		"fail":    chunker.Fail,
+		"drop":    chunker.Drop,
+		"deliver": chunker.Deliver,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sourceOpts := []chunker.SourceOption{chunker.WithIncompletePolicy(policy)}
+	if o.Timeout > 0 {
+		sourceOpts = append(sourceOpts, chunker.WithTimeout(time.Duration(o.Timeout)))
+	}
+	return func(source substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		return chunker.NewAsyncMessageSource(source, sourceOpts...)
+	}, nil
+}
+
+func compressSource(opts Options) (middleware.SourceMiddleware, error) {
+	if err := opts.Decode(&struct{}{}); err != nil {
+		return nil, err
+	}
+	return compress.NewAsyncMessageSource, nil
+}
+
+func drainSource(opts Options) (middleware.SourceMiddleware, error) {
+	var o struct {
+		Timeout Duration `json:"timeout"`
+	}
+	if err := opts.Decode(&o); err != nil {
+		return nil, err
+	}
+	var drainOpts []drain.Option
+	if o.Timeout > 0 {
+		drainOpts = append(drainOpts, drain.WithTimeout(time.Duration(o.Timeout)))
+	}
+	return func(source substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		return drain.NewSource(source, drainOpts...)
+	}, nil
+}
+
+func envelopeSource(opts Options) (middleware.SourceMiddleware, error) {
+	if err := opts.Decode(&struct{}{}); err != nil {
+		return nil, err
+	}
+	return message.NewEnvelopeSource, nil
+}
+
+func instrumentedSource(opts Options) (middleware.SourceMiddleware, error) {
+	var o struct {
+		Topic    string `json:"topic"`
+		Consumer string `json:"consumer"`
+	}
+	if err := opts.Decode(&o); err != nil {
+		return nil, err
+	}
+	if o.Topic == "" || o.Consumer == "" {
+		return nil, errors.New("topic and consumer are required")
+	}
+	counterOpts := prometheus.CounterOpts{
+		Namespace: opts.Namespace,
+		Name:      "messages_consumed_total",
+		Help:      "Total count of messages consumed",
+	}
+	return func(source substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		return instrumented.NewAsyncMessageSource(source, counterOpts, o.Topic, o.Consumer)
+	}, nil
+}
+
+func redactSource(opts Options) (middleware.SourceMiddleware, error) {
+	rules, err := redactRules(opts)
+	if err != nil {
+		return nil, err
+	}
+	return func(source substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		return redact.NewAsyncMessageSource(source, rules...)
+	}, nil
+}
+
+func sampleSource(opts Options) (middleware.SourceMiddleware, error) {
+	var o struct {
+		Fraction float64 `json:"fraction"`
+		Every    int     `json:"every"`
+	}
+	if err := opts.Decode(&o); err != nil {
+		return nil, err
+	}
+	var sampler sample.Sampler
+	switch {
+	case o.Fraction != 0 && o.Every != 0:
+		return nil, errors.New("only one of fraction and every can be set")
+	case o.Fraction > 0 && o.Fraction <= 1:
+		sampler = sample.Random(o.Fraction)
+	case o.Every > 0:
+		sampler = sample.EveryNth(o.Every)
+	default:
+		return nil, errors.New("either a fraction between 0 and 1 or every must be set")
+	}
+	return func(source substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		return sample.NewAsyncMessageSource(source, sampler)
+	}, nil
+}
+
+func tracedSource(opts Options) (middleware.SourceMiddleware, error) {
+	var o struct {
+		Topic    string `json:"topic"`
+		Consumer string `json:"consumer"`
+	}
+	if err := opts.Decode(&o); err != nil {
+		return nil, err
+	}
+	if o.Topic == "" || o.Consumer == "" {
+		return nil, errors.New("topic and consumer are required")
+	}
+	return func(source substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		return traced.NewAsyncMessageSource(source, o.Topic, o.Consumer)
+	}, nil
+}
+
+func ttlSource(opts Options) (middleware.SourceMiddleware, error) {
+	var o struct {
+		TTL Duration `json:"ttl"`
+	}
+	if err := opts.Decode(&o); err != nil {
+		return nil, err
+	}
+	if o.TTL <= 0 {
+		return nil, errors.New("ttl is required")
+	}
+	return func(source substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		return ttl.NewAsyncMessageSource(source, time.Duration(o.TTL))
+	}, nil
+}
+
+// redactRules returns the rules of the redact wrappers: JSON fields and regular expressions.
+func redactRules(opts Options) ([]redact.Rule, error) {
+	var o struct {
+		Fields   []string `json:"fields"`
+		Patterns []string `json:"patterns"`
+	}
+	if err := opts.Decode(&o); err != nil {
+		return nil, err
+	}
+	if len(o.Fields) == 0 && len(o.Patterns) == 0 {
+		return nil, errors.New("at least one field or pattern is required")
+	}
+	var rules []redact.Rule
+	if len(o.Fields) > 0 {
+		rules = append(rules, redact.JSONFields(o.Fields...))
+	}
+	for _, pattern := range o.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid pattern %q", pattern)
+		}
+		rules = append(rules, redact.Pattern(re))
+	}
+	return rules, nil
+}
+
+func defaultString(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
@@ -0,0 +1,166 @@
+// Package config builds message sinks and sources, wrapped by a stack of wrappers, from a declarative
+// configuration, so that the composition of wrappers can be changed without recompiling. Configurations are
+// loaded from YAML or JSON documents, or from environment variables, and strictly validated: unknown wrapper
+// types and options, as well as invalid option values, are all reported at once.
+//
+// Backends are created using suburl, the packages of the required backends must therefore be imported.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes a message sink or source.
+type Config struct {
+	// URL is the substrate URL of the backend, e.g. kafka://localhost:9092/topic.
+	URL string `json:"url"`
+	// Namespace is the namespace of the metrics exposed by the wrappers.
+	Namespace string `json:"namespace,omitempty"`
+	// Wrappers is the stack of wrappers, the first one being the outermost one, see middleware.Chain.
+	Wrappers []Wrapper `json:"wrappers,omitempty"`
+}
+
+// Wrapper describes a wrapper of the stack. In documents, wrappers without options can be given by their type.
+type Wrapper struct {
+	// Type is the type of the wrapper, as registered with RegisterSink or RegisterSource.
+	Type string `json:"type"`
+	// Options are the options of the wrapper, specific to its type.
+	Options map[string]interface{} `json:"options,omitempty"`
+}
+
+// UnmarshalJSON decodes a wrapper given either as an object or as its type.
+func (w *Wrapper) UnmarshalJSON(data []byte) error {
+	var typ string
+	if err := json.Unmarshal(data, &typ); err == nil {
+		*w = Wrapper{Type: typ}
+		return nil
+	}
+	type wrapper Wrapper
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode((*wrapper)(w))
+}
+
+// Duration is a duration given as a string, such as "1m30s", in documents.
+type Duration time.Duration
+
+// UnmarshalJSON parses the duration.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.Errorf("invalid duration %s, expected a string such as \"10s\"", data)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return errors.Errorf("invalid duration %q, expected a string such as \"10s\"", s)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Parse parses a configuration from a YAML or JSON document. Unknown fields are rejected.
+func Parse(data []byte) (*Config, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, errors.Wrap(err, "failed to parse configuration")
+	}
+	cfg := &Config{}
+	if err := decodeStrict(doc, cfg); err != nil {
+		return nil, errors.Wrap(err, "failed to parse configuration")
+	}
+	return cfg, nil
+}
+
+// envVariable matches references to environment variables in documents.
+var envVariable = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Load parses the configuration in the file. References to environment variables of the form ${NAME} are
+// replaced with their values before parsing, e.g. to keep credentials out of the file.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read configuration")
+	}
+	var missing []string
+	data = envVariable.ReplaceAllFunc(data, func(ref []byte) []byte {
+		name := string(envVariable.FindSubmatch(ref)[1])
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return []byte(value)
+	})
+	if len(missing) > 0 {
+		return nil, errors.Errorf("failed to load configuration: environment variables not set: %s", strings.Join(missing, ", "))
+	}
+	return Parse(data)
+}
+
+// FromEnv reads a configuration from the environment variables with the prefix: <prefix>_URL,
+// <prefix>_NAMESPACE and <prefix>_WRAPPERS, which holds the list of wrappers as a YAML or JSON document,
+// e.g. [envelope, {type: compress, options: {encoding: zstd}}].
+func FromEnv(prefix string) (*Config, error) {
+	cfg := &Config{
+		URL:       os.Getenv(prefix + "_URL"),
+		Namespace: os.Getenv(prefix + "_NAMESPACE"),
+	}
+	if cfg.URL == "" {
+		return nil, errors.Errorf("failed to read configuration: %s_URL is not set", prefix)
+	}
+	if wrappers := os.Getenv(prefix + "_WRAPPERS"); wrappers != "" {
+		var doc interface{}
+		if err := yaml.Unmarshal([]byte(wrappers), &doc); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s_WRAPPERS", prefix)
+		}
+		if err := decodeStrict(doc, &cfg.Wrappers); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s_WRAPPERS", prefix)
+		}
+	}
+	return cfg, nil
+}
+
+// decodeStrict decodes a generic document into v through its JSON encoding, rejecting unknown fields.
+func decodeStrict(doc, v interface{}) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return describe(err)
+	}
+	return nil
+}
+
+// describe rephrases decoding errors in terms of the configuration.
+func describe(err error) error {
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+		if typeErr.Field == "" {
+			return errors.Errorf("expected %s, got %s", typeErr.Type, typeErr.Value)
+		}
+		return errors.Errorf("%q must be of type %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value)
+	}
+	if msg := err.Error(); strings.HasPrefix(msg, "json: unknown field ") {
+		return errors.Errorf("unknown field %s", strings.TrimPrefix(msg, "json: unknown field "))
+	}
+	return err
+}
+
+// ValidationError lists all the problems found in a configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("invalid configuration: %s", strings.Join(e.Problems, "; "))
+}
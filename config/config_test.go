@@ -0,0 +1,180 @@
+package config_test
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate/suburl"
+
+	"github.com/uw-labs/substrate-tools/config"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+var broker = mem.NewBroker()
+
+func init() {
+	suburl.RegisterSink("configtest", func(u *url.URL) (substrate.AsyncMessageSink, error) {
+		return broker.NewAsyncMessageSink(u.Host), nil
+	})
+	suburl.RegisterSource("configtest", func(u *url.URL) (substrate.AsyncMessageSource, error) {
+		return broker.NewAsyncMessageSource(u.Host, "group"), nil
+	})
+}
+
+func TestParse(t *testing.T) {
+	cfg, err := config.Parse([]byte(`
+url: kafka://localhost:9092/events
+namespace: app
+wrappers:
+  - envelope
+  - type: compress
+    options:
+      encoding: zstd
+      threshold: 0
+`))
+	require.NoError(t, err)
+	require.Equal(t, &config.Config{
+		URL:       "kafka://localhost:9092/events",
+		Namespace: "app",
+		Wrappers: []config.Wrapper{
+			{Type: "envelope"},
+			{Type: "compress", Options: map[string]interface{}{"encoding": "zstd", "threshold": float64(0)}},
+		},
+	}, cfg)
+
+	// JSON documents are valid YAML documents.
+	cfg, err = config.Parse([]byte(`{"url": "kafka://localhost:9092/events", "wrappers": ["envelope"]}`))
+	require.NoError(t, err)
+	require.Equal(t, []config.Wrapper{{Type: "envelope"}}, cfg.Wrappers)
+
+	_, err = config.Parse([]byte("url: kafka://localhost:9092/events\nwrapers: [envelope]"))
+	require.EqualError(t, err, `failed to parse configuration: unknown field "wrapers"`)
+	_, err = config.Parse([]byte("url: kafka://localhost:9092/events\nwrappers: [{type: envelope, option: {}}]"))
+	require.EqualError(t, err, `failed to parse configuration: unknown field "option"`)
+}
+
+func TestValidation(t *testing.T) {
+	cfg, err := config.Parse([]byte(`
+wrappers:
+  - compres
+  - type: compress
+    options: {encoding: lz4}
+  - type: acktimeout
+    options: {timeout: 10}
+  - type: sizelimit
+    options: {limit: 1024, polcy: split}
+  - type: chunker
+    options: {threshold: "1k"}
+`))
+	require.NoError(t, err)
+
+	_, err = cfg.SinkMiddleware()
+	require.Equal(t, config.ValidationError{Problems: []string{
+		"url is required",
+		`wrappers[0]: unknown sink wrapper type "compres", known types are acktimeout, chunker, compress, envelope, instrumented, redact, sizelimit, traced`,
+		`wrappers[1] (compress): invalid encoding "lz4", expected one of gzip, snappy, zstd`,
+		`wrappers[2] (acktimeout): invalid duration 10, expected a string such as "10s"`,
+		`wrappers[3] (sizelimit): unknown field "polcy"`,
+		`wrappers[4] (chunker): "threshold" must be of type int, got string`,
+	}}, err)
+
+	// The wrapper types of sinks and sources are distinct.
+	cfg = &config.Config{URL: "kafka://localhost:9092/events", Wrappers: []config.Wrapper{{Type: "ttl"}}}
+	_, err = cfg.SinkMiddleware()
+	require.ErrorContains(t, err, `unknown sink wrapper type "ttl"`)
+	_, err = cfg.SourceMiddleware()
+	require.EqualError(t, err, "invalid configuration: wrappers[0] (ttl): ttl is required")
+}
+
+func TestNewSinkAndSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	sinkCfg, err := config.Parse([]byte(`
+url: configtest://roundtrip
+wrappers:
+  - type: redact
+    options: {fields: [password]}
+  - envelope
+  - type: compress
+    options: {threshold: 0}
+`))
+	require.NoError(t, err)
+	sink, err := config.NewSink(sinkCfg)
+	require.NoError(t, err)
+
+	acks, messages := make(chan substrate.Message, 1), make(chan substrate.Message, 1)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+	messages <- message.FromString(`{"password":"secret","user":"joe"}`)
+	select {
+	case <-acks:
+	case err := <-errs:
+		require.FailNow(t, "publishing failed", "%v", err)
+	}
+
+	srcCfg := &config.Config{URL: "configtest://roundtrip", Wrappers: []config.Wrapper{{Type: "envelope"}, {Type: "compress"}}}
+	source, err := config.NewSource(srcCfg)
+	require.NoError(t, err)
+	consumed, sourceAcks := make(chan substrate.Message, 1), make(chan substrate.Message, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, consumed, sourceAcks)
+	}()
+	select {
+	case msg := <-consumed:
+		require.JSONEq(t, `{"password":"[REDACTED]","user":"joe"}`, string(msg.Data()))
+	case err := <-errs:
+		require.FailNow(t, "consuming failed", "%v", err)
+	}
+
+	// Invalid configurations are rejected before connecting to the backend.
+	_, err = config.NewSink(&config.Config{URL: "unknown://host", Wrappers: []config.Wrapper{{Type: "unknown"}}})
+	require.IsType(t, config.ValidationError{}, err)
+	_, err = config.NewSink(&config.Config{URL: "unknown://host"})
+	require.ErrorContains(t, err, "failed to create sink")
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("url: kafka://${CONFIG_TEST_BROKER}/events\nwrappers: [{type: redact, options: {patterns: ['\\d+$']}}]"), 0o600))
+
+	_, err := config.Load(path)
+	require.EqualError(t, err, "failed to load configuration: environment variables not set: CONFIG_TEST_BROKER")
+
+	t.Setenv("CONFIG_TEST_BROKER", "localhost:9092")
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+	require.Equal(t, "kafka://localhost:9092/events", cfg.URL)
+	// Only references of the form ${NAME} are replaced.
+	require.Equal(t, []interface{}{`\d+$`}, cfg.Wrappers[0].Options["patterns"])
+}
+
+func TestFromEnv(t *testing.T) {
+	_, err := config.FromEnv("CONFIG_TEST")
+	require.EqualError(t, err, "failed to read configuration: CONFIG_TEST_URL is not set")
+
+	t.Setenv("CONFIG_TEST_URL", "kafka://localhost:9092/events")
+	t.Setenv("CONFIG_TEST_NAMESPACE", "app")
+	t.Setenv("CONFIG_TEST_WRAPPERS", "[envelope, {type: ttl, options: {ttl: 1h}}]")
+	cfg, err := config.FromEnv("CONFIG_TEST")
+	require.NoError(t, err)
+	require.Equal(t, &config.Config{
+		URL:       "kafka://localhost:9092/events",
+		Namespace: "app",
+		Wrappers: []config.Wrapper{
+			{Type: "envelope"},
+			{Type: "ttl", Options: map[string]interface{}{"ttl": "1h"}},
+		},
+	}, cfg)
+	_, err = cfg.SourceMiddleware()
+	require.NoError(t, err)
+}
@@ -0,0 +1,203 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate/suburl"
+
+	"github.com/uw-labs/substrate-tools/middleware"
+)
+
+// Options are the options of a wrapper of the configuration.
+type Options struct {
+	// Namespace is the namespace of the metrics of the configuration.
+	Namespace string
+
+	values map[string]interface{}
+}
+
+// Decode decodes the options into v, a pointer to a struct with JSON tags, rejecting unknown options.
+func (o Options) Decode(v interface{}) error {
+	values := o.values
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	return decodeStrict(values, v)
+}
+
+// SinkFactory returns the sink middleware of a wrapper type configured with the options. It should fully
+// validate the options, so that invalid configurations are rejected before any backend is connected to.
+type SinkFactory func(opts Options) (middleware.SinkMiddleware, error)
+
+// SourceFactory returns the source middleware of a wrapper type configured with the options. It should fully
+// validate the options, so that invalid configurations are rejected before any backend is connected to.
+type SourceFactory func(opts Options) (middleware.SourceMiddleware, error)
+
+var (
+	mutex     sync.RWMutex
+	sinkTypes = map[string]SinkFactory{}
+	srcTypes  = map[string]SourceFactory{}
+)
+
+// RegisterSink registers a sink wrapper type, replacing any type registered with the same name.
+func RegisterSink(typ string, factory SinkFactory) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	sinkTypes[typ] = factory
+}
+
+// RegisterSource registers a source wrapper type, replacing any type registered with the same name.
+func RegisterSource(typ string, factory SourceFactory) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	srcTypes[typ] = factory
+}
+
+// SinkTypes returns the names of the registered sink wrapper types, sorted.
+func SinkTypes() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return keys(sinkTypes)
+}
+
+// SourceTypes returns the names of the registered source wrapper types, sorted.
+func SourceTypes() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return keys(srcTypes)
+}
+
+// SinkMiddleware validates the configuration and returns the middleware applying its stack of wrappers, each
+// labelling the errors it returns with its type, see middleware.NamedSink. All the problems found are returned
+// as a ValidationError.
+func (c *Config) SinkMiddleware() (middleware.SinkMiddleware, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	var (
+		problems    = c.validateURL()
+		middlewares []middleware.SinkMiddleware
+	)
+	for i, w := range c.Wrappers {
+		factory, ok := sinkTypes[w.Type]
+		if !ok {
+			problems = append(problems, unknownType(i, w.Type, "sink", keys(sinkTypes)))
+			continue
+		}
+		mw, err := factory(Options{Namespace: c.Namespace, values: w.Options})
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("wrappers[%d] (%s): %s", i, w.Type, err))
+			continue
+		}
+		middlewares = append(middlewares, middleware.NamedSink(w.Type, mw))
+	}
+	if len(problems) > 0 {
+		return nil, ValidationError{Problems: problems}
+	}
+	return middleware.Chain(middlewares...), nil
+}
+
+// SourceMiddleware validates the configuration and returns the middleware applying its stack of wrappers, each
+// labelling the errors it returns with its type, see middleware.NamedSource. All the problems found are returned
+// as a ValidationError.
+func (c *Config) SourceMiddleware() (middleware.SourceMiddleware, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	var (
+		problems    = c.validateURL()
+		middlewares []middleware.SourceMiddleware
+	)
+	for i, w := range c.Wrappers {
+		factory, ok := srcTypes[w.Type]
+		if !ok {
+			problems = append(problems, unknownType(i, w.Type, "source", keys(srcTypes)))
+			continue
+		}
+		mw, err := factory(Options{Namespace: c.Namespace, values: w.Options})
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("wrappers[%d] (%s): %s", i, w.Type, err))
+			continue
+		}
+		middlewares = append(middlewares, middleware.NamedSource(w.Type, mw))
+	}
+	if len(problems) > 0 {
+		return nil, ValidationError{Problems: problems}
+	}
+	return middleware.Chain(middlewares...), nil
+}
+
+// NewSink validates the configuration, then connects to the backend and wraps the sink with the wrappers.
+func NewSink(cfg *Config) (substrate.AsyncMessageSink, error) {
+	mw, err := cfg.SinkMiddleware()
+	if err != nil {
+		return nil, err
+	}
+	sink, err := suburl.NewSink(cfg.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create sink")
+	}
+	return mw(sink), nil
+}
+
+// NewSource validates the configuration, then connects to the backend and wraps the source with the wrappers.
+func NewSource(cfg *Config) (substrate.AsyncMessageSource, error) {
+	mw, err := cfg.SourceMiddleware()
+	if err != nil {
+		return nil, err
+	}
+	source, err := suburl.NewSource(cfg.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create source")
+	}
+	return mw(source), nil
+}
+
+func (c *Config) validateURL() []string {
+	if c.URL == "" {
+		return []string{"url is required"}
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return []string{fmt.Sprintf("invalid url: %s", err)}
+	}
+	if u.Scheme == "" {
+		return []string{fmt.Sprintf("invalid url %q: missing scheme", c.URL)}
+	}
+	return nil
+}
+
+func unknownType(i int, typ, kind string, known []string) string {
+	if typ == "" {
+		return fmt.Sprintf("wrappers[%d]: type is required", i)
+	}
+	return fmt.Sprintf("wrappers[%d]: unknown %s wrapper type %q, known types are %s", i, kind, typ, strings.Join(known, ", "))
+}
+
+func keys[F any](types map[string]F) []string {
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// oneOf decodes an enumerated option value, listing the allowed values in the error.
+func oneOf[T any](name, value string, values map[string]T) (T, error) {
+	v, ok := values[value]
+	if !ok {
+		return v, errors.Errorf("invalid %s %q, expected one of %s", name, value, strings.Join(keys(values), ", "))
+	}
+	return v, nil
+}
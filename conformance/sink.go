@@ -0,0 +1,147 @@
+// Package conformance provides reusable test suites verifying that message sink and source wrappers follow the
+// acknowledgement discipline of substrate, so that wrappers outside of this repo can validate themselves too.
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+// timeout is how long the suites wait for a wrapper to do what is expected, before considering it deadlocked.
+const timeout = time.Second * 5
+
+// messageCount is the number of messages published or consumed by the suites.
+const messageCount = 10
+
+// SinkFactory returns the wrapper under test wrapping the backend sink.
+type SinkFactory func(t *testing.T, backend substrate.AsyncMessageSink) substrate.AsyncMessageSink
+
+// TestAsyncSink runs the sink suite against the wrappers returned by the factory. It verifies that the wrapper:
+//   - acknowledges every message published to it, in order, once the backend acknowledged it,
+//   - returns from PublishMessages once the context is cancelled, even while the backend withholds acknowledgements,
+//   - returns from PublishMessages after the backend failed once the context is cancelled, if not before,
+//   - closes the backend when it is closed.
+func TestAsyncSink(t *testing.T, factory SinkFactory) {
+	t.Run("ForwardsAcks", func(t *testing.T) {
+		backend := mock.NewSink()
+		sink := factory(t, backend)
+		defer sink.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		messages, acks, errs := publish(ctx, sink)
+
+		published := make([]substrate.Message, messageCount)
+		for i := range published {
+			published[i] = message.FromString(fmt.Sprintf("message-%d", i))
+		}
+		go func() {
+			for _, msg := range published {
+				select {
+				case <-ctx.Done():
+					return
+				case messages <- msg:
+				}
+			}
+		}()
+		for i, msg := range published {
+			select {
+			case ack := <-acks:
+				require.True(t, ack == msg, "message %d acknowledged out of order", i)
+			case err := <-errs:
+				require.FailNow(t, "publishing failed", "%v", err)
+			case <-ctx.Done():
+				require.FailNow(t, "messages weren't acknowledged", "%d of %d acknowledged", i, messageCount)
+			}
+		}
+
+		cancel()
+		requireReturned(t, errs)
+	})
+
+	t.Run("ContextCancellation", func(t *testing.T) {
+		backend := mock.NewSink().AckWith(func(int, substrate.Message) bool {
+			return false
+		})
+		sink := factory(t, backend)
+		defer sink.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		messages, _, errs := publish(ctx, sink)
+
+		select {
+		case messages <- message.FromString("message"):
+		case <-time.After(timeout):
+			require.FailNow(t, "message wasn't received")
+		}
+		cancel()
+		require.NoError(t, requireReturned(t, errs))
+	})
+
+	t.Run("BackendFailure", func(t *testing.T) {
+		backend := mock.NewSink().FailAt(1, errors.New("backend failed"))
+		sink := factory(t, backend)
+		defer sink.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		messages, _, errs := publish(ctx, sink)
+
+		select {
+		case messages <- message.FromString("message"):
+		case err := <-errs:
+			require.Error(t, err)
+			return
+		case <-time.After(timeout):
+			require.FailNow(t, "message wasn't received")
+		}
+		select {
+		case <-errs:
+			return
+		case <-time.After(time.Millisecond * 100):
+		}
+		// The wrapper may retry publishing to the backend, but it must still stop once cancelled.
+		cancel()
+		requireReturned(t, errs)
+	})
+
+	t.Run("ClosesBackend", func(t *testing.T) {
+		backend := mock.NewSink()
+		sink := factory(t, backend)
+
+		require.NoError(t, sink.Close())
+		require.True(t, backend.WasClosed(), "backend wasn't closed")
+	})
+}
+
+func publish(ctx context.Context, sink substrate.AsyncMessageSink) (chan<- substrate.Message, <-chan substrate.Message, <-chan error) {
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+	return messages, acks, errs
+}
+
+// requireReturned waits for the call to return and returns its error.
+func requireReturned(t *testing.T, errs <-chan error) error {
+	t.Helper()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(timeout):
+		require.FailNow(t, "wrapper didn't return")
+		return nil
+	}
+}
@@ -0,0 +1,113 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+// SourceFactory returns the wrapper under test wrapping the backend source.
+type SourceFactory func(t *testing.T, backend substrate.AsyncMessageSource) substrate.AsyncMessageSource
+
+// TestAsyncSource runs the source suite against the wrappers returned by the factory. The backend sends messages
+// with plain text payloads, which the wrapper must deliver unchanged. It verifies that the wrapper:
+//   - delivers every message of the backend and forwards their acknowledgements to it, in order,
+//   - returns from ConsumeMessages once the context is cancelled, even while messages weren't acknowledged,
+//   - returns from ConsumeMessages once the backend failed,
+//   - closes the backend when it is closed.
+func TestAsyncSource(t *testing.T, factory SourceFactory) {
+	t.Run("ForwardsAcks", func(t *testing.T) {
+		consumed := make([]substrate.Message, messageCount)
+		for i := range consumed {
+			consumed[i] = message.FromString(fmt.Sprintf("message-%d", i))
+		}
+		backend := mock.NewSource(consumed...)
+		source := factory(t, backend)
+		defer source.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		messages, acks, errs := consume(ctx, source)
+
+		for i := range consumed {
+			select {
+			case msg := <-messages:
+				require.Equal(t, fmt.Sprintf("message-%d", i), string(msg.Data()))
+				select {
+				case acks <- msg:
+				case err := <-errs:
+					require.FailNow(t, "consuming failed", "%v", err)
+				}
+			case err := <-errs:
+				require.FailNow(t, "consuming failed", "%v", err)
+			case <-ctx.Done():
+				require.FailNow(t, "messages weren't delivered", "%d of %d delivered", i, messageCount)
+			}
+		}
+		select {
+		case <-backend.AllAcked():
+		case err := <-errs:
+			require.FailNow(t, "consuming failed", "%v", err)
+		case <-ctx.Done():
+			require.FailNow(t, "acknowledgements weren't forwarded", "%d of %d forwarded", len(backend.Acked()), messageCount)
+		}
+
+		cancel()
+		requireReturned(t, errs)
+	})
+
+	t.Run("ContextCancellation", func(t *testing.T) {
+		backend := mock.NewSource(message.FromString("message-0"), message.FromString("message-1"))
+		source := factory(t, backend)
+		defer source.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		messages, _, errs := consume(ctx, source)
+
+		select {
+		case <-messages:
+		case <-time.After(timeout):
+			require.FailNow(t, "message wasn't delivered")
+		}
+		cancel()
+		require.NoError(t, requireReturned(t, errs))
+	})
+
+	t.Run("BackendFailure", func(t *testing.T) {
+		backend := mock.NewSource(message.FromString("message-0")).FailAt(1, errors.New("backend failed"))
+		source := factory(t, backend)
+		defer source.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		_, _, errs := consume(ctx, source)
+
+		requireReturned(t, errs)
+	})
+
+	t.Run("ClosesBackend", func(t *testing.T) {
+		backend := mock.NewSource()
+		source := factory(t, backend)
+
+		require.NoError(t, source.Close())
+		require.True(t, backend.WasClosed(), "backend wasn't closed")
+	})
+}
+
+func consume(ctx context.Context, source substrate.AsyncMessageSource) (<-chan substrate.Message, chan<- substrate.Message, <-chan error) {
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+	return messages, acks, errs
+}
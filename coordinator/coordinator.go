@@ -0,0 +1,294 @@
+// Package coordinator distributes a set of resources, such as substrate source URLs or partitions, across the
+// instances of an application using leases, so that backends without native consumer groups can scale out.
+package coordinator
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+)
+
+const (
+	defaultLeaseTTL = time.Second * 10
+
+	memberPrefix   = "member/"
+	resourcePrefix = "resource/"
+)
+
+// Handler processes a resource, e.g. by consuming a source, until the context is cancelled, which happens when
+// the resource is handed off to another instance. The resource is only released once the handler returned, its lease
+// being renewed until then, so handlers may take longer than the lease TTL to stop.
+type Handler func(ctx context.Context, resource string) error
+
+// Option is a function which sets a Coordinator configuration option.
+type Option func(c *Coordinator)
+
+// WithLeaseTTL sets how long leases are held without being renewed, which bounds how long the resources of a
+// failed instance stay unprocessed. Leases are renewed every third of the TTL. The default is 10 seconds.
+func WithLeaseTTL(ttl time.Duration) Option {
+	return func(c *Coordinator) {
+		c.ttl = ttl
+	}
+}
+
+// WithOwnedGauge sets a gauge that is updated with the number of resources processed by the instance. It panics
+// in case it can't register the metric.
+func WithOwnedGauge(gaugeOpts prometheus.GaugeOpts) Option {
+	return func(c *Coordinator) {
+		c.owned = metrics.Register(prometheus.NewGauge(gaugeOpts)).(prometheus.Gauge)
+	}
+}
+
+// Coordinator processes a share of the resources on behalf of a member of the group of instances.
+type Coordinator struct {
+	store     LeaseStore
+	member    string
+	resources []string
+	ttl       time.Duration
+	owned     prometheus.Gauge
+
+	workers map[string]*worker
+	done    chan workerResult
+}
+
+type worker struct {
+	cancel  func()
+	stopped bool
+}
+
+type workerResult struct {
+	resource string
+	err      error
+}
+
+// New returns a coordinator for the member, which must uniquely identify the instance, e.g. its host name. All
+// the members of the group must be configured with the same resources.
+func New(store LeaseStore, member string, resources []string, opts ...Option) *Coordinator {
+	c := &Coordinator{
+		store:     store,
+		member:    member,
+		resources: append([]string(nil), resources...),
+		ttl:       defaultLeaseTTL,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	sort.Strings(c.resources)
+	return c
+}
+
+// Run joins the group and calls the handler, in its own goroutine, for every resource leased by the member. Every
+// member leases an even share of the resources: when members join or leave, members with too many resources stop
+// the handlers of their surplus resources and release them, and members with too few acquire the free ones.
+// Resources whose lease couldn't be renewed are stopped too. Run returns when the context is cancelled, after
+// stopping all the handlers and releasing all the leases, or when a handler or the store fails. A handler
+// returning nil before being stopped releases its resource, which may be acquired again at the next renewal.
+func (c *Coordinator) Run(ctx context.Context, handler Handler) (err error) {
+	c.workers = make(map[string]*worker)
+	c.done = make(chan workerResult)
+	defer func() {
+		if lErr := c.leave(); lErr != nil {
+			err = multierror.Append(err, lErr)
+		}
+	}()
+
+	ticker := time.NewTicker(c.ttl / 3)
+	defer ticker.Stop()
+
+	if err := c.rebalance(ctx, handler); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := c.rebalance(ctx, handler); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+		case res := <-c.done:
+			if ctx.Err() != nil {
+				// the handler returned because the coordinator is stopping
+				c.stop(res.resource)
+				return c.finished(context.Background(), res)
+			}
+			if err := c.finished(ctx, res); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// rebalance renews the leases of the member and adjusts the resources it processes to its share.
+func (c *Coordinator) rebalance(ctx context.Context, handler Handler) error {
+	if _, err := c.store.Acquire(ctx, memberPrefix+c.member, c.member, c.ttl); err != nil {
+		return err
+	}
+	leases, err := c.store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	var members []string
+	owners := make(map[string]string)
+	for _, lease := range leases {
+		switch {
+		case strings.HasPrefix(lease.Name, memberPrefix):
+			members = append(members, lease.Owner)
+		case strings.HasPrefix(lease.Name, resourcePrefix):
+			owners[strings.TrimPrefix(lease.Name, resourcePrefix)] = lease.Owner
+		}
+	}
+	share := c.share(members)
+
+	// renew the leases of the resources being processed, stopping those that were lost. The leases of stopped
+	// resources are renewed too, so that they aren't acquired by other members before their handlers returned.
+	var active []string
+	for _, resource := range c.resources {
+		w, ok := c.workers[resource]
+		if !ok {
+			continue
+		}
+		held, err := c.store.Acquire(ctx, resourcePrefix+resource, c.member, c.ttl)
+		if err != nil {
+			return err
+		}
+		if w.stopped {
+			continue
+		}
+		if !held {
+			c.stop(resource)
+			continue
+		}
+		active = append(active, resource)
+	}
+
+	// hand off the surplus resources, they are released once their handlers returned
+	for len(active) > share {
+		c.stop(active[len(active)-1])
+		active = active[:len(active)-1]
+	}
+
+	// acquire free resources up to the share
+	for _, resource := range c.resources {
+		if len(active) >= share {
+			break
+		}
+		if _, ok := c.workers[resource]; ok {
+			continue
+		}
+		if owner, ok := owners[resource]; ok && owner != c.member {
+			continue
+		}
+		held, err := c.store.Acquire(ctx, resourcePrefix+resource, c.member, c.ttl)
+		if err != nil {
+			return err
+		}
+		if held {
+			c.start(ctx, resource, handler)
+			active = append(active, resource)
+		}
+	}
+
+	if c.owned != nil {
+		c.owned.Set(float64(len(active)))
+	}
+	return nil
+}
+
+// share returns the number of resources the member should process. Resources are split evenly, the remainder
+// going to the first members in name order.
+func (c *Coordinator) share(members []string) int {
+	sort.Strings(members)
+	index := sort.SearchStrings(members, c.member)
+	if index == len(members) || members[index] != c.member {
+		// the membership lease expired between acquiring and listing it
+		members = append(members, c.member)
+		sort.Strings(members)
+		index = sort.SearchStrings(members, c.member)
+	}
+	share := len(c.resources) / len(members)
+	if index < len(c.resources)%len(members) {
+		share++
+	}
+	return share
+}
+
+func (c *Coordinator) start(ctx context.Context, resource string, handler Handler) {
+	ctx, cancel := context.WithCancel(ctx)
+	c.workers[resource] = &worker{cancel: cancel}
+	go func() {
+		err := handler(ctx, resource)
+		c.done <- workerResult{resource: resource, err: err}
+	}()
+}
+
+func (c *Coordinator) stop(resource string) {
+	w := c.workers[resource]
+	w.stopped = true
+	w.cancel()
+}
+
+// finished releases the resource of a handler that returned. Handlers that weren't stopped fail the coordinator
+// if they return an error.
+func (c *Coordinator) finished(ctx context.Context, res workerResult) error {
+	w := c.workers[res.resource]
+	delete(c.workers, res.resource)
+	w.cancel()
+	err := c.store.Release(ctx, resourcePrefix+res.resource, c.member)
+	if !w.stopped && res.err != nil {
+		hErr := errors.Wrapf(res.err, "handler of %s failed", res.resource)
+		if err != nil {
+			return multierror.Append(hErr, err)
+		}
+		return hErr
+	}
+	return err
+}
+
+// leave stops all the handlers, waits for them to return, renewing their leases meanwhile, and releases all the
+// leases of the member.
+func (c *Coordinator) leave() error {
+	ctx := context.Background()
+
+	var err error
+	for resource, w := range c.workers {
+		if !w.stopped {
+			c.stop(resource)
+		}
+	}
+
+	ticker := time.NewTicker(c.ttl / 3)
+	defer ticker.Stop()
+	for len(c.workers) > 0 {
+		select {
+		case res := <-c.done:
+			if rErr := c.finished(ctx, res); rErr != nil {
+				err = multierror.Append(err, rErr)
+			}
+		case <-ticker.C:
+			for resource := range c.workers {
+				if _, rErr := c.store.Acquire(ctx, resourcePrefix+resource, c.member, c.ttl); rErr != nil {
+					err = multierror.Append(err, rErr)
+				}
+			}
+		}
+	}
+	if rErr := c.store.Release(ctx, memberPrefix+c.member, c.member); rErr != nil {
+		err = multierror.Append(err, rErr)
+	}
+	if c.owned != nil {
+		c.owned.Set(0)
+	}
+	return err
+}
@@ -0,0 +1,227 @@
+package coordinator_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate-tools/coordinator"
+)
+
+// assignments records which member processes each resource, failing if two members process one at the same time.
+type assignments struct {
+	mutex     sync.Mutex
+	owners    map[string]string
+	conflicts int
+	// stopDelay is how long handlers take to return once stopped.
+	stopDelay time.Duration
+}
+
+func (a *assignments) handler(member string) coordinator.Handler {
+	return func(ctx context.Context, resource string) error {
+		a.mutex.Lock()
+		if _, ok := a.owners[resource]; ok {
+			a.conflicts++
+		}
+		a.owners[resource] = member
+		a.mutex.Unlock()
+
+		<-ctx.Done()
+		time.Sleep(a.stopDelay)
+
+		a.mutex.Lock()
+		delete(a.owners, resource)
+		a.mutex.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (a *assignments) counts() map[string]int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	counts := make(map[string]int)
+	for _, member := range a.owners {
+		counts[member]++
+	}
+	return counts
+}
+
+func TestCoordinator_Rebalancing(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	store := coordinator.NewMemoryStore()
+	resources := []string{"p1", "p2", "p3", "p4", "p5"}
+	a := &assignments{owners: make(map[string]string)}
+
+	run := func(ctx context.Context, member string) <-chan error {
+		errs := make(chan error, 1)
+		c := coordinator.New(store, member, resources, coordinator.WithLeaseTTL(time.Millisecond*60))
+		go func() {
+			errs <- c.Run(ctx, a.handler(member))
+		}()
+		return errs
+	}
+	eventually := func(expected map[string]int) {
+		require.Eventually(t, func() bool {
+			return reflect.DeepEqual(expected, a.counts())
+		}, time.Second*2, time.Millisecond*10, "expected %v, got %v", expected, a.counts())
+	}
+
+	ctxA, stopA := context.WithCancel(ctx)
+	errsA := run(ctxA, "a")
+	eventually(map[string]int{"a": 5})
+
+	// A joining member gets its share, the remainder going to the first member.
+	errsB := run(ctx, "b")
+	eventually(map[string]int{"a": 3, "b": 2})
+
+	// The resources of a leaving member are taken over.
+	stopA()
+	require.NoError(t, <-errsA)
+	eventually(map[string]int{"b": 5})
+
+	cancel()
+	require.NoError(t, <-errsB)
+	require.Empty(t, a.counts())
+	require.Zero(t, a.conflicts)
+}
+
+func TestCoordinator_SlowHandlers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	store := coordinator.NewMemoryStore()
+	resources := []string{"p1", "p2"}
+	// Handlers take several lease TTLs to stop, their resources must not be acquired by other members meanwhile.
+	a := &assignments{owners: make(map[string]string), stopDelay: time.Millisecond * 300}
+
+	run := func(ctx context.Context, member string) <-chan error {
+		errs := make(chan error, 1)
+		c := coordinator.New(store, member, resources, coordinator.WithLeaseTTL(time.Millisecond*60))
+		go func() {
+			errs <- c.Run(ctx, a.handler(member))
+		}()
+		return errs
+	}
+	eventually := func(expected map[string]int) {
+		require.Eventually(t, func() bool {
+			return reflect.DeepEqual(expected, a.counts())
+		}, time.Second*2, time.Millisecond*10, "expected %v, got %v", expected, a.counts())
+	}
+
+	ctxA, stopA := context.WithCancel(ctx)
+	errsA := run(ctxA, "a")
+	eventually(map[string]int{"a": 2})
+
+	// The resource handed off is only acquired once its handler returned.
+	errsB := run(ctx, "b")
+	eventually(map[string]int{"a": 1, "b": 1})
+
+	// So are the resources of a leaving member.
+	stopA()
+	require.NoError(t, <-errsA)
+	eventually(map[string]int{"b": 2})
+
+	cancel()
+	require.NoError(t, <-errsB)
+	require.Zero(t, a.conflicts)
+}
+
+func TestCoordinator_HandlerError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	store := coordinator.NewMemoryStore()
+	c := coordinator.New(store, "a", []string{"p1"})
+	err := c.Run(ctx, func(context.Context, string) error {
+		return errors.New("boom")
+	})
+	require.EqualError(t, err, "handler of p1 failed: boom")
+
+	// All the leases are released.
+	leases, err := store.List(ctx)
+	require.NoError(t, err)
+	require.Empty(t, leases)
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := coordinator.NewMemoryStore()
+
+	held, err := store.Acquire(ctx, "lease", "a", time.Minute)
+	require.NoError(t, err)
+	require.True(t, held)
+	held, err = store.Acquire(ctx, "lease", "b", time.Minute)
+	require.NoError(t, err)
+	require.False(t, held)
+
+	// Leases can only be released by their owner.
+	require.NoError(t, store.Release(ctx, "lease", "b"))
+	held, err = store.Acquire(ctx, "lease", "a", time.Minute)
+	require.NoError(t, err)
+	require.True(t, held)
+
+	// Expired leases can be acquired by anyone.
+	_, err = store.Acquire(ctx, "expiring", "a", time.Millisecond)
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond * 5)
+	held, err = store.Acquire(ctx, "expiring", "b", time.Minute)
+	require.NoError(t, err)
+	require.True(t, held)
+}
+
+func TestSQLStore(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := coordinator.NewSQLStore(db, "leases")
+	update := regexp.QuoteMeta("UPDATE leases SET owner = ?, expires_at = ? WHERE name = ? AND (owner = ? OR expires_at < ?)")
+	insert := regexp.QuoteMeta("INSERT INTO leases (name, owner, expires_at) VALUES (?, ?, ?)")
+
+	// The lease is renewed.
+	mock.ExpectExec(update).WillReturnResult(sqlmock.NewResult(0, 1))
+	held, err := store.Acquire(ctx, "lease", "a", time.Minute)
+	require.NoError(t, err)
+	require.True(t, held)
+
+	// The lease doesn't exist yet.
+	mock.ExpectExec(update).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(insert).WithArgs("lease", "a", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	held, err = store.Acquire(ctx, "lease", "a", time.Minute)
+	require.NoError(t, err)
+	require.True(t, held)
+
+	// The lease is held by another owner.
+	mock.ExpectExec(update).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(insert).WillReturnError(errors.New("duplicate key"))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM leases WHERE name = ?")).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	held, err = store.Acquire(ctx, "lease", "b", time.Minute)
+	require.NoError(t, err)
+	require.False(t, held)
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM leases WHERE name = ? AND owner = ?")).
+		WithArgs("lease", "a").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, store.Release(ctx, "lease", "a"))
+
+	expiry := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT name, owner, expires_at FROM leases WHERE expires_at > ?")).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "owner", "expires_at"}).AddRow("lease", "a", expiry))
+	leases, err := store.List(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []coordinator.Lease{{Name: "lease", Owner: "a", Expiry: expiry}}, leases)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
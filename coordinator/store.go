@@ -0,0 +1,179 @@
+package coordinator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Lease is a named lease held by an owner until it expires.
+type Lease struct {
+	Name   string
+	Owner  string
+	Expiry time.Time
+}
+
+// LeaseStore keeps leases shared by all the instances of an application, e.g. in an SQL table or Redis.
+type LeaseStore interface {
+	// Acquire acquires the lease for the owner until the TTL elapses, if it is free, expired or already held
+	// by the owner, in which case it is renewed. It reports whether the owner holds the lease.
+	Acquire(ctx context.Context, name, owner string, ttl time.Duration) (bool, error)
+	// Release releases the lease, if it is held by the owner.
+	Release(ctx context.Context, name, owner string) error
+	// List returns the leases that haven't expired.
+	List(ctx context.Context) ([]Lease, error)
+}
+
+// NewMemoryStore returns an in-memory store, which is mostly useful for testing, as it can only coordinate
+// instances running in the same process.
+func NewMemoryStore() LeaseStore {
+	return &memoryStore{
+		leases: make(map[string]Lease),
+		now:    time.Now,
+	}
+}
+
+type memoryStore struct {
+	mutex  sync.Mutex
+	leases map[string]Lease
+	now    func() time.Time
+}
+
+func (s *memoryStore) Acquire(_ context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	if lease, ok := s.leases[name]; ok && lease.Owner != owner && lease.Expiry.After(now) {
+		return false, nil
+	}
+	s.leases[name] = Lease{Name: name, Owner: owner, Expiry: now.Add(ttl)}
+	return true, nil
+}
+
+func (s *memoryStore) Release(_ context.Context, name, owner string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if lease, ok := s.leases[name]; ok && lease.Owner == owner {
+		delete(s.leases, name)
+	}
+	return nil
+}
+
+func (s *memoryStore) List(_ context.Context) ([]Lease, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	leases := make([]Lease, 0, len(s.leases))
+	for _, lease := range s.leases {
+		if lease.Expiry.After(now) {
+			leases = append(leases, lease)
+		}
+	}
+	return leases, nil
+}
+
+// SQLStoreOption is a function which sets an SQL store configuration option.
+type SQLStoreOption func(s *sqlStore)
+
+// WithDollarPlaceholders makes queries use numbered placeholders, as required by PostgreSQL, instead of ?.
+func WithDollarPlaceholders() SQLStoreOption {
+	return func(s *sqlStore) {
+		s.placeholder = func(n int) string {
+			return fmt.Sprintf("$%d", n)
+		}
+	}
+}
+
+// NewSQLStore returns a store keeping leases in the table, which must have the following columns: a text name,
+// which is the primary key, a text owner and an expires_at timestamp. The clocks of all the instances are
+// assumed to be reasonably synchronised.
+func NewSQLStore(db *sql.DB, table string, opts ...SQLStoreOption) LeaseStore {
+	s := &sqlStore{
+		db:    db,
+		table: table,
+		placeholder: func(int) string {
+			return "?"
+		},
+		now: time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type sqlStore struct {
+	db          *sql.DB
+	table       string
+	placeholder func(n int) string
+	now         func() time.Time
+}
+
+func (s *sqlStore) Acquire(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	now := s.now().UTC()
+	update := fmt.Sprintf(
+		"UPDATE %s SET owner = %s, expires_at = %s WHERE name = %s AND (owner = %s OR expires_at < %s)",
+		s.table, s.placeholder(1), s.placeholder(2), s.placeholder(3), s.placeholder(4), s.placeholder(5),
+	)
+	result, err := s.db.ExecContext(ctx, update, owner, now.Add(ttl), name, owner, now)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to acquire lease")
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return false, errors.Wrap(err, "failed to acquire lease")
+	} else if n > 0 {
+		return true, nil
+	}
+
+	// the lease either doesn't exist yet or is held by another owner, in which case inserting it fails
+	insert := fmt.Sprintf(
+		"INSERT INTO %s (name, owner, expires_at) VALUES (%s, %s, %s)",
+		s.table, s.placeholder(1), s.placeholder(2), s.placeholder(3),
+	)
+	if _, err := s.db.ExecContext(ctx, insert, name, owner, now.Add(ttl)); err != nil {
+		var existing int
+		query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE name = %s", s.table, s.placeholder(1))
+		if qErr := s.db.QueryRowContext(ctx, query, name).Scan(&existing); qErr == nil && existing > 0 {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "failed to acquire lease")
+	}
+	return true, nil
+}
+
+func (s *sqlStore) Release(ctx context.Context, name, owner string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE name = %s AND owner = %s", s.table, s.placeholder(1), s.placeholder(2))
+	if _, err := s.db.ExecContext(ctx, query, name, owner); err != nil {
+		return errors.Wrap(err, "failed to release lease")
+	}
+	return nil
+}
+
+func (s *sqlStore) List(ctx context.Context) ([]Lease, error) {
+	query := fmt.Sprintf("SELECT name, owner, expires_at FROM %s WHERE expires_at > %s", s.table, s.placeholder(1))
+	rows, err := s.db.QueryContext(ctx, query, s.now().UTC())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list leases")
+	}
+	defer rows.Close()
+
+	var leases []Lease
+	for rows.Next() {
+		var lease Lease
+		if err := rows.Scan(&lease.Name, &lease.Owner, &lease.Expiry); err != nil {
+			return nil, errors.Wrap(err, "failed to read lease")
+		}
+		leases = append(leases, lease)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to list leases")
+	}
+	return leases, nil
+}
@@ -0,0 +1,246 @@
+// Package cursor provides the position of consumed messages as cursors, and a message source wrapper remembering
+// the cursors of recently delivered messages, so that the stream can be rewound to an earlier cursor, or point in
+// time, on demand, e.g. to reprocess the messages of the last hour from an admin endpoint.
+package cursor
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/checkpoint"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/ttl"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+const (
+	defaultHistory    = time.Hour
+	defaultResolution = time.Second
+)
+
+// ErrNotSupported is returned when rewinding a source that doesn't implement Rewinder.
+var ErrNotSupported = errors.New("source doesn't support rewinding")
+
+// Cursor is the position of a message within the partition of the topic it was consumed from.
+type Cursor struct {
+	// Partition is the identifier of the partition.
+	Partition string
+	// Offset is the offset of the message within the partition.
+	Offset int64
+}
+
+// Of returns the cursor of the message, and false if the message doesn't implement checkpoint.Positioned.
+func Of(msg substrate.Message) (Cursor, bool) {
+	pMsg, ok := msg.(checkpoint.Positioned)
+	if !ok {
+		return Cursor{}, false
+	}
+	return Cursor{Partition: pMsg.Partition(), Offset: pMsg.Offset()}, true
+}
+
+// Rewinder is implemented by sources of backends able to consume messages again from an earlier position.
+type Rewinder interface {
+	// Rewind makes the source deliver the messages of the partition again, starting at the offset.
+	Rewind(ctx context.Context, partition string, offset int64) error
+}
+
+// AsyncMessageSourceOption is a function which sets an AsyncMessageSource configuration option.
+type AsyncMessageSourceOption func(s *Source)
+
+// WithHistory sets how long the cursors of delivered messages are remembered, which bounds how far back the source
+// can be rewound to a point in time. The default value is 1 hour.
+func WithHistory(history time.Duration) AsyncMessageSourceOption {
+	return func(s *Source) {
+		s.history = history
+	}
+}
+
+// WithResolution sets the minimum time between the timestamps of two remembered cursors of a partition, which bounds
+// the memory used to remember them. Rewinding to a point in time may deliver again messages produced up to that long
+// before it. The default value is 1 second.
+func WithResolution(resolution time.Duration) AsyncMessageSourceOption {
+	return func(s *Source) {
+		s.resolution = resolution
+	}
+}
+
+// WithTimestampFunc sets the function returning the time at which a message was produced. The timestamps of
+// enveloped messages are used by default, falling back to the time at which messages are delivered.
+func WithTimestampFunc(f ttl.TimestampFunc) AsyncMessageSourceOption {
+	return func(s *Source) {
+		s.timestamp = f
+	}
+}
+
+// NewAsyncMessageSource returns a message source that delivers the messages of the underlying source unchanged,
+// remembering the cursors of those implementing checkpoint.Positioned, and that can be rewound if the underlying
+// source implements Rewinder. It should wrap the source of the backend directly, as other wrappers hide its methods.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, opts ...AsyncMessageSourceOption) *Source {
+	s := &Source{
+		source:     source,
+		history:    defaultHistory,
+		resolution: defaultResolution,
+		timestamp:  ttl.EnvelopeTimestamp,
+		now:        time.Now,
+		marks:      make(map[string][]mark),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Source is a message source that can be rewound to an earlier cursor or point in time.
+type Source struct {
+	source     substrate.AsyncMessageSource
+	history    time.Duration
+	resolution time.Duration
+	timestamp  ttl.TimestampFunc
+	now        func() time.Time
+
+	mutex sync.Mutex
+	// marks are the remembered cursors of each partition, in the order in which they were delivered.
+	marks map[string][]mark
+}
+
+// mark is the remembered cursor of a delivered message.
+type mark struct {
+	offset    int64
+	timestamp time.Time
+	delivered time.Time
+}
+
+// ConsumeMessages delivers the messages of the underlying source, remembering their cursors.
+func (s *Source) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "cursor"})
+	rg, ctx := rungroup.New(ctx)
+
+	sourceMsgs := make(chan substrate.Message, cap(messages))
+
+	rg.Go(func() error {
+		return s.source.ConsumeMessages(ctx, sourceMsgs, acks)
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-sourceMsgs:
+				emit.Publish(msg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case messages <- msg:
+					s.remember(msg)
+				}
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+// remember records the cursor of the message, unless another one of its partition was recorded with a timestamp
+// less than the resolution before it.
+func (s *Source) remember(msg substrate.Message) {
+	c, ok := Of(msg)
+	if !ok {
+		return
+	}
+	now := s.now()
+	timestamp, ok := s.timestamp(msg)
+	if !ok {
+		timestamp = now
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	marks := s.marks[c.Partition]
+	expired := 0
+	for expired < len(marks) && now.Sub(marks[expired].delivered) > s.history {
+		expired++
+	}
+	marks = marks[expired:]
+	if n := len(marks); n > 0 && (c.Offset <= marks[n-1].offset || timestamp.Sub(marks[n-1].timestamp) < s.resolution) {
+		s.marks[c.Partition] = marks
+		return
+	}
+	s.marks[c.Partition] = append(marks, mark{offset: c.Offset, timestamp: timestamp, delivered: now})
+}
+
+// Cursors returns, for each partition, the cursor from which messages produced at or after the time are delivered
+// again, sorted by partition. If the time is before the remembered history of a partition, the oldest remembered
+// cursor is returned for it.
+func (s *Source) Cursors(since time.Time) []Cursor {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	cursors := make([]Cursor, 0, len(s.marks))
+	for partition, marks := range s.marks {
+		if len(marks) == 0 {
+			continue
+		}
+		// the last mark at or before the time, as messages produced after it may have been delivered before the
+		// next mark
+		i := sort.Search(len(marks), func(i int) bool {
+			return marks[i].timestamp.After(since)
+		})
+		if i > 0 {
+			i--
+		}
+		cursors = append(cursors, Cursor{Partition: partition, Offset: marks[i].offset})
+	}
+	sort.Slice(cursors, func(i, j int) bool {
+		return cursors[i].Partition < cursors[j].Partition
+	})
+	return cursors
+}
+
+// Rewind makes the source deliver messages again from the cursors, after the messages already delivered. It returns
+// ErrNotSupported if the underlying source doesn't implement Rewinder.
+func (s *Source) Rewind(ctx context.Context, cursors ...Cursor) error {
+	rewinder, ok := s.source.(Rewinder)
+	if !ok {
+		return ErrNotSupported
+	}
+	for _, c := range cursors {
+		if err := rewinder.Rewind(ctx, c.Partition, c.Offset); err != nil {
+			return errors.Wrapf(err, "failed to rewind partition %q to offset %d", c.Partition, c.Offset)
+		}
+		s.forget(c)
+	}
+	return nil
+}
+
+// RewindTo makes the source deliver messages produced at or after the time again, as far as they are remembered.
+func (s *Source) RewindTo(ctx context.Context, since time.Time) error {
+	return s.Rewind(ctx, s.Cursors(since)...)
+}
+
+// forget drops the remembered cursors at or after the cursor, which are remembered again once delivered again.
+func (s *Source) forget(c Cursor) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	marks := s.marks[c.Partition]
+	i := sort.Search(len(marks), func(i int) bool {
+		return marks[i].offset >= c.Offset
+	})
+	s.marks[c.Partition] = marks[:i]
+}
+
+// Close closes the underlying source.
+func (s *Source) Close() error {
+	return s.source.Close()
+}
+
+// Status returns the status of the underlying source.
+func (s *Source) Status() (*substrate.Status, error) {
+	return s.source.Status()
+}
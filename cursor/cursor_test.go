@@ -0,0 +1,122 @@
+package cursor_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/cursor"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+var start = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// minutes returns the timestamp of test messages, whose payload is the number of minutes since the start time.
+func minutes(msg substrate.Message) (time.Time, bool) {
+	n, err := strconv.Atoi(string(msg.Data()))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return start.Add(time.Minute * time.Duration(n)), true
+}
+
+func publish(ctx context.Context, t *testing.T, broker *mem.Broker, count int) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sink := broker.NewAsyncMessageSink("topic")
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+	for i := 0; i < count; i++ {
+		messages <- message.FromString(strconv.Itoa(i))
+		<-acks
+	}
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func receive(t *testing.T, messages <-chan substrate.Message, acks chan<- substrate.Message, count int) []string {
+	var received []string
+	for i := 0; i < count; i++ {
+		msg := <-messages
+		received = append(received, string(msg.Data()))
+		acks <- msg
+	}
+	return received
+}
+
+func TestSource_RewindTo(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	publish(ctx, t, broker, 10)
+
+	source := cursor.NewAsyncMessageSource(broker.NewAsyncMessageSource("topic", "group"),
+		cursor.WithTimestampFunc(minutes),
+		cursor.WithResolution(time.Minute*3),
+	)
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+	require.Len(t, receive(t, messages, acks, 10), 10)
+
+	// Cursors are remembered every 3 minutes, so the closest one before 5 minutes is the one at 3 minutes.
+	require.Equal(t, []cursor.Cursor{{Partition: "0", Offset: 3}}, source.Cursors(start.Add(time.Minute*5)))
+	require.Equal(t, []cursor.Cursor{{Partition: "0", Offset: 0}}, source.Cursors(start.Add(-time.Hour)))
+
+	require.NoError(t, source.RewindTo(ctx, start.Add(time.Minute*5)))
+	require.Equal(t, []string{"3", "4", "5", "6", "7", "8", "9"}, receive(t, messages, acks, 7))
+
+	require.NoError(t, source.Rewind(ctx, cursor.Cursor{Partition: "0", Offset: 8}))
+	require.Equal(t, []string{"8", "9"}, receive(t, messages, acks, 2))
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestSource_History(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	publish(ctx, t, broker, 3)
+
+	source := cursor.NewAsyncMessageSource(broker.NewAsyncMessageSource("topic", "group"),
+		cursor.WithTimestampFunc(minutes),
+		cursor.WithHistory(time.Millisecond*50),
+	)
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	go source.ConsumeMessages(ctx, messages, acks)
+	receive(t, messages, acks, 2)
+
+	// The cursors of messages delivered longer ago than the history are forgotten.
+	time.Sleep(time.Millisecond * 100)
+	receive(t, messages, acks, 1)
+	require.Eventually(t, func() bool {
+		cursors := source.Cursors(start)
+		return len(cursors) == 1 && cursors[0].Offset == 2
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestSource_NotSupported(t *testing.T) {
+	source := cursor.NewAsyncMessageSource(mock.NewSource())
+	require.Equal(t, cursor.ErrNotSupported, source.Rewind(context.Background(), cursor.Cursor{Partition: "0"}))
+	require.Empty(t, source.Cursors(start))
+}
+
+func TestOf(t *testing.T) {
+	_, ok := cursor.Of(message.FromString("payload"))
+	require.False(t, ok)
+}
@@ -0,0 +1,192 @@
+// Package dbsink provides a message sink that writes messages into an SQL database, so that pipelines can
+// terminate in a database without ad-hoc consumers.
+package dbsink
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+)
+
+const (
+	defaultBatchSize     = 100
+	defaultFlushInterval = time.Millisecond * 100
+)
+
+// ArgsFunc returns the arguments the statement is executed with for the message.
+type ArgsFunc func(msg substrate.Message) ([]interface{}, error)
+
+// PayloadArgs executes the statement with the payload of the message as its only argument.
+func PayloadArgs(msg substrate.Message) ([]interface{}, error) {
+	return []interface{}{msg.Data()}, nil
+}
+
+// InsertInto returns a statement inserting a row with the columns into the table, using ? placeholders. Drivers
+// using other placeholders, such as PostgreSQL ones, need their statement written explicitly.
+func InsertInto(table string, columns ...string) string {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), placeholders)
+}
+
+// Option is a function which sets a database sink configuration option.
+type Option func(s *dbSink)
+
+// WithArgs sets the function returning the arguments of the statement for each message. The default is PayloadArgs.
+func WithArgs(args ArgsFunc) Option {
+	return func(s *dbSink) {
+		s.args = args
+	}
+}
+
+// WithBatchSize sets the maximum number of messages written in a single transaction. The default is 100.
+func WithBatchSize(size int) Option {
+	return func(s *dbSink) {
+		s.batchSize = size
+	}
+}
+
+// WithFlushInterval sets the maximum time a message waits for its batch to fill up before the batch is written
+// anyway. The default is 100 milliseconds.
+func WithFlushInterval(interval time.Duration) Option {
+	return func(s *dbSink) {
+		s.flushInterval = interval
+	}
+}
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that executes the statement, typically an
+// insert, for each message. Messages are written in batches, each in a single transaction, and acknowledged once
+// the transaction is committed. A failure to write a batch rolls the transaction back and makes PublishMessages
+// return an error, leaving the messages of the batch unacknowledged.
+func NewAsyncMessageSink(db *sql.DB, statement string, opts ...Option) substrate.AsyncMessageSink {
+	s := &dbSink{
+		db:            db,
+		statement:     statement,
+		args:          PayloadArgs,
+		batchSize:     defaultBatchSize,
+		flushInterval: defaultFlushInterval,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type dbSink struct {
+	db            *sql.DB
+	statement     string
+	args          ArgsFunc
+	batchSize     int
+	flushInterval time.Duration
+}
+
+func (s *dbSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "dbsink"})
+	var (
+		batch []substrate.Message
+		timer *time.Timer
+		due   <-chan time.Time
+	)
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	flush := func() error {
+		if timer != nil {
+			timer.Stop()
+			timer, due = nil, nil
+		}
+		if err := s.write(ctx, batch); err != nil {
+			return emit.Error(err)
+		}
+		for _, msg := range batch {
+			emit.Ack(msg)
+			select {
+			case <-ctx.Done():
+				return nil
+			case acks <- msg:
+			}
+		}
+		batch = nil
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-messages:
+			emit.Publish(msg)
+			batch = append(batch, msg)
+			if len(batch) >= s.batchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			} else if timer == nil {
+				timer = time.NewTimer(s.flushInterval)
+				due = timer.C
+			}
+		case <-due:
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// write executes the statement for every message of the batch in a single transaction.
+func (s *dbSink) write(ctx context.Context, batch []substrate.Message) (err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	stmt, err := tx.PrepareContext(ctx, s.statement)
+	if err != nil {
+		return errors.Wrap(err, "failed to prepare statement")
+	}
+	defer stmt.Close()
+
+	for _, msg := range batch {
+		args, err := s.args(msg)
+		if err != nil {
+			return errors.Wrap(err, "failed to get statement arguments")
+		}
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return errors.Wrap(err, "failed to write message")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "failed to commit transaction")
+	}
+	return nil
+}
+
+// Close does nothing, the database is owned by the caller.
+func (s *dbSink) Close() error {
+	return nil
+}
+
+// Status reports whether the database can be reached.
+func (s *dbSink) Status() (*substrate.Status, error) {
+	if err := s.db.Ping(); err != nil {
+		return &substrate.Status{
+			Working:  false,
+			Problems: []string{err.Error()},
+		}, nil
+	}
+	return &substrate.Status{Working: true}, nil
+}
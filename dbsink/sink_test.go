@@ -0,0 +1,115 @@
+package dbsink_test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/dbsink"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+var insert = regexp.QuoteMeta("INSERT INTO events (payload) VALUES (?)")
+
+func TestInsertInto(t *testing.T) {
+	require.Equal(t, "INSERT INTO events (key, payload) VALUES (?, ?)", dbsink.InsertInto("events", "key", "payload"))
+}
+
+func TestSink_Batches(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	// The first two messages fill a batch, the third one is written once the flush interval elapsed.
+	mock.ExpectBegin()
+	prepared := mock.ExpectPrepare(insert)
+	prepared.ExpectExec().WithArgs([]byte("1")).WillReturnResult(sqlmock.NewResult(1, 1))
+	prepared.ExpectExec().WithArgs([]byte("2")).WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectPrepare(insert).ExpectExec().WithArgs([]byte("3")).WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectCommit()
+
+	sink := dbsink.NewAsyncMessageSink(db, dbsink.InsertInto("events", "payload"),
+		dbsink.WithBatchSize(2),
+		dbsink.WithFlushInterval(time.Millisecond*50),
+	)
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	published := []substrate.Message{message.FromString("1"), message.FromString("2"), message.FromString("3")}
+	messages <- published[0]
+	messages <- published[1]
+	require.Equal(t, published[0], <-acks)
+	require.Equal(t, published[1], <-acks)
+	messages <- published[2]
+	require.Equal(t, published[2], <-acks)
+
+	cancel()
+	require.NoError(t, <-errs)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSink_FailureRollsBack(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	prepared := mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO events (key, payload) VALUES (?, ?)"))
+	prepared.ExpectExec().WithArgs("key-1", []byte("1")).WillReturnResult(sqlmock.NewResult(1, 1))
+	prepared.ExpectExec().WithArgs("key-2", []byte("2")).WillReturnError(errors.New("constraint violation"))
+	mock.ExpectRollback()
+
+	sink := dbsink.NewAsyncMessageSink(db, dbsink.InsertInto("events", "key", "payload"),
+		dbsink.WithBatchSize(2),
+		dbsink.WithArgs(func(msg substrate.Message) ([]interface{}, error) {
+			return []interface{}{"key-" + string(msg.Data()), msg.Data()}, nil
+		}),
+	)
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	messages <- message.FromString("1")
+	messages <- message.FromString("2")
+	require.EqualError(t, <-errs, "failed to write message: constraint violation")
+	require.Empty(t, acks)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSink_Status(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	require.NoError(t, err)
+	defer db.Close()
+
+	sink := dbsink.NewAsyncMessageSink(db, dbsink.InsertInto("events", "payload"))
+
+	mock.ExpectPing()
+	status, err := sink.Status()
+	require.NoError(t, err)
+	require.True(t, status.Working)
+
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	status, err = sink.Status()
+	require.NoError(t, err)
+	require.False(t, status.Working)
+	require.Equal(t, []string{"connection refused"}, status.Problems)
+}
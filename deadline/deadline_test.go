@@ -0,0 +1,132 @@
+package deadline_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/deadline"
+	"github.com/uw-labs/substrate-tools/dlq"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+// stuck returns a handler that blocks until its context is cancelled for the given number of calls, ignoring the
+// cancellation of the first one, and then succeeds.
+func stuck(stuckCalls int32, calls *int32) substrate.ConsumerMessageHandler {
+	return func(ctx context.Context, msg substrate.Message) error {
+		call := atomic.AddInt32(calls, 1)
+		switch {
+		case call == 1 && stuckCalls > 0:
+			time.Sleep(time.Hour)
+		case call <= stuckCalls:
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}
+}
+
+// counterValue returns the value of the registered counter.
+func counterValue(opts prometheus.CounterOpts) float64 {
+	registered := prometheus.Register(prometheus.NewCounter(opts))
+	return testutil.ToFloat64(registered.(prometheus.AlreadyRegisteredError).ExistingCollector.(prometheus.Counter))
+}
+
+func TestHandler_ReturnsBeforeDeadline(t *testing.T) {
+	handlerErr := errors.New("failed")
+	handler := deadline.Handler(func(ctx context.Context, msg substrate.Message) error {
+		_, ok := ctx.Deadline()
+		require.False(t, ok)
+		return handlerErr
+	}, time.Second)
+
+	require.Equal(t, handlerErr, handler(context.Background(), message.FromString("one")))
+}
+
+func TestHandler_FailsByDefault(t *testing.T) {
+	var calls int32
+	opts := prometheus.CounterOpts{Name: "deadline_test_timeouts_total", Help: "Attempts exceeding the deadline."}
+	handler := deadline.Handler(stuck(1, &calls), time.Millisecond*20, deadline.WithTimeoutCounter(opts))
+	before := counterValue(opts)
+
+	err := handler(context.Background(), message.FromString("one"))
+	require.EqualError(t, err, "handler timed out after 20ms")
+	var tErr *deadline.TimeoutError
+	require.True(t, errors.As(err, &tErr))
+	require.Equal(t, 1, tErr.Attempts)
+
+	require.Equal(t, float64(1), counterValue(opts)-before)
+}
+
+func TestHandler_Retry(t *testing.T) {
+	var calls int32
+	handler := deadline.Handler(stuck(2, &calls), time.Millisecond*20, deadline.WithPolicy(deadline.Retry(3, deadline.Fail)))
+
+	require.NoError(t, handler(context.Background(), message.FromString("one")))
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	calls = 0
+	handler = deadline.Handler(stuck(3, &calls), time.Millisecond*20, deadline.WithPolicy(deadline.Retry(2, deadline.Fail)))
+	err := handler(context.Background(), message.FromString("one"))
+	var tErr *deadline.TimeoutError
+	require.True(t, errors.As(err, &tErr))
+	require.Equal(t, 2, tErr.Attempts)
+}
+
+func TestHandler_DeadLetter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	sink := substrate.NewSynchronousMessageSink(broker.NewAsyncMessageSink("dead-letters"))
+	defer sink.Close()
+
+	var calls int32
+	handler := deadline.Handler(stuck(1, &calls), time.Millisecond*20, deadline.WithPolicy(deadline.DeadLetter(sink)))
+	msg := message.NewEnvelopedMessage(message.Envelope{
+		Headers: map[string]string{"key": "value"},
+		Payload: []byte("one"),
+	})
+	require.NoError(t, handler(ctx, msg))
+
+	deadLetters := broker.Messages("dead-letters")
+	require.Len(t, deadLetters, 1)
+	var failure dlq.Failure
+	require.NoError(t, json.Unmarshal(deadLetters[0], &failure))
+	require.Equal(t, dlq.Failure{
+		Payload:  []byte("one"),
+		Headers:  map[string]string{"key": "value"},
+		Reason:   "handler timed out after 20ms",
+		Attempts: 1,
+	}, failure)
+}
+
+func TestHandler_WaitsForHandlerWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	handler := deadline.Handler(func(ctx context.Context, msg substrate.Message) error {
+		cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	}, time.Hour)
+
+	require.Equal(t, context.Canceled, handler(ctx, message.FromString("one")))
+}
+
+func TestHandler_PropagatesPanics(t *testing.T) {
+	handler := deadline.Handler(func(ctx context.Context, msg substrate.Message) error {
+		panic("boom")
+	}, time.Second)
+
+	require.PanicsWithValue(t, "boom", func() {
+		_ = handler(context.Background(), message.FromString("one"))
+	})
+}
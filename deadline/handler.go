@@ -0,0 +1,173 @@
+// Package deadline provides a message handler wrapper enforcing a processing deadline for each message, so that a
+// single stuck message can't freeze the consumption of a whole partition.
+package deadline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/dlq"
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+// ErrRetry is returned by a Policy to make the handler handle the message again, with a new deadline.
+var ErrRetry = errors.New("retry")
+
+// TimeoutError is returned by the handler for a message whose handling exceeded the deadline, if the policy
+// doesn't handle it.
+type TimeoutError struct {
+	// Timeout is the deadline of each attempt.
+	Timeout time.Duration
+	// Attempts is the number of times the message was handled.
+	Attempts int
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("handler timed out after %s", e.Timeout)
+}
+
+// Policy decides what happens to a message whose handling exceeded the deadline. It returns nil for the message to
+// be acknowledged, ErrRetry for it to be handled again or an error returned by the handler.
+type Policy func(ctx context.Context, msg substrate.Message, err *TimeoutError) error
+
+// Fail returns the timeout error, which nacks the message by aborting consumption, so that it is delivered again
+// once consumption restarts.
+func Fail(_ context.Context, _ substrate.Message, err *TimeoutError) error {
+	return err
+}
+
+// Retry returns a policy handling the message again until it was handled the number of times, after which the
+// fallback policy applies.
+func Retry(attempts int, fallback Policy) Policy {
+	return func(ctx context.Context, msg substrate.Message, err *TimeoutError) error {
+		if err.Attempts < attempts {
+			return ErrRetry
+		}
+		return fallback(ctx, msg, err)
+	}
+}
+
+// DeadLetter returns a policy publishing the message to the dead-letter sink, as a dlq.Failure encoded using
+// dlq.JSONEnvelope, and acknowledging it once it was published.
+func DeadLetter(sink substrate.SynchronousMessageSink) Policy {
+	return func(ctx context.Context, msg substrate.Message, err *TimeoutError) error {
+		failure := dlq.Failure{
+			Payload:  msg.Data(),
+			Reason:   err.Error(),
+			Attempts: err.Attempts,
+		}
+		if headers := message.Headers(msg); len(headers) > 0 {
+			failure.Headers = headers
+		}
+		payload, encErr := dlq.JSONEnvelope(failure)
+		if encErr != nil {
+			return errors.Wrap(encErr, "failed to encode dead letter")
+		}
+		if pubErr := sink.PublishMessage(ctx, message.NewMessage(payload)); pubErr != nil {
+			return errors.Wrapf(pubErr, "failed to dead-letter message after %s", err)
+		}
+		return nil
+	}
+}
+
+// Option is a function which sets a deadline handler configuration option.
+type Option func(h *deadlineHandler)
+
+// WithPolicy sets the policy applied to messages whose handling exceeded the deadline. The default is Fail.
+func WithPolicy(policy Policy) Option {
+	return func(h *deadlineHandler) {
+		h.policy = policy
+	}
+}
+
+// WithTimeoutCounter enables a counter of the attempts that exceeded the deadline. It panics in case it can't
+// register the metric.
+func WithTimeoutCounter(counterOpts prometheus.CounterOpts) Option {
+	return func(h *deadlineHandler) {
+		h.timeouts = metrics.Register(prometheus.NewCounter(counterOpts)).(prometheus.Counter)
+	}
+}
+
+type deadlineHandler struct {
+	handler  substrate.ConsumerMessageHandler
+	timeout  time.Duration
+	policy   Policy
+	timeouts prometheus.Counter
+}
+
+// Handler returns a handler calling the provided one with a context cancelled once the timeout elapsed. If the
+// provided handler doesn't return by then, the policy decides what happens to the message, without waiting for the
+// handler, which is abandoned and should return as soon as its context is cancelled. Errors returned by the provided
+// handler are returned unchanged, and its panics are propagated.
+func Handler(handler substrate.ConsumerMessageHandler, timeout time.Duration, opts ...Option) substrate.ConsumerMessageHandler {
+	h := &deadlineHandler{
+		handler: handler,
+		timeout: timeout,
+		policy:  Fail,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h.handle
+}
+
+func (h *deadlineHandler) handle(ctx context.Context, msg substrate.Message) error {
+	for attempts := 1; ; attempts++ {
+		timedOut, err := h.attempt(ctx, msg)
+		if !timedOut {
+			return err
+		}
+		if h.timeouts != nil {
+			h.timeouts.Inc()
+		}
+		if err := h.policy(ctx, msg, &TimeoutError{Timeout: h.timeout, Attempts: attempts}); err != ErrRetry {
+			return err
+		}
+	}
+}
+
+// result is the outcome of calling the handler.
+type result struct {
+	err      error
+	panicked bool
+	value    interface{}
+}
+
+// attempt calls the handler, reporting whether it timed out. It waits for the handler to return if the context is
+// cancelled before the deadline, like a handler called directly.
+func (h *deadlineHandler) attempt(ctx context.Context, msg substrate.Message) (bool, error) {
+	handlerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- result{panicked: true, value: r}
+			}
+		}()
+		done <- result{err: h.handler(handlerCtx, msg)}
+	}()
+
+	timer := time.NewTimer(h.timeout)
+	defer timer.Stop()
+
+	var res result
+	select {
+	case <-timer.C:
+		return true, nil
+	case res = <-done:
+	case <-ctx.Done():
+		res = <-done
+	}
+	if res.panicked {
+		panic(res.value)
+	}
+	return false, res.err
+}
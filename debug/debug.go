@@ -0,0 +1,223 @@
+// Package debug provides message source and sink wrappers tracking the messages in flight through them, and a
+// registry dumping a snapshot of those messages on demand, on a signal or from an http endpoint, formatted like a
+// goroutine dump, to diagnose stuck pipelines in production.
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultMaxMessages = 10
+
+// The kinds of the wrappers in a snapshot.
+const (
+	KindSource = "source"
+	KindSink   = "sink"
+)
+
+// DefaultRegistry is the registry the wrappers are added to unless another one is provided.
+var DefaultRegistry = NewRegistry()
+
+// RegistryOption is a function which sets a registry configuration option.
+type RegistryOption func(r *Registry)
+
+// WithMaxMessages sets the maximum number of messages listed for each wrapper in a snapshot, the oldest ones being
+// listed. The default value is 10.
+func WithMaxMessages(max int) RegistryOption {
+	return func(r *Registry) {
+		r.maxMessages = max
+	}
+}
+
+// Registry holds the wrappers whose messages in flight are included in snapshots. It is safe for concurrent use.
+type Registry struct {
+	maxMessages int
+
+	mutex    sync.Mutex
+	trackers []*tracker
+}
+
+// NewRegistry returns a registry without any wrappers.
+func NewRegistry(opts ...RegistryOption) *Registry {
+	r := &Registry{
+		maxMessages: defaultMaxMessages,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func (r *Registry) add(t *tracker) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.trackers = append(r.trackers, t)
+}
+
+func (r *Registry) remove(t *tracker) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, other := range r.trackers {
+		if other == t {
+			r.trackers = append(r.trackers[:i:i], r.trackers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Snapshot returns the messages currently in flight through all the wrappers, in the order in which the wrappers
+// were created.
+func (r *Registry) Snapshot() Snapshot {
+	r.mutex.Lock()
+	trackers := append([]*tracker(nil), r.trackers...)
+	r.mutex.Unlock()
+
+	now := time.Now()
+	snapshot := Snapshot{Time: now, Wrappers: make([]Wrapper, 0, len(trackers))}
+	for _, t := range trackers {
+		snapshot.Wrappers = append(snapshot.Wrappers, t.snapshot(now, r.maxMessages))
+	}
+	return snapshot
+}
+
+// Snapshot is a snapshot of the messages in flight through the wrappers of a registry.
+type Snapshot struct {
+	// Time is when the snapshot was taken.
+	Time time.Time `json:"time"`
+	// Wrappers are the wrappers of the registry.
+	Wrappers []Wrapper `json:"wrappers"`
+}
+
+// Wrapper is the state of a wrapper in a snapshot.
+type Wrapper struct {
+	// Kind is either KindSource or KindSink.
+	Kind string `json:"kind"`
+	// Name is the name the wrapper was created with.
+	Name string `json:"name"`
+	// InFlight is the number of messages delivered by a source, or published to a sink, that weren't acknowledged.
+	InFlight int `json:"in_flight"`
+	// Messages are the oldest messages in flight, oldest first, up to the maximum number of messages of the registry.
+	Messages []Message `json:"messages"`
+}
+
+// Message is a message in flight in a snapshot.
+type Message struct {
+	// Age is the time since the message was delivered or published, in nanoseconds when encoded as JSON.
+	Age time.Duration `json:"age"`
+	// Size is the size of the payload in bytes, which is 0 if it was discarded.
+	Size int `json:"size"`
+	// ContentType is the content type of the message, if it has one.
+	ContentType string `json:"content_type,omitempty"`
+	// Headers are the headers of the message, if it has any.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// WriteTo writes the snapshot in a text format resembling a goroutine dump, with a paragraph for every wrapper
+// listing its oldest messages.
+func (s Snapshot) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "substrate snapshot at %s\n", s.Time.Format(time.RFC3339))
+	for _, wrapper := range s.Wrappers {
+		b.WriteString("\n")
+		if wrapper.InFlight == 0 {
+			fmt.Fprintf(&b, "%s %s [idle]:\n", wrapper.Kind, wrapper.Name)
+			continue
+		}
+		fmt.Fprintf(&b, "%s %s [%d in flight, oldest %s]:\n", wrapper.Kind, wrapper.Name, wrapper.InFlight, wrapper.Messages[0].Age.Round(time.Millisecond))
+		for i, msg := range wrapper.Messages {
+			fmt.Fprintf(&b, "\tmessage %d: age %s, %d bytes", i+1, msg.Age.Round(time.Millisecond), msg.Size)
+			if msg.ContentType != "" {
+				fmt.Fprintf(&b, ", content type %q", msg.ContentType)
+			}
+			if len(msg.Headers) > 0 {
+				fmt.Fprintf(&b, ", headers %s", formatHeaders(msg.Headers))
+			}
+			b.WriteString("\n")
+		}
+		if more := wrapper.InFlight - len(wrapper.Messages); more > 0 {
+			fmt.Fprintf(&b, "\t... %d more\n", more)
+		}
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// String returns the snapshot in the format written by WriteTo.
+func (s Snapshot) String() string {
+	var b strings.Builder
+	_, _ = s.WriteTo(&b)
+	return b.String()
+}
+
+func formatHeaders(headers map[string]string) string {
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = fmt.Sprintf("%s=%q", key, headers[key])
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// Handler returns an http.Handler serving a snapshot of the registry on GET requests, as text by default, or as
+// JSON if the format query parameter is "json".
+func Handler(r *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		switch format := req.URL.Query().Get("format"); format {
+		case "", "text":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = r.Snapshot().WriteTo(w)
+		case "json":
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(r.Snapshot())
+		default:
+			http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+		}
+	})
+}
+
+// DumpOnSignal writes a snapshot of the registry to the writer, e.g. os.Stderr, every time one of the signals,
+// e.g. syscall.SIGUSR1, is received, until the context is done. Providing no signals disables dumping. It returns an
+// error if writing a snapshot fails.
+func DumpOnSignal(ctx context.Context, r *Registry, w io.Writer, signals ...os.Signal) error {
+	if len(signals) == 0 {
+		<-ctx.Done()
+		return nil
+	}
+
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, signals...)
+	defer signal.Stop(received)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-received:
+			if _, err := r.Snapshot().WriteTo(w); err != nil {
+				return err
+			}
+		}
+	}
+}
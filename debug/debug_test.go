@@ -0,0 +1,212 @@
+package debug_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/debug"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+func TestAsyncMessageSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	registry := debug.NewRegistry(debug.WithMaxMessages(1))
+	one, two, three := message.FromString("1"), message.FromString("22"), message.FromString("333")
+	source := debug.NewAsyncMessageSource(&mock.AsyncMessageSource{Messages: []substrate.Message{one, two, three}},
+		"orders", debug.WithRegistry(registry))
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	// Messages are passed on unchanged.
+	require.Equal(t, one, <-messages)
+	require.Equal(t, two, <-messages)
+	require.Equal(t, three, <-messages)
+	acks <- one
+	require.Eventually(t, func() bool {
+		return registry.Snapshot().Wrappers[0].InFlight == 2
+	}, time.Second, time.Millisecond*10)
+
+	// Only the oldest message is listed.
+	wrapper := registry.Snapshot().Wrappers[0]
+	require.Equal(t, debug.KindSource, wrapper.Kind)
+	require.Equal(t, "orders", wrapper.Name)
+	require.Len(t, wrapper.Messages, 1)
+	require.Equal(t, 2, wrapper.Messages[0].Size)
+	require.Greater(t, wrapper.Messages[0].Age, time.Duration(0))
+
+	// Messages are no longer in flight once consumption stopped.
+	cancel()
+	require.NoError(t, <-errs)
+	require.Zero(t, registry.Snapshot().Wrappers[0].InFlight)
+
+	require.NoError(t, source.Close())
+	require.Empty(t, registry.Snapshot().Wrappers)
+}
+
+func TestAsyncMessageSink(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	registry := debug.NewRegistry()
+	// The first and the last messages are never acknowledged.
+	backend := mock.NewSink().AckWith(func(n int, _ substrate.Message) bool {
+		return n == 2
+	})
+	sink := debug.NewAsyncMessageSink(backend, "archive", debug.WithRegistry(registry))
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	msgs := []substrate.Message{
+		message.NewEnvelopedMessage(message.Envelope{Payload: []byte("1"), Headers: map[string]string{"id": "1"}}),
+		message.FromString("2"),
+		message.FromString("3"),
+	}
+	for _, msg := range msgs {
+		messages <- msg
+	}
+	require.Equal(t, msgs[1], <-acks)
+	require.Eventually(t, func() bool {
+		return len(backend.Published()) == 3
+	}, time.Second, time.Millisecond*10)
+
+	wrapper := registry.Snapshot().Wrappers[0]
+	require.Equal(t, debug.KindSink, wrapper.Kind)
+	require.Equal(t, 2, wrapper.InFlight)
+	require.Equal(t, map[string]string{"id": "1"}, wrapper.Messages[0].Headers)
+	require.Nil(t, wrapper.Messages[1].Headers)
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestSnapshot_String(t *testing.T) {
+	snapshot := debug.Snapshot{
+		Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Wrappers: []debug.Wrapper{
+			{
+				Kind:     debug.KindSource,
+				Name:     "orders",
+				InFlight: 3,
+				Messages: []debug.Message{
+					{Age: time.Minute + time.Second, Size: 12, ContentType: "application/json", Headers: map[string]string{"b": "2", "a": "1"}},
+					{Age: time.Second, Size: 4},
+				},
+			},
+			{Kind: debug.KindSink, Name: "archive", Messages: []debug.Message{}},
+		},
+	}
+
+	expected := `substrate snapshot at 2024-01-02T03:04:05Z
+
+source orders [3 in flight, oldest 1m1s]:
+	message 1: age 1m1s, 12 bytes, content type "application/json", headers {a="1", b="2"}
+	message 2: age 1s, 4 bytes
+	... 1 more
+
+sink archive [idle]:
+`
+	require.Equal(t, expected, snapshot.String())
+}
+
+func TestHandler(t *testing.T) {
+	registry := debug.NewRegistry()
+	source := debug.NewAsyncMessageSource(&mock.AsyncMessageSource{}, "orders", debug.WithRegistry(registry))
+	defer source.Close()
+	server := httptest.NewServer(debug.Handler(registry))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	resp, err = http.Get(server.URL + "?format=json")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var snapshot debug.Snapshot
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&snapshot))
+	require.Equal(t, []debug.Wrapper{{Kind: debug.KindSource, Name: "orders", Messages: []debug.Message{}}}, snapshot.Wrappers)
+
+	resp, err = http.Get(server.URL + "?format=xml")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Post(server.URL, "text/plain", strings.NewReader(""))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+// syncBuffer is a buffer safe for concurrent use.
+type syncBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+func TestDumpOnSignal(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	// The signal is also relayed to the test, so that it never terminates the process.
+	relayed := make(chan os.Signal, 1)
+	signal.Notify(relayed, os.Interrupt)
+	defer signal.Stop(relayed)
+
+	registry := debug.NewRegistry()
+	source := debug.NewAsyncMessageSource(&mock.AsyncMessageSource{}, "orders", debug.WithRegistry(registry))
+	defer source.Close()
+
+	out := &syncBuffer{}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- debug.DumpOnSignal(ctx, registry, out, os.Interrupt)
+	}()
+
+	process, err := os.FindProcess(os.Getpid())
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		require.NoError(t, process.Signal(os.Interrupt))
+		return strings.Contains(out.String(), "source orders [idle]:")
+	}, time.Second, time.Millisecond*10)
+
+	cancel()
+	require.NoError(t, <-errs)
+}
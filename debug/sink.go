@@ -0,0 +1,83 @@
+package debug
+
+import (
+	"context"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that tracks the messages published to the
+// underlying sink until it acknowledges them, adding them under the name to the snapshots of its registry. Messages
+// and acknowledgements are passed on unchanged. The sink is removed from the registry once closed.
+func NewAsyncMessageSink(sink substrate.AsyncMessageSink, name string, opts ...Option) substrate.AsyncMessageSink {
+	return &debugSink{
+		sink:    sink,
+		tracker: newTracker(KindSink, name, opts),
+	}
+}
+
+type debugSink struct {
+	sink    substrate.AsyncMessageSink
+	tracker *tracker
+}
+
+func (s *debugSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "debug"})
+	defer s.tracker.reset()
+
+	rg, ctx := rungroup.New(ctx)
+
+	sinkMsgs := make(chan substrate.Message, cap(messages))
+	sinkAcks := make(chan substrate.Message, cap(acks))
+
+	rg.Go(func() error {
+		return s.sink.PublishMessages(ctx, sinkAcks, sinkMsgs)
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-messages:
+				emit.Publish(msg)
+				s.tracker.add(msg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case sinkMsgs <- msg:
+				}
+			}
+		}
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-sinkAcks:
+				s.tracker.remove(ack)
+				emit.Ack(ack)
+				select {
+				case <-ctx.Done():
+					return nil
+				case acks <- ack:
+				}
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+// Close removes the sink from its registry and closes the underlying sink.
+func (s *debugSink) Close() error {
+	s.tracker.close()
+	return s.sink.Close()
+}
+
+// Status returns the status of the underlying sink.
+func (s *debugSink) Status() (*substrate.Status, error) {
+	return s.sink.Status()
+}
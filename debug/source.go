@@ -0,0 +1,83 @@
+package debug
+
+import (
+	"context"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that tracks the messages delivered by
+// the underlying source until they are acknowledged, adding them under the name to the snapshots of its registry.
+// Messages and acknowledgements are passed on unchanged. The source is removed from the registry once closed.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, name string, opts ...Option) substrate.AsyncMessageSource {
+	return &debugSource{
+		source:  source,
+		tracker: newTracker(KindSource, name, opts),
+	}
+}
+
+type debugSource struct {
+	source  substrate.AsyncMessageSource
+	tracker *tracker
+}
+
+func (s *debugSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "debug"})
+	defer s.tracker.reset()
+
+	rg, ctx := rungroup.New(ctx)
+
+	sourceMsgs := make(chan substrate.Message, cap(messages))
+	sourceAcks := make(chan substrate.Message, cap(acks))
+
+	rg.Go(func() error {
+		return s.source.ConsumeMessages(ctx, sourceMsgs, sourceAcks)
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-sourceMsgs:
+				s.tracker.add(msg)
+				emit.Publish(msg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case messages <- msg:
+				}
+			}
+		}
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				emit.Ack(ack)
+				s.tracker.remove(ack)
+				select {
+				case <-ctx.Done():
+					return nil
+				case sourceAcks <- ack:
+				}
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+// Close removes the source from its registry and closes the underlying source.
+func (s *debugSource) Close() error {
+	s.tracker.close()
+	return s.source.Close()
+}
+
+// Status returns the status of the underlying source.
+func (s *debugSource) Status() (*substrate.Status, error) {
+	return s.source.Status()
+}
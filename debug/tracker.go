@@ -0,0 +1,130 @@
+package debug
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+// Option is a function which sets a configuration option of the debug wrappers.
+type Option func(t *tracker)
+
+// WithRegistry sets the registry the wrapper is added to. The default is DefaultRegistry.
+func WithRegistry(r *Registry) Option {
+	return func(t *tracker) {
+		t.registry = r
+	}
+}
+
+// tracker records when the messages in flight through a wrapper entered it. Messages are identified by their
+// identity, so messages of types that aren't comparable aren't tracked.
+type tracker struct {
+	kind     string
+	name     string
+	registry *Registry
+
+	mutex    sync.Mutex
+	inFlight map[substrate.Message][]time.Time
+}
+
+func newTracker(kind, name string, opts []Option) *tracker {
+	t := &tracker{
+		kind:     kind,
+		name:     name,
+		registry: DefaultRegistry,
+		inFlight: make(map[substrate.Message][]time.Time),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	t.registry.add(t)
+	return t
+}
+
+// add records that the message entered the wrapper.
+func (t *tracker) add(msg substrate.Message) {
+	if !trackable(msg) {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.inFlight[msg] = append(t.inFlight[msg], time.Now())
+}
+
+// remove records that the message was acknowledged, forgetting its oldest entry.
+func (t *tracker) remove(msg substrate.Message) {
+	if !trackable(msg) {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	switch entries := t.inFlight[msg]; len(entries) {
+	case 0:
+	case 1:
+		delete(t.inFlight, msg)
+	default:
+		t.inFlight[msg] = entries[1:]
+	}
+}
+
+// reset forgets all messages, which are no longer in flight once publishing or consuming stopped.
+func (t *tracker) reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.inFlight = make(map[substrate.Message][]time.Time)
+}
+
+// close removes the wrapper from its registry.
+func (t *tracker) close() {
+	t.registry.remove(t)
+}
+
+// trackedMessage is a message in flight along with when it entered the wrapper.
+type trackedMessage struct {
+	msg   substrate.Message
+	since time.Time
+}
+
+func (t *tracker) snapshot(now time.Time, maxMessages int) Wrapper {
+	t.mutex.Lock()
+	var messages []trackedMessage
+	for msg, entries := range t.inFlight {
+		for _, since := range entries {
+			messages = append(messages, trackedMessage{msg: msg, since: since})
+		}
+	}
+	t.mutex.Unlock()
+
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].since.Before(messages[j].since)
+	})
+	w := Wrapper{Kind: t.kind, Name: t.name, InFlight: len(messages), Messages: []Message{}}
+	for i := 0; i < len(messages) && i < maxMessages; i++ {
+		w.Messages = append(w.Messages, snapshotMessage(messages[i], now))
+	}
+	return w
+}
+
+// snapshotMessage returns the metadata of a message in flight.
+func snapshotMessage(tm trackedMessage, now time.Time) Message {
+	msg := Message{
+		Age:         now.Sub(tm.since),
+		Size:        len(tm.msg.Data()),
+		ContentType: message.ContentType(tm.msg),
+	}
+	if headers := message.Headers(tm.msg); len(headers) > 0 {
+		msg.Headers = headers
+	}
+	return msg
+}
+
+func trackable(msg substrate.Message) bool {
+	return msg != nil && reflect.TypeOf(msg).Comparable()
+}
@@ -0,0 +1,205 @@
+// Package dedup provides a message source wrapper that drops messages that were already processed.
+package dedup
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/ackordering"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+const (
+	defaultTTL       = time.Hour
+	defaultStoreSize = 10000
+)
+
+// KeyFunc is a function that extracts the deduplication key from a message. It is called with the messages of the
+// underlying source, so that keys can be based on their headers or envelope. Messages for which an empty key is
+// returned are never dropped.
+type KeyFunc func(msg substrate.Message) string
+
+// AsyncMessageSourceOption is a function which sets an AsyncMessageSource configuration option.
+type AsyncMessageSourceOption func(s *dedupSource)
+
+// WithTTL sets how long the key of a processed message is remembered for. The default value is 1 hour.
+func WithTTL(ttl time.Duration) AsyncMessageSourceOption {
+	return func(s *dedupSource) {
+		s.ttl = ttl
+	}
+}
+
+// WithStore sets the store used to remember the keys of processed messages. The default is an
+// in-memory LRU store holding 10000 keys.
+func WithStore(store Store) AsyncMessageSourceOption {
+	return func(s *dedupSource) {
+		s.store = store
+	}
+}
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that drops messages with
+// a key that was already processed within the TTL. Dropped messages are acknowledged automatically, in
+// order with the acknowledgements of other messages. The key of a message is only remembered once the
+// message has been acknowledged, so that messages which failed to be processed are not lost on redelivery.
+// Duplicates of a message that wasn't acknowledged yet are dropped too, and acknowledged once it is.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, key KeyFunc, opts ...AsyncMessageSourceOption) substrate.AsyncMessageSource {
+	s := &dedupSource{
+		source: ackordering.NewAsyncMessageSource(source),
+		key:    key,
+		ttl:    defaultTTL,
+		store:  NewLRUStore(defaultStoreSize),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type dedupSource struct {
+	source substrate.AsyncMessageSource
+	key    KeyFunc
+	ttl    time.Duration
+	store  Store
+}
+
+func (s *dedupSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "dedup"})
+	rg, ctx := rungroup.New(ctx)
+
+	sourceMsgs := make(chan substrate.Message, cap(messages))
+	sourceAcks := make(chan substrate.Message, cap(acks))
+
+	rg.Go(func() error {
+		return s.source.ConsumeMessages(ctx, sourceMsgs, sourceAcks)
+	})
+	// pending holds the keys of the messages delivered but not acknowledged yet, with the duplicates of the messages
+	// consumed since, which are acknowledged once the message is.
+	var (
+		mutex   sync.Mutex
+		pending = make(map[string][]substrate.Message)
+	)
+
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-sourceMsgs:
+				// The key is extracted from the message of the underlying source, which carries its headers.
+				key := s.key(ackordering.Unwrap(msg))
+				if key != "" {
+					mutex.Lock()
+					duplicates, isPending := pending[key]
+					if isPending {
+						pending[key] = append(duplicates, msg)
+					}
+					mutex.Unlock()
+					if isPending {
+						continue
+					}
+
+					seen, err := s.store.Contains(ctx, key)
+					if err != nil {
+						return errors.Wrap(err, "failed to check deduplication store")
+					}
+					if seen {
+						select {
+						case <-ctx.Done():
+							return nil
+						case sourceAcks <- msg:
+						}
+						continue
+					}
+
+					mutex.Lock()
+					pending[key] = nil
+					mutex.Unlock()
+				}
+
+				dMsg := &dedupMessage{msg: msg, key: key}
+				emit.Publish(dMsg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case messages <- dMsg:
+				}
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				msg, ok := ack.(*dedupMessage)
+				if !ok {
+					return errors.Errorf("unexpected message type: %T", ack)
+				}
+				emit.Ack(ack)
+				var duplicates []substrate.Message
+				if msg.key != "" {
+					if err := s.store.Add(ctx, msg.key, s.ttl); err != nil {
+						return errors.Wrap(err, "failed to update deduplication store")
+					}
+					// The key is removed from the pending ones once it is in the store, so that later duplicates
+					// are either added to the pending ones or found in the store.
+					mutex.Lock()
+					duplicates = pending[msg.key]
+					delete(pending, msg.key)
+					mutex.Unlock()
+				}
+				for _, sourceMsg := range append([]substrate.Message{msg.msg}, duplicates...) {
+					select {
+					case <-ctx.Done():
+						return nil
+					case sourceAcks <- sourceMsg:
+					}
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+func (s *dedupSource) Close() error {
+	return s.source.Close()
+}
+
+func (s *dedupSource) Status() (*substrate.Status, error) {
+	return s.source.Status()
+}
+
+type dedupMessage struct {
+	msg substrate.Message
+	key string
+}
+
+func (msg *dedupMessage) Data() []byte {
+	return msg.msg.Data()
+}
+
+func (msg *dedupMessage) Headers() map[string]string {
+	return message.Headers(msg.msg)
+}
+
+func (msg *dedupMessage) ContentType() string {
+	return message.ContentType(msg.msg)
+}
+
+func (msg *dedupMessage) DiscardPayload() {
+	if dMsg, ok := msg.msg.(substrate.DiscardableMessage); ok {
+		dMsg.DiscardPayload()
+	}
+}
+
+func (msg *dedupMessage) CarriedEnvelope() (message.Envelope, bool) {
+	return message.EnvelopeOf(msg.msg)
+}
@@ -0,0 +1,119 @@
+package dedup_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/dedup"
+	"github.com/uw-labs/substrate-tools/idempotent"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+func TestDedupMessageSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			message.FromString("a"),
+			message.FromString("b"),
+			message.FromString("a"),
+			message.FromString("c"),
+		},
+	}
+	source := dedup.NewAsyncMessageSource(mockSource, func(msg substrate.Message) string {
+		return string(msg.Data())
+	})
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	var consumed []string
+	for i := 0; i < 3; i++ {
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "failed to consume all messages")
+		case msg := <-messages:
+			consumed = append(consumed, string(msg.Data()))
+			acks <- msg
+		}
+	}
+	require.Equal(t, []string{"a", "b", "c"}, consumed)
+
+	// The mock source terminates with an error if acknowledgements are out of order.
+	require.NoError(t, source.Close())
+	require.NoError(t, <-errs)
+}
+
+func TestDedupMessageSource_PendingDuplicate(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{message.FromString("a"), message.FromString("a"), message.FromString("b")},
+	}
+	source := dedup.NewAsyncMessageSource(mockSource, func(msg substrate.Message) string {
+		return string(msg.Data())
+	})
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	// The duplicate is dropped although the first message wasn't acknowledged yet, and acknowledged with it.
+	first := <-messages
+	require.Equal(t, "a", string(first.Data()))
+	second := <-messages
+	require.Equal(t, "b", string(second.Data()))
+	acks <- first
+	acks <- second
+
+	// The mock source terminates with an error if acknowledgements are out of order.
+	require.NoError(t, source.Close())
+	require.NoError(t, <-errs)
+}
+
+func TestDedupMessageSource_HeaderKey(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	headered := func(payload, sequence string) substrate.Message {
+		return message.NewEnvelopedMessage(message.Envelope{
+			Payload: []byte(payload),
+			Headers: map[string]string{idempotent.ProducerIDHeader: "producer", idempotent.SequenceHeader: sequence},
+		})
+	}
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{headered("a", "1"), headered("a again", "1"), headered("b", "2")},
+	}
+	source := dedup.NewAsyncMessageSource(mockSource, idempotent.Key)
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	first := <-messages
+	require.Equal(t, "a", string(first.Data()))
+	require.Equal(t, "1", message.Headers(first)[idempotent.SequenceHeader])
+	acks <- first
+	second := <-messages
+	require.Equal(t, "b", string(second.Data()))
+	acks <- second
+
+	// The mock source terminates with an error if acknowledgements are out of order.
+	require.NoError(t, source.Close())
+	require.NoError(t, <-errs)
+}
@@ -0,0 +1,78 @@
+package dedup
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Store keeps track of the keys of messages that were processed. Implementations backed by external
+// storage, such as Redis, allow deduplication across multiple instances of a consumer.
+type Store interface {
+	// Contains returns whether the key was added to the store and hasn't expired yet.
+	Contains(ctx context.Context, key string) (bool, error)
+	// Add adds the key to the store, it should be expired after the provided TTL.
+	Add(ctx context.Context, key string, ttl time.Duration) error
+}
+
+// NewLRUStore returns an in-memory store that keeps at most the given number of keys, evicting the least
+// recently added keys once it's full.
+func NewLRUStore(size int) Store {
+	return &lruStore{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+type lruEntry struct {
+	key     string
+	expires time.Time
+}
+
+type lruStore struct {
+	mutex   sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+	now     func() time.Time
+}
+
+func (s *lruStore) Contains(_ context.Context, key string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	elem, ok := s.entries[key]
+	if !ok {
+		return false, nil
+	}
+	if s.now().After(elem.Value.(*lruEntry).expires) {
+		s.remove(elem)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (s *lruStore) Add(_ context.Context, key string, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if elem, ok := s.entries[key]; ok {
+		s.remove(elem)
+	}
+	s.entries[key] = s.order.PushFront(&lruEntry{
+		key:     key,
+		expires: s.now().Add(ttl),
+	})
+	for s.order.Len() > s.size {
+		s.remove(s.order.Back())
+	}
+	return nil
+}
+
+func (s *lruStore) remove(elem *list.Element) {
+	s.order.Remove(elem)
+	delete(s.entries, elem.Value.(*lruEntry).key)
+}
@@ -0,0 +1,40 @@
+package dedup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRUStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	store := NewLRUStore(2).(*lruStore)
+	store.now = func() time.Time { return now }
+
+	require.NoError(t, store.Add(ctx, "a", time.Minute))
+	require.NoError(t, store.Add(ctx, "b", time.Hour))
+
+	seen, err := store.Contains(ctx, "a")
+	require.NoError(t, err)
+	require.True(t, seen)
+
+	// Expired keys are not reported as seen.
+	now = now.Add(time.Minute * 2)
+	seen, err = store.Contains(ctx, "a")
+	require.NoError(t, err)
+	require.False(t, seen)
+
+	// The least recently added key is evicted once the store is full.
+	require.NoError(t, store.Add(ctx, "c", time.Hour))
+	require.NoError(t, store.Add(ctx, "d", time.Hour))
+	seen, err = store.Contains(ctx, "b")
+	require.NoError(t, err)
+	require.False(t, seen)
+	seen, err = store.Contains(ctx, "d")
+	require.NoError(t, err)
+	require.True(t, seen)
+}
@@ -0,0 +1,312 @@
+// Package delay provides a message sink wrapper that holds messages until their delay elapsed before publishing
+// them, for retries after a backoff or scheduled events on backends without native delayed delivery.
+package delay
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/internal/inorder"
+	"github.com/uw-labs/substrate-tools/internal/wal"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// DelayFunc returns how long the message is held before it is published. A message with a delay that isn't
+// positive is published straight away. To schedule a message for a given time, return the time until then.
+type DelayFunc func(msg substrate.Message) time.Duration
+
+// SinkOption is a function which sets a delay sink configuration option.
+type SinkOption func(s *delaySink)
+
+// WithDelayFunc sets a function returning the delay of each message, instead of the fixed delay.
+func WithDelayFunc(f DelayFunc) SinkOption {
+	return func(s *delaySink) {
+		s.delayFunc = f
+	}
+}
+
+// WithMaxHeld sets the maximum number of messages held by the sink. Once reached, no more messages are received
+// until the next one is published. The default value is 0 (unlimited).
+func WithMaxHeld(max int) SinkOption {
+	return func(s *delaySink) {
+		s.maxHeld = max
+	}
+}
+
+// WithDurableDir makes the sink append the messages, along with the time they are due, to a write-ahead log in
+// the directory and acknowledge them once written, instead of once they were published. Messages that weren't
+// published yet are held again after a restart, which makes delays longer than the lifetime of the process safe.
+func WithDurableDir(dir string) SinkOption {
+	return func(s *delaySink) {
+		s.dir = dir
+	}
+}
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that holds every message for the delay,
+// or the delay returned for it if configured to, before publishing it to the underlying sink. Messages are
+// published in the order in which they are due and acknowledged once the underlying sink acknowledged them, in
+// the order in which they were published to this sink. It returns an error if the durable log can't be opened.
+func NewAsyncMessageSink(sink substrate.AsyncMessageSink, delay time.Duration, opts ...SinkOption) (substrate.AsyncMessageSink, error) {
+	s := &delaySink{
+		sink: sink,
+		delayFunc: func(substrate.Message) time.Duration {
+			return delay
+		},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.dir != "" {
+		log, err := wal.Open(s.dir, "delay", 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		s.log = log
+	}
+	return s, nil
+}
+
+type delaySink struct {
+	sink      substrate.AsyncMessageSink
+	delayFunc DelayFunc
+	maxHeld   int
+	dir       string
+	log       *wal.Log
+}
+
+// record is a message stored in the write-ahead log.
+type record struct {
+	Data    []byte            `json:"data"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Due     time.Time         `json:"due"`
+}
+
+// delayedMessage is a message held until it is due. It is either the message published by the user, or one read
+// from the log, which ends at the given offset.
+type delayedMessage struct {
+	data     []byte
+	headers  map[string]string
+	due      time.Time
+	original substrate.Message
+	end      int64
+	seq      int
+}
+
+func (msg *delayedMessage) Data() []byte {
+	return msg.data
+}
+
+func (msg *delayedMessage) Headers() map[string]string {
+	return msg.headers
+}
+
+// heldMessages is a heap of messages ordered by the time they are due, and by the order in which they were
+// received for messages due at the same time.
+type heldMessages []*delayedMessage
+
+func (h heldMessages) Len() int { return len(h) }
+
+func (h heldMessages) Less(i, j int) bool {
+	if h[i].due.Equal(h[j].due) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].due.Before(h[j].due)
+}
+
+func (h heldMessages) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *heldMessages) Push(x interface{}) { *h = append(*h, x.(*delayedMessage)) }
+
+func (h *heldMessages) Pop() interface{} {
+	old := *h
+	msg := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return msg
+}
+
+func (s *delaySink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "delay"})
+	rg, ctx := rungroup.New(ctx)
+
+	toSink, sinkAcks := make(chan substrate.Message), make(chan substrate.Message)
+
+	rg.Go(func() error {
+		return s.sink.PublishMessages(ctx, sinkAcks, toSink)
+	})
+	rg.Go(func() error {
+		return emit.Error(s.run(ctx, emit, acks, messages, toSink, sinkAcks))
+	})
+
+	return rg.Wait()
+}
+
+// run holds the messages until they are due and passes on the acknowledgements of the underlying sink.
+func (s *delaySink) run(
+	ctx context.Context,
+	emit events.Emitter,
+	acks chan<- substrate.Message,
+	messages <-chan substrate.Message,
+	toSink chan<- substrate.Message,
+	sinkAcks <-chan substrate.Message,
+) error {
+	var (
+		held  heldMessages
+		seq   int
+		timer *time.Timer
+		timed *delayedMessage
+	)
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	// Messages are published in the order they are due rather than the order they were received in, so every
+	// message has its own lane.
+	queue := inorder.NewQueue[*delayedMessage, *delayedMessage]()
+	hold := func(dMsg *delayedMessage) {
+		dMsg.seq = seq
+		seq++
+		queue.Push(dMsg.seq, dMsg, dMsg)
+		heap.Push(&held, dMsg)
+	}
+
+	if s.log != nil {
+		s.log.Rewind()
+		for {
+			e, err := s.log.Next()
+			if err != nil {
+				return err
+			}
+			if e == nil {
+				break
+			}
+			var rec record
+			if err := json.Unmarshal(e.Body, &rec); err != nil {
+				return errors.Wrap(err, "failed to decode delayed message")
+			}
+			hold(&delayedMessage{data: rec.Data, headers: rec.Headers, due: rec.Due, end: e.End})
+		}
+	}
+
+	for {
+		var (
+			in       = messages
+			out      chan<- substrate.Message
+			next     *delayedMessage
+			deadline <-chan time.Time
+		)
+		if s.maxHeld > 0 && len(held) >= s.maxHeld {
+			in = nil
+		}
+		if len(held) > 0 {
+			if head := held[0]; !head.due.After(time.Now()) {
+				out, next = toSink, head
+			} else {
+				if head != timed {
+					if timer != nil {
+						timer.Stop()
+					}
+					timer, timed = time.NewTimer(time.Until(head.due)), head
+				}
+				deadline = timer.C
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-in:
+			emit.Publish(msg)
+			dMsg := &delayedMessage{
+				data:    msg.Data(),
+				headers: message.Headers(msg),
+				due:     time.Now().Add(s.delayFunc(msg)),
+			}
+			if s.log == nil {
+				dMsg.original = msg
+				hold(dMsg)
+				continue
+			}
+			body, err := json.Marshal(record{Data: dMsg.data, Headers: dMsg.headers, Due: dMsg.due})
+			if err != nil {
+				return errors.Wrap(err, "failed to encode delayed message")
+			}
+			if dMsg.end, err = s.log.Append(body); err != nil {
+				return err
+			}
+			hold(dMsg)
+			emit.Ack(msg)
+			select {
+			case <-ctx.Done():
+				return nil
+			case acks <- msg:
+			}
+		case out <- next:
+			heap.Pop(&held)
+		case <-deadline:
+			timer, timed = nil, nil
+		case ack := <-sinkAcks:
+			dMsg, ok := ack.(*delayedMessage)
+			if !ok {
+				return errors.Errorf("unexpected message type: %T", ack)
+			}
+			released, ok := queue.Complete(dMsg.seq, dMsg)
+			if !ok {
+				return errors.Errorf("unexpected message acknowledged: %v", ack)
+			}
+			if err := s.release(ctx, emit, acks, released); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// release acknowledges the published messages, or commits them to the log if the sink is durable.
+func (s *delaySink) release(ctx context.Context, emit events.Emitter, acks chan<- substrate.Message, released []*delayedMessage) error {
+	if len(released) == 0 {
+		return nil
+	}
+	if s.log != nil {
+		if err := s.log.Commit(released[len(released)-1].end); err != nil {
+			return err
+		}
+		if s.log.Drained() {
+			return s.log.Reset()
+		}
+		return nil
+	}
+	for _, dMsg := range released {
+		emit.Ack(dMsg.original)
+		select {
+		case <-ctx.Done():
+			return nil
+		case acks <- dMsg.original:
+		}
+	}
+	return nil
+}
+
+// Close closes the underlying sink and the log, if the sink is durable, and returns all errors encountered.
+func (s *delaySink) Close() (err error) {
+	err = multierror.Append(err, s.sink.Close()).ErrorOrNil()
+	if s.log != nil {
+		err = multierror.Append(err, s.log.Close()).ErrorOrNil()
+	}
+	return err
+}
+
+// Status returns the status of the underlying sink.
+func (s *delaySink) Status() (*substrate.Status, error) {
+	return s.sink.Status()
+}
@@ -0,0 +1,150 @@
+package delay_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/conformance"
+	"github.com/uw-labs/substrate-tools/delay"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+func payloads(broker *mem.Broker) []string {
+	var published []string
+	for _, data := range broker.Messages("topic") {
+		published = append(published, string(data))
+	}
+	return published
+}
+
+// start publishes messages to the sink in the background, returning the channels of the call and its error.
+func start(ctx context.Context, sink substrate.AsyncMessageSink) (chan<- substrate.Message, <-chan substrate.Message, <-chan error) {
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+	return messages, acks, errs
+}
+
+func TestSink_FixedDelay(t *testing.T) {
+	broker := mem.NewBroker()
+	sink, err := delay.NewAsyncMessageSink(broker.NewAsyncMessageSink("topic"), time.Millisecond*50)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	messages, acks, errs := start(ctx, sink)
+
+	published := time.Now()
+	for _, payload := range []string{"a", "b"} {
+		messages <- message.FromString(payload)
+	}
+	for _, payload := range []string{"a", "b"} {
+		require.Equal(t, payload, string((<-acks).Data()))
+	}
+	require.GreaterOrEqual(t, time.Since(published), time.Millisecond*50)
+	require.Equal(t, []string{"a", "b"}, payloads(broker))
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestSink_DelayFunc(t *testing.T) {
+	broker := mem.NewBroker()
+	sink, err := delay.NewAsyncMessageSink(broker.NewAsyncMessageSink("topic"), 0,
+		delay.WithDelayFunc(func(msg substrate.Message) time.Duration {
+			if strings.HasPrefix(string(msg.Data()), "later") {
+				return time.Millisecond * 50
+			}
+			return 0
+		}),
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	messages, acks, errs := start(ctx, sink)
+
+	for _, payload := range []string{"later-1", "now-1", "later-2", "now-2"} {
+		messages <- message.FromString(payload)
+	}
+	// acknowledgements are in the order messages were published to the sink, regardless of their delay
+	for _, payload := range []string{"later-1", "now-1", "later-2", "now-2"} {
+		require.Equal(t, payload, string((<-acks).Data()))
+	}
+	require.Equal(t, []string{"now-1", "now-2", "later-1", "later-2"}, payloads(broker))
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestSink_Durable(t *testing.T) {
+	dir := t.TempDir()
+	broker := mem.NewBroker()
+	sink, err := delay.NewAsyncMessageSink(broker.NewAsyncMessageSink("topic"), time.Millisecond*100, delay.WithDurableDir(dir))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	messages, acks, errs := start(ctx, sink)
+
+	// messages are acknowledged once written to the log, before they are due
+	messages <- message.FromString("a")
+	require.Equal(t, "a", string((<-acks).Data()))
+	require.Empty(t, payloads(broker))
+
+	cancel()
+	require.NoError(t, <-errs)
+	require.NoError(t, sink.Close())
+
+	sink, err = delay.NewAsyncMessageSink(broker.NewAsyncMessageSink("topic"), time.Hour, delay.WithDurableDir(dir))
+	require.NoError(t, err)
+	defer sink.Close()
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	_, _, errs = start(ctx, sink)
+
+	// the message is due at the time it was published with, not after the delay of the new sink
+	require.Eventually(t, func() bool {
+		return len(payloads(broker)) == 1
+	}, time.Second, time.Millisecond*10)
+	require.Equal(t, []string{"a"}, payloads(broker))
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestSink_MaxHeld(t *testing.T) {
+	broker := mem.NewBroker()
+	sink, err := delay.NewAsyncMessageSink(broker.NewAsyncMessageSink("topic"), time.Hour, delay.WithMaxHeld(1))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	messages, _, errs := start(ctx, sink)
+
+	messages <- message.FromString("a")
+	select {
+	case messages <- message.FromString("b"):
+		require.FailNow(t, "message received while the sink was full")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestSink_Conformance(t *testing.T) {
+	conformance.TestAsyncSink(t, func(t *testing.T, backend substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+		sink, err := delay.NewAsyncMessageSink(backend, time.Millisecond)
+		require.NoError(t, err)
+		return sink
+	})
+}
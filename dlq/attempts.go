@@ -0,0 +1,118 @@
+package dlq
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// AttemptStore keeps track of the number of times each message was delivered. Implementations backed by
+// persistent storage allow counts to survive restarts of the consumer, and to be shared by its instances.
+type AttemptStore interface {
+	// Increment increments the number of delivery attempts of the message with the key and returns it.
+	Increment(ctx context.Context, key string) (int, error)
+	// Reset forgets the delivery attempts of the message with the key.
+	Reset(ctx context.Context, key string) error
+}
+
+// NewMemoryAttemptStore returns an in-memory store, which only counts deliveries within the same process.
+func NewMemoryAttemptStore() AttemptStore {
+	return &memoryAttemptStore{
+		attempts: make(map[string]int),
+	}
+}
+
+type memoryAttemptStore struct {
+	mutex    sync.Mutex
+	attempts map[string]int
+}
+
+func (s *memoryAttemptStore) Increment(_ context.Context, key string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.attempts[key]++
+	return s.attempts[key], nil
+}
+
+func (s *memoryAttemptStore) Reset(_ context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+// NewFileAttemptStore returns a store that keeps the number of delivery attempts of each message in a file in
+// the directory. Files are replaced atomically, so that a crash never leaves a partially written count behind.
+func NewFileAttemptStore(dir string) (AttemptStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "failed to create store directory")
+	}
+	return &fileAttemptStore{dir: dir}, nil
+}
+
+type fileAttemptStore struct {
+	mutex sync.Mutex
+	dir   string
+}
+
+func (s *fileAttemptStore) Increment(_ context.Context, key string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	attempts := 0
+	data, err := os.ReadFile(s.path(key))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return 0, errors.Wrap(err, "failed to read delivery attempts")
+	default:
+		if attempts, err = strconv.Atoi(strings.TrimSpace(string(data))); err != nil {
+			return 0, errors.Wrap(err, "failed to parse delivery attempts")
+		}
+	}
+	attempts++
+
+	tmp, err := os.CreateTemp(s.dir, ".attempts-*")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to write delivery attempts")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(strconv.Itoa(attempts)); err != nil {
+		tmp.Close()
+		return 0, errors.Wrap(err, "failed to write delivery attempts")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, errors.Wrap(err, "failed to write delivery attempts")
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, errors.Wrap(err, "failed to write delivery attempts")
+	}
+	if err := os.Rename(tmp.Name(), s.path(key)); err != nil {
+		return 0, errors.Wrap(err, "failed to write delivery attempts")
+	}
+	return attempts, nil
+}
+
+func (s *fileAttemptStore) Reset(_ context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to reset delivery attempts")
+	}
+	return nil
+}
+
+func (s *fileAttemptStore) path(key string) string {
+	return filepath.Join(s.dir, url.PathEscape(key)+".attempts")
+}
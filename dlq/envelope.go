@@ -0,0 +1,31 @@
+package dlq
+
+import (
+	"encoding/json"
+)
+
+// Failure describes a message that failed to be processed.
+type Failure struct {
+	// Payload is the payload of the original message.
+	Payload []byte `json:"payload"`
+	// Headers are the headers of the original message, if it carried any.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Reason is the error reported for the last processing attempt.
+	Reason string `json:"reason"`
+	// Attempts is the number of times the message was delivered before being dead-lettered.
+	Attempts int `json:"attempts"`
+}
+
+// Envelope is a function that encodes a failure into the payload of the message published to the
+// dead-letter sink.
+type Envelope func(failure Failure) ([]byte, error)
+
+// JSONEnvelope encodes the failure as a JSON object.
+func JSONEnvelope(failure Failure) ([]byte, error) {
+	return json.Marshal(failure)
+}
+
+// RawEnvelope publishes the original payload without any failure metadata.
+func RawEnvelope(failure Failure) ([]byte, error) {
+	return failure.Payload, nil
+}
@@ -0,0 +1,248 @@
+package dlq
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/health"
+	"github.com/uw-labs/substrate-tools/internal/inorder"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+const defaultMaxDeliveries = 5
+
+// Delivered and dead-lettered messages are acknowledged independently of each other, so they are matched with
+// their acknowledgements in separate lanes.
+const (
+	deliveredLane = iota
+	deadLetterLane
+)
+
+// KeyFunc is a function that extracts the key identifying a message across deliveries, such as its ID.
+// Deliveries of messages for which an empty key is returned aren't tracked.
+type KeyFunc func(msg substrate.Message) string
+
+// EnvelopeID returns the ID of the envelope carried by messages, such as the messages consumed from the envelope
+// source, see message.NewEnvelopeSource.
+func EnvelopeID(msg substrate.Message) string {
+	envelope, _ := message.EnvelopeOf(msg)
+	return envelope.ID
+}
+
+// RedeliverySourceOption is a function which sets a redelivery source configuration option.
+type RedeliverySourceOption func(s *redeliverySource)
+
+// WithMaxDeliveries sets the number of times a message is delivered before it is dead-lettered.
+// The default value is 5.
+func WithMaxDeliveries(deliveries int) RedeliverySourceOption {
+	return func(s *redeliverySource) {
+		s.maxDeliveries = deliveries
+	}
+}
+
+// WithRedeliveryEnvelope sets the envelope used to encode messages published to the dead-letter sink.
+// The default is JSONEnvelope.
+func WithRedeliveryEnvelope(envelope Envelope) RedeliverySourceOption {
+	return func(s *redeliverySource) {
+		s.envelope = envelope
+	}
+}
+
+// NewRedeliverySource returns an instance of substrate.AsyncMessageSource that counts the deliveries of every
+// message in the store, and publishes messages that were already delivered the maximum number of times to the
+// dead-letter sink instead of delivering them again. Unlike the source returned by NewAsyncMessageSource, which
+// counts the attempts of messages it redelivers itself, it counts the redeliveries made by the backend, e.g. of
+// messages which were never acknowledged because the consumer crashed. With a persistent store, a message that
+// keeps crashing the consumer is therefore dead-lettered eventually. The count of a message is reset once it is
+// acknowledged or dead-lettered. Acknowledgements are forwarded to the underlying source in the order in which
+// the messages were consumed. When Close is called, both the source and the dead-letter sink are closed.
+func NewRedeliverySource(source substrate.AsyncMessageSource, deadLetterSink substrate.AsyncMessageSink, store AttemptStore, key KeyFunc, opts ...RedeliverySourceOption) substrate.AsyncMessageSource {
+	s := &redeliverySource{
+		source:        source,
+		sink:          deadLetterSink,
+		store:         store,
+		key:           key,
+		maxDeliveries: defaultMaxDeliveries,
+		envelope:      JSONEnvelope,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type redeliverySource struct {
+	source        substrate.AsyncMessageSource
+	sink          substrate.AsyncMessageSink
+	store         AttemptStore
+	key           KeyFunc
+	maxDeliveries int
+	envelope      Envelope
+}
+
+func (s *redeliverySource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "dlq"})
+	rg, ctx := rungroup.New(ctx)
+
+	sourceMsgs := make(chan substrate.Message, cap(messages))
+	sourceAcks := make(chan substrate.Message, cap(acks))
+	deadLetters := make(chan substrate.Message)
+	deadLetterAcks := make(chan substrate.Message)
+	queue := inorder.NewQueue[substrate.Message, substrate.Message]()
+
+	rg.Go(func() error {
+		return s.source.ConsumeMessages(ctx, sourceMsgs, sourceAcks)
+	})
+	rg.Go(func() error {
+		return s.sink.PublishMessages(ctx, deadLetterAcks, deadLetters)
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-sourceMsgs:
+				var (
+					out    chan<- substrate.Message
+					toSend substrate.Message
+				)
+				key := s.key(msg)
+				attempts := 0
+				if key != "" {
+					var err error
+					if attempts, err = s.store.Increment(ctx, key); err != nil {
+						return errors.Wrap(err, "failed to count delivery attempt")
+					}
+				}
+				if attempts <= s.maxDeliveries {
+					toSend = &trackedMessage{msg: msg, key: key}
+					queue.Push(deliveredLane, toSend, msg)
+					out = messages
+					emit.Publish(toSend)
+				} else {
+					deadLetter, err := s.deadLetter(msg, key, attempts-1)
+					if err != nil {
+						return err
+					}
+					emit.Error(errors.Errorf("message dead-lettered after %d deliveries", attempts-1))
+					toSend = deadLetter
+					queue.Push(deadLetterLane, toSend, msg)
+					out = deadLetters
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case out <- toSend:
+				}
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			var (
+				lane int
+				ack  substrate.Message
+				key  string
+			)
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack = <-acks:
+				msg, ok := ack.(*trackedMessage)
+				if !ok {
+					return errors.Errorf("unexpected message type: %T", ack)
+				}
+				lane, key = deliveredLane, msg.key
+				emit.Ack(ack)
+			case ack = <-deadLetterAcks:
+				msg, ok := ack.(*deadLetterMessage)
+				if !ok {
+					return errors.Errorf("unexpected message type: %T", ack)
+				}
+				lane, key = deadLetterLane, msg.key
+			}
+
+			if key != "" {
+				if err := s.store.Reset(ctx, key); err != nil {
+					return errors.Wrap(err, "failed to reset delivery attempts")
+				}
+			}
+			released, ok := queue.Complete(lane, ack)
+			if !ok {
+				return errors.Errorf("unexpected message acknowledged: %v", ack)
+			}
+			for _, msg := range released {
+				select {
+				case <-ctx.Done():
+					return nil
+				case sourceAcks <- msg:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+func (s *redeliverySource) deadLetter(msg substrate.Message, key string, attempts int) (*deadLetterMessage, error) {
+	failure := Failure{
+		Payload:  msg.Data(),
+		Reason:   fmt.Sprintf("exceeded the maximum of %d deliveries", s.maxDeliveries),
+		Attempts: attempts,
+	}
+	if hMsg, ok := msg.(message.HeaderedMessage); ok {
+		failure.Headers = hMsg.Headers()
+	}
+
+	payload, err := s.envelope(failure)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode dead letter")
+	}
+
+	return &deadLetterMessage{
+		payload:  payload,
+		original: msg,
+		key:      key,
+	}, nil
+}
+
+// Close closes both the underlying source and the dead-letter sink and returns all errors encountered.
+func (s *redeliverySource) Close() (err error) {
+	err = multierror.Append(err, s.source.Close()).ErrorOrNil()
+	return multierror.Append(err, s.sink.Close()).ErrorOrNil()
+}
+
+// Status returns the status of the underlying source. It only reports working status if the
+// dead-letter sink does as well.
+func (s *redeliverySource) Status() (*substrate.Status, error) {
+	return health.Combine(s.source, health.Named("dead-letter sink", s.sink)).Status()
+}
+
+type trackedMessage struct {
+	msg substrate.Message
+	key string
+}
+
+func (msg *trackedMessage) Data() []byte {
+	return msg.msg.Data()
+}
+
+func (msg *trackedMessage) Headers() map[string]string {
+	return message.Headers(msg.msg)
+}
+
+func (msg *trackedMessage) DiscardPayload() {
+	if dMsg, ok := msg.msg.(substrate.DiscardableMessage); ok {
+		dMsg.DiscardPayload()
+	}
+}
+
+func (msg *trackedMessage) CarriedEnvelope() (message.Envelope, bool) {
+	return message.EnvelopeOf(msg.msg)
+}
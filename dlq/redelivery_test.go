@@ -0,0 +1,116 @@
+package dlq_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/dlq"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+func envelope(id, payload string) substrate.Message {
+	return message.NewEnvelopedMessage(message.Envelope{ID: id, Payload: []byte(payload)})
+}
+
+// deliver consumes the first message from a fresh redelivery source, without acknowledging it, as a consumer
+// crashing while processing it would.
+func deliver(t *testing.T, store dlq.AttemptStore, msg substrate.Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source := dlq.NewRedeliverySource(mock.NewSource(msg), mock.NewSink(), store, dlq.EnvelopeID, dlq.WithMaxDeliveries(2))
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+	select {
+	case consumed := <-messages:
+		require.Equal(t, msg.Data(), consumed.Data())
+	case err := <-errs:
+		require.FailNow(t, "consumption failed", "%v", err)
+	}
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestRedeliverySource(t *testing.T) {
+	store, err := dlq.NewFileAttemptStore(t.TempDir())
+	require.NoError(t, err)
+
+	// The poison message is delivered the maximum number of times, by successive consumers.
+	poison := envelope("poison", "p")
+	deliver(t, store, poison)
+	deliver(t, store, poison)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	mockSource := mock.NewSource(envelope("a", "1"), poison, message.FromString("2"))
+	deadLetterSink := mock.NewSink()
+	source := dlq.NewRedeliverySource(mockSource, deadLetterSink, store, dlq.EnvelopeID, dlq.WithMaxDeliveries(2))
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	// The other messages are delivered, the poison message is dead-lettered, and all are acknowledged in order.
+	var consumed []string
+	for len(consumed) < 2 {
+		select {
+		case msg := <-messages:
+			consumed = append(consumed, string(msg.Data()))
+			acks <- msg
+		case err := <-errs:
+			require.FailNow(t, "consumption failed", "%v", err)
+		}
+	}
+	select {
+	case <-mockSource.AllAcked():
+	case err := <-errs:
+		require.FailNow(t, "consumption failed", "%v", err)
+	}
+	cancel()
+	require.NoError(t, <-errs)
+	require.Equal(t, []string{"1", "2"}, consumed)
+
+	published := deadLetterSink.Published()
+	require.Len(t, published, 1)
+	var failure dlq.Failure
+	require.NoError(t, json.Unmarshal([]byte(published[0]), &failure))
+	require.Equal(t, dlq.Failure{
+		Payload:  []byte("p"),
+		Reason:   "exceeded the maximum of 2 deliveries",
+		Attempts: 2,
+	}, failure)
+
+	// The counts of acknowledged and dead-lettered messages are reset.
+	for _, key := range []string{"a", "poison"} {
+		attempts, err := store.Increment(context.Background(), key)
+		require.NoError(t, err)
+		require.Equal(t, 1, attempts)
+	}
+}
+
+func TestMemoryAttemptStore(t *testing.T) {
+	ctx := context.Background()
+	store := dlq.NewMemoryAttemptStore()
+
+	for i := 1; i <= 3; i++ {
+		attempts, err := store.Increment(ctx, "key")
+		require.NoError(t, err)
+		require.Equal(t, i, attempts)
+	}
+	require.NoError(t, store.Reset(ctx, "key"))
+	require.NoError(t, store.Reset(ctx, "missing"))
+	attempts, err := store.Increment(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, 1, attempts)
+}
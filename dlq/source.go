@@ -0,0 +1,251 @@
+// Package dlq provides a message source wrapper that publishes messages that failed to be processed
+// to a dead-letter sink.
+package dlq
+
+import (
+	"context"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/ackordering"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/health"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// AsyncMessageSourceOption is a function which sets an AsyncMessageSource configuration option.
+type AsyncMessageSourceOption func(s *dlqSource)
+
+// WithMaxAttempts sets the number of times a message is delivered before it is dead-lettered. Messages
+// nacked before reaching the maximum number of attempts are delivered again. The default value is 1.
+func WithMaxAttempts(attempts int) AsyncMessageSourceOption {
+	return func(s *dlqSource) {
+		s.maxAttempts = attempts
+	}
+}
+
+// WithEnvelope sets the envelope used to encode messages published to the dead-letter sink.
+// The default is JSONEnvelope.
+func WithEnvelope(envelope Envelope) AsyncMessageSourceOption {
+	return func(s *dlqSource) {
+		s.envelope = envelope
+	}
+}
+
+// Nack returns a negative acknowledgement for a message consumed from the dead-letter source.
+// It should be sent on the acks channel instead of the message itself.
+func Nack(msg substrate.Message, reason error) substrate.Message {
+	return &nack{msg: msg, reason: reason}
+}
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that publishes messages
+// that were nacked using the Nack function to the dead-letter sink and acknowledges them once they have
+// been successfully published. Acknowledgements are forwarded to the underlying source in the order in
+// which the messages were consumed. When Close is called, both the source and the dead-letter sink are closed.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, deadLetterSink substrate.AsyncMessageSink, opts ...AsyncMessageSourceOption) substrate.AsyncMessageSource {
+	s := &dlqSource{
+		source:      ackordering.NewAsyncMessageSource(source),
+		sink:        deadLetterSink,
+		maxAttempts: 1,
+		envelope:    JSONEnvelope,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type dlqSource struct {
+	source      substrate.AsyncMessageSource
+	sink        substrate.AsyncMessageSink
+	maxAttempts int
+	envelope    Envelope
+}
+
+func (s *dlqSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "dlq"})
+	rg, ctx := rungroup.New(ctx)
+
+	sourceMsgs := make(chan substrate.Message, cap(messages))
+	sourceAcks := make(chan substrate.Message, cap(acks))
+	deadLetters := make(chan substrate.Message)
+	deadLetterAcks := make(chan substrate.Message)
+
+	rg.Go(func() error {
+		return s.source.ConsumeMessages(ctx, sourceMsgs, sourceAcks)
+	})
+	rg.Go(func() error {
+		return s.sink.PublishMessages(ctx, deadLetterAcks, deadLetters)
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-sourceMsgs:
+				dMsg := &dlqMessage{msg: msg, attempts: 1}
+				emit.Publish(dMsg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case messages <- dMsg:
+				}
+			}
+		}
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				switch msg := ack.(type) {
+				case *dlqMessage:
+					emit.Ack(msg)
+					select {
+					case <-ctx.Done():
+						return nil
+					case sourceAcks <- msg.msg:
+					}
+				case *nack:
+					dMsg, ok := msg.msg.(*dlqMessage)
+					if !ok {
+						return errors.Errorf("unexpected message type: %T", msg.msg)
+					}
+					if msg.reason != nil {
+						emit.Error(msg.reason)
+					}
+					if dMsg.attempts < s.maxAttempts {
+						go s.redeliver(ctx, emit, messages, dMsg)
+						continue
+					}
+					deadLetter, err := s.deadLetter(dMsg, msg.reason)
+					if err != nil {
+						return err
+					}
+					select {
+					case <-ctx.Done():
+						return nil
+					case deadLetters <- deadLetter:
+					}
+				default:
+					return errors.Errorf("unexpected message type: %T", ack)
+				}
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-deadLetterAcks:
+				deadLetter, ok := ack.(*deadLetterMessage)
+				if !ok {
+					return errors.Errorf("unexpected message type: %T", ack)
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case sourceAcks <- deadLetter.original:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+func (s *dlqSource) redeliver(ctx context.Context, emit events.Emitter, messages chan<- substrate.Message, msg *dlqMessage) {
+	next := &dlqMessage{msg: msg.msg, attempts: msg.attempts + 1}
+	emit.Publish(next)
+	select {
+	case <-ctx.Done():
+	case messages <- next:
+	}
+}
+
+func (s *dlqSource) deadLetter(msg *dlqMessage, reason error) (*deadLetterMessage, error) {
+	failure := Failure{
+		Payload:  msg.Data(),
+		Attempts: msg.attempts,
+	}
+	if reason != nil {
+		failure.Reason = reason.Error()
+	}
+	if hMsg, ok := msg.msg.(message.HeaderedMessage); ok {
+		failure.Headers = hMsg.Headers()
+	}
+
+	payload, err := s.envelope(failure)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode dead letter")
+	}
+
+	return &deadLetterMessage{
+		payload:  payload,
+		original: msg.msg,
+	}, nil
+}
+
+// Close closes both the underlying source and the dead-letter sink and returns all errors encountered.
+func (s *dlqSource) Close() (err error) {
+	err = multierror.Append(err, s.source.Close()).ErrorOrNil()
+	return multierror.Append(err, s.sink.Close()).ErrorOrNil()
+}
+
+// Status returns the status of the underlying source. It only reports working status if the
+// dead-letter sink does as well.
+func (s *dlqSource) Status() (*substrate.Status, error) {
+	return health.Combine(s.source, health.Named("dead-letter sink", s.sink)).Status()
+}
+
+type dlqMessage struct {
+	msg      substrate.Message
+	attempts int
+}
+
+func (msg *dlqMessage) Data() []byte {
+	return msg.msg.Data()
+}
+
+func (msg *dlqMessage) DiscardPayload() {
+	if dMsg, ok := msg.msg.(substrate.DiscardableMessage); ok {
+		dMsg.DiscardPayload()
+	}
+}
+
+func (msg *dlqMessage) Headers() map[string]string {
+	return message.Headers(msg.msg)
+}
+
+func (msg *dlqMessage) ContentType() string {
+	return message.ContentType(msg.msg)
+}
+
+func (msg *dlqMessage) CarriedEnvelope() (message.Envelope, bool) {
+	return message.EnvelopeOf(msg.msg)
+}
+
+type nack struct {
+	msg    substrate.Message
+	reason error
+}
+
+func (msg *nack) Data() []byte {
+	return msg.msg.Data()
+}
+
+type deadLetterMessage struct {
+	payload  []byte
+	original substrate.Message
+	// key is the key of the original message, for messages dead-lettered by the redelivery source.
+	key string
+}
+
+func (msg *deadLetterMessage) Data() []byte {
+	return msg.payload
+}
@@ -0,0 +1,182 @@
+package dlq_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/dlq"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+type asyncMessageSinkMock struct {
+	substrate.AsyncMessageSink
+	published chan substrate.Message
+}
+
+func (m asyncMessageSinkMock) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-messages:
+			m.published <- msg
+			acks <- msg
+		}
+	}
+}
+
+func (m asyncMessageSinkMock) Close() error {
+	return nil
+}
+
+func TestDeadLetterMessageSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			message.FromString("1"),
+			message.FromString("2"),
+			message.FromString("3"),
+		},
+	}
+	mockSink := asyncMessageSinkMock{published: make(chan substrate.Message, 1)}
+
+	source := dlq.NewAsyncMessageSource(mockSource, mockSink, dlq.WithMaxAttempts(2))
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	var consumed []substrate.Message
+	for i := 0; i < len(mockSource.Messages); i++ {
+		consumed = append(consumed, <-messages)
+	}
+
+	// Nack the first message twice to get it dead-lettered, ack the others.
+	acks <- dlq.Nack(consumed[0], errors.New("first failure"))
+	acks <- consumed[2]
+	acks <- consumed[1]
+
+	redelivered := <-messages
+	require.Equal(t, "1", string(redelivered.Data()))
+	acks <- dlq.Nack(redelivered, errors.New("second failure"))
+
+	var failure dlq.Failure
+	select {
+	case <-ctx.Done():
+		require.FailNow(t, "message was not dead-lettered")
+	case deadLetter := <-mockSink.published:
+		require.NoError(t, json.Unmarshal(deadLetter.Data(), &failure))
+	}
+	require.Equal(t, dlq.Failure{
+		Payload:  []byte("1"),
+		Reason:   "second failure",
+		Attempts: 2,
+	}, failure)
+
+	// The mock source terminates with an error if acknowledgements are out of order.
+	require.NoError(t, source.Close())
+	require.NoError(t, <-errs)
+	require.True(t, mockSource.WasClosed())
+}
+
+// errorRecorder records the errors emitted into it.
+type errorRecorder struct {
+	events.Nop
+	mutex  sync.Mutex
+	errors []string
+}
+
+func (r *errorRecorder) OnError(_ context.Context, _ events.Info, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.errors = append(r.errors, err.Error())
+}
+
+func (r *errorRecorder) recorded() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string(nil), r.errors...)
+}
+
+func TestDeadLetterMessageSource_Events(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	r := &errorRecorder{}
+	mockSource := &mock.AsyncMessageSource{Messages: []substrate.Message{message.FromString("1")}}
+	mockSink := asyncMessageSinkMock{published: make(chan substrate.Message, 1)}
+	source := dlq.NewAsyncMessageSource(mockSource, mockSink)
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- source.ConsumeMessages(events.WithHooks(ctx, r), messages, acks)
+	}()
+
+	// The reason of a dead-lettered message is emitted, although consuming doesn't fail.
+	acks <- dlq.Nack(<-messages, errors.New("failure"))
+	<-mockSink.published
+	cancel()
+	require.NoError(t, <-errs)
+	require.Equal(t, []string{"failure"}, r.recorded())
+}
+
+func TestDeadLetterMessageSource_InvalidAck(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source := dlq.NewAsyncMessageSource(&mock.AsyncMessageSource{}, asyncMessageSinkMock{})
+	acks := make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- source.ConsumeMessages(ctx, make(chan substrate.Message), acks)
+	}()
+
+	acks <- message.FromString("unknown")
+	require.Error(t, <-errs)
+}
+
+func TestDeadLetterMessageSource_Metadata(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			message.NewEnvelopedMessage(message.Envelope{
+				ContentType: "text/plain",
+				Headers:     map[string]string{"key": "value"},
+				Payload:     []byte("1"),
+			}),
+		},
+	}
+	source := dlq.NewAsyncMessageSource(mockSource, asyncMessageSinkMock{published: make(chan substrate.Message, 1)})
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	// Delivered messages report the headers and content type of the messages of the source.
+	msg := <-messages
+	require.Equal(t, map[string]string{"key": "value"}, message.Headers(msg))
+	require.Equal(t, "text/plain", message.ContentType(msg))
+	acks <- msg
+
+	require.NoError(t, source.Close())
+	require.NoError(t, <-errs)
+}
@@ -0,0 +1,164 @@
+// Package drain provides a message source wrapper that drains messages in flight before stopping, so that the
+// acknowledgements of messages delivered before a shutdown aren't lost.
+package drain
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+const defaultTimeout = time.Second * 30
+
+// TimeoutError is returned by ConsumeMessages when messages delivered before the drain started weren't all
+// acknowledged before the timeout.
+type TimeoutError struct {
+	// Unacknowledged is the number of delivered messages that weren't acknowledged.
+	Unacknowledged int
+}
+
+func (e TimeoutError) Error() string {
+	return fmt.Sprintf("drain timed out with %d unacknowledged messages", e.Unacknowledged)
+}
+
+// Option is a function which sets a drain source configuration option.
+type Option func(s *drainSource)
+
+// WithTimeout sets for how long delivered messages are waited for to be acknowledged once the drain started,
+// before the underlying source is stopped anyway. The default value is 30 seconds.
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *drainSource) {
+		s.timeout = timeout
+	}
+}
+
+// NewSource returns an instance of substrate.AsyncMessageSource that drains the underlying source when the
+// context passed to ConsumeMessages is cancelled or when Close is called: no more messages are delivered, but
+// the acknowledgements of the messages that were delivered are still forwarded to the underlying source, which
+// is only stopped once all of them were, or once the timeout elapsed, in which case a TimeoutError is returned.
+// Consumers must therefore keep acknowledging messages after cancelling the context, until ConsumeMessages
+// returns. Messages received from the underlying source, but not delivered yet, are dropped, so that they are
+// redelivered by the backend. Close waits for ConsumeMessages to return before closing the underlying source.
+func NewSource(source substrate.AsyncMessageSource, opts ...Option) substrate.AsyncMessageSource {
+	s := &drainSource{
+		source:   source,
+		timeout:  defaultTimeout,
+		draining: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type drainSource struct {
+	source  substrate.AsyncMessageSource
+	timeout time.Duration
+
+	draining  chan struct{}
+	drainOnce sync.Once
+
+	mutex sync.Mutex
+	done  chan struct{}
+}
+
+func (s *drainSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "drain"})
+	done := make(chan struct{})
+	defer close(done)
+	s.mutex.Lock()
+	s.done = done
+	s.mutex.Unlock()
+
+	// the underlying source outlives the context, it is only stopped once it was drained
+	rg, backendCtx := rungroup.New(context.WithoutCancel(ctx))
+
+	// acknowledgements are forwarded unbuffered, so that they were all received by the underlying source
+	// once it was drained
+	sourceMsgs := make(chan substrate.Message, cap(messages))
+	sourceAcks := make(chan substrate.Message)
+
+	rg.Go(func() error {
+		return s.source.ConsumeMessages(backendCtx, sourceMsgs, sourceAcks)
+	})
+	rg.Go(emit.Errors(func() error {
+		var (
+			cancelled = ctx.Done()
+			closed    = s.draining
+			in        = sourceMsgs
+			timeout   <-chan time.Time
+			pending   substrate.Message
+			inFlight  int
+		)
+		for {
+			var out chan<- substrate.Message
+			if pending != nil {
+				out = messages
+			}
+			select {
+			case <-backendCtx.Done():
+				return nil
+			case <-cancelled:
+			case <-closed:
+			case <-timeout:
+				return TimeoutError{Unacknowledged: inFlight}
+			case pending = <-in:
+				in = nil
+				continue
+			case out <- pending:
+				emit.Publish(pending)
+				pending, in = nil, sourceMsgs
+				inFlight++
+				continue
+			case ack := <-acks:
+				emit.Ack(ack)
+				select {
+				case <-backendCtx.Done():
+					return nil
+				case sourceAcks <- ack:
+				}
+				inFlight--
+				if inFlight == 0 && timeout != nil {
+					return nil
+				}
+				continue
+			}
+
+			// the drain started, no more messages are delivered
+			if inFlight == 0 {
+				return nil
+			}
+			cancelled, closed, in, pending = nil, nil, nil, nil
+			timer := time.NewTimer(s.timeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+	}))
+
+	return rg.Wait()
+}
+
+// Close starts draining the source, waits for ConsumeMessages to return, if it is running, and closes the
+// underlying source.
+func (s *drainSource) Close() error {
+	s.drainOnce.Do(func() {
+		close(s.draining)
+	})
+	s.mutex.Lock()
+	done := s.done
+	s.mutex.Unlock()
+	if done != nil {
+		<-done
+	}
+	return s.source.Close()
+}
+
+// Status returns the status of the underlying source.
+func (s *drainSource) Status() (*substrate.Status, error) {
+	return s.source.Status()
+}
@@ -0,0 +1,105 @@
+package drain_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/drain"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+func consume(ctx context.Context, source substrate.AsyncMessageSource) (chan substrate.Message, chan substrate.Message, <-chan error) {
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+	return messages, acks, errs
+}
+
+func receive(t *testing.T, messages <-chan substrate.Message) substrate.Message {
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(time.Second * 5):
+		require.FailNow(t, "no message received")
+		return nil
+	}
+}
+
+func TestSource_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mockSource := mock.NewSource(message.FromString("1"), message.FromString("2"), message.FromString("3"))
+	messages, acks, errs := consume(ctx, drain.NewSource(mockSource))
+
+	first, second := receive(t, messages), receive(t, messages)
+	cancel()
+
+	// Delivered messages can still be acknowledged, while no more messages are delivered.
+	acks <- first
+	select {
+	case msg := <-messages:
+		require.FailNow(t, "message delivered while draining", "%s", msg.Data())
+	case err := <-errs:
+		require.FailNow(t, "drain returned before all messages were acknowledged", "%v", err)
+	case <-time.After(time.Millisecond * 50):
+	}
+	acks <- second
+	require.NoError(t, <-errs)
+	require.Equal(t, []substrate.Message{first, second}, mockSource.Acked())
+}
+
+func TestSource_Timeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mockSource := mock.NewSource(message.FromString("1"))
+	messages, _, errs := consume(ctx, drain.NewSource(mockSource, drain.WithTimeout(time.Millisecond*50)))
+
+	receive(t, messages)
+	cancel()
+	require.Equal(t, drain.TimeoutError{Unacknowledged: 1}, <-errs)
+	require.Empty(t, mockSource.Acked())
+}
+
+func TestSource_Close(t *testing.T) {
+	mockSource := mock.NewSource(message.FromString("1"), message.FromString("2"))
+	source := drain.NewSource(mockSource)
+	messages, acks, errs := consume(context.Background(), source)
+
+	msg := receive(t, messages)
+	closed := make(chan error, 1)
+	go func() {
+		closed <- source.Close()
+	}()
+
+	// The underlying source is only closed once the delivered message was acknowledged.
+	select {
+	case <-closed:
+		require.FailNow(t, "source closed before the message was acknowledged")
+	case <-time.After(time.Millisecond * 50):
+	}
+	require.False(t, mockSource.WasClosed())
+	acks <- msg
+	require.NoError(t, <-errs)
+	require.NoError(t, <-closed)
+	require.True(t, mockSource.WasClosed())
+	require.Equal(t, []substrate.Message{msg}, mockSource.Acked())
+}
+
+func TestSource_Idle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	mockSource := mock.NewSource()
+	_, _, errs := consume(ctx, drain.NewSource(mockSource))
+
+	// Without messages in flight, the source stops straight away.
+	cancel()
+	require.NoError(t, <-errs)
+}
@@ -0,0 +1,68 @@
+// Package encrypt provides wrappers that encrypt the payloads of published messages using AES-GCM and
+// transparently decrypt them on consumption.
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// KeyIDHeader is the header recording the ID of the key a payload was encrypted with. The encryption sink
+// sets it on every message, so the sink it wraps must support headers, such as the envelope sink of the
+// message package.
+const KeyIDHeader = "encryption-key-id"
+
+var (
+	// ErrUnknownKey is returned when a key is not known to the key provider.
+	ErrUnknownKey = errors.New("unknown encryption key")
+	// ErrNotEncrypted is returned when consuming a message without the KeyIDHeader header, unless
+	// plaintext messages are allowed.
+	ErrNotEncrypted = errors.New("message is not encrypted")
+)
+
+// seal encrypts the data with the key, prefixing the result with a random nonce. The key ID is
+// authenticated, so that a payload can't be passed off as encrypted with a different key.
+func seal(keyID string, key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(data)+gcm.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "failed to generate nonce")
+	}
+	return gcm.Seal(nonce, nonce, data, []byte(keyID)), nil
+}
+
+// open decrypts data encrypted by seal.
+func open(keyID string, key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("encrypted payload is too short")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(keyID))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt payload")
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid encryption key")
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cipher")
+	}
+	return gcm, nil
+}
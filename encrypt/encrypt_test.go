@@ -0,0 +1,136 @@
+package encrypt_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/encrypt"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+var (
+	keyV1 = bytes.Repeat([]byte{1}, 32)
+	keyV2 = bytes.Repeat([]byte{2}, 32)
+)
+
+func publish(ctx context.Context, t *testing.T, sink substrate.AsyncMessageSink, msgs ...substrate.Message) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	for _, msg := range msgs {
+		messages <- msg
+		require.Equal(t, msg, <-acks)
+	}
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestEncryptionWithKeyRotation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+
+	v1, err := encrypt.NewStaticKeyProvider("v1", map[string][]byte{"v1": keyV1})
+	require.NoError(t, err)
+	publish(ctx, t, encrypt.NewAsyncMessageSink(message.NewEnvelopeSink(broker.NewAsyncMessageSink("topic")), v1),
+		message.FromString("first"),
+	)
+
+	v2, err := encrypt.NewStaticKeyProvider("v2", map[string][]byte{"v1": keyV1, "v2": keyV2})
+	require.NoError(t, err)
+	publish(ctx, t, encrypt.NewAsyncMessageSink(message.NewEnvelopeSink(broker.NewAsyncMessageSink("topic")), v2),
+		message.NewEnvelopedMessage(message.Envelope{
+			ID:      "id-2",
+			Headers: map[string]string{"traceparent": "trace"},
+			Payload: []byte("second"),
+		}),
+	)
+
+	var raw message.Envelope
+	require.NoError(t, json.Unmarshal(broker.Messages("topic")[1], &raw))
+	require.Equal(t, "v2", raw.Headers[encrypt.KeyIDHeader])
+	require.NotContains(t, string(raw.Payload), "second")
+
+	source := encrypt.NewAsyncMessageSource(message.NewEnvelopeSource(broker.NewAsyncMessageSource("topic", "group")), v2)
+	consumed, acks := make(chan substrate.Message), make(chan substrate.Message)
+	go source.ConsumeMessages(ctx, consumed, acks)
+
+	first := <-consumed
+	require.Equal(t, "first", string(first.Data()))
+	require.Empty(t, message.Headers(first))
+	acks <- first
+
+	second := <-consumed
+	require.Equal(t, "second", string(second.Data()))
+	require.Equal(t, map[string]string{"traceparent": "trace"}, message.Headers(second))
+	acks <- second
+}
+
+func TestDecryptionFailures(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	v1, err := encrypt.NewStaticKeyProvider("v1", map[string][]byte{"v1": keyV1})
+	require.NoError(t, err)
+	v2, err := encrypt.NewStaticKeyProvider("v2", map[string][]byte{"v2": keyV2})
+	require.NoError(t, err)
+
+	broker := mem.NewBroker()
+	publish(ctx, t, encrypt.NewAsyncMessageSink(message.NewEnvelopeSink(broker.NewAsyncMessageSink("encrypted")), v1),
+		message.FromString("payload"),
+	)
+	publish(ctx, t, message.NewEnvelopeSink(broker.NewAsyncMessageSink("plaintext")),
+		message.FromString("payload"),
+	)
+
+	tests := []struct {
+		name     string
+		topic    string
+		keys     encrypt.KeyProvider
+		expected error
+	}{
+		{name: "unknown key", topic: "encrypted", keys: v2, expected: encrypt.ErrUnknownKey},
+		{name: "not encrypted", topic: "plaintext", keys: v1, expected: encrypt.ErrNotEncrypted},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := encrypt.NewAsyncMessageSource(message.NewEnvelopeSource(broker.NewAsyncMessageSource(test.topic, "group")), test.keys)
+			err := source.ConsumeMessages(ctx, make(chan substrate.Message), make(chan substrate.Message))
+			require.Equal(t, test.expected, errors.Cause(err))
+		})
+	}
+}
+
+func TestAllowPlaintext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	keys, err := encrypt.NewStaticKeyProvider("v1", map[string][]byte{"v1": keyV1})
+	require.NoError(t, err)
+
+	broker := mem.NewBroker()
+	publish(ctx, t, broker.NewAsyncMessageSink("topic"), message.FromString("payload"))
+
+	source := encrypt.NewAsyncMessageSource(broker.NewAsyncMessageSource("topic", "group"), keys, encrypt.WithAllowPlaintext())
+	consumed, acks := make(chan substrate.Message), make(chan substrate.Message)
+	go source.ConsumeMessages(ctx, consumed, acks)
+
+	msg := <-consumed
+	require.Equal(t, "payload", string(msg.Data()))
+	acks <- msg
+}
@@ -0,0 +1,182 @@
+package encrypt
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// KeyProvider provides the keys used to encrypt and decrypt payloads. Keys must be 16, 24 or 32 bytes
+// long, selecting AES-128, AES-192 or AES-256. To rotate keys, a provider starts returning a new current
+// key, while still returning older keys by their ID, so that messages encrypted under them can be decrypted.
+type KeyProvider interface {
+	// CurrentKey returns the ID of the key used to encrypt new messages along with the key.
+	CurrentKey(ctx context.Context) (id string, key []byte, err error)
+	// Key returns the key with the given ID, or an error wrapping ErrUnknownKey if there is no such key.
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+// NewStaticKeyProvider returns a KeyProvider with a fixed set of keys, which encrypts messages using the key
+// with the current ID. The other keys are only used to decrypt messages encrypted before a rotation.
+func NewStaticKeyProvider(current string, keys map[string][]byte) (KeyProvider, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, errors.Wrapf(ErrUnknownKey, "key %q", current)
+	}
+	return &staticKeyProvider{current: current, keys: keys}, nil
+}
+
+type staticKeyProvider struct {
+	current string
+	keys    map[string][]byte
+}
+
+func (p *staticKeyProvider) CurrentKey(ctx context.Context) (string, []byte, error) {
+	return p.current, p.keys[p.current], nil
+}
+
+func (p *staticKeyProvider) Key(ctx context.Context, id string) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownKey, "key %q", id)
+	}
+	return key, nil
+}
+
+// KeyFile is the format of the file read by the file key provider.
+type KeyFile struct {
+	// Current is the ID of the key used to encrypt new messages.
+	Current string `json:"current"`
+	// Keys maps key IDs to keys, encoded as standard base64.
+	Keys map[string]string `json:"keys"`
+}
+
+// NewFileKeyProvider returns a KeyProvider that reads its keys from a JSON file in the KeyFile format.
+// The file is read again whenever its modification time changes, so that keys can be rotated without
+// a restart.
+func NewFileKeyProvider(path string) KeyProvider {
+	return &fileKeyProvider{path: path}
+}
+
+type fileKeyProvider struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	keys    KeyProvider
+}
+
+func (p *fileKeyProvider) CurrentKey(ctx context.Context) (string, []byte, error) {
+	keys, err := p.load()
+	if err != nil {
+		return "", nil, err
+	}
+	return keys.CurrentKey(ctx)
+}
+
+func (p *fileKeyProvider) Key(ctx context.Context, id string) ([]byte, error) {
+	keys, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	return keys.Key(ctx, id)
+}
+
+// load returns the keys in the file, reading it if it changed since it was last read.
+func (p *fileKeyProvider) load() (KeyProvider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read key file")
+	}
+	if p.keys != nil && info.ModTime().Equal(p.modTime) {
+		return p.keys, nil
+	}
+
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read key file")
+	}
+	var file KeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, errors.Wrap(err, "failed to decode key file")
+	}
+	keys := make(map[string][]byte, len(file.Keys))
+	for id, encoded := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode key %q", id)
+		}
+		keys[id] = key
+	}
+	provider, err := NewStaticKeyProvider(file.Current, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	p.keys, p.modTime = provider, info.ModTime()
+	return provider, nil
+}
+
+// KMS decrypts data keys encrypted with a master key held by a key management service.
+type KMS interface {
+	// Decrypt returns the plaintext of the encrypted data key.
+	Decrypt(ctx context.Context, encryptedKey []byte) ([]byte, error)
+}
+
+// NewKMSKeyProvider returns a KeyProvider for data keys encrypted by a key management service, which
+// encrypts messages using the key with the current ID. Keys are decrypted using the KMS the first time
+// they are needed and cached afterwards.
+func NewKMSKeyProvider(kms KMS, current string, encryptedKeys map[string][]byte) (KeyProvider, error) {
+	if _, ok := encryptedKeys[current]; !ok {
+		return nil, errors.Wrapf(ErrUnknownKey, "key %q", current)
+	}
+	return &kmsKeyProvider{
+		kms:           kms,
+		current:       current,
+		encryptedKeys: encryptedKeys,
+		keys:          make(map[string][]byte),
+	}, nil
+}
+
+type kmsKeyProvider struct {
+	kms           KMS
+	current       string
+	encryptedKeys map[string][]byte
+
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+func (p *kmsKeyProvider) CurrentKey(ctx context.Context) (string, []byte, error) {
+	key, err := p.Key(ctx, p.current)
+	if err != nil {
+		return "", nil, err
+	}
+	return p.current, key, nil
+}
+
+func (p *kmsKeyProvider) Key(ctx context.Context, id string) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[id]; ok {
+		return key, nil
+	}
+	encryptedKey, ok := p.encryptedKeys[id]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownKey, "key %q", id)
+	}
+	key, err := p.kms.Decrypt(ctx, encryptedKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decrypt key %q", id)
+	}
+	p.keys[id] = key
+	return key, nil
+}
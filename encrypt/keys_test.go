@@ -0,0 +1,97 @@
+package encrypt_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate-tools/encrypt"
+)
+
+func writeKeyFile(t *testing.T, path string, file encrypt.KeyFile, modTime time.Time) {
+	data, err := json.Marshal(file)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestFileKeyProvider(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "keys.json")
+	now := time.Now()
+
+	writeKeyFile(t, path, encrypt.KeyFile{
+		Current: "v1",
+		Keys:    map[string]string{"v1": base64.StdEncoding.EncodeToString(keyV1)},
+	}, now)
+
+	keys := encrypt.NewFileKeyProvider(path)
+	id, key, err := keys.CurrentKey(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "v1", id)
+	require.Equal(t, keyV1, key)
+
+	writeKeyFile(t, path, encrypt.KeyFile{
+		Current: "v2",
+		Keys: map[string]string{
+			"v1": base64.StdEncoding.EncodeToString(keyV1),
+			"v2": base64.StdEncoding.EncodeToString(keyV2),
+		},
+	}, now.Add(time.Second))
+
+	id, key, err = keys.CurrentKey(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "v2", id)
+	require.Equal(t, keyV2, key)
+
+	key, err = keys.Key(ctx, "v1")
+	require.NoError(t, err)
+	require.Equal(t, keyV1, key)
+
+	_, err = keys.Key(ctx, "v3")
+	require.Equal(t, encrypt.ErrUnknownKey, errors.Cause(err))
+}
+
+type kmsMock struct {
+	calls int
+}
+
+func (k *kmsMock) Decrypt(ctx context.Context, encryptedKey []byte) ([]byte, error) {
+	k.calls++
+	key := make([]byte, len(encryptedKey))
+	for i, b := range encryptedKey {
+		key[i] = b ^ 0xff
+	}
+	return key, nil
+}
+
+func TestKMSKeyProvider(t *testing.T) {
+	ctx := context.Background()
+	kms := &kmsMock{}
+
+	encryptedKey := make([]byte, len(keyV1))
+	for i, b := range keyV1 {
+		encryptedKey[i] = b ^ 0xff
+	}
+
+	_, err := encrypt.NewKMSKeyProvider(kms, "v2", map[string][]byte{"v1": encryptedKey})
+	require.Equal(t, encrypt.ErrUnknownKey, errors.Cause(err))
+
+	keys, err := encrypt.NewKMSKeyProvider(kms, "v1", map[string][]byte{"v1": encryptedKey})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		id, key, err := keys.CurrentKey(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "v1", id)
+		require.Equal(t, keyV1, key)
+	}
+	require.Equal(t, 1, kms.calls)
+}
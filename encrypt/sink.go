@@ -0,0 +1,57 @@
+package encrypt
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/internal/transform"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that encrypts payloads using the current
+// key of the provider before publishing them, setting the KeyIDHeader header to the ID of the key. Messages are
+// published as message.EnvelopedMessage, so that the envelope sink preserves the metadata of enveloped messages.
+func NewAsyncMessageSink(sink substrate.AsyncMessageSink, keys KeyProvider) substrate.AsyncMessageSink {
+	return &encryptSink{
+		sink: sink,
+		keys: keys,
+	}
+}
+
+type encryptSink struct {
+	sink substrate.AsyncMessageSink
+	keys KeyProvider
+}
+
+func (s *encryptSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	return transform.PublishMessages(ctx, "encrypt", s.sink, acks, messages, s.encrypt)
+}
+
+// encrypt returns the message to publish in place of the provided one.
+func (s *encryptSink) encrypt(ctx context.Context, msg substrate.Message) (substrate.Message, error) {
+	envelope, _ := message.EnvelopeOf(msg)
+	envelope.Headers = message.Headers(msg)
+
+	id, key, err := s.keys.CurrentKey(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get encryption key")
+	}
+	data, err := seal(id, key, envelope.Payload)
+	if err != nil {
+		return nil, err
+	}
+	envelope.Payload = data
+	envelope.Headers[KeyIDHeader] = id
+
+	return message.NewEnvelopedMessage(envelope), nil
+}
+
+func (s *encryptSink) Close() error {
+	return s.sink.Close()
+}
+
+func (s *encryptSink) Status() (*substrate.Status, error) {
+	return s.sink.Status()
+}
@@ -0,0 +1,158 @@
+package encrypt
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// SourceOption is a function which sets a decryption source configuration option.
+type SourceOption func(s *encryptSource)
+
+// WithAllowPlaintext passes messages without the KeyIDHeader header through unchanged, instead of failing
+// consumption with ErrNotEncrypted. This is useful while migrating a topic to encrypted payloads.
+func WithAllowPlaintext() SourceOption {
+	return func(s *encryptSource) {
+		s.allowPlaintext = true
+	}
+}
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that decrypts payloads using the key
+// of the provider with the ID recorded in the KeyIDHeader header, which is removed from decrypted messages.
+// Consumption fails if a payload can't be decrypted.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, keys KeyProvider, opts ...SourceOption) substrate.AsyncMessageSource {
+	s := &encryptSource{
+		source: source,
+		keys:   keys,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type encryptSource struct {
+	source         substrate.AsyncMessageSource
+	keys           KeyProvider
+	allowPlaintext bool
+}
+
+func (s *encryptSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "encrypt"})
+	rg, ctx := rungroup.New(ctx)
+
+	consumed := make(chan substrate.Message, cap(messages))
+	toAck := make(chan substrate.Message, cap(acks))
+
+	rg.Go(func() error {
+		return s.source.ConsumeMessages(ctx, consumed, toAck)
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-consumed:
+				dMsg, err := s.decrypt(ctx, msg)
+				if err != nil {
+					return err
+				}
+				emit.Publish(dMsg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case messages <- dMsg:
+				}
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				emit.Ack(ack)
+				if dMsg, ok := ack.(*decryptedMessage); ok {
+					ack = dMsg.msg
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case toAck <- ack:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+// decrypt returns the message to deliver in place of the consumed one.
+func (s *encryptSource) decrypt(ctx context.Context, msg substrate.Message) (substrate.Message, error) {
+	headers := message.Headers(msg)
+	id, ok := headers[KeyIDHeader]
+	if !ok {
+		if s.allowPlaintext {
+			return msg, nil
+		}
+		return nil, ErrNotEncrypted
+	}
+	key, err := s.keys.Key(ctx, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get decryption key")
+	}
+	data, err := open(id, key, msg.Data())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decrypt message with key %q", id)
+	}
+	delete(headers, KeyIDHeader)
+
+	return &decryptedMessage{
+		data:    data,
+		headers: headers,
+		msg:     msg,
+	}, nil
+}
+
+func (s *encryptSource) Close() error {
+	return s.source.Close()
+}
+
+func (s *encryptSource) Status() (*substrate.Status, error) {
+	return s.source.Status()
+}
+
+// decryptedMessage is a message with a decrypted payload, delivered on behalf of the consumed message.
+type decryptedMessage struct {
+	data    []byte
+	headers map[string]string
+	msg     substrate.Message
+}
+
+// Data returns the decrypted payload.
+func (msg *decryptedMessage) Data() []byte {
+	return msg.data
+}
+
+// Headers returns the headers of the consumed message, without the key ID.
+func (msg *decryptedMessage) Headers() map[string]string {
+	return msg.headers
+}
+
+// DiscardPayload discards the payload.
+func (msg *decryptedMessage) DiscardPayload() {
+	msg.data = nil
+	if dMsg, ok := msg.msg.(substrate.DiscardableMessage); ok {
+		dMsg.DiscardPayload()
+	}
+}
+
+func (msg *decryptedMessage) CarriedEnvelope() (message.Envelope, bool) {
+	return message.EnvelopeOf(msg.msg)
+}
@@ -0,0 +1,52 @@
+package events
+
+import (
+	"context"
+
+	"github.com/uw-labs/substrate"
+)
+
+// Emitter emits the events of a sink, source or wrapper into the hooks carried by the context it was created with.
+// Wrappers create one for every call to PublishMessages or ConsumeMessages, and emit the messages and
+// acknowledgements they pass on, and the errors they fail with themselves, leaving the errors of the sinks and
+// sources they wrap to those.
+type Emitter struct {
+	ctx   context.Context
+	hooks Hooks
+	info  Info
+}
+
+// NewEmitter returns an emitter of the events identified by the info into the hooks carried by the context.
+func NewEmitter(ctx context.Context, info Info) Emitter {
+	return Emitter{ctx: ctx, hooks: FromContext(ctx), info: info}
+}
+
+// Publish emits the message being passed on for publishing, or delivered to the application.
+func (e Emitter) Publish(msg substrate.Message) {
+	e.hooks.OnPublish(e.ctx, e.info, msg)
+}
+
+// Ack emits the acknowledgement of the message.
+func (e Emitter) Ack(msg substrate.Message) {
+	e.hooks.OnAck(e.ctx, e.info, msg)
+}
+
+// Error emits the error, unless it is nil or the result of the context being cancelled, and returns it.
+func (e Emitter) Error(err error) error {
+	if isFailure(err) {
+		e.hooks.OnError(e.ctx, e.info, err)
+	}
+	return err
+}
+
+// Errors returns a function calling f and emitting the error it returns, e.g. to be run in a rungroup.
+func (e Emitter) Errors(f func() error) func() error {
+	return func() error {
+		return e.Error(f())
+	}
+}
+
+// Reconnect emits the reconnection to a backend after it was lost with the error.
+func (e Emitter) Reconnect(attempt int, err error) {
+	e.hooks.OnReconnect(e.ctx, e.info, attempt, err)
+}
@@ -0,0 +1,178 @@
+package events_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/conformance"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+// recorder records the events it receives as strings.
+type recorder struct {
+	mutex  sync.Mutex
+	events []string
+}
+
+func (r *recorder) record(event string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recorder) recorded() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func (r *recorder) OnPublish(_ context.Context, info events.Info, msg substrate.Message) {
+	r.record(info.Component + " publish " + string(msg.Data()))
+}
+
+func (r *recorder) OnAck(_ context.Context, info events.Info, msg substrate.Message) {
+	r.record(info.Component + " ack " + string(msg.Data()))
+}
+
+func (r *recorder) OnError(_ context.Context, info events.Info, err error) {
+	r.record(info.Component + " error " + err.Error())
+}
+
+func (r *recorder) OnReconnect(_ context.Context, info events.Info, _ int, _ error) {
+	r.record(info.Component + " reconnect")
+}
+
+func TestSink(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	// Hooks are taken from the context when none are provided.
+	r := &recorder{}
+	sink := events.NewAsyncMessageSink(mock.NewSink(), nil, events.Info{Component: "sink", Topic: "orders"})
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(events.WithHooks(ctx, r), acks, messages)
+	}()
+
+	for _, payload := range []string{"a", "b"} {
+		messages <- message.FromString(payload)
+		<-acks
+	}
+	cancel()
+	require.NoError(t, <-errs)
+	require.Equal(t, []string{"sink publish a", "sink ack a", "sink publish b", "sink ack b"}, r.recorded())
+}
+
+func TestSink_Error(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	r := &recorder{}
+	sink := events.NewAsyncMessageSink(mock.NewSink().FailAt(1, errors.New("boom")), r, events.Info{Component: "sink"})
+	messages := make(chan substrate.Message, 1)
+	messages <- message.FromString("a")
+	require.EqualError(t, sink.PublishMessages(ctx, make(chan substrate.Message), messages), "boom")
+	require.Equal(t, []string{"sink publish a", "sink error boom"}, r.recorded())
+}
+
+func TestSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	r := &recorder{}
+	backend := mock.NewSource(message.FromString("a"))
+	source := events.NewAsyncMessageSource(backend, r, events.Info{Component: "source"})
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	acks <- <-messages
+	<-backend.AllAcked()
+	cancel()
+	require.NoError(t, <-errs)
+	require.Equal(t, []string{"source publish a", "source ack a"}, r.recorded())
+}
+
+func TestSource_Conformance(t *testing.T) {
+	conformance.TestAsyncSource(t, func(_ *testing.T, backend substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		return events.NewAsyncMessageSource(backend, events.Nop{}, events.Info{Component: "source"})
+	})
+}
+
+func TestSink_Conformance(t *testing.T) {
+	conformance.TestAsyncSink(t, func(_ *testing.T, backend substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+		return events.NewAsyncMessageSink(backend, events.Nop{}, events.Info{Component: "sink"})
+	})
+}
+
+func TestEmitter(t *testing.T) {
+	r := &recorder{}
+	emit := events.NewEmitter(events.WithHooks(context.Background(), r), events.Info{Component: "wrapper"})
+
+	emit.Publish(message.FromString("a"))
+	emit.Ack(message.FromString("a"))
+	// Errors are returned, but only failures are emitted.
+	require.EqualError(t, emit.Errors(func() error { return errors.New("boom") })(), "boom")
+	require.NoError(t, emit.Error(nil))
+	require.ErrorIs(t, emit.Error(context.Canceled), context.Canceled)
+	emit.Reconnect(1, errors.New("connection lost"))
+
+	require.Equal(t, []string{"wrapper publish a", "wrapper ack a", "wrapper error boom", "wrapper reconnect"}, r.recorded())
+}
+
+func TestMulti(t *testing.T) {
+	first, second := &recorder{}, &recorder{}
+	hooks := events.Multi(first, second)
+	hooks.OnReconnect(context.Background(), events.Info{Component: "source"}, 1, errors.New("connection lost"))
+	require.Equal(t, []string{"source reconnect"}, first.recorded())
+	require.Equal(t, []string{"source reconnect"}, second.recorded())
+}
+
+func TestSlogHooks(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	hooks := events.NewSlogHooks(logger)
+	info := events.Info{Component: "sink", Topic: "orders"}
+
+	// Debug events aren't logged at info level.
+	hooks.OnPublish(context.Background(), info, message.FromString("payload"))
+	require.Empty(t, buf.String())
+
+	hooks.OnError(context.Background(), info, errors.New("boom"))
+	require.Contains(t, buf.String(), `level=ERROR msg="operation failed" component=sink topic=orders error=boom`)
+}
+
+func TestPrometheusHooks(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	hooks := events.NewPrometheusHooks(registry, "app")
+	// hooks created with the same registry share the counter
+	other := events.NewPrometheusHooks(registry, "app")
+
+	info := events.Info{Component: "sink", Topic: "orders"}
+	hooks.OnPublish(context.Background(), info, message.FromString("payload"))
+	other.OnPublish(context.Background(), info, message.FromString("payload"))
+	hooks.OnError(context.Background(), info, errors.New("boom"))
+
+	expected := `
+# HELP app_events_total Number of events emitted by sinks, sources and wrappers.
+# TYPE app_events_total counter
+app_events_total{component="sink",event="error",topic="orders"} 1
+app_events_total{component="sink",event="publish",topic="orders"} 2
+`
+	require.NoError(t, testutil.GatherAndCompare(registry, bytes.NewBufferString(expected), "app_events_total"))
+}
@@ -0,0 +1,98 @@
+// Package events provides a single extension point for observing sinks and sources. Hooks receive events when
+// messages are published, acknowledged, when errors occur and when connections are re-established. Every wrapper
+// of this module emits its events into the hooks attached to the context using an Emitter, so that they don't need
+// wrapper-specific options, while the sink and source wrappers of this package emit the events of the sink or
+// source they wrap, e.g. of backends.
+package events
+
+import (
+	"context"
+
+	"github.com/uw-labs/substrate"
+)
+
+// Info identifies the emitter of an event.
+type Info struct {
+	// Component is the name of the sink, source or wrapper emitting the event, e.g. "sink" or "orders-consumer".
+	Component string
+	// Topic is the topic of the sink or source, if known.
+	Topic string
+}
+
+// Hooks receive the events emitted by sinks, sources and wrappers. They are called synchronously by the emitter,
+// so they must not block, and must be safe for concurrent use.
+type Hooks interface {
+	// OnPublish is called when a message is passed on for publishing by a sink, or delivered to the application
+	// by a source.
+	OnPublish(ctx context.Context, info Info, msg substrate.Message)
+	// OnAck is called when a message is acknowledged.
+	OnAck(ctx context.Context, info Info, msg substrate.Message)
+	// OnError is called when publishing or consuming fails with the error.
+	OnError(ctx context.Context, info Info, err error)
+	// OnReconnect is called when the connection to a backend is re-established after it was lost with the error,
+	// the attempt counting the reconnections since the last successful operation, starting from 1.
+	OnReconnect(ctx context.Context, info Info, attempt int, err error)
+}
+
+// Nop is an implementation of Hooks that ignores all events. It can be embedded by implementations only
+// interested in some of the events.
+type Nop struct{}
+
+// OnPublish does nothing.
+func (Nop) OnPublish(context.Context, Info, substrate.Message) {}
+
+// OnAck does nothing.
+func (Nop) OnAck(context.Context, Info, substrate.Message) {}
+
+// OnError does nothing.
+func (Nop) OnError(context.Context, Info, error) {}
+
+// OnReconnect does nothing.
+func (Nop) OnReconnect(context.Context, Info, int, error) {}
+
+// Multi returns hooks passing every event on to all of the provided hooks, in order.
+func Multi(hooks ...Hooks) Hooks {
+	return multiHooks(hooks)
+}
+
+type multiHooks []Hooks
+
+func (m multiHooks) OnPublish(ctx context.Context, info Info, msg substrate.Message) {
+	for _, h := range m {
+		h.OnPublish(ctx, info, msg)
+	}
+}
+
+func (m multiHooks) OnAck(ctx context.Context, info Info, msg substrate.Message) {
+	for _, h := range m {
+		h.OnAck(ctx, info, msg)
+	}
+}
+
+func (m multiHooks) OnError(ctx context.Context, info Info, err error) {
+	for _, h := range m {
+		h.OnError(ctx, info, err)
+	}
+}
+
+func (m multiHooks) OnReconnect(ctx context.Context, info Info, attempt int, err error) {
+	for _, h := range m {
+		h.OnReconnect(ctx, info, attempt, err)
+	}
+}
+
+type contextKey struct{}
+
+// WithHooks returns a context carrying the hooks, which wrappers called with it, or a context derived from it,
+// emit their events into.
+func WithHooks(ctx context.Context, hooks Hooks) context.Context {
+	return context.WithValue(ctx, contextKey{}, hooks)
+}
+
+// FromContext returns the hooks carried by the context, or Nop if there are none.
+func FromContext(ctx context.Context) Hooks {
+	if hooks, ok := ctx.Value(contextKey{}).(Hooks); ok {
+		return hooks
+	}
+	return Nop{}
+}
@@ -0,0 +1,55 @@
+package events
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+)
+
+// The values of the "event" label of the counter of the Prometheus hooks.
+const (
+	EventPublish   = "publish"
+	EventAck       = "ack"
+	EventError     = "error"
+	EventReconnect = "reconnect"
+)
+
+var eventLabels = []string{"event", "component", "topic"}
+
+// NewPrometheusHooks returns hooks counting the events in a counter named "<namespace>_events_total", labelled
+// with event, component and topic. The counter is registered with the registerer, or the default one if it is
+// nil, and shared by all the hooks created with the same registerer and namespace. It panics in case it can't
+// register the metric.
+func NewPrometheusHooks(registerer prometheus.Registerer, namespace string) Hooks {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName(namespace, "", "events_total"),
+		Help: "Number of events emitted by sinks, sources and wrappers.",
+	}, eventLabels)
+	return prometheusHooks{counter: metrics.RegisterWith(registerer, counter).(*prometheus.CounterVec)}
+}
+
+type prometheusHooks struct {
+	counter *prometheus.CounterVec
+}
+
+func (h prometheusHooks) OnPublish(_ context.Context, info Info, _ substrate.Message) {
+	h.counter.WithLabelValues(EventPublish, info.Component, info.Topic).Inc()
+}
+
+func (h prometheusHooks) OnAck(_ context.Context, info Info, _ substrate.Message) {
+	h.counter.WithLabelValues(EventAck, info.Component, info.Topic).Inc()
+}
+
+func (h prometheusHooks) OnError(_ context.Context, info Info, _ error) {
+	h.counter.WithLabelValues(EventError, info.Component, info.Topic).Inc()
+}
+
+func (h prometheusHooks) OnReconnect(_ context.Context, info Info, _ int, _ error) {
+	h.counter.WithLabelValues(EventReconnect, info.Component, info.Topic).Inc()
+}
@@ -0,0 +1,42 @@
+package events
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/uw-labs/substrate"
+)
+
+// NewSlogHooks returns hooks logging every event with the logger. Publishing and acknowledgements are logged at
+// debug level along with the payload size, reconnections at warning level and errors at error level.
+func NewSlogHooks(logger *slog.Logger) Hooks {
+	return slogHooks{logger: logger}
+}
+
+type slogHooks struct {
+	logger *slog.Logger
+}
+
+func (h slogHooks) OnPublish(ctx context.Context, info Info, msg substrate.Message) {
+	h.log(ctx, slog.LevelDebug, "message published", info, slog.Int("size", len(msg.Data())))
+}
+
+func (h slogHooks) OnAck(ctx context.Context, info Info, msg substrate.Message) {
+	h.log(ctx, slog.LevelDebug, "message acknowledged", info, slog.Int("size", len(msg.Data())))
+}
+
+func (h slogHooks) OnError(ctx context.Context, info Info, err error) {
+	h.log(ctx, slog.LevelError, "operation failed", info, slog.Any("error", err))
+}
+
+func (h slogHooks) OnReconnect(ctx context.Context, info Info, attempt int, err error) {
+	h.log(ctx, slog.LevelWarn, "reconnected", info, slog.Int("attempt", attempt), slog.Any("error", err))
+}
+
+func (h slogHooks) log(ctx context.Context, level slog.Level, msg string, info Info, attrs ...slog.Attr) {
+	if !h.logger.Enabled(ctx, level) {
+		return
+	}
+	attrs = append([]slog.Attr{slog.String("component", info.Component), slog.String("topic", info.Topic)}, attrs...)
+	h.logger.LogAttrs(ctx, level, msg, attrs...)
+}
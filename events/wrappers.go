@@ -0,0 +1,138 @@
+package events
+
+import (
+	"context"
+	"errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that emits an event into the hooks for
+// every message it publishes and acknowledges, and when publishing fails. If the hooks are nil, the hooks
+// carried by the context passed to PublishMessages are used.
+func NewAsyncMessageSink(sink substrate.AsyncMessageSink, hooks Hooks, info Info) substrate.AsyncMessageSink {
+	return &eventSink{impl: sink, hooks: hooks, info: info}
+}
+
+type eventSink struct {
+	impl  substrate.AsyncMessageSink
+	hooks Hooks
+	info  Info
+}
+
+// PublishMessages publishes messages to the underlying sink, emitting events.
+func (s *eventSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	hooks := s.hooks
+	if hooks == nil {
+		hooks = FromContext(ctx)
+	}
+	rg, groupCtx := rungroup.New(ctx)
+
+	toPublish, published := make(chan substrate.Message, cap(messages)), make(chan substrate.Message, cap(acks))
+	rg.Go(func() error {
+		err := s.impl.PublishMessages(groupCtx, published, toPublish)
+		if isFailure(err) {
+			hooks.OnError(ctx, s.info, err)
+		}
+		return err
+	})
+	rg.Go(func() error {
+		return forward(groupCtx, messages, toPublish, func(msg substrate.Message) {
+			hooks.OnPublish(ctx, s.info, msg)
+		})
+	})
+	rg.Go(func() error {
+		return forward(groupCtx, published, acks, func(msg substrate.Message) {
+			hooks.OnAck(ctx, s.info, msg)
+		})
+	})
+
+	return rg.Wait()
+}
+
+// Close closes the underlying sink.
+func (s *eventSink) Close() error {
+	return s.impl.Close()
+}
+
+// Status returns the status of the underlying sink.
+func (s *eventSink) Status() (*substrate.Status, error) {
+	return s.impl.Status()
+}
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that emits an event into the hooks for
+// every message it delivers and every acknowledgement it receives, and when consuming fails. If the hooks are nil,
+// the hooks carried by the context passed to ConsumeMessages are used.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, hooks Hooks, info Info) substrate.AsyncMessageSource {
+	return &eventSource{impl: source, hooks: hooks, info: info}
+}
+
+type eventSource struct {
+	impl  substrate.AsyncMessageSource
+	hooks Hooks
+	info  Info
+}
+
+// ConsumeMessages consumes messages from the underlying source, emitting events.
+func (s *eventSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	hooks := s.hooks
+	if hooks == nil {
+		hooks = FromContext(ctx)
+	}
+	rg, groupCtx := rungroup.New(ctx)
+
+	consumed, toAck := make(chan substrate.Message, cap(messages)), make(chan substrate.Message, cap(acks))
+	rg.Go(func() error {
+		err := s.impl.ConsumeMessages(groupCtx, consumed, toAck)
+		if isFailure(err) {
+			hooks.OnError(ctx, s.info, err)
+		}
+		return err
+	})
+	rg.Go(func() error {
+		return forward(groupCtx, consumed, messages, func(msg substrate.Message) {
+			hooks.OnPublish(ctx, s.info, msg)
+		})
+	})
+	rg.Go(func() error {
+		return forward(groupCtx, acks, toAck, func(msg substrate.Message) {
+			hooks.OnAck(ctx, s.info, msg)
+		})
+	})
+
+	return rg.Wait()
+}
+
+// Close closes the underlying source.
+func (s *eventSource) Close() error {
+	return s.impl.Close()
+}
+
+// Status returns the status of the underlying source.
+func (s *eventSource) Status() (*substrate.Status, error) {
+	return s.impl.Status()
+}
+
+// forward passes messages from one channel to the other, calling emit for each of them, until the context is
+// cancelled.
+func forward(ctx context.Context, from <-chan substrate.Message, to chan<- substrate.Message, emit func(substrate.Message)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-from:
+			emit(msg)
+			select {
+			case <-ctx.Done():
+				return nil
+			case to <- msg:
+			}
+		}
+	}
+}
+
+// isFailure reports whether the error is a failure, rather than the result of the context being cancelled.
+func isFailure(err error) bool {
+	return err != nil && !errors.Is(err, context.Canceled)
+}
@@ -0,0 +1,395 @@
+// Package filesink provides a message sink appending messages to rotating files, which are synced before messages
+// are acknowledged, making it an easy terminal for archiving or debugging pipelines.
+package filesink
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+const (
+	defaultPrefix   = "messages"
+	defaultMaxSize  = 64 << 20
+	defaultMaxBatch = 100
+
+	// tmpSuffix is the suffix of the file being written, which is removed once it is complete.
+	tmpSuffix = ".tmp"
+)
+
+// Format is the format in which messages are written to files.
+type Format int
+
+const (
+	// LengthPrefixed writes the payload of each message prefixed by its length, as a 4 byte big endian integer.
+	// Headers aren't written. Files have the ".bin" extension.
+	LengthPrefixed Format = iota
+	// NDJSON writes each message as a JSON encoded Record on its own line. Files have the ".ndjson" extension.
+	NDJSON
+)
+
+// Record is the format of the messages written by the sink in the NDJSON format.
+type Record struct {
+	// Time is the time at which the message was written.
+	Time time.Time `json:"time"`
+	// Data is the payload of the message.
+	Data []byte `json:"data"`
+	// Headers are the headers of the message, if it carried any.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Option is a function which sets a file sink configuration option.
+type Option func(s *fileSink)
+
+// WithFormat sets the format in which messages are written. The default format is LengthPrefixed.
+func WithFormat(format Format) Option {
+	return func(s *fileSink) {
+		s.format = format
+	}
+}
+
+// WithPrefix sets the prefix of the names of the files. The default prefix is "messages".
+func WithPrefix(prefix string) Option {
+	return func(s *fileSink) {
+		s.prefix = prefix
+	}
+}
+
+// WithMaxSize sets the size in bytes of the records written to a file, before compression, after which a new file
+// is started. The default value is 64MiB.
+func WithMaxSize(size int64) Option {
+	return func(s *fileSink) {
+		s.maxSize = size
+	}
+}
+
+// WithMaxAge sets how long after it was started a file is completed, even if it didn't reach the maximum size.
+// Files are only rotated by size by default.
+func WithMaxAge(age time.Duration) Option {
+	return func(s *fileSink) {
+		s.maxAge = age
+	}
+}
+
+// WithCompression makes the sink compress files using gzip, which adds the ".gz" extension to their names.
+func WithCompression() Option {
+	return func(s *fileSink) {
+		s.compress = true
+	}
+}
+
+// WithMaxBatch sets the maximum number of messages written before the file is synced and the messages are
+// acknowledged. Messages are written in batches of those available without waiting. The default value is 100.
+func WithMaxBatch(size int) Option {
+	return func(s *fileSink) {
+		s.maxBatch = size
+	}
+}
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that appends messages to files in the
+// directory, which is created if it doesn't exist. Files are synced before messages are acknowledged, so that
+// acknowledged messages are never lost. The file being written has the ".tmp" suffix, which is removed once the
+// file is complete, i.e. when it is rotated or the sink is closed. Files left incomplete by a crash are completed
+// when a new sink is created for the directory and prefix.
+func NewAsyncMessageSink(dir string, opts ...Option) (substrate.AsyncMessageSink, error) {
+	s := &fileSink{
+		dir:      dir,
+		prefix:   defaultPrefix,
+		maxSize:  defaultMaxSize,
+		maxBatch: defaultMaxBatch,
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "failed to create directory")
+	}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+type fileSink struct {
+	dir      string
+	prefix   string
+	format   Format
+	maxSize  int64
+	maxAge   time.Duration
+	compress bool
+	maxBatch int
+	now      func() time.Time
+
+	mutex  sync.Mutex
+	closed bool
+	seq    int
+	file   *file
+}
+
+// file is the file being written.
+type file struct {
+	path    string
+	f       *os.File
+	gz      *gzip.Writer
+	w       *bufio.Writer
+	size    int64
+	started time.Time
+}
+
+// recover completes the files left incomplete by a previous sink.
+func (s *fileSink) recover() error {
+	paths, err := filepath.Glob(filepath.Join(s.dir, s.prefix+"-*"+tmpSuffix))
+	if err != nil {
+		return errors.Wrap(err, "failed to list incomplete files")
+	}
+	for _, path := range paths {
+		if err := os.Rename(path, strings.TrimSuffix(path, tmpSuffix)); err != nil {
+			return errors.Wrap(err, "failed to complete file")
+		}
+	}
+	return nil
+}
+
+// PublishMessages writes messages to the current file, acknowledging them once it is synced.
+func (s *fileSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "filesink"})
+	var tick <-chan time.Time
+	if s.maxAge > 0 {
+		ticker := time.NewTicker(s.maxAge / 4)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick:
+			if err := s.rotateExpired(); err != nil {
+				return emit.Error(err)
+			}
+		case msg := <-messages:
+			batch, err := s.writeBatch(emit, msg, messages)
+			if err != nil {
+				return emit.Error(err)
+			}
+			for _, msg := range batch {
+				emit.Ack(msg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case acks <- msg:
+				}
+			}
+		}
+	}
+}
+
+// writeBatch writes the message along with the messages available without waiting and syncs the file.
+func (s *fileSink) writeBatch(emit events.Emitter, msg substrate.Message, messages <-chan substrate.Message) ([]substrate.Message, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil, errors.New("sink already closed")
+	}
+
+	batch := []substrate.Message{msg}
+	emit.Publish(msg)
+	if err := s.write(msg); err != nil {
+		return nil, err
+	}
+batch:
+	for len(batch) < s.maxBatch {
+		select {
+		case msg := <-messages:
+			emit.Publish(msg)
+			if err := s.write(msg); err != nil {
+				return nil, err
+			}
+			batch = append(batch, msg)
+		default:
+			break batch
+		}
+	}
+
+	if err := s.file.sync(); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// write writes the message to the current file, starting a new one if it is full or too old.
+func (s *fileSink) write(msg substrate.Message) error {
+	data, err := s.encode(msg)
+	if err != nil {
+		return err
+	}
+	if s.file != nil && s.file.size > 0 && (s.file.size+int64(len(data)) > s.maxSize || s.expired()) {
+		if err := s.complete(); err != nil {
+			return err
+		}
+	}
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.file.w.Write(data); err != nil {
+		return errors.Wrap(err, "failed to write message")
+	}
+	s.file.size += int64(len(data))
+	return nil
+}
+
+// encode returns the record of the message in the format of the sink.
+func (s *fileSink) encode(msg substrate.Message) ([]byte, error) {
+	switch s.format {
+	case LengthPrefixed:
+		data := make([]byte, 4, 4+len(msg.Data()))
+		binary.BigEndian.PutUint32(data, uint32(len(msg.Data())))
+		return append(data, msg.Data()...), nil
+	case NDJSON:
+		record := Record{Time: s.now().UTC(), Data: msg.Data()}
+		if headers := message.Headers(msg); len(headers) > 0 {
+			record.Headers = headers
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to encode message")
+		}
+		return append(data, '\n'), nil
+	default:
+		return nil, errors.Errorf("unknown format %d", s.format)
+	}
+}
+
+func (s *fileSink) expired() bool {
+	return s.maxAge > 0 && s.now().Sub(s.file.started) >= s.maxAge
+}
+
+// rotateExpired completes the current file if it is too old.
+func (s *fileSink) rotateExpired() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file == nil || !s.expired() {
+		return nil
+	}
+	return s.complete()
+}
+
+// open starts a new file.
+func (s *fileSink) open() error {
+	now := s.now().UTC()
+	s.seq++
+	name := fmt.Sprintf("%s-%s-%06d%s", s.prefix, now.Format("20060102T150405.000000000Z"), s.seq, s.extension())
+	path := filepath.Join(s.dir, name)
+
+	f, err := os.OpenFile(path+tmpSuffix, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "failed to create file")
+	}
+	s.file = &file{path: path, f: f, started: now}
+	var w io.Writer = f
+	if s.compress {
+		s.file.gz = gzip.NewWriter(f)
+		w = s.file.gz
+	}
+	s.file.w = bufio.NewWriter(w)
+	return nil
+}
+
+func (s *fileSink) extension() string {
+	ext := ".bin"
+	if s.format == NDJSON {
+		ext = ".ndjson"
+	}
+	if s.compress {
+		ext += ".gz"
+	}
+	return ext
+}
+
+// complete syncs and closes the current file and removes its temporary suffix.
+func (s *fileSink) complete() error {
+	f := s.file
+	s.file = nil
+
+	if err := f.w.Flush(); err != nil {
+		_ = f.f.Close()
+		return errors.Wrap(err, "failed to write file")
+	}
+	if f.gz != nil {
+		if err := f.gz.Close(); err != nil {
+			_ = f.f.Close()
+			return errors.Wrap(err, "failed to write file")
+		}
+	}
+	if err := f.f.Sync(); err != nil {
+		_ = f.f.Close()
+		return errors.Wrap(err, "failed to sync file")
+	}
+	if err := f.f.Close(); err != nil {
+		return errors.Wrap(err, "failed to close file")
+	}
+	if err := os.Rename(f.path+tmpSuffix, f.path); err != nil {
+		return errors.Wrap(err, "failed to complete file")
+	}
+	return nil
+}
+
+// sync flushes the records written to the file and syncs it to disk.
+func (f *file) sync() error {
+	if err := f.w.Flush(); err != nil {
+		return errors.Wrap(err, "failed to write file")
+	}
+	if f.gz != nil {
+		if err := f.gz.Flush(); err != nil {
+			return errors.Wrap(err, "failed to write file")
+		}
+	}
+	if err := f.f.Sync(); err != nil {
+		return errors.Wrap(err, "failed to sync file")
+	}
+	return nil
+}
+
+// Close completes the current file.
+func (s *fileSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+	if s.file == nil {
+		return nil
+	}
+	return s.complete()
+}
+
+// Status reports working status until the sink is closed.
+func (s *fileSink) Status() (*substrate.Status, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return &substrate.Status{Working: false, Problems: []string{"sink already closed"}}, nil
+	}
+	return &substrate.Status{Working: true}, nil
+}
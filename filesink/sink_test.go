@@ -0,0 +1,234 @@
+package filesink_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/filesink"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+func publish(t *testing.T, sink substrate.AsyncMessageSink, msgs ...substrate.Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	for _, msg := range msgs {
+		messages <- msg
+		require.Equal(t, msg, <-acks)
+	}
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func files(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func open(t *testing.T, path string) io.Reader {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	if strings.HasSuffix(path, ".gz") {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		require.NoError(t, err)
+		data, err = io.ReadAll(r)
+		require.NoError(t, err)
+	}
+	return bytes.NewReader(data)
+}
+
+func readLengthPrefixed(t *testing.T, path string) []string {
+	r := open(t, path)
+
+	var payloads []string
+	for {
+		var size uint32
+		err := binary.Read(r, binary.BigEndian, &size)
+		if err == io.EOF {
+			return payloads
+		}
+		require.NoError(t, err)
+		payload := make([]byte, size)
+		_, err = io.ReadFull(r, payload)
+		require.NoError(t, err)
+		payloads = append(payloads, string(payload))
+	}
+}
+
+func readNDJSON(t *testing.T, path string) []filesink.Record {
+	var records []filesink.Record
+	scanner := bufio.NewScanner(open(t, path))
+	for scanner.Scan() {
+		var record filesink.Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.NoError(t, scanner.Err())
+	return records
+}
+
+func TestFileSink_LengthPrefixed(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := filesink.NewAsyncMessageSink(dir)
+	require.NoError(t, err)
+
+	publish(t, sink, message.FromString("one"), message.FromString("two"))
+
+	names := files(t, dir)
+	require.Len(t, names, 1)
+	require.True(t, strings.HasSuffix(names[0], ".bin.tmp"))
+	// Acknowledged messages are synced to the incomplete file.
+	require.Equal(t, []string{"one", "two"}, readLengthPrefixed(t, filepath.Join(dir, names[0])))
+
+	require.NoError(t, sink.Close())
+	names = files(t, dir)
+	require.Len(t, names, 1)
+	require.True(t, strings.HasPrefix(names[0], "messages-"))
+	require.True(t, strings.HasSuffix(names[0], ".bin"))
+	require.Equal(t, []string{"one", "two"}, readLengthPrefixed(t, filepath.Join(dir, names[0])))
+
+	status, err := sink.Status()
+	require.NoError(t, err)
+	require.False(t, status.Working)
+}
+
+func TestFileSink_NDJSON(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := filesink.NewAsyncMessageSink(dir, filesink.WithFormat(filesink.NDJSON), filesink.WithPrefix("orders"))
+	require.NoError(t, err)
+
+	publish(t, sink,
+		message.FromString("one"),
+		message.NewEnvelopedMessage(message.Envelope{
+			Headers: map[string]string{"key": "value"},
+			Payload: []byte("two"),
+		}),
+	)
+	require.NoError(t, sink.Close())
+
+	names := files(t, dir)
+	require.Len(t, names, 1)
+	require.True(t, strings.HasPrefix(names[0], "orders-"))
+	require.True(t, strings.HasSuffix(names[0], ".ndjson"))
+
+	records := readNDJSON(t, filepath.Join(dir, names[0]))
+	require.Len(t, records, 2)
+	require.Equal(t, []byte("one"), records[0].Data)
+	require.Nil(t, records[0].Headers)
+	require.False(t, records[0].Time.IsZero())
+	require.Equal(t, []byte("two"), records[1].Data)
+	require.Equal(t, map[string]string{"key": "value"}, records[1].Headers)
+}
+
+func TestFileSink_RotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	// Each record takes 7 bytes, so that two of them fit in a file.
+	sink, err := filesink.NewAsyncMessageSink(dir, filesink.WithMaxSize(14))
+	require.NoError(t, err)
+
+	publish(t, sink,
+		message.FromString("one"),
+		message.FromString("two"),
+		message.FromString("six"),
+		message.FromString("ten"),
+		message.FromString("big message"),
+	)
+	require.NoError(t, sink.Close())
+
+	var payloads [][]string
+	for _, name := range files(t, dir) {
+		payloads = append(payloads, readLengthPrefixed(t, filepath.Join(dir, name)))
+	}
+	require.Equal(t, [][]string{{"one", "two"}, {"six", "ten"}, {"big message"}}, payloads)
+}
+
+func TestFileSink_RotatesByAge(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := filesink.NewAsyncMessageSink(dir, filesink.WithMaxAge(time.Millisecond*50))
+	require.NoError(t, err)
+	defer sink.Close()
+
+	publish(t, sink, message.FromString("one"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		errs <- sink.PublishMessages(ctx, make(chan substrate.Message), make(chan substrate.Message))
+	}()
+
+	// The file is completed while the sink is idle.
+	require.Eventually(t, func() bool {
+		names := files(t, dir)
+		return len(names) == 1 && strings.HasSuffix(names[0], ".bin")
+	}, time.Second, time.Millisecond*10)
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestFileSink_Compression(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := filesink.NewAsyncMessageSink(dir, filesink.WithFormat(filesink.NDJSON), filesink.WithCompression())
+	require.NoError(t, err)
+
+	publish(t, sink, message.FromString("one"), message.FromString("two"))
+	require.NoError(t, sink.Close())
+
+	names := files(t, dir)
+	require.Len(t, names, 1)
+	require.True(t, strings.HasSuffix(names[0], ".ndjson.gz"))
+
+	records := readNDJSON(t, filepath.Join(dir, names[0]))
+	require.Len(t, records, 2)
+	require.Equal(t, []byte("one"), records[0].Data)
+	require.Equal(t, []byte("two"), records[1].Data)
+}
+
+func TestFileSink_CompletesIncompleteFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "messages-1.bin.tmp"), nil, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other-1.bin.tmp"), nil, 0o644))
+
+	_, err := filesink.NewAsyncMessageSink(dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"messages-1.bin", "other-1.bin.tmp"}, files(t, dir))
+}
+
+func TestFileSink_FailsWhenClosed(t *testing.T) {
+	sink, err := filesink.NewAsyncMessageSink(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, sink.Close())
+
+	messages := make(chan substrate.Message, 1)
+	messages <- message.FromString("one")
+	err = sink.PublishMessages(context.Background(), make(chan substrate.Message), messages)
+	require.EqualError(t, err, "sink already closed")
+}
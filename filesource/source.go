@@ -0,0 +1,423 @@
+// Package filesource provides a message source reading records from the files of a directory, optionally
+// following it for new records, e.g. to ingest logs or to replay the archives of the file sink.
+package filesource
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/checkpoint"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/filesink"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+const defaultInterval = time.Second
+
+// Format is the format in which records are stored in files.
+type Format int
+
+const (
+	// LengthPrefixed reads payloads prefixed by their length, as a 4 byte big endian integer, as written by the
+	// file sink in its LengthPrefixed format.
+	LengthPrefixed Format = iota
+	// NDJSON reads lines of JSON encoded filesink.Record, as written by the file sink in its NDJSON format.
+	NDJSON
+	// Lines reads each line as the payload of a message. Empty lines are skipped.
+	Lines
+)
+
+// Option is a function which sets a file source configuration option.
+type Option func(s *fileSource)
+
+// WithFormat sets the format of the records. The default format is LengthPrefixed.
+func WithFormat(format Format) Option {
+	return func(s *fileSource) {
+		s.format = format
+	}
+}
+
+// WithPattern sets the pattern, as accepted by filepath.Match, that the names of the files to read must match.
+// All files are read by default. To read the archives of a file sink, the pattern should exclude the file being
+// written, e.g. "messages-*.ndjson".
+func WithPattern(pattern string) Option {
+	return func(s *fileSource) {
+		s.pattern = pattern
+	}
+}
+
+// WithFollow makes the source follow the directory, checking for new records appended to the last file and for new
+// files at the provided interval, instead of stopping at the end of the last file. The default interval is 1 second.
+func WithFollow(interval time.Duration) Option {
+	return func(s *fileSource) {
+		s.follow = true
+		if interval > 0 {
+			s.interval = interval
+		}
+	}
+}
+
+// WithCheckpoint makes the source resume after the positions recorded in the checkpoint, which maps the names of
+// files to the offset of the last record consumed from them, e.g. as loaded from the store of a checkpoint source.
+func WithCheckpoint(cp checkpoint.Checkpoint) Option {
+	return func(s *fileSource) {
+		s.start = cp
+	}
+}
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that reads the records of the files in
+// the directory, in the order of their names, and delivers them as messages. Files whose names end with ".gz" are
+// decompressed, and are expected to be complete. Messages implement checkpoint.Positioned, with the name of their
+// file as the partition and the offset of their record, before decompression, within it, so the source can be
+// wrapped by a checkpoint source to resume from the last acknowledged record after a restart. It returns an error
+// if the pattern is malformed.
+func NewAsyncMessageSource(dir string, opts ...Option) (substrate.AsyncMessageSource, error) {
+	s := &fileSource{
+		dir:      dir,
+		pattern:  "*",
+		interval: defaultInterval,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if _, err := filepath.Match(s.pattern, ""); err != nil {
+		return nil, errors.Wrap(err, "invalid pattern")
+	}
+	return s, nil
+}
+
+type fileSource struct {
+	dir      string
+	pattern  string
+	format   Format
+	follow   bool
+	interval time.Duration
+	start    checkpoint.Checkpoint
+
+	mutex  sync.RWMutex
+	cancel func()
+	closed bool
+}
+
+// ConsumeMessages reads the records of the files, and then either follows the directory or waits for the context
+// to be cancelled.
+func (s *fileSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "filesource"})
+	ctx, cancel, err := s.init(ctx)
+	if err != nil {
+		return emit.Error(err)
+	}
+	defer cancel()
+
+	rg, ctx := rungroup.New(ctx)
+
+	rg.Go(emit.Errors(func() error {
+		var last string
+		for {
+			name, err := s.next(last)
+			if err != nil {
+				return err
+			}
+			if name == "" {
+				if !s.follow {
+					<-ctx.Done()
+					return nil
+				}
+				if !s.sleep(ctx) {
+					return nil
+				}
+				continue
+			}
+			if err := s.read(ctx, emit, name, messages); err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			last = name
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				if _, ok := ack.(*fileMessage); !ok {
+					return errors.Errorf("unexpected message acknowledged: %v", ack)
+				}
+				emit.Ack(ack)
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+// next returns the name of the first file following the last one that was read, or an empty string if there is none.
+func (s *fileSource) next(last string) (string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to list files")
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name() <= last {
+			continue
+		}
+		if ok, _ := filepath.Match(s.pattern, entry.Name()); ok {
+			names = append(names, entry.Name())
+		}
+	}
+	if len(names) == 0 {
+		return "", nil
+	}
+	sort.Strings(names)
+	return names[0], nil
+}
+
+// read delivers the records of the file, following it until a later file appears if the source follows the directory.
+func (s *fileSource) read(ctx context.Context, emit events.Emitter, name string, messages chan<- substrate.Message) error {
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return errors.Wrap(err, "failed to open file")
+	}
+	defer f.Close()
+
+	compressed := strings.HasSuffix(name, ".gz")
+	offset, skip := s.start[name]
+	if !skip {
+		offset = 0
+	}
+
+	var r *bufio.Reader
+	reset := func() error {
+		if !compressed {
+			if _, err := f.Seek(offset, io.SeekStart); err != nil {
+				return errors.Wrapf(err, "failed to seek file %s", name)
+			}
+			r = bufio.NewReader(f)
+			return nil
+		}
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return errors.Wrapf(err, "failed to decompress file %s", name)
+		}
+		r = bufio.NewReader(gz)
+		if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+			return errors.Wrapf(err, "failed to seek file %s", name)
+		}
+		return nil
+	}
+	if err := reset(); err != nil {
+		return err
+	}
+
+	for {
+		record, err := s.readRecord(r)
+		switch {
+		case err == nil:
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			done, nErr := s.done(name, compressed)
+			if nErr != nil {
+				return nErr
+			}
+			if done {
+				if err == io.ErrUnexpectedEOF && s.format == LengthPrefixed {
+					return errors.Errorf("truncated record at offset %d of file %s", offset, name)
+				}
+				if err == io.EOF || skip {
+					return nil
+				}
+				// the last line of a complete file doesn't need to be terminated
+			} else {
+				if !s.sleep(ctx) {
+					return nil
+				}
+				if err := reset(); err != nil {
+					return err
+				}
+				continue
+			}
+		default:
+			return errors.Wrapf(err, "failed to read file %s", name)
+		}
+
+		size := int64(len(record))
+		if skip {
+			skip = false
+			offset += size
+			continue
+		}
+		msg, dErr := s.decode(record)
+		if dErr != nil {
+			return errors.Wrapf(dErr, "failed to decode record at offset %d of file %s", offset, name)
+		}
+		if msg != nil {
+			msg.file, msg.offset = name, offset
+			emit.Publish(msg)
+			select {
+			case <-ctx.Done():
+				return nil
+			case messages <- msg:
+			}
+		}
+		offset += size
+		if err == io.ErrUnexpectedEOF {
+			return nil
+		}
+	}
+}
+
+// done reports whether the file was completely read once its end was reached.
+func (s *fileSource) done(name string, compressed bool) (bool, error) {
+	if !s.follow || compressed {
+		return true, nil
+	}
+	next, err := s.next(name)
+	return next != "", err
+}
+
+// readRecord reads the next record, including its framing. It returns io.EOF if there are no more records, and
+// io.ErrUnexpectedEOF along with the data read if the last record is incomplete.
+func (s *fileSource) readRecord(r *bufio.Reader) ([]byte, error) {
+	if s.format != LengthPrefixed {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) > 0 {
+			return line, io.ErrUnexpectedEOF
+		}
+		return line, err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	record := make([]byte, 4+binary.BigEndian.Uint32(header))
+	copy(record, header)
+	if _, err := io.ReadFull(r, record[4:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return record, nil
+}
+
+// decode returns the message of the record, or nil if the record is an empty line.
+func (s *fileSource) decode(record []byte) (*fileMessage, error) {
+	switch s.format {
+	case LengthPrefixed:
+		return &fileMessage{data: record[4:]}, nil
+	case NDJSON, Lines:
+		line := strings.TrimRight(string(record), "\r\n")
+		if line == "" {
+			return nil, nil
+		}
+		if s.format == Lines {
+			return &fileMessage{data: []byte(line)}, nil
+		}
+		var rec filesink.Record
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			return nil, err
+		}
+		return &fileMessage{data: rec.Data, headers: rec.Headers}, nil
+	default:
+		return nil, errors.Errorf("unknown format %d", s.format)
+	}
+}
+
+// sleep waits for the interval, reporting false if the context was cancelled first.
+func (s *fileSource) sleep(ctx context.Context) bool {
+	timer := time.NewTimer(s.interval)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (s *fileSource) init(ctx context.Context) (context.Context, func(), error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil, nil, errors.New("source already closed")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s.cancel = cancel
+	return ctx, cancel, nil
+}
+
+// Close closes the source, it causes any running call to ConsumeMessages to return.
+func (s *fileSource) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.cancel != nil {
+		s.cancel()
+	}
+	return nil
+}
+
+// Status returns the status of the source. It reports not working after the source was closed, or if the
+// directory can't be read.
+func (s *fileSource) Status() (*substrate.Status, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.closed {
+		return &substrate.Status{Working: false, Problems: []string{"source already closed"}}, nil
+	}
+	if _, err := os.Stat(s.dir); err != nil {
+		return &substrate.Status{Working: false, Problems: []string{err.Error()}}, nil
+	}
+	return &substrate.Status{Working: true}, nil
+}
+
+type fileMessage struct {
+	data    []byte
+	headers map[string]string
+	file    string
+	offset  int64
+}
+
+func (msg *fileMessage) Data() []byte {
+	return msg.data
+}
+
+// Headers returns the headers of a message read in the NDJSON format.
+func (msg *fileMessage) Headers() map[string]string {
+	return msg.headers
+}
+
+// Partition returns the name of the file the message was read from.
+func (msg *fileMessage) Partition() string {
+	return msg.file
+}
+
+// Offset returns the offset of the record of the message within its file.
+func (msg *fileMessage) Offset() int64 {
+	return msg.offset
+}
@@ -0,0 +1,236 @@
+package filesource_test
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/checkpoint"
+	"github.com/uw-labs/substrate-tools/filesink"
+	"github.com/uw-labs/substrate-tools/filesource"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+func consume(t *testing.T, source substrate.AsyncMessageSource, count int) []substrate.Message {
+	return consumeUntil(t, source, count, func() bool { return true })
+}
+
+// consumeUntil consumes the messages and keeps the source running until the condition is met.
+func consumeUntil(t *testing.T, source substrate.AsyncMessageSource, count int, condition func() bool) []substrate.Message {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	var consumed []substrate.Message
+	for i := 0; i < count; i++ {
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "failed to consume all messages")
+		case msg := <-messages:
+			consumed = append(consumed, msg)
+			acks <- msg
+		}
+	}
+	require.Eventually(t, condition, time.Second, time.Millisecond*10)
+	cancel()
+	require.NoError(t, <-errs)
+	return consumed
+}
+
+func payloads(msgs []substrate.Message) []string {
+	var payloads []string
+	for _, msg := range msgs {
+		payloads = append(payloads, string(msg.Data()))
+	}
+	return payloads
+}
+
+func write(t *testing.T, path string, data string) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func lengthPrefixed(payloads ...string) string {
+	var data []byte
+	for _, payload := range payloads {
+		data = binary.BigEndian.AppendUint32(data, uint32(len(payload)))
+		data = append(data, payload...)
+	}
+	return string(data)
+}
+
+func TestFileSource_ReadsFileSinkArchives(t *testing.T) {
+	for name, opts := range map[string][]filesink.Option{
+		"ndjson":     {filesink.WithFormat(filesink.NDJSON)},
+		"compressed": {filesink.WithFormat(filesink.NDJSON), filesink.WithCompression()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			sink, err := filesink.NewAsyncMessageSink(dir, append(opts, filesink.WithMaxSize(100))...)
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			acks, messages := make(chan substrate.Message), make(chan substrate.Message, 3)
+			errs := make(chan error)
+			go func() {
+				errs <- sink.PublishMessages(ctx, acks, messages)
+			}()
+			messages <- message.FromString("one")
+			messages <- message.NewEnvelopedMessage(message.Envelope{
+				Headers: map[string]string{"key": "value"},
+				Payload: []byte("two"),
+			})
+			messages <- message.FromString("three")
+			for i := 0; i < 3; i++ {
+				<-acks
+			}
+			cancel()
+			require.NoError(t, <-errs)
+			require.NoError(t, sink.Close())
+
+			source, err := filesource.NewAsyncMessageSource(dir, filesource.WithFormat(filesource.NDJSON))
+			require.NoError(t, err)
+			consumed := consume(t, source, 3)
+			require.Equal(t, []string{"one", "two", "three"}, payloads(consumed))
+			require.Equal(t, map[string]string{"key": "value"}, message.Headers(consumed[1]))
+		})
+	}
+}
+
+func TestFileSource_LengthPrefixed(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "a.bin"), lengthPrefixed("one", "two"))
+	write(t, filepath.Join(dir, "b.bin"), lengthPrefixed("three"))
+	write(t, filepath.Join(dir, "c.txt"), "ignored")
+
+	source, err := filesource.NewAsyncMessageSource(dir, filesource.WithPattern("*.bin"))
+	require.NoError(t, err)
+
+	consumed := consume(t, source, 3)
+	require.Equal(t, []string{"one", "two", "three"}, payloads(consumed))
+
+	positions := make([]string, 0, len(consumed))
+	for _, msg := range consumed {
+		pMsg := msg.(checkpoint.Positioned)
+		positions = append(positions, fmt.Sprintf("%s:%d", pMsg.Partition(), pMsg.Offset()))
+	}
+	require.Equal(t, []string{"a.bin:0", "a.bin:7", "b.bin:0"}, positions)
+}
+
+func TestFileSource_FailsOnTruncatedRecord(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "a.bin"), lengthPrefixed("one")+lengthPrefixed("two")[:5])
+
+	source, err := filesource.NewAsyncMessageSource(dir)
+	require.NoError(t, err)
+
+	messages, acks := make(chan substrate.Message, 1), make(chan substrate.Message)
+	err = source.ConsumeMessages(context.Background(), messages, acks)
+	require.EqualError(t, err, "truncated record at offset 7 of file a.bin")
+}
+
+func TestFileSource_Lines(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "app.log"), "one\r\n\ntwo\nthree")
+
+	source, err := filesource.NewAsyncMessageSource(dir, filesource.WithFormat(filesource.Lines))
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"one", "two", "three"}, payloads(consume(t, source, 3)))
+}
+
+func TestFileSource_Follow(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "1.log"), "one\n")
+
+	source, err := filesource.NewAsyncMessageSource(dir,
+		filesource.WithFormat(filesource.Lines),
+		filesource.WithFollow(time.Millisecond*10),
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+	receive := func() string {
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "failed to consume message")
+		case msg := <-messages:
+			acks <- msg
+			return string(msg.Data())
+		}
+		return ""
+	}
+
+	require.Equal(t, "one", receive())
+	// An incomplete line is only delivered once it is complete.
+	write(t, filepath.Join(dir, "1.log"), "tw")
+	time.Sleep(time.Millisecond * 50)
+	write(t, filepath.Join(dir, "1.log"), "o\n")
+	require.Equal(t, "two", receive())
+	// A new file is read once the current one was read.
+	write(t, filepath.Join(dir, "2.log"), "three\n")
+	require.Equal(t, "three", receive())
+	write(t, filepath.Join(dir, "2.log"), "four\n")
+	require.Equal(t, "four", receive())
+
+	require.NoError(t, source.Close())
+	require.NoError(t, <-errs)
+}
+
+func TestFileSource_ResumesFromCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "1.log"), "one\ntwo\n")
+	write(t, filepath.Join(dir, "2.log"), "three\n")
+	store := checkpoint.NewMemoryStore()
+
+	newSource := func() substrate.AsyncMessageSource {
+		cp, err := store.Load(context.Background(), "consumer")
+		require.NoError(t, err)
+		source, err := filesource.NewAsyncMessageSource(dir,
+			filesource.WithFormat(filesource.Lines),
+			filesource.WithCheckpoint(cp),
+		)
+		require.NoError(t, err)
+		return checkpoint.NewAsyncMessageSource(source, "consumer", store, checkpoint.WithInterval(time.Millisecond*10))
+	}
+	saved := func(expected checkpoint.Checkpoint) func() bool {
+		return func() bool {
+			cp, err := store.Load(context.Background(), "consumer")
+			return err == nil && reflect.DeepEqual(expected, cp)
+		}
+	}
+
+	require.Equal(t, []string{"one", "two"}, payloads(consumeUntil(t, newSource(), 2, saved(checkpoint.Checkpoint{"1.log": 4}))))
+	require.Equal(t, []string{"three"}, payloads(consumeUntil(t, newSource(), 1, saved(checkpoint.Checkpoint{"1.log": 4, "2.log": 0}))))
+
+	write(t, filepath.Join(dir, "2.log"), "four\n")
+	require.Equal(t, []string{"four"}, payloads(consume(t, newSource(), 1)))
+}
+
+func TestFileSource_InvalidPattern(t *testing.T) {
+	_, err := filesource.NewAsyncMessageSource(t.TempDir(), filesource.WithPattern("["))
+	require.EqualError(t, err, "invalid pattern: syntax error in pattern")
+}
@@ -0,0 +1,232 @@
+// Package filter provides a message source wrapper that only delivers messages matching a predicate.
+package filter
+
+import (
+	"context"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/ackordering"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/internal/inorder"
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// Predicate is a function that reports whether a message should be delivered.
+type Predicate func(msg substrate.Message) bool
+
+// AsyncMessageSourceOption is a function which sets an AsyncMessageSource configuration option.
+type AsyncMessageSourceOption func(s *filterSource)
+
+// WithSideSink sets a sink to which messages failing the predicate are published, instead of being dropped.
+// They are acknowledged once they have been published.
+func WithSideSink(sink substrate.AsyncMessageSink) AsyncMessageSourceOption {
+	return func(s *filterSource) {
+		s.sink = sink
+	}
+}
+
+// WithDropCounter enables a counter of messages failing the predicate, labelled with the topic.
+// It panics in case it can't register the metric.
+func WithDropCounter(counterOpts prometheus.CounterOpts, topic string) AsyncMessageSourceOption {
+	return func(s *filterSource) {
+		counter := metrics.Register(prometheus.NewCounterVec(counterOpts, []string{"topic"})).(*prometheus.CounterVec)
+		s.dropped = counter.WithLabelValues(topic)
+	}
+}
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that only delivers messages for which
+// the predicate returns true. The predicate is called with the messages consumed from the underlying source. Other
+// messages are acknowledged automatically, in order with the acknowledgements of delivered messages, or published
+// to the side sink if one is set. When Close is called, both the source and the side sink are closed.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, predicate Predicate, opts ...AsyncMessageSourceOption) substrate.AsyncMessageSource {
+	s := &filterSource{
+		source:    source,
+		predicate: predicate,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return ackordering.NewAsyncMessageSource(s)
+}
+
+// filterSource receives acknowledgements in the order in which messages were consumed from the ack ordering
+// wrapper, so it only needs to order them with the acknowledgements of messages failing the predicate.
+type filterSource struct {
+	source    substrate.AsyncMessageSource
+	predicate Predicate
+	sink      substrate.AsyncMessageSink
+	dropped   prometheus.Counter
+}
+
+// Messages passing the predicate and messages published to the side sink are acknowledged independently of each
+// other, so they are matched with their acknowledgements in separate lanes.
+const (
+	deliveredLane = iota
+	sideLane
+)
+
+func (s *filterSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "filter"})
+	rg, ctx := rungroup.New(ctx)
+
+	sourceMsgs := make(chan substrate.Message, cap(messages))
+	sourceAcks := make(chan substrate.Message, cap(acks))
+	sideMsgs := make(chan substrate.Message)
+	sideAcks := make(chan substrate.Message)
+	queue := inorder.NewQueue[substrate.Message, substrate.Message]()
+
+	if s.sink != nil {
+		rg.Go(func() error {
+			return s.sink.PublishMessages(ctx, sideAcks, sideMsgs)
+		})
+	}
+	rg.Go(func() error {
+		return s.source.ConsumeMessages(ctx, sourceMsgs, sourceAcks)
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-sourceMsgs:
+				var out chan<- substrate.Message
+				var toSend substrate.Message
+				passed := s.predicate(msg)
+				switch {
+				case passed:
+					toSend = &filterMessage{msg: msg}
+					queue.Push(deliveredLane, toSend, msg)
+					out = messages
+					emit.Publish(toSend)
+				case s.sink != nil:
+					toSend = &sideMessage{msg: msg}
+					queue.Push(sideLane, toSend, msg)
+					out = sideMsgs
+				default:
+					queue.PushDone(msg)
+				}
+				if !passed && s.dropped != nil {
+					s.dropped.Inc()
+				}
+				if out == nil {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case out <- toSend:
+				}
+			}
+		}
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			var released []substrate.Message
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-queue.Ready():
+				released = queue.Release()
+			case ack := <-acks:
+				if _, ok := ack.(*filterMessage); !ok {
+					return errors.Errorf("unexpected message type: %T", ack)
+				}
+				var ok bool
+				if released, ok = queue.Complete(deliveredLane, ack); !ok {
+					return errors.Errorf("unexpected message acknowledged: %v", ack)
+				}
+				emit.Ack(ack)
+			case ack := <-sideAcks:
+				if _, ok := ack.(*sideMessage); !ok {
+					return errors.Errorf("unexpected message type: %T", ack)
+				}
+				var ok bool
+				if released, ok = queue.Complete(sideLane, ack); !ok {
+					return errors.Errorf("unexpected message acknowledged: %v", ack)
+				}
+			}
+			for _, msg := range released {
+				select {
+				case <-ctx.Done():
+					return nil
+				case sourceAcks <- msg:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+// Close closes both the underlying source and the side sink, if set, and returns all errors encountered.
+func (s *filterSource) Close() (err error) {
+	err = multierror.Append(err, s.source.Close()).ErrorOrNil()
+	if s.sink != nil {
+		err = multierror.Append(err, s.sink.Close()).ErrorOrNil()
+	}
+	return err
+}
+
+// Status returns the status of the underlying source. It only reports working status if the
+// side sink, if set, does as well.
+func (s *filterSource) Status() (*substrate.Status, error) {
+	status, err := s.source.Status()
+	if err != nil || s.sink == nil {
+		return status, err
+	}
+	sinkStatus, err := s.sink.Status()
+	if err != nil {
+		return nil, err
+	}
+	status.Working = status.Working && sinkStatus.Working
+	for _, problem := range sinkStatus.Problems {
+		status.Problems = append(status.Problems, "side sink: "+problem)
+	}
+	return status, nil
+}
+
+// filterMessage is a message delivered to the user, which is acknowledged in its place.
+type filterMessage struct {
+	msg substrate.Message
+}
+
+func (msg *filterMessage) Data() []byte {
+	return msg.msg.Data()
+}
+
+func (msg *filterMessage) Headers() map[string]string {
+	return message.Headers(msg.msg)
+}
+
+func (msg *filterMessage) DiscardPayload() {
+	if dMsg, ok := msg.msg.(substrate.DiscardableMessage); ok {
+		dMsg.DiscardPayload()
+	}
+}
+
+func (msg *filterMessage) CarriedEnvelope() (message.Envelope, bool) {
+	return message.EnvelopeOf(msg.msg)
+}
+
+// sideMessage is a message published to the side sink on behalf of a message failing the predicate.
+type sideMessage struct {
+	msg substrate.Message
+}
+
+func (msg *sideMessage) Data() []byte {
+	return msg.msg.Data()
+}
+
+func (msg *sideMessage) Headers() map[string]string {
+	return message.Headers(msg.msg)
+}
+
+func (msg *sideMessage) CarriedEnvelope() (message.Envelope, bool) {
+	return message.EnvelopeOf(msg.msg)
+}
@@ -0,0 +1,147 @@
+package filter_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/conformance"
+	"github.com/uw-labs/substrate-tools/filter"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+func wanted(msg substrate.Message) bool {
+	return strings.HasPrefix(string(msg.Data()), "wanted")
+}
+
+func consume(ctx context.Context, t *testing.T, source substrate.AsyncMessageSource, count int) (<-chan error, []string) {
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	var consumed []string
+	for i := 0; i < count; i++ {
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "failed to consume all messages")
+		case msg := <-messages:
+			consumed = append(consumed, string(msg.Data()))
+			acks <- msg
+		}
+	}
+	return errs, consumed
+}
+
+func TestFilterMessageSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	counterOpts := prometheus.CounterOpts{
+		Name: "filter_test_dropped_total",
+		Help: "filter_test_dropped_total",
+	}
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			message.FromString("wanted-1"),
+			message.FromString("unwanted-1"),
+			message.FromString("unwanted-2"),
+			message.FromString("wanted-2"),
+		},
+	}
+	source := filter.NewAsyncMessageSource(mockSource, wanted, filter.WithDropCounter(counterOpts, "topic"))
+
+	counter := prometheus.NewCounterVec(counterOpts, []string{"topic"})
+	if are, ok := prometheus.Register(counter).(prometheus.AlreadyRegisteredError); ok {
+		counter = are.ExistingCollector.(*prometheus.CounterVec)
+	}
+	before := testutil.ToFloat64(counter.WithLabelValues("topic"))
+
+	errs, consumed := consume(ctx, t, source, 2)
+	require.Equal(t, []string{"wanted-1", "wanted-2"}, consumed)
+
+	// The mock source terminates with an error if acknowledgements are out of order.
+	require.NoError(t, source.Close())
+	require.NoError(t, <-errs)
+	require.Equal(t, before+2, testutil.ToFloat64(counter.WithLabelValues("topic")))
+}
+
+func TestFilterMessageSourceWithSideSink(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			message.FromString("unwanted-1"),
+			message.FromString("wanted-1"),
+			message.FromString("unwanted-2"),
+		},
+	}
+	source := filter.NewAsyncMessageSource(mockSource, wanted, filter.WithSideSink(broker.NewAsyncMessageSink("side")))
+
+	errs, consumed := consume(ctx, t, source, 1)
+	require.Equal(t, []string{"wanted-1"}, consumed)
+
+	require.Eventually(t, func() bool {
+		return len(broker.Messages("side")) == 2
+	}, time.Second, time.Millisecond*10)
+	require.Equal(t, "unwanted-1", string(broker.Messages("side")[0]))
+	require.Equal(t, "unwanted-2", string(broker.Messages("side")[1]))
+
+	status, err := source.Status()
+	require.NoError(t, err)
+	require.True(t, status.Working)
+
+	require.NoError(t, source.Close())
+	require.NoError(t, <-errs)
+	require.True(t, mockSource.WasClosed())
+}
+
+type headeredMessage struct {
+	*message.Message
+	headers map[string]string
+}
+
+func (m *headeredMessage) Headers() map[string]string {
+	return m.headers
+}
+
+func TestFilterMessageSourceByHeaders(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			&headeredMessage{Message: message.FromString("1"), headers: map[string]string{"type": "order"}},
+			&headeredMessage{Message: message.FromString("2"), headers: map[string]string{"type": "refund"}},
+			&headeredMessage{Message: message.FromString("3"), headers: map[string]string{"type": "order"}},
+		},
+	}
+	source := filter.NewAsyncMessageSource(mockSource, func(msg substrate.Message) bool {
+		return message.Headers(msg)["type"] == "order"
+	})
+
+	errs, consumed := consume(ctx, t, source, 2)
+	require.Equal(t, []string{"1", "3"}, consumed)
+
+	require.NoError(t, source.Close())
+	require.NoError(t, <-errs)
+}
+
+func TestFilterMessageSource_Conformance(t *testing.T) {
+	conformance.TestAsyncSource(t, func(_ *testing.T, backend substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		return filter.NewAsyncMessageSource(backend, func(substrate.Message) bool {
+			return true
+		})
+	})
+}
@@ -4,6 +4,7 @@ package flush
 import (
 	"context"
 	"fmt"
+	"sync"
 	"sync/atomic"
 
 	"github.com/uw-labs/substrate"
@@ -60,6 +61,8 @@ type AsyncMessageSink struct {
 	ackBufferSize int
 	ackCh         chan substrate.Message
 	ackFn         AckFunc
+	ackMutex      sync.Mutex
+	ackNotify     chan struct{}
 }
 
 // NewAsyncMessageSink returns a pointer a new AsyncMessageSink.
@@ -73,6 +76,7 @@ func NewAsyncMessageSink(ctx context.Context, sink substrate.AsyncMessageSink, o
 		sink:          sink,
 		msgBufferSize: defaultMsgBufferSize,
 		ackBufferSize: defaultAckBufferSize,
+		ackNotify:     make(chan struct{}),
 	}
 
 	for _, opt := range opts {
@@ -108,6 +112,7 @@ func (ams *AsyncMessageSink) Run() error {
 			}
 
 			atomic.AddUint64(&ams.acks, 1)
+			ams.notifyAck()
 		}
 
 		return nil
@@ -153,24 +158,51 @@ func (ams *AsyncMessageSink) Status() (*substrate.Status, error) {
 	return ams.sink.Status()
 }
 
-// Flush blocks until the AsyncMessageSink has consumed as many acks as messages produced or the
-// constructor ctx is done. Flush returns an error if the context is cancelled before all messages
-// produced have been acked.
+// Flush blocks until all messages produced before it was called have been acked or the constructor
+// ctx is done. Flush returns an error if the context is cancelled before all those messages have been
+// acked.
 func (ams *AsyncMessageSink) Flush() error {
+	return ams.FlushContext(context.Background())
+}
+
+// FlushContext blocks until all messages produced before it was called have been acked, the provided
+// ctx is done or the constructor ctx is done. FlushContext returns an error if either context is done
+// before all those messages have been acked.
+func (ams *AsyncMessageSink) FlushContext(ctx context.Context) error {
+	target := atomic.LoadUint64(&ams.msgs)
 	for {
+		notify := ams.ackNotifier()
+		acks := atomic.LoadUint64(&ams.acks)
+		if acks >= target {
+			return nil
+		}
+
 		select {
+		case <-ctx.Done():
+			return fmt.Errorf("incomplete flush: %d left to ack: %w", target-acks, ctx.Err())
 		case <-ams.ctx.Done():
-			if atomic.LoadUint64(&ams.msgs) > atomic.LoadUint64(&ams.acks) {
-				return fmt.Errorf("incomplete flush: %d left to ack", atomic.LoadUint64(&ams.msgs)-atomic.LoadUint64(&ams.acks))
-			}
-
-			return nil
-		default:
-			if atomic.LoadUint64(&ams.acks) != atomic.LoadUint64(&ams.msgs) {
-				continue
+			// Acks may have been processed after the notifier was obtained.
+			if acks = atomic.LoadUint64(&ams.acks); acks >= target {
+				return nil
 			}
-
-			return nil
+			return fmt.Errorf("incomplete flush: %d left to ack", target-acks)
+		case <-notify:
 		}
 	}
 }
+
+// ackNotifier returns a channel that is closed once the next ack has been processed.
+func (ams *AsyncMessageSink) ackNotifier() <-chan struct{} {
+	ams.ackMutex.Lock()
+	defer ams.ackMutex.Unlock()
+
+	return ams.ackNotify
+}
+
+func (ams *AsyncMessageSink) notifyAck() {
+	ams.ackMutex.Lock()
+	defer ams.ackMutex.Unlock()
+
+	close(ams.ackNotify)
+	ams.ackNotify = make(chan struct{})
+}
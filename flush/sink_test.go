@@ -58,7 +58,7 @@ func TestAsyncMessageSinkSuccess(t *testing.T) {
 		go func(i int) {
 			defer wg.Done()
 
-			sink.PublishMessage(ctx, []byte(string('A'+i)))
+			sink.PublishMessage(ctx, []byte(string(rune('A'+i))))
 		}(i)
 	}
 
@@ -113,7 +113,7 @@ func TestAsyncMessageSinkInterruption(t *testing.T) {
 		go func(i int) {
 			defer wg.Done()
 
-			sink.PublishMessage(ctx, []byte(string('A'+i)))
+			sink.PublishMessage(ctx, []byte(string(rune('A'+i))))
 		}(i)
 	}
 
@@ -286,7 +286,7 @@ func benchmarkBufferSizes(b *testing.B, msgBufferSize, ackBufferSize int) {
 		go func(i int) {
 			defer wg.Done()
 
-			sink.PublishMessage(ctx, []byte(string('A'+i)))
+			sink.PublishMessage(ctx, []byte(string(rune('A'+i))))
 		}(i)
 	}
 
@@ -309,3 +309,47 @@ func benchmarkBufferSizes(b *testing.B, msgBufferSize, ackBufferSize int) {
 		b.Fatal("recieved messages in synchronous order")
 	}
 }
+
+func TestAsyncMessageSinkFlushContext(t *testing.T) {
+	ctx := context.TODO()
+
+	release := make(chan struct{})
+	mock := asyncMessageSinkMock{
+		publishMessageMock: func(ctx context.Context, acks chan<- substrate.Message, msgs <-chan substrate.Message) error {
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case msg := <-msgs:
+					select {
+					case <-ctx.Done():
+						return nil
+					case <-release:
+					}
+					acks <- msg
+				}
+			}
+		},
+	}
+
+	sink := flush.NewAsyncMessageSink(ctx, mock)
+	defer sink.Close()
+
+	go sink.Run()
+	if err := sink.PublishMessage(ctx, []byte("dummy-message")); err != nil {
+		t.Fatal(err)
+	}
+
+	flushCtx, flushCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer flushCancel()
+
+	err := sink.FlushContext(flushCtx)
+	if err == nil || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a context.DeadlineExceeded error, got %v", err)
+	}
+
+	close(release)
+	if err := sink.FlushContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
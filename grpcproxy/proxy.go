@@ -0,0 +1,272 @@
+// Package grpcproxy serves the proximo gRPC streaming API on top of substrate sinks and sources, so that
+// services in any language with gRPC support can publish and consume messages through a sidecar, while
+// only the proxy holds the configuration and credentials of the backend.
+package grpcproxy
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/uw-labs/proximo/proto"
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/sync/rungroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/uw-labs/substrate-tools/ackordering"
+)
+
+// ErrUnknownTopic can be returned by a SinkFunc or SourceFunc to reject requests for a topic that
+// can't be published to or consumed from, which fails the stream with codes.NotFound.
+var ErrUnknownTopic = errors.New("unknown topic")
+
+// SinkFunc returns a new sink publishing to the topic. It is called for every publish stream.
+type SinkFunc func(topic string) (substrate.AsyncMessageSink, error)
+
+// SourceFunc returns a new source consuming the topic as the consumer, starting from the initial offset
+// if the source has no position stored for the consumer. It is called for every consume stream.
+type SourceFunc func(topic, consumer string, offset proto.Offset) (substrate.AsyncMessageSource, error)
+
+// Server implements the proximo MessageSink and MessageSource services.
+type Server struct {
+	sinks   SinkFunc
+	sources SourceFunc
+}
+
+// New returns a server publishing to sinks created by the sink function and consuming from sources created
+// by the source function. If either function is nil, the corresponding service fails every stream with
+// codes.Unimplemented.
+func New(sinks SinkFunc, sources SourceFunc) *Server {
+	return &Server{
+		sinks:   sinks,
+		sources: sources,
+	}
+}
+
+// Register registers both services of the server with the gRPC server.
+func (s *Server) Register(server *grpc.Server) {
+	proto.RegisterMessageSinkServer(server, s)
+	proto.RegisterMessageSourceServer(server, s)
+}
+
+// proxyMessage is a message published on behalf of a client.
+type proxyMessage struct {
+	id   string
+	data []byte
+}
+
+func (msg *proxyMessage) Data() []byte {
+	return msg.data
+}
+
+// Publish publishes the messages of the stream to a sink of the requested topic and confirms each message
+// once it is acknowledged by the sink. When the client closes its side of the stream, the stream ends as
+// soon as all messages sent before have been confirmed.
+func (s *Server) Publish(stream proto.MessageSink_PublishServer) error {
+	if s.sinks == nil {
+		return status.Error(codes.Unimplemented, "publishing is disabled")
+	}
+
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	start := req.GetStartRequest()
+	if start == nil {
+		return status.Error(codes.InvalidArgument, "first request must be a start request")
+	}
+
+	sink, err := s.sinks(start.GetTopic())
+	if err != nil {
+		return topicError(err)
+	}
+	defer sink.Close()
+
+	rg, ctx := rungroup.New(stream.Context())
+
+	messages := make(chan substrate.Message)
+	acks := make(chan substrate.Message)
+	requests, recvErrs := receive(stream.Context(), stream.Recv)
+	sent := make(chan int, 1)
+
+	rg.Go(func() error {
+		return sink.PublishMessages(ctx, acks, messages)
+	})
+	rg.Go(func() error {
+		count := 0
+		for {
+			var req *proto.PublisherRequest
+			select {
+			case <-ctx.Done():
+				return nil
+			case err := <-recvErrs:
+				if err != io.EOF {
+					return err
+				}
+				sent <- count
+				<-ctx.Done()
+				return nil
+			case req = <-requests:
+			}
+			msg := req.GetMsg()
+			if msg == nil {
+				return status.Error(codes.InvalidArgument, "publish stream started twice")
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case messages <- &proxyMessage{id: msg.GetId(), data: msg.GetData()}:
+				count++
+			}
+		}
+	})
+	rg.Go(func() error {
+		confirmed := 0
+		total := -1
+		for confirmed != total {
+			select {
+			case <-ctx.Done():
+				return nil
+			case total = <-sent:
+			case ack := <-acks:
+				msg, ok := ack.(*proxyMessage)
+				if !ok {
+					return errors.Errorf("unexpected message type: %T", ack)
+				}
+				if err := stream.Send(&proto.Confirmation{MsgID: msg.id}); err != nil {
+					return err
+				}
+				confirmed++
+			}
+		}
+		return nil
+	})
+
+	return rg.Wait()
+}
+
+// Consume sends the messages of a source of the requested topic to the stream and acknowledges them to the
+// source once they are confirmed by the client. Confirmations can be sent in any order.
+func (s *Server) Consume(stream proto.MessageSource_ConsumeServer) error {
+	if s.sources == nil {
+		return status.Error(codes.Unimplemented, "consuming is disabled")
+	}
+
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	start := req.GetStartRequest()
+	if start == nil {
+		return status.Error(codes.InvalidArgument, "first request must be a start request")
+	}
+
+	source, err := s.sources(start.GetTopic(), start.GetConsumer(), start.GetInitialOffset())
+	if err != nil {
+		return topicError(err)
+	}
+	source = ackordering.NewAsyncMessageSource(source)
+	defer source.Close()
+
+	rg, ctx := rungroup.New(stream.Context())
+
+	messages := make(chan substrate.Message)
+	acks := make(chan substrate.Message)
+
+	requests, recvErrs := receive(stream.Context(), stream.Recv)
+
+	var (
+		mutex   sync.Mutex
+		pending = make(map[string]substrate.Message)
+	)
+
+	rg.Go(func() error {
+		return source.ConsumeMessages(ctx, messages, acks)
+	})
+	rg.Go(func() error {
+		var seq uint64
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-messages:
+				seq++
+				id := strconv.FormatUint(seq, 10)
+				mutex.Lock()
+				pending[id] = msg
+				mutex.Unlock()
+				if err := stream.Send(&proto.Message{Id: id, Data: msg.Data()}); err != nil {
+					return err
+				}
+			}
+		}
+	})
+	rg.Go(func() error {
+		for {
+			var req *proto.ConsumerRequest
+			select {
+			case <-ctx.Done():
+				return nil
+			case err := <-recvErrs:
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			case req = <-requests:
+			}
+			confirmation := req.GetConfirmation()
+			if confirmation == nil {
+				return status.Error(codes.InvalidArgument, "consume stream started twice")
+			}
+			mutex.Lock()
+			msg, ok := pending[confirmation.GetMsgID()]
+			delete(pending, confirmation.GetMsgID())
+			mutex.Unlock()
+			if !ok {
+				return status.Errorf(codes.InvalidArgument, "unexpected message confirmed: %s", confirmation.GetMsgID())
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case acks <- msg:
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+// topicError converts an error returned by a SinkFunc or SourceFunc to a gRPC status error.
+func topicError(err error) error {
+	if errors.Cause(err) == ErrUnknownTopic {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+// receive calls recv in a new goroutine until it fails, passing the received requests and the error to the
+// returned channels. The goroutine isn't waited for, as recv only returns once the stream is done, which
+// happens after the handler returns.
+func receive[T any](ctx context.Context, recv func() (T, error)) (<-chan T, <-chan error) {
+	requests := make(chan T)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			req, err := recv()
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case requests <- req:
+			}
+		}
+	}()
+	return requests, errs
+}
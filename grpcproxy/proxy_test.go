@@ -0,0 +1,287 @@
+package grpcproxy_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/uw-labs/proximo/proto"
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate/proximo"
+	"github.com/uw-labs/sync/rungroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/uw-labs/substrate-tools/grpcproxy"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+func memSinks(broker *mem.Broker, topics ...string) grpcproxy.SinkFunc {
+	return func(topic string) (substrate.AsyncMessageSink, error) {
+		for _, t := range topics {
+			if t == topic {
+				return broker.NewAsyncMessageSink(topic), nil
+			}
+		}
+		return nil, errors.Wrap(grpcproxy.ErrUnknownTopic, topic)
+	}
+}
+
+func memSources(broker *mem.Broker, acked chan<- string) grpcproxy.SourceFunc {
+	return func(topic, consumer string, offset proto.Offset) (substrate.AsyncMessageSource, error) {
+		var opts []mem.AsyncMessageSourceOption
+		if offset == proto.Offset_OFFSET_NEWEST {
+			opts = append(opts, mem.FromNewest())
+		}
+		return &ackRecorder{
+			AsyncMessageSource: broker.NewAsyncMessageSource(topic, consumer, opts...),
+			acked:              acked,
+		}, nil
+	}
+}
+
+// ackRecorder records the payloads of messages acknowledged to the source.
+type ackRecorder struct {
+	substrate.AsyncMessageSource
+	acked chan<- string
+}
+
+func (s *ackRecorder) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	rg, ctx := rungroup.New(ctx)
+	toAck := make(chan substrate.Message)
+
+	rg.Go(func() error {
+		return s.AsyncMessageSource.ConsumeMessages(ctx, messages, toAck)
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				select {
+				case <-ctx.Done():
+					return nil
+				case toAck <- ack:
+				}
+				s.acked <- string(ack.Data())
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+// startProxy serves the proxy on a local port and returns its address.
+func startProxy(t *testing.T, proxy *grpcproxy.Server) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := grpc.NewServer()
+	proxy.Register(server)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	return lis.Addr().String()
+}
+
+func dial(t *testing.T, addr string) *grpc.ClientConn {
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestServer_Publish(t *testing.T) {
+	broker := mem.NewBroker()
+	addr := startProxy(t, grpcproxy.New(memSinks(broker, "orders"), nil))
+
+	sink, err := proximo.NewAsyncMessageSink(proximo.AsyncMessageSinkConfig{
+		Broker:   addr,
+		Topic:    "orders",
+		Insecure: true,
+	})
+	require.NoError(t, err)
+	defer sink.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	for _, payload := range []string{"a", "b", "c"} {
+		msg := message.FromString(payload)
+		messages <- msg
+		select {
+		case ack := <-acks:
+			require.Equal(t, msg, ack)
+		case err := <-errs:
+			require.FailNow(t, "publishing failed", "%v", err)
+		}
+	}
+	cancel()
+	require.NoError(t, <-errs)
+
+	require.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, broker.Messages("orders"))
+}
+
+func TestServer_PublishCloseSend(t *testing.T) {
+	broker := mem.NewBroker()
+	addr := startProxy(t, grpcproxy.New(memSinks(broker, "orders"), nil))
+
+	stream, err := proto.NewMessageSinkClient(dial(t, addr)).Publish(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&proto.PublisherRequest{StartRequest: &proto.StartPublishRequest{Topic: "orders"}}))
+	require.NoError(t, stream.Send(&proto.PublisherRequest{Msg: &proto.Message{Id: "1", Data: []byte("a")}}))
+	require.NoError(t, stream.Send(&proto.PublisherRequest{Msg: &proto.Message{Id: "2", Data: []byte("b")}}))
+	require.NoError(t, stream.CloseSend())
+
+	for _, id := range []string{"1", "2"} {
+		confirmation, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, id, confirmation.GetMsgID())
+	}
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+
+	require.Equal(t, [][]byte{[]byte("a"), []byte("b")}, broker.Messages("orders"))
+}
+
+func TestServer_Consume(t *testing.T) {
+	broker := mem.NewBroker()
+	acked := make(chan string, 3)
+	addr := startProxy(t, grpcproxy.New(memSinks(broker, "orders"), memSources(broker, acked)))
+
+	source, err := proximo.NewAsyncMessageSource(proximo.AsyncMessageSourceConfig{
+		Broker:        addr,
+		Topic:         "orders",
+		ConsumerGroup: "test",
+		Offset:        proximo.OffsetOldest,
+		Insecure:      true,
+	})
+	require.NoError(t, err)
+	defer source.Close()
+
+	publish(t, broker, "orders", "a", "b", "c")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	for _, payload := range []string{"a", "b", "c"} {
+		select {
+		case msg := <-messages:
+			require.Equal(t, payload, string(msg.Data()))
+			acks <- msg
+		case err := <-errs:
+			require.FailNow(t, "consuming failed", "%v", err)
+		}
+		require.Equal(t, payload, receiveAck(t, acked))
+	}
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestServer_ConsumeOutOfOrder(t *testing.T) {
+	broker := mem.NewBroker()
+	acked := make(chan string, 3)
+	addr := startProxy(t, grpcproxy.New(nil, memSources(broker, acked)))
+
+	publish(t, broker, "orders", "a", "b", "c")
+
+	stream, err := proto.NewMessageSourceClient(dial(t, addr)).Consume(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&proto.ConsumerRequest{StartRequest: &proto.StartConsumeRequest{
+		Topic:         "orders",
+		Consumer:      "test",
+		InitialOffset: proto.Offset_OFFSET_OLDEST,
+	}}))
+
+	var ids []string
+	for _, payload := range []string{"a", "b", "c"} {
+		msg, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, payload, string(msg.GetData()))
+		ids = append(ids, msg.GetId())
+	}
+	for _, i := range []int{2, 0, 1} {
+		require.NoError(t, stream.Send(&proto.ConsumerRequest{Confirmation: &proto.Confirmation{MsgID: ids[i]}}))
+	}
+	for _, payload := range []string{"a", "b", "c"} {
+		require.Equal(t, payload, receiveAck(t, acked))
+	}
+
+	require.NoError(t, stream.Send(&proto.ConsumerRequest{Confirmation: &proto.Confirmation{MsgID: ids[0]}}))
+	_, err = stream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "%v", err)
+}
+
+func TestServer_Errors(t *testing.T) {
+	broker := mem.NewBroker()
+	addr := startProxy(t, grpcproxy.New(memSinks(broker, "orders"), nil))
+	conn := dial(t, addr)
+
+	pub, err := proto.NewMessageSinkClient(conn).Publish(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, pub.Send(&proto.PublisherRequest{StartRequest: &proto.StartPublishRequest{Topic: "payments"}}))
+	_, err = pub.Recv()
+	require.Equal(t, codes.NotFound, status.Code(err), "%v", err)
+
+	pub, err = proto.NewMessageSinkClient(conn).Publish(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, pub.Send(&proto.PublisherRequest{Msg: &proto.Message{Id: "1"}}))
+	_, err = pub.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "%v", err)
+
+	sub, err := proto.NewMessageSourceClient(conn).Consume(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, sub.Send(&proto.ConsumerRequest{StartRequest: &proto.StartConsumeRequest{Topic: "orders"}}))
+	_, err = sub.Recv()
+	require.Equal(t, codes.Unimplemented, status.Code(err), "%v", err)
+}
+
+func publish(t *testing.T, broker *mem.Broker, topic string, payloads ...string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	sink := broker.NewAsyncMessageSink(topic)
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	for _, payload := range payloads {
+		msg := message.FromString(payload)
+		messages <- msg
+		require.Equal(t, msg, <-acks)
+	}
+	cancel()
+	require.NoError(t, <-errs)
+	require.NoError(t, sink.Close())
+}
+
+func receiveAck(t *testing.T, acked <-chan string) string {
+	select {
+	case payload := <-acked:
+		return payload
+	case <-time.After(time.Second * 5):
+		require.FailNow(t, "message wasn't acknowledged")
+		return ""
+	}
+}
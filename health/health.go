@@ -0,0 +1,142 @@
+// Package health aggregates the status of sinks, sources and the wrappers around them, and serves it as a
+// readiness and liveness report over HTTP.
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+)
+
+// StatusFunc is an adapter allowing the use of an ordinary function as a substrate.Statuser, e.g. to report
+// the problems specific to a wrapper.
+type StatusFunc func() (*substrate.Status, error)
+
+// Status calls f().
+func (f StatusFunc) Status() (*substrate.Status, error) {
+	return f()
+}
+
+// Named returns a statuser reporting the status of the statuser with its problems and error prefixed by the name.
+func Named(name string, statuser substrate.Statuser) substrate.Statuser {
+	return StatusFunc(func() (*substrate.Status, error) {
+		status, err := statuser.Status()
+		if err != nil {
+			return nil, errors.Wrap(err, name)
+		}
+		problems := make([]string, 0, len(status.Problems))
+		for _, problem := range status.Problems {
+			problems = append(problems, name+": "+problem)
+		}
+		return &substrate.Status{Working: status.Working, Problems: problems}, nil
+	})
+}
+
+// Combine returns a statuser reporting the combined status of all statusers. It only reports working status
+// if all of them do, and reports the problems of all of them, in order. It fails with the first error returned.
+func Combine(statusers ...substrate.Statuser) substrate.Statuser {
+	return StatusFunc(func() (*substrate.Status, error) {
+		combined := &substrate.Status{Working: true}
+		for _, statuser := range statusers {
+			status, err := statuser.Status()
+			if err != nil {
+				return nil, err
+			}
+			combined.Working = combined.Working && status.Working
+			combined.Problems = append(combined.Problems, status.Problems...)
+		}
+		return combined, nil
+	})
+}
+
+// Report is the status of all checks of a handler. The handler is live unless the status of a check couldn't be
+// determined, and ready if all checks report working status.
+type Report struct {
+	Live   bool          `json:"live"`
+	Ready  bool          `json:"ready"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// CheckResult is the status of a single check.
+type CheckResult struct {
+	Name     string   `json:"name"`
+	Working  bool     `json:"working"`
+	Problems []string `json:"problems,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+type check struct {
+	name     string
+	statuser substrate.Statuser
+}
+
+// Handler is an http.Handler serving the report of its checks as JSON. It responds with
+// http.StatusServiceUnavailable unless all checks are ready, while the handler returned by Liveness only does so
+// unless they are all live. It is safe for concurrent use.
+type Handler struct {
+	mutex  sync.RWMutex
+	checks []check
+}
+
+// NewHandler returns a handler without any checks, which is ready until checks are added.
+func NewHandler() *Handler {
+	return &Handler{}
+}
+
+// Add adds a check reporting the status of the statuser under the name.
+func (h *Handler) Add(name string, statuser substrate.Statuser) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.checks = append(h.checks, check{name: name, statuser: statuser})
+}
+
+// Report returns the current status of all checks, in the order in which they were added.
+func (h *Handler) Report() Report {
+	h.mutex.RLock()
+	checks := h.checks
+	h.mutex.RUnlock()
+
+	report := Report{Live: true, Ready: true, Checks: make([]CheckResult, 0, len(checks))}
+	for _, c := range checks {
+		result := CheckResult{Name: c.name}
+		status, err := c.statuser.Status()
+		if err != nil {
+			result.Error = err.Error()
+			report.Live = false
+		} else {
+			result.Working = status.Working
+			result.Problems = status.Problems
+		}
+		report.Ready = report.Ready && result.Working
+		report.Checks = append(report.Checks, result)
+	}
+	return report
+}
+
+// ServeHTTP serves the report, responding with http.StatusServiceUnavailable unless all checks are ready.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	report := h.Report()
+	serveReport(w, report, report.Ready)
+}
+
+// Liveness returns a handler serving the report, responding with http.StatusServiceUnavailable unless all checks
+// are live.
+func (h *Handler) Liveness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		report := h.Report()
+		serveReport(w, report, report.Live)
+	})
+}
+
+func serveReport(w http.ResponseWriter, report Report, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,80 @@
+package health_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/health"
+)
+
+func status(working bool, problems ...string) substrate.Statuser {
+	return health.StatusFunc(func() (*substrate.Status, error) {
+		return &substrate.Status{Working: working, Problems: problems}, nil
+	})
+}
+
+func failing(err error) substrate.Statuser {
+	return health.StatusFunc(func() (*substrate.Status, error) {
+		return nil, err
+	})
+}
+
+func TestCombine(t *testing.T) {
+	combined, err := health.Combine(
+		status(true, "connecting"),
+		health.Named("backlog", status(false, "full")),
+	).Status()
+	require.NoError(t, err)
+	require.Equal(t, &substrate.Status{Working: false, Problems: []string{"connecting", "backlog: full"}}, combined)
+
+	combined, err = health.Combine(status(true), status(true)).Status()
+	require.NoError(t, err)
+	require.Equal(t, &substrate.Status{Working: true}, combined)
+
+	errDown := errors.New("down")
+	_, err = health.Combine(status(true), health.Named("sink", failing(errDown))).Status()
+	require.Equal(t, errDown, errors.Cause(err))
+	require.Equal(t, "sink: down", err.Error())
+}
+
+func serve(t *testing.T, handler http.Handler) (int, health.Report) {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var report health.Report
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	return rec.Code, report
+}
+
+func TestHandler(t *testing.T) {
+	handler := health.NewHandler()
+	code, report := serve(t, handler)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, health.Report{Live: true, Ready: true, Checks: []health.CheckResult{}}, report)
+
+	handler.Add("source", status(true))
+	handler.Add("sink", status(false, "circuit open"))
+
+	code, report = serve(t, handler)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, health.Report{Live: true, Ready: false, Checks: []health.CheckResult{
+		{Name: "source", Working: true},
+		{Name: "sink", Working: false, Problems: []string{"circuit open"}},
+	}}, report)
+
+	code, _ = serve(t, handler.Liveness())
+	require.Equal(t, http.StatusOK, code)
+
+	handler.Add("dlq", failing(errors.New("closed")))
+	code, report = serve(t, handler.Liveness())
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.False(t, report.Live)
+	require.Equal(t, health.CheckResult{Name: "dlq", Error: "closed"}, report.Checks[2])
+}
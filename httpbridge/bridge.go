@@ -0,0 +1,362 @@
+// Package httpbridge provides an HTTP handler that publishes request bodies to substrate sinks, and optionally
+// streams messages consumed from substrate sources as server-sent events, so that services, scripts and browsers
+// that can't use substrate directly can publish to and tail substrate-managed topics.
+package httpbridge
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/uw-labs/substrate"
+
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+)
+
+const (
+	// HeaderPrefix is the prefix of request headers that are passed on as message headers, with the prefix
+	// removed and the name lower cased, e.g. "X-Substrate-Traceparent" sets the "traceparent" header.
+	HeaderPrefix = "X-Substrate-"
+	// BatchContentType is the content type of requests publishing a batch of messages, one per line.
+	BatchContentType = "application/x-ndjson"
+
+	topicsPath          = "/topics/"
+	unknownTopicLabel   = "unknown"
+	defaultMaxBodySize  = 1 << 20
+	defaultMaxBatchSize = 1000
+)
+
+var (
+	// ErrUnknownTopic should be returned, possibly wrapped, by a SinkFunc for topics that can't be published to.
+	ErrUnknownTopic = errors.New("unknown topic")
+	// ErrUnauthorized can be returned by an AuthFunc to reject a request.
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// SinkFunc returns the sink used to publish messages to the topic. It is called the first time a message is
+// published to the topic, and again after the sink failed. Publishing is disabled if it is nil.
+type SinkFunc func(topic string) (substrate.AsyncMessageSink, error)
+
+// AuthFunc authorises a request to publish to the topic, the request is rejected if it returns an error.
+type AuthFunc func(r *http.Request, topic string) error
+
+// BearerToken returns an AuthFunc that only accepts requests carrying the token in a bearer authorization header.
+func BearerToken(token string) AuthFunc {
+	expected := []byte("Bearer " + token)
+	return func(r *http.Request, _ string) error {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			return ErrUnauthorized
+		}
+		return nil
+	}
+}
+
+// Option is a function which sets a bridge configuration option.
+type Option func(b *Bridge)
+
+// WithAuth sets a function used to authorise requests. By default all requests are accepted.
+func WithAuth(auth AuthFunc) Option {
+	return func(b *Bridge) {
+		b.auth = auth
+	}
+}
+
+// WithMaxBodySize sets the maximum size of a request body in bytes. The default value is 1MiB.
+func WithMaxBodySize(size int64) Option {
+	return func(b *Bridge) {
+		b.maxBodySize = size
+	}
+}
+
+// WithMaxBatchSize sets the maximum number of messages in a batch request. The default value is 1000.
+func WithMaxBatchSize(size int) Option {
+	return func(b *Bridge) {
+		b.maxBatchSize = size
+	}
+}
+
+// WithRequestCounter enables a counter of requests, labelled with topic and the HTTP status code of the response.
+// It panics in case it can't register the metric.
+func WithRequestCounter(counterOpts prometheus.CounterOpts) Option {
+	return func(b *Bridge) {
+		b.requests = metrics.Register(prometheus.NewCounterVec(counterOpts, []string{"topic", "code"})).(*prometheus.CounterVec)
+	}
+}
+
+// WithLatencyHistogram enables a histogram tracking the time it takes to publish the messages of successful requests,
+// labelled with topic. It panics in case it can't register the metric.
+func WithLatencyHistogram(histogramOpts prometheus.HistogramOpts) Option {
+	return func(b *Bridge) {
+		b.latency = metrics.Register(prometheus.NewHistogramVec(histogramOpts, []string{"topic"})).(*prometheus.HistogramVec)
+	}
+}
+
+// Bridge is an http.Handler that publishes the body of POST requests to /topics/{topic} to the sink of the topic,
+// and responds once the message has been acknowledged. Requests with the BatchContentType content type publish
+// each non-empty line of the body as a separate message. Messages of concurrent requests are published concurrently.
+//
+// If sources are configured using WithSources, GET requests to /topics/{topic} stream the messages of the topic
+// as server-sent events. Each stream consumes from its own source, and the messages sent to it can be filtered
+// using "header=name:value" and "contains=text" query parameters. The id of events of messages exposing their
+// position is a resume token, which clients reconnecting to the stream send back in the Last-Event-ID header
+// or the resume query parameter to continue after the last event they received.
+type Bridge struct {
+	sinks        SinkFunc
+	sources      SourceFunc
+	auth         AuthFunc
+	maxBodySize  int64
+	maxBatchSize int
+	heartbeat    time.Duration
+	writeTimeout time.Duration
+	requests     *prometheus.CounterVec
+	latency      *prometheus.HistogramVec
+	streams      *prometheus.GaugeVec
+
+	ctx        context.Context
+	cancel     func()
+	mutex      sync.Mutex
+	publishers map[string]*publisher
+	running    sync.WaitGroup
+	closed     bool
+}
+
+// New returns a new bridge publishing messages to the sinks returned by the provided function.
+func New(sinks SinkFunc, opts ...Option) *Bridge {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Bridge{
+		sinks:        sinks,
+		maxBodySize:  defaultMaxBodySize,
+		maxBatchSize: defaultMaxBatchSize,
+		heartbeat:    defaultHeartbeatInterval,
+		ctx:          ctx,
+		cancel:       cancel,
+		publishers:   make(map[string]*publisher),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// response is the body of successful responses.
+type response struct {
+	// Acknowledged is the number of messages that were published and acknowledged.
+	Acknowledged int `json:"acknowledged"`
+}
+
+// ServeHTTP implements http.Handler.
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	topic := strings.TrimPrefix(r.URL.Path, topicsPath)
+	if !strings.HasPrefix(r.URL.Path, topicsPath) || topic == "" || strings.Contains(topic, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method == http.MethodGet && b.sources != nil {
+		b.serveStream(w, r, topic)
+		return
+	}
+
+	start := time.Now()
+	code, count, known, err := b.serve(r, topic)
+	if known {
+		b.countRequest(topic, code)
+	} else {
+		b.countRequest(unknownTopicLabel, code)
+	}
+	if err != nil {
+		if code == http.StatusMethodNotAllowed {
+			w.Header().Set("Allow", b.allowedMethods())
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	if b.latency != nil {
+		b.latency.WithLabelValues(topic).Observe(time.Since(start).Seconds())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(response{Acknowledged: count})
+}
+
+// countRequest increments the request counter, if enabled. Requests are only labelled with their topic once it
+// is known to exist and the request was authorised, so that clients can't create arbitrary label values.
+func (b *Bridge) countRequest(topic string, code int) {
+	if b.requests != nil {
+		b.requests.WithLabelValues(topic, strconv.Itoa(code)).Inc()
+	}
+}
+
+// allowedMethods returns the value of the Allow header of the topic endpoints.
+func (b *Bridge) allowedMethods() string {
+	var methods []string
+	if b.sources != nil {
+		methods = append(methods, http.MethodGet)
+	}
+	if b.sinks != nil {
+		methods = append(methods, http.MethodPost)
+	}
+	return strings.Join(methods, ", ")
+}
+
+// serve publishes the messages of the request, returning the status code of the response along with
+// the number of messages published, and whether the request was authorised for a topic that exists.
+func (b *Bridge) serve(r *http.Request, topic string) (int, int, bool, error) {
+	if r.Method != http.MethodPost || b.sinks == nil {
+		return http.StatusMethodNotAllowed, 0, false, errors.New("method not allowed")
+	}
+	if b.auth != nil {
+		if err := b.auth(r, topic); err != nil {
+			return http.StatusUnauthorized, 0, false, err
+		}
+	}
+
+	p, err := b.publisher(topic)
+	switch {
+	case errors.Cause(err) == ErrUnknownTopic:
+		return http.StatusNotFound, 0, false, err
+	case err == errClosed:
+		return http.StatusServiceUnavailable, 0, false, err
+	case err != nil:
+		return http.StatusBadGateway, 0, false, err
+	}
+
+	msgs, code, err := b.readMessages(r)
+	if err != nil {
+		return code, 0, true, err
+	}
+
+	switch err := p.publish(r.Context(), msgs); {
+	case err == nil:
+		return http.StatusOK, len(msgs), true, nil
+	case err == errClosed:
+		return http.StatusServiceUnavailable, 0, true, err
+	case r.Context().Err() != nil:
+		return http.StatusServiceUnavailable, 0, true, errors.Wrap(err, "request cancelled")
+	default:
+		return http.StatusBadGateway, 0, true, errors.Wrap(err, "failed to publish")
+	}
+}
+
+// readMessages reads the messages from the body of the request.
+func (b *Bridge) readMessages(r *http.Request) ([]*bridgeMessage, int, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, b.maxBodySize+1))
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "failed to read body")
+	}
+	if int64(len(body)) > b.maxBodySize {
+		return nil, http.StatusRequestEntityTooLarge, errors.New("body too large")
+	}
+
+	headers := make(map[string]string)
+	for name, values := range r.Header {
+		if strings.HasPrefix(name, HeaderPrefix) && len(values) > 0 {
+			headers[strings.ToLower(strings.TrimPrefix(name, HeaderPrefix))] = values[0]
+		}
+	}
+	newMessage := func(data []byte) *bridgeMessage {
+		return &bridgeMessage{data: data, headers: headers, acked: make(chan struct{})}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != BatchContentType {
+		return []*bridgeMessage{newMessage(body)}, 0, nil
+	}
+
+	var msgs []*bridgeMessage
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(nil, len(body)+1)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if len(msgs) == b.maxBatchSize {
+			return nil, http.StatusRequestEntityTooLarge, errors.New("batch too large")
+		}
+		msgs = append(msgs, newMessage(append([]byte(nil), scanner.Bytes()...)))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "failed to read batch")
+	}
+	if len(msgs) == 0 {
+		return nil, http.StatusBadRequest, errors.New("empty batch")
+	}
+	return msgs, 0, nil
+}
+
+// publisher returns the publisher of the topic, starting it if there's none or the previous one failed.
+func (b *Bridge) publisher(topic string) (*publisher, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return nil, errClosed
+	}
+	if p, ok := b.publishers[topic]; ok {
+		select {
+		case <-p.done:
+			// the sink failed, so it's replaced by a new one
+			p.sink.Close()
+		default:
+			return p, nil
+		}
+	}
+
+	sink, err := b.sinks(topic)
+	if err != nil {
+		return nil, err
+	}
+	p := startPublisher(b.ctx, sink)
+	b.publishers[topic] = p
+	return p, nil
+}
+
+// Close stops publishing, failing requests waiting for acknowledgements, ends all streams and closes all sinks.
+func (b *Bridge) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.closed = true
+	b.cancel()
+	b.running.Wait()
+
+	var err error
+	for _, p := range b.publishers {
+		<-p.done
+		err = multierror.Append(err, p.sink.Close()).ErrorOrNil()
+	}
+	return err
+}
+
+// Status returns the status of the sinks of all topics, it only reports working status if all of them do.
+func (b *Bridge) Status() (*substrate.Status, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	status := &substrate.Status{Working: true}
+	for topic, p := range b.publishers {
+		sinkStatus, err := p.sink.Status()
+		if err != nil {
+			return nil, errors.Wrapf(err, "topic %s", topic)
+		}
+		status.Working = status.Working && sinkStatus.Working
+		for _, problem := range sinkStatus.Problems {
+			status.Problems = append(status.Problems, topic+": "+problem)
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,270 @@
+package httpbridge_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/httpbridge"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+func memSinks(broker *mem.Broker, topics ...string) httpbridge.SinkFunc {
+	return func(topic string) (substrate.AsyncMessageSink, error) {
+		for _, t := range topics {
+			if t == topic {
+				return broker.NewAsyncMessageSink(topic), nil
+			}
+		}
+		return nil, errors.Wrap(httpbridge.ErrUnknownTopic, topic)
+	}
+}
+
+func post(t *testing.T, handler http.Handler, path, contentType, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func acknowledged(t *testing.T, rec *httptest.ResponseRecorder) int {
+	var resp struct {
+		Acknowledged int `json:"acknowledged"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	return resp.Acknowledged
+}
+
+func TestBridge_Publish(t *testing.T) {
+	broker := mem.NewBroker()
+	bridge := httpbridge.New(memSinks(broker, "orders"))
+
+	rec := post(t, bridge, "/topics/orders", "application/json", `{"id":1}`, nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, 1, acknowledged(t, rec))
+
+	rec = post(t, bridge, "/topics/orders", httpbridge.BatchContentType, "{\"id\":2}\n\n{\"id\":3}\n", nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, 2, acknowledged(t, rec))
+
+	require.NoError(t, bridge.Close())
+	require.Equal(t, [][]byte{[]byte(`{"id":1}`), []byte(`{"id":2}`), []byte(`{"id":3}`)}, broker.Messages("orders"))
+
+	rec = post(t, bridge, "/topics/orders", "", "late", nil)
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestBridge_ConcurrentRequests(t *testing.T) {
+	broker := mem.NewBroker()
+	bridge := httpbridge.New(memSinks(broker, "orders"))
+	defer bridge.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := post(t, bridge, "/topics/orders", httpbridge.BatchContentType, "a\nb\nc", nil)
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		}()
+	}
+	wg.Wait()
+	require.Len(t, broker.Messages("orders"), 60)
+}
+
+type headerSink struct {
+	substrate.AsyncMessageSink
+	headers chan map[string]string
+}
+
+func (s *headerSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-messages:
+			s.headers <- message.Headers(msg)
+			acks <- msg
+		}
+	}
+}
+
+func (s *headerSink) Close() error {
+	return nil
+}
+
+func TestBridge_Headers(t *testing.T) {
+	sink := &headerSink{headers: make(chan map[string]string, 1)}
+	bridge := httpbridge.New(func(string) (substrate.AsyncMessageSink, error) {
+		return sink, nil
+	})
+	defer bridge.Close()
+
+	rec := post(t, bridge, "/topics/orders", "", "payload", map[string]string{
+		"X-Substrate-Traceparent": "trace",
+		"X-Other":                 "ignored",
+	})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, map[string]string{"traceparent": "trace"}, <-sink.headers)
+}
+
+func TestBridge_Errors(t *testing.T) {
+	broker := mem.NewBroker()
+	bridge := httpbridge.New(memSinks(broker, "orders"),
+		httpbridge.WithAuth(httpbridge.BearerToken("secret")),
+		httpbridge.WithMaxBodySize(16),
+		httpbridge.WithMaxBatchSize(2),
+	)
+	defer bridge.Close()
+	auth := map[string]string{"Authorization": "Bearer secret"}
+
+	require.Equal(t, http.StatusUnauthorized, post(t, bridge, "/topics/orders", "", "payload", nil).Code)
+	require.Equal(t, http.StatusUnauthorized, post(t, bridge, "/topics/orders", "", "payload", map[string]string{"Authorization": "Bearer wrong"}).Code)
+	require.Equal(t, http.StatusNotFound, post(t, bridge, "/topics/unknown", "", "payload", auth).Code)
+	require.Equal(t, http.StatusNotFound, post(t, bridge, "/other", "", "payload", auth).Code)
+	require.Equal(t, http.StatusRequestEntityTooLarge, post(t, bridge, "/topics/orders", "", strings.Repeat("a", 17), auth).Code)
+	require.Equal(t, http.StatusRequestEntityTooLarge, post(t, bridge, "/topics/orders", httpbridge.BatchContentType, "a\nb\nc", auth).Code)
+	require.Equal(t, http.StatusBadRequest, post(t, bridge, "/topics/orders", httpbridge.BatchContentType, "\n\n", auth).Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/topics/orders", nil)
+	rec := httptest.NewRecorder()
+	bridge.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	require.Equal(t, http.MethodPost, rec.Header().Get("Allow"))
+
+	require.Empty(t, broker.Messages("orders"))
+}
+
+type failingSink struct {
+	substrate.AsyncMessageSink
+	closed chan struct{}
+}
+
+func (s *failingSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-messages:
+		return errors.New("broker unavailable")
+	}
+}
+
+func (s *failingSink) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func TestBridge_SinkFailure(t *testing.T) {
+	broker := mem.NewBroker()
+	failing := &failingSink{closed: make(chan struct{})}
+	sinks := []substrate.AsyncMessageSink{failing, broker.NewAsyncMessageSink("orders")}
+	bridge := httpbridge.New(func(string) (substrate.AsyncMessageSink, error) {
+		sink := sinks[0]
+		sinks = sinks[1:]
+		return sink, nil
+	})
+	defer bridge.Close()
+
+	rec := post(t, bridge, "/topics/orders", "", "first", nil)
+	require.Equal(t, http.StatusBadGateway, rec.Code)
+	require.Contains(t, rec.Body.String(), "broker unavailable")
+
+	// the failed sink is closed and replaced
+	rec = post(t, bridge, "/topics/orders", "", "second", nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	<-failing.closed
+	require.Equal(t, [][]byte{[]byte("second")}, broker.Messages("orders"))
+}
+
+// gathered returns the series of the metric registered with the default registry, keyed by their labels,
+// with the value of counters and the sample count of histograms.
+func gathered(t *testing.T, name string) map[string]float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+
+	series := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			var labels []string
+			for _, label := range metric.GetLabel() {
+				labels = append(labels, label.GetName()+"="+label.GetValue())
+			}
+			value := metric.GetCounter().GetValue()
+			if metric.GetHistogram() != nil {
+				value = float64(metric.GetHistogram().GetSampleCount())
+			}
+			series[strings.Join(labels, ",")] = value
+		}
+	}
+	return series
+}
+
+func TestBridge_Metrics(t *testing.T) {
+	broker := mem.NewBroker()
+	bridge := httpbridge.New(memSinks(broker, "orders"),
+		httpbridge.WithAuth(httpbridge.BearerToken("secret")),
+		httpbridge.WithRequestCounter(prometheus.CounterOpts{
+			Name: "httpbridge_test_requests_total",
+			Help: "httpbridge_test_requests_total",
+		}),
+		httpbridge.WithLatencyHistogram(prometheus.HistogramOpts{
+			Name: "httpbridge_test_publish_seconds",
+			Help: "httpbridge_test_publish_seconds",
+		}),
+	)
+	defer bridge.Close()
+	auth := map[string]string{"Authorization": "Bearer secret"}
+
+	requestsBefore := gathered(t, "httpbridge_test_requests_total")
+	latencyBefore := gathered(t, "httpbridge_test_publish_seconds")
+
+	post(t, bridge, "/topics/orders", "", "one", auth)
+	post(t, bridge, "/topics/orders", "", "two", auth)
+	post(t, bridge, "/topics/orders", httpbridge.BatchContentType, "", auth)
+	post(t, bridge, "/topics/does-not-exist", "", "three", auth)
+	post(t, bridge, "/topics/whatever-the-client-wants", "", "four", nil)
+
+	requests := gathered(t, "httpbridge_test_requests_total")
+	for labels := range requests {
+		requests[labels] -= requestsBefore[labels]
+	}
+	// requests for topics that don't exist or that weren't authorised aren't labelled with the topic
+	require.Equal(t, map[string]float64{
+		"code=200,topic=orders":  2,
+		"code=400,topic=orders":  1,
+		"code=401,topic=unknown": 1,
+		"code=404,topic=unknown": 1,
+	}, withoutZeros(requests))
+
+	latency := gathered(t, "httpbridge_test_publish_seconds")
+	require.Equal(t, 2.0, latency["topic=orders"]-latencyBefore["topic=orders"])
+	require.Len(t, latency, 1)
+}
+
+func withoutZeros(series map[string]float64) map[string]float64 {
+	for labels, value := range series {
+		if value == 0 {
+			delete(series, labels)
+		}
+	}
+	return series
+}
@@ -0,0 +1,134 @@
+package httpbridge
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// errClosed is returned to requests when the bridge is closed.
+var errClosed = errors.New("bridge is closed")
+
+// bridgeMessage is a message published on behalf of a request.
+type bridgeMessage struct {
+	data    []byte
+	headers map[string]string
+	acked   chan struct{}
+}
+
+func (msg *bridgeMessage) Data() []byte {
+	return msg.data
+}
+
+func (msg *bridgeMessage) Headers() map[string]string {
+	return msg.headers
+}
+
+// publisher publishes the messages of a single topic. Once the sink fails, the publisher is done and
+// a new one has to be started for the topic.
+type publisher struct {
+	sink   substrate.AsyncMessageSink
+	intake chan *bridgeMessage
+	done   chan struct{}
+	err    error
+}
+
+func startPublisher(ctx context.Context, sink substrate.AsyncMessageSink) *publisher {
+	p := &publisher{
+		sink:   sink,
+		intake: make(chan *bridgeMessage),
+		done:   make(chan struct{}),
+	}
+	go p.run(ctx)
+	return p
+}
+
+func (p *publisher) run(ctx context.Context) {
+	rg, ctx := rungroup.New(ctx)
+
+	toPublish := make(chan substrate.Message)
+	acks := make(chan substrate.Message)
+
+	var (
+		mutex   sync.Mutex
+		pending []*bridgeMessage
+	)
+
+	rg.Go(func() error {
+		return p.sink.PublishMessages(ctx, acks, toPublish)
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-p.intake:
+				mutex.Lock()
+				pending = append(pending, msg)
+				mutex.Unlock()
+				select {
+				case <-ctx.Done():
+					return nil
+				case toPublish <- msg:
+				}
+			}
+		}
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				mutex.Lock()
+				bMsg, ok := ack.(*bridgeMessage)
+				if !ok || len(pending) == 0 || bMsg != pending[0] {
+					mutex.Unlock()
+					return errors.Errorf("unexpected message acknowledged: %v", ack)
+				}
+				pending = pending[1:]
+				mutex.Unlock()
+				close(bMsg.acked)
+			}
+		}
+	})
+
+	err := rg.Wait()
+	if err == nil {
+		err = errClosed
+	}
+	p.err = err
+	close(p.done)
+}
+
+// publish hands the messages over to the publisher and waits for all of them to be acknowledged.
+func (p *publisher) publish(ctx context.Context, msgs []*bridgeMessage) error {
+	for _, msg := range msgs {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.done:
+			return p.err
+		case p.intake <- msg:
+		}
+	}
+	for _, msg := range msgs {
+		select {
+		case <-msg.acked:
+			continue
+		default:
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.done:
+			return p.err
+		case <-msg.acked:
+		}
+	}
+	return nil
+}
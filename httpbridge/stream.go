@@ -0,0 +1,315 @@
+package httpbridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/checkpoint"
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+const (
+	// EventStreamContentType is the content type of streams of consumed messages.
+	EventStreamContentType = "text/event-stream"
+	// ResumeParam is the query parameter carrying a resume token, as an alternative to the Last-Event-ID header.
+	ResumeParam = "resume"
+
+	defaultHeartbeatInterval = 15 * time.Second
+)
+
+// SourceFunc returns a new source consuming messages of the topic for a single stream. The resume checkpoint is
+// empty unless the client is resuming a previous stream, in which case it holds the offset of the last message
+// streamed from each partition. Messages at or before it are skipped, so sources that can't start consuming from
+// the checkpoint can start from an earlier position instead.
+type SourceFunc func(topic string, resume checkpoint.Checkpoint) (substrate.AsyncMessageSource, error)
+
+// WithSources enables streaming messages consumed from the sources returned by the provided function as
+// server-sent events to GET requests.
+func WithSources(sources SourceFunc) Option {
+	return func(b *Bridge) {
+		b.sources = sources
+	}
+}
+
+// WithHeartbeatInterval sets how often a comment is sent to idle streams, keeping the connection open and
+// detecting disconnected clients. Heartbeats are disabled if it isn't positive. The default value is 15 seconds.
+func WithHeartbeatInterval(interval time.Duration) Option {
+	return func(b *Bridge) {
+		b.heartbeat = interval
+	}
+}
+
+// WithWriteTimeout sets the maximum time writing an event to a stream can take, after which the client is
+// considered too slow and the stream is ended. By default, slow clients only slow down consumption.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(b *Bridge) {
+		b.writeTimeout = timeout
+	}
+}
+
+// WithStreamGauge enables a gauge of open streams, labelled with topic. It panics in case it can't register the metric.
+func WithStreamGauge(gaugeOpts prometheus.GaugeOpts) Option {
+	return func(b *Bridge) {
+		b.streams = metrics.Register(prometheus.NewGaugeVec(gaugeOpts, []string{"topic"})).(*prometheus.GaugeVec)
+	}
+}
+
+// streamFilter selects the messages sent to a stream, based on the query parameters of the request:
+// each "header=name:value" parameter requires the message to have the header, and "contains" requires
+// the payload to contain the value.
+type streamFilter struct {
+	headers  map[string]string
+	contains []byte
+}
+
+func parseFilter(query url.Values) (*streamFilter, error) {
+	f := &streamFilter{headers: make(map[string]string)}
+	for _, header := range query["header"] {
+		i := strings.IndexByte(header, ':')
+		if i <= 0 {
+			return nil, errors.Errorf("invalid header filter %q, expected name:value", header)
+		}
+		f.headers[strings.ToLower(header[:i])] = header[i+1:]
+	}
+	if contains := query.Get("contains"); contains != "" {
+		f.contains = []byte(contains)
+	}
+	return f, nil
+}
+
+func (f *streamFilter) match(msg substrate.Message) bool {
+	if f.contains != nil && !bytes.Contains(msg.Data(), f.contains) {
+		return false
+	}
+	if len(f.headers) == 0 {
+		return true
+	}
+	headers := message.Headers(msg)
+	for name, value := range f.headers {
+		if actual, ok := headers[name]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeResumeToken returns the resume token of the checkpoint, an URL safe encoding of it.
+func encodeResumeToken(cp checkpoint.Checkpoint) string {
+	data, _ := json.Marshal(cp)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeResumeToken returns the checkpoint of the resume token, or an empty one if the token is empty.
+func decodeResumeToken(token string) (checkpoint.Checkpoint, error) {
+	cp := make(checkpoint.Checkpoint)
+	if token == "" {
+		return cp, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid resume token")
+	}
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, errors.Wrap(err, "invalid resume token")
+	}
+	return cp, nil
+}
+
+// serveStream streams the messages of the topic to the client until it disconnects, the source fails or
+// the bridge is closed.
+func (b *Bridge) serveStream(w http.ResponseWriter, r *http.Request, topic string) {
+	fail := func(code int, err error) {
+		b.countRequest(unknownTopicLabel, code)
+		http.Error(w, err.Error(), code)
+	}
+
+	if b.auth != nil {
+		if err := b.auth(r, topic); err != nil {
+			fail(http.StatusUnauthorized, err)
+			return
+		}
+	}
+	filter, err := parseFilter(r.URL.Query())
+	if err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+	token := r.Header.Get("Last-Event-ID")
+	if param := r.URL.Query().Get(ResumeParam); param != "" {
+		token = param
+	}
+	resume, err := decodeResumeToken(token)
+	if err != nil {
+		fail(http.StatusBadRequest, err)
+		return
+	}
+
+	ctx, done, err := b.startStream(r.Context())
+	if err != nil {
+		fail(http.StatusServiceUnavailable, err)
+		return
+	}
+	defer done()
+
+	source, err := b.sources(topic, copyCheckpoint(resume))
+	switch {
+	case errors.Cause(err) == ErrUnknownTopic:
+		fail(http.StatusNotFound, err)
+		return
+	case err != nil:
+		fail(http.StatusBadGateway, err)
+		return
+	}
+	defer source.Close()
+
+	b.countRequest(topic, http.StatusOK)
+	if b.streams != nil {
+		b.streams.WithLabelValues(topic).Inc()
+		defer b.streams.WithLabelValues(topic).Dec()
+	}
+
+	w.Header().Set("Content-Type", EventStreamContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	if err := b.stream(ctx, w, rc, source, filter, resume); err != nil {
+		var event bytes.Buffer
+		writeEvent(&event, "error", "", []byte(err.Error()))
+		_ = b.write(w, rc, event.Bytes())
+	}
+}
+
+// startStream registers a new stream, returning its context, which is cancelled when the bridge is closed,
+// and a function to call once the stream ended.
+func (b *Bridge) startStream(ctx context.Context) (context.Context, func(), error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return nil, nil, errClosed
+	}
+	b.running.Add(1)
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(b.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+		b.running.Done()
+	}, nil
+}
+
+// stream sends the messages consumed from the source to the client, skipping messages at or before the resume
+// checkpoint. Each message is acknowledged once it was written, or straight away if it is skipped, so the source
+// is only consumed as fast as the client reads.
+func (b *Bridge) stream(ctx context.Context, w http.ResponseWriter, rc *http.ResponseController, source substrate.AsyncMessageSource, filter *streamFilter, resume checkpoint.Checkpoint) error {
+	rg, ctx := rungroup.New(ctx)
+
+	position := copyCheckpoint(resume)
+
+	messages := make(chan substrate.Message)
+	acks := make(chan substrate.Message)
+
+	rg.Go(func() error {
+		return source.ConsumeMessages(ctx, messages, acks)
+	})
+	rg.Go(func() error {
+		var heartbeat <-chan time.Time
+		if b.heartbeat > 0 {
+			ticker := time.NewTicker(b.heartbeat)
+			defer ticker.Stop()
+			heartbeat = ticker.C
+		}
+
+		var event bytes.Buffer
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-heartbeat:
+				if err := b.write(w, rc, []byte(": heartbeat\n\n")); err != nil {
+					return err
+				}
+			case msg := <-messages:
+				send, id := true, ""
+				if pMsg, ok := msg.(checkpoint.Positioned); ok {
+					offset, seen := position[pMsg.Partition()]
+					if seen && pMsg.Offset() <= offset {
+						send = false
+					} else {
+						position[pMsg.Partition()] = pMsg.Offset()
+						id = encodeResumeToken(position)
+					}
+				}
+				if send && filter.match(msg) {
+					event.Reset()
+					writeEvent(&event, "", id, msg.Data())
+					if err := b.write(w, rc, event.Bytes()); err != nil {
+						return err
+					}
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case acks <- msg:
+				}
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+// copyCheckpoint returns a copy of the checkpoint.
+func copyCheckpoint(cp checkpoint.Checkpoint) checkpoint.Checkpoint {
+	c := make(checkpoint.Checkpoint, len(cp))
+	for partition, offset := range cp {
+		c[partition] = offset
+	}
+	return c
+}
+
+// write writes the data to the client and flushes it.
+func (b *Bridge) write(w http.ResponseWriter, rc *http.ResponseController, data []byte) error {
+	if b.writeTimeout > 0 {
+		// writers that don't support deadlines only apply backpressure
+		_ = rc.SetWriteDeadline(time.Now().Add(b.writeTimeout))
+	}
+	if _, err := w.Write(data); err != nil {
+		return errors.Wrap(err, "failed to write event")
+	}
+	return errors.Wrap(rc.Flush(), "failed to flush event")
+}
+
+// writeEvent writes a server-sent event, splitting the data into lines as required by the format.
+func writeEvent(buf *bytes.Buffer, event, id string, data []byte) {
+	if event != "" {
+		buf.WriteString("event: " + event + "\n")
+	}
+	if id != "" {
+		buf.WriteString("id: " + id + "\n")
+	}
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	data = bytes.ReplaceAll(data, []byte("\r"), []byte("\n"))
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+}
@@ -0,0 +1,205 @@
+package httpbridge_test
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/checkpoint"
+	"github.com/uw-labs/substrate-tools/httpbridge"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+type event struct {
+	name string
+	id   string
+	data string
+}
+
+func publish(t *testing.T, broker *mem.Broker, topic string, payloads ...string) {
+	bridge := httpbridge.New(memSinks(broker, topic))
+	defer bridge.Close()
+
+	for _, payload := range payloads {
+		rec := post(t, bridge, "/topics/"+topic, "", payload, nil)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+}
+
+// readEvent reads the next event of the stream, skipping comments.
+func readEvent(t *testing.T, r *bufio.Reader) event {
+	var (
+		e    event
+		data []string
+	)
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && data != nil:
+			e.data = strings.Join(data, "\n")
+			return e
+		case strings.HasPrefix(line, "event: "):
+			e.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "id: "):
+			e.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = append(data, strings.TrimPrefix(line, "data: "))
+		}
+	}
+}
+
+func streamSources(broker *mem.Broker, resumed chan<- checkpoint.Checkpoint) httpbridge.SourceFunc {
+	var streams int64
+	return func(topic string, resume checkpoint.Checkpoint) (substrate.AsyncMessageSource, error) {
+		if topic != "orders" {
+			return nil, errors.Wrap(httpbridge.ErrUnknownTopic, topic)
+		}
+		if resumed != nil {
+			resumed <- resume
+		}
+		// every stream consumes the whole topic using its own consumer group
+		group := "stream-" + strconv.FormatInt(atomic.AddInt64(&streams, 1), 10)
+		return broker.NewAsyncMessageSource(topic, group), nil
+	}
+}
+
+func openStream(t *testing.T, url string, header http.Header) (*http.Response, *bufio.Reader) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return resp, bufio.NewReader(resp.Body)
+}
+
+func TestBridge_StreamResume(t *testing.T) {
+	broker := mem.NewBroker()
+	publish(t, broker, "orders", "one", "two\nlines", "three")
+
+	resumed := make(chan checkpoint.Checkpoint, 2)
+	bridge := httpbridge.New(nil, httpbridge.WithSources(streamSources(broker, resumed)))
+	server := httptest.NewServer(bridge)
+	defer server.Close()
+	defer bridge.Close()
+
+	resp, events := openStream(t, server.URL+"/topics/orders", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, httpbridge.EventStreamContentType, resp.Header.Get("Content-Type"))
+	require.Empty(t, <-resumed)
+
+	require.Equal(t, "one", readEvent(t, events).data)
+	second := readEvent(t, events)
+	require.Equal(t, "two\nlines", second.data)
+	require.NotEmpty(t, second.id)
+	resp.Body.Close()
+
+	resp, events = openStream(t, server.URL+"/topics/orders", http.Header{"Last-Event-Id": {second.id}})
+	defer resp.Body.Close()
+	require.Equal(t, checkpoint.Checkpoint{"0": 1}, <-resumed)
+	require.Equal(t, "three", readEvent(t, events).data)
+
+	publish(t, broker, "orders", "four")
+	require.Equal(t, "four", readEvent(t, events).data)
+}
+
+func TestBridge_StreamFilter(t *testing.T) {
+	broker := mem.NewBroker()
+	publish(t, broker, "orders", "apple", "banana", "cherry", "pineapple")
+
+	bridge := httpbridge.New(nil, httpbridge.WithSources(streamSources(broker, nil)))
+	server := httptest.NewServer(bridge)
+	defer server.Close()
+	defer bridge.Close()
+
+	resp, events := openStream(t, server.URL+"/topics/orders?contains=apple", nil)
+	defer resp.Body.Close()
+	require.Equal(t, "apple", readEvent(t, events).data)
+	require.Equal(t, "pineapple", readEvent(t, events).data)
+}
+
+func TestBridge_StreamHeaderFilter(t *testing.T) {
+	newMessage := func(data, region string) substrate.Message {
+		return message.NewEnvelopedMessage(message.Envelope{Payload: []byte(data), Headers: map[string]string{"region": region}})
+	}
+	bridge := httpbridge.New(nil, httpbridge.WithSources(func(string, checkpoint.Checkpoint) (substrate.AsyncMessageSource, error) {
+		return &mock.AsyncMessageSource{
+			Messages: []substrate.Message{newMessage("one", "eu"), newMessage("two", "us"), newMessage("three", "eu")},
+		}, nil
+	}))
+	server := httptest.NewServer(bridge)
+	defer server.Close()
+	defer bridge.Close()
+
+	resp, events := openStream(t, server.URL+"/topics/orders?header=Region:eu", nil)
+	defer resp.Body.Close()
+	require.Equal(t, event{data: "one"}, readEvent(t, events))
+	require.Equal(t, event{data: "three"}, readEvent(t, events))
+}
+
+func TestBridge_StreamErrors(t *testing.T) {
+	broker := mem.NewBroker()
+	bridge := httpbridge.New(nil,
+		httpbridge.WithSources(streamSources(broker, nil)),
+		httpbridge.WithAuth(httpbridge.BearerToken("secret")),
+	)
+	defer bridge.Close()
+	auth := http.Header{"Authorization": {"Bearer secret"}}
+
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rec := httptest.NewRecorder()
+		bridge.ServeHTTP(rec, req)
+		return rec
+	}
+
+	require.Equal(t, http.StatusUnauthorized, get("/topics/orders", nil).Code)
+	require.Equal(t, http.StatusNotFound, get("/topics/unknown", auth).Code)
+	require.Equal(t, http.StatusBadRequest, get("/topics/orders?resume=invalid", auth).Code)
+	require.Equal(t, http.StatusBadRequest, get("/topics/orders?header=invalid", auth).Code)
+
+	// publishing is disabled without sinks
+	rec := post(t, bridge, "/topics/orders", "", "payload", nil)
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	require.Equal(t, http.MethodGet, rec.Header().Get("Allow"))
+}
+
+func TestBridge_CloseEndsStreams(t *testing.T) {
+	broker := mem.NewBroker()
+	bridge := httpbridge.New(nil,
+		httpbridge.WithSources(streamSources(broker, nil)),
+		httpbridge.WithHeartbeatInterval(10*time.Millisecond),
+	)
+	server := httptest.NewServer(bridge)
+	defer server.Close()
+
+	resp, events := openStream(t, server.URL+"/topics/orders", nil)
+	defer resp.Body.Close()
+
+	// heartbeats are sent while there are no messages
+	line, err := events.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, ": heartbeat\n", line)
+
+	require.NoError(t, bridge.Close())
+	_, err = io.ReadAll(events)
+	require.NoError(t, err)
+}
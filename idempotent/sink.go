@@ -0,0 +1,167 @@
+// Package idempotent provides a message sink wrapper that suppresses re-publishing messages which were
+// already confirmed by the backend, e.g. after a restart.
+package idempotent
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/internal/inorder"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+const (
+	// ProducerIDHeader is the header recording the ID of the producer that published a message.
+	ProducerIDHeader = "idempotent-producer-id"
+	// SequenceHeader is the header recording the sequence ID of a message.
+	SequenceHeader = "idempotent-sequence"
+)
+
+// SequenceFunc is a function that returns the sequence ID of a message. Sequence IDs must be positive, increase
+// monotonically in the order in which messages are published and be derived from the message, e.g. from the offset
+// of the input it was produced from, so that the same message gets the same sequence ID after a restart.
+type SequenceFunc func(msg substrate.Message) uint64
+
+// Key returns the producer ID and the sequence ID of a message published by an idempotent sink, or an
+// empty string if it carries neither. It can be used as the key function of the dedup package to drop
+// duplicates on the consumer side.
+func Key(msg substrate.Message) string {
+	headers := message.Headers(msg)
+	producerID, sequence := headers[ProducerIDHeader], headers[SequenceHeader]
+	if producerID == "" || sequence == "" {
+		return ""
+	}
+	return producerID + "/" + sequence
+}
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that assigns a sequence ID to every
+// message using the sequence function and persists the highest sequence ID confirmed by the backend for the producer
+// in the store. Messages with a sequence ID at or below the stored high-water mark are acknowledged without being
+// published, so a producer that publishes the same messages again after a restart doesn't publish duplicates. The
+// sequence function must therefore assign the same sequence IDs to the same messages across restarts: numbering
+// messages in the order in which they are published would suppress the new messages of a restarted producer.
+// The producer ID and sequence ID are recorded in the ProducerIDHeader and SequenceHeader headers, so that
+// messages published again after a crash can be deduplicated by consumers, giving effectively-once semantics.
+// Messages are published as message.EnvelopedMessage, so that the envelope sink preserves their metadata.
+func NewAsyncMessageSink(sink substrate.AsyncMessageSink, producerID string, store Store, sequence SequenceFunc) substrate.AsyncMessageSink {
+	return &idempotentSink{
+		sink:       sink,
+		producerID: producerID,
+		store:      store,
+		sequence:   sequence,
+	}
+}
+
+type idempotentSink struct {
+	sink       substrate.AsyncMessageSink
+	producerID string
+	store      Store
+	sequence   SequenceFunc
+}
+
+// pendingMessage is a message waiting to be acknowledged, published is nil for suppressed messages.
+type pendingMessage struct {
+	original  substrate.Message
+	published *message.EnvelopedMessage
+	sequence  uint64
+}
+
+func (s *idempotentSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "idempotent"})
+	highWaterMark, err := s.store.HighWaterMark(ctx, s.producerID)
+	if err != nil {
+		return emit.Error(errors.Wrap(err, "failed to read high-water mark"))
+	}
+
+	rg, ctx := rungroup.New(ctx)
+
+	toPublish := make(chan substrate.Message, cap(messages))
+	published := make(chan substrate.Message, cap(acks))
+	queue := inorder.NewQueue[*message.EnvelopedMessage, pendingMessage]()
+
+	rg.Go(func() error {
+		return s.sink.PublishMessages(ctx, published, toPublish)
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-messages:
+				emit.Publish(msg)
+				sequence := s.sequence(msg)
+				if sequence <= highWaterMark {
+					queue.PushDone(pendingMessage{original: msg, sequence: sequence})
+					continue
+				}
+
+				pMsg := pendingMessage{original: msg, published: s.wrap(msg, sequence), sequence: sequence}
+				queue.Push(0, pMsg.published, pMsg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case toPublish <- pMsg.published:
+				}
+			}
+		}
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			var released []pendingMessage
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-queue.Ready():
+				released = queue.Release()
+			case ack := <-published:
+				eMsg, ok := ack.(*message.EnvelopedMessage)
+				if ok {
+					released, ok = queue.Complete(0, eMsg)
+				}
+				if !ok {
+					return errors.Errorf("unexpected message acknowledged: %v", ack)
+				}
+			}
+			for _, msg := range released {
+				if msg.published != nil {
+					if err := s.store.SetHighWaterMark(ctx, s.producerID, msg.sequence); err != nil {
+						return errors.Wrap(err, "failed to store high-water mark")
+					}
+				}
+				emit.Ack(msg.original)
+				select {
+				case <-ctx.Done():
+					return nil
+				case acks <- msg.original:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+// wrap returns the message to publish in place of the provided one.
+func (s *idempotentSink) wrap(msg substrate.Message, sequence uint64) *message.EnvelopedMessage {
+	envelope, _ := message.EnvelopeOf(msg)
+	envelope.Headers = message.Headers(msg)
+	envelope.Headers[ProducerIDHeader] = s.producerID
+	envelope.Headers[SequenceHeader] = strconv.FormatUint(sequence, 10)
+	if envelope.ID == "" {
+		envelope.ID = s.producerID + "/" + envelope.Headers[SequenceHeader]
+	}
+	return message.NewEnvelopedMessage(envelope)
+}
+
+func (s *idempotentSink) Close() error {
+	return s.sink.Close()
+}
+
+func (s *idempotentSink) Status() (*substrate.Status, error) {
+	return s.sink.Status()
+}
@@ -0,0 +1,119 @@
+package idempotent_test
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/idempotent"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+func publish(ctx context.Context, t *testing.T, sink substrate.AsyncMessageSink, payloads ...string) []string {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	go func() {
+		for _, payload := range payloads {
+			select {
+			case <-ctx.Done():
+				return
+			case messages <- message.FromString(payload):
+			}
+		}
+	}()
+
+	var acked []string
+	for range payloads {
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "messages weren't acknowledged")
+		case ack := <-acks:
+			acked = append(acked, string(ack.Data()))
+		}
+	}
+	cancel()
+	require.NoError(t, <-errs)
+	return acked
+}
+
+func envelopes(t *testing.T, broker *mem.Broker) []message.Envelope {
+	var envelopes []message.Envelope
+	for _, data := range broker.Messages("topic") {
+		var envelope message.Envelope
+		require.NoError(t, json.Unmarshal(data, &envelope))
+		envelopes = append(envelopes, envelope)
+	}
+	return envelopes
+}
+
+// sequence numbers messages by their payload, a letter, 'a' being 1.
+func sequence(msg substrate.Message) uint64 {
+	return uint64(msg.Data()[0]-'a') + 1
+}
+
+func TestSuppressDuplicatesAfterRestart(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	store, err := idempotent.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	sink := idempotent.NewAsyncMessageSink(message.NewEnvelopeSink(broker.NewAsyncMessageSink("topic")), "producer", store, sequence)
+	require.Equal(t, []string{"a", "b"}, publish(ctx, t, sink, "a", "b"))
+
+	// A new instance of the producer publishes the same messages again.
+	sink = idempotent.NewAsyncMessageSink(message.NewEnvelopeSink(broker.NewAsyncMessageSink("topic")), "producer", store, sequence)
+	require.Equal(t, []string{"a", "b", "c"}, publish(ctx, t, sink, "a", "b", "c"))
+
+	published := envelopes(t, broker)
+	require.Len(t, published, 3)
+	for i, envelope := range published {
+		require.Equal(t, string(rune('a'+i)), string(envelope.Payload))
+		require.Equal(t, "producer", envelope.Headers[idempotent.ProducerIDHeader])
+		require.Equal(t, strconv.Itoa(i+1), envelope.Headers[idempotent.SequenceHeader])
+		require.Equal(t, "producer/"+strconv.Itoa(i+1), envelope.ID)
+	}
+
+	highWaterMark, err := store.HighWaterMark(ctx, "producer")
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), highWaterMark)
+
+	eMsg := message.NewEnvelopedMessage(published[2])
+	require.Equal(t, "producer/3", idempotent.Key(eMsg))
+	require.Equal(t, "", idempotent.Key(message.FromString("c")))
+}
+
+func TestSuppressedMessagesAreAckedInOrder(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	store := idempotent.NewMemoryStore()
+	require.NoError(t, store.SetHighWaterMark(ctx, "producer", 2))
+
+	broker := mem.NewBroker()
+	sink := idempotent.NewAsyncMessageSink(broker.NewAsyncMessageSink("topic"), "producer", store, func(msg substrate.Message) uint64 {
+		sequence, _ := strconv.ParseUint(string(msg.Data()), 10, 64)
+		return sequence
+	})
+
+	require.Equal(t, []string{"1", "3", "2", "4"}, publish(ctx, t, sink, "1", "3", "2", "4"))
+	require.Equal(t, [][]byte{[]byte("3"), []byte("4")}, broker.Messages("topic"))
+
+	highWaterMark, err := store.HighWaterMark(ctx, "producer")
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), highWaterMark)
+}
@@ -0,0 +1,105 @@
+package idempotent
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Store persists the high-water mark of each producer, i.e. the highest sequence ID that was confirmed by
+// the backend. Implementations backed by external storage allow producers to be moved between hosts.
+type Store interface {
+	// HighWaterMark returns the high-water mark of the producer, or 0 if none was stored.
+	HighWaterMark(ctx context.Context, producerID string) (uint64, error)
+	// SetHighWaterMark stores the high-water mark of the producer.
+	SetHighWaterMark(ctx context.Context, producerID string, sequence uint64) error
+}
+
+// NewMemoryStore returns an in-memory store, which only suppresses duplicates published by the same process.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		marks: make(map[string]uint64),
+	}
+}
+
+type memoryStore struct {
+	mutex sync.Mutex
+	marks map[string]uint64
+}
+
+func (s *memoryStore) HighWaterMark(_ context.Context, producerID string) (uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.marks[producerID], nil
+}
+
+func (s *memoryStore) SetHighWaterMark(_ context.Context, producerID string, sequence uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.marks[producerID] = sequence
+	return nil
+}
+
+// NewFileStore returns a store that keeps the high-water mark of each producer in a file in the directory.
+// Files are replaced atomically, so that a crash never leaves a partially written high-water mark behind.
+func NewFileStore(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "failed to create store directory")
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+type fileStore struct {
+	dir string
+}
+
+func (s *fileStore) HighWaterMark(_ context.Context, producerID string) (uint64, error) {
+	data, err := os.ReadFile(s.path(producerID))
+	switch {
+	case os.IsNotExist(err):
+		return 0, nil
+	case err != nil:
+		return 0, errors.Wrap(err, "failed to read high-water mark")
+	}
+	sequence, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to parse high-water mark")
+	}
+	return sequence, nil
+}
+
+func (s *fileStore) SetHighWaterMark(_ context.Context, producerID string, sequence uint64) error {
+	tmp, err := os.CreateTemp(s.dir, ".hwm-*")
+	if err != nil {
+		return errors.Wrap(err, "failed to write high-water mark")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(strconv.FormatUint(sequence, 10)); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write high-water mark")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write high-water mark")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to write high-water mark")
+	}
+	if err := os.Rename(tmp.Name(), s.path(producerID)); err != nil {
+		return errors.Wrap(err, "failed to write high-water mark")
+	}
+	return nil
+}
+
+func (s *fileStore) path(producerID string) string {
+	return filepath.Join(s.dir, url.PathEscape(producerID)+".hwm")
+}
@@ -0,0 +1,41 @@
+package idempotent_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate-tools/idempotent"
+)
+
+func TestStores(t *testing.T) {
+	fileStore, err := idempotent.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	stores := map[string]idempotent.Store{
+		"memory": idempotent.NewMemoryStore(),
+		"file":   fileStore,
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			highWaterMark, err := store.HighWaterMark(ctx, "producer/1")
+			require.NoError(t, err)
+			require.Equal(t, uint64(0), highWaterMark)
+
+			require.NoError(t, store.SetHighWaterMark(ctx, "producer/1", 10))
+			require.NoError(t, store.SetHighWaterMark(ctx, "producer/1", 42))
+			require.NoError(t, store.SetHighWaterMark(ctx, "producer/2", 7))
+
+			highWaterMark, err = store.HighWaterMark(ctx, "producer/1")
+			require.NoError(t, err)
+			require.Equal(t, uint64(42), highWaterMark)
+
+			highWaterMark, err = store.HighWaterMark(ctx, "producer/2")
+			require.NoError(t, err)
+			require.Equal(t, uint64(7), highWaterMark)
+		})
+	}
+}
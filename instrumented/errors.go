@@ -0,0 +1,137 @@
+package instrumented
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+)
+
+// The error types reported by DefaultErrorClassifier.
+const (
+	ErrorTypeTimeout            = "timeout"
+	ErrorTypeAuth               = "auth"
+	ErrorTypeSerialization      = "serialization"
+	ErrorTypeBackendUnavailable = "backend_unavailable"
+	ErrorTypeUnknown            = "unknown"
+)
+
+var (
+	sinkErrorLabels   = []string{"error_type", "topic"}
+	sourceErrorLabels = []string{"error_type", "topic", "consumer"}
+)
+
+// ErrorClassifier returns the type of an error, which is used as the value of the "error_type" label of the error
+// counters. It should only return a small, fixed set of types, to keep the number of label values bounded.
+type ErrorClassifier func(err error) string
+
+// ErrorTyper can be implemented by errors to report their type to DefaultErrorClassifier.
+type ErrorTyper interface {
+	ErrorType() string
+}
+
+// DefaultErrorClassifier classifies errors using the type reported by errors implementing ErrorTyper, the
+// timeouts of the context and network errors, refused or reset connections, gRPC status codes and JSON
+// decoding errors. Other errors are reported as ErrorTypeUnknown.
+func DefaultErrorClassifier(err error) string {
+	var typer ErrorTyper
+	if errors.As(err, &typer) {
+		return typer.ErrorType()
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return ErrorTypeTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTypeTimeout
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET), errors.Is(err, net.ErrClosed):
+		return ErrorTypeBackendUnavailable
+	}
+
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &grpcErr) {
+		switch grpcErr.GRPCStatus().Code() {
+		case codes.DeadlineExceeded:
+			return ErrorTypeTimeout
+		case codes.Unauthenticated, codes.PermissionDenied:
+			return ErrorTypeAuth
+		case codes.Unavailable:
+			return ErrorTypeBackendUnavailable
+		}
+	}
+
+	var (
+		syntaxErr    *json.SyntaxError
+		unmarshalErr *json.UnmarshalTypeError
+	)
+	if errors.As(err, &syntaxErr) || errors.As(err, &unmarshalErr) {
+		return ErrorTypeSerialization
+	}
+	return ErrorTypeUnknown
+}
+
+// errorCounter counts errors by their type.
+type errorCounter struct {
+	counter    Counter
+	classifier ErrorClassifier
+}
+
+func newErrorCounter(counter Counter, classifier ErrorClassifier) *errorCounter {
+	if classifier == nil {
+		classifier = DefaultErrorClassifier
+	}
+	return &errorCounter{counter: counter, classifier: classifier}
+}
+
+// record increments the counter of the type of the error, with the other label values following the type.
+func (c *errorCounter) record(err error, labelValues ...string) {
+	if c != nil {
+		c.counter.Add(1, append([]string{c.classifier(err)}, labelValues...)...)
+	}
+}
+
+// WithPublishErrorCounter enables a counter of the errors the sink failed with, labelled with topic and error_type,
+// the type being determined by the classifier, or DefaultErrorClassifier if it is nil. It tells transient failures
+// apart from fatal ones, which the error status of the messages counter doesn't. The suggested name for the
+// counter is "substrate_sink_errors_total". It panics in case it can't register the metric.
+func WithPublishErrorCounter(counterOpts prometheus.CounterOpts, classifier ErrorClassifier) SinkOption {
+	return func(ams *instrumentedSink) {
+		counter := prometheusCounter{metrics.Register(prometheus.NewCounterVec(counterOpts, sinkErrorLabels)).(*prometheus.CounterVec)}
+		ams.errors = newErrorCounter(counter, classifier)
+	}
+}
+
+// WithPublishErrors is like WithPublishErrorCounter, but creates the counter using the metrics the sink was
+// created with.
+func WithPublishErrors(counterOpts MetricOpts, classifier ErrorClassifier) SinkOption {
+	return func(ams *instrumentedSink) {
+		ams.errors = newErrorCounter(ams.metrics.NewCounter(counterOpts, sinkErrorLabels), classifier)
+	}
+}
+
+// WithConsumeErrorCounter enables a counter of the errors the source failed with, labelled with topic, consumer
+// and error_type, the type being determined by the classifier, or DefaultErrorClassifier if it is nil. The
+// suggested name for the counter is "substrate_source_errors_total". It panics in case it can't register the metric.
+func WithConsumeErrorCounter(counterOpts prometheus.CounterOpts, classifier ErrorClassifier) SourceOption {
+	return func(ams *instrumentedSource) {
+		counter := prometheusCounter{metrics.Register(prometheus.NewCounterVec(counterOpts, sourceErrorLabels)).(*prometheus.CounterVec)}
+		ams.errors = newErrorCounter(counter, classifier)
+	}
+}
+
+// WithConsumeErrors is like WithConsumeErrorCounter, but creates the counter using the metrics the source was
+// created with.
+func WithConsumeErrors(counterOpts MetricOpts, classifier ErrorClassifier) SourceOption {
+	return func(ams *instrumentedSource) {
+		ams.errors = newErrorCounter(ams.metrics.NewCounter(counterOpts, sourceErrorLabels), classifier)
+	}
+}
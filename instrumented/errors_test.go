@@ -0,0 +1,91 @@
+package instrumented
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+type typedError string
+
+func (e typedError) Error() string     { return string(e) }
+func (e typedError) ErrorType() string { return "quota" }
+
+func TestDefaultErrorClassifier(t *testing.T) {
+	var syntaxErr *json.SyntaxError
+	require.ErrorAs(t, json.Unmarshal([]byte("{"), &struct{}{}), &syntaxErr)
+
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{err: fmt.Errorf("publishing: %w", context.DeadlineExceeded), expected: ErrorTypeTimeout},
+		{err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}, expected: ErrorTypeBackendUnavailable},
+		{err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, expected: ErrorTypeBackendUnavailable},
+		{err: status.Error(codes.Unauthenticated, "invalid token"), expected: ErrorTypeAuth},
+		{err: status.Error(codes.Unavailable, "no leader"), expected: ErrorTypeBackendUnavailable},
+		{err: fmt.Errorf("decoding: %w", syntaxErr), expected: ErrorTypeSerialization},
+		{err: fmt.Errorf("publishing: %w", typedError("too many requests")), expected: "quota"},
+		{err: errors.New("boom"), expected: ErrorTypeUnknown},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.expected, DefaultErrorClassifier(test.err), test.err.Error())
+	}
+}
+
+func TestFactory_Errors(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	factory := NewFactory(registry, "app", WithFactoryErrors(nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	sink := factory.NewAsyncMessageSink(mock.NewSink().FailAt(1, status.Error(codes.Unavailable, "no leader")), "orders")
+	messages := make(chan substrate.Message, 1)
+	messages <- message.FromString("payload")
+	require.Error(t, sink.PublishMessages(ctx, make(chan substrate.Message), messages))
+
+	source := factory.NewAsyncMessageSource(mock.NewSource(message.FromString("payload")).FailAt(1, context.DeadlineExceeded), "orders", "consumer")
+	require.Error(t, source.ConsumeMessages(ctx, make(chan substrate.Message), make(chan substrate.Message)))
+
+	sinkErrors := factory.sinkErrors.counter.(prometheusCounter)
+	require.Equal(t, float64(1), testutil.ToFloat64(sinkErrors.WithLabelValues(ErrorTypeBackendUnavailable, "orders")))
+	sourceErrors := factory.sourceErrors.counter.(prometheusCounter)
+	require.Equal(t, float64(1), testutil.ToFloat64(sourceErrors.WithLabelValues(ErrorTypeTimeout, "orders", "consumer")))
+}
+
+func TestSink_ErrorClassifier(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	classifier := func(error) string {
+		return "custom"
+	}
+	counterOpts := prometheus.CounterOpts{Name: "instrumented_test_sink_messages_total", Help: "instrumented_test_sink_messages_total"}
+	errorOpts := prometheus.CounterOpts{Name: "instrumented_test_sink_errors_total", Help: "instrumented_test_sink_errors_total"}
+	sink := NewAsyncMessageSink(mock.NewSink().FailAt(1, errors.New("boom")), counterOpts, "orders", WithPublishErrorCounter(errorOpts, classifier))
+
+	// the counter is registered globally, so it may have been incremented by previous runs
+	counter := sink.(*instrumentedSink).errors.counter.(prometheusCounter)
+	before := testutil.ToFloat64(counter.WithLabelValues("custom", "orders"))
+
+	messages := make(chan substrate.Message, 1)
+	messages <- message.FromString("payload")
+	require.EqualError(t, sink.PublishMessages(ctx, make(chan substrate.Message), messages), "boom")
+	require.Equal(t, before+1, testutil.ToFloat64(counter.WithLabelValues("custom", "orders")))
+}
@@ -0,0 +1,30 @@
+package instrumented
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+// TraceIDExemplarLabel is the label of the exemplars attached to latency observations, holding the ID of the trace
+// of the observed message.
+const TraceIDExemplarLabel = "trace_id"
+
+// traceExemplar returns an exemplar holding the ID of the trace of the message, if it carries the W3C trace context
+// of a sampled trace in its headers, such as the messages of the traced wrappers. It returns nil otherwise.
+func traceExemplar(msg substrate.Message) map[string]string {
+	hMsg, ok := msg.(message.HeaderedMessage)
+	if !ok {
+		return nil
+	}
+	ctx := propagation.TraceContext{}.Extract(context.Background(), propagation.MapCarrier(hMsg.Headers()))
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() || !spanContext.IsSampled() {
+		return nil
+	}
+	return map[string]string{TraceIDExemplarLabel: spanContext.TraceID().String()}
+}
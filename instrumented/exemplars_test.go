@@ -0,0 +1,91 @@
+package instrumented
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+func tracedMessage(flags string) substrate.Message {
+	return message.NewEnvelopedMessage(message.Envelope{
+		Headers: map[string]string{"traceparent": "00-" + traceID + "-00f067aa0ba902b7-" + flags},
+		Payload: []byte("payload"),
+	})
+}
+
+// exemplars returns the trace IDs of the exemplars of the buckets of the histogram.
+func exemplars(t *testing.T, histogram prometheus.Histogram) []string {
+	var metric dto.Metric
+	require.NoError(t, histogram.Write(&metric))
+
+	var ids []string
+	for _, bucket := range metric.Histogram.Bucket {
+		if bucket.Exemplar == nil {
+			continue
+		}
+		for _, label := range bucket.Exemplar.Label {
+			if label.GetName() == TraceIDExemplarLabel {
+				ids = append(ids, label.GetValue())
+			}
+		}
+	}
+	return ids
+}
+
+func TestLatencyExemplars(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	histogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "exemplars_test_latency",
+		Help:    "exemplars_test_latency",
+		Buckets: []float64{60},
+	}, sinkLabels)
+	sink := newInstrumentedSink(&asyncMessageSinkMock{
+		publishMessageMock: func(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case msg := <-messages:
+					acks <- msg
+				}
+			}
+		},
+	}, NewPrometheusMetrics(prometheus.NewRegistry()), prometheusCounter{prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "exemplars_test_counter",
+		Help: "exemplars_test_counter",
+	}, sinkLabels)}, "topic", []SinkOption{func(s *instrumentedSink) {
+		s.latency = prometheusHistogram{histogram}
+	}})
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	// Messages of unsampled traces, or without trace context, are observed without exemplars.
+	for _, msg := range []substrate.Message{tracedMessage("00"), message.FromString("payload")} {
+		messages <- msg
+		<-acks
+	}
+	observer := histogram.WithLabelValues("success", "topic").(prometheus.Histogram)
+	require.Empty(t, exemplars(t, observer))
+
+	messages <- tracedMessage("01")
+	<-acks
+	require.Equal(t, []string{traceID}, exemplars(t, observer))
+
+	cancel()
+	require.NoError(t, <-errs)
+}
@@ -0,0 +1,136 @@
+package instrumented
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uw-labs/substrate"
+)
+
+// FactoryOption is a function which sets a Factory configuration option.
+type FactoryOption func(f *Factory)
+
+// WithFactoryLatency enables the latency histogram of the sinks created by the factory, named
+// "<namespace>_sink_latency_seconds", using the buckets, or the default ones if there are none.
+func WithFactoryLatency(buckets ...float64) FactoryOption {
+	return func(f *Factory) {
+		f.latency = f.metrics.NewHistogram(f.opts("sink", "latency_seconds", "Time between a message being received by the sink and its acknowledgement.", buckets), sinkLabels)
+	}
+}
+
+// WithFactoryLag enables the lag gauge of the sources created by the factory, named "<namespace>_source_lag".
+func WithFactoryLag() FactoryOption {
+	return func(f *Factory) {
+		f.lag = f.metrics.NewGauge(f.opts("source", "lag", "Number of messages behind the high water mark of the partition.", nil), lagLabels)
+	}
+}
+
+// WithFactorySize enables the payload size histogram of the sources created by the factory, named
+// "<namespace>_source_message_size_bytes", using the buckets, or the default ones if there are none.
+func WithFactorySize(buckets ...float64) FactoryOption {
+	return func(f *Factory) {
+		f.size = f.metrics.NewHistogram(f.opts("source", "message_size_bytes", "Payload sizes of consumed messages in bytes.", buckets), sizeLabels)
+	}
+}
+
+// WithFactoryAckLatency enables the ack latency histogram of the sources created by the factory, named
+// "<namespace>_source_ack_latency_seconds", using the buckets, or the default ones if there are none.
+func WithFactoryAckLatency(buckets ...float64) FactoryOption {
+	return func(f *Factory) {
+		f.ackLatency = f.metrics.NewHistogram(f.opts("source", "ack_latency_seconds", "Time between a message being delivered to the application and its acknowledgement.", buckets), sizeLabels)
+	}
+}
+
+// WithFactoryBytes enables the consumed bytes counter of the sources created by the factory, named
+// "<namespace>_source_consumed_bytes_total".
+func WithFactoryBytes() FactoryOption {
+	return func(f *Factory) {
+		f.bytes = f.metrics.NewCounter(f.opts("source", "consumed_bytes_total", "Total payload size of consumed messages in bytes.", nil), sizeLabels)
+	}
+}
+
+// WithFactoryErrors enables the error counters of the sinks and sources created by the factory, named
+// "<namespace>_sink_errors_total" and "<namespace>_source_errors_total", labelled with the type of the errors
+// as determined by the classifier, or DefaultErrorClassifier if it is nil.
+func WithFactoryErrors(classifier ErrorClassifier) FactoryOption {
+	return func(f *Factory) {
+		f.sinkErrors = newErrorCounter(f.metrics.NewCounter(f.opts("sink", "errors_total", "Number of errors the sink failed with, by type.", nil), sinkErrorLabels), classifier)
+		f.sourceErrors = newErrorCounter(f.metrics.NewCounter(f.opts("source", "errors_total", "Number of errors the source failed with, by type.", nil), sourceErrorLabels), classifier)
+	}
+}
+
+// WithFactoryReconnects enables the reconnection counter of the sinks and sources created by the factory, named
+// "<namespace>_reconnects_total", see WithPublishReconnectCounter and WithConsumeReconnectCounter.
+func WithFactoryReconnects() FactoryOption {
+	return func(f *Factory) {
+		f.reconnects = f.metrics.NewCounter(f.opts("", "reconnects_total", "Number of times publishing or consuming was restarted after failing.", nil), reconnectLabels)
+	}
+}
+
+// Factory creates instrumented sinks and sources for any number of topics, which share the metrics registered
+// when the factory was created instead of registering their own. It is safe for concurrent use.
+type Factory struct {
+	metrics   Metrics
+	namespace string
+
+	sinkCounter   Counter
+	sourceCounter Counter
+	latency       Histogram
+	lag           Gauge
+	size          Histogram
+	bytes         Counter
+	ackLatency    Histogram
+	sinkErrors    *errorCounter
+	sourceErrors  *errorCounter
+	reconnects    Counter
+}
+
+// NewFactory returns a factory registering its metrics with the registerer, or the default one if it is nil,
+// with names prefixed by the namespace. The counters of the sinks and sources are named
+// "<namespace>_sink_messages_total" and "<namespace>_source_messages_total". It panics in case it can't register
+// the metrics.
+func NewFactory(registerer prometheus.Registerer, namespace string, opts ...FactoryOption) *Factory {
+	return NewFactoryWithMetrics(NewPrometheusMetrics(registerer), namespace, opts...)
+}
+
+// NewFactoryWithMetrics is like NewFactory, but creates the metrics using the provided metrics.
+func NewFactoryWithMetrics(metrics Metrics, namespace string, opts ...FactoryOption) *Factory {
+	f := &Factory{
+		metrics:   metrics,
+		namespace: namespace,
+	}
+	f.sinkCounter = metrics.NewCounter(f.opts("sink", "messages_total", "Number of messages published to the sink.", nil), sinkLabels)
+	f.sourceCounter = metrics.NewCounter(f.opts("source", "messages_total", "Number of messages consumed from the source.", nil), sourceLabels)
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+func (f *Factory) opts(subsystem, name, help string, buckets []float64) MetricOpts {
+	return MetricOpts{
+		Name:    prometheus.BuildFQName(f.namespace, subsystem, name),
+		Help:    help,
+		Buckets: buckets,
+	}
+}
+
+// NewAsyncMessageSink returns an instrumented sink for the topic recording the metrics of the factory. Options
+// creating their own metrics use the metrics the factory was created with.
+func (f *Factory) NewAsyncMessageSink(sink substrate.AsyncMessageSink, topic string, opts ...SinkOption) substrate.AsyncMessageSink {
+	return newInstrumentedSink(sink, f.metrics, f.sinkCounter, topic, append([]SinkOption{func(ams *instrumentedSink) {
+		ams.latency, ams.errors = f.latency, f.sinkErrors
+		if f.reconnects != nil {
+			ams.reconnects = newReconnectTracker(f.reconnects, reconnectKindSink, topic, "")
+		}
+	}}, opts...))
+}
+
+// NewAsyncMessageSource returns an instrumented source for the topic and consumer recording the metrics of the
+// factory. Options creating their own metrics use the metrics the factory was created with.
+func (f *Factory) NewAsyncMessageSource(source substrate.AsyncMessageSource, topic, consumer string, opts ...SourceOption) substrate.AsyncMessageSource {
+	return newInstrumentedSource(source, f.metrics, f.sourceCounter, topic, consumer, append([]SourceOption{func(ams *instrumentedSource) {
+		ams.lag, ams.size, ams.bytes, ams.ackLatency, ams.errors = f.lag, f.size, f.bytes, f.ackLatency, f.sourceErrors
+		if f.reconnects != nil {
+			ams.reconnects = newReconnectTracker(f.reconnects, reconnectKindSource, topic, consumer)
+		}
+	}}, opts...))
+}
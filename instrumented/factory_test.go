@@ -0,0 +1,73 @@
+package instrumented
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+func TestFactory_SharesMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	factory := NewFactory(registry, "app", WithFactoryLatency(0.1, 1))
+	// creating another factory with the same registry doesn't panic
+	NewFactory(registry, "app")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	for _, topic := range []string{"orders", "payments"} {
+		sink := factory.NewAsyncMessageSink(mock.NewSink(), topic)
+		acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+		sinkCtx, sinkCancel := context.WithCancel(ctx)
+		errs := make(chan error, 1)
+		go func() {
+			errs <- sink.PublishMessages(sinkCtx, acks, messages)
+		}()
+		messages <- message.FromString("payload")
+		<-acks
+		sinkCancel()
+		require.NoError(t, <-errs)
+	}
+
+	counter := factory.sinkCounter.(prometheusCounter)
+	require.Equal(t, float64(1), testutil.ToFloat64(counter.WithLabelValues("success", "orders")))
+	require.Equal(t, float64(1), testutil.ToFloat64(counter.WithLabelValues("success", "payments")))
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	var names []string
+	for _, family := range families {
+		names = append(names, family.GetName())
+	}
+	// metrics without any label values, such as the source counter, aren't gathered
+	require.Equal(t, []string{"app_sink_latency_seconds", "app_sink_messages_total"}, names)
+}
+
+func TestFactory_Source(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	factory := NewFactory(registry, "app", WithFactoryBytes())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source := factory.NewAsyncMessageSource(mock.NewSource(message.FromString("payload")), "orders", "consumer")
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+	acks <- <-messages
+	cancel()
+	require.NoError(t, <-errs)
+
+	bytes := factory.bytes.(prometheusCounter)
+	require.Equal(t, float64(len("payload")), testutil.ToFloat64(bytes.WithLabelValues("orders", "consumer")))
+}
@@ -0,0 +1,130 @@
+package instrumented
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uw-labs/substrate"
+
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+// OtherLabelValue is the value of a message label reported for the values exceeding its cardinality limit.
+const OtherLabelValue = "other"
+
+const defaultMaxLabelValues = 100
+
+// LabelExtractor returns the value of a label for a message, e.g. the tenant the message belongs to.
+type LabelExtractor func(msg substrate.Message) string
+
+// HeaderLabel returns a LabelExtractor returning the value of the header of messages, or an empty string for
+// messages without it.
+func HeaderLabel(header string) LabelExtractor {
+	return func(msg substrate.Message) string {
+		return message.Headers(msg)[header]
+	}
+}
+
+// MessageLabel is an additional label of the metrics of messages, whose value is extracted from each message,
+// e.g. to count messages by tenant. As every value creates a new series, the number of values reported as they
+// are is limited, all others being reported as OtherLabelValue.
+type MessageLabel struct {
+	// Name is the name of the label, e.g. "tenant".
+	Name string
+	// Extractor returns the value of the label for a message.
+	Extractor LabelExtractor
+	// Values are the only values reported as they are if it isn't empty, e.g. the largest tenants.
+	Values []string
+	// MaxValues is the maximum number of values reported as they are if Values is empty, those being the first
+	// distinct values extracted. The default is 100.
+	MaxValues int
+}
+
+// labelCounter counts messages by the value of a message label, bounding its cardinality.
+type labelCounter struct {
+	counter   Counter
+	extractor LabelExtractor
+	fixed     bool
+	max       int
+
+	mutex  sync.Mutex
+	values map[string]struct{}
+}
+
+func newLabelCounter(counter Counter, label MessageLabel) *labelCounter {
+	lc := &labelCounter{
+		counter:   counter,
+		extractor: label.Extractor,
+		fixed:     len(label.Values) > 0,
+		max:       label.MaxValues,
+		values:    make(map[string]struct{}),
+	}
+	if lc.max <= 0 {
+		lc.max = defaultMaxLabelValues
+	}
+	for _, value := range label.Values {
+		lc.values[value] = struct{}{}
+	}
+	return lc
+}
+
+// record increments the counter of the label value of the message, with the other label values following it.
+func (c *labelCounter) record(msg substrate.Message, labelValues ...string) {
+	if c != nil {
+		c.counter.Add(1, append([]string{c.value(msg)}, labelValues...)...)
+	}
+}
+
+// value returns the value of the label for the message, admitting new values until the limit is reached.
+func (c *labelCounter) value(msg substrate.Message) string {
+	value := c.extractor(msg)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.values[value]; ok {
+		return value
+	}
+	if c.fixed || len(c.values) >= c.max {
+		return OtherLabelValue
+	}
+	c.values[value] = struct{}{}
+	return value
+}
+
+// WithPublishedByLabelCounter enables a counter of the messages successfully published, labelled with topic and
+// the message label, e.g. to bill or alert per tenant. The suggested name for the counter is
+// "substrate_sink_labelled_messages_total". It panics in case it can't register the metric.
+func WithPublishedByLabelCounter(counterOpts prometheus.CounterOpts, label MessageLabel) SinkOption {
+	return func(ams *instrumentedSink) {
+		counter := prometheusCounter{metrics.Register(prometheus.NewCounterVec(counterOpts, []string{label.Name, "topic"})).(*prometheus.CounterVec)}
+		ams.labelled = newLabelCounter(counter, label)
+	}
+}
+
+// WithPublishedByLabel is like WithPublishedByLabelCounter, but creates the counter using the metrics the sink
+// was created with.
+func WithPublishedByLabel(counterOpts MetricOpts, label MessageLabel) SinkOption {
+	return func(ams *instrumentedSink) {
+		ams.labelled = newLabelCounter(ams.metrics.NewCounter(counterOpts, []string{label.Name, "topic"}), label)
+	}
+}
+
+// WithConsumedByLabelCounter enables a counter of the messages acknowledged, labelled with topic, consumer and
+// the message label, e.g. to bill or alert per tenant. The suggested name for the counter is
+// "substrate_source_labelled_messages_total". It panics in case it can't register the metric.
+func WithConsumedByLabelCounter(counterOpts prometheus.CounterOpts, label MessageLabel) SourceOption {
+	return func(ams *instrumentedSource) {
+		counter := prometheusCounter{metrics.Register(prometheus.NewCounterVec(counterOpts, []string{label.Name, "topic", "consumer"})).(*prometheus.CounterVec)}
+		ams.labelled = newLabelCounter(counter, label)
+	}
+}
+
+// WithConsumedByLabel is like WithConsumedByLabelCounter, but creates the counter using the metrics the source
+// was created with.
+func WithConsumedByLabel(counterOpts MetricOpts, label MessageLabel) SourceOption {
+	return func(ams *instrumentedSource) {
+		ams.labelled = newLabelCounter(ams.metrics.NewCounter(counterOpts, []string{label.Name, "topic", "consumer"}), label)
+	}
+}
@@ -0,0 +1,95 @@
+package instrumented
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+func tenantMessage(tenant string) substrate.Message {
+	return message.NewEnvelopedMessage(message.Envelope{Headers: map[string]string{"tenant": tenant}})
+}
+
+func TestPublishMessagesByLabel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	registry := prometheus.NewRegistry()
+	sink := NewAsyncMessageSinkWithOptions(mock.NewSink(),
+		WithRegisterer(registry),
+		WithTopicLabel("events"),
+		WithPublishedByLabel(MetricOpts{Name: "published_by_tenant_total", Help: "Published messages by tenant."}, MessageLabel{
+			Name:      "tenant",
+			Extractor: HeaderLabel("tenant"),
+			MaxValues: 2,
+		}),
+	)
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+	// Tenants beyond the first two are reported as other.
+	for _, tenant := range []string{"a", "b", "a", "c", "d"} {
+		messages <- tenantMessage(tenant)
+		<-acks
+	}
+	cancel()
+	require.NoError(t, <-errs)
+
+	require.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+		# HELP published_by_tenant_total Published messages by tenant.
+		# TYPE published_by_tenant_total counter
+		published_by_tenant_total{tenant="a",topic="events"} 2
+		published_by_tenant_total{tenant="b",topic="events"} 1
+		published_by_tenant_total{tenant="other",topic="events"} 2
+	`), "published_by_tenant_total"))
+}
+
+func TestConsumeMessagesByLabel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	registry := prometheus.NewRegistry()
+	mockSource := mock.NewSource(tenantMessage("a"), tenantMessage("b"), tenantMessage("c"))
+	source := NewAsyncMessageSourceWithOptions(mockSource,
+		WithRegisterer(registry),
+		WithTopicLabel("events"),
+		WithConsumerLabel("app"),
+		// Only the listed tenants are reported as they are.
+		WithConsumedByLabel(MetricOpts{Name: "consumed_by_tenant_total", Help: "Consumed messages by tenant."}, MessageLabel{
+			Name:      "tenant",
+			Extractor: HeaderLabel("tenant"),
+			Values:    []string{"b"},
+		}),
+	)
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+	for i := 0; i < 3; i++ {
+		acks <- <-messages
+	}
+	<-mockSource.AllAcked()
+	cancel()
+	require.NoError(t, <-errs)
+
+	require.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+		# HELP consumed_by_tenant_total Consumed messages by tenant.
+		# TYPE consumed_by_tenant_total counter
+		consumed_by_tenant_total{consumer="app",tenant="b",topic="events"} 1
+		consumed_by_tenant_total{consumer="app",tenant="other",topic="events"} 2
+	`), "consumed_by_tenant_total"))
+}
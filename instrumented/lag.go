@@ -0,0 +1,33 @@
+package instrumented
+
+import (
+	"github.com/uw-labs/substrate"
+)
+
+var lagLabels = []string{"topic", "consumer", "partition"}
+
+// Offsetter is an optional interface implemented by messages that expose their position within
+// the partition of the topic they were consumed from.
+type Offsetter interface {
+	substrate.Message
+	// Partition returns the identifier of the partition the message was consumed from.
+	Partition() string
+	// Offset returns the offset of the message within its partition.
+	Offset() int64
+	// HighWaterMark returns the offset that will be assigned to the next message produced to the partition.
+	HighWaterMark() int64
+}
+
+// observeLag updates the lag gauge of the partition the message was consumed from. Messages that
+// don't implement the Offsetter interface are ignored.
+func observeLag(gauge Gauge, msg substrate.Message, topic, consumer string) {
+	oMsg, ok := msg.(Offsetter)
+	if !ok {
+		return
+	}
+	lag := oMsg.HighWaterMark() - oMsg.Offset() - 1
+	if lag < 0 {
+		lag = 0
+	}
+	gauge.Set(float64(lag), topic, consumer, oMsg.Partition())
+}
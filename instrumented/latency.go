@@ -0,0 +1,81 @@
+package instrumented
+
+import (
+	"sync"
+	"time"
+
+	"github.com/uw-labs/substrate"
+)
+
+// latencyTracker records the time messages spend in flight. It relies on messages being
+// acknowledged in the order in which they were sent. If the histogram supports exemplars, the
+// latency of messages carrying the context of a sampled trace is recorded with the trace ID.
+type latencyTracker struct {
+	histogram Histogram
+	exemplars ExemplarHistogram
+	labels    []string
+
+	mutex   sync.Mutex
+	pending []inFlight
+}
+
+// inFlight is a message in flight.
+type inFlight struct {
+	sent     time.Time
+	exemplar map[string]string
+}
+
+func newLatencyTracker(histogram Histogram, labels ...string) *latencyTracker {
+	exemplars, _ := histogram.(ExemplarHistogram)
+	return &latencyTracker{
+		histogram: histogram,
+		exemplars: exemplars,
+		labels:    labels,
+	}
+}
+
+// start records the time at which the message was sent.
+func (lt *latencyTracker) start(msg substrate.Message) {
+	m := inFlight{sent: time.Now()}
+	if lt.exemplars != nil {
+		m.exemplar = traceExemplar(msg)
+	}
+
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	lt.pending = append(lt.pending, m)
+}
+
+// observe records the latency of the oldest message in flight with the provided label values, which precede
+// the labels of the tracker, e.g. the status.
+func (lt *latencyTracker) observe(labelValues ...string) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	if len(lt.pending) == 0 {
+		return
+	}
+	lt.record(lt.pending[0], append(labelValues, lt.labels...))
+	lt.pending = lt.pending[1:]
+}
+
+// observeAll records the latency of all messages in flight with the provided status.
+func (lt *latencyTracker) observeAll(status string) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	for _, m := range lt.pending {
+		lt.record(m, append([]string{status}, lt.labels...))
+	}
+	lt.pending = nil
+}
+
+func (lt *latencyTracker) record(m inFlight, labelValues []string) {
+	latency := time.Since(m.sent).Seconds()
+	if m.exemplar != nil {
+		lt.exemplars.ObserveWithExemplar(latency, m.exemplar, labelValues...)
+		return
+	}
+	lt.histogram.Observe(latency, labelValues...)
+}
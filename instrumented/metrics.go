@@ -0,0 +1,49 @@
+package instrumented
+
+// MetricOpts are the options of a metric created using Metrics.
+type MetricOpts struct {
+	// Name is the name of the metric.
+	Name string
+	// Help describes the metric.
+	Help string
+	// Buckets are the upper bounds of the buckets of a histogram, the default buckets of the backend
+	// are used if it is empty. It is ignored for other metrics.
+	Buckets []float64
+}
+
+// Metrics creates the metrics recorded by the instrumented wrappers, decoupling them from the metrics backend.
+// The label values passed when recording a metric are in the same order as the labels it was created with.
+type Metrics interface {
+	// NewCounter returns a counter with the given labels.
+	NewCounter(opts MetricOpts, labels []string) Counter
+	// NewGauge returns a gauge with the given labels.
+	NewGauge(opts MetricOpts, labels []string) Gauge
+	// NewHistogram returns a histogram with the given labels.
+	NewHistogram(opts MetricOpts, labels []string) Histogram
+}
+
+// Counter is a metric that can only increase.
+type Counter interface {
+	// Add adds the value, which must not be negative, to the counter with the given label values.
+	Add(value float64, labelValues ...string)
+}
+
+// Gauge is a metric that can be set to any value.
+type Gauge interface {
+	// Set sets the gauge with the given label values to the value.
+	Set(value float64, labelValues ...string)
+}
+
+// Histogram is a metric that records the distribution of observed values.
+type Histogram interface {
+	// Observe records the value in the histogram with the given label values.
+	Observe(value float64, labelValues ...string)
+}
+
+// ExemplarHistogram is a histogram that can attach an exemplar, a set of labels identifying an example of what was
+// observed, to an observation. The histograms created by NewPrometheusMetrics implement it.
+type ExemplarHistogram interface {
+	Histogram
+	// ObserveWithExemplar records the value in the histogram with the given label values along with the exemplar.
+	ObserveWithExemplar(value float64, exemplar map[string]string, labelValues ...string)
+}
@@ -0,0 +1,127 @@
+package instrumented
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uw-labs/substrate"
+)
+
+const (
+	defaultSinkCounterName   = "substrate_sink_messages_total"
+	defaultSourceCounterName = "substrate_source_messages_total"
+)
+
+// settings configure how the sinks and sources created with the WithOptions constructors register their metrics.
+type settings struct {
+	registerer  prometheus.Registerer
+	labels      prometheus.Labels
+	counterName string
+	topic       string
+	consumer    string
+}
+
+// metrics returns the prometheus metrics registering with the registerer, adding the constant labels.
+func (s *settings) metrics() Metrics {
+	registerer := s.registerer
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	if len(s.labels) > 0 {
+		registerer = prometheus.WrapRegistererWith(s.labels, registerer)
+	}
+	return NewPrometheusMetrics(registerer)
+}
+
+// Option is a function which sets an option shared by NewAsyncMessageSinkWithOptions and
+// NewAsyncMessageSourceWithOptions. Options are applied before any SinkOption or SourceOption, whatever
+// their order, so that metrics created by the latter, using the MetricOpts variants such as WithLatency,
+// are registered with the same registerer and constant labels.
+type Option func(s *settings)
+
+// SinkArg is an argument of NewAsyncMessageSinkWithOptions, either an Option or a SinkOption.
+type SinkArg interface {
+	sinkArg()
+}
+
+// SourceArg is an argument of NewAsyncMessageSourceWithOptions, either an Option or a SourceOption.
+type SourceArg interface {
+	sourceArg()
+}
+
+func (Option) sinkArg()         {}
+func (Option) sourceArg()       {}
+func (SinkOption) sinkArg()     {}
+func (SourceOption) sourceArg() {}
+
+// WithRegisterer sets the registerer the metrics are registered with. The default is prometheus.DefaultRegisterer.
+func WithRegisterer(registerer prometheus.Registerer) Option {
+	return func(s *settings) {
+		s.registerer = registerer
+	}
+}
+
+// WithLabels sets constant labels added to all the metrics, e.g. the name of the service.
+func WithLabels(labels prometheus.Labels) Option {
+	return func(s *settings) {
+		s.labels = labels
+	}
+}
+
+// WithCounterName sets the name of the counter of messages. The defaults are "substrate_sink_messages_total"
+// and "substrate_source_messages_total".
+func WithCounterName(name string) Option {
+	return func(s *settings) {
+		s.counterName = name
+	}
+}
+
+// WithTopicLabel sets the value of the topic label of the metrics.
+func WithTopicLabel(topic string) Option {
+	return func(s *settings) {
+		s.topic = topic
+	}
+}
+
+// WithConsumerLabel sets the value of the consumer label of the metrics of a source. It is ignored by sinks.
+func WithConsumerLabel(consumer string) Option {
+	return func(s *settings) {
+		s.consumer = consumer
+	}
+}
+
+// NewAsyncMessageSinkWithOptions is like NewAsyncMessageSink, but configured using options only. The counter of
+// messages, labelled with topic and status, is registered according to the options. It panics in case it can't
+// register the metric.
+func NewAsyncMessageSinkWithOptions(sink substrate.AsyncMessageSink, args ...SinkArg) substrate.AsyncMessageSink {
+	s := &settings{counterName: defaultSinkCounterName}
+	var opts []SinkOption
+	for _, arg := range args {
+		switch arg := arg.(type) {
+		case Option:
+			arg(s)
+		case SinkOption:
+			opts = append(opts, arg)
+		}
+	}
+	metrics := s.metrics()
+	counter := metrics.NewCounter(MetricOpts{Name: s.counterName, Help: "Number of messages published to the sink."}, sinkLabels)
+	return newInstrumentedSink(sink, metrics, counter, s.topic, opts)
+}
+
+// NewAsyncMessageSourceWithOptions is like NewAsyncMessageSource, but configured using options only. The counter
+// of messages, labelled with topic, consumer and status, is registered according to the options. It panics in
+// case it can't register the metric.
+func NewAsyncMessageSourceWithOptions(source substrate.AsyncMessageSource, args ...SourceArg) substrate.AsyncMessageSource {
+	s := &settings{counterName: defaultSourceCounterName}
+	var opts []SourceOption
+	for _, arg := range args {
+		switch arg := arg.(type) {
+		case Option:
+			arg(s)
+		case SourceOption:
+			opts = append(opts, arg)
+		}
+	}
+	metrics := s.metrics()
+	counter := metrics.NewCounter(MetricOpts{Name: s.counterName, Help: "Number of messages consumed from the source."}, sourceLabels)
+	return newInstrumentedSource(source, metrics, counter, s.topic, s.consumer, opts)
+}
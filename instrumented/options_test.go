@@ -0,0 +1,89 @@
+package instrumented
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+func TestNewAsyncMessageSinkWithOptions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	registry := prometheus.NewRegistry()
+	// The latency histogram is registered with the registerer and constant labels, although it comes first.
+	sink := NewAsyncMessageSinkWithOptions(mock.NewSink(),
+		WithLatency(MetricOpts{Name: "publish_latency_seconds"}),
+		WithRegisterer(registry),
+		WithLabels(prometheus.Labels{"service": "orders"}),
+		WithCounterName("published_total"),
+		WithTopicLabel("events"),
+	)
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+	messages <- message.FromString("payload")
+	<-acks
+	cancel()
+	require.NoError(t, <-errs)
+
+	require.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+		# HELP published_total Number of messages published to the sink.
+		# TYPE published_total counter
+		published_total{service="orders",status="error",topic="events"} 0
+		published_total{service="orders",status="success",topic="events"} 1
+	`), "published_total"))
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 2)
+	require.Equal(t, "publish_latency_seconds", families[0].GetName())
+}
+
+func TestNewAsyncMessageSourceWithOptions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	registry := prometheus.NewRegistry()
+	mockSource := mock.NewSource(message.FromString("payload"))
+	source := NewAsyncMessageSourceWithOptions(mockSource,
+		WithRegisterer(registry),
+		WithTopicLabel("events"),
+		WithConsumerLabel("app"),
+		WithBytes(MetricOpts{Name: "consumed_bytes_total", Help: "Consumed bytes."}),
+	)
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+	acks <- <-messages
+	<-mockSource.AllAcked()
+	cancel()
+	require.NoError(t, <-errs)
+
+	require.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+		# HELP substrate_source_messages_total Number of messages consumed from the source.
+		# TYPE substrate_source_messages_total counter
+		substrate_source_messages_total{consumer="app",status="error",topic="events"} 0
+		substrate_source_messages_total{consumer="app",status="success",topic="events"} 1
+	`), "substrate_source_messages_total"))
+	require.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+		# HELP consumed_bytes_total Consumed bytes.
+		# TYPE consumed_bytes_total counter
+		consumed_bytes_total{consumer="app",topic="events"} 7
+	`), "consumed_bytes_total"))
+}
@@ -0,0 +1,87 @@
+// Package otelmetrics provides an implementation of instrumented.Metrics that records metrics using OpenTelemetry.
+package otelmetrics
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/uw-labs/substrate-tools/instrumented"
+)
+
+// New returns an implementation of instrumented.Metrics that creates its instruments using the provided meter.
+// The labels of a metric are recorded as attributes. Creating a metric panics in case the meter returns an error.
+func New(meter metric.Meter) instrumented.Metrics {
+	return metrics{meter: meter}
+}
+
+type metrics struct {
+	meter metric.Meter
+}
+
+func (m metrics) NewCounter(opts instrumented.MetricOpts, labels []string) instrumented.Counter {
+	counter, err := m.meter.Float64Counter(opts.Name, metric.WithDescription(opts.Help))
+	if err != nil {
+		panic(err)
+	}
+	return counterAdapter{counter: counter, labels: labels}
+}
+
+func (m metrics) NewGauge(opts instrumented.MetricOpts, labels []string) instrumented.Gauge {
+	gauge, err := m.meter.Float64Gauge(opts.Name, metric.WithDescription(opts.Help))
+	if err != nil {
+		panic(err)
+	}
+	return gaugeAdapter{gauge: gauge, labels: labels}
+}
+
+func (m metrics) NewHistogram(opts instrumented.MetricOpts, labels []string) instrumented.Histogram {
+	histogramOpts := []metric.Float64HistogramOption{metric.WithDescription(opts.Help)}
+	if len(opts.Buckets) > 0 {
+		histogramOpts = append(histogramOpts, metric.WithExplicitBucketBoundaries(opts.Buckets...))
+	}
+	histogram, err := m.meter.Float64Histogram(opts.Name, histogramOpts...)
+	if err != nil {
+		panic(err)
+	}
+	return histogramAdapter{histogram: histogram, labels: labels}
+}
+
+type counterAdapter struct {
+	counter metric.Float64Counter
+	labels  []string
+}
+
+func (c counterAdapter) Add(value float64, labelValues ...string) {
+	c.counter.Add(context.Background(), value, attributes(c.labels, labelValues))
+}
+
+type gaugeAdapter struct {
+	gauge  metric.Float64Gauge
+	labels []string
+}
+
+func (g gaugeAdapter) Set(value float64, labelValues ...string) {
+	g.gauge.Record(context.Background(), value, attributes(g.labels, labelValues))
+}
+
+type histogramAdapter struct {
+	histogram metric.Float64Histogram
+	labels    []string
+}
+
+func (h histogramAdapter) Observe(value float64, labelValues ...string) {
+	h.histogram.Record(context.Background(), value, attributes(h.labels, labelValues))
+}
+
+// attributes pairs the labels with their values.
+func attributes(labels, values []string) metric.MeasurementOption {
+	kvs := make([]attribute.KeyValue, 0, len(labels))
+	for i, label := range labels {
+		if i < len(values) {
+			kvs = append(kvs, attribute.String(label, values[i]))
+		}
+	}
+	return metric.WithAttributes(kvs...)
+}
@@ -0,0 +1,61 @@
+package otelmetrics_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/uw-labs/substrate-tools/instrumented"
+	"github.com/uw-labs/substrate-tools/instrumented/otelmetrics"
+)
+
+func TestMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	metrics := otelmetrics.New(provider.Meter("test"))
+
+	labels := []string{"status", "topic"}
+	counter := metrics.NewCounter(instrumented.MetricOpts{Name: "counter", Help: "counter"}, labels)
+	gauge := metrics.NewGauge(instrumented.MetricOpts{Name: "gauge", Help: "gauge"}, labels)
+	histogram := metrics.NewHistogram(instrumented.MetricOpts{Name: "histogram", Help: "histogram", Buckets: []float64{1, 10}}, labels)
+
+	counter.Add(1, "success", "topic")
+	counter.Add(2, "success", "topic")
+	gauge.Set(5, "success", "topic")
+	gauge.Set(3, "success", "topic")
+	histogram.Observe(0.5, "error", "topic")
+	histogram.Observe(5, "error", "topic")
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+
+	byName := make(map[string]metricdata.Aggregation)
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		byName[m.Name] = m.Data
+	}
+
+	success := attribute.NewSet(attribute.String("status", "success"), attribute.String("topic", "topic"))
+	failure := attribute.NewSet(attribute.String("status", "error"), attribute.String("topic", "topic"))
+
+	sum := byName["counter"].(metricdata.Sum[float64])
+	require.Len(t, sum.DataPoints, 1)
+	assert.Equal(t, 3.0, sum.DataPoints[0].Value)
+	assert.Equal(t, success, sum.DataPoints[0].Attributes)
+
+	last := byName["gauge"].(metricdata.Gauge[float64])
+	require.Len(t, last.DataPoints, 1)
+	assert.Equal(t, 3.0, last.DataPoints[0].Value)
+
+	hist := byName["histogram"].(metricdata.Histogram[float64])
+	require.Len(t, hist.DataPoints, 1)
+	assert.Equal(t, failure, hist.DataPoints[0].Attributes)
+	assert.Equal(t, uint64(2), hist.DataPoints[0].Count)
+	assert.Equal(t, []float64{1, 10}, hist.DataPoints[0].Bounds)
+	assert.Equal(t, []uint64{1, 1, 0}, hist.DataPoints[0].BucketCounts)
+}
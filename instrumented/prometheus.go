@@ -0,0 +1,76 @@
+package instrumented
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+)
+
+// NewPrometheusMetrics returns an implementation of Metrics that registers prometheus metrics with the provided
+// registerer, or the default one if it is nil. If an identical metric has already been registered, the existing
+// one is used. Creating a metric panics in case it can't be registered.
+func NewPrometheusMetrics(registerer prometheus.Registerer) Metrics {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	return prometheusMetrics{registerer: registerer}
+}
+
+type prometheusMetrics struct {
+	registerer prometheus.Registerer
+}
+
+func (m prometheusMetrics) NewCounter(opts MetricOpts, labels []string) Counter {
+	return prometheusCounter{metrics.RegisterWith(m.registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: opts.Name,
+		Help: opts.Help,
+	}, labels)).(*prometheus.CounterVec)}
+}
+
+func (m prometheusMetrics) NewGauge(opts MetricOpts, labels []string) Gauge {
+	return prometheusGauge{metrics.RegisterWith(m.registerer, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: opts.Name,
+		Help: opts.Help,
+	}, labels)).(*prometheus.GaugeVec)}
+}
+
+func (m prometheusMetrics) NewHistogram(opts MetricOpts, labels []string) Histogram {
+	return prometheusHistogram{metrics.RegisterWith(m.registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    opts.Name,
+		Help:    opts.Help,
+		Buckets: opts.Buckets,
+	}, labels)).(*prometheus.HistogramVec)}
+}
+
+type prometheusCounter struct {
+	*prometheus.CounterVec
+}
+
+func (c prometheusCounter) Add(value float64, labelValues ...string) {
+	c.WithLabelValues(labelValues...).Add(value)
+}
+
+type prometheusGauge struct {
+	*prometheus.GaugeVec
+}
+
+func (g prometheusGauge) Set(value float64, labelValues ...string) {
+	g.WithLabelValues(labelValues...).Set(value)
+}
+
+type prometheusHistogram struct {
+	*prometheus.HistogramVec
+}
+
+func (h prometheusHistogram) Observe(value float64, labelValues ...string) {
+	h.WithLabelValues(labelValues...).Observe(value)
+}
+
+func (h prometheusHistogram) ObserveWithExemplar(value float64, exemplar map[string]string, labelValues ...string) {
+	observer := h.WithLabelValues(labelValues...)
+	if eObserver, ok := observer.(prometheus.ExemplarObserver); ok {
+		eObserver.ObserveWithExemplar(value, exemplar)
+		return
+	}
+	observer.Observe(value)
+}
@@ -0,0 +1,128 @@
+package instrumented
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+)
+
+// The values of the kind label of the reconnection counters.
+const (
+	reconnectKindSink   = "sink"
+	reconnectKindSource = "source"
+)
+
+// reconnectLabels are shared by sinks and sources, so that both can record their reconnections with the same
+// counter. The consumer is empty for sinks.
+var reconnectLabels = []string{"kind", "topic", "consumer"}
+
+// reconnectTracker detects that a call publishing or consuming messages was restarted after the previous one failed,
+// e.g. by a retry wrapper, which reveals flapping backends.
+type reconnectTracker struct {
+	counter     Counter
+	labelValues []string
+	info        events.Info
+
+	mutex   sync.Mutex
+	failure error
+	attempt int
+}
+
+func newReconnectTracker(counter Counter, kind, topic, consumer string) *reconnectTracker {
+	counter.Add(0, kind, topic, consumer)
+	return &reconnectTracker{
+		counter:     counter,
+		labelValues: []string{kind, topic, consumer},
+		info:        events.Info{Component: kind, Topic: topic},
+	}
+}
+
+// start records a reconnection if the previous call failed, emitting it into the hooks carried by the context. The
+// attempt counts the reconnections since a message was last acknowledged.
+func (t *reconnectTracker) start(ctx context.Context) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	failure := t.failure
+	t.failure = nil
+	if failure != nil {
+		t.attempt++
+	}
+	attempt := t.attempt
+	t.mutex.Unlock()
+
+	if failure != nil {
+		t.counter.Add(1, t.labelValues...)
+		events.FromContext(ctx).OnReconnect(ctx, t.info, attempt, failure)
+	}
+}
+
+// end records the error the call returned.
+func (t *reconnectTracker) end(err error) {
+	if t == nil || !isUnexpectedError(err) {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.failure = err
+}
+
+// acked records that a message was acknowledged, which resets the count of attempts.
+func (t *reconnectTracker) acked() {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.attempt = 0
+}
+
+// WithPublishReconnectCounter enables a counter of the times PublishMessages was called again after the previous
+// call failed, e.g. by a retry wrapper, labelled with kind, which is "sink", topic and consumer, which is empty.
+// Every reconnection is also emitted into the OnReconnect hook of the events carried by the context. The suggested
+// name for the counter, which can be shared with sources, is "substrate_reconnects_total". It panics in case it
+// can't register the metric.
+func WithPublishReconnectCounter(counterOpts prometheus.CounterOpts) SinkOption {
+	return func(ams *instrumentedSink) {
+		counter := prometheusCounter{metrics.Register(prometheus.NewCounterVec(counterOpts, reconnectLabels)).(*prometheus.CounterVec)}
+		ams.reconnects = newReconnectTracker(counter, reconnectKindSink, ams.topic, "")
+	}
+}
+
+// WithPublishReconnects is like WithPublishReconnectCounter, but creates the counter using the metrics the sink was
+// created with.
+func WithPublishReconnects(counterOpts MetricOpts) SinkOption {
+	return func(ams *instrumentedSink) {
+		ams.reconnects = newReconnectTracker(ams.metrics.NewCounter(counterOpts, reconnectLabels), reconnectKindSink, ams.topic, "")
+	}
+}
+
+// WithConsumeReconnectCounter enables a counter of the times ConsumeMessages was called again after the previous
+// call failed, e.g. by a retry wrapper, labelled with kind, which is "source", topic and consumer. Every
+// reconnection is also emitted into the OnReconnect hook of the events carried by the context. The suggested name
+// for the counter, which can be shared with sinks, is "substrate_reconnects_total". It panics in case it can't
+// register the metric.
+func WithConsumeReconnectCounter(counterOpts prometheus.CounterOpts) SourceOption {
+	return func(ams *instrumentedSource) {
+		counter := prometheusCounter{metrics.Register(prometheus.NewCounterVec(counterOpts, reconnectLabels)).(*prometheus.CounterVec)}
+		ams.reconnects = newReconnectTracker(counter, reconnectKindSource, ams.topic, ams.consumer)
+	}
+}
+
+// WithConsumeReconnects is like WithConsumeReconnectCounter, but creates the counter using the metrics the source
+// was created with.
+func WithConsumeReconnects(counterOpts MetricOpts) SourceOption {
+	return func(ams *instrumentedSource) {
+		ams.reconnects = newReconnectTracker(ams.metrics.NewCounter(counterOpts, reconnectLabels), reconnectKindSource, ams.topic, ams.consumer)
+	}
+}
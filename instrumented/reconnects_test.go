@@ -0,0 +1,125 @@
+package instrumented
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+// reconnectRecorder records the reconnections emitted into it.
+type reconnectRecorder struct {
+	events.Nop
+
+	mutex    sync.Mutex
+	attempts []int
+}
+
+func (r *reconnectRecorder) OnReconnect(_ context.Context, info events.Info, attempt int, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.attempts = append(r.attempts, attempt)
+}
+
+func (r *reconnectRecorder) recorded() []int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]int(nil), r.attempts...)
+}
+
+// flappingSink fails every call to PublishMessages before acknowledging a message, until it is told to work.
+type flappingSink struct {
+	substrate.AsyncMessageSink
+	working bool
+}
+
+func (s *flappingSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	if !s.working {
+		return errors.New("connection lost")
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-messages:
+			select {
+			case <-ctx.Done():
+				return nil
+			case acks <- msg:
+			}
+		}
+	}
+}
+
+func TestSink_Reconnects(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	backend := &flappingSink{}
+	sink := NewAsyncMessageSinkWithMetrics(backend, NewPrometheusMetrics(registry), MetricOpts{Name: "messages_total", Help: "help"}, "orders",
+		WithPublishReconnects(MetricOpts{Name: "substrate_reconnects_total", Help: "Number of reconnections."}),
+	)
+
+	r := &reconnectRecorder{}
+	ctx, cancel := context.WithTimeout(events.WithHooks(context.Background(), r), time.Second*5)
+	defer cancel()
+
+	// The first call isn't a reconnection, while each call after a failure is.
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	require.EqualError(t, sink.PublishMessages(ctx, acks, messages), "connection lost")
+	require.EqualError(t, sink.PublishMessages(ctx, acks, messages), "connection lost")
+
+	backend.working = true
+	publishCtx, publishCancel := context.WithCancel(ctx)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(publishCtx, acks, messages)
+	}()
+	messages <- message.FromString("payload")
+	<-acks
+	publishCancel()
+	require.NoError(t, <-errs)
+
+	// Publishing again after it was stopped by cancelling the context isn't a reconnection.
+	publishCtx, publishCancel = context.WithCancel(ctx)
+	publishCancel()
+	require.NoError(t, sink.PublishMessages(publishCtx, acks, messages))
+
+	require.Equal(t, []int{1, 2}, r.recorded())
+	expected := `
+# HELP substrate_reconnects_total Number of reconnections.
+# TYPE substrate_reconnects_total counter
+substrate_reconnects_total{consumer="",kind="sink",topic="orders"} 2
+`
+	require.NoError(t, testutil.GatherAndCompare(registry, bytes.NewBufferString(expected), "substrate_reconnects_total"))
+}
+
+func TestSource_Reconnects(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	registry := prometheus.NewRegistry()
+	factory := NewFactory(registry, "substrate", WithFactoryReconnects())
+
+	backend := mock.NewSource(message.FromString("payload")).FailAt(1, errors.New("connection lost"))
+	source := factory.NewAsyncMessageSource(backend, "orders", "billing")
+	require.EqualError(t, source.ConsumeMessages(ctx, make(chan substrate.Message), make(chan substrate.Message)), "connection lost")
+	// The mock source can't be consumed again, which still counts as a reconnection.
+	require.Error(t, source.ConsumeMessages(ctx, make(chan substrate.Message), make(chan substrate.Message)))
+
+	expected := `
+# HELP substrate_reconnects_total Number of times publishing or consuming was restarted after failing.
+# TYPE substrate_reconnects_total counter
+substrate_reconnects_total{consumer="billing",kind="source",topic="orders"} 1
+`
+	require.NoError(t, testutil.GatherAndCompare(registry, bytes.NewBufferString(expected), "substrate_reconnects_total"))
+}
@@ -6,61 +6,123 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+
+	"github.com/uw-labs/substrate-tools/internal/metrics"
 )
 
 var sinkLabels = []string{"status", "topic"}
 
+// SinkOption is a function which sets an instrumented sink configuration option.
+type SinkOption func(ams *instrumentedSink)
+
+// WithLatencyHistogram enables a histogram tracking the time between a message being received by the sink
+// and its acknowledgement arriving, labelled with topic and status. The buckets can be configured using
+// the Buckets field of the provided options. It panics in case it can't register the metric.
+func WithLatencyHistogram(histogramOpts prometheus.HistogramOpts) SinkOption {
+	return func(ams *instrumentedSink) {
+		ams.latency = prometheusHistogram{metrics.Register(prometheus.NewHistogramVec(histogramOpts, sinkLabels)).(*prometheus.HistogramVec)}
+	}
+}
+
+// WithLatency is like WithLatencyHistogram, but creates the histogram using the metrics the sink was created with.
+func WithLatency(histogramOpts MetricOpts) SinkOption {
+	return func(ams *instrumentedSink) {
+		ams.latency = ams.metrics.NewHistogram(histogramOpts, sinkLabels)
+	}
+}
+
 // NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that  exposes prometheus metrics
 // for the message sink labelled with topic and status. It panics in case it can't register the metric.
-func NewAsyncMessageSink(sink substrate.AsyncMessageSink, counterOpts prometheus.CounterOpts, topic string) substrate.AsyncMessageSink {
-	counter := prometheus.NewCounterVec(counterOpts, sinkLabels)
-
-	if err := prometheus.Register(counter); err != nil {
-		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
-			counter = are.ExistingCollector.(*prometheus.CounterVec)
-		} else {
-			panic(err)
-		}
-	}
-	counter.WithLabelValues("error", topic).Add(0)
-	counter.WithLabelValues("success", topic).Add(0)
+func NewAsyncMessageSink(sink substrate.AsyncMessageSink, counterOpts prometheus.CounterOpts, topic string, opts ...SinkOption) substrate.AsyncMessageSink {
+	counter := prometheusCounter{metrics.Register(prometheus.NewCounterVec(counterOpts, sinkLabels)).(*prometheus.CounterVec)}
+	return newInstrumentedSink(sink, NewPrometheusMetrics(nil), counter, topic, opts)
+}
+
+// NewAsyncMessageSinkWithMetrics returns an instance of substrate.AsyncMessageSink that records a counter of
+// published messages labelled with topic and status using the provided metrics.
+func NewAsyncMessageSinkWithMetrics(sink substrate.AsyncMessageSink, metrics Metrics, counterOpts MetricOpts, topic string, opts ...SinkOption) substrate.AsyncMessageSink {
+	return newInstrumentedSink(sink, metrics, metrics.NewCounter(counterOpts, sinkLabels), topic, opts)
+}
+
+func newInstrumentedSink(sink substrate.AsyncMessageSink, metrics Metrics, counter Counter, topic string, opts []SinkOption) *instrumentedSink {
+	counter.Add(0, "error", topic)
+	counter.Add(0, "success", topic)
 
-	return &instrumentedSink{
+	ams := &instrumentedSink{
 		impl:    sink,
+		metrics: metrics,
 		counter: counter,
 		topic:   topic,
 	}
+	for _, opt := range opts {
+		opt(ams)
+	}
+	return ams
 }
 
 // instrumentedSink is an instrumented message sink
-// The counter vector will have the labels "status" and "topic"
+// The counter will have the labels "status" and "topic"
 type instrumentedSink struct {
-	impl    substrate.AsyncMessageSink
-	counter *prometheus.CounterVec
-	topic   string
+	impl       substrate.AsyncMessageSink
+	metrics    Metrics
+	counter    Counter
+	latency    Histogram
+	errors     *errorCounter
+	labelled   *labelCounter
+	reconnects *reconnectTracker
+	topic      string
 }
 
 // PublishMessages implements message publishing wrapped in instrumentation.
 func (ams *instrumentedSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) (rerr error) {
+	emit := events.NewEmitter(ctx, events.Info{Component: "instrumented", Topic: ams.topic})
+	ams.reconnects.start(ctx)
+	defer func() {
+		ams.reconnects.end(rerr)
+	}()
+
 	successes := make(chan substrate.Message, cap(acks))
 
+	toPublish, latency := messages, (*latencyTracker)(nil)
+	if ams.latency != nil {
+		trackCtx, trackCancel := context.WithCancel(ctx)
+		defer trackCancel()
+
+		latency = newLatencyTracker(ams.latency, ams.topic)
+		toPublish = ams.trackLatency(trackCtx, latency, messages)
+		defer func() {
+			if isUnexpectedError(rerr) {
+				latency.observeAll("error")
+			}
+		}()
+	}
+
 	errs := make(chan error)
 	go func() {
 		defer close(errs)
-		errs <- ams.impl.PublishMessages(ctx, successes, messages)
+		errs <- ams.impl.PublishMessages(ctx, successes, toPublish)
 	}()
 
 	for {
 		select {
 		case success := <-successes:
-			ams.counter.WithLabelValues("success", ams.topic).Inc()
+			ams.counter.Add(1, "success", ams.topic)
+			ams.labelled.record(success, ams.topic)
+			ams.reconnects.acked()
+			if latency != nil {
+				latency.observe("success")
+			}
+			emit.Ack(success)
 			select {
 			case acks <- success:
 			case <-ctx.Done():
 				return <-errs
 			case err := <-errs:
 				if isUnexpectedError(err) {
-					ams.counter.WithLabelValues("error", ams.topic).Inc()
+					ams.counter.Add(1, "error", ams.topic)
+					ams.errors.record(err, ams.topic)
+					emit.Error(err)
 				}
 				return err
 			}
@@ -68,13 +130,36 @@ func (ams *instrumentedSink) PublishMessages(ctx context.Context, acks chan<- su
 			return <-errs
 		case err := <-errs:
 			if isUnexpectedError(err) {
-				ams.counter.WithLabelValues("error", ams.topic).Inc()
+				ams.counter.Add(1, "error", ams.topic)
+				ams.errors.record(err, ams.topic)
+				emit.Error(err)
 			}
 			return err
 		}
 	}
 }
 
+// trackLatency forwards messages to the returned channel, recording the time at which each of them was sent.
+func (ams *instrumentedSink) trackLatency(ctx context.Context, latency *latencyTracker, messages <-chan substrate.Message) <-chan substrate.Message {
+	toPublish := make(chan substrate.Message, cap(messages))
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-messages:
+				latency.start(msg)
+				select {
+				case <-ctx.Done():
+					return
+				case toPublish <- msg:
+				}
+			}
+		}
+	}()
+	return toPublish
+}
+
 func isUnexpectedError(err error) bool {
 	switch {
 	case err == nil:
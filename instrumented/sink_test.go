@@ -44,11 +44,11 @@ func TestPublishMessagesSuccessfully(t *testing.T) {
 				}
 			},
 		},
-		counter: prometheus.NewCounterVec(
+		counter: prometheusCounter{prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Help: "sink_counter",
 				Name: "sink_counter",
-			}, sinkLabels),
+			}, sinkLabels)},
 		topic: "testTopic",
 	}
 
@@ -73,7 +73,7 @@ func TestPublishMessagesSuccessfully(t *testing.T) {
 			return
 		case <-acks:
 			var metric dto.Metric
-			assert.NoError(t, sink.counter.WithLabelValues("success", "testTopic").Write(&metric))
+			assert.NoError(t, sink.counter.(prometheusCounter).WithLabelValues("success", "testTopic").Write(&metric))
 			assert.Equal(t, 1, int(*metric.Counter.Value))
 			sinkCancel()
 		}
@@ -94,11 +94,11 @@ func TestPublishMessagesSuccessfully_WithContextError(t *testing.T) {
 				}
 			},
 		},
-		counter: prometheus.NewCounterVec(
+		counter: prometheusCounter{prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Help: "sink_counter",
 				Name: "sink_counter",
-			}, []string{"status", "topic"}),
+			}, []string{"status", "topic"})},
 		topic: "testTopic",
 	}
 
@@ -122,12 +122,12 @@ func TestPublishMessagesSuccessfully_WithContextError(t *testing.T) {
 			assert.True(t, errors.Is(err, context.Canceled))
 			// the error counter should not be increased when producing stops by context canceling
 			var metric dto.Metric
-			assert.NoError(t, sink.counter.WithLabelValues("error", "testTopic").Write(&metric))
+			assert.NoError(t, sink.counter.(prometheusCounter).WithLabelValues("error", "testTopic").Write(&metric))
 			assert.Equal(t, 0, int(*metric.Counter.Value))
 			return
 		case <-acks:
 			var metric dto.Metric
-			assert.NoError(t, sink.counter.WithLabelValues("success", "testTopic").Write(&metric))
+			assert.NoError(t, sink.counter.(prometheusCounter).WithLabelValues("success", "testTopic").Write(&metric))
 			assert.Equal(t, 1, int(*metric.Counter.Value))
 			sinkCancel()
 		}
@@ -149,11 +149,11 @@ func TestPublishMessagesWithError(t *testing.T) {
 				}
 			},
 		},
-		counter: prometheus.NewCounterVec(
+		counter: prometheusCounter{prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Help: "sink_counter",
 				Name: "sink_counter",
-			}, sinkLabels),
+			}, sinkLabels)},
 		topic: "testTopic",
 	}
 
@@ -178,7 +178,7 @@ func TestPublishMessagesWithError(t *testing.T) {
 			assert.Equal(t, producingError, err)
 
 			var metric dto.Metric
-			assert.NoError(t, sink.counter.WithLabelValues("error", "testTopic").Write(&metric))
+			assert.NoError(t, sink.counter.(prometheusCounter).WithLabelValues("error", "testTopic").Write(&metric))
 			assert.Equal(t, 1, int(*metric.Counter.Value))
 
 			sinkCancel()
@@ -209,11 +209,11 @@ func TestProduceOnBackendShutdown(t *testing.T) {
 				}
 			},
 		},
-		counter: prometheus.NewCounterVec(
+		counter: prometheusCounter{prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Help: "sink_counter",
 				Name: "sink_counter",
-			}, sinkLabels),
+			}, sinkLabels)},
 		topic: "testTopic",
 	}
 
@@ -239,7 +239,103 @@ func TestProduceOnBackendShutdown(t *testing.T) {
 		assert.Equal(t, expectedErr, err)
 		// Check metric was increased
 		var metric dto.Metric
-		assert.NoError(t, source.counter.WithLabelValues("error", "testTopic").Write(&metric))
+		assert.NoError(t, source.counter.(prometheusCounter).WithLabelValues("error", "testTopic").Write(&metric))
 		assert.Equal(t, 1, int(*metric.Counter.Value))
 	}
 }
+
+func TestPublishMessagesWithLatencyHistogram(t *testing.T) {
+	sink := instrumentedSink{
+		impl: &asyncMessageSinkMock{
+			publishMessageMock: func(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+				for {
+					select {
+					case <-ctx.Done():
+						return nil
+					case msg := <-messages:
+						acks <- msg
+					}
+				}
+			},
+		},
+		counter: prometheusCounter{prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Help: "sink_counter",
+				Name: "sink_counter",
+			}, sinkLabels)},
+		latency: prometheusHistogram{prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Help: "sink_latency",
+				Name: "sink_latency",
+			}, sinkLabels)},
+		topic: "testTopic",
+	}
+
+	acks := make(chan substrate.Message)
+	messages := make(chan substrate.Message)
+
+	sinkContext, sinkCancel := context.WithCancel(context.Background())
+	defer sinkCancel()
+
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- sink.PublishMessages(sinkContext, acks, messages)
+	}()
+
+	messages <- Message{}
+	messages <- Message{}
+	<-acks
+	<-acks
+	sinkCancel()
+	assert.NoError(t, <-errs)
+
+	var metric dto.Metric
+	assert.NoError(t, sink.latency.(prometheusHistogram).WithLabelValues("success", "testTopic").(prometheus.Histogram).Write(&metric))
+	assert.Equal(t, 2, int(*metric.Histogram.SampleCount))
+}
+
+func TestPublishMessagesWithLatencyHistogramAndError(t *testing.T) {
+	producingError := errors.New("message producing error")
+	sink := instrumentedSink{
+		impl: &asyncMessageSinkMock{
+			publishMessageMock: func(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-messages:
+					return producingError
+				}
+			},
+		},
+		counter: prometheusCounter{prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Help: "sink_counter",
+				Name: "sink_counter",
+			}, sinkLabels)},
+		latency: prometheusHistogram{prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Help: "sink_latency",
+				Name: "sink_latency",
+			}, sinkLabels)},
+		topic: "testTopic",
+	}
+
+	messages := make(chan substrate.Message)
+
+	sinkContext, sinkCancel := context.WithCancel(context.Background())
+	defer sinkCancel()
+
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- sink.PublishMessages(sinkContext, make(chan substrate.Message), messages)
+	}()
+
+	messages <- Message{}
+	assert.Equal(t, producingError, <-errs)
+
+	var metric dto.Metric
+	assert.NoError(t, sink.latency.(prometheusHistogram).WithLabelValues("error", "testTopic").(prometheus.Histogram).Write(&metric))
+	assert.Equal(t, 1, int(*metric.Histogram.SampleCount))
+}
@@ -5,77 +5,233 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+)
+
+var (
+	sourceLabels = []string{"status", "topic", "consumer"}
+	sizeLabels   = []string{"topic", "consumer"}
 )
 
-var sourceLabels = []string{"status", "topic", "consumer"}
+// SourceOption is a function which sets an instrumented source configuration option.
+type SourceOption func(ams *instrumentedSource)
+
+// WithLagGauge enables a gauge tracking the consumer lag, labelled with topic, consumer and partition.
+// The lag is computed for each consumed message implementing the Offsetter interface, other messages
+// are ignored. The suggested name for the gauge is "substrate_source_lag". It panics in case it can't
+// register the metric.
+func WithLagGauge(gaugeOpts prometheus.GaugeOpts) SourceOption {
+	return func(ams *instrumentedSource) {
+		ams.lag = prometheusGauge{metrics.Register(prometheus.NewGaugeVec(gaugeOpts, lagLabels)).(*prometheus.GaugeVec)}
+	}
+}
+
+// WithLag is like WithLagGauge, but creates the gauge using the metrics the source was created with.
+func WithLag(gaugeOpts MetricOpts) SourceOption {
+	return func(ams *instrumentedSource) {
+		ams.lag = ams.metrics.NewGauge(gaugeOpts, lagLabels)
+	}
+}
+
+// WithSizeHistogram enables a histogram of the payload sizes of consumed messages in bytes, labelled with topic
+// and consumer. The buckets can be configured using the Buckets field of the provided options. The suggested
+// name for the histogram is "substrate_source_message_size_bytes". It panics in case it can't register the metric.
+func WithSizeHistogram(histogramOpts prometheus.HistogramOpts) SourceOption {
+	return func(ams *instrumentedSource) {
+		ams.size = prometheusHistogram{metrics.Register(prometheus.NewHistogramVec(histogramOpts, sizeLabels)).(*prometheus.HistogramVec)}
+	}
+}
+
+// WithSize is like WithSizeHistogram, but creates the histogram using the metrics the source was created with.
+func WithSize(histogramOpts MetricOpts) SourceOption {
+	return func(ams *instrumentedSource) {
+		ams.size = ams.metrics.NewHistogram(histogramOpts, sizeLabels)
+	}
+}
+
+// WithBytesCounter enables a counter of the total payload size of consumed messages in bytes, labelled with
+// topic and consumer. The suggested name for the counter is "substrate_source_consumed_bytes_total". It panics
+// in case it can't register the metric.
+func WithBytesCounter(counterOpts prometheus.CounterOpts) SourceOption {
+	return func(ams *instrumentedSource) {
+		ams.bytes = prometheusCounter{metrics.Register(prometheus.NewCounterVec(counterOpts, sizeLabels)).(*prometheus.CounterVec)}
+	}
+}
+
+// WithBytes is like WithBytesCounter, but creates the counter using the metrics the source was created with.
+func WithBytes(counterOpts MetricOpts) SourceOption {
+	return func(ams *instrumentedSource) {
+		ams.bytes = ams.metrics.NewCounter(counterOpts, sizeLabels)
+	}
+}
+
+// WithAckLatencyHistogram enables a histogram tracking the time between a message being delivered to the
+// application and its acknowledgement being returned, labelled with topic and consumer. It quantifies how long
+// handlers take independently of the lag of the backend. The buckets can be configured using the Buckets field
+// of the provided options. The suggested name for the histogram is "substrate_source_ack_latency_seconds".
+// It panics in case it can't register the metric.
+func WithAckLatencyHistogram(histogramOpts prometheus.HistogramOpts) SourceOption {
+	return func(ams *instrumentedSource) {
+		ams.ackLatency = prometheusHistogram{metrics.Register(prometheus.NewHistogramVec(histogramOpts, sizeLabels)).(*prometheus.HistogramVec)}
+	}
+}
+
+// WithAckLatency is like WithAckLatencyHistogram, but creates the histogram using the metrics the source was
+// created with.
+func WithAckLatency(histogramOpts MetricOpts) SourceOption {
+	return func(ams *instrumentedSource) {
+		ams.ackLatency = ams.metrics.NewHistogram(histogramOpts, sizeLabels)
+	}
+}
 
 // NewAsyncMessageSink returns an instance of substrate.AsyncMessageSource that exposes prometheus metrics
 // for the message source labelled with topic and status. It panics in case it can't register the metric.
-func NewAsyncMessageSource(source substrate.AsyncMessageSource, counterOpts prometheus.CounterOpts, topic, consumer string) substrate.AsyncMessageSource {
-	counter := prometheus.NewCounterVec(counterOpts, sourceLabels)
-
-	if err := prometheus.Register(counter); err != nil {
-		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
-			counter = are.ExistingCollector.(*prometheus.CounterVec)
-		} else {
-			panic(err)
-		}
-	}
-	counter.WithLabelValues("error", topic, consumer).Add(0)
-	counter.WithLabelValues("success", topic, consumer).Add(0)
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, counterOpts prometheus.CounterOpts, topic, consumer string, opts ...SourceOption) substrate.AsyncMessageSource {
+	counter := prometheusCounter{metrics.Register(prometheus.NewCounterVec(counterOpts, sourceLabels)).(*prometheus.CounterVec)}
+	return newInstrumentedSource(source, NewPrometheusMetrics(nil), counter, topic, consumer, opts)
+}
+
+// NewAsyncMessageSourceWithMetrics returns an instance of substrate.AsyncMessageSource that records a counter of
+// consumed messages labelled with topic, consumer and status using the provided metrics.
+func NewAsyncMessageSourceWithMetrics(source substrate.AsyncMessageSource, metrics Metrics, counterOpts MetricOpts, topic, consumer string, opts ...SourceOption) substrate.AsyncMessageSource {
+	return newInstrumentedSource(source, metrics, metrics.NewCounter(counterOpts, sourceLabels), topic, consumer, opts)
+}
 
-	return &instrumentedSource{
+func newInstrumentedSource(source substrate.AsyncMessageSource, metrics Metrics, counter Counter, topic, consumer string, opts []SourceOption) *instrumentedSource {
+	counter.Add(0, "error", topic, consumer)
+	counter.Add(0, "success", topic, consumer)
+
+	ams := &instrumentedSource{
 		impl:     source,
+		metrics:  metrics,
 		counter:  counter,
 		topic:    topic,
 		consumer: consumer,
 	}
+	for _, opt := range opts {
+		opt(ams)
+	}
+	return ams
 }
 
 // instrumentedSource is an instrumented message source
-// The counter vector will have the labels "status", "topic" and "consumer"
+// The counter will have the labels "status", "topic" and "consumer"
 type instrumentedSource struct {
-	impl     substrate.AsyncMessageSource
-	counter  *prometheus.CounterVec
-	topic    string
-	consumer string
+	impl       substrate.AsyncMessageSource
+	metrics    Metrics
+	counter    Counter
+	lag        Gauge
+	size       Histogram
+	bytes      Counter
+	ackLatency Histogram
+	errors     *errorCounter
+	labelled   *labelCounter
+	reconnects *reconnectTracker
+	topic      string
+	consumer   string
 }
 
 // ConsumeMessages implements message consuming wrapped in instrumentation
-func (ams *instrumentedSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+func (ams *instrumentedSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) (rerr error) {
+	emit := events.NewEmitter(ctx, events.Info{Component: "instrumented", Topic: ams.topic})
+	ams.reconnects.start(ctx)
+	defer func() {
+		ams.reconnects.end(rerr)
+	}()
+
 	toBeAcked := make(chan substrate.Message, cap(acks))
 
+	var latency *latencyTracker
+	if ams.ackLatency != nil {
+		latency = newLatencyTracker(ams.ackLatency, ams.topic, ams.consumer)
+	}
+
+	consumed := messages
+	if ams.lag != nil || ams.size != nil || ams.bytes != nil || latency != nil {
+		trackCtx, trackCancel := context.WithCancel(ctx)
+		defer trackCancel()
+
+		consumed = ams.trackMessages(trackCtx, latency, messages)
+	}
+
 	errs := make(chan error)
 	go func() {
 		defer close(errs)
-		errs <- ams.impl.ConsumeMessages(ctx, messages, toBeAcked)
+		errs <- ams.impl.ConsumeMessages(ctx, consumed, toBeAcked)
 	}()
 
 	for {
 		select {
 		case ack := <-acks:
+			emit.Ack(ack)
+			if latency != nil {
+				latency.observe()
+			}
 			select {
 			case toBeAcked <- ack:
 			case <-ctx.Done():
 				return <-errs
 			case err := <-errs:
 				if err != nil {
-					ams.counter.WithLabelValues("error", ams.topic, ams.consumer).Inc()
+					ams.counter.Add(1, "error", ams.topic, ams.consumer)
+					ams.errors.record(err, ams.topic, ams.consumer)
+					emit.Error(err)
 				}
 				return err
 			}
-			ams.counter.WithLabelValues("success", ams.topic, ams.consumer).Inc()
+			ams.counter.Add(1, "success", ams.topic, ams.consumer)
+			ams.labelled.record(ack, ams.topic, ams.consumer)
+			ams.reconnects.acked()
 		case <-ctx.Done():
 			return <-errs
 		case err := <-errs:
 			if err != nil {
-				ams.counter.WithLabelValues("error", ams.topic, ams.consumer).Inc()
+				ams.counter.Add(1, "error", ams.topic, ams.consumer)
+				ams.errors.record(err, ams.topic, ams.consumer)
+				emit.Error(err)
 			}
 			return err
 		}
 	}
 }
 
+// trackMessages returns a channel from which consumed messages are forwarded to the provided channel,
+// while updating the metrics that are derived from the messages themselves, and recording the time at which
+// each of them was delivered if the ack latency is tracked.
+func (ams *instrumentedSource) trackMessages(ctx context.Context, latency *latencyTracker, messages chan<- substrate.Message) chan<- substrate.Message {
+	consumed := make(chan substrate.Message, cap(messages))
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-consumed:
+				if ams.lag != nil {
+					observeLag(ams.lag, msg, ams.topic, ams.consumer)
+				}
+				if ams.size != nil {
+					ams.size.Observe(float64(len(msg.Data())), ams.topic, ams.consumer)
+				}
+				if ams.bytes != nil {
+					ams.bytes.Add(float64(len(msg.Data())), ams.topic, ams.consumer)
+				}
+				if latency != nil {
+					// the message can't be acknowledged before it is delivered, so it is recorded beforehand
+					latency.start(msg)
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case messages <- msg:
+				}
+			}
+		}
+	}()
+	return consumed
+}
+
 // Close closes the message source
 func (ams *instrumentedSource) Close() error {
 	return ams.impl.Close()
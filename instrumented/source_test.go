@@ -39,11 +39,11 @@ func TestConsumeMessagesSuccessfully(t *testing.T) {
 				}
 			},
 		},
-		counter: prometheus.NewCounterVec(
+		counter: prometheusCounter{prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Help: "source_counter",
 				Name: "source_counter",
-			}, sourceLabels),
+			}, sourceLabels)},
 		topic:    "testTopic",
 		consumer: "testConsumer",
 	}
@@ -69,7 +69,7 @@ func TestConsumeMessagesSuccessfully(t *testing.T) {
 			return
 		case <-receivedAcks:
 			var metric dto.Metric
-			assert.NoError(t, source.counter.WithLabelValues("success", "testTopic", "testConsumer").Write(&metric))
+			assert.NoError(t, source.counter.(prometheusCounter).WithLabelValues("success", "testTopic", "testConsumer").Write(&metric))
 			assert.Equal(t, 1, int(*metric.Counter.Value))
 
 			sourceCancel()
@@ -86,11 +86,11 @@ func TestConsumeMessagesWithError(t *testing.T) {
 				return consumingErr
 			},
 		},
-		counter: prometheus.NewCounterVec(
+		counter: prometheusCounter{prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Help: "source_counter",
 				Name: "source_counter",
-			}, sourceLabels),
+			}, sourceLabels)},
 		topic:    "testTopic",
 		consumer: "testConsumer",
 	}
@@ -112,7 +112,7 @@ func TestConsumeMessagesWithError(t *testing.T) {
 	assert.Equal(t, consumingErr, err)
 
 	var metric dto.Metric
-	assert.NoError(t, source.counter.WithLabelValues("error", "testTopic", "testConsumer").Write(&metric))
+	assert.NoError(t, source.counter.(prometheusCounter).WithLabelValues("error", "testTopic", "testConsumer").Write(&metric))
 	assert.Equal(t, 1, int(*metric.Counter.Value))
 
 	sourceCancel()
@@ -133,11 +133,11 @@ func TestConsumeOnBackendShutdown(t *testing.T) {
 				}
 			},
 		},
-		counter: prometheus.NewCounterVec(
+		counter: prometheusCounter{prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Help: "source_counter",
 				Name: "source_counter",
-			}, sourceLabels),
+			}, sourceLabels)},
 		topic:    "testTopic",
 		consumer: "testConsumer",
 	}
@@ -172,7 +172,192 @@ func TestConsumeOnBackendShutdown(t *testing.T) {
 		assert.Equal(t, expectedErr, err)
 		// Check metric was increased
 		var metric dto.Metric
-		assert.NoError(t, source.counter.WithLabelValues("error", "testTopic", "testConsumer").Write(&metric))
+		assert.NoError(t, source.counter.(prometheusCounter).WithLabelValues("error", "testTopic", "testConsumer").Write(&metric))
 		assert.Equal(t, 1, int(*metric.Counter.Value))
 	}
 }
+
+type offsetMessage struct {
+	Message
+	partition     string
+	offset        int64
+	highWaterMark int64
+}
+
+func (m offsetMessage) Partition() string {
+	return m.partition
+}
+
+func (m offsetMessage) Offset() int64 {
+	return m.offset
+}
+
+func (m offsetMessage) HighWaterMark() int64 {
+	return m.highWaterMark
+}
+
+func TestConsumeMessagesWithLagGauge(t *testing.T) {
+	source := instrumentedSource{
+		impl: &asyncMessageSourceMock{
+			consumerMessagesMock: func(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+				messages <- offsetMessage{partition: "0", offset: 5, highWaterMark: 10}
+				messages <- offsetMessage{partition: "1", offset: 3, highWaterMark: 4}
+				messages <- Message{}
+				<-ctx.Done()
+				return nil
+			},
+		},
+		counter: prometheusCounter{prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Help: "source_counter",
+				Name: "source_counter",
+			}, sourceLabels)},
+		lag: prometheusGauge{prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Help: "source_lag",
+				Name: "source_lag",
+			}, lagLabels)},
+		topic:    "testTopic",
+		consumer: "testConsumer",
+	}
+
+	messages := make(chan substrate.Message)
+
+	sourceContext, sourceCancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer sourceCancel()
+
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- source.ConsumeMessages(sourceContext, messages, make(chan substrate.Message))
+	}()
+
+	for i := 0; i < 3; i++ {
+		<-messages
+	}
+	sourceCancel()
+	assert.NoError(t, <-errs)
+
+	var metric dto.Metric
+	assert.NoError(t, source.lag.(prometheusGauge).WithLabelValues("testTopic", "testConsumer", "0").Write(&metric))
+	assert.Equal(t, 4, int(*metric.Gauge.Value))
+	assert.NoError(t, source.lag.(prometheusGauge).WithLabelValues("testTopic", "testConsumer", "1").Write(&metric))
+	assert.Equal(t, 0, int(*metric.Gauge.Value))
+}
+
+func TestConsumeMessagesWithSizeMetrics(t *testing.T) {
+	source := instrumentedSource{
+		impl: &asyncMessageSourceMock{
+			consumerMessagesMock: func(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+				messages <- Message{data: []byte("12345")}
+				messages <- Message{data: []byte("123")}
+				messages <- Message{}
+				<-ctx.Done()
+				return nil
+			},
+		},
+		counter: prometheusCounter{prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Help: "source_counter",
+				Name: "source_counter",
+			}, sourceLabels)},
+		size: prometheusHistogram{prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Help:    "source_message_size",
+				Name:    "source_message_size",
+				Buckets: []float64{4, 16},
+			}, sizeLabels)},
+		bytes: prometheusCounter{prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Help: "source_consumed_bytes",
+				Name: "source_consumed_bytes",
+			}, sizeLabels)},
+		topic:    "testTopic",
+		consumer: "testConsumer",
+	}
+
+	messages := make(chan substrate.Message)
+
+	sourceContext, sourceCancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer sourceCancel()
+
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- source.ConsumeMessages(sourceContext, messages, make(chan substrate.Message))
+	}()
+
+	for i := 0; i < 3; i++ {
+		<-messages
+	}
+	sourceCancel()
+	assert.NoError(t, <-errs)
+
+	var metric dto.Metric
+	assert.NoError(t, source.bytes.(prometheusCounter).WithLabelValues("testTopic", "testConsumer").Write(&metric))
+	assert.Equal(t, 8, int(*metric.Counter.Value))
+
+	histogram := source.size.(prometheusHistogram).WithLabelValues("testTopic", "testConsumer").(prometheus.Histogram)
+	assert.NoError(t, histogram.Write(&metric))
+	assert.Equal(t, uint64(3), metric.Histogram.GetSampleCount())
+	assert.Equal(t, 8.0, metric.Histogram.GetSampleSum())
+	assert.Equal(t, uint64(2), metric.Histogram.Bucket[0].GetCumulativeCount())
+	assert.Equal(t, uint64(3), metric.Histogram.Bucket[1].GetCumulativeCount())
+}
+
+func TestConsumeMessagesWithAckLatency(t *testing.T) {
+	source := instrumentedSource{
+		impl: &asyncMessageSourceMock{
+			consumerMessagesMock: func(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+				for i := 0; i < 2; i++ {
+					messages <- Message{}
+					select {
+					case <-ctx.Done():
+						return nil
+					case <-acks:
+					}
+				}
+				<-ctx.Done()
+				return nil
+			},
+		},
+		counter: prometheusCounter{prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Help: "source_counter",
+				Name: "source_counter",
+			}, sourceLabels)},
+		ackLatency: prometheusHistogram{prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Help:    "source_ack_latency",
+				Name:    "source_ack_latency",
+				Buckets: []float64{0.01, 10},
+			}, sizeLabels)},
+		topic:    "testTopic",
+		consumer: "testConsumer",
+	}
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+
+	sourceContext, sourceCancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer sourceCancel()
+
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- source.ConsumeMessages(sourceContext, messages, acks)
+	}()
+
+	acks <- <-messages
+	msg := <-messages
+	time.Sleep(time.Millisecond * 20)
+	acks <- msg
+	sourceCancel()
+	assert.NoError(t, <-errs)
+
+	var metric dto.Metric
+	histogram := source.ackLatency.(prometheusHistogram).WithLabelValues("testTopic", "testConsumer").(prometheus.Histogram)
+	assert.NoError(t, histogram.Write(&metric))
+	assert.Equal(t, uint64(2), metric.Histogram.GetSampleCount())
+	assert.Equal(t, uint64(1), metric.Histogram.Bucket[0].GetCumulativeCount())
+	assert.Equal(t, uint64(2), metric.Histogram.Bucket[1].GetCumulativeCount())
+}
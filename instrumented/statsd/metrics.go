@@ -0,0 +1,115 @@
+// Package statsd provides an implementation of instrumented.Metrics that writes metrics in the statsd line protocol.
+package statsd
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/uw-labs/substrate-tools/instrumented"
+)
+
+// Option is a function which sets a statsd metrics configuration option.
+type Option func(m *metrics)
+
+// WithPrefix sets a prefix, which is joined to the name of every metric with a dot.
+func WithPrefix(prefix string) Option {
+	return func(m *metrics) {
+		m.prefix = prefix + "."
+	}
+}
+
+// WithLabelsInName appends the label values to the name of the metric, separated by dots, instead of
+// sending them as DogStatsD tags. This is useful for statsd servers that don't support tags.
+func WithLabelsInName() Option {
+	return func(m *metrics) {
+		m.labelsInName = true
+	}
+}
+
+// New returns an implementation of instrumented.Metrics that writes every recorded value to the provided writer
+// as a single newline terminated statsd line. Labels are sent as DogStatsD tags by default. Errors returned by
+// the writer are ignored, as metrics are sent on a best effort basis.
+func New(w io.Writer, opts ...Option) instrumented.Metrics {
+	m := &metrics{w: w}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+type metrics struct {
+	mu           sync.Mutex
+	w            io.Writer
+	prefix       string
+	labelsInName bool
+}
+
+func (m *metrics) NewCounter(opts instrumented.MetricOpts, labels []string) instrumented.Counter {
+	return metric{metrics: m, name: opts.Name, labels: labels, kind: "c"}
+}
+
+func (m *metrics) NewGauge(opts instrumented.MetricOpts, labels []string) instrumented.Gauge {
+	return metric{metrics: m, name: opts.Name, labels: labels, kind: "g"}
+}
+
+func (m *metrics) NewHistogram(opts instrumented.MetricOpts, labels []string) instrumented.Histogram {
+	return metric{metrics: m, name: opts.Name, labels: labels, kind: "h"}
+}
+
+// write writes a single line for the metric.
+func (m *metrics) write(name string, labels []string, kind string, value float64, labelValues []string) {
+	var b strings.Builder
+	b.WriteString(m.prefix)
+	b.WriteString(name)
+	if m.labelsInName {
+		for _, value := range labelValues {
+			b.WriteByte('.')
+			b.WriteString(value)
+		}
+	}
+	b.WriteByte(':')
+	b.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	b.WriteByte('|')
+	b.WriteString(kind)
+	if !m.labelsInName && len(labels) > 0 {
+		b.WriteString("|#")
+		for i, label := range labels {
+			if i >= len(labelValues) {
+				break
+			}
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(label)
+			b.WriteByte(':')
+			b.WriteString(labelValues[i])
+		}
+	}
+	b.WriteByte('\n')
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, _ = io.WriteString(m.w, b.String())
+}
+
+// metric implements the counter, gauge and histogram interfaces, which only differ in their statsd type.
+type metric struct {
+	metrics *metrics
+	name    string
+	labels  []string
+	kind    string
+}
+
+func (m metric) Add(value float64, labelValues ...string) {
+	m.metrics.write(m.name, m.labels, m.kind, value, labelValues)
+}
+
+func (m metric) Set(value float64, labelValues ...string) {
+	m.metrics.write(m.name, m.labels, m.kind, value, labelValues)
+}
+
+func (m metric) Observe(value float64, labelValues ...string) {
+	m.metrics.write(m.name, m.labels, m.kind, value, labelValues)
+}
@@ -0,0 +1,44 @@
+package statsd_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/uw-labs/substrate-tools/instrumented"
+	"github.com/uw-labs/substrate-tools/instrumented/statsd"
+)
+
+func TestMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	metrics := statsd.New(&buf, statsd.WithPrefix("app"))
+
+	labels := []string{"status", "topic"}
+	metrics.NewCounter(instrumented.MetricOpts{Name: "counter"}, labels).Add(1, "success", "topic")
+	metrics.NewGauge(instrumented.MetricOpts{Name: "gauge"}, labels).Set(2.5, "success", "topic")
+	metrics.NewHistogram(instrumented.MetricOpts{Name: "histogram"}, nil).Observe(0.25)
+
+	assert.Equal(t, "app.counter:1|c|#status:success,topic:topic\n"+
+		"app.gauge:2.5|g|#status:success,topic:topic\n"+
+		"app.histogram:0.25|h\n", buf.String())
+}
+
+func TestMetricsWithLabelsInName(t *testing.T) {
+	var buf bytes.Buffer
+	metrics := statsd.New(&buf, statsd.WithLabelsInName())
+
+	metrics.NewCounter(instrumented.MetricOpts{Name: "counter"}, []string{"status", "topic"}).Add(3, "error", "topic")
+
+	assert.Equal(t, "counter.error.topic:3|c\n", buf.String())
+}
+
+func TestInstrumentedSinkWithMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	metrics := statsd.New(&buf)
+
+	instrumented.NewAsyncMessageSinkWithMetrics(nil, metrics, instrumented.MetricOpts{Name: "sink_counter"}, "topic")
+
+	assert.Equal(t, "sink_counter:0|c|#status:error,topic:topic\n"+
+		"sink_counter:0|c|#status:success,topic:topic\n", buf.String())
+}
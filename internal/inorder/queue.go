@@ -0,0 +1,94 @@
+// Package inorder provides a queue releasing values in the order in which they were pushed, for wrappers that
+// complete some messages themselves, e.g. by skipping them, while others wait for an acknowledgement.
+package inorder
+
+import (
+	"sync"
+)
+
+// Queue holds values in the order in which they were pushed and releases them in that order once they are done.
+// Values are either done when pushed, or once the acknowledgement of their key arrives. Keys are matched per lane:
+// the values of a lane must be acknowledged in order, but lanes are acknowledged independently of each other,
+// e.g. messages delivered to the user and messages published to a side sink. It is safe for concurrent use.
+type Queue[K comparable, V any] struct {
+	mutex   sync.Mutex
+	pending []entry[K, V]
+	ready   chan struct{}
+}
+
+type entry[K comparable, V any] struct {
+	lane  int
+	key   K
+	value V
+	done  bool
+}
+
+// NewQueue returns a new empty queue.
+func NewQueue[K comparable, V any]() *Queue[K, V] {
+	return &Queue[K, V]{
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// Push queues a value that is done once the key is acknowledged in the lane.
+func (q *Queue[K, V]) Push(lane int, key K, value V) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.pending = append(q.pending, entry[K, V]{lane: lane, key: key, value: value})
+}
+
+// PushDone queues a value that is already done. As it may be releasable straight away, Ready is signalled.
+func (q *Queue[K, V]) PushDone(value V) {
+	q.mutex.Lock()
+	q.pending = append(q.pending, entry[K, V]{value: value, done: true})
+	q.mutex.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Ready returns a channel that is signalled after values were pushed using PushDone. Signals are coalesced,
+// which is safe as Release always releases all values that are done.
+func (q *Queue[K, V]) Ready() <-chan struct{} {
+	return q.ready
+}
+
+// Complete marks the first value of the lane that isn't done yet as done, if its key matches the acknowledged
+// one, and returns the values that can be released, in order. It reports false if the key doesn't match.
+func (q *Queue[K, V]) Complete(lane int, key K) ([]V, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for i := range q.pending {
+		if q.pending[i].done || q.pending[i].lane != lane {
+			continue
+		}
+		if q.pending[i].key != key {
+			return nil, false
+		}
+		q.pending[i].done = true
+		return q.release(), true
+	}
+	return nil, false
+}
+
+// Release removes the values at the front of the queue that are done and returns them, in order.
+func (q *Queue[K, V]) Release() []V {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.release()
+}
+
+func (q *Queue[K, V]) release() []V {
+	var released []V
+	for len(q.pending) > 0 && q.pending[0].done {
+		released = append(released, q.pending[0].value)
+		q.pending[0] = entry[K, V]{}
+		q.pending = q.pending[1:]
+	}
+	return released
+}
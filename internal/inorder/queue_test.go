@@ -0,0 +1,67 @@
+package inorder_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate-tools/internal/inorder"
+)
+
+func TestQueue_ReleasesInOrder(t *testing.T) {
+	q := inorder.NewQueue[string, int]()
+	q.Push(0, "a", 1)
+	q.PushDone(2)
+	q.Push(0, "b", 3)
+	q.PushDone(4)
+
+	// done values wait for the values pushed before them
+	<-q.Ready()
+	require.Empty(t, q.Release())
+
+	released, ok := q.Complete(0, "a")
+	require.True(t, ok)
+	require.Equal(t, []int{1, 2}, released)
+
+	released, ok = q.Complete(0, "b")
+	require.True(t, ok)
+	require.Equal(t, []int{3, 4}, released)
+}
+
+func TestQueue_CompleteBehindDoneValues(t *testing.T) {
+	q := inorder.NewQueue[string, int]()
+	q.PushDone(1)
+	q.PushDone(2)
+	q.Push(0, "a", 3)
+
+	// the acknowledgement is matched even though the done values at the front weren't released yet
+	released, ok := q.Complete(0, "a")
+	require.True(t, ok)
+	require.Equal(t, []int{1, 2, 3}, released)
+	require.Empty(t, q.Release())
+}
+
+func TestQueue_Lanes(t *testing.T) {
+	q := inorder.NewQueue[string, int]()
+	q.Push(0, "a", 1)
+	q.Push(1, "b", 2)
+	q.Push(0, "c", 3)
+
+	released, ok := q.Complete(1, "b")
+	require.True(t, ok)
+	require.Empty(t, released)
+
+	released, ok = q.Complete(0, "a")
+	require.True(t, ok)
+	require.Equal(t, []int{1, 2}, released)
+
+	// keys are matched in order within a lane
+	_, ok = q.Complete(0, "b")
+	require.False(t, ok)
+	_, ok = q.Complete(1, "c")
+	require.False(t, ok)
+
+	released, ok = q.Complete(0, "c")
+	require.True(t, ok)
+	require.Equal(t, []int{3}, released)
+}
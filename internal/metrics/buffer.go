@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The names of the gauges reporting the occupancy of the buffers of all the wrappers, so that backpressure can be
+// monitored the same way across wrappers.
+const (
+	BufferUsedName     = "substrate_tools_buffer_used"
+	BufferCapacityName = "substrate_tools_buffer_capacity"
+)
+
+// bufferLabels are the wrapper owning the buffer, the name given to its instance and the buffer within the instance,
+// which is empty for wrappers with a single buffer.
+var bufferLabels = []string{"wrapper", "name", "buffer"}
+
+// Buffer reports the occupancy of a buffer using the shared buffer gauges. A nil Buffer reports nothing, so that
+// wrappers don't need to check whether reporting is enabled.
+type Buffer struct {
+	used prometheus.Gauge
+}
+
+// NewBuffer returns a Buffer reporting with the buffer gauges registered with the default registry, setting the
+// capacity of the buffer, which is 0 if it is unbounded. It panics in case it can't register the gauges.
+func NewBuffer(wrapper, name, buffer string, capacity float64) *Buffer {
+	return NewBufferWith(prometheus.DefaultRegisterer, wrapper, name, buffer, capacity)
+}
+
+// NewBufferWith is like NewBuffer, but registers the gauges with the provided registerer.
+func NewBufferWith(registerer prometheus.Registerer, wrapper, name, buffer string, capacity float64) *Buffer {
+	used := RegisterWith(registerer, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: BufferUsedName,
+		Help: "Number of messages, or bytes for buffers on disk, held in the buffer of a wrapper.",
+	}, bufferLabels)).(*prometheus.GaugeVec)
+	capacities := RegisterWith(registerer, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: BufferCapacityName,
+		Help: "Capacity of the buffer of a wrapper, in the same unit as the used gauge, or 0 if it is unbounded.",
+	}, bufferLabels)).(*prometheus.GaugeVec)
+
+	capacities.WithLabelValues(wrapper, name, buffer).Set(capacity)
+	b := &Buffer{used: used.WithLabelValues(wrapper, name, buffer)}
+	b.Set(0)
+	return b
+}
+
+// Set sets the occupancy of the buffer.
+func (b *Buffer) Set(used float64) {
+	if b != nil {
+		b.used.Set(used)
+	}
+}
+
+// Add adds the delta, which can be negative, to the occupancy of the buffer.
+func (b *Buffer) Add(delta float64) {
+	if b != nil {
+		b.used.Add(delta)
+	}
+}
@@ -0,0 +1,23 @@
+// Package metrics provides the registration of prometheus collectors shared by the wrappers.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Register registers the collector with the default registry, returning the existing collector
+// if an identical one has already been registered. It panics in case it can't register the collector.
+func Register(collector prometheus.Collector) prometheus.Collector {
+	return RegisterWith(prometheus.DefaultRegisterer, collector)
+}
+
+// RegisterWith is like Register, but registers the collector with the provided registerer.
+func RegisterWith(registerer prometheus.Registerer, collector prometheus.Collector) prometheus.Collector {
+	if err := registerer.Register(collector); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+		panic(err)
+	}
+	return collector
+}
@@ -0,0 +1,52 @@
+package metrics_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+)
+
+func TestRegisterWith(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	opts := prometheus.CounterOpts{Name: "counter", Help: "counter"}
+
+	counter := metrics.RegisterWith(registry, prometheus.NewCounter(opts))
+	// an identical collector is replaced by the registered one
+	require.Equal(t, counter, metrics.RegisterWith(registry, prometheus.NewCounter(opts)))
+
+	// a collector conflicting with a registered one can't be registered
+	require.Panics(t, func() {
+		metrics.RegisterWith(registry, prometheus.NewCounter(prometheus.CounterOpts{Name: "counter", Help: "different help"}))
+	})
+}
+
+func TestNewBufferWith(t *testing.T) {
+	registry := prometheus.NewRegistry()
+
+	buffer := metrics.NewBufferWith(registry, "fanout", "orders", "0", 10)
+	buffer.Add(3)
+	buffer.Add(-1)
+	metrics.NewBufferWith(registry, "spool", "orders", "", 0).Set(512)
+
+	expected := `
+# HELP substrate_tools_buffer_capacity Capacity of the buffer of a wrapper, in the same unit as the used gauge, or 0 if it is unbounded.
+# TYPE substrate_tools_buffer_capacity gauge
+substrate_tools_buffer_capacity{buffer="",name="orders",wrapper="spool"} 0
+substrate_tools_buffer_capacity{buffer="0",name="orders",wrapper="fanout"} 10
+# HELP substrate_tools_buffer_used Number of messages, or bytes for buffers on disk, held in the buffer of a wrapper.
+# TYPE substrate_tools_buffer_used gauge
+substrate_tools_buffer_used{buffer="",name="orders",wrapper="spool"} 512
+substrate_tools_buffer_used{buffer="0",name="orders",wrapper="fanout"} 2
+`
+	require.NoError(t, testutil.GatherAndCompare(registry, bytes.NewBufferString(expected), metrics.BufferUsedName, metrics.BufferCapacityName))
+
+	// a nil buffer reports nothing
+	var disabled *metrics.Buffer
+	disabled.Set(1)
+	disabled.Add(1)
+}
@@ -0,0 +1,115 @@
+// Package transform provides the publishing loop shared by sink wrappers publishing other messages in place of the
+// messages they receive, e.g. compressed, encrypted or split ones, and acknowledging the original messages once the
+// sink acknowledged the messages published in their place.
+package transform
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// Func returns the message to publish in place of the provided one.
+type Func func(ctx context.Context, msg substrate.Message) (substrate.Message, error)
+
+// SplitFunc returns the messages to publish in place of the provided one, in order. It must return at least one
+// message.
+type SplitFunc func(ctx context.Context, msg substrate.Message) ([]substrate.Message, error)
+
+// inFlight pairs a published message with the original one, which is acknowledged in its place once the last
+// message published for it is acknowledged.
+type inFlight struct {
+	published substrate.Message
+	original  substrate.Message
+	last      bool
+}
+
+// PublishMessages publishes the message returned by the function in place of each message to the sink, and
+// acknowledges the original message once the sink acknowledged it. It fails as soon as the function does. The sink
+// must acknowledge messages in the order in which they were published. Events are emitted into the hooks carried by
+// the context as the ones of the component.
+func PublishMessages(ctx context.Context, component string, sink substrate.AsyncMessageSink, acks chan<- substrate.Message, messages <-chan substrate.Message, f Func) error {
+	return PublishSplit(ctx, component, sink, acks, messages, func(ctx context.Context, msg substrate.Message) ([]substrate.Message, error) {
+		out, err := f(ctx, msg)
+		if err != nil {
+			return nil, err
+		}
+		return []substrate.Message{out}, nil
+	})
+}
+
+// PublishSplit is like PublishMessages, but publishes any number of messages in place of each message, which is
+// acknowledged once the sink acknowledged all of them.
+func PublishSplit(ctx context.Context, component string, sink substrate.AsyncMessageSink, acks chan<- substrate.Message, messages <-chan substrate.Message, f SplitFunc) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: component})
+	rg, ctx := rungroup.New(ctx)
+
+	toPublish := make(chan substrate.Message, cap(messages))
+	published := make(chan substrate.Message, cap(acks))
+
+	var (
+		mu      sync.Mutex
+		pending []inFlight
+	)
+
+	rg.Go(func() error {
+		return sink.PublishMessages(ctx, published, toPublish)
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-messages:
+				emit.Publish(msg)
+				out, err := f(ctx, msg)
+				if err != nil {
+					return err
+				}
+				for i, pMsg := range out {
+					mu.Lock()
+					pending = append(pending, inFlight{published: pMsg, original: msg, last: i == len(out)-1})
+					mu.Unlock()
+					select {
+					case <-ctx.Done():
+						return nil
+					case toPublish <- pMsg:
+					}
+				}
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-published:
+				mu.Lock()
+				if len(pending) == 0 || pending[0].published != ack {
+					mu.Unlock()
+					return errors.Errorf("unexpected message acknowledged: %v", ack)
+				}
+				next := pending[0]
+				pending = pending[1:]
+				mu.Unlock()
+				if !next.last {
+					continue
+				}
+				emit.Ack(next.original)
+				select {
+				case <-ctx.Done():
+					return nil
+				case acks <- next.original:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
@@ -0,0 +1,95 @@
+package transform_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/internal/transform"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+func TestPublishMessages(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	sink := mock.NewSink().Expect("A", "B")
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- transform.PublishMessages(ctx, "test", sink, acks, messages, func(_ context.Context, msg substrate.Message) (substrate.Message, error) {
+			return message.FromString(strings.ToUpper(string(msg.Data()))), nil
+		})
+	}()
+
+	// The original messages are acknowledged in place of the published ones.
+	for _, payload := range []string{"a", "b"} {
+		msg := message.FromString(payload)
+		messages <- msg
+		require.Equal(t, substrate.Message(msg), <-acks)
+	}
+	cancel()
+	require.NoError(t, <-errs)
+	require.True(t, sink.AssertExpectations(t))
+}
+
+func TestPublishSplit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	sink := mock.NewSink().Expect("a", "b", "c")
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- transform.PublishSplit(ctx, "test", sink, acks, messages, func(_ context.Context, msg substrate.Message) ([]substrate.Message, error) {
+			var out []substrate.Message
+			for _, r := range string(msg.Data()) {
+				out = append(out, message.FromString(string(r)))
+			}
+			return out, nil
+		})
+	}()
+
+	// Each original message is acknowledged once all the messages published in its place are.
+	for _, payload := range []string{"ab", "c"} {
+		msg := message.FromString(payload)
+		messages <- msg
+		require.Equal(t, substrate.Message(msg), <-acks)
+	}
+	cancel()
+	require.NoError(t, <-errs)
+	require.True(t, sink.AssertExpectations(t))
+}
+
+func TestPublishMessages_Error(t *testing.T) {
+	errTransform := errors.New("transform failed")
+	messages := make(chan substrate.Message, 1)
+	messages <- message.FromString("a")
+
+	err := transform.PublishMessages(context.Background(), "test", mock.NewSink(), make(chan substrate.Message), messages, func(context.Context, substrate.Message) (substrate.Message, error) {
+		return nil, errTransform
+	})
+	require.Equal(t, errTransform, err)
+}
+
+func TestPublishMessages_UnexpectedAck(t *testing.T) {
+	// The sink skips the acknowledgement of the first message, acknowledging the second one first.
+	sink := mock.NewSink().AckWith(func(n int, _ substrate.Message) bool {
+		return n != 1
+	})
+	messages := make(chan substrate.Message, 2)
+	messages <- message.FromString("a")
+	messages <- message.FromString("b")
+
+	err := transform.PublishMessages(context.Background(), "test", sink, make(chan substrate.Message), messages, func(_ context.Context, msg substrate.Message) (substrate.Message, error) {
+		return message.FromString(string(msg.Data())), nil
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unexpected message acknowledged")
+}
@@ -0,0 +1,228 @@
+// Package wal provides a write-ahead log of encoded messages with a committed offset, for wrappers that persist
+// messages to disk before publishing them, e.g. while the backend is unavailable or until they are due.
+package wal
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+const headerSize = 8
+
+// ErrFull is returned when an entry can't be appended because the entries that haven't been committed reached the
+// maximum size of the log.
+var ErrFull = errors.New("log is full")
+
+// Log is a write-ahead log. Each entry consists of the length and the CRC-32 checksum of its body, followed by
+// the body itself. The offset of the first entry that hasn't been committed yet is stored in a separate file.
+// It is not safe for concurrent use, except for reading the backlog and whether it is full.
+type Log struct {
+	name       string
+	file       *os.File
+	offsetFile *os.File
+	maxSize    int64
+	syncEvery  time.Duration
+	lastSync   map[*os.File]time.Time
+
+	size      int64
+	readPos   int64
+	committed int64
+
+	// backlog is the size of the entries that haven't been committed, and full whether the last entry
+	// couldn't be appended because of the maximum size.
+	backlog atomic.Int64
+	full    atomic.Bool
+}
+
+// Open opens the log with the name in the directory, creating it if needed. The entries are stored in the
+// file "<name>.wal" and the committed offset in "<name>.offset". Entries after the first invalid one, e.g. one
+// partially written before a crash, are discarded. A positive maximum size limits the size in bytes of the entries
+// that haven't been committed.
+// By default the files are flushed to disk after every write, a positive sync interval flushes them on writes
+// at most once per interval, while a negative one leaves flushing to the OS.
+func Open(dir, name string, maxSize int64, syncEvery time.Duration) (*Log, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrapf(err, "failed to create %s directory", name)
+	}
+	file, err := os.OpenFile(filepath.Join(dir, name+".wal"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s", name)
+	}
+	offsetFile, err := os.OpenFile(filepath.Join(dir, name+".offset"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrapf(err, "failed to open %s offset", name)
+	}
+
+	l := &Log{
+		name:       name,
+		file:       file,
+		offsetFile: offsetFile,
+		maxSize:    maxSize,
+		syncEvery:  syncEvery,
+		lastSync:   make(map[*os.File]time.Time),
+	}
+	if err := l.recover(); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// recover restores the committed offset and truncates the log after its last valid entry.
+func (l *Log) recover() error {
+	info, err := l.file.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %s", l.name)
+	}
+	l.size = info.Size()
+
+	var offset [8]byte
+	switch _, err := l.offsetFile.ReadAt(offset[:], 0); err {
+	case nil:
+		l.committed = int64(binary.BigEndian.Uint64(offset[:]))
+	case io.EOF:
+	default:
+		return errors.Wrapf(err, "failed to read %s offset", l.name)
+	}
+	if l.committed > l.size {
+		l.committed = l.size
+	}
+
+	l.readPos = l.committed
+	for l.readPos < l.size {
+		if _, err := l.Next(); err != nil {
+			if err := l.file.Truncate(l.readPos); err != nil {
+				return errors.Wrapf(err, "failed to truncate %s", l.name)
+			}
+			l.size = l.readPos
+		}
+	}
+	l.readPos = l.committed
+	l.backlog.Store(l.size - l.committed)
+	return nil
+}
+
+// Append writes an entry with the body to the end of the log and returns the offset at which the next entry
+// starts, which is the offset to commit once the entry was processed.
+func (l *Log) Append(body []byte) (int64, error) {
+	entry := make([]byte, headerSize+len(body))
+	binary.BigEndian.PutUint32(entry[0:4], uint32(len(body)))
+	binary.BigEndian.PutUint32(entry[4:8], crc32.ChecksumIEEE(body))
+	copy(entry[headerSize:], body)
+
+	if l.maxSize > 0 && l.size-l.committed+int64(len(entry)) > l.maxSize {
+		l.full.Store(true)
+		return 0, ErrFull
+	}
+	if _, err := l.file.WriteAt(entry, l.size); err != nil {
+		return 0, errors.Wrapf(err, "failed to write to %s", l.name)
+	}
+	l.size += int64(len(entry))
+	l.backlog.Store(l.size - l.committed)
+	l.full.Store(false)
+	return l.size, l.sync(l.file)
+}
+
+// Entry is an entry read from the log, along with the offset at which the next entry starts.
+type Entry struct {
+	Body []byte
+	End  int64
+}
+
+// Next reads the entry at the read position and advances it. It returns nil if all entries have been read.
+func (l *Log) Next() (*Entry, error) {
+	if l.readPos >= l.size {
+		return nil, nil
+	}
+	var header [headerSize]byte
+	if _, err := l.file.ReadAt(header[:], l.readPos); err != nil {
+		return nil, errors.Wrapf(err, "failed to read from %s", l.name)
+	}
+	length := int64(binary.BigEndian.Uint32(header[0:4]))
+	if l.readPos+headerSize+length > l.size {
+		return nil, errors.Errorf("%s is corrupted", l.name)
+	}
+	body := make([]byte, length)
+	if _, err := l.file.ReadAt(body, l.readPos+headerSize); err != nil {
+		return nil, errors.Wrapf(err, "failed to read from %s", l.name)
+	}
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, errors.Errorf("%s is corrupted", l.name)
+	}
+
+	l.readPos += headerSize + length
+	return &Entry{Body: body, End: l.readPos}, nil
+}
+
+// Commit records that all entries before the offset have been processed.
+func (l *Log) Commit(offset int64) error {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(offset))
+	if _, err := l.offsetFile.WriteAt(buf[:], 0); err != nil {
+		return errors.Wrapf(err, "failed to write %s offset", l.name)
+	}
+	l.committed = offset
+	l.backlog.Store(l.size - l.committed)
+	l.full.Store(false)
+	return l.sync(l.offsetFile)
+}
+
+// Rewind moves the read position back to the first entry that hasn't been committed.
+func (l *Log) Rewind() {
+	l.readPos = l.committed
+}
+
+// Drained reports whether all entries have been committed.
+func (l *Log) Drained() bool {
+	return l.committed == l.size
+}
+
+// Reset discards all entries, which must have been committed.
+func (l *Log) Reset() error {
+	if err := l.file.Truncate(0); err != nil {
+		return errors.Wrapf(err, "failed to truncate %s", l.name)
+	}
+	l.size, l.readPos = 0, 0
+	return l.Commit(0)
+}
+
+// Backlog returns the size in bytes of the entries that haven't been committed.
+func (l *Log) Backlog() int64 {
+	return l.backlog.Load()
+}
+
+// Full reports whether the last entry couldn't be appended because of the maximum size, until an entry is appended
+// or committed.
+func (l *Log) Full() bool {
+	return l.full.Load()
+}
+
+// sync flushes the file to disk according to the sync interval.
+func (l *Log) sync(file *os.File) error {
+	switch {
+	case l.syncEvery < 0:
+		return nil
+	case l.syncEvery > 0 && time.Since(l.lastSync[file]) < l.syncEvery:
+		return nil
+	}
+	if err := file.Sync(); err != nil {
+		return errors.Wrapf(err, "failed to sync %s", l.name)
+	}
+	l.lastSync[file] = time.Now()
+	return nil
+}
+
+// Close closes the files of the log.
+func (l *Log) Close() (err error) {
+	err = multierror.Append(err, l.file.Close()).ErrorOrNil()
+	return multierror.Append(err, l.offsetFile.Close()).ErrorOrNil()
+}
@@ -0,0 +1,80 @@
+package wal_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate-tools/internal/wal"
+)
+
+func TestLog_Recover(t *testing.T) {
+	dir := t.TempDir()
+	log, err := wal.Open(dir, "test", 0, -1)
+	require.NoError(t, err)
+
+	first, err := log.Append([]byte("a"))
+	require.NoError(t, err)
+	_, err = log.Append([]byte("b"))
+	require.NoError(t, err)
+	require.NoError(t, log.Commit(first))
+	require.NoError(t, log.Close())
+
+	// a partially written entry is discarded
+	file, err := os.OpenFile(filepath.Join(dir, "test.wal"), os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = file.Write([]byte{0, 0, 0, 9, 1})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	log, err = wal.Open(dir, "test", 0, -1)
+	require.NoError(t, err)
+	defer log.Close()
+	require.False(t, log.Drained())
+
+	e, err := log.Next()
+	require.NoError(t, err)
+	require.Equal(t, "b", string(e.Body))
+	require.Equal(t, e.End, first+int64(len(e.Body))+8)
+	e, err = log.Next()
+	require.NoError(t, err)
+	require.Nil(t, e)
+
+	log.Rewind()
+	e, err = log.Next()
+	require.NoError(t, err)
+	require.Equal(t, "b", string(e.Body))
+	require.NoError(t, log.Commit(e.End))
+	require.True(t, log.Drained())
+	require.Zero(t, log.Backlog())
+}
+
+func TestLog_Full(t *testing.T) {
+	log, err := wal.Open(t.TempDir(), "test", 12, -1)
+	require.NoError(t, err)
+	defer log.Close()
+
+	end, err := log.Append([]byte("abcd"))
+	require.NoError(t, err)
+	_, err = log.Append([]byte("e"))
+	require.Equal(t, wal.ErrFull, err)
+	require.True(t, log.Full())
+	require.Equal(t, int64(12), log.Backlog())
+
+	// The maximum size only applies to the entries that haven't been committed.
+	require.NoError(t, log.Commit(end))
+	require.False(t, log.Full())
+	end, err = log.Append([]byte("efgh"))
+	require.NoError(t, err)
+	require.Equal(t, int64(12), log.Backlog())
+	_, err = log.Append([]byte("i"))
+	require.Equal(t, wal.ErrFull, err)
+
+	require.NoError(t, log.Commit(end))
+	require.NoError(t, log.Reset())
+	require.False(t, log.Full())
+	_, err = log.Append([]byte("i"))
+	require.NoError(t, err)
+}
@@ -0,0 +1,250 @@
+// Package mem provides an in-memory message broker with substrate sink and source implementations,
+// intended for testing substrate pipelines without running an actual broker.
+package mem
+
+import (
+	"context"
+	"sync"
+)
+
+// BrokerOption is a function which sets a Broker configuration option.
+type BrokerOption func(b *Broker)
+
+// WithBufferSize sets the number of messages retained by each topic. Once the buffer is full, the
+// oldest messages are discarded, even if they haven't been consumed yet. The default value is 0 (unlimited).
+func WithBufferSize(size int) BrokerOption {
+	return func(b *Broker) {
+		b.bufferSize = size
+	}
+}
+
+// WithTopicBufferSize sets the number of messages retained by the given topic, overriding the broker default.
+func WithTopicBufferSize(topic string, size int) BrokerOption {
+	return func(b *Broker) {
+		b.topicBufferSizes[topic] = size
+	}
+}
+
+// Broker is an in-memory message broker. Each topic is an append only log of messages, that can be consumed
+// by any number of consumer groups. Each consumer group keeps track of the messages acknowledged, so that
+// a new source in the group resumes consumption after the last acknowledged message.
+type Broker struct {
+	mutex            sync.Mutex
+	topics           map[string]*topic
+	bufferSize       int
+	topicBufferSizes map[string]int
+}
+
+// NewBroker returns a new, empty, in-memory broker.
+func NewBroker(opts ...BrokerOption) *Broker {
+	b := &Broker{
+		topics:           make(map[string]*topic),
+		topicBufferSizes: make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Messages returns the payloads of all the messages currently retained by the topic.
+func (b *Broker) Messages(topicName string) [][]byte {
+	t := b.topic(topicName)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	payloads := make([][]byte, len(t.messages))
+	copy(payloads, t.messages)
+	return payloads
+}
+
+func (b *Broker) topic(name string) *topic {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	t, ok := b.topics[name]
+	if !ok {
+		size, ok := b.topicBufferSizes[name]
+		if !ok {
+			size = b.bufferSize
+		}
+		t = &topic{
+			name:       name,
+			bufferSize: size,
+			groups:     make(map[string]*group),
+			notify:     make(chan struct{}),
+		}
+		b.topics[name] = t
+	}
+	return t
+}
+
+type topic struct {
+	mutex      sync.Mutex
+	name       string
+	bufferSize int
+	first      int64
+	messages   [][]byte
+	groups     map[string]*group
+	notify     chan struct{}
+}
+
+type group struct {
+	next      int64
+	committed int64
+	acked     map[int64]bool
+	consumers int
+}
+
+// append adds the payload to the topic and notifies all consumers waiting for new messages.
+func (t *topic) append(payload []byte) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.messages = append(t.messages, payload)
+	if t.bufferSize > 0 && len(t.messages) > t.bufferSize {
+		t.messages[0] = nil
+		t.messages = t.messages[1:]
+		t.first++
+	}
+
+	close(t.notify)
+	t.notify = make(chan struct{})
+}
+
+// group returns the consumer group with the given name, creating it if it doesn't exist yet.
+// It must be called with the topic mutex held.
+func (t *topic) group(name string, fromNewest bool) *group {
+	g, ok := t.groups[name]
+	if !ok {
+		g = &group{acked: make(map[int64]bool)}
+		if fromNewest {
+			g.committed = t.first + int64(len(t.messages))
+		}
+		t.groups[name] = g
+	}
+	return g
+}
+
+// join registers a new consumer in the group. The first consumer to join restarts consumption from
+// the last acknowledged message.
+func (t *topic) join(name string, fromNewest bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	g := t.group(name, fromNewest)
+	if g.consumers == 0 {
+		g.next = g.committed
+		g.acked = make(map[int64]bool)
+	}
+	g.consumers++
+}
+
+func (t *topic) leave(name string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.groups[name].consumers--
+}
+
+// read blocks until there is a message for the consumer group to consume or the context is done.
+func (t *topic) read(ctx context.Context, name string) (*memMessage, bool) {
+	for {
+		t.mutex.Lock()
+		g := t.groups[name]
+		if g.next < t.first {
+			g.next = t.first
+		}
+		if g.committed < t.first {
+			g.committed = t.first
+		}
+		if end := t.first + int64(len(t.messages)); g.next < end {
+			msg := &memMessage{
+				topic:         t.name,
+				group:         name,
+				offset:        g.next,
+				highWaterMark: end,
+				data:          t.messages[g.next-t.first],
+			}
+			g.next++
+			t.mutex.Unlock()
+			return msg, true
+		}
+		notify := t.notify
+		t.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-notify:
+		}
+	}
+}
+
+// ack marks the message as acknowledged by the consumer group.
+func (t *topic) ack(name string, offset int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	g := t.groups[name]
+	if offset < g.committed {
+		// the message was delivered again after the consumer group was rewound
+		return
+	}
+	g.acked[offset] = true
+	for g.acked[g.committed] {
+		delete(g.acked, g.committed)
+		g.committed++
+	}
+}
+
+// rewind makes the consumer group consume messages again from the offset, which also becomes its committed
+// offset if it is earlier. Consumers waiting for new messages are notified.
+func (t *topic) rewind(name string, offset int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	g := t.groups[name]
+	if offset < t.first {
+		offset = t.first
+	}
+	g.next = offset
+	if offset < g.committed {
+		g.committed = offset
+	}
+	for acked := range g.acked {
+		if acked >= offset {
+			delete(g.acked, acked)
+		}
+	}
+
+	close(t.notify)
+	t.notify = make(chan struct{})
+}
+
+// memMessage is a message consumed from the in-memory broker. It implements the
+// instrumented.Offsetter interface.
+type memMessage struct {
+	topic         string
+	group         string
+	offset        int64
+	highWaterMark int64
+	data          []byte
+}
+
+func (msg *memMessage) Data() []byte {
+	return msg.data
+}
+
+func (msg *memMessage) Partition() string {
+	return "0"
+}
+
+func (msg *memMessage) Offset() int64 {
+	return msg.offset
+}
+
+func (msg *memMessage) HighWaterMark() int64 {
+	return msg.highWaterMark
+}
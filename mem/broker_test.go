@@ -0,0 +1,145 @@
+package mem_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+func publish(t *testing.T, broker *mem.Broker, topic string, payloads ...string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	sink := broker.NewAsyncMessageSink(topic)
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	for _, payload := range payloads {
+		msg := message.FromString(payload)
+		messages <- msg
+		require.Equal(t, msg, <-acks)
+	}
+	cancel()
+	require.NoError(t, <-errs)
+	require.NoError(t, sink.Close())
+}
+
+func consume(t *testing.T, source substrate.AsyncMessageSource, count, toAck int) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	var consumed []string
+	for i := 0; i < count; i++ {
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "failed to consume all messages")
+		case msg := <-messages:
+			consumed = append(consumed, string(msg.Data()))
+			if i < toAck {
+				acks <- msg
+			}
+		}
+	}
+	require.NoError(t, source.Close())
+	require.NoError(t, <-errs)
+
+	return consumed
+}
+
+func TestBroker_ConsumerGroups(t *testing.T) {
+	broker := mem.NewBroker()
+	publish(t, broker, "topic", "1", "2", "3")
+
+	require.Equal(t, []string{"1", "2", "3"}, consume(t, broker.NewAsyncMessageSource("topic", "group-a"), 3, 3))
+	require.Equal(t, []string{"1", "2", "3"}, consume(t, broker.NewAsyncMessageSource("topic", "group-b"), 3, 1))
+
+	publish(t, broker, "topic", "4")
+
+	require.Equal(t, []string{"4"}, consume(t, broker.NewAsyncMessageSource("topic", "group-a"), 1, 1))
+	require.Equal(t, []string{"2", "3", "4"}, consume(t, broker.NewAsyncMessageSource("topic", "group-b"), 3, 3))
+}
+
+func TestBroker_FromNewest(t *testing.T) {
+	broker := mem.NewBroker()
+	publish(t, broker, "topic", "1", "2")
+
+	source := broker.NewAsyncMessageSource("topic", "group", mem.FromNewest())
+	publish(t, broker, "topic", "3")
+
+	require.Equal(t, []string{"3"}, consume(t, source, 1, 1))
+}
+
+func TestBroker_BufferSize(t *testing.T) {
+	broker := mem.NewBroker(mem.WithBufferSize(5), mem.WithTopicBufferSize("small", 2))
+	publish(t, broker, "topic", "1", "2", "3")
+	publish(t, broker, "small", "1", "2", "3")
+
+	require.Len(t, broker.Messages("topic"), 3)
+	require.Equal(t, []string{"2", "3"}, consume(t, broker.NewAsyncMessageSource("small", "group"), 2, 2))
+}
+
+func TestBroker_ConsumeAgain(t *testing.T) {
+	broker := mem.NewBroker()
+	publish(t, broker, "topic", "1", "2")
+
+	source := broker.NewAsyncMessageSource("topic", "group")
+	for _, payload := range []string{"1", "2"} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+		errs := make(chan error, 1)
+		go func() {
+			errs <- source.ConsumeMessages(ctx, messages, acks)
+		}()
+
+		msg := <-messages
+		require.Equal(t, payload, string(msg.Data()))
+		acks <- msg
+		cancel()
+		require.NoError(t, <-errs)
+	}
+	require.NoError(t, source.Close())
+}
+
+func TestBroker_ClosedSource(t *testing.T) {
+	broker := mem.NewBroker()
+	source := broker.NewAsyncMessageSource("topic", "group")
+	require.NoError(t, source.Close())
+
+	status, err := source.Status()
+	require.NoError(t, err)
+	require.False(t, status.Working)
+	require.Error(t, source.ConsumeMessages(context.Background(), make(chan substrate.Message), make(chan substrate.Message)))
+}
+
+func TestBroker_Rewind(t *testing.T) {
+	broker := mem.NewBroker()
+	publish(t, broker, "topic", "1", "2", "3")
+
+	source := broker.NewAsyncMessageSource("topic", "group")
+	require.Equal(t, []string{"1", "2", "3"}, consume(t, source, 3, 3))
+
+	// Rewinding also moves the committed offset of the group back, so a new source replays from it.
+	rewinder := broker.NewAsyncMessageSource("topic", "group").(interface {
+		Rewind(ctx context.Context, partition string, offset int64) error
+	})
+	require.EqualError(t, rewinder.Rewind(context.Background(), "1", 0), `unknown partition "1"`)
+	require.NoError(t, rewinder.Rewind(context.Background(), "0", 1))
+	require.Equal(t, []string{"2", "3"}, consume(t, broker.NewAsyncMessageSource("topic", "group"), 2, 2))
+}
@@ -0,0 +1,73 @@
+package mem
+
+import (
+	"context"
+	"sync"
+
+	"github.com/uw-labs/substrate"
+)
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that appends messages to the
+// given topic of the broker. Messages are acknowledged as soon as they have been appended.
+func (b *Broker) NewAsyncMessageSink(topicName string) substrate.AsyncMessageSink {
+	return &memSink{
+		topic: b.topic(topicName),
+	}
+}
+
+type memSink struct {
+	topic *topic
+
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// PublishMessages appends all the messages received to the topic and acknowledges them.
+func (s *memSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-messages:
+			if s.isClosed() {
+				return substrate.ErrSinkAlreadyClosed
+			}
+			payload := make([]byte, len(msg.Data()))
+			copy(payload, msg.Data())
+			s.topic.append(payload)
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case acks <- msg:
+			}
+		}
+	}
+}
+
+func (s *memSink) isClosed() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.closed
+}
+
+// Close closes the sink, any further attempts to publish messages will fail.
+func (s *memSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+	return nil
+}
+
+// Status returns the status of the sink. It will report not working after the sink was closed.
+func (s *memSink) Status() (*substrate.Status, error) {
+	if !s.isClosed() {
+		return &substrate.Status{Working: true}, nil
+	}
+	return &substrate.Status{
+		Working:  false,
+		Problems: []string{"sink already closed"},
+	}, nil
+}
@@ -0,0 +1,160 @@
+package mem
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// AsyncMessageSourceOption is a function which sets an AsyncMessageSource configuration option.
+type AsyncMessageSourceOption func(s *memSource)
+
+// FromNewest makes a new consumer group start consuming messages published after its first source
+// was created, rather than replaying the topic from the beginning. It has no effect for consumer groups
+// that already exist.
+func FromNewest() AsyncMessageSourceOption {
+	return func(s *memSource) {
+		s.fromNewest = true
+	}
+}
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that consumes messages from
+// the given topic of the broker as part of the given consumer group. Messages are distributed between all
+// sources of the consumer group that are consuming at the same time. By default, a new consumer group replays
+// the topic from the beginning.
+func (b *Broker) NewAsyncMessageSource(topicName, consumerGroup string, opts ...AsyncMessageSourceOption) substrate.AsyncMessageSource {
+	s := &memSource{
+		topic: b.topic(topicName),
+		group: consumerGroup,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.topic.mutex.Lock()
+	s.topic.group(consumerGroup, s.fromNewest)
+	s.topic.mutex.Unlock()
+
+	return s
+}
+
+type memSource struct {
+	topic      *topic
+	group      string
+	fromNewest bool
+
+	mutex  sync.RWMutex
+	cancel func()
+	closed bool
+}
+
+// ConsumeMessages consumes messages from the topic until the context is cancelled or the source is closed.
+// It may be called again once it returned, until the source is closed.
+// Acknowledgements may be sent in any order, but only messages acknowledged in sequence are committed for
+// the consumer group.
+func (s *memSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	ctx, err := s.init(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.release()
+
+	s.topic.join(s.group, s.fromNewest)
+	defer s.topic.leave(s.group)
+
+	rg, ctx := rungroup.New(ctx)
+	rg.Go(func() error {
+		for {
+			msg, ok := s.topic.read(ctx, s.group)
+			if !ok {
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case messages <- msg:
+			}
+		}
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				msg, ok := ack.(*memMessage)
+				if !ok || msg.topic != s.topic.name || msg.group != s.group {
+					return errors.Errorf("unexpected message acknowledged: %v", ack)
+				}
+				s.topic.ack(s.group, msg.offset)
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+// Rewind makes the consumer group of the source consume messages again from the offset, after the messages that
+// were already delivered. The topic only has one partition, "0". Rewinding before the oldest message retained by
+// the topic replays it from that message.
+func (s *memSource) Rewind(ctx context.Context, partition string, offset int64) error {
+	if partition != "0" {
+		return errors.Errorf("unknown partition %q", partition)
+	}
+	s.topic.rewind(s.group, offset)
+	return nil
+}
+
+func (s *memSource) init(ctx context.Context) (context.Context, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil, errors.New("source already closed")
+	}
+	if s.cancel != nil {
+		return nil, errors.New("source already in use")
+	}
+	ctx, s.cancel = context.WithCancel(ctx)
+
+	return ctx, nil
+}
+
+// release makes the source available to another call to ConsumeMessages.
+func (s *memSource) release() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.cancel()
+	s.cancel = nil
+}
+
+// Close closes the source, causing any call to ConsumeMessages to return.
+func (s *memSource) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+	if s.cancel != nil {
+		s.cancel()
+	}
+	return nil
+}
+
+// Status returns the status of the source. It will report not working after the source was closed.
+func (s *memSource) Status() (*substrate.Status, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if !s.closed {
+		return &substrate.Status{Working: true}, nil
+	}
+	return &substrate.Status{
+		Working:  false,
+		Problems: []string{"source already closed"},
+	}, nil
+}
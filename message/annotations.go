@@ -0,0 +1,172 @@
+package message
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// AnnotatedMessage is a message carrying annotations, metadata attached to it by wrappers, e.g. for tracing, retries
+// or routing, while it passes through them. Unlike headers, annotations aren't part of the published message, so
+// attaching them doesn't require re-encoding the payload. Wrappers that need to read annotations should check for
+// this interface using a checked type assertion.
+type AnnotatedMessage interface {
+	substrate.Message
+	// Annotations returns the annotations of the message, which must not be modified.
+	Annotations() map[string][]byte
+}
+
+// Annotate returns a message with the annotation added to the annotations of the message, replacing an annotation
+// with the same key. The returned message delegates to the annotated one, reporting its payload, headers, content
+// type and envelope and discarding its payload, so that it passes through wrappers and sinks that don't understand annotations.
+// Annotating a message returned by Annotate again doesn't wrap it twice.
+func Annotate(msg substrate.Message, key string, value []byte) AnnotatedMessage {
+	annotations := Annotations(msg)
+	annotations[key] = value
+	return &annotatedMessage{msg: Unannotated(msg), annotations: annotations}
+}
+
+// Annotations returns a copy of the annotations carried by the message, or an empty map if the message doesn't
+// implement AnnotatedMessage.
+func Annotations(msg substrate.Message) map[string][]byte {
+	annotations := make(map[string][]byte)
+	if aMsg, ok := msg.(AnnotatedMessage); ok {
+		for k, v := range aMsg.Annotations() {
+			annotations[k] = v
+		}
+	}
+	return annotations
+}
+
+// Annotation returns the value of the annotation of the message with the key, and whether the message has it.
+func Annotation(msg substrate.Message, key string) ([]byte, bool) {
+	if aMsg, ok := msg.(AnnotatedMessage); ok {
+		value, ok := aMsg.Annotations()[key]
+		return value, ok
+	}
+	return nil, false
+}
+
+// Unannotated returns the message annotated using Annotate, or the message itself if it wasn't returned by Annotate.
+func Unannotated(msg substrate.Message) substrate.Message {
+	if aMsg, ok := msg.(*annotatedMessage); ok {
+		return aMsg.msg
+	}
+	return msg
+}
+
+type annotatedMessage struct {
+	msg         substrate.Message
+	annotations map[string][]byte
+}
+
+func (msg *annotatedMessage) Data() []byte {
+	return msg.msg.Data()
+}
+
+func (msg *annotatedMessage) Headers() map[string]string {
+	return Headers(msg.msg)
+}
+
+func (msg *annotatedMessage) ContentType() string {
+	return ContentType(msg.msg)
+}
+
+func (msg *annotatedMessage) CarriedEnvelope() (Envelope, bool) {
+	return EnvelopeOf(msg.msg)
+}
+
+func (msg *annotatedMessage) DiscardPayload() {
+	if dMsg, ok := msg.msg.(substrate.DiscardableMessage); ok {
+		dMsg.DiscardPayload()
+	}
+}
+
+func (msg *annotatedMessage) Annotations() map[string][]byte {
+	return msg.annotations
+}
+
+// NewUnannotatingSink returns an instance of substrate.AsyncMessageSink that publishes the messages annotated using
+// Annotate to the sink without their annotations, for sinks relying on the concrete type of the messages published to
+// them, and acknowledges the annotated messages once the sink acknowledged them. Other messages are published as they
+// are. The sink must acknowledge messages in the order in which they were published, publishing fails otherwise.
+func NewUnannotatingSink(sink substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+	return &unannotatingSink{sink: sink}
+}
+
+type unannotatingSink struct {
+	sink substrate.AsyncMessageSink
+}
+
+func (s *unannotatingSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "annotations"})
+	rg, ctx := rungroup.New(ctx)
+
+	toPublish := make(chan substrate.Message, cap(messages))
+	published := make(chan substrate.Message, cap(acks))
+	// inFlight holds the messages published to the sink, in order, until it acknowledges them.
+	var (
+		mutex    sync.Mutex
+		inFlight []substrate.Message
+	)
+
+	rg.Go(func() error {
+		return s.sink.PublishMessages(ctx, published, toPublish)
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-messages:
+				emit.Publish(msg)
+				mutex.Lock()
+				inFlight = append(inFlight, msg)
+				mutex.Unlock()
+				select {
+				case <-ctx.Done():
+					return nil
+				case toPublish <- Unannotated(msg):
+				}
+			}
+		}
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-published:
+				mutex.Lock()
+				if len(inFlight) == 0 || Unannotated(inFlight[0]) != ack {
+					mutex.Unlock()
+					return errors.Errorf("unexpected message acknowledged: %v", ack)
+				}
+				msg := inFlight[0]
+				inFlight = inFlight[1:]
+				mutex.Unlock()
+				emit.Ack(msg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case acks <- msg:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+func (s *unannotatingSink) Close() error {
+	return s.sink.Close()
+}
+
+func (s *unannotatingSink) Status() (*substrate.Status, error) {
+	return s.sink.Status()
+}
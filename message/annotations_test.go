@@ -0,0 +1,122 @@
+package message_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+func TestAnnotate(t *testing.T) {
+	original := &headeredMessage{Message: message.FromString("payload"), headers: map[string]string{"key": "value"}}
+
+	annotated := message.Annotate(original, "retries", []byte("1"))
+	annotated = message.Annotate(annotated, "route", []byte("eu"))
+	annotated = message.Annotate(annotated, "retries", []byte("2"))
+
+	require.Equal(t, map[string][]byte{"retries": []byte("2"), "route": []byte("eu")}, annotated.Annotations())
+	value, ok := message.Annotation(annotated, "route")
+	require.True(t, ok)
+	require.Equal(t, []byte("eu"), value)
+	_, ok = message.Annotation(annotated, "unknown")
+	require.False(t, ok)
+
+	// The annotated message behaves like the original one, which is only wrapped once.
+	require.Equal(t, "payload", string(annotated.Data()))
+	require.Equal(t, map[string]string{"key": "value"}, message.Headers(annotated))
+	require.Equal(t, substrate.Message(original), message.Unannotated(annotated))
+	annotated.(substrate.DiscardableMessage).DiscardPayload()
+	require.Nil(t, original.Data())
+
+	// Messages that weren't annotated have no annotations.
+	plain := message.FromString("plain")
+	require.Empty(t, message.Annotations(plain))
+	_, ok = message.Annotation(plain, "retries")
+	require.False(t, ok)
+	require.Equal(t, substrate.Message(plain), message.Unannotated(plain))
+}
+
+func TestAnnotate_Envelope(t *testing.T) {
+	enveloped := message.NewEnvelopedMessage(message.Envelope{ID: "id", ContentType: "text/plain", Payload: []byte("1")})
+
+	envelope, ok := message.EnvelopeOf(message.Annotate(enveloped, "route", []byte("eu")))
+	require.True(t, ok)
+	require.Equal(t, enveloped.Envelope, envelope)
+}
+
+func TestUnannotatingSink(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	original := message.FromString("1")
+	// The messages received by the backend are recorded as they are.
+	var received []substrate.Message
+	backend := mock.NewSink().AckWith(func(_ int, msg substrate.Message) bool {
+		received = append(received, msg)
+		return true
+	})
+	sink := message.NewUnannotatingSink(backend)
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	annotated := message.Annotate(original, "route", []byte("eu"))
+	plain := message.FromString("2")
+	messages <- annotated
+	require.Equal(t, substrate.Message(annotated), <-acks)
+	messages <- plain
+	require.Equal(t, substrate.Message(plain), <-acks)
+
+	// The sink only receives the original messages.
+	require.Equal(t, []substrate.Message{original, plain}, received)
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+// reorderingSink acknowledges the messages published to it in reverse order, once it received two of them.
+type reorderingSink struct{}
+
+func (reorderingSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	first, second := <-messages, <-messages
+	for _, msg := range []substrate.Message{second, first} {
+		select {
+		case <-ctx.Done():
+			return nil
+		case acks <- msg:
+		}
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (reorderingSink) Close() error {
+	return nil
+}
+
+func (reorderingSink) Status() (*substrate.Status, error) {
+	return &substrate.Status{Working: true}, nil
+}
+
+func TestUnannotatingSink_UnexpectedAck(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	sink := message.NewUnannotatingSink(reorderingSink{})
+
+	messages := make(chan substrate.Message, 2)
+	messages <- message.Annotate(message.FromString("1"), "route", []byte("eu"))
+	messages <- message.FromString("2")
+
+	err := sink.PublishMessages(ctx, make(chan substrate.Message), messages)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unexpected message acknowledged")
+}
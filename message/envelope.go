@@ -0,0 +1,321 @@
+package message
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// Envelope is the format in which messages are published by the envelope sink. It carries the payload
+// of the message along with its metadata.
+type Envelope struct {
+	// ID uniquely identifies the message.
+	ID string `json:"id"`
+	// Timestamp is the time at which the message was published.
+	Timestamp time.Time `json:"timestamp"`
+	// ContentType describes the format of the payload.
+	ContentType string `json:"content_type,omitempty"`
+	// Headers are arbitrary key value pairs, such as the trace context.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Payload is the payload of the message.
+	Payload []byte `json:"payload"`
+}
+
+// EnvelopedMessage is a message carrying the metadata of an envelope. Messages consumed from the envelope
+// source are of this type, while messages of this type published to the envelope sink will have their
+// metadata preserved.
+type EnvelopedMessage struct {
+	Envelope
+
+	msg substrate.Message
+}
+
+// EnvelopeCarrier is implemented by messages carrying the metadata of an envelope, such as EnvelopedMessage and the
+// messages of wrappers delivering or publishing the messages they wrap, so that the envelope of a message is kept
+// while it passes through wrappers. Use EnvelopeOf rather than asserting on the concrete type of a message.
+type EnvelopeCarrier interface {
+	substrate.Message
+	// CarriedEnvelope returns the envelope carried by the message, and false if it doesn't carry one.
+	CarriedEnvelope() (Envelope, bool)
+}
+
+// EnvelopeOf returns the envelope carried by the message, with the payload of the message, and whether the message
+// carries one. The returned envelope only holds the payload if the message doesn't carry one.
+func EnvelopeOf(msg substrate.Message) (Envelope, bool) {
+	var (
+		envelope Envelope
+		ok       bool
+	)
+	if eMsg, isCarrier := msg.(EnvelopeCarrier); isCarrier {
+		envelope, ok = eMsg.CarriedEnvelope()
+	}
+	envelope.Payload = msg.Data()
+	return envelope, ok
+}
+
+// NewEnvelopedMessage returns a new message with the provided envelope.
+func NewEnvelopedMessage(envelope Envelope) *EnvelopedMessage {
+	return &EnvelopedMessage{Envelope: envelope}
+}
+
+// Data returns the payload.
+func (msg *EnvelopedMessage) Data() []byte {
+	return msg.Payload
+}
+
+// Headers returns the headers of the envelope.
+func (msg *EnvelopedMessage) Headers() map[string]string {
+	return msg.Envelope.Headers
+}
+
+// CarriedEnvelope returns the envelope of the message.
+func (msg *EnvelopedMessage) CarriedEnvelope() (Envelope, bool) {
+	return msg.Envelope, true
+}
+
+// DiscardPayload discards the payload.
+func (msg *EnvelopedMessage) DiscardPayload() {
+	msg.Payload = nil
+	if dMsg, ok := msg.msg.(substrate.DiscardableMessage); ok {
+		dMsg.DiscardPayload()
+	}
+}
+
+// EnvelopeSinkOption is a function which sets an envelope sink configuration option.
+type EnvelopeSinkOption func(s *envelopeSink)
+
+// WithContentType sets the content type of envelopes which don't specify one, and whose messages don't implement
+// ContentTypedMessage.
+func WithContentType(contentType string) EnvelopeSinkOption {
+	return func(s *envelopeSink) {
+		s.contentType = contentType
+	}
+}
+
+// NewEnvelopeSink returns an instance of substrate.AsyncMessageSink that wraps the payload of each message
+// in an envelope, encoded as JSON, before publishing it. The headers of messages implementing HeaderedMessage
+// are recorded in the envelope. Messages of type EnvelopedMessage keep their metadata, missing IDs and timestamps
+// are generated.
+func NewEnvelopeSink(sink substrate.AsyncMessageSink, opts ...EnvelopeSinkOption) substrate.AsyncMessageSink {
+	s := &envelopeSink{
+		sink: sink,
+		now:  time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type envelopeSink struct {
+	sink        substrate.AsyncMessageSink
+	contentType string
+	now         func() time.Time
+}
+
+func (s *envelopeSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "envelope"})
+	rg, ctx := rungroup.New(ctx)
+
+	toPublish := make(chan substrate.Message, cap(messages))
+	published := make(chan substrate.Message, cap(acks))
+
+	rg.Go(func() error {
+		return s.sink.PublishMessages(ctx, published, toPublish)
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-messages:
+				emit.Publish(msg)
+				eMsg, err := s.wrap(msg)
+				if err != nil {
+					return err
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case toPublish <- eMsg:
+				}
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-published:
+				eMsg, ok := ack.(*envelopeMessage)
+				if !ok {
+					return errors.Errorf("unexpected message type: %T", ack)
+				}
+				emit.Ack(eMsg.msg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case acks <- eMsg.msg:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+func (s *envelopeSink) wrap(msg substrate.Message) (*envelopeMessage, error) {
+	envelope, _ := EnvelopeOf(msg)
+	if headers := Headers(msg); len(headers) > 0 {
+		envelope.Headers = headers
+	}
+	if envelope.ID == "" {
+		id, err := newID()
+		if err != nil {
+			return nil, err
+		}
+		envelope.ID = id
+	}
+	if envelope.Timestamp.IsZero() {
+		envelope.Timestamp = s.now().UTC()
+	}
+	if envelope.ContentType == "" {
+		envelope.ContentType = ContentType(msg)
+	}
+	if envelope.ContentType == "" {
+		envelope.ContentType = s.contentType
+	}
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode envelope")
+	}
+	return &envelopeMessage{data: data, msg: msg}, nil
+}
+
+func (s *envelopeSink) Close() error {
+	return s.sink.Close()
+}
+
+func (s *envelopeSink) Status() (*substrate.Status, error) {
+	return s.sink.Status()
+}
+
+// NewEnvelopeSource returns an instance of substrate.AsyncMessageSource that unwraps the payload of messages
+// published by the envelope sink. Consumed messages are of type EnvelopedMessage, giving access to the metadata
+// of the envelope. Consumption fails if a message is not a valid envelope.
+func NewEnvelopeSource(source substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+	return &envelopeSource{
+		source: source,
+	}
+}
+
+type envelopeSource struct {
+	source substrate.AsyncMessageSource
+}
+
+func (s *envelopeSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "envelope"})
+	rg, ctx := rungroup.New(ctx)
+
+	consumed := make(chan substrate.Message, cap(messages))
+	toAck := make(chan substrate.Message, cap(acks))
+
+	rg.Go(emit.Errors(func() error {
+		return s.source.ConsumeMessages(ctx, consumed, toAck)
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-consumed:
+				eMsg := &EnvelopedMessage{msg: msg}
+				if err := json.Unmarshal(msg.Data(), &eMsg.Envelope); err != nil {
+					return errors.Wrap(err, "failed to decode envelope")
+				}
+				emit.Publish(eMsg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case messages <- eMsg:
+				}
+			}
+		}
+	}))
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				eMsg, ok := ack.(*EnvelopedMessage)
+				if !ok || eMsg.msg == nil {
+					return errors.Errorf("unexpected message acknowledged: %v", ack)
+				}
+				emit.Ack(ack)
+				select {
+				case <-ctx.Done():
+					return nil
+				case toAck <- eMsg.msg:
+				}
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+func (s *envelopeSource) Close() error {
+	return s.source.Close()
+}
+
+func (s *envelopeSource) Status() (*substrate.Status, error) {
+	return s.source.Status()
+}
+
+// envelopeMessage is an encoded envelope published on behalf of the original message.
+type envelopeMessage struct {
+	data []byte
+	msg  substrate.Message
+}
+
+func (msg *envelopeMessage) Data() []byte {
+	return msg.data
+}
+
+func newID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", errors.Wrap(err, "failed to generate message ID")
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// ContentTypedMessage is implemented by messages describing the format of their payload, which the envelope sink
+// records as the content type of their envelopes.
+type ContentTypedMessage interface {
+	substrate.Message
+	ContentType() string
+}
+
+// ContentType returns the content type of the message, which is the one it reports if it implements
+// ContentTypedMessage, or the content type of its envelope if it carries one. It returns an empty string otherwise.
+func ContentType(msg substrate.Message) string {
+	if cMsg, ok := msg.(ContentTypedMessage); ok {
+		return cMsg.ContentType()
+	}
+	if envelope, ok := EnvelopeOf(msg); ok {
+		return envelope.ContentType
+	}
+	return ""
+}
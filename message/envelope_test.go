@@ -0,0 +1,130 @@
+package message_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+type headeredMessage struct {
+	*message.Message
+	headers map[string]string
+}
+
+func (m *headeredMessage) Headers() map[string]string {
+	return m.headers
+}
+
+// wrappedMessage is a message of a wrapper forwarding the envelope of the message it wraps.
+type wrappedMessage struct {
+	msg substrate.Message
+}
+
+func (m *wrappedMessage) Data() []byte {
+	return m.msg.Data()
+}
+
+func (m *wrappedMessage) CarriedEnvelope() (message.Envelope, bool) {
+	return message.EnvelopeOf(m.msg)
+}
+
+func TestEnvelope(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	sink := message.NewEnvelopeSink(broker.NewAsyncMessageSink("topic"), message.WithContentType("text/plain"))
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	go sink.PublishMessages(ctx, acks, messages)
+
+	published := []substrate.Message{
+		&headeredMessage{Message: message.FromString("1"), headers: map[string]string{"traceparent": "trace"}},
+		message.NewEnvelopedMessage(message.Envelope{ID: "id-2", ContentType: "application/json", Payload: []byte("{}")}),
+	}
+	for _, msg := range published {
+		messages <- msg
+		require.Equal(t, msg, <-acks)
+	}
+
+	var raw message.Envelope
+	require.NoError(t, json.Unmarshal(broker.Messages("topic")[0], &raw))
+	require.Equal(t, []byte("1"), raw.Payload)
+
+	source := message.NewEnvelopeSource(broker.NewAsyncMessageSource("topic", "group"))
+	consumed, sourceAcks := make(chan substrate.Message), make(chan substrate.Message)
+	go source.ConsumeMessages(ctx, consumed, sourceAcks)
+
+	first := (<-consumed).(*message.EnvelopedMessage)
+	require.Equal(t, "1", string(first.Data()))
+	require.NotEmpty(t, first.ID)
+	require.False(t, first.Timestamp.IsZero())
+	require.Equal(t, "text/plain", first.ContentType)
+	require.Equal(t, map[string]string{"traceparent": "trace"}, message.Headers(first))
+	sourceAcks <- first
+
+	second := (<-consumed).(*message.EnvelopedMessage)
+	require.Equal(t, "{}", string(second.Data()))
+	require.Equal(t, "id-2", second.ID)
+	require.Equal(t, "application/json", second.ContentType)
+	sourceAcks <- second
+}
+
+func TestEnvelopeOf(t *testing.T) {
+	timestamp := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	enveloped := message.NewEnvelopedMessage(message.Envelope{ID: "id", Timestamp: timestamp, ContentType: "text/plain", Payload: []byte("1")})
+
+	envelope, ok := message.EnvelopeOf(&wrappedMessage{msg: enveloped})
+	require.True(t, ok)
+	require.Equal(t, enveloped.Envelope, envelope)
+	require.Equal(t, "text/plain", message.ContentType(&wrappedMessage{msg: enveloped}))
+
+	envelope, ok = message.EnvelopeOf(&wrappedMessage{msg: message.FromString("2")})
+	require.False(t, ok)
+	require.Equal(t, message.Envelope{Payload: []byte("2")}, envelope)
+}
+
+func TestEnvelopeSink_WrappedMessage(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	sink := message.NewEnvelopeSink(broker.NewAsyncMessageSink("topic"))
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	go sink.PublishMessages(ctx, acks, messages)
+
+	timestamp := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	msg := &wrappedMessage{msg: message.NewEnvelopedMessage(message.Envelope{ID: "id", Timestamp: timestamp, Payload: []byte("1")})}
+	messages <- msg
+	require.Equal(t, msg, <-acks)
+
+	var raw message.Envelope
+	require.NoError(t, json.Unmarshal(broker.Messages("topic")[0], &raw))
+	require.Equal(t, "id", raw.ID)
+	require.True(t, timestamp.Equal(raw.Timestamp))
+	require.Equal(t, []byte("1"), raw.Payload)
+}
+
+func TestEnvelopeSource_InvalidEnvelope(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	rawSink := broker.NewAsyncMessageSink("topic")
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	go rawSink.PublishMessages(ctx, acks, messages)
+	messages <- message.FromString("not an envelope")
+	<-acks
+
+	source := message.NewEnvelopeSource(broker.NewAsyncMessageSource("topic", "group"))
+	err := source.ConsumeMessages(ctx, make(chan substrate.Message), make(chan substrate.Message))
+	require.Error(t, err)
+}
@@ -0,0 +1,25 @@
+package message
+
+import (
+	"github.com/uw-labs/substrate"
+)
+
+// HeaderedMessage is a message that carries a set of string headers alongside its payload.
+// Wrappers that need to pass metadata along with a message, such as trace context, should
+// check for this interface using a checked type assertion.
+type HeaderedMessage interface {
+	substrate.Message
+	Headers() map[string]string
+}
+
+// Headers returns a copy of the headers carried by the message, or an empty map if the message
+// doesn't implement HeaderedMessage.
+func Headers(msg substrate.Message) map[string]string {
+	headers := make(map[string]string)
+	if hMsg, ok := msg.(HeaderedMessage); ok {
+		for k, v := range hMsg.Headers() {
+			headers[k] = v
+		}
+	}
+	return headers
+}
@@ -0,0 +1,165 @@
+// Package metadata attaches the standard metadata of consumed messages, such as their ID, correlation ID, trace
+// headers and tenant, to the context of message handlers, so that business code can read it using typed accessors
+// instead of parsing envelopes and headers by hand.
+package metadata
+
+import (
+	"context"
+	"time"
+
+	"github.com/uw-labs/substrate"
+
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+const (
+	// CorrelationIDHeader is the default header carrying the correlation ID of a message.
+	CorrelationIDHeader = "correlation-id"
+	// TenantHeader is the default header carrying the tenant a message belongs to.
+	TenantHeader = "tenant"
+	// TraceParentHeader is the header carrying the W3C trace context, as propagated by the traced package.
+	TraceParentHeader = "traceparent"
+	// TraceStateHeader is the header carrying the vendor specific W3C trace state.
+	TraceStateHeader = "tracestate"
+)
+
+// Metadata is the metadata of a consumed message.
+type Metadata struct {
+	// MessageID is the ID of the message envelope, if the message was consumed from an envelope source.
+	MessageID string
+	// CorrelationID identifies the flow of messages the message belongs to. It defaults to the message ID if the
+	// message doesn't carry one, so that the messages a handler publishes can be correlated with it.
+	CorrelationID string
+	// Tenant is the tenant the message belongs to.
+	Tenant string
+	// Timestamp is the time at which the message was published, if the message was consumed from an envelope
+	// source.
+	Timestamp time.Time
+	// Headers are all the headers of the message, including the trace headers.
+	Headers map[string]string
+}
+
+// Option is a function which sets a metadata extraction option.
+type Option func(e *extractor)
+
+// WithCorrelationIDHeader sets the header carrying the correlation ID. The default is CorrelationIDHeader.
+func WithCorrelationIDHeader(header string) Option {
+	return func(e *extractor) {
+		e.correlationIDHeader = header
+	}
+}
+
+// WithTenantHeader sets the header carrying the tenant. The default is TenantHeader.
+func WithTenantHeader(header string) Option {
+	return func(e *extractor) {
+		e.tenantHeader = header
+	}
+}
+
+type extractor struct {
+	correlationIDHeader string
+	tenantHeader        string
+}
+
+func newExtractor(opts []Option) *extractor {
+	e := &extractor{
+		correlationIDHeader: CorrelationIDHeader,
+		tenantHeader:        TenantHeader,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Extract returns the metadata of the message, taken from its envelope, if it carries one, and
+// from its headers. Messages delivered by the source of this package carry the metadata they were consumed with.
+func Extract(msg substrate.Message, opts ...Option) Metadata {
+	if mMsg, ok := msg.(*metadataMessage); ok {
+		return mMsg.metadata
+	}
+	return newExtractor(opts).extract(msg)
+}
+
+func (e *extractor) extract(msg substrate.Message) Metadata {
+	md := Metadata{Headers: message.Headers(msg)}
+	if envelope, ok := message.EnvelopeOf(msg); ok {
+		md.MessageID, md.Timestamp = envelope.ID, envelope.Timestamp
+	}
+	md.CorrelationID = md.Headers[e.correlationIDHeader]
+	if md.CorrelationID == "" {
+		md.CorrelationID = md.MessageID
+	}
+	md.Tenant = md.Headers[e.tenantHeader]
+	return md
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the metadata.
+func NewContext(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, contextKey{}, md)
+}
+
+// FromContext returns the metadata carried by the context, and whether there was any.
+func FromContext(ctx context.Context) (Metadata, bool) {
+	md, ok := ctx.Value(contextKey{}).(Metadata)
+	return md, ok
+}
+
+// MessageID returns the ID of the message whose metadata is carried by the context, or an empty string.
+func MessageID(ctx context.Context) string {
+	md, _ := FromContext(ctx)
+	return md.MessageID
+}
+
+// CorrelationID returns the correlation ID of the message whose metadata is carried by the context, or an empty
+// string.
+func CorrelationID(ctx context.Context) string {
+	md, _ := FromContext(ctx)
+	return md.CorrelationID
+}
+
+// Tenant returns the tenant of the message whose metadata is carried by the context, or an empty string.
+func Tenant(ctx context.Context) string {
+	md, _ := FromContext(ctx)
+	return md.Tenant
+}
+
+// Timestamp returns the publishing time of the message whose metadata is carried by the context, or the zero time.
+func Timestamp(ctx context.Context) time.Time {
+	md, _ := FromContext(ctx)
+	return md.Timestamp
+}
+
+// TraceParent returns the W3C trace context of the message whose metadata is carried by the context, or an empty
+// string.
+func TraceParent(ctx context.Context) string {
+	return Header(ctx, TraceParentHeader)
+}
+
+// Header returns the header of the message whose metadata is carried by the context, or an empty string.
+func Header(ctx context.Context, name string) string {
+	md, _ := FromContext(ctx)
+	return md.Headers[name]
+}
+
+// Handler returns a handler calling the provided one with a context carrying the metadata of the message, see
+// Extract. The options only apply to messages that weren't delivered by the source of this package.
+func Handler(handler substrate.ConsumerMessageHandler, opts ...Option) substrate.ConsumerMessageHandler {
+	e := newExtractor(opts)
+	return func(ctx context.Context, msg substrate.Message) error {
+		md, ok := carried(msg)
+		if !ok {
+			md = e.extract(msg)
+		}
+		return handler(NewContext(ctx, md), msg)
+	}
+}
+
+func carried(msg substrate.Message) (Metadata, bool) {
+	if mMsg, ok := msg.(*metadataMessage); ok {
+		return mMsg.metadata, true
+	}
+	return Metadata{}, false
+}
@@ -0,0 +1,117 @@
+package metadata_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/conformance"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/metadata"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+func TestExtract(t *testing.T) {
+	timestamp := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	msg := message.NewEnvelopedMessage(message.Envelope{
+		ID:        "id-1",
+		Timestamp: timestamp,
+		Headers: map[string]string{
+			metadata.TenantHeader:      "acme",
+			metadata.TraceParentHeader: "00-trace-span-01",
+		},
+		Payload: []byte("payload"),
+	})
+
+	md := metadata.Extract(msg)
+	require.Equal(t, "id-1", md.MessageID)
+	// The correlation ID defaults to the message ID.
+	require.Equal(t, "id-1", md.CorrelationID)
+	require.Equal(t, "acme", md.Tenant)
+	require.Equal(t, timestamp, md.Timestamp)
+
+	md = metadata.Extract(message.NewEnvelopedMessage(message.Envelope{
+		ID:      "id-2",
+		Headers: map[string]string{"x-correlation": "flow", "x-tenant": "other"},
+	}), metadata.WithCorrelationIDHeader("x-correlation"), metadata.WithTenantHeader("x-tenant"))
+	require.Equal(t, "flow", md.CorrelationID)
+	require.Equal(t, "other", md.Tenant)
+
+	// Messages without an envelope or headers have no metadata.
+	require.Equal(t, metadata.Metadata{Headers: map[string]string{}}, metadata.Extract(message.FromString("plain")))
+}
+
+func TestHandler(t *testing.T) {
+	msg := message.NewEnvelopedMessage(message.Envelope{
+		ID: "id-1",
+		Headers: map[string]string{
+			metadata.CorrelationIDHeader: "flow",
+			metadata.TenantHeader:        "acme",
+			metadata.TraceParentHeader:   "00-trace-span-01",
+		},
+	})
+
+	var called bool
+	handler := metadata.Handler(func(ctx context.Context, _ substrate.Message) error {
+		called = true
+		require.Equal(t, "id-1", metadata.MessageID(ctx))
+		require.Equal(t, "flow", metadata.CorrelationID(ctx))
+		require.Equal(t, "acme", metadata.Tenant(ctx))
+		require.Equal(t, "00-trace-span-01", metadata.TraceParent(ctx))
+		require.Equal(t, "flow", metadata.Header(ctx, metadata.CorrelationIDHeader))
+		return nil
+	})
+	require.NoError(t, handler(context.Background(), msg))
+	require.True(t, called)
+
+	// Contexts without metadata return empty values.
+	_, ok := metadata.FromContext(context.Background())
+	require.False(t, ok)
+	require.Empty(t, metadata.CorrelationID(context.Background()))
+}
+
+func TestSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	backend := mock.NewSource(
+		message.NewEnvelopedMessage(message.Envelope{ID: "id-1", Headers: map[string]string{metadata.TenantHeader: "acme"}}),
+		message.NewEnvelopedMessage(message.Envelope{ID: "id-2"}),
+	)
+	// The metadata carried by the messages takes precedence over the options of the handler.
+	source := metadata.NewAsyncMessageSource(backend)
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	var ids, tenants []string
+	handler := metadata.Handler(func(ctx context.Context, msg substrate.Message) error {
+		ids = append(ids, metadata.MessageID(ctx))
+		tenants = append(tenants, metadata.Tenant(ctx))
+		return nil
+	}, metadata.WithTenantHeader("ignored"))
+	for i := 0; i < 2; i++ {
+		msg := <-messages
+		require.NoError(t, handler(ctx, msg))
+		acks <- msg
+	}
+	require.Equal(t, []string{"id-1", "id-2"}, ids)
+	require.Equal(t, []string{"acme", ""}, tenants)
+
+	// The mock source terminates with an error if acknowledgements are out of order.
+	<-backend.AllAcked()
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestConformance(t *testing.T) {
+	conformance.TestAsyncSource(t, func(_ *testing.T, backend substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		return metadata.NewAsyncMessageSource(backend)
+	})
+}
@@ -0,0 +1,110 @@
+package metadata
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/sync/rungroup"
+
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that extracts the metadata of the
+// consumed messages once, before they are unwrapped by other wrappers, and delivers messages carrying it. Their
+// metadata is returned by Extract and attached to the context of handlers wrapped by Handler, or by NewContext.
+// The source should wrap an envelope source, see message.NewEnvelopeSource, for messages to have an ID.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, opts ...Option) substrate.AsyncMessageSource {
+	return &metadataSource{
+		source:    source,
+		extractor: newExtractor(opts),
+	}
+}
+
+type metadataSource struct {
+	source    substrate.AsyncMessageSource
+	extractor *extractor
+}
+
+func (s *metadataSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "metadata"})
+	rg, ctx := rungroup.New(ctx)
+
+	consumed := make(chan substrate.Message, cap(messages))
+	toAck := make(chan substrate.Message, cap(acks))
+
+	rg.Go(func() error {
+		return s.source.ConsumeMessages(ctx, consumed, toAck)
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-consumed:
+				mMsg := &metadataMessage{msg: msg, metadata: s.extractor.extract(msg)}
+				emit.Publish(mMsg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case messages <- mMsg:
+				}
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				mMsg, ok := ack.(*metadataMessage)
+				if !ok {
+					return errors.Errorf("unexpected message acknowledged: %v", ack)
+				}
+				emit.Ack(ack)
+				select {
+				case <-ctx.Done():
+					return nil
+				case toAck <- mMsg.msg:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+func (s *metadataSource) Close() error {
+	return s.source.Close()
+}
+
+func (s *metadataSource) Status() (*substrate.Status, error) {
+	return s.source.Status()
+}
+
+// metadataMessage is a consumed message carrying its metadata.
+type metadataMessage struct {
+	msg      substrate.Message
+	metadata Metadata
+}
+
+func (msg *metadataMessage) Data() []byte {
+	return msg.msg.Data()
+}
+
+func (msg *metadataMessage) Headers() map[string]string {
+	return message.Headers(msg.msg)
+}
+
+func (msg *metadataMessage) DiscardPayload() {
+	if dMsg, ok := msg.msg.(substrate.DiscardableMessage); ok {
+		dMsg.DiscardPayload()
+	}
+}
+
+func (msg *metadataMessage) CarriedEnvelope() (message.Envelope, bool) {
+	return message.EnvelopeOf(msg.msg)
+}
@@ -0,0 +1,49 @@
+package middleware
+
+// Error is an error returned by a sink or source wrapped by a named middleware.
+type Error struct {
+	// Middleware is the name of the middleware.
+	Middleware string
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Middleware + ": " + e.Err.Error()
+}
+
+// Cause returns the underlying error, so that errors.Cause returns the original error.
+func (e *Error) Cause() error {
+	return e.Err
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// label wraps the error with the name of the middleware, unless the error is nil or already labelled.
+func label(name string, err error) error {
+	if err == nil || labelled(err) {
+		return err
+	}
+	return &Error{Middleware: name, Err: err}
+}
+
+// labelled checks whether the error, or any error it wraps, is an *Error.
+func labelled(err error) bool {
+	for err != nil {
+		if _, ok := err.(*Error); ok {
+			return true
+		}
+		switch wrapper := err.(type) {
+		case interface{ Cause() error }:
+			err = wrapper.Cause()
+		case interface{ Unwrap() error }:
+			err = wrapper.Unwrap()
+		default:
+			return false
+		}
+	}
+	return false
+}
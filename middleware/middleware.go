@@ -0,0 +1,116 @@
+// Package middleware provides types and helpers to compose message sink and message source wrappers declaratively.
+package middleware
+
+import (
+	"context"
+
+	"github.com/uw-labs/substrate"
+)
+
+// SinkMiddleware wraps a message sink, returning the wrapping sink.
+type SinkMiddleware func(sink substrate.AsyncMessageSink) substrate.AsyncMessageSink
+
+// SourceMiddleware wraps a message source, returning the wrapping source.
+type SourceMiddleware func(source substrate.AsyncMessageSource) substrate.AsyncMessageSource
+
+// Chain returns a middleware applying all the provided middlewares, nil ones are skipped. The first middleware
+// is the outermost one, i.e. Chain(a, b)(sink) is equivalent to a(b(sink)). Published messages therefore pass
+// through the middlewares in the given order before reaching the sink, and consumed messages pass through them
+// in the reverse order before reaching the user, so that e.g. a chain of compression and encryption middlewares
+// can be used for both the sink and the source.
+func Chain[M ~func(T) T, T any](middlewares ...M) M {
+	return func(wrapped T) T {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			if middlewares[i] != nil {
+				wrapped = middlewares[i](wrapped)
+			}
+		}
+		return wrapped
+	}
+}
+
+// NamedSink returns a middleware applying the provided one, that labels errors returned by the wrapping sink with
+// the name, unless they have already been labelled by a middleware closer to the sink. The returned errors are of
+// type *Error.
+func NamedSink(name string, middleware SinkMiddleware) SinkMiddleware {
+	return func(sink substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+		return &namedSink{AsyncMessageSink: middleware(sink), name: name, inner: sink}
+	}
+}
+
+type namedSink struct {
+	substrate.AsyncMessageSink
+	name  string
+	inner substrate.AsyncMessageSink
+}
+
+func (s *namedSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	return label(s.name, s.AsyncMessageSink.PublishMessages(ctx, acks, messages))
+}
+
+func (s *namedSink) Close() error {
+	return label(s.name, s.AsyncMessageSink.Close())
+}
+
+func (s *namedSink) Status() (*substrate.Status, error) {
+	status, err := s.AsyncMessageSink.Status()
+	return status, label(s.name, err)
+}
+
+// NamedSource returns a middleware applying the provided one, that labels errors returned by the wrapping source with
+// the name, unless they have already been labelled by a middleware closer to the source. The returned errors are of
+// type *Error.
+func NamedSource(name string, middleware SourceMiddleware) SourceMiddleware {
+	return func(source substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		return &namedSource{AsyncMessageSource: middleware(source), name: name, inner: source}
+	}
+}
+
+type namedSource struct {
+	substrate.AsyncMessageSource
+	name  string
+	inner substrate.AsyncMessageSource
+}
+
+func (s *namedSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	return label(s.name, s.AsyncMessageSource.ConsumeMessages(ctx, messages, acks))
+}
+
+func (s *namedSource) Close() error {
+	return label(s.name, s.AsyncMessageSource.Close())
+}
+
+func (s *namedSource) Status() (*substrate.Status, error) {
+	status, err := s.AsyncMessageSource.Status()
+	return status, label(s.name, err)
+}
+
+// SinkNames returns the names of the named middlewares wrapping the sink, outermost first, along with the sink
+// wrapped by the innermost one. Middlewares that weren't named using NamedSink are hidden in the sink they wrap,
+// or in the sink returned if they wrap the innermost named middleware.
+func SinkNames(sink substrate.AsyncMessageSink) ([]string, substrate.AsyncMessageSink) {
+	var names []string
+	for {
+		named, ok := sink.(*namedSink)
+		if !ok {
+			return names, sink
+		}
+		names = append(names, named.name)
+		sink = named.inner
+	}
+}
+
+// SourceNames returns the names of the named middlewares wrapping the source, outermost first, along with the
+// source wrapped by the innermost one. Middlewares that weren't named using NamedSource are hidden in the source
+// they wrap, or in the source returned if they wrap the innermost named middleware.
+func SourceNames(source substrate.AsyncMessageSource) ([]string, substrate.AsyncMessageSource) {
+	var names []string
+	for {
+		named, ok := source.(*namedSource)
+		if !ok {
+			return names, source
+		}
+		names = append(names, named.name)
+		source = named.inner
+	}
+}
@@ -0,0 +1,186 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/compress"
+	"github.com/uw-labs/substrate-tools/encrypt"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/middleware"
+)
+
+func TestChainOrdering(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	keys, err := encrypt.NewStaticKeyProvider("v1", map[string][]byte{"v1": bytes.Repeat([]byte{1}, 32)})
+	require.NoError(t, err)
+
+	sinkChain := middleware.Chain[middleware.SinkMiddleware](
+		func(sink substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+			return compress.NewAsyncMessageSink(sink, compress.WithThreshold(16))
+		},
+		func(sink substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+			return encrypt.NewAsyncMessageSink(sink, keys)
+		},
+		nil,
+		func(sink substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+			return message.NewEnvelopeSink(sink)
+		},
+	)
+	sourceChain := middleware.Chain(
+		compress.NewAsyncMessageSource,
+		func(source substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+			return encrypt.NewAsyncMessageSource(source, keys)
+		},
+		message.NewEnvelopeSource,
+	)
+
+	broker := mem.NewBroker()
+	sink := sinkChain(broker.NewAsyncMessageSink("topic"))
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	go sink.PublishMessages(ctx, acks, messages)
+
+	payload := bytes.Repeat([]byte("payload"), 100)
+	msg := message.NewMessage(payload)
+	messages <- msg
+	require.Equal(t, msg, <-acks)
+
+	// The payload is compressed before being encrypted, so the encryption sink records the content encoding.
+	var raw message.Envelope
+	require.NoError(t, json.Unmarshal(broker.Messages("topic")[0], &raw))
+	require.Equal(t, "gzip", raw.Headers[compress.ContentEncodingHeader])
+	require.Equal(t, "v1", raw.Headers[encrypt.KeyIDHeader])
+
+	source := sourceChain(broker.NewAsyncMessageSource("topic", "group"))
+	consumed, sourceAcks := make(chan substrate.Message), make(chan substrate.Message)
+	go source.ConsumeMessages(ctx, consumed, sourceAcks)
+
+	received := <-consumed
+	require.Equal(t, payload, received.Data())
+	require.Empty(t, message.Headers(received))
+	sourceAcks <- received
+}
+
+type failingSink struct {
+	err error
+}
+
+func (s failingSink) PublishMessages(context.Context, chan<- substrate.Message, <-chan substrate.Message) error {
+	return s.err
+}
+
+func (s failingSink) Close() error {
+	return s.err
+}
+
+func (s failingSink) Status() (*substrate.Status, error) {
+	return nil, s.err
+}
+
+type failingSource struct {
+	err error
+}
+
+func (s failingSource) ConsumeMessages(context.Context, chan<- substrate.Message, <-chan substrate.Message) error {
+	return s.err
+}
+
+func (s failingSource) Close() error {
+	return nil
+}
+
+func (s failingSource) Status() (*substrate.Status, error) {
+	return &substrate.Status{Working: true}, nil
+}
+
+func identitySink(sink substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+	return sink
+}
+
+func TestNamedSinkErrors(t *testing.T) {
+	sinkErr := errors.New("sink failure")
+
+	sink := middleware.Chain(
+		middleware.NamedSink("outer", identitySink),
+		middleware.NamedSink("inner", identitySink),
+	)(failingSink{err: sinkErr})
+
+	err := sink.PublishMessages(context.Background(), make(chan substrate.Message), make(chan substrate.Message))
+	require.EqualError(t, err, "inner: sink failure")
+	require.Equal(t, sinkErr, errors.Cause(err))
+	require.Equal(t, "inner", err.(*middleware.Error).Middleware)
+
+	require.EqualError(t, sink.Close(), "inner: sink failure")
+
+	_, err = sink.Status()
+	require.EqualError(t, err, "inner: sink failure")
+}
+
+func TestNamedSinkKeepsWrappedLabel(t *testing.T) {
+	sinkErr := errors.New("sink failure")
+
+	sink := middleware.Chain(
+		middleware.NamedSink("outer", func(sink substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+			return failingSink{err: errors.Wrap(sink.Close(), "closing")}
+		}),
+		middleware.NamedSink("inner", identitySink),
+	)(failingSink{err: sinkErr})
+
+	err := sink.Close()
+	require.EqualError(t, err, "closing: inner: sink failure")
+	require.Equal(t, sinkErr, errors.Cause(err))
+}
+
+func TestNamedSourceErrors(t *testing.T) {
+	sourceErr := errors.New("source failure")
+
+	source := middleware.Chain(
+		middleware.NamedSource("dedup", func(source substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+			return source
+		}),
+	)(failingSource{err: sourceErr})
+
+	err := source.ConsumeMessages(context.Background(), make(chan substrate.Message), make(chan substrate.Message))
+	require.EqualError(t, err, "dedup: source failure")
+	require.Equal(t, sourceErr, errors.Cause(err))
+
+	require.NoError(t, source.Close())
+	status, err := source.Status()
+	require.NoError(t, err)
+	require.True(t, status.Working)
+}
+
+func TestNames(t *testing.T) {
+	backend := failingSink{err: errors.New("sink failure")}
+	sink := middleware.Chain(
+		middleware.NamedSink("outer", identitySink),
+		identitySink,
+		middleware.NamedSink("inner", identitySink),
+	)(backend)
+
+	names, inner := middleware.SinkNames(sink)
+	require.Equal(t, []string{"outer", "inner"}, names)
+	require.Equal(t, backend, inner)
+
+	names, inner = middleware.SinkNames(backend)
+	require.Empty(t, names)
+	require.Equal(t, backend, inner)
+
+	source := failingSource{err: errors.New("source failure")}
+	names, innerSource := middleware.SourceNames(middleware.NamedSource("named", func(source substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		return source
+	})(source))
+	require.Equal(t, []string{"named"}, names)
+	require.Equal(t, source, innerSource)
+}
@@ -0,0 +1,177 @@
+package mock
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/uw-labs/substrate"
+)
+
+var _ substrate.AsyncMessageSource = (*Source)(nil)
+
+// Source is a scriptable message source. It sends its messages to the user and expects them to be acknowledged
+// in order, then waits for the context to be cancelled. Its behaviour is configured using the fluent methods,
+// which must be called before the source is used.
+type Source struct {
+	messages        []substrate.Message
+	failAt          int
+	failErr         error
+	returnWhenAcked bool
+
+	mutex    sync.RWMutex
+	cancel   func()
+	closed   bool
+	acked    []substrate.Message
+	allAcked chan struct{}
+}
+
+// NewSource returns a new source sending the messages.
+func NewSource(messages ...substrate.Message) *Source {
+	return &Source{
+		messages: messages,
+		allAcked: make(chan struct{}),
+	}
+}
+
+// FailAt makes ConsumeMessages return the error instead of sending the n-th message, counting from 1.
+func (s *Source) FailAt(n int, err error) *Source {
+	s.failAt, s.failErr = n, err
+	return s
+}
+
+// ReturnWhenAcked makes ConsumeMessages return nil once all the messages have been acknowledged, instead of
+// waiting for the context to be cancelled.
+func (s *Source) ReturnWhenAcked() *Source {
+	s.returnWhenAcked = true
+	return s
+}
+
+// ConsumeMessages sends the messages to the user and checks that the acknowledgements are in order. It returns
+// an error for an unexpected acknowledgement, or in case the source was already used or closed.
+func (s *Source) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	ctx, err := s.init(ctx)
+	if err != nil {
+		return err
+	}
+
+	sent := 0
+	for {
+		acked := len(s.Acked())
+		if acked == len(s.messages) && s.returnWhenAcked {
+			return nil
+		}
+
+		var (
+			out  chan<- substrate.Message
+			next substrate.Message
+		)
+		if sent < len(s.messages) {
+			if sent+1 == s.failAt {
+				return s.failErr
+			}
+			out, next = messages, s.messages[sent]
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case out <- next:
+			sent++
+		case msg := <-acks:
+			if acked == sent || msg != s.messages[acked] {
+				var expected substrate.Message
+				if acked < sent {
+					expected = s.messages[acked]
+				}
+				return substrate.InvalidAckError{Acked: msg, Expected: expected}
+			}
+			s.mutex.Lock()
+			s.acked = append(s.acked, msg)
+			if len(s.acked) == len(s.messages) {
+				close(s.allAcked)
+			}
+			s.mutex.Unlock()
+		}
+	}
+}
+
+func (s *Source) init(ctx context.Context) (context.Context, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.cancel != nil {
+		return nil, errors.New("source already in use")
+	}
+	if s.closed {
+		return nil, errors.New("source already closed")
+	}
+	ctx, s.cancel = context.WithCancel(ctx)
+	if len(s.messages) == 0 {
+		close(s.allAcked)
+	}
+	return ctx, nil
+}
+
+// Acked returns the messages acknowledged so far.
+func (s *Source) Acked() []substrate.Message {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return append([]substrate.Message(nil), s.acked...)
+}
+
+// AllAcked returns a channel that is closed once all the messages have been acknowledged.
+func (s *Source) AllAcked() <-chan struct{} {
+	return s.allAcked
+}
+
+// AssertExpectations reports an error to t if any of the messages hasn't been acknowledged.
+func (s *Source) AssertExpectations(t TestingT) bool {
+	t.Helper()
+
+	acked := s.Acked()
+	if len(acked) < len(s.messages) {
+		t.Errorf("%d of %d messages weren't acknowledged", len(s.messages)-len(acked), len(s.messages))
+		return false
+	}
+	return true
+}
+
+// Close closes the message source, it will cause the call to ConsumeMessages to return.
+func (s *Source) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	if s.cancel != nil {
+		s.cancel()
+	}
+	return nil
+}
+
+// Status returns the status of this message source. It will report not working after the source was closed.
+func (s *Source) Status() (*substrate.Status, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if !s.closed {
+		return &substrate.Status{Working: true}, nil
+	}
+	return &substrate.Status{
+		Working:  false,
+		Problems: []string{"source already closed"},
+	}, nil
+}
+
+// WasClosed indicates whether the close method was called on the message source.
+func (s *Source) WasClosed() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.closed
+}
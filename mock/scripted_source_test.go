@@ -0,0 +1,71 @@
+package mock_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+func TestSource_ReturnWhenAcked(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source := mock.NewSource(message.FromString("a"), message.FromString("b")).ReturnWhenAcked()
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	first, second := <-messages, <-messages
+	r := &recorder{}
+	require.False(t, source.AssertExpectations(r))
+	require.Len(t, r.errors, 1)
+
+	acks <- first
+	acks <- second
+	<-source.AllAcked()
+	require.NoError(t, <-errs)
+	require.True(t, source.AssertExpectations(t))
+	require.Equal(t, []substrate.Message{first, second}, source.Acked())
+}
+
+func TestSource_OutOfOrderAck(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source := mock.NewSource(message.FromString("a"), message.FromString("b"))
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	<-messages
+	acks <- <-messages
+	require.IsType(t, substrate.InvalidAckError{}, <-errs)
+}
+
+func TestSource_FailAt(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	errFailed := errors.New("failed")
+	source := mock.NewSource(message.FromString("a"), message.FromString("b")).FailAt(2, errFailed)
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	require.Equal(t, "a", string((<-messages).Data()))
+	require.Equal(t, errFailed, <-errs)
+	require.Error(t, source.ConsumeMessages(ctx, messages, acks))
+}
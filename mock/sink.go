@@ -0,0 +1,193 @@
+package mock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/uw-labs/substrate"
+)
+
+var _ substrate.AsyncMessageSink = (*Sink)(nil)
+
+// TestingT is the subset of testing.T used by the assertion helpers.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// AckFunc decides whether the sink acknowledges the n-th message published to it, counting from 1.
+type AckFunc func(n int, msg substrate.Message) bool
+
+// Sink is a scriptable message sink. By default it acknowledges every message straight away. Its behaviour is
+// configured using the fluent methods, which must be called before the sink is used.
+type Sink struct {
+	expected []string
+	failAt   int
+	failErr  error
+	ackFunc  AckFunc
+	ackDelay time.Duration
+
+	mutex     sync.RWMutex
+	cancel    func()
+	closed    bool
+	published []string
+}
+
+// NewSink returns a new sink acknowledging every message published to it.
+func NewSink() *Sink {
+	return &Sink{}
+}
+
+// Expect sets the payloads of the messages the sink expects to be published, in order. Publishing any other
+// message makes PublishMessages return an error.
+func (s *Sink) Expect(payloads ...string) *Sink {
+	s.expected = payloads
+	return s
+}
+
+// FailAt makes PublishMessages return the error when it receives the n-th message, counting from 1, which isn't
+// published.
+func (s *Sink) FailAt(n int, err error) *Sink {
+	s.failAt, s.failErr = n, err
+	return s
+}
+
+// AckWith sets the function deciding which messages are acknowledged. Messages it returns false for are never
+// acknowledged, which makes the acknowledgements of the messages after them out of order.
+func (s *Sink) AckWith(f AckFunc) *Sink {
+	s.ackFunc = f
+	return s
+}
+
+// AckDelay makes the sink wait for the delay before acknowledging every message.
+func (s *Sink) AckDelay(delay time.Duration) *Sink {
+	s.ackDelay = delay
+	return s
+}
+
+// PublishMessages receives messages, checks them against the expectations and acknowledges them as configured,
+// until the context is cancelled, the sink is closed or the configured error is returned. It will error in case
+// the sink was already closed.
+func (s *Sink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	ctx, err := s.init(ctx)
+	if err != nil {
+		return err
+	}
+
+	for n := 1; ; n++ {
+		var msg substrate.Message
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg = <-messages:
+		}
+
+		if n == s.failAt {
+			return s.failErr
+		}
+		if s.expected != nil && (n > len(s.expected) || string(msg.Data()) != s.expected[n-1]) {
+			return fmt.Errorf("unexpected message %d published: %q", n, msg.Data())
+		}
+		s.mutex.Lock()
+		s.published = append(s.published, string(msg.Data()))
+		s.mutex.Unlock()
+
+		if s.ackFunc != nil && !s.ackFunc(n, msg) {
+			continue
+		}
+		if s.ackDelay > 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(s.ackDelay):
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case acks <- msg:
+		}
+	}
+}
+
+func (s *Sink) init(ctx context.Context) (context.Context, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil, errors.New("sink already closed")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	if s.cancel == nil {
+		s.cancel = cancel
+	} else {
+		// The sink may be used again, e.g. by wrappers restarting it, and Close cancels all the calls.
+		previous := s.cancel
+		s.cancel = func() {
+			previous()
+			cancel()
+		}
+	}
+	return ctx, nil
+}
+
+// Published returns the payloads of the messages published so far.
+func (s *Sink) Published() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return append([]string(nil), s.published...)
+}
+
+// AssertExpectations reports an error to t if any of the expected messages hasn't been published.
+func (s *Sink) AssertExpectations(t TestingT) bool {
+	t.Helper()
+
+	published := s.Published()
+	if len(published) < len(s.expected) {
+		t.Errorf("expected messages weren't published: %q", s.expected[len(published):])
+		return false
+	}
+	return true
+}
+
+// Close closes the message sink, it will cause the calls to PublishMessages to return.
+func (s *Sink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	if s.cancel != nil {
+		s.cancel()
+	}
+	return nil
+}
+
+// Status returns the status of this message sink. It will report not working after the sink was closed.
+func (s *Sink) Status() (*substrate.Status, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if !s.closed {
+		return &substrate.Status{Working: true}, nil
+	}
+	return &substrate.Status{
+		Working:  false,
+		Problems: []string{"sink already closed"},
+	}, nil
+}
+
+// WasClosed indicates whether the close method was called on the message sink.
+func (s *Sink) WasClosed() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.closed
+}
@@ -0,0 +1,92 @@
+package mock_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+)
+
+// recorder records the errors reported by the assertion helpers.
+type recorder struct {
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, format)
+}
+
+func publish(ctx context.Context, sink substrate.AsyncMessageSink) (chan<- substrate.Message, <-chan substrate.Message, <-chan error) {
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+	return messages, acks, errs
+}
+
+func TestSink_Expect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	sink := mock.NewSink().Expect("a", "b")
+	messages, acks, errs := publish(ctx, sink)
+
+	messages <- message.FromString("a")
+	require.Equal(t, "a", string((<-acks).Data()))
+
+	r := &recorder{}
+	require.False(t, sink.AssertExpectations(r))
+	require.Len(t, r.errors, 1)
+
+	messages <- message.FromString("c")
+	require.EqualError(t, <-errs, `unexpected message 2 published: "c"`)
+	require.Equal(t, []string{"a"}, sink.Published())
+}
+
+func TestSink_FailAt(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	errFailed := errors.New("failed")
+	sink := mock.NewSink().FailAt(2, errFailed)
+	messages, acks, errs := publish(ctx, sink)
+
+	messages <- message.FromString("a")
+	<-acks
+	messages <- message.FromString("b")
+	require.Equal(t, errFailed, <-errs)
+	require.Equal(t, []string{"a"}, sink.Published())
+	require.True(t, sink.AssertExpectations(t))
+}
+
+func TestSink_AckWith(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	sink := mock.NewSink().
+		AckWith(func(n int, _ substrate.Message) bool {
+			return n != 1
+		}).
+		AckDelay(time.Millisecond * 10)
+	messages, acks, errs := publish(ctx, sink)
+
+	messages <- message.FromString("a")
+	messages <- message.FromString("b")
+	require.Equal(t, "b", string((<-acks).Data()))
+
+	require.NoError(t, sink.Close())
+	require.NoError(t, <-errs)
+	require.True(t, sink.WasClosed())
+	status, err := sink.Status()
+	require.NoError(t, err)
+	require.False(t, status.Working)
+}
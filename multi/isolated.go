@@ -0,0 +1,547 @@
+package multi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/sync/rungroup"
+
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+	"github.com/uw-labs/substrate-tools/internal/wal"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+const (
+	defaultBufferSize    = 1000
+	defaultRetryInterval = time.Second
+)
+
+// ErrBufferFull is returned by an isolated fan-out sink with the OverflowFail policy when the buffer of a sink is full.
+var ErrBufferFull = errors.New("buffer is full")
+
+var errChildStopped = errors.New("sink stopped")
+
+// OverflowPolicy determines what an isolated fan-out sink does with a message when the buffer of a sink is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for the sink to publish buffered messages, which stalls all the sinks.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest doesn't publish the message to the sink.
+	OverflowDropNewest
+	// OverflowFail fails publishing with ErrBufferFull.
+	OverflowFail
+)
+
+// IsolatedFanOutSinkOption is a function which sets an isolated fan-out sink configuration option.
+type IsolatedFanOutSinkOption func(s *isolatedFanOutSink)
+
+// WithBufferSize sets the maximum number of messages buffered in memory for each sink, including the messages
+// that were sent to the sink but not acknowledged yet. The default is 1000.
+func WithBufferSize(size int) IsolatedFanOutSinkOption {
+	return func(s *isolatedFanOutSink) {
+		s.bufferSize = size
+	}
+}
+
+// WithSpillDir makes the sink spill the messages exceeding the buffer of a sink to a write-ahead log in the
+// directory, instead of applying the overflow policy. The log of each sink is limited to the maximum size in
+// bytes if it is positive, the overflow policy applying once it is full. Spilled messages are published after
+// a restart if they weren't acknowledged by the sink before.
+func WithSpillDir(dir string, maxSize int64) IsolatedFanOutSinkOption {
+	return func(s *isolatedFanOutSink) {
+		s.spillDir, s.spillMaxSize = dir, maxSize
+	}
+}
+
+// WithOverflowPolicy sets what to do with a message when the buffer of a sink is full. The default is OverflowBlock.
+func WithOverflowPolicy(policy OverflowPolicy) IsolatedFanOutSinkOption {
+	return func(s *isolatedFanOutSink) {
+		s.policy = policy
+	}
+}
+
+// WithSinkRetryInterval sets the time to wait before publishing to a sink again after it failed. The default is
+// one second.
+func WithSinkRetryInterval(interval time.Duration) IsolatedFanOutSinkOption {
+	return func(s *isolatedFanOutSink) {
+		s.retryInterval = interval
+	}
+}
+
+// WithSinkErrorHandler sets a handler called when a sink fails, e.g. to log the error, which is emitted into the
+// events hooks carried by the context either way. By default, failures are only emitted.
+func WithSinkErrorHandler(handler func(sinkIndex int, err error)) IsolatedFanOutSinkOption {
+	return func(s *isolatedFanOutSink) {
+		if handler != nil {
+			s.errHandler = handler
+		}
+	}
+}
+
+// WithBacklogGauge sets a gauge, labelled with the index of the sink, that is updated with the number of
+// messages buffered for each sink, in memory or on disk. It panics in case it can't register the metric.
+func WithBacklogGauge(gaugeOpts prometheus.GaugeOpts) IsolatedFanOutSinkOption {
+	return func(s *isolatedFanOutSink) {
+		s.backlog = metrics.Register(prometheus.NewGaugeVec(gaugeOpts, []string{"sink"})).(*prometheus.GaugeVec)
+	}
+}
+
+// WithDroppedCounter sets a counter, labelled with the index of the sink, of the messages that weren't published
+// to each sink because of the OverflowDropNewest policy. It panics in case it can't register the metric.
+func WithDroppedCounter(counterOpts prometheus.CounterOpts) IsolatedFanOutSinkOption {
+	return func(s *isolatedFanOutSink) {
+		s.dropped = metrics.Register(prometheus.NewCounterVec(counterOpts, []string{"sink"})).(*prometheus.CounterVec)
+	}
+}
+
+// WithBufferGauges reports the number of messages buffered in memory for each sink, along with the buffer size as
+// the capacity, with the shared buffer gauges, labelled with the wrapper, which is "fanout", the provided name and
+// the index of the sink. Spilled messages are only counted by the backlog gauge. It panics in case it can't register
+// the metrics.
+func WithBufferGauges(name string) IsolatedFanOutSinkOption {
+	return func(s *isolatedFanOutSink) {
+		s.bufferName = &name
+	}
+}
+
+// NewIsolatedFanOutSink returns an instance of substrate.AsyncMessageSink that publishes every message to all
+// of the provided message sinks, buffering messages independently for each of them, so that a slow or failing
+// sink doesn't stall the others. Each sink publishes its messages in order, retrying after it fails.
+//
+// Messages are acknowledged once they are buffered for all the sinks, before any of the sinks acknowledged them,
+// so messages buffered in memory are delivered at most once: they are lost if publishing stops, e.g. the process
+// exits, before a sink published them, and aren't published again as they were acknowledged already. Only spilled
+// messages are delivered at least once, as they are published after a restart: a buffer size of 0 with a spill
+// directory spills all messages, making delivery to all the sinks at least once. Use NewFanOutSink for messages to
+// only be acknowledged once all the sinks acknowledged them.
+//
+// It returns an error if no message sinks are provided or the spill logs can't be opened.
+func NewIsolatedFanOutSink(sinks []substrate.AsyncMessageSink, opts ...IsolatedFanOutSinkOption) (substrate.AsyncMessageSink, error) {
+	if len(sinks) == 0 {
+		return nil, ErrNoMessageSinks
+	}
+	s := &isolatedFanOutSink{
+		bufferSize:    defaultBufferSize,
+		retryInterval: defaultRetryInterval,
+		errHandler:    func(int, error) {},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	for i, sink := range sinks {
+		b := &sinkBuffer{
+			index:      i,
+			label:      strconv.Itoa(i),
+			sink:       sink,
+			size:       s.bufferSize,
+			policy:     s.policy,
+			errHandler: s.errHandler,
+			backlog:    s.backlog,
+			dropped:    s.dropped,
+			ready:      make(chan struct{}, 1),
+			space:      make(chan struct{}, 1),
+		}
+		if s.bufferName != nil {
+			b.buffer = metrics.NewBuffer("fanout", *s.bufferName, b.label, float64(s.bufferSize))
+		}
+		s.buffers = append(s.buffers, b)
+		if s.spillDir != "" {
+			spill, err := wal.Open(filepath.Join(s.spillDir, b.label), "spill", s.spillMaxSize, 0)
+			if err != nil {
+				_ = s.closeLogs()
+				return nil, err
+			}
+			b.spill = spill
+			if err := b.recover(); err != nil {
+				_ = s.closeLogs()
+				return nil, err
+			}
+		}
+		b.updateBacklog()
+	}
+	return s, nil
+}
+
+type isolatedFanOutSink struct {
+	buffers       []*sinkBuffer
+	bufferSize    int
+	spillDir      string
+	spillMaxSize  int64
+	policy        OverflowPolicy
+	retryInterval time.Duration
+	errHandler    func(sinkIndex int, err error)
+	backlog       *prometheus.GaugeVec
+	dropped       *prometheus.CounterVec
+	bufferName    *string
+}
+
+// PublishMessages buffers messages for all the underlying sinks, acknowledging them once buffered, while the sinks
+// publish their buffered messages.
+func (s *isolatedFanOutSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "multi"})
+	rg, ctx := rungroup.New(ctx)
+
+	for _, b := range s.buffers {
+		b := b
+		rg.Go(emit.Errors(func() error {
+			return s.run(ctx, emit, b)
+		}))
+	}
+
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-messages:
+				emit.Publish(msg)
+				for _, b := range s.buffers {
+					if err := b.push(ctx, msg); err != nil {
+						return err
+					}
+				}
+				emit.Ack(msg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case acks <- msg:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+// run publishes the messages buffered for the sink, publishing them again after the retry interval when it fails.
+func (s *isolatedFanOutSink) run(ctx context.Context, emit events.Emitter, b *sinkBuffer) error {
+	for {
+		err := b.publish(ctx, emit)
+		b.rewind()
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case err != errChildStopped:
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(s.retryInterval):
+		}
+	}
+}
+
+// Close closes all the underlying sinks and spill logs and returns all errors encountered.
+func (s *isolatedFanOutSink) Close() (err error) {
+	for _, b := range s.buffers {
+		err = multierror.Append(err, b.sink.Close()).ErrorOrNil()
+	}
+	return multierror.Append(err, s.closeLogs()).ErrorOrNil()
+}
+
+func (s *isolatedFanOutSink) closeLogs() (err error) {
+	for _, b := range s.buffers {
+		if b.spill != nil {
+			err = multierror.Append(err, b.spill.Close()).ErrorOrNil()
+		}
+	}
+	return err
+}
+
+// Status reports the status of all the underlying sinks, along with the number of messages buffered for each of
+// them. It collects all errors encountered and only reports working status if all the underlying sinks do.
+func (s *isolatedFanOutSink) Status() (*substrate.Status, error) {
+	sinks := make([]substrate.AsyncMessageSink, len(s.buffers))
+	for i, b := range s.buffers {
+		sinks[i] = b.sink
+	}
+	status, err := sinksStatus(sinks)
+	for _, b := range s.buffers {
+		if backlog := b.backlogSize(); backlog > 0 {
+			status.Problems = append(status.Problems, fmt.Sprintf("sink %d: backlog: %d messages", b.index, backlog))
+		}
+	}
+	return status, err
+}
+
+// spillRecord is a message stored in a spill log.
+type spillRecord struct {
+	Data    []byte            `json:"data"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// bufferedMessage is a message buffered for a sink, either in memory or read from the spill log, in which case
+// it ends at the given offset.
+type bufferedMessage struct {
+	msg     substrate.Message
+	data    []byte
+	headers map[string]string
+	end     int64
+}
+
+func (msg *bufferedMessage) Data() []byte {
+	if msg.msg != nil {
+		return msg.msg.Data()
+	}
+	return msg.data
+}
+
+func (msg *bufferedMessage) Headers() map[string]string {
+	if msg.msg != nil {
+		return message.Headers(msg.msg)
+	}
+	return msg.headers
+}
+
+func (msg *bufferedMessage) CarriedEnvelope() (message.Envelope, bool) {
+	return message.EnvelopeOf(msg.msg)
+}
+
+// sinkBuffer buffers the messages of a sink. Messages are buffered in memory until the buffer is full, then
+// spilled, if enabled, until all the spilled messages were published, which keeps them in order.
+type sinkBuffer struct {
+	index      int
+	label      string
+	sink       substrate.AsyncMessageSink
+	size       int
+	policy     OverflowPolicy
+	errHandler func(sinkIndex int, err error)
+	backlog    *prometheus.GaugeVec
+	dropped    *prometheus.CounterVec
+	buffer     *metrics.Buffer
+
+	mutex sync.Mutex
+	// queue holds the messages in memory followed by the spilled messages read from the log, those before the
+	// cursor having been sent to the sink.
+	queue    []*bufferedMessage
+	inMemory int
+	cursor   int
+	spill    *wal.Log
+	spilled  int
+	ready    chan struct{}
+	space    chan struct{}
+}
+
+// push buffers the message, applying the overflow policy if the buffer is full.
+func (b *sinkBuffer) push(ctx context.Context, msg substrate.Message) error {
+	for {
+		buffered, err := b.tryPush(msg)
+		if err != nil || buffered {
+			return err
+		}
+		switch b.policy {
+		case OverflowDropNewest:
+			if b.dropped != nil {
+				b.dropped.WithLabelValues(b.label).Inc()
+			}
+			return nil
+		case OverflowFail:
+			return errors.Wrapf(ErrBufferFull, "sink %d", b.index)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-b.space:
+		}
+	}
+}
+
+// tryPush buffers the message unless the buffer is full.
+func (b *sinkBuffer) tryPush(msg substrate.Message) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	defer b.updateBacklog()
+
+	switch {
+	case b.spilled == 0 && b.inMemory < b.size:
+		b.queue = append(b.queue, &bufferedMessage{msg: msg})
+		b.inMemory++
+	case b.spill != nil:
+		body, err := json.Marshal(spillRecord{Data: msg.Data(), Headers: message.Headers(msg)})
+		if err != nil {
+			return false, errors.Wrap(err, "failed to encode spilled message")
+		}
+		switch _, err := b.spill.Append(body); err {
+		case nil:
+		case wal.ErrFull:
+			return false, nil
+		default:
+			return false, err
+		}
+		b.spilled++
+	default:
+		return false, nil
+	}
+	select {
+	case b.ready <- struct{}{}:
+	default:
+	}
+	return true, nil
+}
+
+// next returns the next message to send to the sink, reading it from the spill log if all the messages in
+// memory were sent. It returns nil if there is none.
+func (b *sinkBuffer) next() (*bufferedMessage, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.cursor == len(b.queue) && b.spilled > 0 {
+		e, err := b.spill.Next()
+		if err != nil {
+			return nil, err
+		}
+		if e != nil {
+			var rec spillRecord
+			if err := json.Unmarshal(e.Body, &rec); err != nil {
+				return nil, errors.Wrap(err, "failed to decode spilled message")
+			}
+			b.queue = append(b.queue, &bufferedMessage{data: rec.Data, headers: rec.Headers, end: e.End})
+		}
+	}
+	if b.cursor == len(b.queue) {
+		return nil, nil
+	}
+	msg := b.queue[b.cursor]
+	b.cursor++
+	return msg, nil
+}
+
+// acked removes the oldest message sent to the sink, which acknowledged it.
+func (b *sinkBuffer) acked(ack substrate.Message) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	defer b.updateBacklog()
+
+	msg, ok := ack.(*bufferedMessage)
+	if !ok || b.cursor == 0 || msg != b.queue[0] {
+		return errors.Errorf("unexpected message acknowledged: %v", ack)
+	}
+	b.queue, b.cursor = b.queue[1:], b.cursor-1
+
+	if msg.msg != nil {
+		b.inMemory--
+		select {
+		case b.space <- struct{}{}:
+		default:
+		}
+		return nil
+	}
+	if err := b.spill.Commit(msg.end); err != nil {
+		return err
+	}
+	if b.spilled--; b.spilled == 0 {
+		if err := b.spill.Reset(); err != nil {
+			return err
+		}
+		select {
+		case b.space <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// rewind makes the messages that weren't acknowledged be sent again, spilled messages being read from the log again.
+func (b *sinkBuffer) rewind() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.queue, b.cursor = b.queue[:b.inMemory], 0
+	if b.spill != nil {
+		b.spill.Rewind()
+	}
+}
+
+// recover counts the messages left in the spill log by a previous run, which are published first.
+func (b *sinkBuffer) recover() error {
+	for {
+		e, err := b.spill.Next()
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			b.spill.Rewind()
+			return nil
+		}
+		b.spilled++
+	}
+}
+
+// backlogSize returns the number of messages buffered, in memory or spilled.
+func (b *sinkBuffer) backlogSize() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.inMemory + b.spilled
+}
+
+// updateBacklog updates the backlog and buffer gauges, the mutex must be held.
+func (b *sinkBuffer) updateBacklog() {
+	if b.backlog != nil {
+		b.backlog.WithLabelValues(b.label).Set(float64(b.inMemory + b.spilled))
+	}
+	b.buffer.Set(float64(b.inMemory))
+}
+
+// publish publishes the buffered messages to the sink until it fails.
+func (b *sinkBuffer) publish(ctx context.Context, emit events.Emitter) error {
+	rg, ctx := rungroup.New(ctx)
+
+	toPublish, published := make(chan substrate.Message), make(chan substrate.Message)
+
+	rg.Go(func() error {
+		if err := b.sink.PublishMessages(ctx, published, toPublish); ctx.Err() == nil {
+			emit.Error(errors.Wrapf(err, "sink %d failed", b.index))
+			b.errHandler(b.index, err)
+		}
+		return errChildStopped
+	})
+	rg.Go(func() error {
+		for {
+			msg, err := b.next()
+			if err != nil {
+				return err
+			}
+			if msg == nil {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-b.ready:
+				}
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case toPublish <- msg:
+			}
+		}
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-published:
+				if err := b.acked(ack); err != nil {
+					return err
+				}
+			}
+		}
+	})
+
+	return rg.Wait()
+}
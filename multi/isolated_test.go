@@ -0,0 +1,215 @@
+package multi_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/multi"
+)
+
+// gatedSink is a sink that only starts publishing once its gate is opened.
+type gatedSink struct {
+	substrate.AsyncMessageSink
+	gate chan struct{}
+}
+
+func (s gatedSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-s.gate:
+	}
+	return s.AsyncMessageSink.PublishMessages(ctx, acks, messages)
+}
+
+func publishAll(ctx context.Context, t *testing.T, messages chan<- substrate.Message, acks <-chan substrate.Message, payloads ...string) {
+	for _, payload := range payloads {
+		msg := message.FromString(payload)
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "failed to publish message")
+		case messages <- msg:
+		}
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "message wasn't acknowledged")
+		case ack := <-acks:
+			require.Equal(t, msg, ack)
+		}
+	}
+}
+
+func payloads(broker *mem.Broker, topic string) []string {
+	var payloads []string
+	for _, data := range broker.Messages(topic) {
+		payloads = append(payloads, string(data))
+	}
+	return payloads
+}
+
+func TestIsolatedFanOutSink_DropNewest(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	dropped := prometheus.CounterOpts{Name: "isolated_fan_out_dropped_total", Help: "Dropped messages."}
+	sink, err := multi.NewIsolatedFanOutSink([]substrate.AsyncMessageSink{
+		broker.NewAsyncMessageSink("fast"),
+		gatedSink{AsyncMessageSink: broker.NewAsyncMessageSink("slow"), gate: make(chan struct{})},
+	}, multi.WithBufferSize(2), multi.WithOverflowPolicy(multi.OverflowDropNewest), multi.WithDroppedCounter(dropped),
+		multi.WithBufferGauges("drop-newest"))
+	require.NoError(t, err)
+
+	// The counter is shared by the runs of the test.
+	counter := prometheus.NewCounterVec(dropped, []string{"sink"})
+	if err := prometheus.Register(counter); err != nil {
+		counter = err.(prometheus.AlreadyRegisteredError).ExistingCollector.(*prometheus.CounterVec)
+	}
+	before := testutil.ToFloat64(counter.WithLabelValues("1"))
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	// The slow sink doesn't stall the fast one, the messages exceeding its buffer are dropped.
+	for i, payload := range []string{"1", "2", "3", "4", "5"} {
+		publishAll(ctx, t, messages, acks, payload)
+		require.Eventually(t, func() bool {
+			return len(broker.Messages("fast")) == i+1
+		}, time.Second, time.Millisecond*10)
+	}
+	require.Equal(t, []string{"1", "2", "3", "4", "5"}, payloads(broker, "fast"))
+
+	require.Equal(t, float64(3), testutil.ToFloat64(counter.WithLabelValues("1"))-before)
+
+	status, err := sink.Status()
+	require.NoError(t, err)
+	require.Equal(t, []string{"sink 1: backlog: 2 messages"}, status.Problems)
+
+	expected := `
+# HELP substrate_tools_buffer_capacity Capacity of the buffer of a wrapper, in the same unit as the used gauge, or 0 if it is unbounded.
+# TYPE substrate_tools_buffer_capacity gauge
+substrate_tools_buffer_capacity{buffer="0",name="drop-newest",wrapper="fanout"} 2
+substrate_tools_buffer_capacity{buffer="1",name="drop-newest",wrapper="fanout"} 2
+# HELP substrate_tools_buffer_used Number of messages, or bytes for buffers on disk, held in the buffer of a wrapper.
+# TYPE substrate_tools_buffer_used gauge
+substrate_tools_buffer_used{buffer="0",name="drop-newest",wrapper="fanout"} 0
+substrate_tools_buffer_used{buffer="1",name="drop-newest",wrapper="fanout"} 2
+`
+	require.Eventually(t, func() bool {
+		return testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected),
+			"substrate_tools_buffer_used", "substrate_tools_buffer_capacity") == nil
+	}, time.Second, time.Millisecond*10)
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestIsolatedFanOutSink_Spill(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	gate := make(chan struct{})
+	sink, err := multi.NewIsolatedFanOutSink([]substrate.AsyncMessageSink{
+		broker.NewAsyncMessageSink("fast"),
+		gatedSink{AsyncMessageSink: broker.NewAsyncMessageSink("slow"), gate: gate},
+	}, multi.WithBufferSize(1), multi.WithSpillDir(t.TempDir(), 0))
+	require.NoError(t, err)
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	publishAll(ctx, t, messages, acks, "1", "2", "3")
+	require.Eventually(t, func() bool {
+		status, err := sink.Status()
+		return err == nil && len(status.Problems) == 1 && status.Problems[0] == "sink 1: backlog: 3 messages"
+	}, time.Second, time.Millisecond*10)
+
+	// The spilled messages are published in order once the slow sink catches up.
+	close(gate)
+	require.Eventually(t, func() bool {
+		return len(broker.Messages("slow")) == 3
+	}, time.Second, time.Millisecond*10)
+	publishAll(ctx, t, messages, acks, "4")
+	require.Eventually(t, func() bool {
+		return len(broker.Messages("slow")) == 4
+	}, time.Second, time.Millisecond*10)
+	require.Equal(t, []string{"1", "2", "3", "4"}, payloads(broker, "slow"))
+	require.Equal(t, []string{"1", "2", "3", "4"}, payloads(broker, "fast"))
+
+	cancel()
+	require.NoError(t, <-errs)
+	require.NoError(t, sink.Close())
+}
+
+func TestIsolatedFanOutSink_Fail(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	sink, err := multi.NewIsolatedFanOutSink([]substrate.AsyncMessageSink{
+		gatedSink{AsyncMessageSink: mem.NewBroker().NewAsyncMessageSink("slow"), gate: make(chan struct{})},
+	}, multi.WithBufferSize(1), multi.WithOverflowPolicy(multi.OverflowFail))
+	require.NoError(t, err)
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message, 2)
+	messages <- message.FromString("1")
+	messages <- message.FromString("2")
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+	<-acks
+	err = <-errs
+	require.Equal(t, multi.ErrBufferFull, errors.Cause(err))
+	require.EqualError(t, err, "sink 0: buffer is full")
+}
+
+func TestIsolatedFanOutSink_Retry(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	failures := make(chan int, 1)
+	sink, err := multi.NewIsolatedFanOutSink([]substrate.AsyncMessageSink{
+		broker.NewAsyncMessageSink("topic"),
+		failingSink{err: errors.New("sink error")},
+	}, multi.WithSinkRetryInterval(time.Millisecond), multi.WithSinkErrorHandler(func(sinkIndex int, _ error) {
+		select {
+		case failures <- sinkIndex:
+		default:
+		}
+	}))
+	require.NoError(t, err)
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	// The failing sink is retried, without affecting the other sink.
+	publishAll(ctx, t, messages, acks, "1", "2")
+	require.Equal(t, 1, <-failures)
+	require.Eventually(t, func() bool {
+		return len(broker.Messages("topic")) == 2
+	}, time.Second, time.Millisecond*10)
+
+	cancel()
+	require.NoError(t, <-errs)
+}
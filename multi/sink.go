@@ -0,0 +1,279 @@
+package multi
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// ErrNoMessageSinks is an error indicating that no message sinks were provided to the fan-out sink.
+var ErrNoMessageSinks = errors.New("no message sinks provided")
+
+// ErrAllSinksFailed is an error indicating that all sinks of a best-effort fan-out sink failed.
+var ErrAllSinksFailed = errors.New("all message sinks failed")
+
+// FanOutSinkOption is a function which sets a fan-out sink configuration option.
+type FanOutSinkOption func(s *fanOutSink)
+
+// WithBestEffort makes the fan-out sink carry on publishing to the remaining sinks when one of them fails,
+// instead of failing as soon as any of them does. Messages are acknowledged once all the remaining sinks have
+// acknowledged them. The handler, if not nil, is called for every sink that fails, e.g. to log the error, which is
+// emitted into the events hooks carried by the context either way. Publishing fails with ErrAllSinksFailed once all
+// sinks have failed.
+func WithBestEffort(handler func(sinkIndex int, err error)) FanOutSinkOption {
+	return func(s *fanOutSink) {
+		s.bestEffort = true
+		if handler != nil {
+			s.errHandler = handler
+		}
+	}
+}
+
+// NewFanOutSink returns an instance of substrate.AsyncMessageSink that publishes every message to all of the
+// provided message sinks and only acknowledges it once all of them have acknowledged it. By default, publishing
+// fails as soon as any of the sinks fails. It returns an error if no message sinks are provided.
+func NewFanOutSink(sinks []substrate.AsyncMessageSink, opts ...FanOutSinkOption) (substrate.AsyncMessageSink, error) {
+	if len(sinks) == 0 {
+		return nil, ErrNoMessageSinks
+	}
+	s := &fanOutSink{
+		sinks:      sinks,
+		errHandler: func(int, error) {},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
+}
+
+// fanOutSink implements substrate.AsyncMessageSink that publishes messages to multiple sinks.
+type fanOutSink struct {
+	sinks      []substrate.AsyncMessageSink
+	bestEffort bool
+	errHandler func(sinkIndex int, err error)
+}
+
+// PublishMessages publishes messages to all the underlying sinks and acknowledges them once all sinks did.
+func (s *fanOutSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "multi"})
+	rg, ctx := rungroup.New(ctx)
+
+	done := make(chan *fanOutMessage)
+	children := make([]*fanOutChild, len(s.sinks))
+	alive := int32(len(s.sinks))
+
+	for i, sink := range s.sinks {
+		child := &fanOutChild{
+			index: i,
+			sink:  sink,
+			in:    make(chan *fanOutMessage, cap(messages)),
+			done:  done,
+		}
+		children[i] = child
+
+		rg.Go(func() error {
+			err := child.publish(ctx)
+			if err == nil || ctx.Err() != nil {
+				return err
+			}
+			if !s.bestEffort {
+				return errors.Wrapf(err, "sink %d failed", child.index)
+			}
+			emit.Error(errors.Wrapf(err, "sink %d failed", child.index))
+			s.errHandler(child.index, err)
+			if atomic.AddInt32(&alive, -1) == 0 {
+				return emit.Error(ErrAllSinksFailed)
+			}
+			return child.drain(ctx)
+		})
+	}
+
+	// Send each message to all the sinks.
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-messages:
+				emit.Publish(msg)
+				fMsg := &fanOutMessage{msg: msg, pending: len(children)}
+				for _, child := range children {
+					select {
+					case <-ctx.Done():
+						return nil
+					case child.in <- fMsg:
+					}
+				}
+			}
+		}
+	})
+
+	// Acknowledge messages once all the sinks have acknowledged them.
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case fMsg := <-done:
+				fMsg.pending--
+				if fMsg.pending > 0 {
+					continue
+				}
+				emit.Ack(fMsg.msg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case acks <- fMsg.msg:
+				}
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+// Close closes all the underlying sinks and returns all errors encountered.
+func (s *fanOutSink) Close() (err error) {
+	for _, sink := range s.sinks {
+		err = multierror.Append(err, sink.Close()).ErrorOrNil()
+	}
+	return err
+}
+
+// Status calls the status method on all underlying sinks. It collects all errors encountered and
+// only reports working status if all the underlying sinks do.
+func (s *fanOutSink) Status() (*substrate.Status, error) {
+	return sinksStatus(s.sinks)
+}
+
+// sinksStatus returns the combined status of the sinks, their problems being prefixed by their index.
+func sinksStatus(sinks []substrate.AsyncMessageSink) (status *substrate.Status, err error) {
+	status = &substrate.Status{Working: true}
+
+	for i, sink := range sinks {
+		sinkStatus, sinkErr := sink.Status()
+		if sinkErr != nil {
+			status.Working = false
+			err = multierror.Append(err, sinkErr)
+		} else {
+			status.Working = status.Working && sinkStatus.Working
+			for _, problem := range sinkStatus.Problems {
+				status.Problems = append(status.Problems, fmt.Sprintf("sink %v: %s", i, problem))
+			}
+		}
+	}
+
+	return status, err
+}
+
+// fanOutChild publishes messages to one of the sinks, keeping track of the messages in flight.
+type fanOutChild struct {
+	index int
+	sink  substrate.AsyncMessageSink
+	in    chan *fanOutMessage
+	done  chan<- *fanOutMessage
+
+	mutex    sync.Mutex
+	inFlight []*fanOutMessage
+}
+
+func (c *fanOutChild) publish(ctx context.Context) error {
+	rg, ctx := rungroup.New(ctx)
+
+	toPublish := make(chan substrate.Message, cap(c.in))
+	published := make(chan substrate.Message, cap(c.in))
+
+	rg.Go(func() error {
+		return c.sink.PublishMessages(ctx, published, toPublish)
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case fMsg := <-c.in:
+				c.mutex.Lock()
+				c.inFlight = append(c.inFlight, fMsg)
+				c.mutex.Unlock()
+
+				select {
+				case <-ctx.Done():
+					return nil
+				case toPublish <- fMsg.msg:
+				}
+			}
+		}
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-published:
+				// Sinks acknowledge messages in order, so the acknowledged message is the oldest one in
+				// flight. It's only removed once the acknowledgement was passed on, so that it is not lost
+				// in case the sink fails in the meantime.
+				c.mutex.Lock()
+				if len(c.inFlight) == 0 {
+					c.mutex.Unlock()
+					return errors.Errorf("unexpected message acknowledged: %v", ack)
+				}
+				fMsg := c.inFlight[0]
+				c.mutex.Unlock()
+
+				select {
+				case <-ctx.Done():
+					return nil
+				case c.done <- fMsg:
+				}
+
+				c.mutex.Lock()
+				c.inFlight = c.inFlight[1:]
+				c.mutex.Unlock()
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+// drain treats all messages in flight, as well as all messages sent to the sink from now on, as
+// acknowledged. It is used once the sink failed in best-effort mode.
+func (c *fanOutChild) drain(ctx context.Context) error {
+	c.mutex.Lock()
+	inFlight := c.inFlight
+	c.inFlight = nil
+	c.mutex.Unlock()
+
+	for _, fMsg := range inFlight {
+		select {
+		case <-ctx.Done():
+			return nil
+		case c.done <- fMsg:
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case fMsg := <-c.in:
+			select {
+			case <-ctx.Done():
+				return nil
+			case c.done <- fMsg:
+			}
+		}
+	}
+}
+
+type fanOutMessage struct {
+	msg     substrate.Message
+	pending int
+}
@@ -0,0 +1,159 @@
+package multi_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/multi"
+)
+
+type failingSink struct {
+	substrate.AsyncMessageSink
+	err error
+}
+
+func (s failingSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-messages:
+		return s.err
+	}
+}
+
+func TestNewFanOutSink_Error(t *testing.T) {
+	_, err := multi.NewFanOutSink(nil)
+	require.Equal(t, multi.ErrNoMessageSinks, err)
+}
+
+func TestFanOutSink_PublishMessages(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	sink, err := multi.NewFanOutSink([]substrate.AsyncMessageSink{
+		broker.NewAsyncMessageSink("topic-1"),
+		broker.NewAsyncMessageSink("topic-2"),
+	})
+	require.NoError(t, err)
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	published := []substrate.Message{message.FromString("1"), message.FromString("2"), message.FromString("3")}
+	go func() {
+		for _, msg := range published {
+			messages <- msg
+		}
+	}()
+	for _, msg := range published {
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "failed to acknowledge all messages")
+		case ack := <-acks:
+			require.Equal(t, msg, ack)
+		}
+	}
+	cancel()
+	require.NoError(t, <-errs)
+
+	expected := [][]byte{[]byte("1"), []byte("2"), []byte("3")}
+	require.Equal(t, expected, broker.Messages("topic-1"))
+	require.Equal(t, expected, broker.Messages("topic-2"))
+}
+
+func TestFanOutSink_FailFast(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	sinkErr := errors.New("sink error")
+	sink, err := multi.NewFanOutSink([]substrate.AsyncMessageSink{
+		mem.NewBroker().NewAsyncMessageSink("topic"),
+		failingSink{err: sinkErr},
+	})
+	require.NoError(t, err)
+
+	messages := make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- sink.PublishMessages(ctx, make(chan substrate.Message, 1), messages)
+	}()
+
+	messages <- message.FromString("1")
+	err = <-errs
+	require.Error(t, err)
+	require.Equal(t, sinkErr, errors.Cause(err))
+}
+
+func TestFanOutSink_BestEffort(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	sinkErr := errors.New("sink error")
+	failed := make(chan int, 1)
+	broker := mem.NewBroker()
+	sink, err := multi.NewFanOutSink([]substrate.AsyncMessageSink{
+		failingSink{err: sinkErr},
+		broker.NewAsyncMessageSink("topic"),
+	}, multi.WithBestEffort(func(sinkIndex int, err error) {
+		require.Equal(t, sinkErr, err)
+		failed <- sinkIndex
+	}))
+	require.NoError(t, err)
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	for _, payload := range []string{"1", "2"} {
+		msg := message.FromString(payload)
+		messages <- msg
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "failed to acknowledge message")
+		case ack := <-acks:
+			require.Equal(t, msg, ack)
+		}
+	}
+	require.Equal(t, 0, <-failed)
+	require.Len(t, broker.Messages("topic"), 2)
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestFanOutSink_BestEffortAllFailed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	sink, err := multi.NewFanOutSink([]substrate.AsyncMessageSink{
+		failingSink{err: errors.New("sink error")},
+		failingSink{err: errors.New("sink error")},
+	}, multi.WithBestEffort(func(int, error) {}))
+	require.NoError(t, err)
+
+	messages := make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- sink.PublishMessages(ctx, make(chan substrate.Message, 1), messages)
+	}()
+
+	messages <- message.FromString("1")
+	require.Equal(t, multi.ErrAllSinksFailed, <-errs)
+}
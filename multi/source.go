@@ -3,10 +3,13 @@ package multi
 import (
 	"context"
 	"fmt"
+	"sync"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/message"
 	"github.com/uw-labs/sync/rungroup"
 )
 
@@ -33,6 +36,7 @@ type multiSource struct {
 // ConsumeMessages starts to consume messages from all the underlying sources and forwards acknowledgements
 // to the appropriate one. It terminates as soon as any of the underlying sources does or when the context is cancelled.
 func (s multiSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "multi"})
 	toSources := make([]chan<- substrate.Message, len(s.sources))
 
 	rg, ctx := rungroup.New(ctx)
@@ -54,6 +58,7 @@ func (s multiSource) ConsumeMessages(ctx context.Context, messages chan<- substr
 						index: index,
 						msg:   msg,
 					}
+					emit.Publish(tMsg)
 					select {
 					case <-ctx.Done():
 						return nil
@@ -69,7 +74,7 @@ func (s multiSource) ConsumeMessages(ctx context.Context, messages chan<- substr
 		})
 	}
 	// Forward acks to the correct source.
-	rg.Go(func() error {
+	rg.Go(emit.Errors(func() error {
 		for {
 			select {
 			case <-ctx.Done():
@@ -79,6 +84,7 @@ func (s multiSource) ConsumeMessages(ctx context.Context, messages chan<- substr
 				if !ok {
 					return errors.Errorf("unexpected message type: %T", msg)
 				}
+				emit.Ack(tMsg)
 				ackChan := toSources[tMsg.index]
 				select {
 				case <-ctx.Done():
@@ -87,7 +93,7 @@ func (s multiSource) ConsumeMessages(ctx context.Context, messages chan<- substr
 				}
 			}
 		}
-	})
+	}))
 
 	return rg.Wait()
 }
@@ -121,6 +127,128 @@ func (s multiSource) Status() (status *substrate.Status, err error) {
 	return status, err
 }
 
+// NewFanInSource returns an instance of substrate.AsyncMessageSource that merges messages from all of the provided
+// message sources and routes acknowledgements back to the source the message came from. Unlike the source returned
+// by NewAsyncMessageSource, it waits for all the underlying sources to shut down before returning and reports the
+// errors of all of them. A source that terminates without an error doesn't stop the others, messages from it that
+// are acknowledged afterwards are ignored. It returns an error if no message sources are provided.
+func NewFanInSource(sources ...substrate.AsyncMessageSource) (substrate.AsyncMessageSource, error) {
+	if len(sources) == 0 {
+		return nil, ErrNoMessageSources
+	}
+	return fanInSource{
+		multiSource: multiSource{sources: sources},
+	}, nil
+}
+
+// fanInSource implements substrate.AsyncMessageSource that merges messages from multiple sources.
+type fanInSource struct {
+	multiSource
+}
+
+// ConsumeMessages starts to consume messages from all the underlying sources and forwards acknowledgements
+// to the appropriate one. It terminates when the context is cancelled, when any of the underlying sources fails
+// or when all of them are finished, and returns the errors of all the sources that failed.
+func (s fanInSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "multi"})
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		errMutex sync.Mutex
+		result   *multierror.Error
+	)
+	fail := func(err error) {
+		errMutex.Lock()
+		result = multierror.Append(result, err)
+		errMutex.Unlock()
+		cancel()
+	}
+
+	toSources := make([]chan<- substrate.Message, len(s.sources))
+	finished := make([]<-chan struct{}, len(s.sources))
+
+	var wg, sourcesWG sync.WaitGroup
+	for i, source := range s.sources {
+		index, source := i, source
+		sourceAcks := make(chan substrate.Message)
+		sourceMsgs := make(chan substrate.Message)
+		done := make(chan struct{})
+		toSources[index], finished[index] = sourceAcks, done
+
+		// Start consuming source.
+		sourcesWG.Add(1)
+		go func() {
+			defer sourcesWG.Done()
+			defer close(done)
+			if err := source.ConsumeMessages(ctx, sourceMsgs, sourceAcks); err != nil {
+				fail(errors.Wrapf(err, "source %d", index))
+			}
+		}()
+
+		// Annotate messages with the index of the source they come from
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-done:
+					return
+				case msg := <-sourceMsgs:
+					tMsg := &sourceMessage{
+						index: index,
+						msg:   msg,
+					}
+					emit.Publish(tMsg)
+					select {
+					case <-ctx.Done():
+						return
+					case messages <- tMsg:
+					}
+				}
+			}
+		}()
+	}
+
+	allFinished := make(chan struct{})
+	go func() {
+		sourcesWG.Wait()
+		close(allFinished)
+	}()
+
+	// Forward acks to the correct source.
+forwardAcks:
+	for {
+		select {
+		case <-ctx.Done():
+			break forwardAcks
+		case <-allFinished:
+			break forwardAcks
+		case msg := <-acks:
+			tMsg, ok := msg.(*sourceMessage)
+			if !ok {
+				fail(emit.Error(errors.Errorf("unexpected message type: %T", msg)))
+				break forwardAcks
+			}
+			emit.Ack(tMsg)
+			select {
+			case <-ctx.Done():
+				break forwardAcks
+			case <-finished[tMsg.index]:
+			case toSources[tMsg.index] <- tMsg.msg:
+			}
+		}
+	}
+
+	cancel()
+	wg.Wait()
+	<-allFinished
+
+	return result.ErrorOrNil()
+}
+
 type sourceMessage struct {
 	index int
 	msg   substrate.Message
@@ -135,3 +263,15 @@ func (m *sourceMessage) DiscardPayload() {
 func (m *sourceMessage) Data() []byte {
 	return m.msg.Data()
 }
+
+func (m *sourceMessage) Headers() map[string]string {
+	return message.Headers(m.msg)
+}
+
+func (m *sourceMessage) ContentType() string {
+	return message.ContentType(m.msg)
+}
+
+func (m *sourceMessage) CarriedEnvelope() (message.Envelope, bool) {
+	return message.EnvelopeOf(m.msg)
+}
@@ -5,6 +5,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 
 	"github.com/uw-labs/substrate"
@@ -114,3 +116,148 @@ func TestMultiMessageSource_Status(t *testing.T) {
 	require.False(t, status.Working)
 	require.Len(t, status.Problems, 2)
 }
+
+type finishingSource struct {
+	substrate.AsyncMessageSource
+	err error
+}
+
+func (s finishingSource) ConsumeMessages(context.Context, chan<- substrate.Message, <-chan substrate.Message) error {
+	return s.err
+}
+
+func TestNewFanInSource_Error(t *testing.T) {
+	_, err := multi.NewFanInSource()
+	require.Equal(t, multi.ErrNoMessageSources, err)
+}
+
+func TestFanInSource_ConsumeMessages(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source, err := multi.NewFanInSource(
+		&mock.AsyncMessageSource{
+			Messages: []substrate.Message{
+				message.FromString("1.1"),
+				message.FromString("1.2"),
+			},
+		},
+		finishingSource{},
+		&mock.AsyncMessageSource{
+			Messages: []substrate.Message{
+				message.FromString("3.1"),
+				message.FromString("3.2"),
+				message.FromString("3.3"),
+			},
+		},
+	)
+	require.NoError(t, err)
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	consumed := make([]substrate.Message, 5)
+	for i := 0; i < len(consumed); i++ {
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "failed to consume all messages")
+		case consumed[i] = <-messages:
+		}
+	}
+	for i := 0; i < len(consumed); i++ {
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "failed to acknowledge all messages")
+		case acks <- consumed[i]:
+		}
+	}
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestFanInSource_ConsumeMessagesErrors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	err1, err2 := errors.New("source error 1"), errors.New("source error 2")
+	source, err := multi.NewFanInSource(
+		finishingSource{err: err1},
+		&mock.AsyncMessageSource{},
+		finishingSource{err: err2},
+	)
+	require.NoError(t, err)
+
+	err = source.ConsumeMessages(ctx, make(chan substrate.Message), make(chan substrate.Message))
+	require.Error(t, err)
+	mErr, ok := err.(*multierror.Error)
+	require.True(t, ok)
+	require.Len(t, mErr.Errors, 2)
+	require.ElementsMatch(t, []error{err1, err2}, []error{
+		errors.Cause(mErr.Errors[0]),
+		errors.Cause(mErr.Errors[1]),
+	})
+	require.NoError(t, ctx.Err())
+}
+
+func TestFanInSource_ConsumeMessagesAllFinished(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source, err := multi.NewFanInSource(finishingSource{}, finishingSource{})
+	require.NoError(t, err)
+
+	require.NoError(t, source.ConsumeMessages(ctx, make(chan substrate.Message), make(chan substrate.Message)))
+	require.NoError(t, ctx.Err())
+}
+
+// metadataMessage is a message reporting headers and a content type.
+type metadataMessage struct {
+	*message.Message
+	headers     map[string]string
+	contentType string
+}
+
+func (m *metadataMessage) Headers() map[string]string {
+	return m.headers
+}
+
+func (m *metadataMessage) ContentType() string {
+	return m.contentType
+}
+
+func TestFanInSource_Metadata(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source, err := multi.NewFanInSource(&mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			&metadataMessage{
+				Message:     message.FromString("1"),
+				headers:     map[string]string{"traceparent": "00-trace-span-01"},
+				contentType: "text/plain",
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	// Delivered messages report the headers and content type of the messages of the sources.
+	msg := <-messages
+	require.Equal(t, map[string]string{"traceparent": "00-trace-span-01"}, message.Headers(msg))
+	require.Equal(t, "text/plain", message.ContentType(msg))
+	acks <- msg
+
+	cancel()
+	require.NoError(t, <-errs)
+}
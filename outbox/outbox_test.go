@@ -0,0 +1,211 @@
+package outbox_test
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/outbox"
+)
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	store := outbox.NewMemoryStore()
+	for i := 0; i < 5; i++ {
+		store.Insert(fmt.Sprintf("order-%d", i%2), []byte(fmt.Sprintf("event-%d", i)))
+	}
+
+	broker := mem.NewBroker()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- outbox.Run(ctx, store, broker.NewAsyncMessageSink("events"),
+			outbox.WithBatchSize(2),
+			outbox.WithPollInterval(time.Millisecond*10),
+		)
+	}()
+
+	require.Eventually(t, func() bool {
+		return len(store.Unsent()) == 0
+	}, time.Second, time.Millisecond*10)
+
+	// Rows inserted while relaying are picked up on the next poll.
+	store.Insert("order-0", []byte("event-5"))
+	require.Eventually(t, func() bool {
+		return len(store.Unsent()) == 0
+	}, time.Second, time.Millisecond*10)
+
+	cancel()
+	require.NoError(t, <-errs)
+
+	var published []string
+	for _, data := range broker.Messages("events") {
+		published = append(published, string(data))
+	}
+	require.Equal(t, []string{"event-0", "event-1", "event-2", "event-3", "event-4", "event-5"}, published)
+}
+
+func TestSource_MarksAcknowledgedRowsOnStop(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	store := outbox.NewMemoryStore()
+	store.Insert("order-1", []byte("event-1"))
+	store.Insert("order-1", []byte("event-2"))
+
+	gaugeOpts := prometheus.GaugeOpts{Name: "outbox_lag_seconds", Help: "outbox_lag_seconds"}
+	source := outbox.NewAsyncMessageSource(store, outbox.WithPollInterval(time.Hour), outbox.WithLagGauge(gaugeOpts))
+	gauge := prometheus.Register(prometheus.NewGauge(gaugeOpts)).(prometheus.AlreadyRegisteredError).ExistingCollector.(prometheus.Gauge)
+
+	consumeCtx, stop := context.WithCancel(ctx)
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(consumeCtx, messages, acks)
+	}()
+
+	first := <-messages
+	require.Equal(t, "order-1", message.Headers(first)[outbox.AggregateIDHeader])
+	require.Equal(t, "event-2", string((<-messages).Data()))
+	require.Greater(t, testutil.ToFloat64(gauge), float64(0))
+	acks <- first
+
+	stop()
+	require.NoError(t, <-errs)
+	require.Equal(t, []int64{2}, store.Unsent())
+}
+
+func TestSource_OutOfOrderAck(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	store := outbox.NewMemoryStore()
+	store.Insert("order-1", []byte("event-1"))
+	store.Insert("order-1", []byte("event-2"))
+	source := outbox.NewAsyncMessageSource(store)
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	<-messages
+	acks <- <-messages
+	require.Error(t, <-errs)
+	require.Len(t, store.Unsent(), 2)
+}
+
+// uncommittedStore hides the rows of the transactions that didn't commit yet.
+type uncommittedStore struct {
+	*outbox.MemoryStore
+
+	mutex       sync.Mutex
+	uncommitted map[int64]bool
+}
+
+func (s *uncommittedStore) Fetch(ctx context.Context, after int64, limit int) ([]outbox.Row, error) {
+	rows, err := s.MemoryStore.Fetch(ctx, after, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var committed []outbox.Row
+	for _, row := range rows {
+		if !s.uncommitted[row.ID] {
+			committed = append(committed, row)
+		}
+	}
+	return committed, nil
+}
+
+func (s *uncommittedStore) commit(id int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.uncommitted, id)
+}
+
+func TestRun_LateCommit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	store := &uncommittedStore{MemoryStore: outbox.NewMemoryStore(), uncommitted: make(map[int64]bool)}
+	for i := 1; i <= 3; i++ {
+		store.Insert("order-1", []byte(fmt.Sprintf("event-%d", i)))
+	}
+	// The second row commits after the third one.
+	store.uncommitted[2] = true
+
+	broker := mem.NewBroker()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- outbox.Run(ctx, store, broker.NewAsyncMessageSink("events"), outbox.WithPollInterval(time.Millisecond*10))
+	}()
+
+	require.Eventually(t, func() bool {
+		unsent := store.Unsent()
+		return len(unsent) == 1 && unsent[0] == 2
+	}, time.Second, time.Millisecond*10)
+
+	store.commit(2)
+	require.Eventually(t, func() bool {
+		return len(store.Unsent()) == 0
+	}, time.Second, time.Millisecond*10)
+
+	cancel()
+	require.NoError(t, <-errs)
+
+	// The late row isn't skipped, but it is published after the row with a greater ID, as the transactions
+	// inserting the rows of the aggregate weren't serialized.
+	var published []string
+	for _, data := range broker.Messages("events") {
+		published = append(published, string(data))
+	}
+	require.Equal(t, []string{"event-1", "event-3", "event-2"}, published)
+}
+
+func TestSQLStore(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := outbox.NewSQLStore(db, "outbox", outbox.WithPlaceholder(outbox.Dollar))
+
+	createdAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(
+		"SELECT id, aggregate_id, payload, created_at FROM outbox WHERE sent_at IS NULL AND id > $1 ORDER BY id LIMIT $2",
+	)).WithArgs(10, 2).WillReturnRows(sqlmock.NewRows([]string{"id", "aggregate_id", "payload", "created_at"}).
+		AddRow(11, "order-1", []byte("event-1"), createdAt).
+		AddRow(12, "order-2", []byte("event-2"), createdAt))
+
+	rows, err := store.Fetch(ctx, 10, 2)
+	require.NoError(t, err)
+	require.Equal(t, []outbox.Row{
+		{ID: 11, AggregateID: "order-1", Payload: []byte("event-1"), CreatedAt: createdAt},
+		{ID: 12, AggregateID: "order-2", Payload: []byte("event-2"), CreatedAt: createdAt},
+	}, rows)
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE outbox SET sent_at = $1 WHERE id IN ($2, $3)")).
+		WithArgs(sqlmock.AnyArg(), 11, 12).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	require.NoError(t, store.MarkSent(ctx, []int64{11, 12}))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
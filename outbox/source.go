@@ -0,0 +1,255 @@
+// Package outbox provides a relay for the transactional outbox pattern: services insert the messages they
+// want to publish into an outbox table, in the same transaction as their state changes, and the relay
+// tails the table and publishes the rows through a substrate sink.
+package outbox
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/bridge"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// AggregateIDHeader is the header carrying the aggregate ID of messages relayed from the outbox.
+const AggregateIDHeader = "aggregate-id"
+
+const (
+	defaultBatchSize    = 100
+	defaultPollInterval = time.Second
+)
+
+// Message is a message relayed from the outbox.
+type Message struct {
+	Row
+}
+
+// Data returns the payload of the row.
+func (msg *Message) Data() []byte {
+	return msg.Payload
+}
+
+// Headers returns the aggregate ID of the row in the AggregateIDHeader header.
+func (msg *Message) Headers() map[string]string {
+	return map[string]string{AggregateIDHeader: msg.AggregateID}
+}
+
+// Option is a function which sets an outbox source configuration option.
+type Option func(s *outboxSource)
+
+// WithBatchSize sets the maximum number of rows fetched at once, which is also the maximum number of rows
+// awaiting acknowledgement and the number of acknowledged rows marked as sent at once. The default is 100.
+func WithBatchSize(size int) Option {
+	return func(s *outboxSource) {
+		s.batchSize = size
+	}
+}
+
+// WithPollInterval sets how long to wait before fetching rows again once the outbox is empty, which is also
+// the longest acknowledged rows wait before being marked as sent. The default is one second.
+func WithPollInterval(interval time.Duration) Option {
+	return func(s *outboxSource) {
+		s.pollInterval = interval
+	}
+}
+
+// WithLagGauge sets a gauge that is updated with the age, in seconds, of the oldest row that was fetched
+// but not acknowledged yet, or 0 once all fetched rows are acknowledged. It panics in case it can't register
+// the metric.
+func WithLagGauge(gaugeOpts prometheus.GaugeOpts) Option {
+	return func(s *outboxSource) {
+		s.lag = metrics.Register(prometheus.NewGauge(gaugeOpts)).(prometheus.Gauge)
+	}
+}
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that delivers the rows of the
+// outbox as messages of type Message, fetching them in ID order. Rows committed after rows with a greater ID,
+// for instance by a longer transaction, are delivered once the source caught up with the outbox, as it then
+// polls again from the lowest unsent ID, so they are delivered after rows with a greater ID. Rows are therefore
+// only delivered in ID order as long as they are committed in ID order, and the messages of an aggregate are
+// only delivered in the order they were inserted if the transactions inserting them are serialized, e.g. by
+// locking the aggregate. Acknowledged rows are marked as sent, in batches, so rows acknowledged just before the
+// source stops may be delivered again by the next one. Messages must be acknowledged in order. A single source
+// should consume an outbox at a time, as concurrent sources would deliver the same rows.
+func NewAsyncMessageSource(store Store, opts ...Option) substrate.AsyncMessageSource {
+	s := &outboxSource{
+		store:        store,
+		batchSize:    defaultBatchSize,
+		pollInterval: defaultPollInterval,
+		now:          time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Run relays the rows of the outbox to the sink until the context is cancelled or either of them fails. Rows
+// are published in the order they are delivered by NewAsyncMessageSource, and marked as sent once the sink
+// acknowledged them. It returns nil if it is stopped by cancelling the context.
+func Run(ctx context.Context, store Store, sink substrate.AsyncMessageSink, opts ...Option) error {
+	return bridge.Run(ctx, NewAsyncMessageSource(store, opts...), sink, bridge.Options{})
+}
+
+type outboxSource struct {
+	store        Store
+	batchSize    int
+	pollInterval time.Duration
+	lag          prometheus.Gauge
+	now          func() time.Time
+
+	mutex   sync.Mutex
+	pending []*Message
+	// delivered holds the IDs of the rows delivered but not marked as sent yet, which are skipped when polling
+	// again from the lowest unsent ID.
+	delivered map[int64]struct{}
+}
+
+func (s *outboxSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "outbox"})
+	rg, ctx := rungroup.New(ctx)
+
+	inFlight := make(chan struct{}, s.batchSize)
+	s.mutex.Lock()
+	s.pending = nil
+	s.delivered = make(map[int64]struct{})
+	s.mutex.Unlock()
+
+	rg.Go(emit.Errors(func() error {
+		var after int64
+		for {
+			rows, err := s.store.Fetch(ctx, after, s.batchSize)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			if len(rows) == 0 {
+				// rows with a lower ID may have been committed since they were polled
+				after = 0
+				s.updateLag()
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(s.pollInterval):
+				}
+				continue
+			}
+
+			for _, row := range rows {
+				after = row.ID
+				s.mutex.Lock()
+				_, delivered := s.delivered[row.ID]
+				s.mutex.Unlock()
+				if delivered {
+					continue
+				}
+
+				select {
+				case <-ctx.Done():
+					return nil
+				case inFlight <- struct{}{}:
+				}
+				msg := &Message{Row: row}
+				s.mutex.Lock()
+				s.pending = append(s.pending, msg)
+				s.delivered[row.ID] = struct{}{}
+				s.mutex.Unlock()
+				s.updateLag()
+
+				emit.Publish(msg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case messages <- msg:
+				}
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		ticker := time.NewTicker(s.pollInterval)
+		defer ticker.Stop()
+
+		var sent []int64
+		markSent := func(ctx context.Context) error {
+			if len(sent) == 0 {
+				return nil
+			}
+			if err := s.store.MarkSent(ctx, sent); err != nil {
+				return err
+			}
+			s.mutex.Lock()
+			for _, id := range sent {
+				delete(s.delivered, id)
+			}
+			s.mutex.Unlock()
+			sent = nil
+			return nil
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				// rows acknowledged so far are still marked as sent, to limit duplicates on restart
+				return markSent(context.WithoutCancel(ctx))
+			case <-ticker.C:
+				if err := markSent(ctx); err != nil {
+					return err
+				}
+			case ack := <-acks:
+				s.mutex.Lock()
+				if len(s.pending) == 0 || ack != substrate.Message(s.pending[0]) {
+					s.mutex.Unlock()
+					return errors.Errorf("unexpected message acknowledged: %v", ack)
+				}
+				s.pending = s.pending[1:]
+				s.mutex.Unlock()
+				s.updateLag()
+				<-inFlight
+				emit.Ack(ack)
+
+				sent = append(sent, ack.(*Message).ID)
+				if len(sent) >= s.batchSize {
+					if err := markSent(ctx); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+// updateLag sets the lag gauge to the age of the oldest pending row.
+func (s *outboxSource) updateLag() {
+	if s.lag == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.pending) == 0 {
+		s.lag.Set(0)
+		return
+	}
+	s.lag.Set(s.now().Sub(s.pending[0].CreatedAt).Seconds())
+}
+
+// Close does nothing, the store is owned by the caller.
+func (s *outboxSource) Close() error {
+	return nil
+}
+
+// Status reports that the source is working, as failures to access the store make ConsumeMessages return.
+func (s *outboxSource) Status() (*substrate.Status, error) {
+	return &substrate.Status{Working: true}, nil
+}
@@ -0,0 +1,186 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Row is a message waiting in the outbox.
+type Row struct {
+	// ID orders the rows of the outbox, rows are fetched in increasing ID order.
+	ID int64
+	// AggregateID identifies the entity the message is about, such as an order.
+	AggregateID string
+	// Payload is the payload of the message.
+	Payload []byte
+	// CreatedAt is the time at which the row was inserted.
+	CreatedAt time.Time
+}
+
+// Store gives access to the rows of an outbox.
+type Store interface {
+	// Fetch returns up to limit rows that weren't marked as sent, with an ID greater than after, ordered by ID.
+	Fetch(ctx context.Context, after int64, limit int) ([]Row, error)
+	// MarkSent marks the rows with the IDs as sent, so that they are never fetched again.
+	MarkSent(ctx context.Context, ids []int64) error
+}
+
+// NewMemoryStore returns an in-memory store, which is mostly useful for testing.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// MemoryStore is an in-memory outbox.
+type MemoryStore struct {
+	mutex sync.Mutex
+	rows  []Row
+	sent  map[int64]bool
+}
+
+// Insert appends a row with the aggregate ID and the payload to the outbox, returning its ID.
+func (s *MemoryStore) Insert(aggregateID string, payload []byte) int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id := int64(len(s.rows) + 1)
+	s.rows = append(s.rows, Row{ID: id, AggregateID: aggregateID, Payload: payload, CreatedAt: time.Now()})
+	return id
+}
+
+// Unsent returns the IDs of the rows that weren't marked as sent.
+func (s *MemoryStore) Unsent() []int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var ids []int64
+	for _, row := range s.rows {
+		if !s.sent[row.ID] {
+			ids = append(ids, row.ID)
+		}
+	}
+	return ids
+}
+
+// Fetch returns the unsent rows after the ID.
+func (s *MemoryStore) Fetch(_ context.Context, after int64, limit int) ([]Row, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var rows []Row
+	for _, row := range s.rows {
+		if row.ID > after && !s.sent[row.ID] && len(rows) < limit {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// MarkSent marks the rows as sent.
+func (s *MemoryStore) MarkSent(_ context.Context, ids []int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.sent == nil {
+		s.sent = make(map[int64]bool)
+	}
+	for _, id := range ids {
+		s.sent[id] = true
+	}
+	return nil
+}
+
+// Placeholder returns the query placeholder of the n-th argument, counting from 1.
+type Placeholder func(n int) string
+
+var (
+	// QuestionMark is the placeholder used by MySQL and SQLite.
+	QuestionMark Placeholder = func(int) string { return "?" }
+	// Dollar is the placeholder used by PostgreSQL.
+	Dollar Placeholder = func(n int) string { return fmt.Sprintf("$%d", n) }
+)
+
+// SQLStoreOption is a function which sets an SQL store configuration option.
+type SQLStoreOption func(s *sqlStore)
+
+// WithPlaceholder sets the placeholder used in queries. The default is QuestionMark.
+func WithPlaceholder(placeholder Placeholder) SQLStoreOption {
+	return func(s *sqlStore) {
+		s.placeholder = placeholder
+	}
+}
+
+// NewSQLStore returns a store backed by the table, which must have the following columns: an auto incremented
+// integer id, a text aggregate_id, a binary payload, a created_at timestamp and a nullable sent_at timestamp,
+// which is set once rows are sent. An index on (sent_at, id) keeps fetching efficient as the table grows.
+func NewSQLStore(db *sql.DB, table string, opts ...SQLStoreOption) Store {
+	s := &sqlStore{
+		db:          db,
+		table:       table,
+		placeholder: QuestionMark,
+		now:         time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type sqlStore struct {
+	db          *sql.DB
+	table       string
+	placeholder Placeholder
+	now         func() time.Time
+}
+
+func (s *sqlStore) Fetch(ctx context.Context, after int64, limit int) ([]Row, error) {
+	query := fmt.Sprintf(
+		"SELECT id, aggregate_id, payload, created_at FROM %s WHERE sent_at IS NULL AND id > %s ORDER BY id LIMIT %s",
+		s.table, s.placeholder(1), s.placeholder(2),
+	)
+	result, err := s.db.QueryContext(ctx, query, after, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch outbox rows")
+	}
+	defer result.Close()
+
+	var rows []Row
+	for result.Next() {
+		var row Row
+		if err := result.Scan(&row.ID, &row.AggregateID, &row.Payload, &row.CreatedAt); err != nil {
+			return nil, errors.Wrap(err, "failed to read outbox row")
+		}
+		rows = append(rows, row)
+	}
+	if err := result.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to fetch outbox rows")
+	}
+	return rows, nil
+}
+
+func (s *sqlStore) MarkSent(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, s.now().UTC())
+	placeholders := make([]string, len(ids))
+	for i, id := range ids {
+		args = append(args, id)
+		placeholders[i] = s.placeholder(i + 2)
+	}
+
+	query := fmt.Sprintf(
+		"UPDATE %s SET sent_at = %s WHERE id IN (%s)",
+		s.table, s.placeholder(1), strings.Join(placeholders, ", "),
+	)
+	if _, err := s.db.ExecContext(ctx, query, args...); err != nil {
+		return errors.Wrap(err, "failed to mark outbox rows as sent")
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package pause
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Pauser is implemented by anything that can be paused and resumed, such as AsyncMessageSource.
+type Pauser interface {
+	Pause()
+	Resume()
+	Paused() bool
+}
+
+// state is the body of the responses of the handler.
+type state struct {
+	Paused bool `json:"paused"`
+}
+
+// Handler returns an http.Handler controlling the pauser, meant to be mounted on an admin endpoint: a POST
+// request pauses it, a DELETE request resumes it and a GET request only reports whether it is paused. All of
+// them respond with the resulting state as JSON.
+func Handler(p Pauser) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			p.Pause()
+		case http.MethodDelete:
+			p.Resume()
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(state{Paused: p.Paused()})
+	})
+}
@@ -0,0 +1,195 @@
+// Package pause provides a message source wrapper that can be paused and resumed at runtime, e.g. by operators
+// halting consumption during an incident without restarting the service.
+package pause
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// Option is a function which sets an AsyncMessageSource configuration option.
+type Option func(s *AsyncMessageSource)
+
+// WithPausedGauge sets a gauge that is set to 1 while the source is paused and to 0 otherwise. It panics in case
+// it can't register the metric.
+func WithPausedGauge(gaugeOpts prometheus.GaugeOpts) Option {
+	return func(s *AsyncMessageSource) {
+		s.gauge = metrics.Register(prometheus.NewGauge(gaugeOpts)).(prometheus.Gauge)
+	}
+}
+
+// WithPaused makes the source start paused, so that nothing is delivered until it is resumed.
+func WithPaused() Option {
+	return func(s *AsyncMessageSource) {
+		s.paused = true
+	}
+}
+
+// AsyncMessageSource is an instance of substrate.AsyncMessageSource that stops delivering messages while it is
+// paused. Acknowledgements of messages delivered before it was paused are still passed on to the underlying
+// source, so that outstanding messages can be completed. It is safe to pause and resume it concurrently with
+// consuming messages.
+type AsyncMessageSource struct {
+	impl  substrate.AsyncMessageSource
+	gauge prometheus.Gauge
+
+	mutex  sync.Mutex
+	paused bool
+	// changed is closed, and replaced, whenever the source is paused or resumed.
+	changed chan struct{}
+}
+
+// NewAsyncMessageSource returns a source delivering the messages of the provided source while it isn't paused.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, opts ...Option) *AsyncMessageSource {
+	s := &AsyncMessageSource{
+		impl:    source,
+		changed: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.updateGauge()
+	return s
+}
+
+// Pause stops the delivery of messages. A message consumed from the underlying source, but not delivered yet, is
+// held until the source is resumed. Pausing a paused source has no effect.
+func (s *AsyncMessageSource) Pause() {
+	s.set(true)
+}
+
+// Resume resumes the delivery of messages. Resuming a source that isn't paused has no effect.
+func (s *AsyncMessageSource) Resume() {
+	s.set(false)
+}
+
+// Paused reports whether the source is paused.
+func (s *AsyncMessageSource) Paused() bool {
+	paused, _ := s.state()
+	return paused
+}
+
+func (s *AsyncMessageSource) set(paused bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.paused == paused {
+		return
+	}
+	s.paused = paused
+	close(s.changed)
+	s.changed = make(chan struct{})
+	s.updateGauge()
+}
+
+// state returns whether the source is paused, along with a channel that is closed once that changes.
+func (s *AsyncMessageSource) state() (bool, <-chan struct{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.paused, s.changed
+}
+
+func (s *AsyncMessageSource) updateGauge() {
+	if s.gauge == nil {
+		return
+	}
+	if s.paused {
+		s.gauge.Set(1)
+	} else {
+		s.gauge.Set(0)
+	}
+}
+
+// ConsumeMessages consumes messages from the underlying source, delivering them only while the source isn't
+// paused.
+func (s *AsyncMessageSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "pause"})
+	rg, ctx := rungroup.New(ctx)
+
+	consumed := make(chan substrate.Message)
+	rg.Go(func() error {
+		return s.impl.ConsumeMessages(ctx, consumed, acks)
+	})
+	rg.Go(func() error {
+		for {
+			if !s.waitResumed(ctx) {
+				return nil
+			}
+			var msg substrate.Message
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg = <-consumed:
+			}
+			if !s.deliver(ctx, messages, msg) {
+				return nil
+			}
+			emit.Publish(msg)
+		}
+	})
+
+	return rg.Wait()
+}
+
+// waitResumed blocks while the source is paused. It returns false if the context is cancelled first.
+func (s *AsyncMessageSource) waitResumed(ctx context.Context) bool {
+	for {
+		paused, changed := s.state()
+		if !paused {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changed:
+		}
+	}
+}
+
+// deliver delivers the message while the source isn't paused, holding it back while the source is paused.
+// It returns false if the context is cancelled first.
+func (s *AsyncMessageSource) deliver(ctx context.Context, messages chan<- substrate.Message, msg substrate.Message) bool {
+	for {
+		paused, changed := s.state()
+		if paused {
+			select {
+			case <-ctx.Done():
+				return false
+			case <-changed:
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case messages <- msg:
+			return true
+		case <-changed:
+		}
+	}
+}
+
+// Close closes the underlying source.
+func (s *AsyncMessageSource) Close() error {
+	return s.impl.Close()
+}
+
+// Status returns the status of the underlying source, reporting the pause as a problem.
+func (s *AsyncMessageSource) Status() (*substrate.Status, error) {
+	status, err := s.impl.Status()
+	if err != nil || !s.Paused() {
+		return status, err
+	}
+	return &substrate.Status{
+		Working:  status.Working,
+		Problems: append(append([]string(nil), status.Problems...), "consumption is paused"),
+	}, nil
+}
@@ -0,0 +1,139 @@
+package pause_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/conformance"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+	"github.com/uw-labs/substrate-tools/pause"
+)
+
+func messages(count int) []substrate.Message {
+	msgs := make([]substrate.Message, count)
+	for i := range msgs {
+		msgs[i] = message.FromString(fmt.Sprintf("message-%d", i))
+	}
+	return msgs
+}
+
+func TestSource_PauseResume(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	gaugeOpts := prometheus.GaugeOpts{Name: "pause_test_paused", Help: "pause_test_paused"}
+	backend := mock.NewSource(messages(3)...)
+	source := pause.NewAsyncMessageSource(backend, pause.WithPausedGauge(gaugeOpts))
+	gauge := prometheus.Register(prometheus.NewGauge(gaugeOpts)).(prometheus.AlreadyRegisteredError).ExistingCollector.(prometheus.Gauge)
+
+	msgs, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, msgs, acks)
+	}()
+
+	first := <-msgs
+	source.Pause()
+	require.True(t, source.Paused())
+	require.Equal(t, float64(1), testutil.ToFloat64(gauge))
+
+	// Outstanding messages can still be acknowledged while paused, but nothing else is delivered.
+	acks <- first
+	require.Eventually(t, func() bool {
+		return len(backend.Acked()) == 1
+	}, time.Second, time.Millisecond*5)
+	select {
+	case msg := <-msgs:
+		require.FailNow(t, "message delivered while paused", "%s", msg.Data())
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	status, err := source.Status()
+	require.NoError(t, err)
+	require.Contains(t, status.Problems, "consumption is paused")
+
+	source.Resume()
+	require.False(t, source.Paused())
+	require.Equal(t, float64(0), testutil.ToFloat64(gauge))
+	for i := 1; i < 3; i++ {
+		msg := <-msgs
+		require.Equal(t, fmt.Sprintf("message-%d", i), string(msg.Data()))
+		acks <- msg
+	}
+
+	select {
+	case <-backend.AllAcked():
+	case err := <-errs:
+		require.FailNow(t, "consuming failed", "%v", err)
+	}
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestSource_StartPaused(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source := pause.NewAsyncMessageSource(mock.NewSource(messages(1)...), pause.WithPaused())
+	require.True(t, source.Paused())
+
+	msgs := make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, msgs, make(chan substrate.Message))
+	}()
+
+	select {
+	case <-msgs:
+		require.FailNow(t, "message delivered while paused")
+	case <-time.After(time.Millisecond * 50):
+	}
+	source.Resume()
+	require.Equal(t, "message-0", string((<-msgs).Data()))
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestSource_Conformance(t *testing.T) {
+	conformance.TestAsyncSource(t, func(_ *testing.T, backend substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		return pause.NewAsyncMessageSource(backend)
+	})
+}
+
+func TestHandler(t *testing.T) {
+	source := pause.NewAsyncMessageSource(mock.NewSource())
+	handler := pause.Handler(source)
+
+	serve := func(method string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, "/admin/pause", nil))
+		return rec
+	}
+
+	rec := serve(http.MethodPost)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"paused": true}`, rec.Body.String())
+	require.True(t, source.Paused())
+
+	rec = serve(http.MethodGet)
+	require.JSONEq(t, `{"paused": true}`, rec.Body.String())
+
+	rec = serve(http.MethodDelete)
+	require.JSONEq(t, `{"paused": false}`, rec.Body.String())
+	require.False(t, source.Paused())
+
+	rec = serve(http.MethodPut)
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	require.Equal(t, "GET, POST, DELETE", rec.Header().Get("Allow"))
+}
@@ -0,0 +1,131 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/uw-labs/substrate-tools/middleware"
+)
+
+// Kinds of the nodes of a graph.
+const (
+	KindSource           = "source"
+	KindSourceMiddleware = "source_middleware"
+	KindStage            = "stage"
+	KindSinkMiddleware   = "sink_middleware"
+	KindSink             = "sink"
+)
+
+// Graph describes the wiring of a pipeline, in the order in which messages flow through it.
+type Graph struct {
+	Nodes []Node `json:"nodes"`
+	Edges []Edge `json:"edges"`
+}
+
+// Node is a node of a graph.
+type Node struct {
+	// ID identifies the node within the graph.
+	ID string `json:"id"`
+	// Kind is one of the kinds of nodes, e.g. KindStage.
+	Kind string `json:"kind"`
+	// Name is the name of the stage or the middleware, or the type of the source or the sink.
+	Name string `json:"name"`
+	// Workers is the number of messages a stage transforms concurrently.
+	Workers int `json:"workers,omitempty"`
+	// Buffer is the number of transformed messages a stage can hold.
+	Buffer int `json:"buffer,omitempty"`
+}
+
+// Edge is an edge of a graph, from the node passing messages on to the node receiving them.
+type Edge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Graph returns the wiring of the pipeline. Middlewares wrapping the source or the sink are only included if they
+// were named, using middleware.NamedSource and middleware.NamedSink, as done by the config package, otherwise they
+// are described as part of the source or the sink.
+func (p *Pipeline) Graph() Graph {
+	var g Graph
+	add := func(n Node) {
+		if len(g.Nodes) > 0 {
+			g.Edges = append(g.Edges, Edge{From: g.Nodes[len(g.Nodes)-1].ID, To: n.ID})
+		}
+		g.Nodes = append(g.Nodes, n)
+	}
+
+	if p.source != nil {
+		names, source := middleware.SourceNames(p.source)
+		add(Node{ID: KindSource, Kind: KindSource, Name: fmt.Sprintf("%T", source)})
+		// Consumed messages pass through the innermost middleware first.
+		for i := len(names) - 1; i >= 0; i-- {
+			add(Node{ID: fmt.Sprintf("%s-%d", KindSourceMiddleware, i), Kind: KindSourceMiddleware, Name: names[i]})
+		}
+	}
+	for i, st := range p.stages {
+		add(Node{
+			ID:      fmt.Sprintf("%s-%d", KindStage, i),
+			Kind:    KindStage,
+			Name:    st.name,
+			Workers: st.workers,
+			Buffer:  p.bufferSize,
+		})
+	}
+	if p.sink != nil {
+		names, sink := middleware.SinkNames(p.sink)
+		for i, name := range names {
+			add(Node{ID: fmt.Sprintf("%s-%d", KindSinkMiddleware, i), Kind: KindSinkMiddleware, Name: name})
+		}
+		add(Node{ID: KindSink, Kind: KindSink, Name: fmt.Sprintf("%T", sink)})
+	}
+	return g
+}
+
+// DOT returns the graph in the DOT language, so that it can be rendered using graphviz.
+func (g Graph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph pipeline {\n\trankdir=LR;\n")
+	for _, n := range g.Nodes {
+		label, attrs := n.Name, ""
+		switch n.Kind {
+		case KindSource, KindSink:
+			attrs = " shape=cylinder"
+		case KindSourceMiddleware, KindSinkMiddleware:
+			attrs = " shape=box style=dashed"
+		case KindStage:
+			label = fmt.Sprintf("%s\nworkers: %d, buffer: %d", n.Name, n.Workers, n.Buffer)
+			attrs = " shape=box"
+		}
+		fmt.Fprintf(&b, "\t%s [label=%s%s];\n", strconv.Quote(n.ID), strconv.Quote(label), attrs)
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "\t%s -> %s;\n", strconv.Quote(e.From), strconv.Quote(e.To))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// GraphHandler returns an http.Handler serving the graph of the pipeline on GET requests, as JSON, or in the DOT
+// language if the format query parameter is "dot".
+func GraphHandler(p *Pipeline) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		switch format := r.URL.Query().Get("format"); format {
+		case "", "json":
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(p.Graph())
+		case "dot":
+			w.Header().Set("Content-Type", "text/vnd.graphviz")
+			_, _ = w.Write([]byte(p.Graph().DOT()))
+		default:
+			http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package pipeline_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/middleware"
+	"github.com/uw-labs/substrate-tools/pipeline"
+)
+
+func identitySource(source substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+	return source
+}
+
+func identitySink(sink substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+	return sink
+}
+
+func newGraphPipeline() *pipeline.Pipeline {
+	source := middleware.Chain(
+		middleware.NamedSource("decompress", identitySource),
+		middleware.NamedSource("instrumented", identitySource),
+	)(newRecordingSource())
+	sink := middleware.NamedSink("compress", identitySink)(mem.NewBroker().NewAsyncMessageSink("out"))
+
+	return pipeline.From(source).
+		Stage("decode", nil).
+		FanOut(4).
+		Buffer(10).
+		Transform(nil).
+		To(sink)
+}
+
+func TestPipeline_Graph(t *testing.T) {
+	g := newGraphPipeline().Graph()
+
+	require.Equal(t, []pipeline.Node{
+		{ID: "source", Kind: pipeline.KindSource, Name: "*pipeline_test.recordingSource"},
+		{ID: "source_middleware-1", Kind: pipeline.KindSourceMiddleware, Name: "instrumented"},
+		{ID: "source_middleware-0", Kind: pipeline.KindSourceMiddleware, Name: "decompress"},
+		{ID: "stage-0", Kind: pipeline.KindStage, Name: "decode", Workers: 1, Buffer: 10},
+		{ID: "stage-1", Kind: pipeline.KindStage, Name: "transform-2", Workers: 4, Buffer: 10},
+		{ID: "sink_middleware-0", Kind: pipeline.KindSinkMiddleware, Name: "compress"},
+		{ID: "sink", Kind: pipeline.KindSink, Name: "*mem.memSink"},
+	}, g.Nodes)
+	require.Equal(t, []pipeline.Edge{
+		{From: "source", To: "source_middleware-1"},
+		{From: "source_middleware-1", To: "source_middleware-0"},
+		{From: "source_middleware-0", To: "stage-0"},
+		{From: "stage-0", To: "stage-1"},
+		{From: "stage-1", To: "sink_middleware-0"},
+		{From: "sink_middleware-0", To: "sink"},
+	}, g.Edges)
+
+	require.Equal(t, `digraph pipeline {
+	rankdir=LR;
+	"source" [label="*pipeline_test.recordingSource" shape=cylinder];
+	"source_middleware-1" [label="instrumented" shape=box style=dashed];
+	"source_middleware-0" [label="decompress" shape=box style=dashed];
+	"stage-0" [label="decode\nworkers: 1, buffer: 10" shape=box];
+	"stage-1" [label="transform-2\nworkers: 4, buffer: 10" shape=box];
+	"sink_middleware-0" [label="compress" shape=box style=dashed];
+	"sink" [label="*mem.memSink" shape=cylinder];
+	"source" -> "source_middleware-1";
+	"source_middleware-1" -> "source_middleware-0";
+	"source_middleware-0" -> "stage-0";
+	"stage-0" -> "stage-1";
+	"stage-1" -> "sink_middleware-0";
+	"sink_middleware-0" -> "sink";
+}
+`, g.DOT())
+}
+
+func TestGraphHandler(t *testing.T) {
+	p := newGraphPipeline()
+	handler := pipeline.GraphHandler(p)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var g pipeline.Graph
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &g))
+	require.Equal(t, p.Graph(), g)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?format=dot", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "text/vnd.graphviz", w.Header().Get("Content-Type"))
+	require.Equal(t, p.Graph().DOT(), w.Body.String())
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?format=svg", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
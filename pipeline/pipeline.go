@@ -0,0 +1,337 @@
+// Package pipeline provides a builder wiring a substrate source, a sequence of transformation stages and a substrate
+// sink together, taking care of the acknowledgements, bounded buffering between stages and error handling.
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/internal/inorder"
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+const (
+	resultOK      = "ok"
+	resultDropped = "dropped"
+	resultError   = "error"
+
+	sinkStage = "sink"
+)
+
+// TransformFunc transforms a message. The returned message is passed on to the next stage instead of the message,
+// or the message is dropped if it returns nil, in which case it is acknowledged to the source without being
+// published. An error returned by it stops the pipeline.
+type TransformFunc func(ctx context.Context, msg substrate.Message) (substrate.Message, error)
+
+// Pipeline is a builder of a pipeline consuming messages from a source, passing them through its stages in order
+// and publishing them to a sink. Messages are only acknowledged to the source once the sink acknowledged them, or
+// once they were dropped, in the order in which they were consumed. The methods of the builder are not safe for
+// concurrent use, and it must not be modified once it is running.
+type Pipeline struct {
+	source     substrate.AsyncMessageSource
+	sink       substrate.AsyncMessageSink
+	stages     []stage
+	workers    int
+	bufferSize int
+	counter    *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+	err        error
+}
+
+type stage struct {
+	name      string
+	transform TransformFunc
+	workers   int
+}
+
+// From starts building a pipeline consuming messages from the source.
+func From(source substrate.AsyncMessageSource) *Pipeline {
+	return &Pipeline{
+		source:  source,
+		workers: 1,
+	}
+}
+
+// Transform adds a stage transforming messages using the function. The stage is named after its position in the
+// pipeline, e.g. "transform-1" for the first one.
+func (p *Pipeline) Transform(f TransformFunc) *Pipeline {
+	return p.Stage(fmt.Sprintf("transform-%d", len(p.stages)+1), f)
+}
+
+// Stage adds a stage with the given name transforming messages using the function. The name is used to label
+// the metrics of the stage and the errors returned by it.
+func (p *Pipeline) Stage(name string, f TransformFunc) *Pipeline {
+	p.stages = append(p.stages, stage{name: name, transform: f, workers: p.workers})
+	return p
+}
+
+// FanOut sets the number of messages transformed concurrently by each of the stages added after it. Messages
+// leave every stage in the order in which they entered it, regardless of the number of workers. The default
+// value is 1.
+func (p *Pipeline) FanOut(workers int) *Pipeline {
+	if workers < 1 {
+		p.setErr(errors.Errorf("invalid number of workers: %d", workers))
+		return p
+	}
+	p.workers = workers
+	return p
+}
+
+// Buffer sets the number of transformed messages each stage can hold, in addition to those being transformed,
+// while the next stage isn't ready to receive them. Once the buffer is full, the stage stops receiving messages,
+// which eventually stops consumption from the source. The default value is 0.
+func (p *Pipeline) Buffer(size int) *Pipeline {
+	if size < 0 {
+		p.setErr(errors.Errorf("invalid buffer size: %d", size))
+		return p
+	}
+	p.bufferSize = size
+	return p
+}
+
+// WithStageCounter sets a counter that is incremented for every message processed by a stage, labelled with
+// the name of the stage and the result, which is one of "ok", "dropped" and "error". Messages acknowledged by
+// the sink are counted under the "sink" stage.
+func (p *Pipeline) WithStageCounter(counterOpts prometheus.CounterOpts) *Pipeline {
+	p.counter = metrics.Register(prometheus.NewCounterVec(counterOpts, []string{"stage", "result"})).(*prometheus.CounterVec)
+	return p
+}
+
+// WithStageDurationHistogram sets a histogram that observes how long each stage took to transform a message,
+// labelled with the name of the stage.
+func (p *Pipeline) WithStageDurationHistogram(histogramOpts prometheus.HistogramOpts) *Pipeline {
+	p.duration = metrics.Register(prometheus.NewHistogramVec(histogramOpts, []string{"stage"})).(*prometheus.HistogramVec)
+	return p
+}
+
+// To sets the sink the messages are published to.
+func (p *Pipeline) To(sink substrate.AsyncMessageSink) *Pipeline {
+	p.sink = sink
+	return p
+}
+
+func (p *Pipeline) setErr(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// item is a message passing through the pipeline, along with the consumed message it originates from.
+// The message is nil once it was dropped.
+type item struct {
+	original substrate.Message
+	msg      substrate.Message
+}
+
+// task is an item being transformed by a stage, done is closed once it was transformed.
+type task struct {
+	item *item
+	err  error
+	done chan struct{}
+}
+
+// Run runs the pipeline until the context is cancelled or either the source, the sink or a stage fails.
+// It returns nil if it is stopped by cancelling the context.
+func (p *Pipeline) Run(ctx context.Context) error {
+	switch {
+	case p.err != nil:
+		return p.err
+	case p.source == nil:
+		return errors.New("pipeline has no source")
+	case p.sink == nil:
+		return errors.New("pipeline has no sink")
+	}
+
+	rg, ctx := rungroup.New(ctx)
+
+	consumed, sourceAcks := make(chan substrate.Message), make(chan substrate.Message)
+	toPublish, sinkAcks := make(chan substrate.Message), make(chan substrate.Message)
+	queue := inorder.NewQueue[substrate.Message, substrate.Message]()
+
+	rg.Go(func() error {
+		if err := p.source.ConsumeMessages(ctx, consumed, sourceAcks); err != nil {
+			return errors.Wrap(err, "source failed")
+		}
+		return nil
+	})
+	rg.Go(func() error {
+		if err := p.sink.PublishMessages(ctx, sinkAcks, toPublish); err != nil {
+			return errors.Wrap(err, "sink failed")
+		}
+		return nil
+	})
+
+	consumedItems := make(chan *item)
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-consumed:
+				select {
+				case <-ctx.Done():
+					return nil
+				case consumedItems <- &item{original: msg, msg: msg}:
+				}
+			}
+		}
+	})
+
+	in := (<-chan *item)(consumedItems)
+	for _, st := range p.stages {
+		st, stageIn, stageOut := st, in, make(chan *item)
+		rg.Go(func() error {
+			return p.runStage(ctx, st, stageIn, stageOut)
+		})
+		in = stageOut
+	}
+
+	// Publish transformed messages, while dropped ones are acknowledged in order.
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case it := <-in:
+				if it.msg == nil {
+					queue.PushDone(it.original)
+					continue
+				}
+				queue.Push(0, it.msg, it.original)
+				select {
+				case <-ctx.Done():
+					return nil
+				case toPublish <- it.msg:
+				}
+			}
+		}
+	})
+	rg.Go(func() error {
+		for {
+			var released []substrate.Message
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-queue.Ready():
+				released = queue.Release()
+			case ack := <-sinkAcks:
+				var ok bool
+				if released, ok = queue.Complete(0, ack); !ok {
+					return errors.Errorf("unexpected message acknowledged: %v", ack)
+				}
+				p.count(sinkStage, resultOK)
+			}
+			for _, msg := range released {
+				select {
+				case <-ctx.Done():
+					return nil
+				case sourceAcks <- msg:
+				}
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+// runStage transforms the items using the workers of the stage, passing them on in the order they were received.
+func (p *Pipeline) runStage(ctx context.Context, st stage, in <-chan *item, out chan<- *item) error {
+	rg, ctx := rungroup.New(ctx)
+
+	work := make(chan *task)
+	ordered := make(chan *task, st.workers+p.bufferSize)
+
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case it := <-in:
+				t := &task{item: it, done: make(chan struct{})}
+				select {
+				case <-ctx.Done():
+					return nil
+				case ordered <- t:
+				}
+				if it.msg == nil {
+					close(t.done)
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case work <- t:
+				}
+			}
+		}
+	})
+	for i := 0; i < st.workers; i++ {
+		rg.Go(func() error {
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case t := <-work:
+					p.transform(ctx, st, t)
+					close(t.done)
+				}
+			}
+		})
+	}
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case t := <-ordered:
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-t.done:
+				}
+				if t.err != nil {
+					return errors.Wrapf(t.err, "stage %s failed", st.name)
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case out <- t.item:
+				}
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+func (p *Pipeline) transform(ctx context.Context, st stage, t *task) {
+	start := time.Now()
+	msg, err := st.transform(ctx, t.item.msg)
+	if p.duration != nil {
+		p.duration.WithLabelValues(st.name).Observe(time.Since(start).Seconds())
+	}
+
+	switch {
+	case err != nil:
+		t.err = err
+		p.count(st.name, resultError)
+	case msg == nil:
+		t.item.msg = nil
+		p.count(st.name, resultDropped)
+	default:
+		t.item.msg = msg
+		p.count(st.name, resultOK)
+	}
+}
+
+func (p *Pipeline) count(stage, result string) {
+	if p.counter != nil {
+		p.counter.WithLabelValues(stage, result).Inc()
+	}
+}
@@ -0,0 +1,218 @@
+package pipeline_test
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/pipeline"
+)
+
+// recordingSource is a source sending the messages and recording their acknowledgements, which it expects
+// to be in order.
+type recordingSource struct {
+	substrate.AsyncMessageSource
+	messages []substrate.Message
+	acked    chan substrate.Message
+}
+
+func newRecordingSource(payloads ...string) *recordingSource {
+	s := &recordingSource{acked: make(chan substrate.Message, len(payloads))}
+	for _, payload := range payloads {
+		s.messages = append(s.messages, message.FromString(payload))
+	}
+	return s
+}
+
+func (s *recordingSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	toSend, toAck := s.messages, s.messages
+	for {
+		out := messages
+		var next substrate.Message
+		if len(toSend) > 0 {
+			next = toSend[0]
+		} else {
+			out = nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case out <- next:
+			toSend = toSend[1:]
+		case ack := <-acks:
+			if len(toAck) == 0 || ack != toAck[0] {
+				return substrate.InvalidAckError{Acked: ack}
+			}
+			toAck = toAck[1:]
+			s.acked <- ack
+		}
+	}
+}
+
+// waitAcked waits until all messages of the source have been acknowledged.
+func (s *recordingSource) waitAcked(t *testing.T) {
+	for range s.messages {
+		select {
+		case <-s.acked:
+		case <-time.After(time.Second * 5):
+			require.FailNow(t, "messages weren't acknowledged")
+		}
+	}
+}
+
+func payloads(broker *mem.Broker) []string {
+	var published []string
+	for _, data := range broker.Messages("out") {
+		published = append(published, string(data))
+	}
+	return published
+}
+
+func TestPipeline_Run(t *testing.T) {
+	var inputs, expected []string
+	for i := 0; i < 100; i++ {
+		inputs = append(inputs, strconv.Itoa(i))
+		if i%3 != 0 {
+			expected = append(expected, "n-"+strconv.Itoa(i*2))
+		}
+	}
+	source := newRecordingSource(inputs...)
+	broker := mem.NewBroker()
+
+	p := pipeline.From(source).
+		Stage("drop", func(_ context.Context, msg substrate.Message) (substrate.Message, error) {
+			n, err := strconv.Atoi(string(msg.Data()))
+			if err != nil || n%3 == 0 {
+				return nil, err
+			}
+			return msg, nil
+		}).
+		FanOut(8).
+		Buffer(4).
+		Transform(func(_ context.Context, msg substrate.Message) (substrate.Message, error) {
+			time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond)
+			n, _ := strconv.Atoi(string(msg.Data()))
+			return message.FromString(strconv.Itoa(n * 2)), nil
+		}).
+		Transform(func(_ context.Context, msg substrate.Message) (substrate.Message, error) {
+			return message.FromString("n-" + string(msg.Data())), nil
+		}).
+		To(broker.NewAsyncMessageSink("out"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- p.Run(ctx)
+	}()
+
+	source.waitAcked(t)
+	require.Equal(t, expected, payloads(broker))
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestPipeline_StageError(t *testing.T) {
+	errInvalid := errors.New("invalid message")
+	source := newRecordingSource("a", "b", "c")
+	broker := mem.NewBroker()
+
+	err := pipeline.From(source).
+		FanOut(2).
+		Stage("validate", func(_ context.Context, msg substrate.Message) (substrate.Message, error) {
+			if string(msg.Data()) == "b" {
+				return nil, errInvalid
+			}
+			return msg, nil
+		}).
+		To(broker.NewAsyncMessageSink("out")).
+		Run(context.Background())
+	require.Equal(t, errInvalid, errors.Cause(err))
+	require.True(t, strings.HasPrefix(err.Error(), "stage validate failed"), err.Error())
+	require.Subset(t, []string{"a"}, payloads(broker))
+}
+
+func TestPipeline_Invalid(t *testing.T) {
+	source := newRecordingSource()
+	sink := mem.NewBroker().NewAsyncMessageSink("out")
+
+	require.EqualError(t, pipeline.From(source).Run(context.Background()), "pipeline has no sink")
+	require.EqualError(t, pipeline.From(nil).To(sink).Run(context.Background()), "pipeline has no source")
+	require.EqualError(t, pipeline.From(source).FanOut(0).To(sink).Run(context.Background()), "invalid number of workers: 0")
+	require.EqualError(t, pipeline.From(source).Buffer(-1).To(sink).Run(context.Background()), "invalid buffer size: -1")
+}
+
+func TestPipeline_Metrics(t *testing.T) {
+	source := newRecordingSource("1", "2", "3")
+
+	p := pipeline.From(source).
+		WithStageCounter(prometheus.CounterOpts{
+			Name: "pipeline_test_messages_total",
+			Help: "pipeline_test_messages_total",
+		}).
+		WithStageDurationHistogram(prometheus.HistogramOpts{
+			Name: "pipeline_test_stage_seconds",
+			Help: "pipeline_test_stage_seconds",
+		}).
+		Stage("odd", func(_ context.Context, msg substrate.Message) (substrate.Message, error) {
+			if string(msg.Data()) == "2" {
+				return nil, nil
+			}
+			return msg, nil
+		}).
+		To(mem.NewBroker().NewAsyncMessageSink("out"))
+
+	before := gathered(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- p.Run(ctx)
+	}()
+	source.waitAcked(t)
+	cancel()
+	require.NoError(t, <-errs)
+
+	after := gathered(t)
+	require.Equal(t, float64(2), after["pipeline_test_messages_total{result=ok,stage=odd}"]-before["pipeline_test_messages_total{result=ok,stage=odd}"])
+	require.Equal(t, float64(1), after["pipeline_test_messages_total{result=dropped,stage=odd}"]-before["pipeline_test_messages_total{result=dropped,stage=odd}"])
+	require.Equal(t, float64(2), after["pipeline_test_messages_total{result=ok,stage=sink}"]-before["pipeline_test_messages_total{result=ok,stage=sink}"])
+	require.Equal(t, float64(3), after["pipeline_test_stage_seconds{stage=odd}"]-before["pipeline_test_stage_seconds{stage=odd}"])
+}
+
+// gathered returns the values of the counters and the sample counts of the histograms of the test, keyed by
+// their name and labels.
+func gathered(t *testing.T) map[string]float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+
+	values := make(map[string]float64)
+	for _, family := range families {
+		if !strings.HasPrefix(family.GetName(), "pipeline_test_") {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			var labels []string
+			for _, label := range metric.GetLabel() {
+				labels = append(labels, label.GetName()+"="+label.GetValue())
+			}
+			key := family.GetName() + "{" + strings.Join(labels, ",") + "}"
+			if metric.GetCounter() != nil {
+				values[key] = metric.GetCounter().GetValue()
+			} else {
+				values[key] = float64(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return values
+}
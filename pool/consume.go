@@ -0,0 +1,216 @@
+// Package pool provides a way of consuming an asynchronous message source using a bounded pool of workers.
+package pool
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"runtime/debug"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/sync/rungroup"
+
+	"github.com/uw-labs/substrate-tools/ackordering"
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+)
+
+// PanicError is the error returned when the handler panics and no panic handler is set.
+type PanicError struct {
+	// Value is the value the handler panicked with.
+	Value interface{}
+	// Stack is the stack trace of the goroutine that panicked.
+	Stack []byte
+}
+
+func (e PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+// PanicHandler is called with the message and the error describing the panic, when the handler panics.
+// If it returns nil, the message is acknowledged and the worker carries on, otherwise consumption is aborted
+// and the returned error is returned.
+type PanicHandler func(msg substrate.Message, err PanicError) error
+
+// ConsumeOption is a function which sets a Consume configuration option.
+type ConsumeOption func(cfg *consumeConfig)
+
+// WithWorkers sets the number of workers calling the handler concurrently. The default value is 1.
+func WithWorkers(workers uint) ConsumeOption {
+	return func(cfg *consumeConfig) {
+		if workers > 0 {
+			cfg.workers = workers
+		}
+	}
+}
+
+// WithQueueSize sets the number of consumed messages buffered while waiting for a worker. The default value
+// is 0 (unbuffered).
+func WithQueueSize(size uint) ConsumeOption {
+	return func(cfg *consumeConfig) {
+		cfg.queueSize = size
+	}
+}
+
+// WithPanicHandler sets the function called when the handler panics. By default, a panic aborts consumption
+// and a PanicError is returned.
+func WithPanicHandler(handler PanicHandler) ConsumeOption {
+	return func(cfg *consumeConfig) {
+		cfg.panicHandler = handler
+	}
+}
+
+// WithQueueDepthGauge enables a gauge of the number of messages buffered while waiting for a worker, sampled
+// whenever a worker takes a message. With ConsumeKeyed, it is the queue of the worker taking the message.
+// It panics in case it can't register the metric.
+func WithQueueDepthGauge(gaugeOpts prometheus.GaugeOpts) ConsumeOption {
+	return func(cfg *consumeConfig) {
+		cfg.queueDepth = metrics.Register(prometheus.NewGauge(gaugeOpts)).(prometheus.Gauge)
+	}
+}
+
+// WithHandlerDurationHistogram enables a histogram of the time it takes the handler to handle a message,
+// labelled with status, which is one of success, error or panic. It panics in case it can't register the metric.
+func WithHandlerDurationHistogram(histogramOpts prometheus.HistogramOpts) ConsumeOption {
+	return func(cfg *consumeConfig) {
+		cfg.duration = metrics.Register(prometheus.NewHistogramVec(histogramOpts, []string{"status"})).(*prometheus.HistogramVec)
+	}
+}
+
+type consumeConfig struct {
+	workers      uint
+	queueSize    uint
+	panicHandler PanicHandler
+	queueDepth   prometheus.Gauge
+	duration     *prometheus.HistogramVec
+}
+
+// Consume consumes messages from the source, calling the handler for each of them from a pool of workers.
+// A message is acknowledged once the handler returns successfully for it, acknowledgements are passed on
+// to the source in the order in which the messages were consumed. The handler is called with the messages of the
+// source, so that it can rely on their concrete type, e.g. to read their headers. If the handler returns an error,
+// consumption is aborted and the error returned. This function blocks until the context is done or
+// an error occurs.
+func Consume(ctx context.Context, source substrate.AsyncMessageSource, handler substrate.ConsumerMessageHandler, opts ...ConsumeOption) error {
+	cfg := &consumeConfig{workers: 1}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	rg, ctx := rungroup.New(ctx)
+	messages := make(chan substrate.Message, cfg.queueSize)
+	acks := make(chan substrate.Message, cfg.workers)
+
+	ordered := ackordering.NewAsyncMessageSource(source)
+	rg.Go(func() error {
+		return ordered.ConsumeMessages(ctx, messages, acks)
+	})
+	for i := uint(0); i < cfg.workers; i++ {
+		rg.Go(func() error {
+			return cfg.work(ctx, handler, messages, acks)
+		})
+	}
+
+	return rg.Wait()
+}
+
+// KeyFunc returns the key of a message.
+type KeyFunc func(msg substrate.Message) string
+
+// ConsumeKeyed works like Consume, except that messages with the same key are handled one at a time, in the order
+// in which they were consumed. Messages are assigned to workers by the hash of their key, so messages with
+// different keys are handled concurrently. The queue size applies to each of the workers.
+func ConsumeKeyed(ctx context.Context, source substrate.AsyncMessageSource, key KeyFunc, handler substrate.ConsumerMessageHandler, opts ...ConsumeOption) error {
+	cfg := &consumeConfig{workers: 1}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	rg, ctx := rungroup.New(ctx)
+	messages := make(chan substrate.Message)
+	acks := make(chan substrate.Message, cfg.workers)
+
+	ordered := ackordering.NewAsyncMessageSource(source)
+	rg.Go(func() error {
+		return ordered.ConsumeMessages(ctx, messages, acks)
+	})
+
+	shards := make([]chan substrate.Message, cfg.workers)
+	for i := range shards {
+		shard := make(chan substrate.Message, cfg.queueSize)
+		shards[i] = shard
+		rg.Go(func() error {
+			return cfg.work(ctx, handler, shard, acks)
+		})
+	}
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-messages:
+				hash := fnv.New32a()
+				hash.Write([]byte(key(ackordering.Unwrap(msg))))
+				select {
+				case <-ctx.Done():
+					return nil
+				case shards[hash.Sum32()%uint32(len(shards))] <- msg:
+				}
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+// work calls the handler for messages from the queue and acknowledges them, until the context
+// is done or the handler fails.
+func (cfg *consumeConfig) work(ctx context.Context, handler substrate.ConsumerMessageHandler, queue <-chan substrate.Message, acks chan<- substrate.Message) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-queue:
+			if cfg.queueDepth != nil {
+				cfg.queueDepth.Set(float64(len(queue)))
+			}
+			if err := cfg.handle(ctx, handler, ackordering.Unwrap(msg)); err != nil {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case acks <- msg:
+			}
+		}
+	}
+}
+
+// handle calls the handler, recovering from panics and recording its duration.
+func (cfg *consumeConfig) handle(ctx context.Context, handler substrate.ConsumerMessageHandler, msg substrate.Message) (err error) {
+	start, panicked := time.Now(), false
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = true
+			panicErr := PanicError{Value: r, Stack: debug.Stack()}
+			if cfg.panicHandler == nil {
+				err = panicErr
+			} else {
+				err = cfg.panicHandler(msg, panicErr)
+			}
+		}
+		if cfg.duration != nil {
+			status := "success"
+			switch {
+			case panicked:
+				status = "panic"
+			case err != nil:
+				status = "error"
+			}
+			cfg.duration.WithLabelValues(status).Observe(time.Since(start).Seconds())
+		}
+	}()
+
+	return handler(ctx, msg)
+}
@@ -0,0 +1,224 @@
+package pool_test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+	"github.com/uw-labs/substrate-tools/pool"
+)
+
+func messages(n int) []substrate.Message {
+	msgs := make([]substrate.Message, n)
+	for i := range msgs {
+		msgs[i] = message.FromString(string(rune('a' + i)))
+	}
+	return msgs
+}
+
+func TestConsume(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	// The mock source fails in case acknowledgements are not passed on in order.
+	source := &mock.AsyncMessageSource{Messages: messages(20)}
+
+	var (
+		mutex   sync.Mutex
+		handled []string
+		active  int32
+		maxSeen int32
+	)
+	handler := func(ctx context.Context, msg substrate.Message) error {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			seen := atomic.LoadInt32(&maxSeen)
+			if n <= seen || atomic.CompareAndSwapInt32(&maxSeen, seen, n) {
+				break
+			}
+		}
+		// Handle messages out of order.
+		time.Sleep(time.Millisecond * time.Duration(msg.Data()[0]%5))
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		handled = append(handled, string(msg.Data()))
+		if len(handled) == 20 {
+			cancel()
+		}
+		return nil
+	}
+
+	require.NoError(t, pool.Consume(ctx, source, handler, pool.WithWorkers(4), pool.WithQueueSize(4)))
+	require.Len(t, handled, 20)
+	require.True(t, atomic.LoadInt32(&maxSeen) > 1)
+	require.True(t, atomic.LoadInt32(&maxSeen) <= 4)
+}
+
+func TestConsume_HandlerError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	handlerErr := errors.New("handler error")
+	err := pool.Consume(ctx, &mock.AsyncMessageSource{Messages: messages(5)}, func(context.Context, substrate.Message) error {
+		return handlerErr
+	}, pool.WithWorkers(2))
+	require.Equal(t, handlerErr, err)
+}
+
+func TestConsume_Panic(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	err := pool.Consume(ctx, &mock.AsyncMessageSource{Messages: messages(1)}, func(context.Context, substrate.Message) error {
+		panic("boom")
+	})
+	require.IsType(t, pool.PanicError{}, err)
+	require.Equal(t, "boom", err.(pool.PanicError).Value)
+	require.NotEmpty(t, err.(pool.PanicError).Stack)
+}
+
+func TestConsume_PanicHandler(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	var (
+		handled  int32
+		panicked int32
+	)
+	handler := func(ctx context.Context, msg substrate.Message) error {
+		if atomic.AddInt32(&handled, 1) == 3 {
+			cancel()
+		}
+		if string(msg.Data()) == "a" {
+			panic("boom")
+		}
+		return nil
+	}
+	panicHandler := func(msg substrate.Message, err pool.PanicError) error {
+		atomic.AddInt32(&panicked, 1)
+		return nil
+	}
+
+	histogramOpts := prometheus.HistogramOpts{Name: "pool_test_handler_duration_seconds"}
+	gaugeOpts := prometheus.GaugeOpts{Name: "pool_test_queue_depth"}
+	require.NoError(t, pool.Consume(ctx, &mock.AsyncMessageSource{Messages: messages(3)}, handler,
+		pool.WithWorkers(2),
+		pool.WithPanicHandler(panicHandler),
+		pool.WithHandlerDurationHistogram(histogramOpts),
+		pool.WithQueueDepthGauge(gaugeOpts),
+	))
+	require.Equal(t, int32(1), atomic.LoadInt32(&panicked))
+
+	histogram := prometheus.NewHistogramVec(histogramOpts, []string{"status"})
+	existing := prometheus.Register(histogram).(prometheus.AlreadyRegisteredError).ExistingCollector.(*prometheus.HistogramVec)
+	// Durations are recorded both for the successfully handled messages and the panic.
+	collected := make(chan prometheus.Metric, 10)
+	existing.Collect(collected)
+	close(collected)
+	require.Len(t, collected, 2)
+}
+
+func TestConsumeKeyed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	msgs := make([]substrate.Message, 40)
+	for i := range msgs {
+		msgs[i] = message.FromString(fmt.Sprintf("%d:%d", i%4, i))
+	}
+	source := &mock.AsyncMessageSource{Messages: msgs}
+	key := func(msg substrate.Message) string {
+		return strings.SplitN(string(msg.Data()), ":", 2)[0]
+	}
+
+	var (
+		mutex   sync.Mutex
+		active  = make(map[string]bool)
+		handled = make(map[string][]int)
+		total   int
+	)
+	handler := func(ctx context.Context, msg substrate.Message) error {
+		parts := strings.SplitN(string(msg.Data()), ":", 2)
+		mutex.Lock()
+		if active[parts[0]] {
+			mutex.Unlock()
+			return errors.Errorf("messages with key %s handled concurrently", parts[0])
+		}
+		active[parts[0]] = true
+		mutex.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		active[parts[0]] = false
+		seq, _ := strconv.Atoi(parts[1])
+		handled[parts[0]] = append(handled[parts[0]], seq)
+		if total++; total == len(msgs) {
+			cancel()
+		}
+		return nil
+	}
+
+	require.NoError(t, pool.ConsumeKeyed(ctx, source, key, handler, pool.WithWorkers(3), pool.WithQueueSize(2)))
+	require.Len(t, handled, 4)
+	for k, seqs := range handled {
+		require.Len(t, seqs, 10, k)
+		require.True(t, sort.IntsAreSorted(seqs), "messages with key %s handled out of order: %v", k, seqs)
+	}
+}
+
+func TestConsumeKeyed_OriginalMessages(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	msgs := make([]substrate.Message, 4)
+	for i := range msgs {
+		msgs[i] = message.NewEnvelopedMessage(message.Envelope{
+			Payload: []byte(strconv.Itoa(i)),
+			Headers: map[string]string{"tenant": strconv.Itoa(i % 2)},
+		})
+	}
+	source := &mock.AsyncMessageSource{Messages: msgs}
+
+	// The key function and the handler receive the messages of the source, so that headers can be used as keys.
+	var (
+		mutex   sync.Mutex
+		keys    []string
+		handled []substrate.Message
+	)
+	key := func(msg substrate.Message) string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		keys = append(keys, message.Headers(msg)["tenant"])
+		return message.Headers(msg)["tenant"]
+	}
+	handler := func(ctx context.Context, msg substrate.Message) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		handled = append(handled, msg)
+		if len(handled) == len(msgs) {
+			cancel()
+		}
+		return nil
+	}
+
+	require.NoError(t, pool.ConsumeKeyed(ctx, source, key, handler, pool.WithWorkers(2)))
+	require.Equal(t, []string{"0", "1", "0", "1"}, keys)
+	require.ElementsMatch(t, msgs, handled)
+}
@@ -0,0 +1,53 @@
+package priority
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// schedule delivers the given number of messages, with every source always having a message available,
+// and returns the sources they were delivered from.
+func schedule(sched *scheduler, count int) []int {
+	var order []int
+	for i := 0; i < count; i++ {
+		for index := range sched.heads {
+			if sched.heads[index] == nil {
+				sched.heads[index] = &priorityMessage{index: index}
+			}
+		}
+		index := sched.next()
+		sched.delivered(index)
+		order = append(order, index)
+	}
+	return order
+}
+
+func TestScheduler_Strict(t *testing.T) {
+	sched := newScheduler(2, nil, 0)
+	require.Equal(t, -1, sched.next())
+	require.Equal(t, []int{0, 0, 0, 0}, schedule(sched, 4))
+
+	sched.heads[0] = nil
+	require.Equal(t, 1, sched.next())
+}
+
+func TestScheduler_StarvationLimit(t *testing.T) {
+	sched := newScheduler(3, nil, 2)
+	require.Equal(t, []int{0, 0, 1, 2, 0, 0, 1, 2}, schedule(sched, 8))
+}
+
+func TestScheduler_Weights(t *testing.T) {
+	sched := newScheduler(2, []int{3, 1}, 0)
+	require.Equal(t, []int{0, 0, 1, 0, 0, 0, 1, 0}, schedule(sched, 8))
+
+	// Sources without messages available don't accumulate weight.
+	sched = newScheduler(2, []int{1, 1}, 0)
+	sched.heads[1] = &priorityMessage{index: 1}
+	for i := 0; i < 3; i++ {
+		require.Equal(t, 1, sched.next())
+		sched.delivered(1)
+		sched.heads[1] = &priorityMessage{index: 1}
+	}
+	require.Equal(t, []int{0, 1, 0, 1}, schedule(sched, 4))
+}
@@ -0,0 +1,285 @@
+// Package priority provides a message source that consumes from multiple sources, delivering their messages
+// according to the priority of the sources.
+package priority
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/health"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// ErrNoMessageSources is returned when no message sources are provided.
+var ErrNoMessageSources = errors.New("no message sources provided")
+
+// Option is a function which sets a priority source configuration option.
+type Option func(s *prioritySource)
+
+// WithWeights makes the source deliver messages in proportion to the weights of the sources that have messages
+// available, instead of by strict priority. There must be one positive weight per source.
+func WithWeights(weights ...int) Option {
+	return func(s *prioritySource) {
+		s.weights = weights
+	}
+}
+
+// WithStarvationLimit sets the number of messages from higher priority sources that can be delivered while a
+// message of a lower priority source is available before the message of the lower priority source is delivered
+// instead. It only applies to strict priority. The default value is 0 (unlimited), in which case lower priority
+// sources are only consumed once the higher priority ones have no messages available.
+func WithStarvationLimit(limit int) Option {
+	return func(s *prioritySource) {
+		s.starvationLimit = limit
+	}
+}
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that consumes messages from all the
+// sources, which are ordered from the highest priority to the lowest. Out of the messages available, it delivers
+// the message of the source with the highest priority, or follows the weights of the sources if configured to.
+// Acknowledgements are passed on to the source the message came from. It returns an error if no message sources
+// are provided or the options are invalid.
+func NewAsyncMessageSource(sources []substrate.AsyncMessageSource, opts ...Option) (substrate.AsyncMessageSource, error) {
+	if len(sources) == 0 {
+		return nil, ErrNoMessageSources
+	}
+	s := &prioritySource{sources: sources}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.weights != nil {
+		if len(s.weights) != len(sources) {
+			return nil, errors.Errorf("got %d weights for %d sources", len(s.weights), len(sources))
+		}
+		for _, weight := range s.weights {
+			if weight <= 0 {
+				return nil, errors.Errorf("invalid weight: %d", weight)
+			}
+		}
+	}
+	if s.starvationLimit < 0 {
+		return nil, errors.Errorf("invalid starvation limit: %d", s.starvationLimit)
+	}
+	return s, nil
+}
+
+type prioritySource struct {
+	sources         []substrate.AsyncMessageSource
+	weights         []int
+	starvationLimit int
+}
+
+// priorityMessage is a message annotated with the index of the source it comes from.
+type priorityMessage struct {
+	index int
+	msg   substrate.Message
+}
+
+func (msg *priorityMessage) Data() []byte {
+	return msg.msg.Data()
+}
+
+func (msg *priorityMessage) DiscardPayload() {
+	if dMsg, ok := msg.msg.(substrate.DiscardableMessage); ok {
+		dMsg.DiscardPayload()
+	}
+}
+
+func (msg *priorityMessage) Headers() map[string]string {
+	return message.Headers(msg.msg)
+}
+
+func (msg *priorityMessage) ContentType() string {
+	return message.ContentType(msg.msg)
+}
+
+func (msg *priorityMessage) CarriedEnvelope() (message.Envelope, bool) {
+	return message.EnvelopeOf(msg.msg)
+}
+
+// ConsumeMessages consumes messages from all the underlying sources and delivers them by priority. It terminates
+// as soon as any of the underlying sources does or when the context is cancelled.
+func (s *prioritySource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "priority"})
+	rg, ctx := rungroup.New(ctx)
+
+	arrivals := make(chan *priorityMessage)
+	taken := make([]chan struct{}, len(s.sources))
+	toSources := make([]chan substrate.Message, len(s.sources))
+
+	for i, source := range s.sources {
+		index, source := i, source
+		sourceMsgs := make(chan substrate.Message)
+		taken[index] = make(chan struct{})
+		toSources[index] = make(chan substrate.Message)
+
+		rg.Go(func() error {
+			return source.ConsumeMessages(ctx, sourceMsgs, toSources[index])
+		})
+		// Pass on one message of the source at a time, so that the scheduler knows which sources have messages
+		// available.
+		rg.Go(func() error {
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case msg := <-sourceMsgs:
+					select {
+					case <-ctx.Done():
+						return nil
+					case arrivals <- &priorityMessage{index: index, msg: msg}:
+					}
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-taken[index]:
+				}
+			}
+		})
+	}
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				pMsg, ok := ack.(*priorityMessage)
+				if !ok {
+					return errors.Errorf("unexpected message type: %T", ack)
+				}
+				emit.Ack(ack)
+				select {
+				case <-ctx.Done():
+					return nil
+				case toSources[pMsg.index] <- pMsg.msg:
+				}
+			}
+		}
+	}))
+	rg.Go(func() error {
+		sched := newScheduler(len(s.sources), s.weights, s.starvationLimit)
+		for {
+			var (
+				out  chan<- substrate.Message
+				next *priorityMessage
+			)
+			if index := sched.next(); index >= 0 {
+				out, next = messages, sched.heads[index]
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case pMsg := <-arrivals:
+				sched.heads[pMsg.index] = pMsg
+			case out <- next:
+				emit.Publish(next)
+				sched.delivered(next.index)
+				select {
+				case <-ctx.Done():
+					return nil
+				case taken[next.index] <- struct{}{}:
+				}
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+// Close closes all the underlying sources and returns all errors encountered.
+func (s *prioritySource) Close() (err error) {
+	for _, source := range s.sources {
+		err = multierror.Append(err, source.Close()).ErrorOrNil()
+	}
+	return err
+}
+
+// Status returns the combined status of all the underlying sources, it only reports working status if all of
+// them do.
+func (s *prioritySource) Status() (*substrate.Status, error) {
+	statusers := make([]substrate.Statuser, 0, len(s.sources))
+	for i, source := range s.sources {
+		statusers = append(statusers, health.Named(fmt.Sprintf("source %d", i), source))
+	}
+	return health.Combine(statusers...).Status()
+}
+
+// scheduler chooses the source of the next message to deliver out of the sources that have a message available.
+type scheduler struct {
+	heads           []*priorityMessage
+	weights         []int
+	current         []int
+	starvationLimit int
+	skipped         []int
+}
+
+func newScheduler(sources int, weights []int, starvationLimit int) *scheduler {
+	return &scheduler{
+		heads:           make([]*priorityMessage, sources),
+		weights:         weights,
+		current:         make([]int, sources),
+		starvationLimit: starvationLimit,
+		skipped:         make([]int, sources),
+	}
+}
+
+// next returns the index of the source whose message should be delivered next, or -1 if no source has a message
+// available.
+func (s *scheduler) next() int {
+	if s.weights != nil {
+		// Smooth weighted round-robin: the source with the highest current weight after adding its
+		// weight is chosen.
+		best := -1
+		for i, head := range s.heads {
+			if head != nil && (best < 0 || s.current[i]+s.weights[i] > s.current[best]+s.weights[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+
+	if s.starvationLimit > 0 {
+		for i, head := range s.heads {
+			if head != nil && s.skipped[i] >= s.starvationLimit {
+				return i
+			}
+		}
+	}
+	for i, head := range s.heads {
+		if head != nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// delivered records that the message of the source was delivered.
+func (s *scheduler) delivered(index int) {
+	if s.weights != nil {
+		total := 0
+		for i, head := range s.heads {
+			if head != nil {
+				s.current[i] += s.weights[i]
+				total += s.weights[i]
+			}
+		}
+		s.current[index] -= total
+	}
+	// Only lower priority sources are skipped by delivering the message.
+	for i := index + 1; i < len(s.heads); i++ {
+		if s.heads[i] != nil {
+			s.skipped[i]++
+		}
+	}
+	s.skipped[index] = 0
+	s.heads[index] = nil
+}
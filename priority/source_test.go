@@ -0,0 +1,131 @@
+package priority_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/conformance"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+	"github.com/uw-labs/substrate-tools/priority"
+)
+
+func TestNewAsyncMessageSource_Error(t *testing.T) {
+	_, err := priority.NewAsyncMessageSource(nil)
+	require.Equal(t, priority.ErrNoMessageSources, err)
+
+	sources := []substrate.AsyncMessageSource{&mock.AsyncMessageSource{}, &mock.AsyncMessageSource{}}
+	_, err = priority.NewAsyncMessageSource(sources, priority.WithWeights(1))
+	require.EqualError(t, err, "got 1 weights for 2 sources")
+	_, err = priority.NewAsyncMessageSource(sources, priority.WithWeights(1, 0))
+	require.EqualError(t, err, "invalid weight: 0")
+	_, err = priority.NewAsyncMessageSource(sources, priority.WithStarvationLimit(-1))
+	require.EqualError(t, err, "invalid starvation limit: -1")
+}
+
+func TestPrioritySource_ConsumeMessages(t *testing.T) {
+	for name, opts := range map[string][]priority.Option{
+		"strict":     nil,
+		"starvation": {priority.WithStarvationLimit(1)},
+		"weights":    {priority.WithWeights(3, 1)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+
+			urgent := &mock.AsyncMessageSource{Messages: []substrate.Message{
+				message.FromString("urgent-1"),
+				message.FromString("urgent-2"),
+				message.FromString("urgent-3"),
+			}}
+			bulk := &mock.AsyncMessageSource{Messages: []substrate.Message{
+				message.FromString("bulk-1"),
+				message.FromString("bulk-2"),
+			}}
+			source, err := priority.NewAsyncMessageSource([]substrate.AsyncMessageSource{urgent, bulk}, opts...)
+			require.NoError(t, err)
+
+			messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+			errs := make(chan error, 1)
+			go func() {
+				errs <- source.ConsumeMessages(ctx, messages, acks)
+			}()
+
+			// The mock sources fail if the acknowledgements they receive are out of order.
+			var consumed []string
+			for i := 0; i < 5; i++ {
+				select {
+				case msg := <-messages:
+					consumed = append(consumed, string(msg.Data()))
+					acks <- msg
+				case err := <-errs:
+					require.FailNow(t, "consuming failed", "%v", err)
+				}
+			}
+			sort.Strings(consumed)
+			require.Equal(t, []string{"bulk-1", "bulk-2", "urgent-1", "urgent-2", "urgent-3"}, consumed)
+
+			cancel()
+			require.NoError(t, <-errs)
+			require.NoError(t, source.Close())
+			require.True(t, urgent.WasClosed())
+			require.True(t, bulk.WasClosed())
+		})
+	}
+}
+
+func TestPrioritySource_Metadata(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	mockSource := &mock.AsyncMessageSource{Messages: []substrate.Message{
+		message.NewEnvelopedMessage(message.Envelope{
+			ContentType: "text/plain",
+			Headers:     map[string]string{"key": "value"},
+			Payload:     []byte("1"),
+		}),
+	}}
+	source, err := priority.NewAsyncMessageSource([]substrate.AsyncMessageSource{mockSource})
+	require.NoError(t, err)
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	// Delivered messages report the headers and content type of the messages of the sources.
+	msg := <-messages
+	require.Equal(t, map[string]string{"key": "value"}, message.Headers(msg))
+	require.Equal(t, "text/plain", message.ContentType(msg))
+	acks <- msg
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestPrioritySource_Status(t *testing.T) {
+	closed := &mock.AsyncMessageSource{}
+	require.NoError(t, closed.Close())
+
+	source, err := priority.NewAsyncMessageSource([]substrate.AsyncMessageSource{&mock.AsyncMessageSource{}, closed})
+	require.NoError(t, err)
+
+	status, err := source.Status()
+	require.NoError(t, err)
+	require.False(t, status.Working)
+	require.Equal(t, []string{"source 1: sink already closed"}, status.Problems)
+}
+
+func TestPrioritySource_Conformance(t *testing.T) {
+	conformance.TestAsyncSource(t, func(t *testing.T, backend substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		source, err := priority.NewAsyncMessageSource([]substrate.AsyncMessageSource{backend})
+		require.NoError(t, err)
+		return source
+	})
+}
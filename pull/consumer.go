@@ -0,0 +1,211 @@
+// Package pull provides a pull based consumer fetching batches of messages from an asynchronous message source on
+// demand, for applications preferring batch loops over channel streaming.
+package pull
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+)
+
+const defaultPrefetch = 100
+
+// ErrClosed is returned by a consumer once it was closed.
+var ErrClosed = errors.New("consumer closed")
+
+// ConsumerOption is a function which sets a Consumer configuration option.
+type ConsumerOption func(c *Consumer)
+
+// WithPrefetch sets the number of messages consumed from the source ahead of the calls to Fetch. The default
+// value is 100.
+func WithPrefetch(n int) ConsumerOption {
+	return func(c *Consumer) {
+		c.prefetch = n
+	}
+}
+
+// WithMaxWait sets how long Fetch waits for more messages once it received the first one, before returning fewer
+// messages than requested. The default value is 0, i.e. Fetch returns the messages already available.
+func WithMaxWait(wait time.Duration) ConsumerOption {
+	return func(c *Consumer) {
+		c.maxWait = wait
+	}
+}
+
+// Consumer fetches messages from a source on demand. Consumption starts on the first call to Fetch and goes on in
+// the background until the consumer is closed or the source fails. Fetched messages can be acknowledged in any
+// order, they are acknowledged to the source in the order in which they were fetched. It is safe for concurrent
+// use.
+type Consumer struct {
+	source   substrate.AsyncMessageSource
+	prefetch int
+	maxWait  time.Duration
+
+	messages chan substrate.Message
+	acks     chan substrate.Message
+	start    sync.Once
+	ctx      context.Context
+	cancel   func()
+	done     chan struct{}
+	err      error
+
+	mutex   sync.Mutex
+	fetched []*fetched
+}
+
+// fetched is a message returned by Fetch that wasn't acknowledged to the source yet.
+type fetched struct {
+	msg   substrate.Message
+	acked bool
+}
+
+// NewConsumer returns a consumer fetching messages from the source. Closing the consumer closes the source.
+func NewConsumer(source substrate.AsyncMessageSource, opts ...ConsumerOption) *Consumer {
+	c := &Consumer{
+		source:   source,
+		prefetch: defaultPrefetch,
+		acks:     make(chan substrate.Message),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.messages = make(chan substrate.Message, c.prefetch)
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c
+}
+
+// run consumes messages from the source until the consumer is closed or the source fails.
+func (c *Consumer) run() {
+	go func() {
+		err := c.source.ConsumeMessages(c.ctx, c.messages, c.acks)
+		if err == nil {
+			err = ErrClosed
+		}
+		c.err = err
+		close(c.done)
+	}()
+}
+
+// Fetch returns up to n messages. It waits until at least one message is available, or until the context is
+// done, in which case it returns the error of the context. It returns the error of the source if it failed, or
+// ErrClosed once the consumer was closed.
+func (c *Consumer) Fetch(ctx context.Context, n int) ([]substrate.Message, error) {
+	if n < 1 {
+		return nil, errors.Errorf("invalid number of messages: %d", n)
+	}
+	c.start.Do(c.run)
+
+	select {
+	case <-c.done:
+		return nil, c.err
+	default:
+	}
+
+	var (
+		batch   []substrate.Message
+		timeout <-chan time.Time
+	)
+	for len(batch) < n {
+		if len(batch) > 0 && c.maxWait <= 0 {
+			select {
+			case msg := <-c.messages:
+				batch = c.track(batch, msg)
+				continue
+			default:
+				return batch, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if len(batch) == 0 {
+				return nil, ctx.Err()
+			}
+			return batch, nil
+		case <-c.done:
+			if len(batch) == 0 {
+				return nil, c.err
+			}
+			return batch, nil
+		case <-timeout:
+			return batch, nil
+		case msg := <-c.messages:
+			batch = c.track(batch, msg)
+			if len(batch) == 1 && c.maxWait > 0 {
+				timer := time.NewTimer(c.maxWait)
+				defer timer.Stop()
+				timeout = timer.C
+			}
+		}
+	}
+	return batch, nil
+}
+
+// track records the message as fetched and adds it to the batch.
+func (c *Consumer) track(batch []substrate.Message, msg substrate.Message) []substrate.Message {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.fetched = append(c.fetched, &fetched{msg: msg})
+	return append(batch, msg)
+}
+
+// Ack acknowledges the fetched messages. Messages are acknowledged to the source once all the messages fetched
+// before them were acknowledged too. It returns an error if a message wasn't fetched or was already acknowledged,
+// in which case none of the messages is acknowledged.
+func (c *Consumer) Ack(msgs ...substrate.Message) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// All the messages are validated before any of them is marked as acknowledged.
+	toAck := make(map[*fetched]bool, len(msgs))
+	for _, msg := range msgs {
+		var match *fetched
+		for _, f := range c.fetched {
+			if !f.acked && !toAck[f] && f.msg == msg {
+				match = f
+				break
+			}
+		}
+		if match == nil {
+			return errors.Errorf("unexpected message acknowledged: %v", msg)
+		}
+		toAck[match] = true
+	}
+	for f := range toAck {
+		f.acked = true
+	}
+
+	// The mutex is held while sending, so that concurrent calls acknowledge messages to the source in order.
+	for len(c.fetched) > 0 && c.fetched[0].acked {
+		select {
+		case <-c.done:
+			return c.err
+		case c.acks <- c.fetched[0].msg:
+			c.fetched = c.fetched[1:]
+		}
+	}
+	return nil
+}
+
+// Close stops consuming from the source and closes it. Messages fetched but not acknowledged yet are left
+// unacknowledged.
+func (c *Consumer) Close() error {
+	c.cancel()
+	c.start.Do(func() {
+		c.err = ErrClosed
+		close(c.done)
+	})
+	<-c.done
+	return c.source.Close()
+}
+
+// Status returns the status of the source.
+func (c *Consumer) Status() (*substrate.Status, error) {
+	return c.source.Status()
+}
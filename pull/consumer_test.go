@@ -0,0 +1,117 @@
+package pull_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+	"github.com/uw-labs/substrate-tools/pull"
+)
+
+func TestConsumer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	one, two, three := message.FromString("1"), message.FromString("2"), message.FromString("3")
+	source := &mock.AsyncMessageSource{Messages: []substrate.Message{one, two, three}}
+	consumer := pull.NewConsumer(source, pull.WithMaxWait(time.Millisecond*50))
+
+	batch, err := consumer.Fetch(ctx, 2)
+	require.NoError(t, err)
+	require.Equal(t, []substrate.Message{one, two}, batch)
+	batch, err = consumer.Fetch(ctx, 2)
+	require.NoError(t, err)
+	require.Equal(t, []substrate.Message{three}, batch)
+
+	// The mock source fails on acknowledgements that aren't in order.
+	require.NoError(t, consumer.Ack(three, one))
+	require.NoError(t, consumer.Ack(two))
+	require.EqualError(t, consumer.Ack(two), fmt.Sprintf("unexpected message acknowledged: %v", two))
+
+	fetchCtx, fetchCancel := context.WithTimeout(ctx, time.Millisecond*50)
+	defer fetchCancel()
+	_, err = consumer.Fetch(fetchCtx, 1)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	require.NoError(t, consumer.Close())
+	_, err = consumer.Fetch(ctx, 1)
+	require.Equal(t, pull.ErrClosed, err)
+	status, err := consumer.Status()
+	require.NoError(t, err)
+	require.False(t, status.Working)
+}
+
+func TestConsumer_InvalidAck(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	one, two := message.FromString("1"), message.FromString("2")
+	source := mock.NewSource(one, two)
+	consumer := pull.NewConsumer(source)
+	defer consumer.Close()
+
+	var batch []substrate.Message
+	for len(batch) < 2 {
+		fetched, err := consumer.Fetch(ctx, 2-len(batch))
+		require.NoError(t, err)
+		batch = append(batch, fetched...)
+	}
+
+	// None of the messages is acknowledged if one of them is invalid, even if it comes last.
+	unknown := message.FromString("unknown")
+	require.EqualError(t, consumer.Ack(one, unknown), fmt.Sprintf("unexpected message acknowledged: %v", unknown))
+	require.EqualError(t, consumer.Ack(two, two), fmt.Sprintf("unexpected message acknowledged: %v", two))
+	require.Empty(t, source.Acked())
+
+	require.NoError(t, consumer.Ack(one, two))
+	select {
+	case <-source.AllAcked():
+	case <-ctx.Done():
+		require.FailNow(t, "messages weren't acknowledged")
+	}
+}
+
+func TestConsumer_Available(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	one := message.FromString("1")
+	consumer := pull.NewConsumer(&mock.AsyncMessageSource{Messages: []substrate.Message{one}})
+	defer consumer.Close()
+
+	// Without a maximum wait, only the messages already available are returned.
+	batch, err := consumer.Fetch(ctx, 10)
+	require.NoError(t, err)
+	require.Equal(t, []substrate.Message{one}, batch)
+
+	_, err = consumer.Fetch(ctx, 0)
+	require.EqualError(t, err, "invalid number of messages: 0")
+}
+
+func TestConsumer_SourceError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	errSource := errors.New("source failure")
+	consumer := pull.NewConsumer(mock.NewSource(message.FromString("1")).FailAt(1, errSource))
+	defer consumer.Close()
+
+	_, err := consumer.Fetch(ctx, 1)
+	require.Equal(t, errSource, err)
+}
+
+func TestConsumer_CloseBeforeFetch(t *testing.T) {
+	source := &mock.AsyncMessageSource{}
+	consumer := pull.NewConsumer(source)
+
+	require.NoError(t, consumer.Close())
+	_, err := consumer.Fetch(context.Background(), 1)
+	require.Equal(t, pull.ErrClosed, err)
+}
@@ -0,0 +1,125 @@
+package quarantine
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+var (
+	_ Destination = (*FileDestination)(nil)
+	_ Destination = (*SinkDestination)(nil)
+)
+
+// FileDestination appends quarantined messages to a file, one JSON encoded record per line.
+type FileDestination struct {
+	mutex sync.Mutex
+	file  *os.File
+	enc   *json.Encoder
+}
+
+// NewFileDestination returns a destination appending to the file at the path, which is created if needed.
+func NewFileDestination(path string) (*FileDestination, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open quarantine file")
+	}
+	return &FileDestination{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Quarantine appends the record to the file and syncs it, so that it survives the crash of the process.
+func (d *FileDestination) Quarantine(_ context.Context, record Record) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if err := d.enc.Encode(record); err != nil {
+		return errors.Wrap(err, "failed to write quarantine record")
+	}
+	if err := d.file.Sync(); err != nil {
+		return errors.Wrap(err, "failed to write quarantine record")
+	}
+	return nil
+}
+
+// Close closes the file.
+func (d *FileDestination) Close() error {
+	return d.file.Close()
+}
+
+// SinkDestination publishes quarantined messages to a sink, with the JSON encoded record as payload and the headers
+// of the quarantined message.
+type SinkDestination struct {
+	sink     substrate.AsyncMessageSink
+	cancel   func()
+	messages chan substrate.Message
+	acks     chan substrate.Message
+	done     chan struct{}
+
+	// mutex makes sure a single record is published at a time, so that acknowledgements match them.
+	mutex sync.Mutex
+	err   error
+}
+
+// NewSinkDestination returns a destination publishing to the sink, until it is closed.
+func NewSinkDestination(sink substrate.AsyncMessageSink) *SinkDestination {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &SinkDestination{
+		sink:     sink,
+		cancel:   cancel,
+		messages: make(chan substrate.Message),
+		acks:     make(chan substrate.Message),
+		done:     make(chan struct{}),
+	}
+	go func() {
+		defer close(d.done)
+		d.err = sink.PublishMessages(ctx, d.acks, d.messages)
+		if d.err == nil {
+			d.err = errors.New("quarantine sink stopped")
+		}
+	}()
+	return d
+}
+
+// Quarantine publishes the record and waits for it to be acknowledged.
+func (d *SinkDestination) Quarantine(ctx context.Context, record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode quarantine record")
+	}
+	var msg substrate.Message = message.NewMessage(data)
+	if len(record.Headers) > 0 {
+		msg = message.NewEnvelopedMessage(message.Envelope{Payload: data, Headers: record.Headers})
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-d.done:
+		return errors.Wrap(d.err, "failed to publish quarantine record")
+	case d.messages <- msg:
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-d.done:
+		return errors.Wrap(d.err, "failed to publish quarantine record")
+	case <-d.acks:
+		return nil
+	}
+}
+
+// Close stops publishing and closes the sink.
+func (d *SinkDestination) Close() error {
+	d.cancel()
+	<-d.done
+	return d.sink.Close()
+}
@@ -0,0 +1,120 @@
+// Package quarantine provides a message handler wrapper that recovers from panics of the handler, quarantining the
+// message that caused the panic together with the stack trace and acknowledging it, so that a single poison message
+// can't crash-loop a whole consumer.
+package quarantine
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/pool"
+)
+
+// Record describes a quarantined message.
+type Record struct {
+	// Payload is the payload of the message.
+	Payload []byte `json:"payload"`
+	// Headers are the headers of the message, if it carried any.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Panic is the value the handler panicked with.
+	Panic string `json:"panic"`
+	// Stack is the stack trace of the goroutine that panicked.
+	Stack string `json:"stack"`
+	// Time is the time at which the message was quarantined.
+	Time time.Time `json:"time"`
+}
+
+// Destination keeps quarantined messages for later inspection.
+type Destination interface {
+	// Quarantine records the message.
+	Quarantine(ctx context.Context, record Record) error
+}
+
+// Option is a function which sets a quarantining handler configuration option.
+type Option func(q *quarantiner)
+
+// WithQuarantinedCounter enables a counter of quarantined messages. It panics in case it can't register the metric.
+func WithQuarantinedCounter(counterOpts prometheus.CounterOpts) Option {
+	return func(q *quarantiner) {
+		q.counterOpts = &counterOpts
+	}
+}
+
+// WithRegisterer sets the registerer the counter of quarantined messages is registered with. The default is
+// prometheus.DefaultRegisterer.
+func WithRegisterer(registerer prometheus.Registerer) Option {
+	return func(q *quarantiner) {
+		q.registerer = registerer
+	}
+}
+
+type quarantiner struct {
+	dest        Destination
+	counterOpts *prometheus.CounterOpts
+	registerer  prometheus.Registerer
+	quarantined prometheus.Counter
+	now         func() time.Time
+}
+
+func newQuarantiner(dest Destination, opts []Option) *quarantiner {
+	q := &quarantiner{dest: dest, registerer: prometheus.DefaultRegisterer, now: time.Now}
+	for _, opt := range opts {
+		opt(q)
+	}
+	if q.counterOpts != nil {
+		q.quarantined = metrics.RegisterWith(q.registerer, prometheus.NewCounter(*q.counterOpts)).(prometheus.Counter)
+	}
+	return q
+}
+
+// Handler returns a handler calling the provided one and recovering from its panics. The message that caused a
+// panic is quarantined to the destination and the handler returns nil, so that the message is acknowledged and
+// consumption carries on. Errors returned by the provided handler are returned unchanged. If the message can't
+// be quarantined, an error describing both the panic and the failure is returned instead.
+func Handler(handler substrate.ConsumerMessageHandler, dest Destination, opts ...Option) substrate.ConsumerMessageHandler {
+	q := newQuarantiner(dest, opts)
+	return func(ctx context.Context, msg substrate.Message) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = q.quarantine(ctx, msg, r, debug.Stack())
+			}
+		}()
+		return handler(ctx, msg)
+	}
+}
+
+// PanicHandler returns a pool.PanicHandler quarantining the messages whose handler panicked, for handlers
+// run by the pool package, which recovers from panics itself.
+func PanicHandler(dest Destination, opts ...Option) pool.PanicHandler {
+	q := newQuarantiner(dest, opts)
+	return func(msg substrate.Message, err pool.PanicError) error {
+		return q.quarantine(context.Background(), msg, err.Value, err.Stack)
+	}
+}
+
+func (q *quarantiner) quarantine(ctx context.Context, msg substrate.Message, value interface{}, stack []byte) error {
+	record := Record{
+		Payload: msg.Data(),
+		Panic:   fmt.Sprint(value),
+		Stack:   string(stack),
+		Time:    q.now().UTC(),
+	}
+	if headers := message.Headers(msg); len(headers) > 0 {
+		record.Headers = headers
+	}
+	if err := q.dest.Quarantine(ctx, record); err != nil {
+		return errors.Wrapf(err, "failed to quarantine message after handler panicked: %v", value)
+	}
+	if q.quarantined != nil {
+		q.quarantined.Inc()
+	}
+	return nil
+}
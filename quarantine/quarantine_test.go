@@ -0,0 +1,189 @@
+package quarantine_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+	"github.com/uw-labs/substrate-tools/pool"
+	"github.com/uw-labs/substrate-tools/quarantine"
+	substratesync "github.com/uw-labs/substrate-tools/sync"
+)
+
+type recorder struct {
+	mutex   sync.Mutex
+	records []quarantine.Record
+	err     error
+}
+
+func (r *recorder) Quarantine(_ context.Context, record quarantine.Record) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	r.records = append(r.records, record)
+	return nil
+}
+
+func poisoned(_ context.Context, msg substrate.Message) error {
+	if string(msg.Data()) == "poison" {
+		panic("boom")
+	}
+	return nil
+}
+
+func TestHandler(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	registry := prometheus.NewRegistry()
+	dest := &recorder{}
+	handler := quarantine.Handler(poisoned, dest,
+		quarantine.WithQuarantinedCounter(prometheus.CounterOpts{
+			Name: "quarantine_test_quarantined_total",
+			Help: "quarantine_test_quarantined_total",
+		}),
+		quarantine.WithRegisterer(registry),
+	)
+
+	source := mock.NewSource(
+		message.FromString("a"),
+		message.NewEnvelopedMessage(message.Envelope{Payload: []byte("poison"), Headers: map[string]string{"key": "k"}}),
+		message.FromString("b"),
+	)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- substratesync.ConsumeEach(ctx, source, handler)
+	}()
+
+	// The poison message is acknowledged, and consumption carries on.
+	select {
+	case <-source.AllAcked():
+	case err := <-errs:
+		require.FailNow(t, "consumption failed", "%v", err)
+	}
+	cancel()
+	require.NoError(t, <-errs)
+
+	require.Len(t, dest.records, 1)
+	record := dest.records[0]
+	require.Equal(t, "poison", string(record.Payload))
+	require.Equal(t, map[string]string{"key": "k"}, record.Headers)
+	require.Equal(t, "boom", record.Panic)
+	require.Contains(t, record.Stack, "quarantine_test.poisoned")
+	require.False(t, record.Time.IsZero())
+
+	require.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP quarantine_test_quarantined_total quarantine_test_quarantined_total
+# TYPE quarantine_test_quarantined_total counter
+quarantine_test_quarantined_total 1
+`)))
+}
+
+func TestHandler_Errors(t *testing.T) {
+	ctx := context.Background()
+
+	// Errors of the handler are returned unchanged.
+	handler := quarantine.Handler(func(context.Context, substrate.Message) error {
+		return errors.New("failed")
+	}, &recorder{})
+	require.EqualError(t, handler(ctx, message.FromString("a")), "failed")
+
+	// The panic isn't swallowed if the message can't be quarantined.
+	handler = quarantine.Handler(poisoned, &recorder{err: errors.New("unavailable")})
+	require.EqualError(t, handler(ctx, message.FromString("poison")),
+		"failed to quarantine message after handler panicked: boom: unavailable")
+}
+
+func TestPanicHandler(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	dest := &recorder{}
+	source := mock.NewSource(message.FromString("poison"), message.FromString("a"))
+	errs := make(chan error, 1)
+	go func() {
+		errs <- pool.Consume(ctx, source, poisoned, pool.WithPanicHandler(quarantine.PanicHandler(dest)))
+	}()
+
+	select {
+	case <-source.AllAcked():
+	case err := <-errs:
+		require.FailNow(t, "consumption failed", "%v", err)
+	}
+	cancel()
+	require.NoError(t, <-errs)
+
+	require.Len(t, dest.records, 1)
+	require.Equal(t, "boom", dest.records[0].Panic)
+	require.NotEmpty(t, dest.records[0].Stack)
+}
+
+func TestFileDestination(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quarantine.jsonl")
+	dest, err := quarantine.NewFileDestination(path)
+	require.NoError(t, err)
+
+	handler := quarantine.Handler(poisoned, dest)
+	require.NoError(t, handler(context.Background(), message.FromString("poison")))
+	require.NoError(t, handler(context.Background(), message.FromString("poison")))
+	require.NoError(t, dest.Close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var records []quarantine.Record
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var record quarantine.Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, records, 2)
+	require.Equal(t, "poison", string(records[0].Payload))
+}
+
+func TestSinkDestination(t *testing.T) {
+	sink := mock.NewSink()
+	dest := quarantine.NewSinkDestination(sink)
+
+	handler := quarantine.Handler(poisoned, dest)
+	require.NoError(t, handler(context.Background(), message.FromString("poison")))
+	require.NoError(t, dest.Close())
+	require.True(t, sink.WasClosed())
+
+	published := sink.Published()
+	require.Len(t, published, 1)
+	var record quarantine.Record
+	require.NoError(t, json.Unmarshal([]byte(published[0]), &record))
+	require.Equal(t, "poison", string(record.Payload))
+	require.Equal(t, "boom", record.Panic)
+}
+
+func TestSinkDestination_Failure(t *testing.T) {
+	sink := mock.NewSink().FailAt(1, errors.New("broken"))
+	dest := quarantine.NewSinkDestination(sink)
+
+	err := dest.Quarantine(context.Background(), quarantine.Record{Payload: []byte("poison")})
+	require.EqualError(t, err, "failed to publish quarantine record: broken")
+	require.NoError(t, dest.Close())
+}
@@ -0,0 +1,116 @@
+// Package redact provides message sink and source wrappers that redact payloads using rules, such as JSON field
+// paths or regular expressions, so that personal data never reaches logging, recording or debugging tools.
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Replacement is the value redacted data is replaced with.
+const Replacement = "[REDACTED]"
+
+// Wildcard matches any field of an object or any element of an array in JSON field paths.
+const Wildcard = "*"
+
+// Rule redacts payloads.
+type Rule interface {
+	// Redact returns the redacted payload, which may be the provided one if nothing had to be redacted.
+	Redact(payload []byte) ([]byte, error)
+}
+
+// RuleFunc is a function implementing Rule.
+type RuleFunc func(payload []byte) ([]byte, error)
+
+// Redact calls the function with the payload.
+func (f RuleFunc) Redact(payload []byte) ([]byte, error) {
+	return f(payload)
+}
+
+// Payload returns the payload redacted by all the rules, in order.
+func Payload(payload []byte, rules ...Rule) ([]byte, error) {
+	for _, rule := range rules {
+		var err error
+		if payload, err = rule.Redact(payload); err != nil {
+			return nil, err
+		}
+	}
+	return payload, nil
+}
+
+// Pattern returns a rule replacing every match of the regular expression with Replacement. It applies to any
+// payload, e.g. plain text logs.
+func Pattern(re *regexp.Regexp) Rule {
+	return RuleFunc(func(payload []byte) ([]byte, error) {
+		if !re.Match(payload) {
+			return payload, nil
+		}
+		return re.ReplaceAllLiteral(payload, []byte(Replacement)), nil
+	})
+}
+
+// JSONFields returns a rule replacing the values at the dot separated paths of JSON payloads with Replacement,
+// whatever their type. Path segments select fields of objects, or elements of arrays by their index, and Wildcard
+// selects all of them, e.g. customers.*.email. Payloads that aren't JSON objects or arrays are left unchanged, so
+// that the rule can be combined with patterns for text payloads. Payloads are only re-encoded, with sorted keys,
+// if a value was redacted.
+func JSONFields(paths ...string) Rule {
+	split := make([][]string, len(paths))
+	for i, path := range paths {
+		split[i] = strings.Split(path, ".")
+	}
+	return RuleFunc(func(payload []byte) ([]byte, error) {
+		trimmed := bytes.TrimSpace(payload)
+		if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+			return payload, nil
+		}
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		dec.UseNumber()
+		var value interface{}
+		if err := dec.Decode(&value); err != nil {
+			return payload, nil
+		}
+
+		redacted := false
+		for _, path := range split {
+			value = redactPath(value, path, &redacted)
+		}
+		if !redacted {
+			return payload, nil
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to encode redacted payload")
+		}
+		return data, nil
+	})
+}
+
+// redactPath returns the value with the value at the path replaced, recording whether anything was replaced.
+func redactPath(value interface{}, path []string, redacted *bool) interface{} {
+	if len(path) == 0 {
+		*redacted = true
+		return Replacement
+	}
+	field, rest := path[0], path[1:]
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if field == Wildcard || field == key {
+				v[key] = redactPath(child, rest, redacted)
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			if field == Wildcard || field == strconv.Itoa(i) {
+				v[i] = redactPath(child, rest, redacted)
+			}
+		}
+	}
+	return value
+}
@@ -0,0 +1,143 @@
+package redact_test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/conformance"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+	"github.com/uw-labs/substrate-tools/redact"
+)
+
+var email = regexp.MustCompile(`[\w.+-]+@[\w-]+\.[\w.]+`)
+
+func TestPayload(t *testing.T) {
+	rules := []redact.Rule{
+		redact.JSONFields("user.email", "cards.*.number", "items.1"),
+		redact.Pattern(email),
+	}
+
+	tests := []struct {
+		name     string
+		payload  string
+		expected string
+	}{
+		{
+			name:     "json",
+			payload:  `{"user":{"email":"ann@example.com","id":12345678901234567890},"cards":[{"number":"4111"},{"number":"5500"}],"items":["a","b"]}`,
+			expected: `{"cards":[{"number":"[REDACTED]"},{"number":"[REDACTED]"}],"items":["a","[REDACTED]"],"user":{"email":"[REDACTED]","id":12345678901234567890}}`,
+		},
+		{
+			name:     "nested object",
+			payload:  `{"user":{"email":{"work":"w","home":"h"}}}`,
+			expected: `{"user":{"email":"[REDACTED]"}}`,
+		},
+		{
+			name:     "pattern in json",
+			payload:  `{"note":"contact bob@example.org"}`,
+			expected: `{"note":"contact [REDACTED]"}`,
+		},
+		{
+			name:     "untouched json",
+			payload:  `{ "id": 1 }`,
+			expected: `{ "id": 1 }`,
+		},
+		{
+			name:     "text",
+			payload:  "login by ann@example.com failed",
+			expected: "login by [REDACTED] failed",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			redacted, err := redact.Payload([]byte(test.payload), rules...)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, string(redacted))
+		})
+	}
+}
+
+func TestSink(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	backend := mock.NewSink()
+	sink := redact.NewAsyncMessageSink(backend, redact.Pattern(email))
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	published := []substrate.Message{
+		message.FromString("from ann@example.com"),
+		message.NewEnvelopedMessage(message.Envelope{Payload: []byte("no pii"), Headers: map[string]string{"k": "v"}}),
+	}
+	for _, msg := range published {
+		messages <- msg
+		require.Equal(t, msg, <-acks)
+	}
+	require.Equal(t, []string{"from [REDACTED]", "no pii"}, backend.Published())
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestSink_RuleError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	sink := redact.NewAsyncMessageSink(mock.NewSink(), redact.RuleFunc(func([]byte) ([]byte, error) {
+		return nil, errors.New("boom")
+	}))
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message, 1)
+	messages <- message.FromString("payload")
+	require.EqualError(t, sink.PublishMessages(ctx, acks, messages), "failed to redact message: boom")
+}
+
+func TestSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	backend := mock.NewSource(
+		message.FromString(`{"email":"ann@example.com","id":1}`),
+		message.FromString("plain text"),
+	)
+	source := redact.NewAsyncMessageSource(backend, redact.JSONFields("email"))
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	var consumed []string
+	for i := 0; i < 2; i++ {
+		msg := <-messages
+		consumed = append(consumed, string(msg.Data()))
+		acks <- msg
+	}
+	require.Equal(t, []string{`{"email":"[REDACTED]","id":1}`, "plain text"}, consumed)
+
+	// The mock source terminates with an error if acknowledgements are out of order.
+	<-backend.AllAcked()
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestConformance(t *testing.T) {
+	conformance.TestAsyncSink(t, func(_ *testing.T, backend substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+		return redact.NewAsyncMessageSink(backend, redact.Pattern(email))
+	})
+	conformance.TestAsyncSource(t, func(_ *testing.T, backend substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		return redact.NewAsyncMessageSource(backend, redact.Pattern(email))
+	})
+}
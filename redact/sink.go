@@ -0,0 +1,78 @@
+package redact
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/internal/transform"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that redacts payloads using the rules
+// before publishing them, e.g. to wrap a recorder or a sink feeding debugging tools. Headers are preserved, and the
+// original messages are acknowledged. Publishing fails if a rule returns an error.
+func NewAsyncMessageSink(sink substrate.AsyncMessageSink, rules ...Rule) substrate.AsyncMessageSink {
+	return &redactSink{
+		sink:  sink,
+		rules: rules,
+	}
+}
+
+type redactSink struct {
+	sink  substrate.AsyncMessageSink
+	rules []Rule
+}
+
+func (s *redactSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	return transform.PublishMessages(ctx, "redact", s.sink, acks, messages, func(_ context.Context, msg substrate.Message) (substrate.Message, error) {
+		return redact(msg, s.rules)
+	})
+}
+
+func (s *redactSink) Close() error {
+	return s.sink.Close()
+}
+
+func (s *redactSink) Status() (*substrate.Status, error) {
+	return s.sink.Status()
+}
+
+// redact returns the message with a redacted payload, delivered or published on behalf of the provided one.
+func redact(msg substrate.Message, rules []Rule) (*redactedMessage, error) {
+	data, err := Payload(msg.Data(), rules...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to redact message")
+	}
+	return &redactedMessage{
+		data:    data,
+		headers: message.Headers(msg),
+		msg:     msg,
+	}, nil
+}
+
+// redactedMessage is a message with a redacted payload.
+type redactedMessage struct {
+	data    []byte
+	headers map[string]string
+	msg     substrate.Message
+}
+
+// Data returns the redacted payload.
+func (msg *redactedMessage) Data() []byte {
+	return msg.data
+}
+
+// Headers returns the headers of the original message.
+func (msg *redactedMessage) Headers() map[string]string {
+	return msg.headers
+}
+
+// DiscardPayload discards the payload.
+func (msg *redactedMessage) DiscardPayload() {
+	msg.data = nil
+	if dMsg, ok := msg.msg.(substrate.DiscardableMessage); ok {
+		dMsg.DiscardPayload()
+	}
+}
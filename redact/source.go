@@ -0,0 +1,86 @@
+package redact
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that redacts the payloads of consumed
+// messages using the rules before delivering them, e.g. to wrap the source of a debugging tool. Headers are
+// preserved. Consumption fails if a rule returns an error.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, rules ...Rule) substrate.AsyncMessageSource {
+	return &redactSource{
+		source: source,
+		rules:  rules,
+	}
+}
+
+type redactSource struct {
+	source substrate.AsyncMessageSource
+	rules  []Rule
+}
+
+func (s *redactSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "redact"})
+	rg, ctx := rungroup.New(ctx)
+
+	consumed := make(chan substrate.Message, cap(messages))
+	toAck := make(chan substrate.Message, cap(acks))
+
+	rg.Go(func() error {
+		return s.source.ConsumeMessages(ctx, consumed, toAck)
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-consumed:
+				rMsg, err := redact(msg, s.rules)
+				if err != nil {
+					return err
+				}
+				emit.Publish(rMsg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case messages <- rMsg:
+				}
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				emit.Ack(ack)
+				rMsg, ok := ack.(*redactedMessage)
+				if !ok {
+					return errors.Errorf("unexpected message acknowledged: %v", ack)
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case toAck <- rMsg.msg:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+func (s *redactSource) Close() error {
+	return s.source.Close()
+}
+
+func (s *redactSource) Status() (*substrate.Status, error) {
+	return s.source.Status()
+}
@@ -0,0 +1,28 @@
+// Package replay provides a sink wrapper that records published messages and a source that replays
+// recorded messages, making it possible to reproduce production traffic in tests.
+package replay
+
+import (
+	"time"
+)
+
+// record is a single recorded message. Recordings consist of one JSON encoded record per line.
+type record struct {
+	Time    time.Time         `json:"time"`
+	Data    []byte            `json:"data"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// replayMessage is a message replayed from a record.
+type replayMessage struct {
+	data    []byte
+	headers map[string]string
+}
+
+func (msg *replayMessage) Data() []byte {
+	return msg.data
+}
+
+func (msg *replayMessage) Headers() map[string]string {
+	return msg.headers
+}
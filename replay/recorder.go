@@ -0,0 +1,162 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/sync/rungroup"
+
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+// NewRecorder returns an instance of substrate.AsyncMessageSink that publishes messages to the provided sink
+// and records every message acknowledged by it to the writer, together with the time at which it was received.
+// If the sink is nil, messages are only recorded and acknowledged straight away. Closing the recorder closes
+// the writer if it implements io.Closer.
+func NewRecorder(sink substrate.AsyncMessageSink, w io.Writer) substrate.AsyncMessageSink {
+	return &recorder{
+		impl: sink,
+		w:    w,
+		enc:  json.NewEncoder(w),
+	}
+}
+
+// recorder implements substrate.AsyncMessageSink that records published messages.
+type recorder struct {
+	impl substrate.AsyncMessageSink
+	w    io.Writer
+	enc  *json.Encoder
+}
+
+// PublishMessages publishes messages to the underlying sink and records them once they are acknowledged.
+func (r *recorder) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "replay"})
+	if r.impl == nil {
+		return emit.Error(r.recordMessages(ctx, emit, acks, messages))
+	}
+
+	rg, ctx := rungroup.New(ctx)
+
+	toPublish := make(chan substrate.Message, cap(messages))
+	published := make(chan substrate.Message, cap(acks))
+
+	var (
+		mutex    sync.Mutex
+		received []time.Time
+	)
+
+	rg.Go(func() error {
+		return r.impl.PublishMessages(ctx, published, toPublish)
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-messages:
+				emit.Publish(msg)
+				mutex.Lock()
+				received = append(received, time.Now())
+				mutex.Unlock()
+
+				select {
+				case <-ctx.Done():
+					return nil
+				case toPublish <- msg:
+				}
+			}
+		}
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-published:
+				// Sinks acknowledge messages in order, so the acknowledgement is for the oldest message received.
+				mutex.Lock()
+				if len(received) == 0 {
+					mutex.Unlock()
+					return errors.Errorf("unexpected message acknowledged: %v", ack)
+				}
+				at := received[0]
+				received = received[1:]
+				mutex.Unlock()
+
+				if err := r.record(at, ack); err != nil {
+					return err
+				}
+				emit.Ack(ack)
+				select {
+				case <-ctx.Done():
+					return nil
+				case acks <- ack:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+// recordMessages records and acknowledges messages, it is used when there is no underlying sink.
+func (r *recorder) recordMessages(ctx context.Context, emit events.Emitter, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-messages:
+			emit.Publish(msg)
+			if err := r.record(time.Now(), msg); err != nil {
+				return err
+			}
+			emit.Ack(msg)
+			select {
+			case <-ctx.Done():
+				return nil
+			case acks <- msg:
+			}
+		}
+	}
+}
+
+func (r *recorder) record(at time.Time, msg substrate.Message) error {
+	rec := record{
+		Time:    at,
+		Data:    msg.Data(),
+		Headers: message.Headers(msg),
+	}
+	if len(rec.Headers) == 0 {
+		rec.Headers = nil
+	}
+	if err := r.enc.Encode(rec); err != nil {
+		return errors.Wrap(err, "failed to record message")
+	}
+	return nil
+}
+
+// Close closes the underlying sink and the writer, if it implements io.Closer.
+func (r *recorder) Close() (err error) {
+	if r.impl != nil {
+		err = multierror.Append(err, r.impl.Close()).ErrorOrNil()
+	}
+	if closer, ok := r.w.(io.Closer); ok {
+		err = multierror.Append(err, closer.Close()).ErrorOrNil()
+	}
+	return err
+}
+
+// Status returns the status of the underlying sink, the recorder always reports working without one.
+func (r *recorder) Status() (*substrate.Status, error) {
+	if r.impl == nil {
+		return &substrate.Status{Working: true}, nil
+	}
+	return r.impl.Status()
+}
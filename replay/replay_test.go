@@ -0,0 +1,137 @@
+package replay_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/replay"
+)
+
+func publish(t *testing.T, sink substrate.AsyncMessageSink, msgs ...substrate.Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	for _, msg := range msgs {
+		messages <- msg
+		require.Equal(t, msg, <-acks)
+	}
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func consume(t *testing.T, source substrate.AsyncMessageSource, count int) []substrate.Message {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	var consumed []substrate.Message
+	for i := 0; i < count; i++ {
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "failed to consume all messages")
+		case msg := <-messages:
+			consumed = append(consumed, msg)
+			acks <- msg
+		}
+	}
+	cancel()
+	require.NoError(t, <-errs)
+	return consumed
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	var recording bytes.Buffer
+	broker := mem.NewBroker()
+	recorder := replay.NewRecorder(broker.NewAsyncMessageSink("topic"), &recording)
+
+	publish(t, recorder,
+		message.FromString("one"),
+		message.NewEnvelopedMessage(message.Envelope{
+			Headers: map[string]string{"key": "value"},
+			Payload: []byte("two"),
+		}),
+	)
+	require.NoError(t, recorder.Close())
+	require.Equal(t, [][]byte{[]byte("one"), []byte("two")}, broker.Messages("topic"))
+
+	source, err := replay.NewReplaySource(bytes.NewReader(recording.Bytes()))
+	require.NoError(t, err)
+
+	// Recordings can be replayed any number of times.
+	for i := 0; i < 2; i++ {
+		consumed := consume(t, source, 2)
+		require.Equal(t, "one", string(consumed[0].Data()))
+		require.Empty(t, message.Headers(consumed[0]))
+		require.Equal(t, "two", string(consumed[1].Data()))
+		require.Equal(t, map[string]string{"key": "value"}, message.Headers(consumed[1]))
+	}
+}
+
+func TestRecorder_WithoutSink(t *testing.T) {
+	var recording bytes.Buffer
+	recorder := replay.NewRecorder(nil, &recording)
+	publish(t, recorder, message.FromString("one"), message.FromString("two"))
+
+	require.Equal(t, 2, strings.Count(recording.String(), "\n"))
+}
+
+func TestReplaySource_OriginalTiming(t *testing.T) {
+	recording := strings.Join([]string{
+		`{"time":"2020-01-01T00:00:00Z","data":"b25l"}`,
+		`{"time":"2020-01-01T00:00:00.2Z","data":"dHdv"}`,
+	}, "\n")
+
+	source, err := replay.NewReplaySource(strings.NewReader(recording), replay.WithOriginalTiming(2))
+	require.NoError(t, err)
+
+	start := time.Now()
+	consumed := consume(t, source, 2)
+	require.GreaterOrEqual(t, time.Since(start), time.Millisecond*100)
+	require.Equal(t, "one", string(consumed[0].Data()))
+	require.Equal(t, "two", string(consumed[1].Data()))
+}
+
+func TestReplaySource_InvalidRecording(t *testing.T) {
+	_, err := replay.NewReplaySource(strings.NewReader("not json"))
+	require.Error(t, err)
+}
+
+func TestReplaySource_InvalidAck(t *testing.T) {
+	source, err := replay.NewReplaySource(strings.NewReader(`{"time":"2020-01-01T00:00:00Z","data":"b25l"}`))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	<-messages
+	acks <- message.FromString("one")
+	require.IsType(t, substrate.InvalidAckError{}, <-errs)
+}
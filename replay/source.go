@@ -0,0 +1,196 @@
+package replay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+)
+
+// ErrSourceClosed is returned by a replay source that was already closed.
+var ErrSourceClosed = errors.New("source already closed")
+
+// ReplaySourceOption is a function which sets a replay source configuration option.
+type ReplaySourceOption func(s *replaySource)
+
+// WithOriginalTiming makes the replay source wait between delivering messages for as long as passed between
+// them being recorded. The delays are divided by the speed, so e.g. a speed of 2 replays twice as fast.
+func WithOriginalTiming(speed float64) ReplaySourceOption {
+	return func(s *replaySource) {
+		s.timed = true
+		s.speed = speed
+	}
+}
+
+// NewReplaySource returns an instance of substrate.AsyncMessageSource that replays the messages recorded by
+// a recorder. The whole recording is read upfront, so that it can be replayed any number of times. It returns
+// an error if the recording can't be read.
+func NewReplaySource(r io.Reader, opts ...ReplaySourceOption) (substrate.AsyncMessageSource, error) {
+	s := &replaySource{speed: 1}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.speed <= 0 {
+		s.speed = 1
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, errors.Wrapf(err, "failed to decode record on line %d", line)
+		}
+		s.records = append(s.records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read recording")
+	}
+	return s, nil
+}
+
+// replaySource implements substrate.AsyncMessageSource that replays recorded messages.
+type replaySource struct {
+	records []record
+	timed   bool
+	speed   float64
+
+	mutex  sync.RWMutex
+	cancel func()
+	closed bool
+}
+
+// ConsumeMessages replays all recorded messages, waits for all of them to be acknowledged in the correct
+// order and then waits for the provided context to be cancelled.
+func (s *replaySource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "replay"})
+	ctx, cancel, err := s.init(ctx)
+	if err != nil {
+		return emit.Error(err)
+	}
+	defer cancel()
+
+	replayed := make([]*replayMessage, len(s.records))
+	for i, rec := range s.records {
+		replayed[i] = &replayMessage{data: rec.Data, headers: rec.Headers}
+	}
+
+	toAck := 0
+	checkAck := func(ack substrate.Message) error {
+		if toAck >= len(replayed) || ack != substrate.Message(replayed[toAck]) {
+			var expected substrate.Message
+			if toAck < len(replayed) {
+				expected = replayed[toAck]
+			}
+			return emit.Error(substrate.InvalidAckError{Acked: ack, Expected: expected})
+		}
+		emit.Ack(ack)
+		toAck++
+		return nil
+	}
+
+	for i, msg := range replayed {
+		if s.timed && i > 0 {
+			delay := time.Duration(float64(s.records[i].Time.Sub(s.records[i-1].Time)) / s.speed)
+			if elapsed, err := s.wait(ctx, acks, checkAck, delay); !elapsed {
+				return err
+			}
+		}
+		for sent := false; !sent; {
+			select {
+			case <-ctx.Done():
+				return nil
+			case messages <- msg:
+				emit.Publish(msg)
+				sent = true
+			case ack := <-acks:
+				if err := checkAck(ack); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for toAck < len(replayed) {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ack := <-acks:
+			if err := checkAck(ack); err != nil {
+				return err
+			}
+		}
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+// wait waits for the given delay while processing acknowledgements. It reports whether the delay elapsed
+// and returns the error of an invalid acknowledgement.
+func (s *replaySource) wait(ctx context.Context, acks <-chan substrate.Message, checkAck func(substrate.Message) error, delay time.Duration) (bool, error) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case <-timer.C:
+			return true, nil
+		case ack := <-acks:
+			if err := checkAck(ack); err != nil {
+				return false, err
+			}
+		}
+	}
+}
+
+func (s *replaySource) init(ctx context.Context) (context.Context, func(), error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil, nil, ErrSourceClosed
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s.cancel = cancel
+	return ctx, cancel, nil
+}
+
+// Close closes the source, it causes any running call to ConsumeMessages to return.
+func (s *replaySource) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.cancel != nil {
+		s.cancel()
+	}
+	return nil
+}
+
+// Status returns the status of the source. It reports not working after the source was closed.
+func (s *replaySource) Status() (*substrate.Status, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if !s.closed {
+		return &substrate.Status{Working: true}, nil
+	}
+	return &substrate.Status{
+		Working:  false,
+		Problems: []string{"source already closed"},
+	}, nil
+}
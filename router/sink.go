@@ -0,0 +1,277 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/sync/rungroup"
+
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+)
+
+// FallbackRoute is the route label used in metrics for messages published to the fallback sink.
+const FallbackRoute = "fallback"
+
+var (
+	// ErrNoMessageSinks is an error indicating that neither routes nor a fallback sink were provided to the routing sink.
+	ErrNoMessageSinks = errors.New("no message sinks provided")
+	// ErrUnknownRoute is an error indicating that a message was routed to a route without a sink and
+	// no fallback sink was configured.
+	ErrUnknownRoute = errors.New("no message sink for route")
+)
+
+// RouteFunc returns the route of the provided message.
+type RouteFunc func(msg substrate.Message) string
+
+// SinkOption is a function which sets a routing sink configuration option.
+type SinkOption func(s *routingSink)
+
+// WithFallbackSink sets the sink that messages with a route that has no sink are published to.
+// Without a fallback sink, publishing fails with ErrUnknownRoute for such messages.
+func WithFallbackSink(sink substrate.AsyncMessageSink) SinkOption {
+	return func(s *routingSink) {
+		s.fallback = sink
+	}
+}
+
+// WithRouteCounter enables a counter of acknowledged messages labelled with the route they were published
+// to. Messages published to the fallback sink are labelled with FallbackRoute. It panics in case it can't
+// register the metric.
+func WithRouteCounter(counterOpts prometheus.CounterOpts) SinkOption {
+	return func(s *routingSink) {
+		s.counter = metrics.Register(prometheus.NewCounterVec(counterOpts, []string{"route"})).(*prometheus.CounterVec)
+	}
+}
+
+// NewRoutingSink returns an instance of substrate.AsyncMessageSink that publishes each message to the sink
+// of the route returned for it by the route function. Messages are acknowledged in the order in which they
+// were received, regardless of which sink they were published to. It returns an error if neither routes nor
+// a fallback sink are provided.
+func NewRoutingSink(route RouteFunc, sinks map[string]substrate.AsyncMessageSink, opts ...SinkOption) (substrate.AsyncMessageSink, error) {
+	s := &routingSink{
+		route: route,
+		sinks: sinks,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if len(s.sinks) == 0 && s.fallback == nil {
+		return nil, ErrNoMessageSinks
+	}
+
+	for name := range s.sinks {
+		s.routes = append(s.routes, name)
+	}
+	sort.Strings(s.routes)
+
+	if s.counter != nil {
+		for _, name := range s.routes {
+			s.counter.WithLabelValues(name).Add(0)
+		}
+		if s.fallback != nil {
+			s.counter.WithLabelValues(FallbackRoute).Add(0)
+		}
+	}
+	return s, nil
+}
+
+// routingSink implements substrate.AsyncMessageSink that publishes messages to a sink chosen per message.
+type routingSink struct {
+	route    RouteFunc
+	sinks    map[string]substrate.AsyncMessageSink
+	routes   []string
+	fallback substrate.AsyncMessageSink
+	counter  *prometheus.CounterVec
+}
+
+// PublishMessages publishes messages to the sinks of their routes and acknowledges them in order once
+// the sinks did.
+func (s *routingSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "router"})
+	rg, ctx := rungroup.New(ctx)
+
+	pending := make(chan *routedMessage)
+	done := make(chan *routedMessage)
+
+	children := make(map[string]*routeChild, len(s.sinks))
+	start := func(name string, sink substrate.AsyncMessageSink) *routeChild {
+		child := &routeChild{
+			name: name,
+			in:   make(chan substrate.Message, cap(messages)),
+		}
+		published := make(chan substrate.Message, cap(messages))
+
+		rg.Go(func() error {
+			if err := sink.PublishMessages(ctx, published, child.in); err != nil {
+				return errors.Wrapf(err, "sink for route %q failed", name)
+			}
+			return nil
+		})
+		rg.Go(emit.Errors(func() error {
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case ack := <-published:
+					// Sinks acknowledge messages in order, so the acknowledged message is the oldest one in flight.
+					rMsg := child.pop()
+					if rMsg == nil {
+						return errors.Errorf("unexpected message acknowledged on route %q: %v", name, ack)
+					}
+					select {
+					case <-ctx.Done():
+						return nil
+					case done <- rMsg:
+					}
+				}
+			}
+		}))
+		return child
+	}
+	for _, name := range s.routes {
+		children[name] = start(name, s.sinks[name])
+	}
+	var fallback *routeChild
+	if s.fallback != nil {
+		fallback = start(FallbackRoute, s.fallback)
+	}
+
+	// Send each message to the sink of its route.
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-messages:
+				emit.Publish(msg)
+				route := s.route(msg)
+				child, ok := children[route]
+				if !ok {
+					if fallback == nil {
+						return errors.Wrapf(ErrUnknownRoute, "route %q", route)
+					}
+					child = fallback
+				}
+
+				rMsg := &routedMessage{msg: msg, route: child.name}
+				select {
+				case <-ctx.Done():
+					return nil
+				case pending <- rMsg:
+				}
+				child.push(rMsg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case child.in <- msg:
+				}
+			}
+		}
+	}))
+
+	// Acknowledge messages in the order in which they were received.
+	rg.Go(func() error {
+		var queue []*routedMessage
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case rMsg := <-pending:
+				queue = append(queue, rMsg)
+			case rMsg := <-done:
+				rMsg.acked = true
+				if s.counter != nil {
+					s.counter.WithLabelValues(rMsg.route).Inc()
+				}
+				for len(queue) > 0 && queue[0].acked {
+					emit.Ack(queue[0].msg)
+					select {
+					case <-ctx.Done():
+						return nil
+					case acks <- queue[0].msg:
+					}
+					queue = queue[1:]
+				}
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+// Close closes all the underlying sinks and returns all errors encountered.
+func (s *routingSink) Close() (err error) {
+	for _, name := range s.routes {
+		err = multierror.Append(err, s.sinks[name].Close()).ErrorOrNil()
+	}
+	if s.fallback != nil {
+		err = multierror.Append(err, s.fallback.Close()).ErrorOrNil()
+	}
+	return err
+}
+
+// Status calls the status method on all underlying sinks. It collects all errors encountered and
+// only reports working status if all the underlying sinks do.
+func (s *routingSink) Status() (status *substrate.Status, err error) {
+	status = &substrate.Status{Working: true}
+
+	check := func(name string, sink substrate.AsyncMessageSink) {
+		sinkStatus, sinkErr := sink.Status()
+		if sinkErr != nil {
+			status.Working = false
+			err = multierror.Append(err, sinkErr)
+		} else {
+			status.Working = status.Working && sinkStatus.Working
+			for _, problem := range sinkStatus.Problems {
+				status.Problems = append(status.Problems, fmt.Sprintf("route %s: %s", name, problem))
+			}
+		}
+	}
+	for _, name := range s.routes {
+		check(name, s.sinks[name])
+	}
+	if s.fallback != nil {
+		check(FallbackRoute, s.fallback)
+	}
+
+	return status, err
+}
+
+// routeChild keeps track of the messages in flight for one of the routes.
+type routeChild struct {
+	name string
+	in   chan substrate.Message
+
+	mutex    sync.Mutex
+	inFlight []*routedMessage
+}
+
+func (c *routeChild) push(rMsg *routedMessage) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.inFlight = append(c.inFlight, rMsg)
+}
+
+func (c *routeChild) pop() *routedMessage {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.inFlight) == 0 {
+		return nil
+	}
+	rMsg := c.inFlight[0]
+	c.inFlight = c.inFlight[1:]
+	return rMsg
+}
+
+type routedMessage struct {
+	msg   substrate.Message
+	route string
+	acked bool
+}
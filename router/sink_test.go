@@ -0,0 +1,109 @@
+package router_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/router"
+)
+
+func routeByPrefix(msg substrate.Message) string {
+	return string(msg.Data()[:1])
+}
+
+func publish(ctx context.Context, t *testing.T, sink substrate.AsyncMessageSink, payloads ...string) error {
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	for _, payload := range payloads {
+		msg := message.FromString(payload)
+		select {
+		case err := <-errs:
+			return err
+		case messages <- msg:
+		}
+		select {
+		case err := <-errs:
+			return err
+		case ack := <-acks:
+			require.Equal(t, msg, ack)
+		}
+	}
+	return nil
+}
+
+func TestNewRoutingSink_Error(t *testing.T) {
+	_, err := router.NewRoutingSink(routeByPrefix, nil)
+	require.Equal(t, router.ErrNoMessageSinks, err)
+}
+
+func TestRoutingSink_PublishMessages(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	sink, err := router.NewRoutingSink(routeByPrefix, map[string]substrate.AsyncMessageSink{
+		"a": broker.NewAsyncMessageSink("topic-a"),
+		"b": broker.NewAsyncMessageSink("topic-b"),
+	},
+		router.WithFallbackSink(broker.NewAsyncMessageSink("topic-fallback")),
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, publish(ctx, t, sink, "a1", "b1", "c1", "a2", "b2", "a3"))
+
+	require.Equal(t, [][]byte{[]byte("a1"), []byte("a2"), []byte("a3")}, broker.Messages("topic-a"))
+	require.Equal(t, [][]byte{[]byte("b1"), []byte("b2")}, broker.Messages("topic-b"))
+	require.Equal(t, [][]byte{[]byte("c1")}, broker.Messages("topic-fallback"))
+}
+
+func TestRoutingSink_RouteCounter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	sink, err := router.NewRoutingSink(routeByPrefix, map[string]substrate.AsyncMessageSink{
+		"a": broker.NewAsyncMessageSink("topic-a"),
+	},
+		router.WithFallbackSink(broker.NewAsyncMessageSink("topic-fallback")),
+		router.WithRouteCounter(prometheus.CounterOpts{Name: "routing_sink_test_route_messages_total"}),
+	)
+	require.NoError(t, err)
+
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "routing_sink_test_route_messages_total"}, []string{"route"})
+	err = prometheus.Register(counter)
+	require.IsType(t, prometheus.AlreadyRegisteredError{}, err)
+	counter = err.(prometheus.AlreadyRegisteredError).ExistingCollector.(*prometheus.CounterVec)
+	before, beforeFallback := testutil.ToFloat64(counter.WithLabelValues("a")), testutil.ToFloat64(counter.WithLabelValues(router.FallbackRoute))
+
+	require.NoError(t, publish(ctx, t, sink, "a1", "b1", "a2"))
+
+	require.Equal(t, before+2, testutil.ToFloat64(counter.WithLabelValues("a")))
+	require.Equal(t, beforeFallback+1, testutil.ToFloat64(counter.WithLabelValues(router.FallbackRoute)))
+}
+
+func TestRoutingSink_UnknownRoute(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	sink, err := router.NewRoutingSink(routeByPrefix, map[string]substrate.AsyncMessageSink{
+		"a": mem.NewBroker().NewAsyncMessageSink("topic-a"),
+	})
+	require.NoError(t, err)
+
+	err = publish(ctx, t, sink, "a1", "b1")
+	require.Error(t, err)
+	require.Equal(t, router.ErrUnknownRoute, errors.Cause(err))
+}
@@ -0,0 +1,86 @@
+// Package sample provides a message source wrapper that only delivers a sample of the messages, for low cost
+// monitoring consumers of high volume topics.
+package sample
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/filter"
+)
+
+// Sampler is a function that reports whether a message is part of the sample.
+type Sampler func(msg substrate.Message) bool
+
+// Random returns a sampler choosing each message at random with the probability given by the fraction.
+func Random(fraction float64) Sampler {
+	return func(substrate.Message) bool {
+		return rand.Float64() < fraction
+	}
+}
+
+// EveryNth returns a sampler choosing every n-th message, starting with the first one. It chooses every message
+// if n isn't greater than 1. The count is shared by all the sources using the sampler.
+func EveryNth(n int) Sampler {
+	var count atomic.Uint64
+	return func(substrate.Message) bool {
+		return n <= 1 || (count.Add(1)-1)%uint64(n) == 0
+	}
+}
+
+// KeyFunc is a function that extracts the key of a message.
+type KeyFunc func(msg substrate.Message) string
+
+// KeyHash returns a sampler choosing the messages whose key hashes into the fraction of the hash space, so
+// that either all or none of the messages with the same key are chosen, across consumers and restarts.
+func KeyHash(fraction float64, key KeyFunc) Sampler {
+	return func(msg substrate.Message) bool {
+		if fraction >= 1 {
+			return true
+		}
+		h := fnv.New64a()
+		h.Write([]byte(key(msg)))
+		return float64(mix(h.Sum64())) < fraction*math.MaxUint64
+	}
+}
+
+// AsyncMessageSourceOption is a function which sets an AsyncMessageSource configuration option.
+type AsyncMessageSourceOption func(s *sampleSource)
+
+// WithSkippedCounter enables a counter of messages that aren't part of the sample, labelled with the topic.
+// It panics in case it can't register the metric.
+func WithSkippedCounter(counterOpts prometheus.CounterOpts, topic string) AsyncMessageSourceOption {
+	return func(s *sampleSource) {
+		s.filterOpts = append(s.filterOpts, filter.WithDropCounter(counterOpts, topic))
+	}
+}
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that only delivers the messages chosen
+// by the sampler. Other messages are acknowledged automatically, in order with the acknowledgements of delivered
+// messages.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, sampler Sampler, opts ...AsyncMessageSourceOption) substrate.AsyncMessageSource {
+	s := &sampleSource{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return filter.NewAsyncMessageSource(source, filter.Predicate(sampler), s.filterOpts...)
+}
+
+type sampleSource struct {
+	filterOpts []filter.AsyncMessageSourceOption
+}
+
+// mix is the finaliser of MurmurHash3, which spreads the FNV hashes of similar keys over the whole hash space.
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
@@ -0,0 +1,96 @@
+package sample_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+	"github.com/uw-labs/substrate-tools/sample"
+)
+
+func messages(count int) []substrate.Message {
+	msgs := make([]substrate.Message, count)
+	for i := range msgs {
+		msgs[i] = message.FromString(fmt.Sprintf("message-%d", i))
+	}
+	return msgs
+}
+
+func TestSampleSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	counterOpts := prometheus.CounterOpts{
+		Name: "sample_test_skipped_total",
+		Help: "sample_test_skipped_total",
+	}
+	backend := mock.NewSource(messages(7)...)
+	source := sample.NewAsyncMessageSource(backend, sample.EveryNth(3), sample.WithSkippedCounter(counterOpts, "topic"))
+
+	counter := prometheus.NewCounterVec(counterOpts, []string{"topic"})
+	if are, ok := prometheus.Register(counter).(prometheus.AlreadyRegisteredError); ok {
+		counter = are.ExistingCollector.(*prometheus.CounterVec)
+	}
+	before := testutil.ToFloat64(counter.WithLabelValues("topic"))
+
+	msgs, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, msgs, acks)
+	}()
+
+	var consumed []string
+	for i := 0; i < 3; i++ {
+		msg := <-msgs
+		consumed = append(consumed, string(msg.Data()))
+		acks <- msg
+	}
+	require.Equal(t, []string{"message-0", "message-3", "message-6"}, consumed)
+
+	// The mock source terminates with an error if acknowledgements are out of order.
+	select {
+	case <-backend.AllAcked():
+	case err := <-errs:
+		require.FailNow(t, "consuming failed", "%v", err)
+	}
+	cancel()
+	require.NoError(t, <-errs)
+	require.Equal(t, before+4, testutil.ToFloat64(counter.WithLabelValues("topic")))
+}
+
+func TestSamplers(t *testing.T) {
+	msgs := messages(1000)
+	count := func(sampler sample.Sampler) int {
+		chosen := 0
+		for _, msg := range msgs {
+			if sampler(msg) {
+				chosen++
+			}
+		}
+		return chosen
+	}
+
+	require.InDelta(t, 100, count(sample.Random(0.1)), 50)
+	require.Equal(t, 1000, count(sample.EveryNth(0)))
+
+	key := func(msg substrate.Message) string {
+		return string(msg.Data())
+	}
+	require.InDelta(t, 250, count(sample.KeyHash(0.25, key)), 60)
+	require.Equal(t, 1000, count(sample.KeyHash(1, key)))
+	require.Zero(t, count(sample.KeyHash(0, key)))
+
+	// messages with the same key are chosen consistently
+	sampler := sample.KeyHash(0.5, key)
+	for _, msg := range msgs[:10] {
+		require.Equal(t, sampler(msg), sampler(message.FromString(string(msg.Data()))))
+	}
+}
@@ -0,0 +1,223 @@
+// Package scaling observes the load of consumers, such as the number of messages awaiting acknowledgement, the time
+// taken to acknowledge them and the lag of the backend, and recommends the number of consumer replicas. The
+// recommendation is served over HTTP in a format compatible with the external scalers of KEDA, so that Kubernetes
+// can scale consumers on substrate level signals.
+package scaling
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMetricName = "substrate_consumer_utilization"
+
+	// latencyWeight is the weight of the latest acknowledgement in the moving average of the ack latency.
+	latencyWeight = 0.2
+)
+
+// Signals are the load signals observed by an Observer.
+type Signals struct {
+	// QueueDepth is the number of messages delivered to the application that weren't acknowledged yet.
+	QueueDepth int `json:"queueDepth"`
+	// AckLatency is the exponentially weighted moving average of the time taken to acknowledge messages.
+	AckLatency time.Duration `json:"ackLatency"`
+	// Lag is the sum of the latest lag of each partition, for messages implementing instrumented.Offsetter.
+	Lag int64 `json:"lag"`
+}
+
+// Recommendation is the scaling recommendation derived from the signals. Its JSON encoding carries the fields of
+// the responses of KEDA external scalers: isActive, metricName, metricValue and targetSize.
+type Recommendation struct {
+	// Active reports whether there is any work, i.e. whether consumers shouldn't be scaled to zero.
+	Active bool `json:"isActive"`
+	// MetricName is the name of the utilization metric.
+	MetricName string `json:"metricName"`
+	// MetricValue is the utilization in percent, the highest ratio of a signal to its target.
+	MetricValue int64 `json:"metricValue"`
+	// TargetSize is the target of the utilization, always 100.
+	TargetSize int64 `json:"targetSize"`
+	// Replicas is the recommended number of replicas, if the current number of replicas was provided.
+	Replicas int `json:"replicas,omitempty"`
+	// Signals are the signals the recommendation is derived from.
+	Signals Signals `json:"signals"`
+}
+
+// Option is a function which sets an Observer configuration option.
+type Option func(o *Observer)
+
+// WithTargetQueueDepth sets the number of messages awaiting acknowledgement a replica is expected to handle.
+func WithTargetQueueDepth(depth int) Option {
+	return func(o *Observer) {
+		o.targetQueueDepth = depth
+	}
+}
+
+// WithTargetAckLatency sets the ack latency above which more replicas are recommended.
+func WithTargetAckLatency(latency time.Duration) Option {
+	return func(o *Observer) {
+		o.targetAckLatency = latency
+	}
+}
+
+// WithTargetLag sets the lag a replica is expected to handle.
+func WithTargetLag(lag int64) Option {
+	return func(o *Observer) {
+		o.targetLag = lag
+	}
+}
+
+// WithReplicaBounds sets the minimum and maximum number of recommended replicas. A minimum of 0 allows scaling to
+// zero when there is no work. The defaults are 0 and no maximum.
+func WithReplicaBounds(min, max int) Option {
+	return func(o *Observer) {
+		o.minReplicas, o.maxReplicas = min, max
+	}
+}
+
+// WithMetricName sets the name of the utilization metric. The default is "substrate_consumer_utilization".
+func WithMetricName(name string) Option {
+	return func(o *Observer) {
+		o.metricName = name
+	}
+}
+
+// Observer collects the signals of the sources wrapped by NewAsyncMessageSource and recommends the number of
+// replicas from their ratio to the targets. Signals without a target don't affect the recommendation. It is an
+// http.Handler serving the recommendation, and is safe for concurrent use.
+type Observer struct {
+	targetQueueDepth int
+	targetAckLatency time.Duration
+	targetLag        int64
+	minReplicas      int
+	maxReplicas      int
+	metricName       string
+
+	mutex      sync.Mutex
+	queueDepth int
+	ackLatency time.Duration
+	lags       map[string]int64
+}
+
+// NewObserver returns an observer without any signals.
+func NewObserver(opts ...Option) *Observer {
+	o := &Observer{
+		metricName: defaultMetricName,
+		lags:       make(map[string]int64),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Signals returns the current signals.
+func (o *Observer) Signals() Signals {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	signals := Signals{QueueDepth: o.queueDepth, AckLatency: o.ackLatency}
+	for _, lag := range o.lags {
+		signals.Lag += lag
+	}
+	return signals
+}
+
+// Recommend returns the recommendation for the current number of replicas, which is scaled by the utilization,
+// as KEDA does with metrics of type Value: consumers at twice their targets are recommended twice as many
+// replicas. The recommended number of replicas is only set if the current number is positive.
+func (o *Observer) Recommend(current int) Recommendation {
+	signals := o.Signals()
+	utilization := math.Max(
+		ratio(float64(signals.QueueDepth), float64(o.targetQueueDepth)),
+		math.Max(
+			ratio(float64(signals.AckLatency), float64(o.targetAckLatency)),
+			ratio(float64(signals.Lag), float64(o.targetLag)),
+		),
+	)
+
+	r := Recommendation{
+		Active:      signals.QueueDepth > 0 || signals.Lag > 0,
+		MetricName:  o.metricName,
+		MetricValue: int64(math.Ceil(utilization * 100)),
+		TargetSize:  100,
+		Signals:     signals,
+	}
+	if current > 0 {
+		r.Replicas = o.bound(int(math.Ceil(float64(current)*utilization)), r.Active)
+	}
+	return r
+}
+
+func ratio(value, target float64) float64 {
+	if target <= 0 {
+		return 0
+	}
+	return value / target
+}
+
+// bound returns the number of replicas within the bounds, keeping at least one replica while there is work.
+func (o *Observer) bound(replicas int, active bool) int {
+	if active && replicas < 1 {
+		replicas = 1
+	}
+	if replicas < o.minReplicas {
+		replicas = o.minReplicas
+	}
+	if o.maxReplicas > 0 && replicas > o.maxReplicas {
+		replicas = o.maxReplicas
+	}
+	return replicas
+}
+
+// ServeHTTP serves the recommendation as JSON, for the current number of replicas given by the replicas query
+// parameter, if any. It can be polled by the metrics API scaler of KEDA, using metricValue as the value location
+// and the Value metric type, so that the number of replicas is scaled by the utilization.
+func (o *Observer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var current int
+	if value := r.URL.Query().Get("replicas"); value != "" {
+		var err error
+		if current, err = strconv.Atoi(value); err != nil || current < 0 {
+			http.Error(w, "invalid replicas: "+value, http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(o.Recommend(current))
+}
+
+// delivered records a message being delivered to the application.
+func (o *Observer) delivered(partition string, lag int64, hasLag bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.queueDepth++
+	if hasLag {
+		o.lags[partition] = lag
+	}
+}
+
+// acked records a message being acknowledged after the latency.
+func (o *Observer) acked(latency time.Duration) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.queueDepth--
+	if o.ackLatency == 0 {
+		o.ackLatency = latency
+		return
+	}
+	o.ackLatency += time.Duration(latencyWeight * float64(latency-o.ackLatency))
+}
+
+// discarded records messages that won't be acknowledged, as the source consuming them stopped.
+func (o *Observer) discarded(n int) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.queueDepth -= n
+}
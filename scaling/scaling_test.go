@@ -0,0 +1,97 @@
+package scaling_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/conformance"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+	"github.com/uw-labs/substrate-tools/scaling"
+)
+
+type offsetMessage struct {
+	substrate.Message
+	partition     string
+	offset, water int64
+}
+
+func (m offsetMessage) Partition() string    { return m.partition }
+func (m offsetMessage) Offset() int64        { return m.offset }
+func (m offsetMessage) HighWaterMark() int64 { return m.water }
+
+func TestObserver(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	observer := scaling.NewObserver(
+		scaling.WithTargetQueueDepth(1),
+		scaling.WithTargetLag(10),
+		scaling.WithReplicaBounds(1, 5),
+	)
+	backend := mock.NewSource(
+		offsetMessage{Message: message.FromString("1"), partition: "0", offset: 10, water: 31},
+		offsetMessage{Message: message.FromString("2"), partition: "1", offset: 5, water: 6},
+	)
+	source := scaling.NewAsyncMessageSource(backend, observer)
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	first, second := <-messages, <-messages
+	require.Equal(t, scaling.Signals{QueueDepth: 2, Lag: 20}, observer.Signals())
+
+	// The queue depth is at twice its target, the lag too.
+	recommendation := observer.Recommend(2)
+	require.True(t, recommendation.Active)
+	require.Equal(t, int64(200), recommendation.MetricValue)
+	require.Equal(t, 4, recommendation.Replicas)
+	// The recommendation is bounded.
+	require.Equal(t, 5, observer.Recommend(3).Replicas)
+
+	acks <- first
+	acks <- second
+	<-backend.AllAcked()
+	cancel()
+	require.NoError(t, <-errs)
+
+	signals := observer.Signals()
+	require.Zero(t, signals.QueueDepth)
+	require.Positive(t, signals.AckLatency)
+}
+
+func TestObserver_ServeHTTP(t *testing.T) {
+	observer := scaling.NewObserver(scaling.WithTargetQueueDepth(10), scaling.WithMetricName("orders"))
+
+	rec := httptest.NewRecorder()
+	observer.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/scaling?replicas=3", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var recommendation scaling.Recommendation
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &recommendation))
+	// Without any work, the minimum number of replicas is recommended.
+	require.Equal(t, scaling.Recommendation{
+		MetricName: "orders",
+		TargetSize: 100,
+	}, recommendation)
+
+	rec = httptest.NewRecorder()
+	observer.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/scaling?replicas=many", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestConformance(t *testing.T) {
+	conformance.TestAsyncSource(t, func(_ *testing.T, backend substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		return scaling.NewAsyncMessageSource(backend, scaling.NewObserver())
+	})
+}
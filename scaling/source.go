@@ -0,0 +1,110 @@
+package scaling
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/sync/rungroup"
+
+	"github.com/uw-labs/substrate-tools/instrumented"
+)
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that reports the messages it delivers
+// and their acknowledgements to the observer. The lag is computed for messages implementing
+// instrumented.Offsetter, so the source should wrap the backend directly, like the instrumented source.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, observer *Observer) substrate.AsyncMessageSource {
+	return &scalingSource{
+		source:   source,
+		observer: observer,
+	}
+}
+
+type scalingSource struct {
+	source   substrate.AsyncMessageSource
+	observer *Observer
+}
+
+func (s *scalingSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "scaling"})
+	rg, ctx := rungroup.New(ctx)
+
+	consumed := make(chan substrate.Message, cap(messages))
+	toAck := make(chan substrate.Message, cap(acks))
+	// delivery times of the messages awaiting acknowledgement, which are acknowledged in order
+	var (
+		mutex   sync.Mutex
+		pending []time.Time
+	)
+
+	rg.Go(func() error {
+		return s.source.ConsumeMessages(ctx, consumed, toAck)
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-consumed:
+				mutex.Lock()
+				pending = append(pending, time.Now())
+				mutex.Unlock()
+				s.observer.delivered(messageLag(msg))
+				emit.Publish(msg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case messages <- msg:
+				}
+			}
+		}
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				emit.Ack(ack)
+				mutex.Lock()
+				if len(pending) > 0 {
+					s.observer.acked(time.Since(pending[0]))
+					pending = pending[1:]
+				}
+				mutex.Unlock()
+				select {
+				case <-ctx.Done():
+					return nil
+				case toAck <- ack:
+				}
+			}
+		}
+	})
+
+	err := rg.Wait()
+	// messages that weren't acknowledged won't be, they are redelivered to the next consumer
+	s.observer.discarded(len(pending))
+	return err
+}
+
+func messageLag(msg substrate.Message) (string, int64, bool) {
+	oMsg, ok := msg.(instrumented.Offsetter)
+	if !ok {
+		return "", 0, false
+	}
+	lag := oMsg.HighWaterMark() - oMsg.Offset() - 1
+	if lag < 0 {
+		lag = 0
+	}
+	return oMsg.Partition(), lag, true
+}
+
+func (s *scalingSource) Close() error {
+	return s.source.Close()
+}
+
+func (s *scalingSource) Status() (*substrate.Status, error) {
+	return s.source.Status()
+}
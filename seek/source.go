@@ -0,0 +1,139 @@
+// Package seek provides a message source wrapper that starts consumption from an approximate point in time, for
+// backends without native support for seeking by timestamp, by fast-forwarding through older messages.
+package seek
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/checkpoint"
+	"github.com/uw-labs/substrate-tools/filter"
+	"github.com/uw-labs/substrate-tools/ttl"
+)
+
+const defaultProgressInterval = time.Second * 5
+
+// Progress describes how far the source fast-forwarded.
+type Progress struct {
+	// Partition is the partition of the last consumed message, or empty if messages aren't positioned.
+	Partition string
+	// Skipped is the number of messages skipped so far, in all the partitions.
+	Skipped int
+	// Timestamp is the timestamp of the last consumed message.
+	Timestamp time.Time
+	// Reached reports whether the last consumed message is the first one of its partition at or after the
+	// start time, from which messages are delivered.
+	Reached bool
+}
+
+// ProgressFunc is called with the progress of the fast-forward.
+type ProgressFunc func(p Progress)
+
+// AsyncMessageSourceOption is a function which sets an AsyncMessageSource configuration option.
+type AsyncMessageSourceOption func(s *seekSource)
+
+// WithTimestampFunc sets the function returning the time at which a message was produced. The timestamps of
+// enveloped messages are used by default.
+func WithTimestampFunc(f ttl.TimestampFunc) AsyncMessageSourceOption {
+	return func(s *seekSource) {
+		s.timestamp = f
+	}
+}
+
+// WithProgress sets a function called with the progress every interval while skipping messages, and whenever a
+// partition reaches the start time. It is called synchronously, so it must not block.
+func WithProgress(f ProgressFunc, interval time.Duration) AsyncMessageSourceOption {
+	return func(s *seekSource) {
+		s.progress = f
+		s.interval = interval
+	}
+}
+
+// WithSkippedCounter enables a counter of skipped messages, labelled with the topic.
+// It panics in case it can't register the metric.
+func WithSkippedCounter(counterOpts prometheus.CounterOpts, topic string) AsyncMessageSourceOption {
+	return func(s *seekSource) {
+		s.filterOpts = append(s.filterOpts, filter.WithDropCounter(counterOpts, topic))
+	}
+}
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that skips the messages produced before
+// the start time, until the first message produced at or after it, from which all the messages are delivered. Skipped
+// messages are acknowledged automatically, in order with the acknowledgements of delivered messages. Partitions of
+// messages implementing checkpoint.Positioned are fast-forwarded independently. Messages with an unknown timestamp
+// are always delivered. The underlying source should start consuming from a position before the start time, e.g. the
+// start of the topic. Wrapping a checkpoint source makes the progress of the fast-forward survive restarts, as the
+// skipped messages are acknowledged through it.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, start time.Time, opts ...AsyncMessageSourceOption) substrate.AsyncMessageSource {
+	s := &seekSource{
+		start:     start,
+		timestamp: ttl.EnvelopeTimestamp,
+		interval:  defaultProgressInterval,
+		reached:   make(map[string]bool),
+		now:       time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return filter.NewAsyncMessageSource(source, s.deliver, s.filterOpts...)
+}
+
+type seekSource struct {
+	start      time.Time
+	timestamp  ttl.TimestampFunc
+	progress   ProgressFunc
+	interval   time.Duration
+	filterOpts []filter.AsyncMessageSourceOption
+	now        func() time.Time
+
+	// the filter only calls the predicate from one goroutine, but it is called again for every consumption
+	mutex    sync.Mutex
+	reached  map[string]bool
+	skipped  int
+	reported time.Time
+}
+
+// deliver reports whether the message is delivered, i.e. whether its partition reached the start time.
+func (s *seekSource) deliver(msg substrate.Message) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var partition string
+	if pMsg, ok := msg.(checkpoint.Positioned); ok {
+		partition = pMsg.Partition()
+	}
+	if s.reached[partition] {
+		return true
+	}
+	timestamp, ok := s.timestamp(msg)
+	if !ok {
+		return true
+	}
+
+	p := Progress{Partition: partition, Timestamp: timestamp}
+	if !timestamp.Before(s.start) {
+		s.reached[partition] = true
+		p.Skipped, p.Reached = s.skipped, true
+		s.report(p, true)
+		return true
+	}
+	s.skipped++
+	p.Skipped = s.skipped
+	s.report(p, false)
+	return false
+}
+
+func (s *seekSource) report(p Progress, force bool) {
+	if s.progress == nil {
+		return
+	}
+	now := s.now()
+	if !force && now.Sub(s.reported) < s.interval {
+		return
+	}
+	s.reported = now
+	s.progress(p)
+}
@@ -0,0 +1,110 @@
+package seek_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/conformance"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+	"github.com/uw-labs/substrate-tools/seek"
+)
+
+var start = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// positioned is a message of a partition produced the given number of minutes after the start time.
+type positioned struct {
+	substrate.Message
+	partition string
+	minutes   int
+}
+
+func (msg positioned) Partition() string { return msg.partition }
+func (msg positioned) Offset() int64     { return 0 }
+
+func at(payload, partition string, minutes int) substrate.Message {
+	return positioned{Message: message.FromString(payload), partition: partition, minutes: minutes}
+}
+
+func timestamp(msg substrate.Message) (time.Time, bool) {
+	pMsg, ok := msg.(positioned)
+	if !ok {
+		return time.Time{}, false
+	}
+	return start.Add(time.Minute * time.Duration(pMsg.minutes)), true
+}
+
+func consume(ctx context.Context, t *testing.T, source substrate.AsyncMessageSource, count int) (<-chan error, []string) {
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	var consumed []string
+	for i := 0; i < count; i++ {
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "failed to consume all messages")
+		case msg := <-messages:
+			consumed = append(consumed, string(msg.Data()))
+			acks <- msg
+		}
+	}
+	return errs, consumed
+}
+
+func TestSeekSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	var (
+		mutex    sync.Mutex
+		progress []seek.Progress
+	)
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			at("a-old", "a", -10),
+			at("b-old", "b", -5),
+			at("a-new", "a", 0),
+			message.FromString("unknown"),
+			at("a-late", "a", -1), // the partition already reached the start time
+			at("b-older", "b", -20),
+			at("b-new", "b", 1),
+		},
+	}
+	source := seek.NewAsyncMessageSource(mockSource, start,
+		seek.WithTimestampFunc(timestamp),
+		seek.WithProgress(func(p seek.Progress) {
+			mutex.Lock()
+			progress = append(progress, p)
+			mutex.Unlock()
+		}, time.Hour),
+	)
+
+	errs, consumed := consume(ctx, t, source, 4)
+	require.Equal(t, []string{"a-new", "unknown", "a-late", "b-new"}, consumed)
+
+	// The mock source terminates with an error if acknowledgements are out of order.
+	require.NoError(t, source.Close())
+	require.NoError(t, <-errs)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	require.Equal(t, []seek.Progress{
+		{Partition: "a", Skipped: 1, Timestamp: start.Add(-time.Minute * 10)},
+		{Partition: "a", Skipped: 2, Timestamp: start, Reached: true},
+		{Partition: "b", Skipped: 3, Timestamp: start.Add(time.Minute), Reached: true},
+	}, progress)
+}
+
+func TestSeekSource_Conformance(t *testing.T) {
+	conformance.TestAsyncSource(t, func(_ *testing.T, backend substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		return seek.NewAsyncMessageSource(backend, start)
+	})
+}
@@ -0,0 +1,226 @@
+// Package shutdown provides a coordinator that gracefully shuts down services built from substrate sources
+// and sinks, making sure that messages in flight are acknowledged before anything is closed.
+package shutdown
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"github.com/uw-labs/substrate"
+)
+
+var (
+	// ErrShutdownTimeout is returned when the graceful shutdown didn't finish within the configured timeout.
+	ErrShutdownTimeout = errors.New("graceful shutdown timed out")
+	// ErrAlreadyStarted is returned by Run when the coordinator was already run.
+	ErrAlreadyStarted = errors.New("coordinator already started")
+)
+
+// Option is a function which sets a coordinator configuration option.
+type Option func(c *Coordinator)
+
+// WithTimeout sets for how long the coordinator waits for messages in flight to be acknowledged, before
+// forcefully stopping everything. It defaults to 30 seconds.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Coordinator) {
+		c.timeout = timeout
+	}
+}
+
+// WithSignals sets the signals that start the shutdown. It defaults to os.Interrupt and syscall.SIGTERM,
+// providing no signals disables listening for signals.
+func WithSignals(signals ...os.Signal) Option {
+	return func(c *Coordinator) {
+		c.signals = signals
+	}
+}
+
+// Coordinator runs the goroutines of a service and shuts it down gracefully. Once the shutdown starts, the
+// sources wrapped by the coordinator stop delivering new messages and return as soon as all already delivered
+// messages are acknowledged. Once all sources have returned, the wrapped sinks return as soon as all messages
+// published to them are acknowledged. Finally, once all goroutines have returned, the sources, the sinks and
+// any other registered closers are closed, in that order.
+type Coordinator struct {
+	timeout time.Duration
+	signals []os.Signal
+
+	goroutines []func(ctx context.Context) error
+	sources    []io.Closer
+	sinks      []io.Closer
+	others     []io.Closer
+
+	draining      chan struct{}
+	flushing      chan struct{}
+	startFlushing sync.Once
+
+	mutex         sync.Mutex
+	started       bool
+	shuttingDown  bool
+	activeSources int
+}
+
+// New returns a new coordinator.
+func New(opts ...Option) *Coordinator {
+	c := &Coordinator{
+		timeout:  time.Second * 30,
+		signals:  []os.Signal{os.Interrupt, syscall.SIGTERM},
+		draining: make(chan struct{}),
+		flushing: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Go registers a goroutine to be started by Run. The goroutine should consume messages from wrapped sources and
+// return once they do. The provided context is only cancelled if the graceful shutdown times out. It must be
+// called before Run.
+func (c *Coordinator) Go(fn func(ctx context.Context) error) {
+	c.goroutines = append(c.goroutines, fn)
+}
+
+// AddCloser registers a closer that is closed after all sources and sinks were closed. Closers are closed in
+// the order in which they were registered. It must be called before Run.
+func (c *Coordinator) AddCloser(closer io.Closer) {
+	c.others = append(c.others, closer)
+}
+
+// Source wraps the source, so that it stops delivering messages when the shutdown starts and returns once all
+// delivered messages were acknowledged. The source is closed by the coordinator. It must be called before Run.
+func (c *Coordinator) Source(source substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+	c.sources = append(c.sources, source)
+	return &coordinatedSource{impl: source, coordinator: c}
+}
+
+// Sink wraps the sink, so that it returns once all sources have returned and all messages published to it were
+// acknowledged. The sink is closed by the coordinator, after all sources. It must be called before Run.
+func (c *Coordinator) Sink(sink substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+	c.sinks = append(c.sinks, sink)
+	return &coordinatedSink{impl: sink, coordinator: c}
+}
+
+// Run starts all registered goroutines and waits for the provided context to be cancelled, for one of the
+// configured signals or for any of the goroutines to return. It then shuts everything down gracefully and
+// returns all errors returned by the goroutines or encountered when closing. A coordinator can only be run once,
+// further calls return ErrAlreadyStarted.
+func (c *Coordinator) Run(ctx context.Context) error {
+	if !c.start() {
+		return ErrAlreadyStarted
+	}
+
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+
+	var (
+		mutex  sync.Mutex
+		result *multierror.Error
+		wg     sync.WaitGroup
+	)
+	returned := make(chan struct{}, len(c.goroutines))
+	for _, fn := range c.goroutines {
+		wg.Add(1)
+		go func(fn func(ctx context.Context) error) {
+			defer wg.Done()
+			if err := fn(runCtx); err != nil {
+				mutex.Lock()
+				result = multierror.Append(result, err)
+				mutex.Unlock()
+			}
+			returned <- struct{}{}
+		}(fn)
+	}
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	if len(c.signals) > 0 {
+		signal.Notify(signals, c.signals...)
+		defer signal.Stop(signals)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-signals:
+	case <-returned:
+	case <-finished:
+	}
+	c.shutdown()
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	select {
+	case <-finished:
+	case <-timer.C:
+		result = multierror.Append(result, ErrShutdownTimeout)
+		cancel()
+		<-finished
+	}
+
+	for _, closers := range [][]io.Closer{c.sources, c.sinks, c.others} {
+		for _, closer := range closers {
+			if err := closer.Close(); err != nil {
+				result = multierror.Append(result, err)
+			}
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+// start records that the coordinator was started, it reports false if it already was.
+func (c *Coordinator) start() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.started {
+		return false
+	}
+	c.started = true
+	return true
+}
+
+// shutdown starts the graceful shutdown.
+func (c *Coordinator) shutdown() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.shuttingDown = true
+	close(c.draining)
+	if c.activeSources == 0 {
+		c.startFlushing.Do(func() { close(c.flushing) })
+	}
+}
+
+// sourceStarted records that a source started consuming, it reports false if the shutdown already started.
+func (c *Coordinator) sourceStarted() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.shuttingDown {
+		return false
+	}
+	c.activeSources++
+	return true
+}
+
+// sourceStopped records that a source stopped consuming, starting to flush sinks if it was the last one
+// during the shutdown.
+func (c *Coordinator) sourceStopped() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.activeSources--
+	if c.shuttingDown && c.activeSources == 0 {
+		c.startFlushing.Do(func() { close(c.flushing) })
+	}
+}
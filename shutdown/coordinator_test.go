@@ -0,0 +1,194 @@
+package shutdown_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/bridge"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+	"github.com/uw-labs/substrate-tools/shutdown"
+)
+
+type closeRecorder struct {
+	mutex  sync.Mutex
+	closed []string
+}
+
+func (r *closeRecorder) closer(name string) closerFunc {
+	return func() error {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.closed = append(r.closed, name)
+		return nil
+	}
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+type recordingSource struct {
+	substrate.AsyncMessageSource
+	close closerFunc
+}
+
+func (s recordingSource) Close() error {
+	return s.close()
+}
+
+type recordingSink struct {
+	substrate.AsyncMessageSink
+	close closerFunc
+}
+
+func (s recordingSink) Close() error {
+	return s.close()
+}
+
+func TestCoordinator_DrainsSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			message.FromString("1"),
+			message.FromString("2"),
+			message.FromString("3"),
+		},
+	}
+	c := shutdown.New(shutdown.WithSignals())
+	source := c.Source(mockSource)
+
+	runCtx, stop := context.WithCancel(ctx)
+	c.Go(func(ctx context.Context) error {
+		messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+		errs := make(chan error, 1)
+		go func() {
+			errs <- source.ConsumeMessages(ctx, messages, acks)
+		}()
+
+		msg := <-messages
+		stop()
+		// Give the coordinator time to start the shutdown.
+		time.Sleep(time.Millisecond * 20)
+		select {
+		case <-messages:
+			return errors.New("message delivered after the shutdown started")
+		case err := <-errs:
+			return errors.Wrap(err, "source returned before the message was acknowledged")
+		case <-time.After(time.Millisecond * 50):
+		}
+
+		acks <- msg
+		return <-errs
+	})
+
+	require.NoError(t, c.Run(runCtx))
+	require.True(t, mockSource.WasClosed())
+}
+
+func TestCoordinator_Pipeline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	acks, messages := make(chan substrate.Message, 10), make(chan substrate.Message, 10)
+	go broker.NewAsyncMessageSink("in").PublishMessages(ctx, acks, messages)
+	for i := 0; i < 10; i++ {
+		messages <- message.FromString("message")
+		<-acks
+	}
+
+	c := shutdown.New(shutdown.WithSignals())
+	source := c.Source(broker.NewAsyncMessageSource("in", "group"))
+	sink := c.Sink(broker.NewAsyncMessageSink("out"))
+	c.Go(func(ctx context.Context) error {
+		return bridge.Run(ctx, source, sink, bridge.Options{MaxInFlight: 5})
+	})
+
+	runCtx, stop := context.WithCancel(ctx)
+	go func() {
+		require.Eventually(t, func() bool {
+			return len(broker.Messages("out")) > 0
+		}, time.Second*5, time.Millisecond)
+		stop()
+	}()
+	require.NoError(t, c.Run(runCtx))
+
+	// Everything that was published was acknowledged, so consuming again only returns the remaining messages.
+	remaining := 10 - len(broker.Messages("out"))
+	if remaining == 0 {
+		return
+	}
+	consumeCtx, consumeCancel := context.WithTimeout(ctx, time.Millisecond*100)
+	defer consumeCancel()
+	consumed := make(chan substrate.Message, 10)
+	_ = broker.NewAsyncMessageSource("in", "group").ConsumeMessages(consumeCtx, consumed, make(chan substrate.Message))
+	require.Len(t, consumed, remaining)
+}
+
+func TestCoordinator_Timeout(t *testing.T) {
+	c := shutdown.New(shutdown.WithSignals(), shutdown.WithTimeout(time.Millisecond*50))
+	source := c.Source(&mock.AsyncMessageSource{
+		Messages: []substrate.Message{message.FromString("1")},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.Go(func(ctx context.Context) error {
+		messages := make(chan substrate.Message)
+		errs := make(chan error, 1)
+		go func() {
+			errs <- source.ConsumeMessages(ctx, messages, make(chan substrate.Message))
+		}()
+		<-messages
+		cancel()
+		// The message is never acknowledged.
+		return <-errs
+	})
+
+	err := c.Run(ctx)
+	require.Error(t, err)
+	require.Equal(t, []error{shutdown.ErrShutdownTimeout}, err.(*multierror.Error).Errors)
+}
+
+func TestCoordinator_CloseOrder(t *testing.T) {
+	recorder := &closeRecorder{}
+	c := shutdown.New(shutdown.WithSignals())
+	c.AddCloser(recorder.closer("closer"))
+	c.Sink(recordingSink{close: recorder.closer("sink")})
+	c.Source(recordingSource{close: recorder.closer("source 1")})
+	c.Source(recordingSource{close: recorder.closer("source 2")})
+
+	workerErr := errors.New("worker error")
+	c.Go(func(ctx context.Context) error {
+		return workerErr
+	})
+
+	err := c.Run(context.Background())
+	require.Error(t, err)
+	require.Equal(t, []error{workerErr}, err.(*multierror.Error).Errors)
+	require.Equal(t, []string{"source 1", "source 2", "sink", "closer"}, recorder.closed)
+}
+
+func TestCoordinator_RunTwice(t *testing.T) {
+	recorder := &closeRecorder{}
+	c := shutdown.New(shutdown.WithSignals())
+	c.AddCloser(recorder.closer("closer"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, c.Run(ctx))
+	require.Equal(t, shutdown.ErrAlreadyStarted, c.Run(ctx))
+	require.Equal(t, []string{"closer"}, recorder.closed)
+}
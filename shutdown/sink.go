@@ -0,0 +1,87 @@
+package shutdown
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// coordinatedSink implements substrate.AsyncMessageSink that stops once the coordinator's sources have stopped.
+type coordinatedSink struct {
+	impl        substrate.AsyncMessageSink
+	coordinator *Coordinator
+}
+
+// PublishMessages publishes messages to the underlying sink until the context is cancelled, or until all
+// published messages were acknowledged once all sources of the coordinator have stopped during the shutdown.
+func (s *coordinatedSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "shutdown"})
+	rg, ctx := rungroup.New(ctx)
+
+	toPublish := make(chan substrate.Message, cap(messages))
+	published := make(chan substrate.Message, cap(acks))
+	flushing := s.coordinator.flushing
+
+	// inFlight is the number of messages published, but not acknowledged yet. It is checked the same way
+	// as the number of messages in flight of a coordinated source.
+	var inFlight int64
+
+	rg.Go(func() error {
+		return s.impl.PublishMessages(ctx, published, toPublish)
+	})
+	rg.Go(func() error {
+		flushing := flushing
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-flushing:
+				if atomic.LoadInt64(&inFlight) == 0 {
+					return nil
+				}
+				flushing = nil
+			case msg := <-messages:
+				emit.Publish(msg)
+				atomic.AddInt64(&inFlight, 1)
+				select {
+				case <-ctx.Done():
+					return nil
+				case toPublish <- msg:
+				}
+			}
+		}
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-published:
+				emit.Ack(ack)
+				select {
+				case <-ctx.Done():
+					return nil
+				case acks <- ack:
+				}
+				if atomic.AddInt64(&inFlight, -1) == 0 && isClosed(flushing) {
+					return nil
+				}
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+// Close does nothing, the underlying sink is closed by the coordinator.
+func (s *coordinatedSink) Close() error {
+	return nil
+}
+
+// Status returns the status of the underlying sink.
+func (s *coordinatedSink) Status() (*substrate.Status, error) {
+	return s.impl.Status()
+}
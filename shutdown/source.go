@@ -0,0 +1,109 @@
+package shutdown
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// coordinatedSource implements substrate.AsyncMessageSource that stops when the coordinator shuts down.
+type coordinatedSource struct {
+	impl        substrate.AsyncMessageSource
+	coordinator *Coordinator
+}
+
+// ConsumeMessages consumes messages from the underlying source until the context is cancelled, or until all
+// delivered messages were acknowledged once the shutdown started.
+func (s *coordinatedSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "shutdown"})
+	if !s.coordinator.sourceStarted() {
+		return nil
+	}
+	defer s.coordinator.sourceStopped()
+
+	rg, ctx := rungroup.New(ctx)
+
+	consumed := make(chan substrate.Message, cap(messages))
+	toAck := make(chan substrate.Message, cap(acks))
+	draining := s.coordinator.draining
+
+	// inFlight is the number of messages delivered, but not acknowledged yet. The goroutine forwarding
+	// messages checks it after observing the shutdown, while the one forwarding acknowledgements checks
+	// for the shutdown after updating it, so that one of them always notices that the source is drained.
+	var inFlight int64
+
+	rg.Go(func() error {
+		return s.impl.ConsumeMessages(ctx, consumed, toAck)
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-draining:
+				if atomic.LoadInt64(&inFlight) == 0 {
+					return nil
+				}
+				<-ctx.Done()
+				return nil
+			case msg := <-consumed:
+				atomic.AddInt64(&inFlight, 1)
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-draining:
+					// The message is not delivered, so it will be redelivered by the broker.
+					if atomic.AddInt64(&inFlight, -1) == 0 {
+						return nil
+					}
+					<-ctx.Done()
+					return nil
+				case messages <- msg:
+					emit.Publish(msg)
+				}
+			}
+		}
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				emit.Ack(ack)
+				select {
+				case <-ctx.Done():
+					return nil
+				case toAck <- ack:
+				}
+				if atomic.AddInt64(&inFlight, -1) == 0 && isClosed(draining) {
+					return nil
+				}
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+// Close does nothing, the underlying source is closed by the coordinator.
+func (s *coordinatedSource) Close() error {
+	return nil
+}
+
+// Status returns the status of the underlying source.
+func (s *coordinatedSource) Status() (*substrate.Status, error) {
+	return s.impl.Status()
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
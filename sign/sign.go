@@ -0,0 +1,132 @@
+// Package sign provides wrappers that sign the payloads of published messages, using HMAC or Ed25519, and verify
+// the signatures on consumption, so that messages crossing a trust boundary can't be forged or tampered with.
+package sign
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// SignatureHeader is the header carrying the base64 encoded signature of the payload.
+	SignatureHeader = "signature"
+	// KeyIDHeader is the header recording the ID of the key a payload was signed with. The signing sink sets
+	// both headers on every message, so the sink it wraps must support headers, such as the envelope sink of the
+	// message package.
+	KeyIDHeader = "signature-key-id"
+)
+
+var (
+	// ErrUnknownKey is returned when a message was signed with a key that is not known to the verifier.
+	ErrUnknownKey = errors.New("unknown signing key")
+	// ErrInvalidSignature is returned when the signature of a message doesn't match its payload.
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrNotSigned is returned when consuming a message without a signature, unless unsigned messages are allowed.
+	ErrNotSigned = errors.New("message is not signed")
+)
+
+// Signer signs payloads.
+type Signer interface {
+	// Sign returns the ID of the key used to sign the data along with the signature.
+	Sign(data []byte) (keyID string, signature []byte, err error)
+}
+
+// Verifier verifies the signatures of payloads. To rotate keys, a verifier keeps accepting signatures made with
+// older keys, by their ID, until all messages signed with them have been consumed.
+type Verifier interface {
+	// Verify returns an error wrapping ErrUnknownKey if there is no key with the given ID, or ErrInvalidSignature
+	// if the signature doesn't match the data.
+	Verify(keyID string, data, signature []byte) error
+}
+
+// signedData returns the data covered by a signature, which is the payload prefixed by the key ID, so that a
+// signature can't be passed off as made with a different key.
+func signedData(keyID string, data []byte) []byte {
+	signed := make([]byte, 0, len(keyID)+1+len(data))
+	signed = append(signed, keyID...)
+	signed = append(signed, 0)
+	return append(signed, data...)
+}
+
+// HMAC signs and verifies payloads using HMAC-SHA256 with shared secret keys.
+type HMAC struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewHMAC returns an HMAC signer and verifier with a fixed set of keys, which signs messages using the key with the
+// current ID. The other keys are only used to verify messages signed before a rotation.
+func NewHMAC(current string, keys map[string][]byte) (*HMAC, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, errors.Wrapf(ErrUnknownKey, "key %q", current)
+	}
+	return &HMAC{current: current, keys: keys}, nil
+}
+
+// Sign signs the data using the current key.
+func (h *HMAC) Sign(data []byte) (string, []byte, error) {
+	return h.current, h.sum(h.keys[h.current], h.current, data), nil
+}
+
+// Verify verifies the signature of the data using the key with the given ID.
+func (h *HMAC) Verify(keyID string, data, signature []byte) error {
+	key, ok := h.keys[keyID]
+	if !ok {
+		return errors.Wrapf(ErrUnknownKey, "key %q", keyID)
+	}
+	if !hmac.Equal(signature, h.sum(key, keyID, data)) {
+		return errors.Wrapf(ErrInvalidSignature, "key %q", keyID)
+	}
+	return nil
+}
+
+func (h *HMAC) sum(key []byte, keyID string, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(signedData(keyID, data))
+	return mac.Sum(nil)
+}
+
+// NewEd25519Signer returns a Signer signing payloads with the Ed25519 private key, recording the key ID with
+// every signature.
+func NewEd25519Signer(keyID string, key ed25519.PrivateKey) (Signer, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, errors.Errorf("invalid ed25519 private key %q", keyID)
+	}
+	return &ed25519Signer{keyID: keyID, key: key}, nil
+}
+
+type ed25519Signer struct {
+	keyID string
+	key   ed25519.PrivateKey
+}
+
+func (s *ed25519Signer) Sign(data []byte) (string, []byte, error) {
+	return s.keyID, ed25519.Sign(s.key, signedData(s.keyID, data)), nil
+}
+
+// NewEd25519Verifier returns a Verifier checking signatures against the Ed25519 public keys, by key ID. Consumers
+// only need the public keys, so that they can't sign messages themselves.
+func NewEd25519Verifier(keys map[string]ed25519.PublicKey) (Verifier, error) {
+	for id, key := range keys {
+		if len(key) != ed25519.PublicKeySize {
+			return nil, errors.Errorf("invalid ed25519 public key %q", id)
+		}
+	}
+	return ed25519Verifier(keys), nil
+}
+
+type ed25519Verifier map[string]ed25519.PublicKey
+
+func (v ed25519Verifier) Verify(keyID string, data, signature []byte) error {
+	key, ok := v[keyID]
+	if !ok {
+		return errors.Wrapf(ErrUnknownKey, "key %q", keyID)
+	}
+	if !ed25519.Verify(key, signedData(keyID, data), signature) {
+		return errors.Wrapf(ErrInvalidSignature, "key %q", keyID)
+	}
+	return nil
+}
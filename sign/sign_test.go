@@ -0,0 +1,220 @@
+package sign_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/sign"
+)
+
+var (
+	keyV1 = bytes.Repeat([]byte{1}, 32)
+	keyV2 = bytes.Repeat([]byte{2}, 32)
+)
+
+func publish(ctx context.Context, t *testing.T, sink substrate.AsyncMessageSink, msgs ...substrate.Message) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+	for _, msg := range msgs {
+		messages <- msg
+		require.Equal(t, msg, <-acks)
+	}
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestHMAC(t *testing.T) {
+	v1, err := sign.NewHMAC("v1", map[string][]byte{"v1": keyV1})
+	require.NoError(t, err)
+	v2, err := sign.NewHMAC("v2", map[string][]byte{"v1": keyV1, "v2": keyV2})
+	require.NoError(t, err)
+
+	id, signature, err := v1.Sign([]byte("payload"))
+	require.NoError(t, err)
+	require.Equal(t, "v1", id)
+
+	// Signatures made with the previous key are still accepted after a rotation.
+	require.NoError(t, v2.Verify(id, []byte("payload"), signature))
+	require.Equal(t, sign.ErrInvalidSignature, errors.Cause(v2.Verify(id, []byte("tampered"), signature)))
+	require.Equal(t, sign.ErrInvalidSignature, errors.Cause(v2.Verify("v2", []byte("payload"), signature)))
+	require.Equal(t, sign.ErrUnknownKey, errors.Cause(v1.Verify("v2", []byte("payload"), signature)))
+
+	_, err = sign.NewHMAC("v3", map[string][]byte{"v1": keyV1})
+	require.Equal(t, sign.ErrUnknownKey, errors.Cause(err))
+}
+
+func TestEd25519(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	signer, err := sign.NewEd25519Signer("ed1", private)
+	require.NoError(t, err)
+	verifier, err := sign.NewEd25519Verifier(map[string]ed25519.PublicKey{"ed1": public})
+	require.NoError(t, err)
+
+	id, signature, err := signer.Sign([]byte("payload"))
+	require.NoError(t, err)
+	require.Equal(t, "ed1", id)
+	require.NoError(t, verifier.Verify(id, []byte("payload"), signature))
+	require.Equal(t, sign.ErrInvalidSignature, errors.Cause(verifier.Verify(id, []byte("tampered"), signature)))
+	require.Equal(t, sign.ErrUnknownKey, errors.Cause(verifier.Verify("ed2", []byte("payload"), signature)))
+
+	_, err = sign.NewEd25519Verifier(map[string]ed25519.PublicKey{"ed1": public[:8]})
+	require.EqualError(t, err, `invalid ed25519 public key "ed1"`)
+}
+
+func TestSigningWithKeyRotation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+
+	v1, err := sign.NewHMAC("v1", map[string][]byte{"v1": keyV1})
+	require.NoError(t, err)
+	publish(ctx, t, sign.NewAsyncMessageSink(message.NewEnvelopeSink(broker.NewAsyncMessageSink("topic")), v1),
+		message.FromString("first"),
+	)
+	v2, err := sign.NewHMAC("v2", map[string][]byte{"v1": keyV1, "v2": keyV2})
+	require.NoError(t, err)
+	publish(ctx, t, sign.NewAsyncMessageSink(message.NewEnvelopeSink(broker.NewAsyncMessageSink("topic")), v2),
+		message.NewEnvelopedMessage(message.Envelope{
+			ID:      "id-2",
+			Headers: map[string]string{"traceparent": "trace"},
+			Payload: []byte("second"),
+		}),
+	)
+
+	var raw message.Envelope
+	require.NoError(t, json.Unmarshal(broker.Messages("topic")[1], &raw))
+	require.Equal(t, "v2", raw.Headers[sign.KeyIDHeader])
+	require.NotEmpty(t, raw.Headers[sign.SignatureHeader])
+	require.Equal(t, "trace", raw.Headers["traceparent"])
+
+	source := sign.NewAsyncMessageSource(message.NewEnvelopeSource(broker.NewAsyncMessageSource("topic", "group")), v2)
+	consumed, acks := make(chan substrate.Message), make(chan substrate.Message)
+	go source.ConsumeMessages(ctx, consumed, acks)
+
+	first := <-consumed
+	require.Equal(t, "first", string(first.Data()))
+	require.Equal(t, "v1", message.Headers(first)[sign.KeyIDHeader])
+	acks <- first
+	second := <-consumed
+	require.Equal(t, "second", string(second.Data()))
+	acks <- second
+}
+
+// tamper publishes the message to the topic with its payload replaced.
+func tamper(ctx context.Context, t *testing.T, broker *mem.Broker, signer sign.Signer, topic string) {
+	sink := sign.NewAsyncMessageSink(message.NewEnvelopeSink(broker.NewAsyncMessageSink("signed")), signer)
+	publish(ctx, t, sink, message.FromString("payload"))
+
+	var envelope message.Envelope
+	require.NoError(t, json.Unmarshal(broker.Messages("signed")[0], &envelope))
+	envelope.Payload = []byte("forged")
+	publish(ctx, t, message.NewEnvelopeSink(broker.NewAsyncMessageSink(topic)), message.NewEnvelopedMessage(envelope))
+}
+
+func TestVerificationFailures(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	v1, err := sign.NewHMAC("v1", map[string][]byte{"v1": keyV1})
+	require.NoError(t, err)
+	v2, err := sign.NewHMAC("v2", map[string][]byte{"v2": keyV2})
+	require.NoError(t, err)
+
+	broker := mem.NewBroker()
+	tamper(ctx, t, broker, v1, "tampered")
+	publish(ctx, t, message.NewEnvelopeSink(broker.NewAsyncMessageSink("unsigned")), message.FromString("payload"))
+
+	tests := []struct {
+		name     string
+		topic    string
+		verifier sign.Verifier
+		expected error
+	}{
+		{name: "unknown key", topic: "signed", verifier: v2, expected: sign.ErrUnknownKey},
+		{name: "tampered", topic: "tampered", verifier: v1, expected: sign.ErrInvalidSignature},
+		{name: "not signed", topic: "unsigned", verifier: v1, expected: sign.ErrNotSigned},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := sign.NewAsyncMessageSource(message.NewEnvelopeSource(broker.NewAsyncMessageSource(test.topic, "group")), test.verifier)
+			err := source.ConsumeMessages(ctx, make(chan substrate.Message), make(chan substrate.Message))
+			require.Equal(t, test.expected, errors.Cause(err))
+		})
+	}
+}
+
+func TestAllowUnsigned(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	verifier, err := sign.NewHMAC("v1", map[string][]byte{"v1": keyV1})
+	require.NoError(t, err)
+
+	broker := mem.NewBroker()
+	publish(ctx, t, broker.NewAsyncMessageSink("topic"), message.FromString("payload"))
+
+	source := sign.NewAsyncMessageSource(broker.NewAsyncMessageSource("topic", "group"), verifier, sign.WithAllowUnsigned())
+	consumed, acks := make(chan substrate.Message), make(chan substrate.Message)
+	go source.ConsumeMessages(ctx, consumed, acks)
+
+	msg := <-consumed
+	require.Equal(t, "payload", string(msg.Data()))
+	acks <- msg
+}
+
+func TestQuarantineSink(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	signer, err := sign.NewHMAC("v1", map[string][]byte{"v1": keyV1})
+	require.NoError(t, err)
+
+	broker := mem.NewBroker()
+	tamper(ctx, t, broker, signer, "topic")
+	publish(ctx, t, sign.NewAsyncMessageSink(message.NewEnvelopeSink(broker.NewAsyncMessageSink("topic")), signer),
+		message.FromString("genuine"),
+	)
+
+	source := sign.NewAsyncMessageSource(message.NewEnvelopeSource(broker.NewAsyncMessageSource("topic", "group")), signer,
+		sign.WithQuarantineSink(message.NewEnvelopeSink(broker.NewAsyncMessageSink("quarantine"))),
+	)
+	consumed, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, consumed, acks)
+	}()
+
+	msg := <-consumed
+	require.Equal(t, "genuine", string(msg.Data()))
+	acks <- msg
+
+	require.Eventually(t, func() bool {
+		return len(broker.Messages("quarantine")) == 1
+	}, time.Second, time.Millisecond*10)
+	var quarantined message.Envelope
+	require.NoError(t, json.Unmarshal(broker.Messages("quarantine")[0], &quarantined))
+	require.Equal(t, "forged", string(quarantined.Payload))
+	require.Equal(t, "v1", quarantined.Headers[sign.KeyIDHeader])
+
+	cancel()
+	require.NoError(t, <-errs)
+}
@@ -0,0 +1,56 @@
+package sign
+
+import (
+	"context"
+	"encoding/base64"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/internal/transform"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that signs payloads using the signer before
+// publishing them, setting the SignatureHeader and KeyIDHeader headers. Messages are published as
+// message.EnvelopedMessage, so that the envelope sink preserves the metadata of enveloped messages.
+func NewAsyncMessageSink(sink substrate.AsyncMessageSink, signer Signer) substrate.AsyncMessageSink {
+	return &signSink{
+		sink:   sink,
+		signer: signer,
+	}
+}
+
+type signSink struct {
+	sink   substrate.AsyncMessageSink
+	signer Signer
+}
+
+func (s *signSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	return transform.PublishMessages(ctx, "sign", s.sink, acks, messages, func(_ context.Context, msg substrate.Message) (substrate.Message, error) {
+		return s.sign(msg)
+	})
+}
+
+// sign returns the message to publish in place of the provided one.
+func (s *signSink) sign(msg substrate.Message) (substrate.Message, error) {
+	envelope, _ := message.EnvelopeOf(msg)
+	envelope.Headers = message.Headers(msg)
+
+	id, signature, err := s.signer.Sign(envelope.Payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to sign message")
+	}
+	envelope.Headers[SignatureHeader] = base64.StdEncoding.EncodeToString(signature)
+	envelope.Headers[KeyIDHeader] = id
+
+	return message.NewEnvelopedMessage(envelope), nil
+}
+
+func (s *signSink) Close() error {
+	return s.sink.Close()
+}
+
+func (s *signSink) Status() (*substrate.Status, error) {
+	return s.sink.Status()
+}
@@ -0,0 +1,120 @@
+package sign
+
+import (
+	"context"
+	"encoding/base64"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/filter"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// SourceOption is a function which sets a verifying source configuration option.
+type SourceOption func(s *verifyingSource)
+
+// WithAllowUnsigned passes messages without the SignatureHeader header through unchanged, instead of treating
+// them as failing verification with ErrNotSigned. This is useful while migrating a topic to signed payloads.
+func WithAllowUnsigned() SourceOption {
+	return func(s *verifyingSource) {
+		s.allowUnsigned = true
+	}
+}
+
+// WithQuarantineSink sets a sink to which messages failing verification are published, instead of failing
+// consumption. They are acknowledged once they have been published.
+func WithQuarantineSink(sink substrate.AsyncMessageSink) SourceOption {
+	return func(s *verifyingSource) {
+		s.quarantine = sink
+	}
+}
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that verifies the signatures of the
+// messages consumed from the underlying source, using the key with the ID recorded in the KeyIDHeader header, before
+// delivering them unchanged. By default, consumption fails with an error wrapping ErrNotSigned, ErrUnknownKey or
+// ErrInvalidSignature as soon as a message fails verification. When Close is called, both the source and the
+// quarantine sink, if set, are closed.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, verifier Verifier, opts ...SourceOption) substrate.AsyncMessageSource {
+	s := &verifyingSource{
+		source:   source,
+		verifier: verifier,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.quarantine != nil {
+		return filter.NewAsyncMessageSource(source, func(msg substrate.Message) bool {
+			return s.verify(msg) == nil
+		}, filter.WithSideSink(s.quarantine))
+	}
+	return s
+}
+
+// verifyingSource delivers the messages of the underlying source until one fails verification.
+type verifyingSource struct {
+	source        substrate.AsyncMessageSource
+	verifier      Verifier
+	allowUnsigned bool
+	quarantine    substrate.AsyncMessageSink
+}
+
+func (s *verifyingSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "sign"})
+	rg, ctx := rungroup.New(ctx)
+
+	sourceMsgs := make(chan substrate.Message, cap(messages))
+
+	rg.Go(func() error {
+		return s.source.ConsumeMessages(ctx, sourceMsgs, acks)
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-sourceMsgs:
+				if err := s.verify(msg); err != nil {
+					return errors.Wrap(err, "failed to verify message")
+				}
+				emit.Publish(msg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case messages <- msg:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+// verify checks the signature of the message.
+func (s *verifyingSource) verify(msg substrate.Message) error {
+	headers := message.Headers(msg)
+	encoded, ok := headers[SignatureHeader]
+	if !ok {
+		if s.allowUnsigned {
+			return nil
+		}
+		return ErrNotSigned
+	}
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return errors.Wrap(ErrInvalidSignature, "malformed signature")
+	}
+	return s.verifier.Verify(headers[KeyIDHeader], msg.Data(), signature)
+}
+
+// Close closes the underlying source.
+func (s *verifyingSource) Close() error {
+	return s.source.Close()
+}
+
+// Status returns the status of the underlying source.
+func (s *verifyingSource) Status() (*substrate.Status, error) {
+	return s.source.Status()
+}
@@ -0,0 +1,83 @@
+package sizelimit
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/chunker"
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+	"github.com/uw-labs/substrate-tools/internal/transform"
+)
+
+// SinkOption is a function which sets a size limiting sink configuration option.
+type SinkOption func(s *limitSink)
+
+// WithPolicy sets what is done with messages larger than the limit. The default policy is Reject.
+func WithPolicy(policy Policy) SinkOption {
+	return func(s *limitSink) {
+		s.policy = policy
+	}
+}
+
+// WithOversizedCounter enables a counter of messages larger than the limit, whatever the policy, labelled with
+// the topic. It panics in case it can't register the metric.
+func WithOversizedCounter(counterOpts prometheus.CounterOpts, topic string) SinkOption {
+	return func(s *limitSink) {
+		counter := metrics.Register(prometheus.NewCounterVec(counterOpts, []string{"topic"})).(*prometheus.CounterVec)
+		s.oversized = counter.WithLabelValues(topic)
+	}
+}
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that checks the size of payloads against
+// the limit, in bytes, before publishing them. Messages within the limit are published unchanged. Larger messages
+// are handled according to the policy: they are either rejected, or split into chunks carrying the headers of
+// the original message and the chunk headers, the original message being acknowledged once all its chunks are.
+// The limit applies to payloads only, so it should leave room for the overhead of headers or envelopes.
+func NewAsyncMessageSink(sink substrate.AsyncMessageSink, limit int, opts ...SinkOption) substrate.AsyncMessageSink {
+	s := &limitSink{
+		sink:  sink,
+		limit: limit,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type limitSink struct {
+	sink      substrate.AsyncMessageSink
+	limit     int
+	policy    Policy
+	oversized prometheus.Counter
+}
+
+func (s *limitSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	return transform.PublishSplit(ctx, "sizelimit", s.sink, acks, messages, func(_ context.Context, msg substrate.Message) ([]substrate.Message, error) {
+		return s.check(msg)
+	})
+}
+
+// check returns the messages to publish for the message.
+func (s *limitSink) check(msg substrate.Message) ([]substrate.Message, error) {
+	data := msg.Data()
+	if len(data) <= s.limit {
+		return []substrate.Message{msg}, nil
+	}
+	if s.oversized != nil {
+		s.oversized.Inc()
+	}
+	if s.policy != Split {
+		return nil, TooLargeError{Size: len(data), Limit: s.limit}
+	}
+	return chunker.Split(msg, s.limit)
+}
+
+func (s *limitSink) Close() error {
+	return s.sink.Close()
+}
+
+func (s *limitSink) Status() (*substrate.Status, error) {
+	return s.sink.Status()
+}
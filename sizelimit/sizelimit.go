@@ -0,0 +1,43 @@
+// Package sizelimit provides a message sink wrapper enforcing a maximum payload size before publishing, either by
+// rejecting larger messages or by splitting them into chunks, and a source reassembling chunked messages, so that
+// producers fail fast instead of hitting opaque max message size errors of the backend.
+package sizelimit
+
+import (
+	"fmt"
+
+	"github.com/uw-labs/substrate-tools/chunker"
+)
+
+// Headers set on chunks of split messages, in addition to the headers of the original message.
+const (
+	// ChunkIDHeader identifies the message the chunk belongs to.
+	ChunkIDHeader = chunker.IDHeader
+	// ChunkIndexHeader is the position of the chunk within its message, starting at 0.
+	ChunkIndexHeader = chunker.IndexHeader
+	// ChunkCountHeader is the number of chunks of the message.
+	ChunkCountHeader = chunker.CountHeader
+)
+
+// TooLargeError is the error returned when a message larger than the limit is rejected.
+type TooLargeError struct {
+	// Size is the size of the payload of the message, in bytes.
+	Size int
+	// Limit is the maximum size of payloads, in bytes.
+	Limit int
+}
+
+func (e TooLargeError) Error() string {
+	return fmt.Sprintf("message of %d bytes exceeds the limit of %d bytes", e.Size, e.Limit)
+}
+
+// Policy is what the sink does with messages larger than the limit.
+type Policy int
+
+const (
+	// Reject makes PublishMessages return a TooLargeError.
+	Reject Policy = iota
+	// Split publishes the payload in chunks no larger than the limit using the chunker package, which are
+	// reassembled by the source.
+	Split
+)
@@ -0,0 +1,97 @@
+package sizelimit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/conformance"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+	"github.com/uw-labs/substrate-tools/sizelimit"
+)
+
+func TestSink_Reject(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	counterOpts := prometheus.CounterOpts{
+		Name: "sizelimit_test_oversized_total",
+		Help: "sizelimit_test_oversized_total",
+	}
+	backend := mock.NewSink()
+	sink := sizelimit.NewAsyncMessageSink(backend, 8, sizelimit.WithOversizedCounter(counterOpts, "topic"))
+
+	counter := prometheus.NewCounterVec(counterOpts, []string{"topic"})
+	if are, ok := prometheus.Register(counter).(prometheus.AlreadyRegisteredError); ok {
+		counter = are.ExistingCollector.(*prometheus.CounterVec)
+	}
+	before := testutil.ToFloat64(counter.WithLabelValues("topic"))
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	small := message.FromString("12345678")
+	messages <- small
+	require.Equal(t, small, <-acks)
+	messages <- message.FromString("123456789")
+
+	err := <-errs
+	var tooLarge sizelimit.TooLargeError
+	require.True(t, errors.As(err, &tooLarge))
+	require.Equal(t, sizelimit.TooLargeError{Size: 9, Limit: 8}, tooLarge)
+	require.EqualError(t, err, "message of 9 bytes exceeds the limit of 8 bytes")
+	require.Equal(t, []string{"12345678"}, backend.Published())
+	require.Equal(t, before+1, testutil.ToFloat64(counter.WithLabelValues("topic")))
+}
+
+func TestSplitAndReassemble(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	sink := sizelimit.NewAsyncMessageSink(message.NewEnvelopeSink(broker.NewAsyncMessageSink("topic")), 4, sizelimit.WithPolicy(sizelimit.Split))
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	go sink.PublishMessages(ctx, acks, messages)
+
+	published := []substrate.Message{
+		message.NewEnvelopedMessage(message.Envelope{Payload: []byte("abcdefghij"), Headers: map[string]string{"k": "v"}}),
+		message.FromString("abc"),
+	}
+	for _, msg := range published {
+		messages <- msg
+		// The original message is acknowledged once all its chunks are.
+		require.Equal(t, msg, <-acks)
+	}
+	require.Len(t, broker.Messages("topic"), 4)
+
+	source := sizelimit.NewAsyncMessageSource(message.NewEnvelopeSource(broker.NewAsyncMessageSource("topic", "group")))
+	consumed, sourceAcks := make(chan substrate.Message), make(chan substrate.Message)
+	go source.ConsumeMessages(ctx, consumed, sourceAcks)
+
+	first := <-consumed
+	require.Equal(t, "abcdefghij", string(first.Data()))
+	require.Equal(t, map[string]string{"k": "v"}, message.Headers(first))
+	sourceAcks <- first
+
+	second := <-consumed
+	require.Equal(t, "abc", string(second.Data()))
+	sourceAcks <- second
+}
+
+func TestConformance(t *testing.T) {
+	conformance.TestAsyncSink(t, func(_ *testing.T, backend substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+		return sizelimit.NewAsyncMessageSink(backend, 1024, sizelimit.WithPolicy(sizelimit.Split))
+	})
+}
@@ -0,0 +1,13 @@
+package sizelimit
+
+import (
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/chunker"
+)
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that reassembles messages split by the
+// sink. It is a chunker source, which can be configured with the options of the chunker package, e.g. to handle
+// messages whose chunks don't all arrive.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, opts ...chunker.SourceOption) substrate.AsyncMessageSource {
+	return chunker.NewAsyncMessageSource(source, opts...)
+}
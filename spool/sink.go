@@ -0,0 +1,333 @@
+// Package spool provides a message sink wrapper that spools messages to disk while the backend is unavailable.
+package spool
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/health"
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+	"github.com/uw-labs/substrate-tools/internal/wal"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+const defaultRetryInterval = time.Second
+
+// ErrSpoolFull is returned when a message can't be spooled because the spooled messages that haven't been published
+// yet reached the maximum size of the spool.
+var ErrSpoolFull = errors.New("spool is full")
+
+// SinkOption is a function which sets a spooling sink configuration option.
+type SinkOption func(s *spoolSink)
+
+// WithMaxSize sets the maximum size in bytes of the spooled messages that haven't been published yet. The default
+// value is 0 (unlimited).
+func WithMaxSize(size int64) SinkOption {
+	return func(s *spoolSink) {
+		s.maxSize = size
+	}
+}
+
+// WithSyncInterval sets how often the spool is flushed to disk. By default it is flushed after every write,
+// before the message is acknowledged. A positive interval flushes it on writes at most once per interval,
+// trading durability in case of a crash for throughput, while a negative one leaves flushing to the OS.
+func WithSyncInterval(interval time.Duration) SinkOption {
+	return func(s *spoolSink) {
+		s.syncInterval = interval
+	}
+}
+
+// WithRetryInterval sets the time to wait before publishing to the backend again after it failed.
+// The default value is 1 second.
+func WithRetryInterval(interval time.Duration) SinkOption {
+	return func(s *spoolSink) {
+		s.retryInterval = interval
+	}
+}
+
+// WithBufferGauges reports the size in bytes of the spooled messages that haven't been published yet, along with the
+// maximum size as the capacity, with the shared buffer gauges, labelled with the wrapper, which is "spool", and the
+// provided name. It panics in case it can't register the metrics.
+func WithBufferGauges(name string) SinkOption {
+	return func(s *spoolSink) {
+		s.bufferName = &name
+	}
+}
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that publishes messages to the backend
+// sink while it is available. When the backend fails, messages that haven't been acknowledged by it, and all
+// messages published until it recovers, are appended to a write-ahead log in the directory and acknowledged once
+// written. The spooled messages are published again, in order, once the backend recovers, or after a restart,
+// which gives at-least-once delivery across outages of the backend. The last error returned by the backend is
+// reported by Status until the backend acknowledges a message again.
+func NewAsyncMessageSink(sink substrate.AsyncMessageSink, dir string, opts ...SinkOption) (substrate.AsyncMessageSink, error) {
+	s := &spoolSink{
+		sink:          sink,
+		retryInterval: defaultRetryInterval,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.bufferName != nil {
+		s.buffer = metrics.NewBuffer("spool", *s.bufferName, "", float64(s.maxSize))
+	}
+
+	log, err := wal.Open(dir, "spool", s.maxSize, s.syncInterval)
+	if err != nil {
+		return nil, err
+	}
+	s.wal = log
+	return s, nil
+}
+
+type spoolSink struct {
+	sink          substrate.AsyncMessageSink
+	wal           *wal.Log
+	maxSize       int64
+	syncInterval  time.Duration
+	retryInterval time.Duration
+	bufferName    *string
+	buffer        *metrics.Buffer
+
+	mutex      sync.Mutex
+	backendErr error
+}
+
+// spoolMessage is a message published to the backend, either on behalf of a message published by the user
+// or read from the spool.
+type spoolMessage struct {
+	data     []byte
+	headers  map[string]string
+	original substrate.Message
+	end      int64
+}
+
+func (msg *spoolMessage) Data() []byte {
+	return msg.data
+}
+
+func (msg *spoolMessage) Headers() map[string]string {
+	return msg.headers
+}
+
+func (s *spoolSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "spool"})
+	rg, ctx := rungroup.New(ctx)
+
+	toBackend := make(chan substrate.Message)
+	backendAcks := make(chan substrate.Message)
+	backendUp := make(chan struct{})
+	backendDown := make(chan struct{})
+
+	rg.Go(func() error {
+		var (
+			attempt int
+			failure error
+		)
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case backendUp <- struct{}{}:
+			}
+			if failure != nil {
+				emit.Reconnect(attempt, failure)
+			}
+			// The backend is expected to fail while unavailable, so its error is reported by Status and publishing
+			// is retried rather than failing.
+			if err := s.sink.PublishMessages(ctx, backendAcks, toBackend); err != nil && ctx.Err() == nil {
+				// reconnection attempts are counted since the backend last acknowledged a message
+				if s.lastBackendErr() == nil {
+					attempt = 0
+				}
+				attempt++
+				failure = emit.Error(err)
+				s.setBackendErr(err)
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case backendDown <- struct{}{}:
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(s.retryInterval):
+			}
+		}
+	})
+	rg.Go(func() error {
+		return emit.Error(s.run(ctx, emit, acks, messages, toBackend, backendAcks, backendUp, backendDown))
+	})
+
+	return rg.Wait()
+}
+
+// run routes messages either to the backend or to the spool, and replays spooled messages once the backend is up.
+func (s *spoolSink) run(
+	ctx context.Context,
+	emit events.Emitter,
+	acks chan<- substrate.Message,
+	messages <-chan substrate.Message,
+	toBackend chan<- substrate.Message,
+	backendAcks <-chan substrate.Message,
+	backendUp, backendDown <-chan struct{},
+) error {
+	var (
+		up       bool
+		spooling = !s.wal.Drained()
+		outbox   *spoolMessage
+		inFlight []*spoolMessage
+	)
+
+	ack := func(msg substrate.Message) bool {
+		emit.Ack(msg)
+		select {
+		case <-ctx.Done():
+			return false
+		case acks <- msg:
+			return true
+		}
+	}
+	spool := func(msg *spoolMessage) error {
+		if err := appendRecord(s.wal, record{Data: msg.data, Headers: msg.headers}); err != nil {
+			return err
+		}
+		ack(msg.original)
+		return nil
+	}
+
+	for {
+		s.buffer.Set(float64(s.wal.Backlog()))
+
+		// Spooled messages are replayed while the backend is up, until the spool has been drained.
+		if up && spooling && outbox == nil {
+			if len(inFlight) == 0 && s.wal.Drained() {
+				if err := s.wal.Reset(); err != nil {
+					return err
+				}
+				spooling = false
+			} else {
+				e, err := nextRecord(s.wal)
+				if err != nil {
+					return err
+				}
+				if e != nil {
+					outbox = &spoolMessage{data: e.Data, headers: e.Headers, end: e.end}
+				}
+			}
+		}
+
+		// Messages published by the user are only read when they can be either spooled or sent to the backend.
+		in := messages
+		if !spooling && (!up || outbox != nil) {
+			in = nil
+		}
+		out := toBackend
+		if outbox == nil {
+			out = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-in:
+			emit.Publish(msg)
+			sMsg := &spoolMessage{data: msg.Data(), headers: message.Headers(msg), original: msg}
+			if !spooling {
+				outbox = sMsg
+				continue
+			}
+			if err := spool(sMsg); err != nil {
+				return err
+			}
+		case out <- outbox:
+			inFlight = append(inFlight, outbox)
+			outbox = nil
+		case bAck := <-backendAcks:
+			sMsg, ok := bAck.(*spoolMessage)
+			if !ok || len(inFlight) == 0 || sMsg != inFlight[0] {
+				return errors.Errorf("unexpected message acknowledged: %v", bAck)
+			}
+			inFlight = inFlight[1:]
+			s.setBackendErr(nil)
+			if sMsg.original != nil {
+				if !ack(sMsg.original) {
+					return nil
+				}
+				continue
+			}
+			if err := s.wal.Commit(sMsg.end); err != nil {
+				return err
+			}
+		case <-backendUp:
+			up = true
+		case <-backendDown:
+			up = false
+			if spooling {
+				// spooled messages that weren't acknowledged are read from the spool again
+				inFlight, outbox = nil, nil
+				s.wal.Rewind()
+				continue
+			}
+			pending := inFlight
+			if outbox != nil {
+				pending = append(pending, outbox)
+			}
+			inFlight, outbox, spooling = nil, nil, true
+			for _, sMsg := range pending {
+				if err := spool(sMsg); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// Close closes both the backend sink and the spool and returns all errors encountered.
+func (s *spoolSink) Close() (err error) {
+	err = multierror.Append(err, s.sink.Close()).ErrorOrNil()
+	return multierror.Append(err, s.wal.Close()).ErrorOrNil()
+}
+
+// Status returns the status of the backend sink, along with the size of the spooled messages that haven't been
+// published yet and the last error returned by the backend. It only reports working status if the last message
+// could be spooled as well.
+func (s *spoolSink) Status() (*substrate.Status, error) {
+	return health.Combine(s.sink, health.StatusFunc(s.spoolStatus)).Status()
+}
+
+func (s *spoolSink) spoolStatus() (*substrate.Status, error) {
+	status := &substrate.Status{Working: true}
+	if backlog := s.wal.Backlog(); backlog > 0 {
+		status.Problems = append(status.Problems, fmt.Sprintf("spool backlog: %d bytes", backlog))
+	}
+	if err := s.lastBackendErr(); err != nil {
+		status.Problems = append(status.Problems, fmt.Sprintf("backend failed: %v", err))
+	}
+	if s.wal.Full() {
+		status.Working = false
+		status.Problems = append(status.Problems, ErrSpoolFull.Error())
+	}
+	return status, nil
+}
+
+func (s *spoolSink) setBackendErr(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.backendErr = err
+}
+
+func (s *spoolSink) lastBackendErr() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.backendErr
+}
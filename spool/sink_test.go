@@ -0,0 +1,271 @@
+package spool_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/spool"
+)
+
+var errUnavailable = errors.New("backend unavailable")
+
+// flakySink is a sink that fails while it is down.
+type flakySink struct {
+	substrate.AsyncMessageSink
+
+	mu     sync.Mutex
+	down   bool
+	fail   chan struct{}
+	failed chan struct{}
+}
+
+func newFlakySink(sink substrate.AsyncMessageSink, down bool) *flakySink {
+	return &flakySink{
+		AsyncMessageSink: sink,
+		down:             down,
+		fail:             make(chan struct{}),
+		failed:           make(chan struct{}, 1),
+	}
+}
+
+func (s *flakySink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	s.mu.Lock()
+	down, fail := s.down, s.fail
+	s.mu.Unlock()
+	if down {
+		return errUnavailable
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.AsyncMessageSink.PublishMessages(ctx, acks, messages)
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-fail:
+		cancel()
+		<-errs
+		s.failed <- struct{}{}
+		return errUnavailable
+	}
+}
+
+func (s *flakySink) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+	if down {
+		close(s.fail)
+	} else {
+		s.fail = make(chan struct{})
+	}
+}
+
+func publish(ctx context.Context, t *testing.T, messages chan<- substrate.Message, acks <-chan substrate.Message, payloads ...string) {
+	for _, payload := range payloads {
+		msg := message.FromString(payload)
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "failed to publish message")
+		case messages <- msg:
+		}
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "message wasn't acknowledged")
+		case ack := <-acks:
+			require.Equal(t, msg, ack)
+		}
+	}
+}
+
+func published(broker *mem.Broker) []string {
+	var payloads []string
+	for _, data := range broker.Messages("topic") {
+		payloads = append(payloads, string(data))
+	}
+	return payloads
+}
+
+func TestSpoolDuringOutage(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	backend := newFlakySink(broker.NewAsyncMessageSink("topic"), false)
+	sink, err := spool.NewAsyncMessageSink(backend, t.TempDir(), spool.WithRetryInterval(time.Millisecond*10))
+	require.NoError(t, err)
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	publish(ctx, t, messages, acks, "a", "b")
+	require.Equal(t, []string{"a", "b"}, published(broker))
+
+	backend.setDown(true)
+	<-backend.failed
+
+	publish(ctx, t, messages, acks, "c", "d")
+	require.Equal(t, []string{"a", "b"}, published(broker))
+
+	status, err := sink.Status()
+	require.NoError(t, err)
+	require.True(t, status.Working)
+	require.Len(t, status.Problems, 2)
+	require.Contains(t, status.Problems[0], "spool backlog")
+	require.Equal(t, "backend failed: backend unavailable", status.Problems[1])
+
+	backend.setDown(false)
+	require.Eventually(t, func() bool {
+		return len(published(broker)) == 4
+	}, time.Second, time.Millisecond*10)
+	require.Equal(t, []string{"a", "b", "c", "d"}, published(broker))
+	require.Eventually(t, func() bool {
+		status, err := sink.Status()
+		return err == nil && status.Working && len(status.Problems) == 0
+	}, time.Second, time.Millisecond*10)
+
+	publish(ctx, t, messages, acks, "e")
+	require.Equal(t, []string{"a", "b", "c", "d", "e"}, published(broker))
+
+	cancel()
+	require.NoError(t, <-errs)
+	require.NoError(t, sink.Close())
+}
+
+func TestSpoolReplayAfterRestart(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	dir := t.TempDir()
+	broker := mem.NewBroker()
+
+	sink, err := spool.NewAsyncMessageSink(newFlakySink(broker.NewAsyncMessageSink("topic"), true), dir)
+	require.NoError(t, err)
+
+	publishCtx, publishCancel := context.WithCancel(ctx)
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(publishCtx, acks, messages)
+	}()
+
+	publish(ctx, t, messages, acks, "x", "y")
+	publishCancel()
+	require.NoError(t, <-errs)
+	require.NoError(t, sink.Close())
+
+	// Simulate a crash in the middle of writing a message.
+	file, err := os.OpenFile(filepath.Join(dir, "spool.wal"), os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = file.Write([]byte{0, 0, 0, 42, 1})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	sink, err = spool.NewAsyncMessageSink(broker.NewAsyncMessageSink("topic"), dir)
+	require.NoError(t, err)
+	go func() {
+		errs <- sink.PublishMessages(ctx, make(chan substrate.Message), make(chan substrate.Message))
+	}()
+
+	require.Eventually(t, func() bool {
+		return len(published(broker)) == 2
+	}, time.Second, time.Millisecond*10)
+	require.Equal(t, []string{"x", "y"}, published(broker))
+
+	cancel()
+	require.NoError(t, <-errs)
+	require.NoError(t, sink.Close())
+}
+
+func TestSpoolFull(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	backend := newFlakySink(mem.NewBroker().NewAsyncMessageSink("topic"), true)
+	sink, err := spool.NewAsyncMessageSink(backend, t.TempDir(), spool.WithMaxSize(10))
+	require.NoError(t, err)
+
+	messages := make(chan substrate.Message, 1)
+	messages <- message.FromString("message")
+
+	err = sink.PublishMessages(ctx, make(chan substrate.Message), messages)
+	require.Equal(t, spool.ErrSpoolFull, errors.Cause(err))
+
+	status, err := sink.Status()
+	require.NoError(t, err)
+	require.False(t, status.Working)
+	require.Contains(t, status.Problems, spool.ErrSpoolFull.Error())
+	require.NoError(t, sink.Close())
+}
+
+func TestSpoolBufferGauges(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	backend := newFlakySink(broker.NewAsyncMessageSink("topic"), true)
+	sink, err := spool.NewAsyncMessageSink(backend, t.TempDir(), spool.WithBufferGauges("outage"),
+		spool.WithMaxSize(1000), spool.WithRetryInterval(time.Millisecond*10))
+	require.NoError(t, err)
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	publish(ctx, t, messages, acks, "a", "b")
+	status, err := sink.Status()
+	require.NoError(t, err)
+	require.NotEmpty(t, status.Problems)
+	var backlog int
+	_, err = fmt.Sscanf(status.Problems[0], "spool backlog: %d bytes", &backlog)
+	require.NoError(t, err)
+	requireBuffer(t, backlog)
+
+	backend.setDown(false)
+	require.Eventually(t, func() bool {
+		return len(published(broker)) == 2
+	}, time.Second, time.Millisecond*10)
+	requireBuffer(t, 0)
+
+	cancel()
+	require.NoError(t, <-errs)
+	require.NoError(t, sink.Close())
+}
+
+func requireBuffer(t *testing.T, used int) {
+	expected := fmt.Sprintf(`
+# HELP substrate_tools_buffer_capacity Capacity of the buffer of a wrapper, in the same unit as the used gauge, or 0 if it is unbounded.
+# TYPE substrate_tools_buffer_capacity gauge
+substrate_tools_buffer_capacity{buffer="",name="outage",wrapper="spool"} 1000
+# HELP substrate_tools_buffer_used Number of messages, or bytes for buffers on disk, held in the buffer of a wrapper.
+# TYPE substrate_tools_buffer_used gauge
+substrate_tools_buffer_used{buffer="",name="outage",wrapper="spool"} %d
+`, used)
+	require.Eventually(t, func() bool {
+		return testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected),
+			"substrate_tools_buffer_used", "substrate_tools_buffer_capacity") == nil
+	}, time.Second, time.Millisecond*10)
+}
@@ -0,0 +1,435 @@
+package spool
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/health"
+	"github.com/uw-labs/substrate-tools/internal/wal"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// ErrUnknownToken is returned when confirming or aborting a message whose token wasn't prepared, or whose
+// message was already published, or aborted and discarded from the log.
+var ErrUnknownToken = errors.New("unknown token")
+
+var errBackendStopped = errors.New("backend stopped")
+
+// Token identifies a prepared message. It can be stored along with the changes of the local transaction, to
+// decide whether to confirm or abort the messages left prepared after a restart.
+type Token string
+
+// The operations recorded in the log of a two-phase sink.
+const (
+	opPrepare = "prepare"
+	opConfirm = "confirm"
+	opAbort   = "abort"
+	opPublish = "publish"
+)
+
+// twoPhaseRecord is an operation stored in the write-ahead log of a two-phase sink.
+type twoPhaseRecord struct {
+	Op      string            `json:"op"`
+	Token   Token             `json:"token"`
+	Data    []byte            `json:"data,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+type messageState int
+
+const (
+	statePrepared messageState = iota
+	stateConfirmed
+	stateAborted
+	statePublished
+)
+
+// preparedMessage is a message prepared for publication, along with the number of log records referring to it
+// that weren't committed.
+type preparedMessage struct {
+	token   Token
+	data    []byte
+	headers map[string]string
+	state   messageState
+	seq     int
+	records int
+}
+
+func (msg *preparedMessage) Data() []byte {
+	return msg.data
+}
+
+func (msg *preparedMessage) Headers() map[string]string {
+	return msg.headers
+}
+
+func (msg *preparedMessage) settled() bool {
+	return msg.state == stateAborted || msg.state == statePublished
+}
+
+// logRecord is a record of the log that wasn't committed, which ends at the offset.
+type logRecord struct {
+	msg *preparedMessage
+	end int64
+}
+
+// TwoPhaseSink publishes messages in two phases, so that their publication can be coordinated with local
+// transactions: messages are first prepared, which writes them to a write-ahead log without publishing them,
+// then either confirmed, once the transaction committed, or aborted. Confirmed messages are published by Run,
+// in the order in which they were confirmed. Messages survive restarts in every phase, prepared messages being
+// returned by Pending until they are confirmed or aborted. It is safe for concurrent use.
+type TwoPhaseSink struct {
+	sink          substrate.AsyncMessageSink
+	maxSize       int64
+	retryInterval time.Duration
+
+	mutex     sync.Mutex
+	log       *wal.Log
+	messages  map[Token]*preparedMessage
+	records   []logRecord
+	confirmed []*preparedMessage
+	seq       int
+	notify    chan struct{}
+}
+
+// NewTwoPhaseSink returns a two-phase sink publishing the confirmed messages to the sink, which writes its log
+// in the directory. The options of NewAsyncMessageSink apply: the maximum size limits the messages that can be
+// prepared, while confirming and aborting always succeed, and the retry interval is the time Run waits before
+// publishing to the sink again after it failed. Messages recovered from the log are prepared or confirmed again.
+func NewTwoPhaseSink(sink substrate.AsyncMessageSink, dir string, opts ...SinkOption) (*TwoPhaseSink, error) {
+	s := &spoolSink{retryInterval: defaultRetryInterval}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	log, err := wal.Open(dir, "twophase", 0, s.syncInterval)
+	if err != nil {
+		return nil, err
+	}
+	p := &TwoPhaseSink{
+		sink:          sink,
+		maxSize:       s.maxSize,
+		retryInterval: s.retryInterval,
+		log:           log,
+		messages:      make(map[Token]*preparedMessage),
+		notify:        make(chan struct{}, 1),
+	}
+	if err := p.recover(); err != nil {
+		log.Close()
+		return nil, err
+	}
+	if err := p.commit(); err != nil {
+		log.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// recover replays the operations recorded in the log.
+func (p *TwoPhaseSink) recover() error {
+	for {
+		e, err := p.log.Next()
+		if err != nil || e == nil {
+			return err
+		}
+		var rec twoPhaseRecord
+		if err := json.Unmarshal(e.Body, &rec); err != nil {
+			return errors.Wrap(err, "failed to decode two-phase record")
+		}
+
+		msg, ok := p.messages[rec.Token]
+		switch {
+		case ok:
+			p.apply(msg, rec.Op)
+		case rec.Op == opPrepare:
+			msg = &preparedMessage{token: rec.Token, data: rec.Data, headers: rec.Headers}
+			p.messages[rec.Token] = msg
+		default:
+			// the earlier records of the message were committed, which only happens once it is settled
+			msg = &preparedMessage{token: rec.Token, state: statePublished}
+			p.messages[rec.Token] = msg
+		}
+		msg.records++
+		p.records = append(p.records, logRecord{msg: msg, end: e.End})
+	}
+}
+
+// apply updates the state of the message according to the operation.
+func (p *TwoPhaseSink) apply(msg *preparedMessage, op string) {
+	switch op {
+	case opConfirm:
+		p.seq++
+		msg.state, msg.seq = stateConfirmed, p.seq
+		p.confirmed = append(p.confirmed, msg)
+		select {
+		case p.notify <- struct{}{}:
+		default:
+		}
+	case opAbort:
+		msg.state = stateAborted
+	case opPublish:
+		msg.state = statePublished
+		p.confirmed = p.confirmed[1:]
+	}
+}
+
+// record appends the operation on the message to the log and applies it.
+func (p *TwoPhaseSink) record(msg *preparedMessage, op string) error {
+	rec := twoPhaseRecord{Op: op, Token: msg.token}
+	if op == opPrepare {
+		rec.Data, rec.Headers = msg.data, msg.headers
+	}
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode two-phase record")
+	}
+	end, err := p.log.Append(body)
+	if err != nil {
+		return err
+	}
+	p.apply(msg, op)
+	msg.records++
+	p.records = append(p.records, logRecord{msg: msg, end: end})
+	return p.commit()
+}
+
+// commit commits the records of settled messages at the start of the log, discarding the log once all of them
+// are committed.
+func (p *TwoPhaseSink) commit() error {
+	var offset int64
+	for len(p.records) > 0 && p.records[0].msg.settled() {
+		rec := p.records[0]
+		p.records = p.records[1:]
+		if rec.msg.records--; rec.msg.records == 0 {
+			delete(p.messages, rec.msg.token)
+		}
+		offset = rec.end
+	}
+	switch {
+	case offset == 0:
+		return nil
+	case len(p.records) == 0:
+		return p.log.Reset()
+	default:
+		return p.log.Commit(offset)
+	}
+}
+
+// Prepare writes the message to the log without publishing it, and returns the token identifying it. It returns
+// ErrSpoolFull if the log reached its maximum size.
+func (p *TwoPhaseSink) Prepare(msg substrate.Message) (Token, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.maxSize > 0 && p.log.Backlog() >= p.maxSize {
+		return "", ErrSpoolFull
+	}
+	pMsg := &preparedMessage{token: token, data: msg.Data(), headers: message.Headers(msg)}
+	p.messages[token] = pMsg
+	if err := p.record(pMsg, opPrepare); err != nil {
+		delete(p.messages, token)
+		return "", err
+	}
+	return token, nil
+}
+
+// Confirm makes the prepared message eligible for publication. Confirming a message that is awaiting publication
+// has no effect, while confirming an aborted message fails.
+func (p *TwoPhaseSink) Confirm(token Token) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	msg, ok := p.messages[token]
+	switch {
+	case !ok || msg.state == statePublished:
+		return ErrUnknownToken
+	case msg.state == stateAborted:
+		return errors.Errorf("failed to confirm message %s, it was aborted", token)
+	case msg.state == stateConfirmed:
+		return nil
+	}
+	return p.record(msg, opConfirm)
+}
+
+// Abort discards the prepared message. Aborting a message that wasn't discarded from the log yet has no effect,
+// while aborting a confirmed message fails.
+func (p *TwoPhaseSink) Abort(token Token) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	msg, ok := p.messages[token]
+	switch {
+	case !ok || msg.state == statePublished:
+		return ErrUnknownToken
+	case msg.state == stateConfirmed:
+		return errors.Errorf("failed to abort message %s, it was confirmed", token)
+	case msg.state == stateAborted:
+		return nil
+	}
+	return p.record(msg, opAbort)
+}
+
+// Pending returns the tokens of the messages that were prepared but neither confirmed nor aborted, in the order
+// in which they were prepared. After a restart, the application should confirm or abort each of them depending
+// on the outcome of its transaction.
+func (p *TwoPhaseSink) Pending() []Token {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var tokens []Token
+	seen := make(map[Token]bool)
+	for _, rec := range p.records {
+		if rec.msg.state == statePrepared && !seen[rec.msg.token] {
+			seen[rec.msg.token] = true
+			tokens = append(tokens, rec.msg.token)
+		}
+	}
+	return tokens
+}
+
+// Run publishes the confirmed messages to the sink until the context is cancelled, in the order in which they
+// were confirmed. When the sink fails, the messages it didn't acknowledge are published again after the retry
+// interval, which gives at-least-once delivery. It returns an error if the log can't be written.
+func (p *TwoPhaseSink) Run(ctx context.Context) error {
+	for {
+		if err := p.publish(ctx); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(p.retryInterval):
+		}
+	}
+}
+
+// publish publishes the confirmed messages until the sink fails.
+func (p *TwoPhaseSink) publish(ctx context.Context) error {
+	rg, ctx := rungroup.New(ctx)
+
+	toSink, sinkAcks := make(chan substrate.Message), make(chan substrate.Message)
+
+	rg.Go(func() error {
+		// The error is ignored, as the sink is expected to fail while unavailable.
+		_ = p.sink.PublishMessages(ctx, sinkAcks, toSink)
+		return errBackendStopped
+	})
+	rg.Go(func() error {
+		sent := 0
+		for {
+			next := p.next(sent)
+			if next == nil {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-p.notify:
+				}
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case toSink <- next:
+				sent = next.seq
+			}
+		}
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-sinkAcks:
+				if err := p.published(ack); err != nil {
+					return err
+				}
+			}
+		}
+	})
+
+	if err := rg.Wait(); err != errBackendStopped {
+		return err
+	}
+	return nil
+}
+
+// next returns the first confirmed message that wasn't published, confirmed after the given sequence number, if any.
+func (p *TwoPhaseSink) next(after int) *preparedMessage {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	i := sort.Search(len(p.confirmed), func(i int) bool {
+		return p.confirmed[i].seq > after
+	})
+	if i == len(p.confirmed) {
+		return nil
+	}
+	return p.confirmed[i]
+}
+
+// published records that the sink acknowledged the message.
+func (p *TwoPhaseSink) published(ack substrate.Message) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	msg, ok := ack.(*preparedMessage)
+	if !ok || len(p.confirmed) == 0 || msg != p.confirmed[0] {
+		return errors.Errorf("unexpected message acknowledged: %v", ack)
+	}
+	return p.record(msg, opPublish)
+}
+
+// Close closes both the sink and the log and returns all errors encountered.
+func (p *TwoPhaseSink) Close() (err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	err = multierror.Append(err, p.sink.Close()).ErrorOrNil()
+	return multierror.Append(err, p.log.Close()).ErrorOrNil()
+}
+
+// Status returns the status of the sink, along with the number of messages awaiting confirmation and the number
+// of confirmed messages that weren't published yet.
+func (p *TwoPhaseSink) Status() (*substrate.Status, error) {
+	return health.Combine(p.sink, health.StatusFunc(p.twoPhaseStatus)).Status()
+}
+
+func (p *TwoPhaseSink) twoPhaseStatus() (*substrate.Status, error) {
+	pending := len(p.Pending())
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	status := &substrate.Status{Working: true}
+	if pending > 0 {
+		status.Problems = append(status.Problems, fmt.Sprintf("prepared messages: %d", pending))
+	}
+	if len(p.confirmed) > 0 {
+		status.Problems = append(status.Problems, fmt.Sprintf("confirmed messages awaiting publication: %d", len(p.confirmed)))
+	}
+	return status, nil
+}
+
+func newToken() (Token, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", errors.Wrap(err, "failed to generate token")
+	}
+	return Token(hex.EncodeToString(id)), nil
+}
@@ -0,0 +1,133 @@
+package spool_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/spool"
+)
+
+func TestTwoPhaseSink(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	backend := newFlakySink(broker.NewAsyncMessageSink("topic"), false)
+	sink, err := spool.NewTwoPhaseSink(backend, t.TempDir(), spool.WithRetryInterval(time.Millisecond*10))
+	require.NoError(t, err)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.Run(ctx)
+	}()
+
+	a, err := sink.Prepare(message.FromString("a"))
+	require.NoError(t, err)
+	b, err := sink.Prepare(message.FromString("b"))
+	require.NoError(t, err)
+	c, err := sink.Prepare(message.FromString("c"))
+	require.NoError(t, err)
+	require.Equal(t, []spool.Token{a, b, c}, sink.Pending())
+
+	// Messages are published in the order in which they are confirmed, aborted ones never are.
+	require.NoError(t, sink.Confirm(c))
+	require.NoError(t, sink.Abort(b))
+	require.NoError(t, sink.Confirm(a))
+	require.Eventually(t, func() bool {
+		return len(published(broker)) == 2
+	}, time.Second, time.Millisecond*10)
+	require.Equal(t, []string{"c", "a"}, published(broker))
+	require.Empty(t, sink.Pending())
+
+	// Settled messages are discarded.
+	require.Equal(t, spool.ErrUnknownToken, sink.Confirm(b))
+	require.Equal(t, spool.ErrUnknownToken, sink.Abort(a))
+
+	// Confirmed messages are published once the sink recovers.
+	backend.setDown(true)
+	<-backend.failed
+	d, err := sink.Prepare(message.FromString("d"))
+	require.NoError(t, err)
+	require.NoError(t, sink.Confirm(d))
+	require.Error(t, sink.Abort(d))
+	backend.setDown(false)
+	require.Eventually(t, func() bool {
+		return len(published(broker)) == 3
+	}, time.Second, time.Millisecond*10)
+	require.Equal(t, []string{"c", "a", "d"}, published(broker))
+
+	cancel()
+	require.NoError(t, <-errs)
+	require.NoError(t, sink.Close())
+}
+
+func TestTwoPhaseSink_Restart(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	dir := t.TempDir()
+	broker := mem.NewBroker()
+
+	// The sink is closed before publishing anything.
+	sink, err := spool.NewTwoPhaseSink(broker.NewAsyncMessageSink("topic"), dir)
+	require.NoError(t, err)
+	a, err := sink.Prepare(message.FromString("a"))
+	require.NoError(t, err)
+	b, err := sink.Prepare(message.FromString("b"))
+	require.NoError(t, err)
+	c, err := sink.Prepare(message.FromString("c"))
+	require.NoError(t, err)
+	require.NoError(t, sink.Confirm(b))
+	require.NoError(t, sink.Abort(c))
+	require.NoError(t, sink.Abort(c))
+	require.Error(t, sink.Confirm(c))
+	require.NoError(t, sink.Close())
+
+	sink, err = spool.NewTwoPhaseSink(broker.NewAsyncMessageSink("topic"), dir)
+	require.NoError(t, err)
+	require.Equal(t, []spool.Token{a}, sink.Pending())
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.Run(ctx)
+	}()
+	require.NoError(t, sink.Confirm(a))
+	require.Eventually(t, func() bool {
+		return len(published(broker)) == 2
+	}, time.Second, time.Millisecond*10)
+	require.Equal(t, []string{"b", "a"}, published(broker))
+
+	cancel()
+	require.NoError(t, <-errs)
+	require.NoError(t, sink.Close())
+
+	// Published messages aren't published again.
+	sink, err = spool.NewTwoPhaseSink(broker.NewAsyncMessageSink("topic"), dir)
+	require.NoError(t, err)
+	require.Empty(t, sink.Pending())
+	status, err := sink.Status()
+	require.NoError(t, err)
+	require.Empty(t, status.Problems)
+	require.NoError(t, sink.Close())
+}
+
+func TestTwoPhaseSink_Full(t *testing.T) {
+	sink, err := spool.NewTwoPhaseSink(mem.NewBroker().NewAsyncMessageSink("topic"), t.TempDir(), spool.WithMaxSize(10))
+	require.NoError(t, err)
+
+	token, err := sink.Prepare(message.FromString("message"))
+	require.NoError(t, err)
+	_, err = sink.Prepare(message.FromString("message"))
+	require.Equal(t, spool.ErrSpoolFull, err)
+
+	// Messages can always be aborted.
+	require.NoError(t, sink.Abort(token))
+	_, err = sink.Prepare(message.FromString("message"))
+	require.NoError(t, err)
+	require.NoError(t, sink.Close())
+}
@@ -0,0 +1,48 @@
+package spool
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate-tools/internal/wal"
+)
+
+// record is a message stored in the write-ahead log.
+type record struct {
+	Data    []byte            `json:"data"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// entry is a record read from the log, along with the offset at which the next entry starts.
+type entry struct {
+	record
+	end int64
+}
+
+// appendRecord writes the record to the end of the log.
+func appendRecord(log *wal.Log, rec record) error {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode spooled message")
+	}
+	switch _, err := log.Append(body); err {
+	case wal.ErrFull:
+		return ErrSpoolFull
+	default:
+		return err
+	}
+}
+
+// nextRecord reads the next record from the log. It returns nil if all records have been read.
+func nextRecord(log *wal.Log) (*entry, error) {
+	e, err := log.Next()
+	if err != nil || e == nil {
+		return nil, err
+	}
+	rec := &entry{end: e.End}
+	if err := json.Unmarshal(e.Body, &rec.record); err != nil {
+		return nil, errors.Wrap(err, "failed to decode spooled message")
+	}
+	return rec, nil
+}
@@ -0,0 +1,309 @@
+// Package subscriptions provides a message source merging a set of sources that changes at runtime, for consumers
+// whose list of topics changes while they are running, e.g. multi-tenant consumers subscribing to a topic per tenant.
+package subscriptions
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+var (
+	// ErrSubscriptionExists is the error returned when adding a subscription with the name of an existing one.
+	ErrSubscriptionExists = errors.New("subscription already exists")
+	// ErrSubscriptionNotFound is the error returned when removing a subscription that doesn't exist.
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	// ErrAlreadyConsuming is the error returned when consuming from a manager that is already being consumed from.
+	ErrAlreadyConsuming = errors.New("subscriptions are already being consumed")
+)
+
+// Manager is an instance of substrate.AsyncMessageSource that merges the messages of its subscriptions, which can
+// be added and removed while messages are being consumed, and routes acknowledgements back to the source the
+// message came from. It is safe for concurrent use.
+type Manager struct {
+	mutex         sync.Mutex
+	subscriptions map[string]*subscription
+	// run is the current call to ConsumeMessages, if any.
+	run *run
+}
+
+type subscription struct {
+	source   substrate.AsyncMessageSource
+	consumer *consumer
+}
+
+// run is a call to ConsumeMessages.
+type run struct {
+	ctx      context.Context
+	cancel   func()
+	emit     events.Emitter
+	messages chan<- substrate.Message
+	wg       sync.WaitGroup
+
+	errMutex sync.Mutex
+	err      *multierror.Error
+}
+
+func (r *run) fail(err error) {
+	r.errMutex.Lock()
+	r.err = multierror.Append(r.err, err)
+	r.errMutex.Unlock()
+	r.cancel()
+}
+
+// consumer consumes the source of a subscription during a run.
+type consumer struct {
+	cancel  func()
+	acks    chan substrate.Message
+	emit    events.Emitter
+	removed atomic.Bool
+	// done is closed once the source stopped consuming, stopped once its messages are no longer forwarded too.
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// New returns a manager without any subscriptions.
+func New() *Manager {
+	return &Manager{
+		subscriptions: make(map[string]*subscription),
+	}
+}
+
+// Add adds a subscription consuming from the source under the name, which must be unique. If messages are being
+// consumed from the manager, the source starts being consumed immediately.
+func (m *Manager) Add(name string, source substrate.AsyncMessageSource) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.subscriptions[name]; ok {
+		return errors.Wrap(ErrSubscriptionExists, name)
+	}
+	sub := &subscription{source: source}
+	m.subscriptions[name] = sub
+	if m.run != nil {
+		m.start(m.run, name, sub)
+	}
+	return nil
+}
+
+// Remove removes the subscription with the name. It stops consuming from its source, waits for it to return and
+// closes it. Acknowledgements of the messages of the subscription that are received afterwards are ignored, so
+// those messages are delivered again if the source is subscribed to later.
+func (m *Manager) Remove(name string) error {
+	m.mutex.Lock()
+	sub, ok := m.subscriptions[name]
+	delete(m.subscriptions, name)
+	var c *consumer
+	if ok && m.run != nil {
+		c = sub.consumer
+	}
+	m.mutex.Unlock()
+
+	if !ok {
+		return errors.Wrap(ErrSubscriptionNotFound, name)
+	}
+	if c != nil {
+		c.removed.Store(true)
+		c.cancel()
+		<-c.stopped
+	}
+	return sub.source.Close()
+}
+
+// Names returns the names of the subscriptions, in alphabetical order.
+func (m *Manager) Names() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.sortedNames()
+}
+
+// ConsumeMessages consumes messages from the sources of all the subscriptions, including the ones added while it
+// runs, and forwards acknowledgements to the appropriate one. A source that terminates without an error doesn't
+// stop the others, acknowledgements of its messages are ignored. It terminates when the context is cancelled or
+// any of the sources fails, after all of them stopped, and returns the errors of all the sources that failed.
+func (m *Manager) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	r := &run{
+		ctx:      ctx,
+		cancel:   cancel,
+		emit:     events.NewEmitter(ctx, events.Info{Component: "subscriptions"}),
+		messages: messages,
+	}
+	m.mutex.Lock()
+	if m.run != nil {
+		m.mutex.Unlock()
+		return ErrAlreadyConsuming
+	}
+	m.run = r
+	for name, sub := range m.subscriptions {
+		m.start(r, name, sub)
+	}
+	m.mutex.Unlock()
+
+	// Forward acks to the correct source.
+forwardAcks:
+	for {
+		select {
+		case <-ctx.Done():
+			break forwardAcks
+		case msg := <-acks:
+			sMsg, ok := msg.(*subscriptionMessage)
+			if !ok {
+				r.fail(r.emit.Error(errors.Errorf("unexpected message type: %T", msg)))
+				break forwardAcks
+			}
+			sMsg.consumer.emit.Ack(sMsg)
+			select {
+			case <-ctx.Done():
+				break forwardAcks
+			case <-sMsg.consumer.done:
+			case sMsg.consumer.acks <- sMsg.msg:
+			}
+		}
+	}
+
+	cancel()
+	// no subscriptions can be started once the run is reset, so waiting for them can't race with starting them
+	m.mutex.Lock()
+	m.run = nil
+	m.mutex.Unlock()
+	r.wg.Wait()
+
+	return r.err.ErrorOrNil()
+}
+
+// start starts consuming the source of the subscription. It must be called with the mutex held.
+func (m *Manager) start(r *run, name string, sub *subscription) {
+	ctx, cancel := context.WithCancel(r.ctx)
+	c := &consumer{
+		cancel:  cancel,
+		acks:    make(chan substrate.Message),
+		emit:    events.NewEmitter(r.ctx, events.Info{Component: "subscriptions", Topic: name}),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	sub.consumer = c
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer close(c.stopped)
+		defer cancel()
+
+		consumed := make(chan substrate.Message)
+		var err error
+		go func() {
+			defer close(c.done)
+			err = sub.source.ConsumeMessages(ctx, consumed, c.acks)
+		}()
+
+		// Annotate messages with the consumer they come from.
+	forward:
+		for {
+			select {
+			case <-ctx.Done():
+				break forward
+			case <-c.done:
+				break forward
+			case msg := <-consumed:
+				sMsg := &subscriptionMessage{consumer: c, msg: msg}
+				c.emit.Publish(sMsg)
+				select {
+				case <-ctx.Done():
+					break forward
+				case r.messages <- sMsg:
+				}
+			}
+		}
+
+		cancel()
+		<-c.done
+		if err != nil && !c.removed.Load() {
+			r.fail(errors.Wrapf(err, "subscription %s", name))
+		}
+	}()
+}
+
+// Close closes the sources of all the subscriptions and returns all errors encountered.
+func (m *Manager) Close() (err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, name := range m.sortedNames() {
+		if cErr := m.subscriptions[name].source.Close(); cErr != nil {
+			err = multierror.Append(err, errors.Wrapf(cErr, "subscription %s", name))
+		}
+	}
+	return err
+}
+
+// Status calls the status method on the sources of all the subscriptions. It collects all errors encountered and
+// only reports working status if all the sources do, prefixing their problems with the name of their subscription.
+func (m *Manager) Status() (status *substrate.Status, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	status = &substrate.Status{Working: true}
+	for _, name := range m.sortedNames() {
+		sourceStatus, sourceErr := m.subscriptions[name].source.Status()
+		if sourceErr != nil {
+			status.Working = false
+			err = multierror.Append(err, errors.Wrapf(sourceErr, "subscription %s", name))
+			continue
+		}
+		status.Working = status.Working && sourceStatus.Working
+		for _, problem := range sourceStatus.Problems {
+			status.Problems = append(status.Problems, fmt.Sprintf("subscription %s: %s", name, problem))
+		}
+	}
+	return status, err
+}
+
+// sortedNames returns the names of the subscriptions in alphabetical order. It must be called with the mutex held.
+func (m *Manager) sortedNames() []string {
+	names := make([]string, 0, len(m.subscriptions))
+	for name := range m.subscriptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type subscriptionMessage struct {
+	consumer *consumer
+	msg      substrate.Message
+}
+
+func (m *subscriptionMessage) DiscardPayload() {
+	if dMsg, ok := m.msg.(substrate.DiscardableMessage); ok {
+		dMsg.DiscardPayload()
+	}
+}
+
+func (m *subscriptionMessage) Data() []byte {
+	return m.msg.Data()
+}
+
+func (m *subscriptionMessage) Headers() map[string]string {
+	return message.Headers(m.msg)
+}
+
+func (m *subscriptionMessage) ContentType() string {
+	return message.ContentType(m.msg)
+}
+
+func (m *subscriptionMessage) CarriedEnvelope() (message.Envelope, bool) {
+	return message.EnvelopeOf(m.msg)
+}
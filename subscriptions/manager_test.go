@@ -0,0 +1,152 @@
+package subscriptions_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/conformance"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+	"github.com/uw-labs/substrate-tools/subscriptions"
+)
+
+func consume(ctx context.Context, source substrate.AsyncMessageSource) (<-chan substrate.Message, chan<- substrate.Message, <-chan error) {
+	msgs, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, msgs, acks)
+	}()
+	return msgs, acks, errs
+}
+
+// receive acknowledges the next count messages and returns their payloads.
+func receive(t *testing.T, msgs <-chan substrate.Message, acks chan<- substrate.Message, count int) map[string]bool {
+	received := make(map[string]bool)
+	for i := 0; i < count; i++ {
+		select {
+		case msg := <-msgs:
+			received[string(msg.Data())] = true
+			acks <- msg
+		case <-time.After(time.Second):
+			require.FailNow(t, "message wasn't delivered", "%d of %d delivered", i, count)
+		}
+	}
+	return received
+}
+
+func TestManager_AddRemove(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	orders := mock.NewSource(message.FromString("order-1"), message.FromString("order-2"))
+	payments := mock.NewSource(message.FromString("payment-1"))
+
+	manager := subscriptions.New()
+	require.NoError(t, manager.Add("orders", orders))
+	msgs, acks, errs := consume(ctx, manager)
+	require.Equal(t, map[string]bool{"order-1": true, "order-2": true}, receive(t, msgs, acks, 2))
+
+	// Subscriptions added while consuming start immediately.
+	require.NoError(t, manager.Add("payments", payments))
+	require.Equal(t, map[string]bool{"payment-1": true}, receive(t, msgs, acks, 1))
+
+	// The mock sources terminate with an error if acknowledgements are out of order.
+	for _, source := range []*mock.Source{orders, payments} {
+		select {
+		case <-source.AllAcked():
+		case err := <-errs:
+			require.FailNow(t, "consuming failed", "%v", err)
+		}
+	}
+
+	require.NoError(t, manager.Remove("orders"))
+	require.True(t, orders.WasClosed())
+	require.Equal(t, []string{"payments"}, manager.Names())
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestManager_Metadata(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	manager := subscriptions.New()
+	require.NoError(t, manager.Add("orders", mock.NewSource(message.NewEnvelopedMessage(message.Envelope{
+		ContentType: "text/plain",
+		Headers:     map[string]string{"traceparent": "00-trace-span-01"},
+		Payload:     []byte("order-1"),
+	}))))
+	msgs, acks, errs := consume(ctx, manager)
+
+	// Delivered messages report the headers and content type of the messages of the subscriptions.
+	msg := <-msgs
+	require.Equal(t, map[string]string{"traceparent": "00-trace-span-01"}, message.Headers(msg))
+	require.Equal(t, "text/plain", message.ContentType(msg))
+	acks <- msg
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestManager_RemoveOutstanding(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source := mock.NewSource(message.FromString("message-0"), message.FromString("message-1"))
+	manager := subscriptions.New()
+	require.NoError(t, manager.Add("topic", source))
+	msgs, acks, errs := consume(ctx, manager)
+
+	msg := <-msgs
+	require.NoError(t, manager.Remove("topic"))
+
+	// The acknowledgement of a message of a removed subscription is ignored.
+	acks <- msg
+	require.Empty(t, source.Acked())
+	require.Empty(t, manager.Names())
+
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestManager_SourceFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	manager := subscriptions.New()
+	require.NoError(t, manager.Add("healthy", mock.NewSource()))
+	require.NoError(t, manager.Add("failing", mock.NewSource(message.FromString("message-0")).FailAt(1, errors.New("boom"))))
+
+	_, _, errs := consume(ctx, manager)
+	err := <-errs
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "subscription failing: boom")
+}
+
+func TestManager_Errors(t *testing.T) {
+	manager := subscriptions.New()
+	require.NoError(t, manager.Add("topic", mock.NewSource(message.FromString("message-0"))))
+	require.Equal(t, subscriptions.ErrSubscriptionExists, errors.Cause(manager.Add("topic", mock.NewSource())))
+	require.Equal(t, subscriptions.ErrSubscriptionNotFound, errors.Cause(manager.Remove("other")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	msgs, _, errs := consume(ctx, manager)
+	<-msgs
+	require.Equal(t, subscriptions.ErrAlreadyConsuming, manager.ConsumeMessages(ctx, nil, nil))
+	cancel()
+	require.NoError(t, <-errs)
+}
+
+func TestManager_Conformance(t *testing.T) {
+	conformance.TestAsyncSource(t, func(t *testing.T, backend substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		manager := subscriptions.New()
+		require.NoError(t, manager.Add("topic", backend))
+		return manager
+	})
+}
@@ -0,0 +1,80 @@
+// Package sync provides a synchronous, message at a time, way of consuming an asynchronous message source.
+package sync
+
+import (
+	"context"
+	"time"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// ConsumeOption is a function which sets a ConsumeEach configuration option.
+type ConsumeOption func(cfg *consumeConfig)
+
+// WithRetries sets the number of times the handler is retried for a message it failed to handle before
+// ConsumeEach is aborted, waiting for the given delay between attempts. The default value is 0 (abort on
+// the first error).
+func WithRetries(retries int, delay time.Duration) ConsumeOption {
+	return func(cfg *consumeConfig) {
+		cfg.retries = retries
+		cfg.delay = delay
+	}
+}
+
+type consumeConfig struct {
+	retries int
+	delay   time.Duration
+}
+
+// ConsumeEach consumes messages from the source one at a time, calling the handler for each of them. A message
+// is acknowledged once the handler returns successfully for it. If the handler returns an error and there are
+// no retries left, consumption is aborted and the error returned. Messages are handled and acknowledged in the
+// order in which they are consumed. This function blocks until the context is done or an error occurs.
+func ConsumeEach(ctx context.Context, source substrate.AsyncMessageSource, handler substrate.ConsumerMessageHandler, opts ...ConsumeOption) error {
+	cfg := &consumeConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	rg, ctx := rungroup.New(ctx)
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+
+	rg.Go(func() error {
+		return source.ConsumeMessages(ctx, messages, acks)
+	})
+	rg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-messages:
+				if err := handle(ctx, cfg, handler, msg); err != nil {
+					return err
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case acks <- msg:
+				}
+			}
+		}
+	})
+
+	return rg.Wait()
+}
+
+func handle(ctx context.Context, cfg *consumeConfig, handler substrate.ConsumerMessageHandler, msg substrate.Message) error {
+	for attempt := 0; ; attempt++ {
+		err := handler(ctx, msg)
+		if err == nil || attempt >= cfg.retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(cfg.delay):
+		}
+	}
+}
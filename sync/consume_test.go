@@ -0,0 +1,91 @@
+package sync_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+	"github.com/uw-labs/substrate-tools/sync"
+)
+
+func TestConsumeEach(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			message.FromString("1"),
+			message.FromString("2"),
+			message.FromString("3"),
+		},
+	}
+
+	var handled []string
+	err := sync.ConsumeEach(ctx, source, func(_ context.Context, msg substrate.Message) error {
+		handled = append(handled, string(msg.Data()))
+		if len(handled) == len(source.Messages) {
+			// Close the source once all messages were received, the mock source
+			// checks that the acknowledgements are received in order.
+			go func() {
+				time.Sleep(time.Millisecond * 100)
+				source.Close()
+			}()
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"1", "2", "3"}, handled)
+}
+
+func TestConsumeEach_Abort(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			message.FromString("1"),
+		},
+	}
+	handlerErr := errors.New("handler error")
+
+	calls := 0
+	err := sync.ConsumeEach(ctx, source, func(_ context.Context, msg substrate.Message) error {
+		calls++
+		return handlerErr
+	})
+	require.Equal(t, handlerErr, err)
+	require.Equal(t, 1, calls)
+}
+
+func TestConsumeEach_Retries(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	source := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			message.FromString("1"),
+			message.FromString("2"),
+		},
+	}
+	handlerErr := errors.New("handler error")
+
+	calls := 0
+	err := sync.ConsumeEach(ctx, source, func(_ context.Context, msg substrate.Message) error {
+		calls++
+		if string(msg.Data()) == "1" && calls < 3 {
+			return handlerErr
+		}
+		if string(msg.Data()) == "2" {
+			return handlerErr
+		}
+		return nil
+	}, sync.WithRetries(2, time.Millisecond))
+	require.Equal(t, handlerErr, err)
+	require.Equal(t, 6, calls)
+}
@@ -0,0 +1,173 @@
+// Package toolsurl extends suburl with wrappers configured in the query of substrate URLs, e.g.
+//
+//	kafka://localhost:9092/events?envelope=true&compress=zstd&compress.threshold=0
+//
+// Query parameters named after a wrapper type registered with the config package enable that wrapper, in the order
+// in which they appear, the first one being the outermost one. The value of the parameter is either true, or false to
+// leave the wrapper out, or the value of the main option of the wrapper, such as the encoding of compress. Other
+// options are set using parameters of the form <wrapper>.<option>. Values are parsed as YAML scalars or flow
+// sequences, e.g. 0.5 or [password, token]. All the other parameters are left in the URL passed to suburl.
+package toolsurl
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/uw-labs/substrate"
+
+	"github.com/uw-labs/substrate-tools/config"
+)
+
+// NamespaceParam is the query parameter setting the namespace of the metrics of the wrappers.
+const NamespaceParam = "metrics-namespace"
+
+// mainOptions are the options set by the value of the parameter enabling a wrapper, per wrapper type.
+var (
+	mainSinkOptions = map[string]string{
+		"acktimeout": "timeout",
+		"chunker":    "threshold",
+		"compress":   "encoding",
+		"envelope":   "content_type",
+		"sizelimit":  "limit",
+	}
+	mainSourceOptions = map[string]string{
+		"ackordering":  "window_size",
+		"backpressure": "budget",
+		"chunker":      "timeout",
+		"drain":        "timeout",
+		"sample":       "fraction",
+		"ttl":          "ttl",
+	}
+)
+
+// topicOptions are the wrapper types whose topic option defaults to the path of the URL.
+var topicOptions = map[string]bool{
+	"instrumented": true,
+	"traced":       true,
+}
+
+// NewSink returns the sink of the URL, wrapped with the wrappers configured in its query.
+func NewSink(u string) (substrate.AsyncMessageSink, error) {
+	cfg, err := ParseSink(u)
+	if err != nil {
+		return nil, err
+	}
+	return config.NewSink(cfg)
+}
+
+// NewSource returns the source of the URL, wrapped with the wrappers configured in its query.
+func NewSource(u string) (substrate.AsyncMessageSource, error) {
+	cfg, err := ParseSource(u)
+	if err != nil {
+		return nil, err
+	}
+	return config.NewSource(cfg)
+}
+
+// ParseSink returns the configuration of a sink described by the URL.
+func ParseSink(u string) (*config.Config, error) {
+	return parse(u, config.SinkTypes(), mainSinkOptions)
+}
+
+// ParseSource returns the configuration of a source described by the URL.
+func ParseSource(u string) (*config.Config, error) {
+	return parse(u, config.SourceTypes(), mainSourceOptions)
+}
+
+func parse(rawURL string, types []string, mainOptions map[string]string) (*config.Config, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid url")
+	}
+	known := make(map[string]bool, len(types))
+	for _, typ := range types {
+		known[typ] = true
+	}
+
+	cfg := &config.Config{}
+	var (
+		// wrappers are indexed by type, as options may be given before the parameter enabling the wrapper
+		wrappers = make(map[string]*config.Wrapper)
+		order    []string
+		disabled = make(map[string]bool)
+		rest     []string
+	)
+	wrapper := func(typ string) *config.Wrapper {
+		w, ok := wrappers[typ]
+		if !ok {
+			w = &config.Wrapper{Type: typ, Options: map[string]interface{}{}}
+			wrappers[typ] = w
+			order = append(order, typ)
+		}
+		return w
+	}
+
+	// the query is split by hand, as url.Values doesn't preserve the order of the parameters
+	for _, param := range strings.Split(u.RawQuery, "&") {
+		if param == "" {
+			continue
+		}
+		rawKey, rawValue, _ := strings.Cut(param, "=")
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid query parameter %q", rawKey)
+		}
+		value, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid value of query parameter %q", key)
+		}
+
+		typ, option, hasOption := strings.Cut(key, ".")
+		switch {
+		case key == NamespaceParam:
+			cfg.Namespace = value
+		case !known[typ]:
+			rest = append(rest, param)
+		case hasOption:
+			wrapper(typ).Options[option] = parseValue(value)
+		case value == "" || value == "true":
+			wrapper(typ)
+		case value == "false":
+			disabled[typ] = true
+		default:
+			main, ok := mainOptions[typ]
+			if !ok {
+				return nil, errors.Errorf("invalid query parameter %s=%s: the %s wrapper can only be set to true or false", key, value, typ)
+			}
+			wrapper(typ).Options[main] = parseValue(value)
+		}
+	}
+
+	for _, typ := range order {
+		if disabled[typ] {
+			continue
+		}
+		w := wrappers[typ]
+		if _, ok := w.Options["topic"]; !ok && topicOptions[typ] {
+			w.Options["topic"] = strings.Trim(u.Path, "/")
+		}
+		if len(w.Options) == 0 {
+			w.Options = nil
+		}
+		cfg.Wrappers = append(cfg.Wrappers, *w)
+	}
+	u.RawQuery = strings.Join(rest, "&")
+	cfg.URL = u.String()
+	return cfg, nil
+}
+
+// parseValue parses a parameter value as a YAML value, so that numbers, booleans and lists are typed. Values that
+// aren't valid YAML scalars or sequences are kept as strings.
+func parseValue(value string) interface{} {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(value), &v); err != nil || v == nil {
+		return value
+	}
+	if _, ok := v.(map[string]interface{}); ok {
+		return value
+	}
+	return v
+}
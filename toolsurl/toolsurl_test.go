@@ -0,0 +1,105 @@
+package toolsurl_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate/suburl"
+
+	"github.com/uw-labs/substrate-tools/config"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/toolsurl"
+)
+
+var (
+	broker = mem.NewBroker()
+	// queries records the query of the URLs the backends were created with.
+	queries = make(chan string, 2)
+)
+
+func init() {
+	suburl.RegisterSink("toolsurltest", func(u *url.URL) (substrate.AsyncMessageSink, error) {
+		queries <- u.RawQuery
+		return broker.NewAsyncMessageSink(u.Host), nil
+	})
+	suburl.RegisterSource("toolsurltest", func(u *url.URL) (substrate.AsyncMessageSource, error) {
+		queries <- u.RawQuery
+		return broker.NewAsyncMessageSource(u.Host, "group"), nil
+	})
+}
+
+func TestParseSink(t *testing.T) {
+	cfg, err := toolsurl.ParseSink("kafka://localhost:9092/events?compress.threshold=0&instrumented=true&version=2.1.0" +
+		"&envelope&compress=zstd&redact.fields=[password,%20token]&sizelimit=1024&sizelimit=false&metrics-namespace=orders")
+	require.NoError(t, err)
+	require.Equal(t, &config.Config{
+		URL:       "kafka://localhost:9092/events?version=2.1.0",
+		Namespace: "orders",
+		Wrappers: []config.Wrapper{
+			{Type: "compress", Options: map[string]interface{}{"encoding": "zstd", "threshold": 0}},
+			{Type: "instrumented", Options: map[string]interface{}{"topic": "events"}},
+			{Type: "envelope"},
+			{Type: "redact", Options: map[string]interface{}{"fields": []interface{}{"password", "token"}}},
+		},
+	}, cfg)
+}
+
+func TestParseSource(t *testing.T) {
+	cfg, err := toolsurl.ParseSource("kafka://localhost:9092/events?consumer-group=g&ttl=1h&sample=0.5&traced.consumer=app")
+	require.NoError(t, err)
+	require.Equal(t, &config.Config{
+		URL: "kafka://localhost:9092/events?consumer-group=g",
+		Wrappers: []config.Wrapper{
+			{Type: "ttl", Options: map[string]interface{}{"ttl": "1h"}},
+			{Type: "sample", Options: map[string]interface{}{"fraction": 0.5}},
+			{Type: "traced", Options: map[string]interface{}{"topic": "events", "consumer": "app"}},
+		},
+	}, cfg)
+
+	_, err = toolsurl.ParseSource("kafka://localhost:9092/events?envelope=yes")
+	require.EqualError(t, err, "invalid query parameter envelope=yes: the envelope wrapper can only be set to true or false")
+}
+
+func TestNewSinkAndSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	sink, err := toolsurl.NewSink("toolsurltest://roundtrip?envelope=true&compress=snappy&compress.threshold=0&backend=option")
+	require.NoError(t, err)
+	require.Equal(t, "backend=option", <-queries)
+
+	acks, messages := make(chan substrate.Message, 1), make(chan substrate.Message, 1)
+	errs := make(chan error, 2)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+	messages <- message.FromString("payload")
+	select {
+	case <-acks:
+	case err := <-errs:
+		require.FailNow(t, "publishing failed", "%v", err)
+	}
+
+	source, err := toolsurl.NewSource("toolsurltest://roundtrip?envelope&compress")
+	require.NoError(t, err)
+	require.Equal(t, "", <-queries)
+	consumed, sourceAcks := make(chan substrate.Message, 1), make(chan substrate.Message, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, consumed, sourceAcks)
+	}()
+	select {
+	case msg := <-consumed:
+		require.Equal(t, "payload", string(msg.Data()))
+	case err := <-errs:
+		require.FailNow(t, "consuming failed", "%v", err)
+	}
+
+	// Options are validated by the config package.
+	_, err = toolsurl.NewSink("toolsurltest://roundtrip?compress=lz4")
+	require.EqualError(t, err, `invalid configuration: wrappers[0] (compress): invalid encoding "lz4", expected one of gzip, snappy, zstd`)
+}
@@ -0,0 +1,120 @@
+package traced
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that records a producer span
+// for every message published, from the moment it is received until it is acknowledged. The span context
+// is injected into the message headers, which are exposed through the message.HeaderedMessage interface.
+// If the message already carries a trace context in its headers, it is used as the parent of the span.
+func NewAsyncMessageSink(sink substrate.AsyncMessageSink, topic string, opts ...Option) substrate.AsyncMessageSink {
+	return &tracedSink{
+		impl:   sink,
+		config: newConfig(opts),
+		topic:  topic,
+	}
+}
+
+// tracedSink is a traced message sink.
+type tracedSink struct {
+	impl   substrate.AsyncMessageSink
+	config *config
+	topic  string
+}
+
+// PublishMessages implements message publishing wrapped in tracing.
+func (ams *tracedSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "traced"})
+	rg, ctx := rungroup.New(ctx)
+	toPublish := make(chan substrate.Message, cap(messages))
+	published := make(chan substrate.Message, cap(acks))
+	inFlight := newSpans()
+
+	rg.Go(func() error {
+		return ams.impl.PublishMessages(ctx, published, toPublish)
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-messages:
+				emit.Publish(msg)
+				tMsg := ams.startSpan(ctx, msg)
+				inFlight.add(tMsg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case toPublish <- tMsg:
+				}
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-published:
+				tMsg, ok := ack.(*tracedMessage)
+				if !ok {
+					return errors.Errorf("unexpected message type: %T", ack)
+				}
+				inFlight.end(tMsg)
+				emit.Ack(tMsg.msg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case acks <- tMsg.msg:
+				}
+			}
+		}
+	}))
+
+	err := rg.Wait()
+	inFlight.endAll(err)
+
+	return err
+}
+
+func (ams *tracedSink) startSpan(ctx context.Context, msg substrate.Message) *tracedMessage {
+	headers := message.Headers(msg)
+	ctx = ams.config.propagator.Extract(ctx, propagation.MapCarrier(headers))
+
+	ctx, span := ams.config.tracer().Start(ctx, "publish "+ams.topic,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.operation", "publish"),
+			attribute.String("messaging.destination.name", ams.topic),
+			attribute.Int("messaging.message.body.size", len(msg.Data())),
+		),
+	)
+	ams.config.propagator.Inject(ctx, propagation.MapCarrier(headers))
+
+	return &tracedMessage{
+		msg:     msg,
+		span:    span,
+		headers: headers,
+	}
+}
+
+// Close closes the message sink.
+func (ams *tracedSink) Close() error {
+	return ams.impl.Close()
+}
+
+// Status returns the status of this sink, or an error if the status could not be determined.
+func (ams *tracedSink) Status() (*substrate.Status, error) {
+	return ams.impl.Status()
+}
@@ -0,0 +1,118 @@
+package traced_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/traced"
+)
+
+type asyncMessageSinkMock struct {
+	substrate.AsyncMessageSink
+	publishMessageMock func(context.Context, chan<- substrate.Message, <-chan substrate.Message) error
+}
+
+func (m asyncMessageSinkMock) PublishMessages(ctx context.Context, acks chan<- substrate.Message, out <-chan substrate.Message) error {
+	return m.publishMessageMock(ctx, acks, out)
+}
+
+func newRecorder() (*tracetest.SpanRecorder, []traced.Option) {
+	recorder := tracetest.NewSpanRecorder()
+	return recorder, []traced.Option{
+		traced.WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))),
+		traced.WithPropagator(propagation.TraceContext{}),
+	}
+}
+
+func TestPublishMessagesSuccessfully(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	recorder, opts := newRecorder()
+	published := make(chan substrate.Message, 1)
+	sink := traced.NewAsyncMessageSink(asyncMessageSinkMock{
+		publishMessageMock: func(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case msg := <-messages:
+					published <- msg
+					acks <- msg
+				}
+			}
+		},
+	}, "testTopic", opts...)
+
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	msg := message.FromString("payload")
+	messages <- msg
+
+	select {
+	case <-ctx.Done():
+		require.FailNow(t, "message was not acknowledged")
+	case ack := <-acks:
+		require.Equal(t, msg, ack)
+	}
+	cancel()
+	require.NoError(t, <-errs)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, "publish testTopic", spans[0].Name())
+	require.Equal(t, trace.SpanKindProducer, spans[0].SpanKind())
+	require.Equal(t, codes.Ok, spans[0].Status().Code)
+
+	headers := message.Headers(<-published)
+	require.Contains(t, headers, "traceparent")
+}
+
+func TestPublishMessagesWithError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	producingErr := errors.New("message producing error")
+	recorder, opts := newRecorder()
+	sink := traced.NewAsyncMessageSink(asyncMessageSinkMock{
+		publishMessageMock: func(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-messages:
+				return producingErr
+			}
+		},
+	}, "testTopic", opts...)
+
+	errs := make(chan error)
+	messages := make(chan substrate.Message)
+	go func() {
+		defer close(errs)
+		errs <- sink.PublishMessages(ctx, make(chan substrate.Message), messages)
+	}()
+
+	messages <- message.FromString("payload")
+	require.Equal(t, producingErr, <-errs)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, codes.Error, spans[0].Status().Code)
+	require.Equal(t, producingErr.Error(), spans[0].Status().Description)
+}
@@ -0,0 +1,122 @@
+package traced
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that records a consumer span
+// for every message consumed, from the moment it is received until it is acknowledged. If the message
+// carries a trace context in its headers, as injected by the traced sink, the span becomes part of the
+// producer's trace. Use MessageContext to obtain a context carrying the span of a consumed message.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, topic, consumer string, opts ...Option) substrate.AsyncMessageSource {
+	return &tracedSource{
+		impl:     source,
+		config:   newConfig(opts),
+		topic:    topic,
+		consumer: consumer,
+	}
+}
+
+// tracedSource is a traced message source.
+type tracedSource struct {
+	impl     substrate.AsyncMessageSource
+	config   *config
+	topic    string
+	consumer string
+}
+
+// ConsumeMessages implements message consuming wrapped in tracing.
+func (ams *tracedSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "traced"})
+	rg, ctx := rungroup.New(ctx)
+	consumed := make(chan substrate.Message, cap(messages))
+	toAck := make(chan substrate.Message, cap(acks))
+	inFlight := newSpans()
+
+	rg.Go(func() error {
+		return ams.impl.ConsumeMessages(ctx, consumed, toAck)
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-consumed:
+				tMsg := ams.startSpan(ctx, msg)
+				inFlight.add(tMsg)
+				emit.Publish(tMsg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case messages <- tMsg:
+				}
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				tMsg, ok := ack.(*tracedMessage)
+				if !ok {
+					return errors.Errorf("unexpected message type: %T", ack)
+				}
+				emit.Ack(ack)
+				select {
+				case <-ctx.Done():
+					return nil
+				case toAck <- tMsg.msg:
+					inFlight.end(tMsg)
+				}
+			}
+		}
+	}))
+
+	err := rg.Wait()
+	inFlight.endAll(err)
+
+	return err
+}
+
+func (ams *tracedSource) startSpan(ctx context.Context, msg substrate.Message) *tracedMessage {
+	headers := message.Headers(msg)
+	ctx = ams.config.propagator.Extract(ctx, propagation.MapCarrier(headers))
+
+	_, span := ams.config.tracer().Start(ctx, "process "+ams.topic,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.operation", "process"),
+			attribute.String("messaging.destination.name", ams.topic),
+			attribute.String("messaging.consumer.group.name", ams.consumer),
+			attribute.Int("messaging.message.body.size", len(msg.Data())),
+		),
+	)
+
+	return &tracedMessage{
+		msg:     msg,
+		span:    span,
+		headers: headers,
+	}
+}
+
+// Close closes the message source.
+func (ams *tracedSource) Close() error {
+	return ams.impl.Close()
+}
+
+// Status returns the status of this source, or an error if the status could not be determined.
+func (ams *tracedSource) Status() (*substrate.Status, error) {
+	return ams.impl.Status()
+}
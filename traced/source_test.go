@@ -0,0 +1,129 @@
+package traced_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+	"github.com/uw-labs/substrate-tools/traced"
+)
+
+type headeredMessage struct {
+	*message.Message
+	headers map[string]string
+}
+
+func (m *headeredMessage) Headers() map[string]string {
+	return m.headers
+}
+
+func TestConsumeMessagesSuccessfully(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	recorder, opts := newRecorder()
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			message.FromString("1"),
+			message.FromString("2"),
+		},
+	}
+	source := traced.NewAsyncMessageSource(mockSource, "testTopic", "testConsumer", opts...)
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	for i := 0; i < len(mockSource.Messages); i++ {
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "failed to consume all messages")
+		case msg := <-messages:
+			span := trace.SpanFromContext(traced.MessageContext(ctx, msg))
+			require.True(t, span.SpanContext().IsValid())
+			acks <- msg
+		}
+	}
+
+	require.Eventually(t, func() bool {
+		return len(recorder.Ended()) == 2
+	}, time.Second, time.Millisecond*10)
+	cancel()
+	require.NoError(t, <-errs)
+
+	for _, span := range recorder.Ended() {
+		require.Equal(t, "process testTopic", span.Name())
+		require.Equal(t, trace.SpanKindConsumer, span.SpanKind())
+		require.Equal(t, codes.Ok, span.Status().Code)
+	}
+}
+
+func TestConsumeMessagesContinuesTrace(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	recorder, opts := newRecorder()
+
+	// Publish a message to obtain the headers injected by the sink.
+	published := make(chan substrate.Message, 1)
+	sink := traced.NewAsyncMessageSink(asyncMessageSinkMock{
+		publishMessageMock: func(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+			msg := <-messages
+			published <- msg
+			acks <- msg
+			<-ctx.Done()
+			return nil
+		},
+	}, "testTopic", opts...)
+
+	sinkCtx, sinkCancel := context.WithCancel(ctx)
+	sinkAcks, sinkMessages := make(chan substrate.Message), make(chan substrate.Message)
+	sinkErrs := make(chan error)
+	go func() {
+		defer close(sinkErrs)
+		sinkErrs <- sink.PublishMessages(sinkCtx, sinkAcks, sinkMessages)
+	}()
+	sinkMessages <- message.FromString("payload")
+	<-sinkAcks
+	sinkCancel()
+	require.NoError(t, <-sinkErrs)
+
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			&headeredMessage{
+				Message: message.FromString("payload"),
+				headers: message.Headers(<-published),
+			},
+		},
+	}
+	source := traced.NewAsyncMessageSource(mockSource, "testTopic", "testConsumer", opts...)
+
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+	acks <- <-messages
+
+	require.Eventually(t, func() bool {
+		return len(recorder.Ended()) == 2
+	}, time.Second, time.Millisecond*10)
+	cancel()
+	require.NoError(t, <-errs)
+
+	spans := recorder.Ended()
+	producer, consumer := spans[0], spans[1]
+	require.Equal(t, producer.SpanContext().TraceID(), consumer.SpanContext().TraceID())
+	require.Equal(t, producer.SpanContext().SpanID(), consumer.Parent().SpanID())
+}
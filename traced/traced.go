@@ -0,0 +1,137 @@
+// Package traced provides wrappers for substrate sinks and sources that record OpenTelemetry spans
+// for every message and propagate trace context between producers and consumers using message headers.
+package traced
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+const instrumentationName = "github.com/uw-labs/substrate-tools/traced"
+
+// Option is a function which sets a tracing wrapper configuration option.
+type Option func(cfg *config)
+
+// WithTracerProvider sets the tracer provider used to create spans. The default is the global provider.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(cfg *config) {
+		cfg.provider = provider
+	}
+}
+
+// WithPropagator sets the propagator used to inject and extract trace context from message
+// headers. The default is the global propagator.
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(cfg *config) {
+		cfg.propagator = propagator
+	}
+}
+
+type config struct {
+	provider   trace.TracerProvider
+	propagator propagation.TextMapPropagator
+}
+
+func newConfig(opts []Option) *config {
+	cfg := &config{
+		provider:   otel.GetTracerProvider(),
+		propagator: otel.GetTextMapPropagator(),
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+func (cfg *config) tracer() trace.Tracer {
+	return cfg.provider.Tracer(instrumentationName)
+}
+
+// MessageContext returns a copy of the context carrying the span of the provided message, so that
+// handlers can create child spans. The context is returned unchanged if the message wasn't received
+// from a traced source.
+func MessageContext(ctx context.Context, msg substrate.Message) context.Context {
+	tMsg, ok := msg.(*tracedMessage)
+	if !ok {
+		return ctx
+	}
+	return trace.ContextWithSpan(ctx, tMsg.span)
+}
+
+// tracedMessage wraps a message together with the span recorded for it and the headers
+// carrying the span context.
+type tracedMessage struct {
+	msg     substrate.Message
+	span    trace.Span
+	headers map[string]string
+}
+
+func (msg *tracedMessage) Data() []byte {
+	return msg.msg.Data()
+}
+
+func (msg *tracedMessage) Headers() map[string]string {
+	return msg.headers
+}
+
+func (msg *tracedMessage) DiscardPayload() {
+	if dMsg, ok := msg.msg.(substrate.DiscardableMessage); ok {
+		dMsg.DiscardPayload()
+	}
+}
+
+func (msg *tracedMessage) CarriedEnvelope() (message.Envelope, bool) {
+	return message.EnvelopeOf(msg.msg)
+}
+
+var _ message.HeaderedMessage = (*tracedMessage)(nil)
+
+// spans keeps track of the spans of messages that are in flight.
+type spans struct {
+	mutex sync.Mutex
+	spans map[*tracedMessage]struct{}
+}
+
+func newSpans() *spans {
+	return &spans{spans: make(map[*tracedMessage]struct{})}
+}
+
+func (s *spans) add(msg *tracedMessage) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.spans[msg] = struct{}{}
+}
+
+// end ends the span of the message with a successful status.
+func (s *spans) end(msg *tracedMessage) {
+	s.mutex.Lock()
+	delete(s.spans, msg)
+	s.mutex.Unlock()
+
+	msg.span.SetStatus(codes.Ok, "")
+	msg.span.End()
+}
+
+// endAll ends the spans of all messages in flight. Spans are marked as failed if an error is provided.
+func (s *spans) endAll(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for msg := range s.spans {
+		if err != nil {
+			msg.span.RecordError(err)
+			msg.span.SetStatus(codes.Error, err.Error())
+		}
+		msg.span.End()
+		delete(s.spans, msg)
+	}
+}
@@ -0,0 +1,70 @@
+// Package ttl provides a message source wrapper that drops messages older than a time to live.
+package ttl
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/filter"
+	"github.com/uw-labs/substrate-tools/message"
+)
+
+// TimestampFunc returns the time at which the message was produced, and false if it isn't known.
+type TimestampFunc func(msg substrate.Message) (time.Time, bool)
+
+// EnvelopeTimestamp returns the timestamp of the envelope carried by messages, such as the messages consumed from
+// the envelope source. It is the default TimestampFunc.
+func EnvelopeTimestamp(msg substrate.Message) (time.Time, bool) {
+	envelope, ok := message.EnvelopeOf(msg)
+	if !ok || envelope.Timestamp.IsZero() {
+		return time.Time{}, false
+	}
+	return envelope.Timestamp, true
+}
+
+// AsyncMessageSourceOption is a function which sets an AsyncMessageSource configuration option.
+type AsyncMessageSourceOption func(s *ttlSource)
+
+// WithTimestampFunc sets the function returning the time at which a message was produced.
+func WithTimestampFunc(f TimestampFunc) AsyncMessageSourceOption {
+	return func(s *ttlSource) {
+		s.timestamp = f
+	}
+}
+
+// WithExpiredCounter enables a counter of expired messages, labelled with the topic.
+// It panics in case it can't register the metric.
+func WithExpiredCounter(counterOpts prometheus.CounterOpts, topic string) AsyncMessageSourceOption {
+	return func(s *ttlSource) {
+		s.filterOpts = append(s.filterOpts, filter.WithDropCounter(counterOpts, topic))
+	}
+}
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that drops messages that were produced
+// longer than the TTL ago, e.g. to skip stale events after a long outage of the consumer. Dropped messages are
+// acknowledged automatically, in order with the acknowledgements of delivered messages. Messages with an unknown
+// timestamp are always delivered.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, ttl time.Duration, opts ...AsyncMessageSourceOption) substrate.AsyncMessageSource {
+	s := &ttlSource{
+		ttl:       ttl,
+		timestamp: EnvelopeTimestamp,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return filter.NewAsyncMessageSource(source, s.live, s.filterOpts...)
+}
+
+type ttlSource struct {
+	ttl        time.Duration
+	timestamp  TimestampFunc
+	filterOpts []filter.AsyncMessageSourceOption
+}
+
+// live reports whether the message hasn't expired yet.
+func (s *ttlSource) live(msg substrate.Message) bool {
+	timestamp, ok := s.timestamp(msg)
+	return !ok || time.Since(timestamp) <= s.ttl
+}
@@ -0,0 +1,137 @@
+package ttl_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/conformance"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+	"github.com/uw-labs/substrate-tools/ttl"
+)
+
+func consume(ctx context.Context, t *testing.T, source substrate.AsyncMessageSource, count int) (<-chan error, []string) {
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	var consumed []string
+	for i := 0; i < count; i++ {
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "failed to consume all messages")
+		case msg := <-messages:
+			consumed = append(consumed, string(msg.Data()))
+			acks <- msg
+		}
+	}
+	return errs, consumed
+}
+
+func enveloped(payload string, age time.Duration) substrate.Message {
+	return message.NewEnvelopedMessage(message.Envelope{
+		Timestamp: time.Now().Add(-age),
+		Payload:   []byte(payload),
+	})
+}
+
+func TestTTLSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	counterOpts := prometheus.CounterOpts{
+		Name: "ttl_test_expired_total",
+		Help: "ttl_test_expired_total",
+	}
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			enveloped("stale-1", time.Hour),
+			enveloped("fresh-1", 0),
+			message.FromString("unknown"),
+			enveloped("stale-2", time.Hour),
+			enveloped("fresh-2", time.Second),
+		},
+	}
+	source := ttl.NewAsyncMessageSource(mockSource, time.Minute, ttl.WithExpiredCounter(counterOpts, "topic"))
+
+	counter := prometheus.NewCounterVec(counterOpts, []string{"topic"})
+	if are, ok := prometheus.Register(counter).(prometheus.AlreadyRegisteredError); ok {
+		counter = are.ExistingCollector.(*prometheus.CounterVec)
+	}
+	before := testutil.ToFloat64(counter.WithLabelValues("topic"))
+
+	errs, consumed := consume(ctx, t, source, 3)
+	require.Equal(t, []string{"fresh-1", "unknown", "fresh-2"}, consumed)
+
+	// The mock source terminates with an error if acknowledgements are out of order.
+	require.NoError(t, source.Close())
+	require.NoError(t, <-errs)
+	require.Equal(t, before+2, testutil.ToFloat64(counter.WithLabelValues("topic")))
+}
+
+func TestTTLSourceWithTimestampFunc(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	produced := map[string]time.Time{
+		"stale": time.Now().Add(-time.Hour),
+		"fresh": time.Now(),
+	}
+	mockSource := &mock.AsyncMessageSource{
+		Messages: []substrate.Message{
+			message.FromString("stale"),
+			message.FromString("fresh"),
+		},
+	}
+	source := ttl.NewAsyncMessageSource(mockSource, time.Minute, ttl.WithTimestampFunc(func(msg substrate.Message) (time.Time, bool) {
+		timestamp, ok := produced[string(msg.Data())]
+		return timestamp, ok
+	}))
+
+	errs, consumed := consume(ctx, t, source, 1)
+	require.Equal(t, []string{"fresh"}, consumed)
+
+	require.NoError(t, source.Close())
+	require.NoError(t, <-errs)
+}
+
+// wrappedMessage is a message of a wrapper forwarding the envelope of the message it wraps.
+type wrappedMessage struct {
+	msg substrate.Message
+}
+
+func (m *wrappedMessage) Data() []byte {
+	return m.msg.Data()
+}
+
+func (m *wrappedMessage) CarriedEnvelope() (message.Envelope, bool) {
+	return message.EnvelopeOf(m.msg)
+}
+
+func TestEnvelopeTimestamp(t *testing.T) {
+	timestamp := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	enveloped := message.NewEnvelopedMessage(message.Envelope{Timestamp: timestamp})
+
+	for _, msg := range []substrate.Message{enveloped, &wrappedMessage{msg: enveloped}} {
+		got, ok := ttl.EnvelopeTimestamp(msg)
+		require.True(t, ok)
+		require.Equal(t, timestamp, got)
+	}
+
+	_, ok := ttl.EnvelopeTimestamp(&wrappedMessage{msg: message.FromString("unknown")})
+	require.False(t, ok)
+}
+
+func TestTTLSource_Conformance(t *testing.T) {
+	conformance.TestAsyncSource(t, func(_ *testing.T, backend substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+		return ttl.NewAsyncMessageSource(backend, time.Minute)
+	})
+}
@@ -0,0 +1,62 @@
+// Package typed provides generic adapters for substrate sinks and sources that publish and consume values
+// of a Go type, rather than raw payloads, using a codec.
+package typed
+
+import (
+	"encoding/json"
+)
+
+// Codec encodes values of a type into message payloads and decodes them back.
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// JSONCodec is a codec that encodes values as JSON.
+type JSONCodec[T any] struct{}
+
+// Encode encodes the value as JSON.
+func (JSONCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Decode decodes the value from JSON.
+func (JSONCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// CodecFuncs is a codec implemented by a pair of functions.
+type CodecFuncs[T any] struct {
+	EncodeFunc func(v T) ([]byte, error)
+	DecodeFunc func(data []byte) (T, error)
+}
+
+// Encode encodes the value using the EncodeFunc.
+func (c CodecFuncs[T]) Encode(v T) ([]byte, error) {
+	return c.EncodeFunc(v)
+}
+
+// Decode decodes the value using the DecodeFunc.
+func (c CodecFuncs[T]) Decode(data []byte) (T, error) {
+	return c.DecodeFunc(data)
+}
+
+// Codecs returns the codec of values of a content type, e.g. to encode and decode values in several formats
+// on the same topic. It is implemented by codec.Registry.
+type Codecs[T any] interface {
+	Codec(contentType string) (Codec[T], error)
+}
+
+// ContentTypeFunc returns the content type a value is encoded with.
+type ContentTypeFunc[T any] func(v T) string
+
+// singleCodec is the Codecs of sinks and sources using the same codec for all content types.
+type singleCodec[T any] struct {
+	codec Codec[T]
+}
+
+func (c singleCodec[T]) Codec(string) (Codec[T], error) {
+	return c.codec, nil
+}
@@ -0,0 +1,129 @@
+package typed
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// Sink is a message sink that publishes values of type T, encoded using a codec.
+type Sink[T any] struct {
+	sink        substrate.AsyncMessageSink
+	codecs      Codecs[T]
+	contentType ContentTypeFunc[T]
+}
+
+// NewSink returns a new typed sink publishing to the provided sink. When Close is called on the Sink,
+// this is also propagated to the underlying AsyncMessageSink.
+func NewSink[T any](sink substrate.AsyncMessageSink, codec Codec[T]) *Sink[T] {
+	return NewSinkWithCodecs[T](sink, singleCodec[T]{codec: codec}, func(T) string { return "" })
+}
+
+// NewSinkWithCodecs returns a new typed sink publishing to the provided sink, which encodes every value using
+// the codec of the content type returned for it. The content type is reported by the published messages, see
+// message.ContentTypedMessage, so that wrapping the sink in an envelope sink records it in the envelope, for
+// a source created with NewSourceWithCodecs to decode the value. This allows topics carrying several formats,
+// e.g. while migrating from one to another.
+func NewSinkWithCodecs[T any](sink substrate.AsyncMessageSink, codecs Codecs[T], contentType ContentTypeFunc[T]) *Sink[T] {
+	return &Sink[T]{
+		sink:        sink,
+		codecs:      codecs,
+		contentType: contentType,
+	}
+}
+
+// PublishMessages encodes and publishes any values found on the `messages` channel and returns them on
+// the `acks` channel once they have been published. If a value can't be encoded, an error is returned.
+// This function will block until the context is done or until an error occurs.
+func (s *Sink[T]) PublishMessages(ctx context.Context, acks chan<- T, messages <-chan T) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "typed"})
+	rg, ctx := rungroup.New(ctx)
+
+	toPublish := make(chan substrate.Message, cap(messages))
+	published := make(chan substrate.Message, cap(acks))
+
+	rg.Go(func() error {
+		return s.sink.PublishMessages(ctx, published, toPublish)
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case v := <-messages:
+				msg, err := s.encode(v)
+				if err != nil {
+					return err
+				}
+				emit.Publish(msg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case toPublish <- msg:
+				}
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-published:
+				msg, ok := ack.(*valueMessage[T])
+				if !ok {
+					return errors.Errorf("unexpected message type: %T", ack)
+				}
+				emit.Ack(msg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case acks <- msg.value:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+func (s *Sink[T]) encode(v T) (*valueMessage[T], error) {
+	contentType := s.contentType(v)
+	codec, err := s.codecs.Codec(contentType)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode message")
+	}
+	data, err := codec.Encode(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode message")
+	}
+	return &valueMessage[T]{value: v, data: data, contentType: contentType}, nil
+}
+
+// Close closes the underlying sink.
+func (s *Sink[T]) Close() error {
+	return s.sink.Close()
+}
+
+// Status returns the status of the underlying sink.
+func (s *Sink[T]) Status() (*substrate.Status, error) {
+	return s.sink.Status()
+}
+
+type valueMessage[T any] struct {
+	value       T
+	data        []byte
+	contentType string
+}
+
+func (msg *valueMessage[T]) Data() []byte {
+	return msg.data
+}
+
+func (msg *valueMessage[T]) ContentType() string {
+	return msg.contentType
+}
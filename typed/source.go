@@ -0,0 +1,176 @@
+package typed
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/ackordering"
+	"github.com/uw-labs/substrate-tools/dlq"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// SourceOption is a function which sets a Source configuration option.
+type SourceOption func(cfg *sourceConfig)
+
+// WithSkipDecodeErrors makes the source acknowledge and drop messages that can't be decoded, instead
+// of returning an error.
+func WithSkipDecodeErrors() SourceOption {
+	return func(cfg *sourceConfig) {
+		cfg.skip = true
+	}
+}
+
+// WithDeadLetterDecodeErrors makes the source publish messages that can't be decoded to the provided
+// dead-letter sink, instead of returning an error. See the dlq package for details.
+func WithDeadLetterDecodeErrors(sink substrate.AsyncMessageSink, opts ...dlq.AsyncMessageSourceOption) SourceOption {
+	return func(cfg *sourceConfig) {
+		cfg.deadLetterSink = sink
+		cfg.deadLetterOpts = opts
+	}
+}
+
+type sourceConfig struct {
+	skip           bool
+	deadLetterSink substrate.AsyncMessageSink
+	deadLetterOpts []dlq.AsyncMessageSourceOption
+}
+
+// Message is a message consumed from a typed source. It must be sent back on the acks channel once
+// it has been processed.
+type Message[T any] struct {
+	// Value is the decoded payload of the message.
+	Value T
+
+	msg substrate.Message
+}
+
+// Source is a message source that consumes values of type T, decoded using a codec.
+type Source[T any] struct {
+	source        substrate.AsyncMessageSource
+	codecs        Codecs[T]
+	skip          bool
+	deadLettering bool
+}
+
+// NewSource returns a new typed source consuming from the provided source. By default, an error is returned
+// from ConsumeMessages when a message can't be decoded. When Close is called on the Source, this is also
+// propagated to the underlying AsyncMessageSource.
+func NewSource[T any](source substrate.AsyncMessageSource, codec Codec[T], opts ...SourceOption) *Source[T] {
+	return NewSourceWithCodecs[T](source, singleCodec[T]{codec: codec}, opts...)
+}
+
+// NewSourceWithCodecs returns a new typed source consuming from the provided source, which decodes every message
+// using the codec of its content type, see message.ContentType, e.g. the content type of its envelope if the
+// source is wrapped in an envelope source. Messages without a content type are decoded using the codec of the
+// empty content type. Messages whose content type has no codec are handled like messages that can't be decoded.
+func NewSourceWithCodecs[T any](source substrate.AsyncMessageSource, codecs Codecs[T], opts ...SourceOption) *Source[T] {
+	cfg := &sourceConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	s := &Source[T]{
+		source: source,
+		codecs: codecs,
+	}
+	switch {
+	case cfg.deadLetterSink != nil:
+		s.source = dlq.NewAsyncMessageSource(source, cfg.deadLetterSink, cfg.deadLetterOpts...)
+		s.deadLettering = true
+	case cfg.skip:
+		s.source = ackordering.NewAsyncMessageSource(source)
+		s.skip = true
+	}
+	return s
+}
+
+// ConsumeMessages provides decoded messages on the `messages` channel and expects them to be sent back to
+// the `acks` channel once they have been handled. This function will block until the context is done, or
+// until an error occurs.
+func (s *Source[T]) ConsumeMessages(ctx context.Context, messages chan<- *Message[T], acks <-chan *Message[T]) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "typed"})
+	rg, ctx := rungroup.New(ctx)
+
+	consumed := make(chan substrate.Message, cap(messages))
+	toAck := make(chan substrate.Message, cap(acks))
+
+	rg.Go(func() error {
+		return s.source.ConsumeMessages(ctx, consumed, toAck)
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-consumed:
+				v, err := s.decode(msg)
+				if err != nil {
+					var ack substrate.Message
+					switch {
+					case s.deadLettering:
+						// the error is emitted by the dead-letter source as the reason of the nack
+						ack = dlq.Nack(msg, err)
+					case s.skip:
+						emit.Error(errors.Wrap(err, "failed to decode message"))
+						ack = msg
+					default:
+						return errors.Wrap(err, "failed to decode message")
+					}
+					select {
+					case <-ctx.Done():
+						return nil
+					case toAck <- ack:
+					}
+					continue
+				}
+
+				emit.Publish(msg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case messages <- &Message[T]{Value: v, msg: msg}:
+				}
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack := <-acks:
+				emit.Ack(ack.msg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case toAck <- ack.msg:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+func (s *Source[T]) decode(msg substrate.Message) (T, error) {
+	codec, err := s.codecs.Codec(message.ContentType(msg))
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return codec.Decode(msg.Data())
+}
+
+// Close closes the underlying source.
+func (s *Source[T]) Close() error {
+	return s.source.Close()
+}
+
+// Status returns the status of the underlying source.
+func (s *Source[T]) Status() (*substrate.Status, error) {
+	return s.source.Status()
+}
@@ -0,0 +1,120 @@
+package typed_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/typed"
+)
+
+type event struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func publishRaw(t *testing.T, broker *mem.Broker, topic string, payloads ...string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	sink := broker.NewAsyncMessageSink(topic)
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	go sink.PublishMessages(ctx, acks, messages)
+	for _, payload := range payloads {
+		messages <- message.FromString(payload)
+		<-acks
+	}
+}
+
+func consume(t *testing.T, source *typed.Source[event], count int, beforeClose ...func()) ([]event, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	messages, acks := make(chan *typed.Message[event]), make(chan *typed.Message[event])
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	var events []event
+	for len(events) < count {
+		select {
+		case <-ctx.Done():
+			require.FailNow(t, "failed to consume all messages")
+		case err := <-errs:
+			return events, err
+		case msg := <-messages:
+			events = append(events, msg.Value)
+			select {
+			case acks <- msg:
+			case err := <-errs:
+				return events, err
+			}
+		}
+	}
+	for _, fn := range beforeClose {
+		fn()
+	}
+	require.NoError(t, source.Close())
+	return events, <-errs
+}
+
+func TestTypedSinkAndSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	sink := typed.NewSink[event](broker.NewAsyncMessageSink("events"), typed.JSONCodec[event]{})
+
+	acks, messages := make(chan event), make(chan event)
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	published := []event{{ID: 1, Name: "one"}, {ID: 2, Name: "two"}}
+	for _, e := range published {
+		messages <- e
+		require.Equal(t, e, <-acks)
+	}
+	cancel()
+	require.NoError(t, <-errs)
+	require.Equal(t, `{"id":1,"name":"one"}`, string(broker.Messages("events")[0]))
+
+	source := typed.NewSource[event](broker.NewAsyncMessageSource("events", "group"), typed.JSONCodec[event]{})
+	consumed, err := consume(t, source, 2)
+	require.NoError(t, err)
+	require.Equal(t, published, consumed)
+}
+
+func TestTypedSource_DecodeErrors(t *testing.T) {
+	broker := mem.NewBroker()
+	publishRaw(t, broker, "events", `{"id":1}`, `not json`, `{"id":2}`)
+
+	source := typed.NewSource[event](broker.NewAsyncMessageSource("events", "fail"), typed.JSONCodec[event]{})
+	consumed, err := consume(t, source, 2)
+	require.Error(t, err)
+	require.Equal(t, []event{{ID: 1}}, consumed)
+
+	source = typed.NewSource[event](broker.NewAsyncMessageSource("events", "skip"), typed.JSONCodec[event]{},
+		typed.WithSkipDecodeErrors())
+	consumed, err = consume(t, source, 2)
+	require.NoError(t, err)
+	require.Equal(t, []event{{ID: 1}, {ID: 2}}, consumed)
+
+	source = typed.NewSource[event](broker.NewAsyncMessageSource("events", "dlq"), typed.JSONCodec[event]{},
+		typed.WithDeadLetterDecodeErrors(broker.NewAsyncMessageSink("events-dlq")))
+	consumed, err = consume(t, source, 2, func() {
+		require.Eventually(t, func() bool {
+			return len(broker.Messages("events-dlq")) == 1
+		}, time.Second, time.Millisecond*10)
+	})
+	require.NoError(t, err)
+	require.Equal(t, []event{{ID: 1}, {ID: 2}}, consumed)
+}
@@ -0,0 +1,412 @@
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// schema is a compiled JSON Schema.
+type schema struct {
+	// types is empty if any type is allowed.
+	types []string
+	// always is set for the boolean schemas true and false.
+	always *bool
+
+	properties           map[string]*schema
+	required             []string
+	additionalProperties *schema
+	items                *schema
+	enum                 []interface{}
+
+	minimum, maximum                   *float64
+	exclusiveMinimum, exclusiveMaximum *float64
+	minLength, maxLength               *int
+	minItems, maxItems                 *int
+	pattern                            *regexp.Regexp
+
+	allOf, anyOf, oneOf []*schema
+	not                 *schema
+}
+
+// unsupported are the keywords of the JSON Schema specification constraining documents that aren't supported, in
+// the order they are reported in.
+var unsupported = []string{
+	"additionalItems", "contains", "contentEncoding", "contentMediaType", "contentSchema", "dependencies",
+	"dependentRequired", "dependentSchemas", "else", "format", "if", "maxContains", "maxProperties", "minContains",
+	"minProperties", "multipleOf", "patternProperties", "prefixItems", "propertyNames", "then", "unevaluatedItems",
+	"unevaluatedProperties", "uniqueItems",
+}
+
+// NewJSONSchema returns a validator checking that payloads are JSON documents valid against the schema. It supports
+// the following keywords: type, enum, const, properties, required, additionalProperties, items, minimum, maximum,
+// exclusiveMinimum, exclusiveMaximum, minLength, maxLength, pattern, minItems, maxItems, allOf, anyOf, oneOf and not.
+// Schemas using references or other keywords constraining documents, e.g. format or uniqueItems, return an error
+// rather than accepting documents they would reject, while annotations, e.g. title, and unknown keywords are ignored.
+func NewJSONSchema(data []byte) (Validator, error) {
+	var raw interface{}
+	if err := decode(data, &raw); err != nil {
+		return nil, errors.Wrap(err, "failed to decode JSON schema")
+	}
+	s, err := compile(raw, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid JSON schema")
+	}
+	return ValidatorFunc(func(payload []byte) error {
+		var doc interface{}
+		if err := decode(payload, &doc); err != nil {
+			return errors.Wrap(err, "invalid JSON")
+		}
+		return s.validate(doc, "")
+	}), nil
+}
+
+// decode decodes JSON keeping numbers as json.Number, so that integers can be told apart from other numbers.
+func decode(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return errors.New("unexpected data after JSON document")
+	}
+	return nil
+}
+
+func compile(raw interface{}, path string) (*schema, error) {
+	if b, ok := raw.(bool); ok {
+		return &schema{always: &b}, nil
+	}
+	obj, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("%s: schema must be an object or a boolean", pointer(path))
+	}
+	for _, keyword := range []string{"$ref", "$dynamicRef", "$recursiveRef"} {
+		if _, ok := obj[keyword]; ok {
+			return nil, errors.Errorf("%s: references aren't supported", pointer(path))
+		}
+	}
+	for _, keyword := range unsupported {
+		if _, ok := obj[keyword]; ok {
+			return nil, errors.Errorf("%s: keyword %s isn't supported", pointer(path), keyword)
+		}
+	}
+
+	s := &schema{}
+	var err error
+	switch types := obj["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{types}
+	case []interface{}:
+		for _, t := range types {
+			name, ok := t.(string)
+			if !ok {
+				return nil, errors.Errorf("%s: type must be a string", pointer(path))
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return nil, errors.Errorf("%s: type must be a string or an array", pointer(path))
+	}
+	if enum, ok := obj["enum"]; ok {
+		if s.enum, ok = enum.([]interface{}); !ok {
+			return nil, errors.Errorf("%s: enum must be an array", pointer(path))
+		}
+	}
+	if c, ok := obj["const"]; ok {
+		s.enum = []interface{}{c}
+	}
+
+	if raw, ok := obj["properties"]; ok {
+		properties, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("%s: properties must be an object", pointer(path))
+		}
+		s.properties = make(map[string]*schema, len(properties))
+		for name, property := range properties {
+			if s.properties[name], err = compile(property, path+"/properties/"+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if raw, ok := obj["required"]; ok {
+		required, ok := raw.([]interface{})
+		if !ok {
+			return nil, errors.Errorf("%s: required must be an array of strings", pointer(path))
+		}
+		for _, name := range required {
+			name, ok := name.(string)
+			if !ok {
+				return nil, errors.Errorf("%s: required must be an array of strings", pointer(path))
+			}
+			s.required = append(s.required, name)
+		}
+	}
+	if additional, ok := obj["additionalProperties"]; ok {
+		if s.additionalProperties, err = compile(additional, path+"/additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+	if items, ok := obj["items"]; ok {
+		if s.items, err = compile(items, path+"/items"); err != nil {
+			return nil, err
+		}
+	}
+
+	for keyword, target := range map[string]**float64{
+		"minimum":          &s.minimum,
+		"maximum":          &s.maximum,
+		"exclusiveMinimum": &s.exclusiveMinimum,
+		"exclusiveMaximum": &s.exclusiveMaximum,
+	} {
+		if value, ok := obj[keyword]; ok {
+			n, ok := number(value)
+			if !ok {
+				return nil, errors.Errorf("%s: %s must be a number", pointer(path), keyword)
+			}
+			*target = &n
+		}
+	}
+	for keyword, target := range map[string]**int{
+		"minLength": &s.minLength,
+		"maxLength": &s.maxLength,
+		"minItems":  &s.minItems,
+		"maxItems":  &s.maxItems,
+	} {
+		if value, ok := obj[keyword]; ok {
+			n, ok := number(value)
+			if !ok || n < 0 || n != math.Trunc(n) {
+				return nil, errors.Errorf("%s: %s must be a non-negative integer", pointer(path), keyword)
+			}
+			limit := int(n)
+			*target = &limit
+		}
+	}
+	if pattern, ok := obj["pattern"]; ok {
+		expr, ok := pattern.(string)
+		if !ok {
+			return nil, errors.Errorf("%s: pattern must be a string", pointer(path))
+		}
+		if s.pattern, err = regexp.Compile(expr); err != nil {
+			return nil, errors.Wrapf(err, "%s: invalid pattern", pointer(path))
+		}
+	}
+
+	for keyword, target := range map[string]*[]*schema{
+		"allOf": &s.allOf,
+		"anyOf": &s.anyOf,
+		"oneOf": &s.oneOf,
+	} {
+		value, ok := obj[keyword]
+		if !ok {
+			continue
+		}
+		subschemas, ok := value.([]interface{})
+		if !ok {
+			return nil, errors.Errorf("%s: %s must be an array", pointer(path), keyword)
+		}
+		for i, raw := range subschemas {
+			sub, err := compile(raw, fmt.Sprintf("%s/%s/%d", path, keyword, i))
+			if err != nil {
+				return nil, err
+			}
+			*target = append(*target, sub)
+		}
+	}
+	if not, ok := obj["not"]; ok {
+		if s.not, err = compile(not, path+"/not"); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *schema) validate(doc interface{}, path string) error {
+	if s.always != nil {
+		if !*s.always {
+			return errors.Errorf("%s: no value is allowed", pointer(path))
+		}
+		return nil
+	}
+	if len(s.types) > 0 && !s.hasType(doc) {
+		return errors.Errorf("%s: expected %s, got %s", pointer(path), joinTypes(s.types), typeOf(doc))
+	}
+	if s.enum != nil && !s.inEnum(doc) {
+		return errors.Errorf("%s: value isn't one of the allowed values", pointer(path))
+	}
+
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				return errors.Errorf("%s: missing required property %q", pointer(path), name)
+			}
+		}
+		for name, value := range v {
+			property, ok := s.properties[name]
+			if !ok {
+				property = s.additionalProperties
+			}
+			if property == nil {
+				continue
+			}
+			if property.always != nil && !*property.always && !ok {
+				return errors.Errorf("%s: unexpected property %q", pointer(path), name)
+			}
+			if err := property.validate(value, path+"/"+name); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if s.minItems != nil && len(v) < *s.minItems {
+			return errors.Errorf("%s: expected at least %d items", pointer(path), *s.minItems)
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			return errors.Errorf("%s: expected at most %d items", pointer(path), *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range v {
+				if err := s.items.validate(item, path+"/"+strconv.Itoa(i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.minLength != nil && length < *s.minLength {
+			return errors.Errorf("%s: expected at least %d characters", pointer(path), *s.minLength)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			return errors.Errorf("%s: expected at most %d characters", pointer(path), *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return errors.Errorf("%s: value doesn't match pattern %q", pointer(path), s.pattern)
+		}
+	case json.Number:
+		n, _ := number(v)
+		switch {
+		case s.minimum != nil && n < *s.minimum:
+			return errors.Errorf("%s: expected at least %v", pointer(path), *s.minimum)
+		case s.maximum != nil && n > *s.maximum:
+			return errors.Errorf("%s: expected at most %v", pointer(path), *s.maximum)
+		case s.exclusiveMinimum != nil && n <= *s.exclusiveMinimum:
+			return errors.Errorf("%s: expected more than %v", pointer(path), *s.exclusiveMinimum)
+		case s.exclusiveMaximum != nil && n >= *s.exclusiveMaximum:
+			return errors.Errorf("%s: expected less than %v", pointer(path), *s.exclusiveMaximum)
+		}
+	}
+
+	for _, sub := range s.allOf {
+		if err := sub.validate(doc, path); err != nil {
+			return err
+		}
+	}
+	if len(s.anyOf) > 0 && s.matching(s.anyOf, doc, path) == 0 {
+		return errors.Errorf("%s: value doesn't match any of the schemas", pointer(path))
+	}
+	if len(s.oneOf) > 0 && s.matching(s.oneOf, doc, path) != 1 {
+		return errors.Errorf("%s: value doesn't match exactly one of the schemas", pointer(path))
+	}
+	if s.not != nil && s.not.validate(doc, path) == nil {
+		return errors.Errorf("%s: value matches a disallowed schema", pointer(path))
+	}
+	return nil
+}
+
+func (s *schema) matching(subschemas []*schema, doc interface{}, path string) int {
+	matched := 0
+	for _, sub := range subschemas {
+		if sub.validate(doc, path) == nil {
+			matched++
+		}
+	}
+	return matched
+}
+
+func (s *schema) hasType(doc interface{}) bool {
+	actual := typeOf(doc)
+	for _, t := range s.types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *schema) inEnum(doc interface{}) bool {
+	for _, allowed := range s.enum {
+		if equal(allowed, doc) {
+			return true
+		}
+	}
+	return false
+}
+
+// equal compares JSON values, comparing numbers by their value rather than their representation.
+func equal(a, b interface{}) bool {
+	if x, ok := a.(json.Number); ok {
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		m, _ := number(x)
+		n, _ := number(y)
+		return m == n
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func typeOf(doc interface{}) string {
+	switch v := doc.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case json.Number:
+		if n, _ := number(v); n == math.Trunc(n) {
+			return "integer"
+		}
+		return "number"
+	default:
+		return fmt.Sprintf("%T", doc)
+	}
+}
+
+func joinTypes(types []string) string {
+	if len(types) == 1 {
+		return types[0]
+	}
+	return fmt.Sprintf("one of %v", types)
+}
+
+func number(value interface{}) (float64, bool) {
+	n, ok := value.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return f, err == nil
+}
+
+// pointer returns the JSON pointer of the path, which is the root if it is empty.
+func pointer(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
@@ -0,0 +1,96 @@
+package validate_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate-tools/validate"
+)
+
+const orderSchema = `{
+	"type": "object",
+	"required": ["id", "items"],
+	"additionalProperties": false,
+	"properties": {
+		"id": {"type": "string", "pattern": "^order-[0-9]+$"},
+		"status": {"enum": ["new", "paid"]},
+		"items": {
+			"type": "array",
+			"minItems": 1,
+			"items": {
+				"type": "object",
+				"required": ["quantity"],
+				"properties": {
+					"quantity": {"type": "integer", "minimum": 1, "exclusiveMaximum": 100},
+					"note": {"type": ["string", "null"], "maxLength": 5}
+				}
+			}
+		},
+		"discount": {"oneOf": [{"type": "number", "maximum": 0.5}, {"const": "free"}]}
+	}
+}`
+
+func TestJSONSchema(t *testing.T) {
+	validator, err := validate.NewJSONSchema([]byte(orderSchema))
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		payload string
+		err     string
+	}{
+		"valid":             {payload: `{"id": "order-1", "status": "paid", "items": [{"quantity": 2, "note": null}], "discount": 0.1}`},
+		"valid const":       {payload: `{"id": "order-1", "items": [{"quantity": 99}], "discount": "free"}`},
+		"not JSON":          {payload: `order-1`, err: "invalid JSON: invalid character 'o' looking for beginning of value"},
+		"trailing data":     {payload: `{} {}`, err: "invalid JSON: unexpected data after JSON document"},
+		"wrong type":        {payload: `[]`, err: "/: expected object, got array"},
+		"missing required":  {payload: `{"id": "order-1"}`, err: `/: missing required property "items"`},
+		"additional":        {payload: `{"id": "order-1", "items": [{"quantity": 1}], "extra": 1}`, err: `/: unexpected property "extra"`},
+		"pattern":           {payload: `{"id": "1", "items": [{"quantity": 1}]}`, err: `/id: value doesn't match pattern "^order-[0-9]+$"`},
+		"enum":              {payload: `{"id": "order-1", "status": "lost", "items": [{"quantity": 1}]}`, err: "/status: value isn't one of the allowed values"},
+		"min items":         {payload: `{"id": "order-1", "items": []}`, err: "/items: expected at least 1 items"},
+		"integer":           {payload: `{"id": "order-1", "items": [{"quantity": 1.5}]}`, err: "/items/0/quantity: expected integer, got number"},
+		"minimum":           {payload: `{"id": "order-1", "items": [{"quantity": 0}]}`, err: "/items/0/quantity: expected at least 1"},
+		"exclusive maximum": {payload: `{"id": "order-1", "items": [{"quantity": 100}]}`, err: "/items/0/quantity: expected less than 100"},
+		"type union":        {payload: `{"id": "order-1", "items": [{"quantity": 1, "note": 1}]}`, err: "/items/0/note: expected one of [string null], got integer"},
+		"max length":        {payload: `{"id": "order-1", "items": [{"quantity": 1, "note": "too long"}]}`, err: "/items/0/note: expected at most 5 characters"},
+		"one of":            {payload: `{"id": "order-1", "items": [{"quantity": 1}], "discount": 0.9}`, err: "/discount: value doesn't match exactly one of the schemas"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := validator.Validate([]byte(tc.payload))
+			if tc.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func TestJSONSchema_Invalid(t *testing.T) {
+	for schema, expected := range map[string]string{
+		`[]`:                                   "invalid JSON schema: /: schema must be an object or a boolean",
+		`{"$ref": "#/$defs/order"}`:            "invalid JSON schema: /: references aren't supported",
+		`{"type": "string", "format": "uuid"}`: "invalid JSON schema: /: keyword format isn't supported",
+		`{"items": {"uniqueItems": true}}`:     "invalid JSON schema: /items: keyword uniqueItems isn't supported",
+		`{"if": {}, "then": {}, "else": {}}`:   "invalid JSON schema: /: keyword else isn't supported",
+		`{"multipleOf": 2}`:                    "invalid JSON schema: /: keyword multipleOf isn't supported",
+		`{"properties": {"id": {"type": 1}}}`:  "invalid JSON schema: /properties/id: type must be a string or an array",
+		`{"minLength": -1}`:                    "invalid JSON schema: /: minLength must be a non-negative integer",
+		`{"anyOf": [{"pattern": "("}]}`:        "invalid JSON schema: /anyOf/0: invalid pattern: error parsing regexp: missing closing ): `(`",
+		`{"not": {"required": "id"}}`:          "invalid JSON schema: /not: required must be an array of strings",
+		`{"type": []`:                          "failed to decode JSON schema: unexpected EOF",
+	} {
+		_, err := validate.NewJSONSchema([]byte(schema))
+		require.EqualError(t, err, expected, schema)
+	}
+
+	// Annotations and unknown keywords are ignored.
+	_, err := validate.NewJSONSchema([]byte(`{"title": "order", "description": "an order", "x-owner": "orders"}`))
+	require.NoError(t, err)
+
+	validator, err := validate.NewJSONSchema([]byte(`{"not": {"type": "string"}, "anyOf": [true, false]}`))
+	require.NoError(t, err)
+	require.NoError(t, validator.Validate([]byte(`1`)))
+	require.EqualError(t, validator.Validate([]byte(`"a"`)), "/: value matches a disallowed schema")
+}
@@ -0,0 +1,132 @@
+package validate
+
+import (
+	"context"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/health"
+	"github.com/uw-labs/substrate-tools/internal/inorder"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// Messages published to the sink and to the dead-letter sink are acknowledged independently of each other,
+// so they are matched with their acknowledgements in separate lanes.
+const (
+	sinkLane = iota
+	deadLetterLane
+)
+
+// NewAsyncMessageSink returns an instance of substrate.AsyncMessageSink that validates messages before publishing
+// them to the underlying sink. Invalid messages are rejected by default. Acknowledgements are passed on in the order
+// in which the messages were published, including those of messages published to the dead-letter sink, if set.
+// When Close is called, both the sink and the dead-letter sink are closed.
+func NewAsyncMessageSink(sink substrate.AsyncMessageSink, validator Validator, opts ...Option) substrate.AsyncMessageSink {
+	return &validatingSink{
+		sink:   sink,
+		config: newConfig(validator, opts),
+	}
+}
+
+type validatingSink struct {
+	sink substrate.AsyncMessageSink
+	config
+}
+
+func (s *validatingSink) PublishMessages(ctx context.Context, acks chan<- substrate.Message, messages <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "validate"})
+	rg, ctx := rungroup.New(ctx)
+
+	toSink, sinkAcks := make(chan substrate.Message), make(chan substrate.Message)
+	deadLetters, deadLetterAcks := make(chan substrate.Message), make(chan substrate.Message)
+	queue := inorder.NewQueue[substrate.Message, substrate.Message]()
+
+	rg.Go(func() error {
+		return s.sink.PublishMessages(ctx, sinkAcks, toSink)
+	})
+	if s.deadLetters != nil {
+		rg.Go(func() error {
+			return s.deadLetters.PublishMessages(ctx, deadLetterAcks, deadLetters)
+		})
+	}
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-messages:
+				emit.Publish(msg)
+				out := toSink
+				switch err := s.check(msg); {
+				case err == nil:
+					queue.Push(sinkLane, msg, msg)
+				case s.deadLetters != nil:
+					emit.Error(err)
+					queue.Push(deadLetterLane, msg, msg)
+					out = deadLetters
+				case s.policy == Pass:
+					emit.Error(err)
+					queue.Push(sinkLane, msg, msg)
+				default:
+					return reject(err)
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case out <- msg:
+				}
+			}
+		}
+	}))
+	rg.Go(emit.Errors(func() error {
+		for {
+			var (
+				ack  substrate.Message
+				lane int
+			)
+			select {
+			case <-ctx.Done():
+				return nil
+			case ack = <-sinkAcks:
+				lane = sinkLane
+			case ack = <-deadLetterAcks:
+				lane = deadLetterLane
+			}
+			released, ok := queue.Complete(lane, ack)
+			if !ok {
+				return errors.Errorf("unexpected message acknowledged: %v", ack)
+			}
+			for _, msg := range released {
+				emit.Ack(msg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case acks <- msg:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+// Close closes both the underlying sink and the dead-letter sink, if set, and returns all errors encountered.
+func (s *validatingSink) Close() (err error) {
+	err = multierror.Append(err, s.sink.Close()).ErrorOrNil()
+	if s.deadLetters != nil {
+		err = multierror.Append(err, s.deadLetters.Close()).ErrorOrNil()
+	}
+	return err
+}
+
+// Status returns the status of the underlying sink. It only reports working status if the dead-letter sink, if set,
+// does as well.
+func (s *validatingSink) Status() (*substrate.Status, error) {
+	if s.deadLetters == nil {
+		return s.sink.Status()
+	}
+	return health.Combine(s.sink, health.Named("dead-letter sink", s.deadLetters)).Status()
+}
@@ -0,0 +1,130 @@
+package validate_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/conformance"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/validate"
+)
+
+// validator only accepts payloads starting with "valid".
+var validator = validate.ValidatorFunc(func(data []byte) error {
+	if !strings.HasPrefix(string(data), "valid") {
+		return errors.New("payload must start with valid")
+	}
+	return nil
+})
+
+func payloads(broker *mem.Broker, topic string) []string {
+	var published []string
+	for _, data := range broker.Messages(topic) {
+		published = append(published, string(data))
+	}
+	return published
+}
+
+func publish(ctx context.Context, sink substrate.AsyncMessageSink, payloads ...string) error {
+	acks, messages := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- sink.PublishMessages(ctx, acks, messages)
+	}()
+
+	var published []substrate.Message
+	for _, payload := range payloads {
+		msg := message.FromString(payload)
+		select {
+		case messages <- msg:
+			published = append(published, msg)
+		case err := <-errs:
+			return err
+		}
+	}
+	for _, msg := range published {
+		select {
+		case ack := <-acks:
+			if ack != msg {
+				return errors.Errorf("unexpected message acknowledged: %v", ack)
+			}
+		case err := <-errs:
+			return err
+		}
+	}
+	return nil
+}
+
+func TestSink_Reject(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	sink := validate.NewAsyncMessageSink(broker.NewAsyncMessageSink("topic"), validator)
+
+	err := publish(ctx, sink, "valid-1", "invalid", "valid-2")
+	require.Equal(t, validate.ErrInvalidMessage, errors.Cause(err))
+	require.EqualError(t, err, "payload must start with valid: invalid message")
+	require.Equal(t, []string{"valid-1"}, payloads(broker, "topic"))
+}
+
+func TestSink_Pass(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	counterOpts := prometheus.CounterOpts{
+		Name: "validate_test_invalid_total",
+		Help: "validate_test_invalid_total",
+	}
+	broker := mem.NewBroker()
+	sink := validate.NewAsyncMessageSink(broker.NewAsyncMessageSink("topic"), validator,
+		validate.WithPolicy(validate.Pass),
+		validate.WithInvalidCounter(counterOpts, "topic"),
+	)
+
+	counter := prometheus.NewCounterVec(counterOpts, []string{"topic"})
+	if are, ok := prometheus.Register(counter).(prometheus.AlreadyRegisteredError); ok {
+		counter = are.ExistingCollector.(*prometheus.CounterVec)
+	}
+	before := testutil.ToFloat64(counter.WithLabelValues("topic"))
+
+	require.NoError(t, publish(ctx, sink, "valid-1", "invalid", "valid-2"))
+	require.Equal(t, []string{"valid-1", "invalid", "valid-2"}, payloads(broker, "topic"))
+	require.Equal(t, before+1, testutil.ToFloat64(counter.WithLabelValues("topic")))
+}
+
+func TestSink_DeadLetterSink(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	sink := validate.NewAsyncMessageSink(broker.NewAsyncMessageSink("topic"), validator,
+		validate.WithDeadLetterSink(broker.NewAsyncMessageSink("invalid")),
+	)
+
+	require.NoError(t, publish(ctx, sink, "valid-1", "invalid-1", "valid-2", "invalid-2"))
+	require.Equal(t, []string{"valid-1", "valid-2"}, payloads(broker, "topic"))
+	require.Equal(t, []string{"invalid-1", "invalid-2"}, payloads(broker, "invalid"))
+
+	require.NoError(t, sink.Close())
+	status, err := sink.Status()
+	require.NoError(t, err)
+	require.False(t, status.Working)
+}
+
+func TestSink_Conformance(t *testing.T) {
+	conformance.TestAsyncSink(t, func(_ *testing.T, backend substrate.AsyncMessageSink) substrate.AsyncMessageSink {
+		return validate.NewAsyncMessageSink(backend, validate.ValidatorFunc(func([]byte) error {
+			return nil
+		}))
+	})
+}
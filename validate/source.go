@@ -0,0 +1,78 @@
+package validate
+
+import (
+	"context"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/events"
+	"github.com/uw-labs/substrate-tools/filter"
+	"github.com/uw-labs/sync/rungroup"
+)
+
+// NewAsyncMessageSource returns an instance of substrate.AsyncMessageSource that validates the messages consumed
+// from the underlying source before delivering them. Invalid messages are rejected by default. Messages published
+// to the dead-letter sink, if set, are acknowledged automatically, in order with the acknowledgements of delivered
+// messages. When Close is called, both the source and the dead-letter sink are closed.
+func NewAsyncMessageSource(source substrate.AsyncMessageSource, validator Validator, opts ...Option) substrate.AsyncMessageSource {
+	c := newConfig(validator, opts)
+	switch {
+	case c.deadLetters != nil:
+		return filter.NewAsyncMessageSource(source, func(msg substrate.Message) bool {
+			return c.check(msg) == nil
+		}, filter.WithSideSink(c.deadLetters))
+	case c.policy == Pass:
+		return filter.NewAsyncMessageSource(source, func(msg substrate.Message) bool {
+			_ = c.check(msg)
+			return true
+		})
+	default:
+		return &rejectingSource{source: source, config: c}
+	}
+}
+
+// rejectingSource delivers the messages of the underlying source until an invalid one is consumed.
+type rejectingSource struct {
+	source substrate.AsyncMessageSource
+	config
+}
+
+func (s *rejectingSource) ConsumeMessages(ctx context.Context, messages chan<- substrate.Message, acks <-chan substrate.Message) error {
+	emit := events.NewEmitter(ctx, events.Info{Component: "validate"})
+	rg, ctx := rungroup.New(ctx)
+
+	sourceMsgs := make(chan substrate.Message, cap(messages))
+
+	rg.Go(func() error {
+		return s.source.ConsumeMessages(ctx, sourceMsgs, acks)
+	})
+	rg.Go(emit.Errors(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg := <-sourceMsgs:
+				if err := s.check(msg); err != nil {
+					return reject(err)
+				}
+				emit.Publish(msg)
+				select {
+				case <-ctx.Done():
+					return nil
+				case messages <- msg:
+				}
+			}
+		}
+	}))
+
+	return rg.Wait()
+}
+
+// Close closes the underlying source.
+func (s *rejectingSource) Close() error {
+	return s.source.Close()
+}
+
+// Status returns the status of the underlying source.
+func (s *rejectingSource) Status() (*substrate.Status, error) {
+	return s.source.Status()
+}
@@ -0,0 +1,115 @@
+package validate_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/conformance"
+	"github.com/uw-labs/substrate-tools/mem"
+	"github.com/uw-labs/substrate-tools/message"
+	"github.com/uw-labs/substrate-tools/mock"
+	"github.com/uw-labs/substrate-tools/validate"
+)
+
+func consume(ctx context.Context, source substrate.AsyncMessageSource, count int) ([]string, error) {
+	messages, acks := make(chan substrate.Message), make(chan substrate.Message)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- source.ConsumeMessages(ctx, messages, acks)
+	}()
+
+	var consumed []string
+	for len(consumed) < count {
+		select {
+		case msg := <-messages:
+			consumed = append(consumed, string(msg.Data()))
+			select {
+			case acks <- msg:
+			case err := <-errs:
+				return consumed, err
+			}
+		case err := <-errs:
+			return consumed, err
+		}
+	}
+	return consumed, nil
+}
+
+func TestSource_Reject(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	backend := mock.NewSource(message.FromString("valid-1"), message.FromString("invalid"))
+	source := validate.NewAsyncMessageSource(backend, validator)
+
+	consumed, err := consume(ctx, source, 2)
+	require.Equal(t, []string{"valid-1"}, consumed)
+	require.Equal(t, validate.ErrInvalidMessage, errors.Cause(err))
+}
+
+func TestSource_Pass(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	backend := mock.NewSource(message.FromString("valid-1"), message.FromString("invalid"))
+	source := validate.NewAsyncMessageSource(backend, validator, validate.WithPolicy(validate.Pass))
+
+	consumed, err := consume(ctx, source, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"valid-1", "invalid"}, consumed)
+	<-backend.AllAcked()
+}
+
+func TestSource_DeadLetterSink(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	broker := mem.NewBroker()
+	backend := mock.NewSource(message.FromString("invalid-1"), message.FromString("valid-1"), message.FromString("invalid-2"))
+	source := validate.NewAsyncMessageSource(backend, validator, validate.WithDeadLetterSink(broker.NewAsyncMessageSink("invalid")))
+
+	consumed, err := consume(ctx, source, 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"valid-1"}, consumed)
+
+	// The mock source terminates with an error if acknowledgements are out of order.
+	<-backend.AllAcked()
+	require.Equal(t, []string{"invalid-1", "invalid-2"}, payloads(broker, "invalid"))
+}
+
+func TestSource_Proto(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	valid, err := proto.Marshal(durationpb.New(time.Second))
+	require.NoError(t, err)
+	backend := mock.NewSource(message.NewMessage(valid), message.FromString("\xff"))
+	source := validate.NewAsyncMessageSource(backend, validate.NewProto((&durationpb.Duration{}).ProtoReflect().Descriptor()))
+
+	consumed, err := consume(ctx, source, 2)
+	require.Equal(t, []string{string(valid)}, consumed)
+	require.Equal(t, validate.ErrInvalidMessage, errors.Cause(err))
+}
+
+func TestSource_Conformance(t *testing.T) {
+	t.Run("reject", func(t *testing.T) {
+		conformance.TestAsyncSource(t, func(_ *testing.T, backend substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+			return validate.NewAsyncMessageSource(backend, validate.ValidatorFunc(func([]byte) error {
+				return nil
+			}))
+		})
+	})
+	// The payloads of the suite are all invalid, but passed on.
+	t.Run("pass", func(t *testing.T) {
+		conformance.TestAsyncSource(t, func(_ *testing.T, backend substrate.AsyncMessageSource) substrate.AsyncMessageSource {
+			return validate.NewAsyncMessageSource(backend, validator, validate.WithPolicy(validate.Pass))
+		})
+	})
+}
@@ -0,0 +1,105 @@
+// Package validate provides message sink and source wrappers that validate payloads, e.g. against a JSON Schema
+// or a protobuf message descriptor, so that schema discipline can be enforced at the transport layer.
+package validate
+
+import (
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/uw-labs/substrate"
+	"github.com/uw-labs/substrate-tools/internal/metrics"
+)
+
+// ErrInvalidMessage is returned when an invalid message is rejected.
+var ErrInvalidMessage = errors.New("invalid message")
+
+// Validator validates message payloads.
+type Validator interface {
+	// Validate returns an error describing why the payload is invalid, or nil if it is valid.
+	Validate(data []byte) error
+}
+
+// ValidatorFunc is a function implementing Validator.
+type ValidatorFunc func(data []byte) error
+
+// Validate calls the function with the payload.
+func (f ValidatorFunc) Validate(data []byte) error {
+	return f(data)
+}
+
+// NewProto returns a validator checking that payloads are protobuf messages of the type described by the descriptor,
+// in the binary format.
+func NewProto(desc protoreflect.MessageDescriptor) Validator {
+	return ValidatorFunc(func(data []byte) error {
+		return proto.Unmarshal(data, dynamicpb.NewMessage(desc))
+	})
+}
+
+// Policy is what the wrappers do with invalid messages.
+type Policy int
+
+const (
+	// Reject makes PublishMessages or ConsumeMessages return an error wrapping ErrInvalidMessage.
+	Reject Policy = iota
+	// Pass only counts invalid messages, which are published or delivered like valid ones.
+	Pass
+)
+
+// Option is a function which sets a validating wrapper configuration option.
+type Option func(c *config)
+
+// WithPolicy sets what is done with invalid messages. The default policy is Reject.
+func WithPolicy(policy Policy) Option {
+	return func(c *config) {
+		c.policy = policy
+	}
+}
+
+// WithDeadLetterSink sets a sink to which invalid messages are published instead of applying the policy. They are
+// acknowledged once they have been published.
+func WithDeadLetterSink(sink substrate.AsyncMessageSink) Option {
+	return func(c *config) {
+		c.deadLetters = sink
+	}
+}
+
+// WithInvalidCounter enables a counter of invalid messages, labelled with the topic.
+// It panics in case it can't register the metric.
+func WithInvalidCounter(counterOpts prometheus.CounterOpts, topic string) Option {
+	return func(c *config) {
+		counter := metrics.Register(prometheus.NewCounterVec(counterOpts, []string{"topic"})).(*prometheus.CounterVec)
+		c.invalid = counter.WithLabelValues(topic)
+	}
+}
+
+type config struct {
+	validator   Validator
+	policy      Policy
+	deadLetters substrate.AsyncMessageSink
+	invalid     prometheus.Counter
+}
+
+func newConfig(validator Validator, opts []Option) config {
+	c := config{validator: validator}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// check validates the message, counting it if it is invalid.
+func (c *config) check(msg substrate.Message) error {
+	err := c.validator.Validate(msg.Data())
+	if err != nil && c.invalid != nil {
+		c.invalid.Inc()
+	}
+	return err
+}
+
+// reject returns the error returned for a message rejected because of the validation error.
+func reject(err error) error {
+	return errors.Wrapf(ErrInvalidMessage, "%v", err)
+}